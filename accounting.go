@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestAccounting balances the requests a run submitted against what became of them, and checks that every
+// goroutine the run started has finished by the end of it. The results channel is closed once the executor has
+// waited for every request rather than after a count of them, so a request that never reports back doesn't hang the
+// run; it shows up here as lost instead, as does one dropped by an executor cancelled before it could start it.
+type RequestAccounting struct {
+	Submitted int `json:"submitted"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	TimedOut  int `json:"timed_out"`
+	Rejected  int `json:"rejected"`
+	Lost      int `json:"lost,omitempty"` // submitted but never reported back
+	// Goroutines still running once the run is over, leakGrace after it, that were not before it started, and the
+	// functions they are parked in, most first.
+	LeakedGoroutines int      `json:"leaked_goroutines,omitempty"`
+	Leaks            []string `json:"leaks,omitempty"`
+}
+
+// leakGrace is how long goroutines a run leaves behind are given to finish before they are counted as leaked: hedged
+// duplicate calls and requests still draining after an interrupt finish on their own, just after the run does.
+const leakGrace = time.Second
+
+// pooledGoroutineFrames mark goroutines that connection pools keep by design, which outlive the run that opened their
+// connections and are reused by the next, rather than leaks.
+var pooledGoroutineFrames = []string{"net/http.(*persistConn)", "database/sql.(*DB)", "google.golang.org/grpc"}
+
+// goroutineSnapshot counts the process's goroutines by stack, leaving out the pooled ones and the one taking it.
+type goroutineSnapshot map[string]int
+
+func takeGoroutineSnapshot() goroutineSnapshot {
+	var buf bytes.Buffer
+	// debug=1 groups goroutines with identical stacks, a count for each.
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return nil
+	}
+	s := goroutineSnapshot{}
+	scanner := bufio.NewScanner(&buf)
+	count := 0
+	var frames []string
+	flush := func() {
+		stack := strings.Join(frames, "\n")
+		skip := strings.Contains(stack, "main.takeGoroutineSnapshot")
+		for _, f := range pooledGoroutineFrames {
+			skip = skip || strings.Contains(stack, f)
+		}
+		if count > 0 && !skip {
+			s[stack] += count
+		}
+		count, frames = 0, nil
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "#"):
+			// #	0x4f1c2d	main.runBenchmark.func3+0x2d	/src/main.go:620
+			if fields := strings.Fields(line); len(fields) >= 3 {
+				fn := fields[2]
+				if plus := strings.LastIndex(fn, "+"); plus > 0 {
+					fn = fn[:plus]
+				}
+				frames = append(frames, fn)
+			}
+		default:
+			if at := strings.Index(line, " @ "); at > 0 {
+				count, _ = strconv.Atoi(line[:at])
+			}
+		}
+	}
+	flush()
+	return s
+}
+
+// leaked returns how many more goroutines after has than s, and the functions the extra ones are parked in, the first
+// frame of their stacks outside the runtime, most first.
+func (s goroutineSnapshot) leaked(after goroutineSnapshot) (int, []string) {
+	total := 0
+	where := map[string]int{}
+	for stack, n := range after {
+		if extra := n - s[stack]; extra > 0 {
+			total += extra
+			where[parkedIn(stack)] += extra
+		}
+	}
+	var leaks []string
+	for f := range where {
+		leaks = append(leaks, f)
+	}
+	sort.Slice(leaks, func(i, j int) bool {
+		if where[leaks[i]] != where[leaks[j]] {
+			return where[leaks[i]] > where[leaks[j]]
+		}
+		return leaks[i] < leaks[j]
+	})
+	for i, f := range leaks {
+		leaks[i] = fmt.Sprintf("%d in %s", where[f], f)
+	}
+	return total, leaks
+}
+
+// parkedIn is the first function of stack, innermost first, outside the runtime and the standard library's
+// synchronization, where a goroutine waiting on a channel or lock was left.
+func parkedIn(stack string) string {
+	frames := strings.Split(stack, "\n")
+	for _, f := range frames {
+		if !strings.HasPrefix(f, "runtime.") && !strings.HasPrefix(f, "sync.") && !strings.HasPrefix(f, "internal/") &&
+			!strings.HasPrefix(f, "time.") {
+			return f
+		}
+	}
+	return frames[0]
+}
+
+// checkLeaks waits up to leakGrace for the goroutines a run started to finish, and returns how many of them have not.
+func checkLeaks(before goroutineSnapshot) (int, []string) {
+	if before == nil {
+		return 0, nil
+	}
+	deadline := time.Now().Add(leakGrace)
+	for {
+		n, leaks := before.leaked(takeGoroutineSnapshot())
+		if n == 0 || time.Now().After(deadline) {
+			return n, leaks
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func outputAccounting(result BenchmarkResult) {
+	a := result.Accounting
+	if a.Submitted == 0 {
+		return
+	}
+	fmt.Printf("\tAccounting: %s submitted = %s succeeded + %s failed + %s timed out + %s rejected",
+		formatCount(int64(a.Submitted)), formatCount(int64(a.Succeeded)), formatCount(int64(a.Failed)),
+		formatCount(int64(a.TimedOut)), formatCount(int64(a.Rejected)))
+	if a.Lost > 0 {
+		fmt.Printf(" + %s lost", formatCount(int64(a.Lost)))
+	}
+	if a.LeakedGoroutines == 0 {
+		fmt.Printf("; every goroutine finished\n")
+	} else {
+		fmt.Println()
+	}
+	if a.Lost > 0 {
+		fmt.Printf("\tWarning: %s submitted requests never reported back: abandoned by a stalled run or dropped by an executor cancelled before it started them\n",
+			formatCount(int64(a.Lost)))
+	}
+	if a.LeakedGoroutines > 0 {
+		fmt.Printf("\tWarning: %s goroutines leaked, still running %v after the run: %s\n", formatCount(int64(a.LeakedGoroutines)),
+			leakGrace, strings.Join(a.Leaks, ", "))
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image/color"
+	"math"
+	"sync"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// The adaptive executors' tuning, as in Netflix's concurrency-limits: a request slower than adaptiveTolerance times
+// the fastest seen counts as a sign of overload, as a failed one does, and overload shrinks the limit to
+// adaptiveBackoff of itself. The gradient limit moves adaptiveSmoothing of the way to its new estimate each request.
+const (
+	adaptiveInitialLimit = 1
+	adaptiveTolerance    = 2.0
+	adaptiveBackoff      = 0.9
+	adaptiveSmoothing    = 0.2
+)
+
+// adaptiveExecutor bounds concurrency with a limit that moves with the latency and failures of the requests it runs,
+// between 1 and the co-routine count, instead of staying at the co-routine count: aimd adds one to the limit for every
+// limit's worth of requests that come back fine and backs off on overload; gradient scales it by how far the latency
+// has drifted from the fastest seen, and adds the square root of the limit as headroom for queueing. Neither grows
+// the limit while fewer than half of it are in use, which would be growth the load never tested.
+type adaptiveExecutor struct {
+	ctx       context.Context
+	algorithm string // aimd or gradient
+	max       float64
+	wake      chan struct{}
+	wg        sync.WaitGroup
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	fastest  time.Duration
+	epoch    time.Time
+	trace    []LimitSample
+}
+
+// LimitSample is an adaptive executor's limit from Elapsed into the run on.
+type LimitSample struct {
+	Elapsed time.Duration `json:"elapsed_ns"`
+	Limit   int           `json:"limit"`
+}
+
+func newAdaptiveExecutor(ctx context.Context, algorithm string, limit int64) *adaptiveExecutor {
+	return &adaptiveExecutor{ctx: ctx, algorithm: algorithm, max: float64(limit), wake: make(chan struct{}, 1),
+		limit: math.Min(adaptiveInitialLimit, float64(limit)), epoch: time.Now()}
+}
+
+// begin starts the limit's trace, when the run's requests start.
+func (e *adaptiveExecutor) begin() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.epoch = time.Now()
+	e.trace = []LimitSample{{Limit: int(e.limit)}}
+}
+
+func (e *adaptiveExecutor) Go(fn func() error) bool {
+	for {
+		e.mu.Lock()
+		if e.inflight < int(e.limit) {
+			e.inflight++
+			room := e.inflight < int(e.limit)
+			e.mu.Unlock()
+			if room {
+				e.signal() // the limit may have grown by more than one slot; let the next waiter see it
+			}
+			break
+		}
+		e.mu.Unlock()
+		select {
+		case <-e.wake:
+		case <-e.ctx.Done():
+			return false // the run was cancelled while waiting for the limit
+		}
+	}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		start := time.Now()
+		err := fn()
+		e.done(time.Since(start), err != nil)
+		e.signal()
+	}()
+	return true
+}
+
+func (e *adaptiveExecutor) signal() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// done updates the limit with a request that took rtt, and failed if dropped.
+func (e *adaptiveExecutor) done(rtt time.Duration, dropped bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	inflight := e.inflight
+	e.inflight--
+	if e.fastest == 0 || rtt < e.fastest {
+		e.fastest = rtt
+	}
+	overloaded := dropped || float64(rtt) > adaptiveTolerance*float64(e.fastest)
+	limit := e.limit
+	switch {
+	case e.algorithm == "aimd" && overloaded:
+		limit *= adaptiveBackoff
+	case e.algorithm == "aimd":
+		if float64(inflight) >= limit/2 {
+			limit += 1 / limit
+		}
+	case dropped:
+		limit = (1-adaptiveSmoothing)*limit + adaptiveSmoothing*limit*adaptiveBackoff
+	default:
+		gradient := math.Max(0.5, math.Min(1, adaptiveTolerance*float64(e.fastest)/float64(rtt)))
+		estimate := limit*gradient + math.Sqrt(limit)
+		if float64(inflight) < limit/2 {
+			estimate = math.Min(estimate, limit)
+		}
+		limit = (1-adaptiveSmoothing)*limit + adaptiveSmoothing*estimate
+	}
+	limit = math.Max(1, math.Min(e.max, limit))
+	if int(limit) != int(e.limit) && e.trace != nil {
+		e.trace = append(e.trace, LimitSample{Elapsed: time.Since(e.epoch), Limit: int(limit)})
+	}
+	e.limit = limit
+}
+
+func (e *adaptiveExecutor) Wait() error {
+	e.wg.Wait()
+	return nil
+}
+
+// limitTrace returns the limit's changes since begin, ending with where it stood at the end of the run; nil for a nil
+// executor, as runs with a static limit have.
+func (e *adaptiveExecutor) limitTrace() []LimitSample {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.trace == nil {
+		return nil
+	}
+	return append(e.trace, LimitSample{Elapsed: time.Since(e.epoch), Limit: int(e.limit)})
+}
+
+// AdaptiveLimitStats summarizes the limit an adaptive executor ran a run at.
+type AdaptiveLimitStats struct {
+	Final   int     `json:"final"`
+	Mean    float64 `json:"mean"` // weighted by how long the limit held
+	Min     int     `json:"min"`
+	Max     int     `json:"max"`
+	Changes int     `json:"changes"`
+}
+
+func adaptiveLimitStats(trace []LimitSample) *AdaptiveLimitStats {
+	if len(trace) == 0 {
+		return nil
+	}
+	s := &AdaptiveLimitStats{Final: trace[len(trace)-1].Limit, Min: trace[0].Limit, Max: trace[0].Limit, Changes: len(trace) - 2}
+	if s.Changes < 0 {
+		s.Changes = 0
+	}
+	var weighted float64
+	for i, sample := range trace {
+		if sample.Limit < s.Min {
+			s.Min = sample.Limit
+		}
+		if sample.Limit > s.Max {
+			s.Max = sample.Limit
+		}
+		if i > 0 {
+			weighted += float64(trace[i-1].Limit) * (sample.Elapsed - trace[i-1].Elapsed).Seconds()
+		}
+	}
+	if total := trace[len(trace)-1].Elapsed.Seconds(); total > 0 {
+		s.Mean = weighted / total
+	} else {
+		s.Mean = float64(s.Final)
+	}
+	return s
+}
+
+func outputAdaptiveLimit(result BenchmarkResult) {
+	s := adaptiveLimitStats(result.LimitTrace)
+	if s == nil {
+		return
+	}
+	fmt.Printf("\tAdaptive limit: ended at %d, averaging %s over the run, between %d and %d, changed %s times\n", s.Final,
+		formatFloat(s.Mean, 1), s.Min, s.Max, formatCount(int64(s.Changes)))
+}
+
+// plotAdaptiveLimit draws, for sweeps with an adaptive executor, how the limit moved over the run of each at the
+// highest co-routine count, the one least held back by its ceiling, against the co-routine count at which the
+// semaphore executor reached its highest throughput: the best a static limit did, found by sweeping. An injected
+// brownout is shaded, as that is where the limit should back off and then recover.
+func plotAdaptiveLimit(results []BenchmarkResult, opts PlotOptions) error {
+	runs := map[string]BenchmarkResult{}
+	var executors []string
+	var best *BenchmarkResult
+	for i, r := range results {
+		if len(r.LimitTrace) > 1 {
+			prev, ok := runs[r.Config.Executor]
+			if !ok {
+				executors = append(executors, r.Config.Executor)
+			}
+			if !ok || r.NumCoroutines > prev.NumCoroutines {
+				runs[r.Config.Executor] = r
+			}
+		}
+		if r.Config.Executor == "semaphore" && r.Failure == nil && (best == nil || r.ThroughputRps > best.ThroughputRps) {
+			best = &results[i]
+		}
+	}
+	if len(executors) == 0 {
+		return nil
+	}
+	plt := plot.New()
+	plt.Title.Text = "Adaptive Concurrency Limit over Time"
+	plt.X.Label.Text = "Time since start (s)"
+	plt.Y.Label.Text = "Concurrency limit"
+	plt.Y.Min = 0
+	var end float64
+	for i, executor := range executors {
+		r := runs[executor]
+		var pts plotter.XYs
+		for j, s := range r.LimitTrace {
+			if j > 0 {
+				pts = append(pts, plotter.XY{X: s.Elapsed.Seconds(), Y: float64(r.LimitTrace[j-1].Limit)})
+			}
+			pts = append(pts, plotter.XY{X: s.Elapsed.Seconds(), Y: float64(s.Limit)})
+		}
+		end = math.Max(end, pts[len(pts)-1].X)
+		line, err := plotter.NewLine(pts)
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = plotutil.Color(i)
+		line.LineStyle.Width = vg.Points(1.5)
+		plt.Add(line)
+		plt.Legend.Add(fmt.Sprintf("%s, up to %d", executor, r.NumCoroutines), line)
+	}
+	if first := runs[executors[0]]; first.Config.Faults.enabled() && first.Config.Faults.Duration > 0 {
+		f, top := first.Config.Faults, float64(first.NumCoroutines)
+		from, to := f.Start.Seconds(), math.Min((f.Start+f.Duration).Seconds(), end)
+		band, err := plotter.NewPolygon(plotter.XYs{{X: from, Y: 0}, {X: to, Y: 0}, {X: to, Y: top}, {X: from, Y: top}})
+		if err != nil {
+			return err
+		}
+		band.Color = color.NRGBA{R: 255, A: 48}
+		band.LineStyle.Width = 0
+		plt.Add(band)
+		plt.Legend.Add("injected faults", band)
+	}
+	if best != nil {
+		line, err := plotter.NewLine(plotter.XYs{{X: 0, Y: float64(best.NumCoroutines)}, {X: end, Y: float64(best.NumCoroutines)}})
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = color.Black
+		line.LineStyle.Dashes = []vg.Length{vg.Points(4), vg.Points(3)}
+		plt.Add(line)
+		plt.Legend.Add(fmt.Sprintf("best static semaphore, %d (%s)", best.NumCoroutines, formatRps(best.ThroughputRps)), line)
+	}
+	plt.Legend.Top = true
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("adaptive_limit.png"))
+}
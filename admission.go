@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// admissionQueue puts a bounded queue in front of the executor, as a server applying backpressure would: a request
+// that arrives while every co-routine is busy and depth requests are already waiting for one is rejected at once,
+// rather than joining an unbounded queue at the executor. Dispatching never blocks the load generator, so that arrivals
+// stay on schedule while requests wait.
+type admissionQueue struct {
+	capacity int64 // co-routines plus queue depth
+	admitted int64 // running or waiting for a co-routine
+	rejected int64
+	wg       sync.WaitGroup // dispatches still handing their request to the executor
+}
+
+func newAdmissionQueue(coroutines int64, depth int) *admissionQueue {
+	return &admissionQueue{capacity: coroutines + int64(depth)}
+}
+
+// admit hands fn to executor, unless the queue is full, in which case it counts a rejection and returns false. If the
+// executor refuses fn once it gets there, refused is called instead.
+func (q *admissionQueue) admit(executor Executor, fn func() error, refused func()) bool {
+	if atomic.AddInt64(&q.admitted, 1) > q.capacity {
+		atomic.AddInt64(&q.admitted, -1)
+		atomic.AddInt64(&q.rejected, 1)
+		return false
+	}
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		if !executor.Go(func() error {
+			defer atomic.AddInt64(&q.admitted, -1)
+			return fn()
+		}) {
+			atomic.AddInt64(&q.admitted, -1)
+			refused()
+		}
+	}()
+	return true
+}
+
+// wait blocks until every admitted request has reached the executor, whose Wait covers it from there.
+func (q *admissionQueue) wait() {
+	q.wg.Wait()
+}
+
+func (q *admissionQueue) rejections() int {
+	if q == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&q.rejected))
+}
+
+// RejectionRate is the fraction of the requests offered to the run that its admission queue turned away.
+func (b BenchmarkResult) RejectionRate() float64 {
+	if offered := b.Iterations + b.Rejected; offered > 0 {
+		return float64(b.Rejected) / float64(offered)
+	}
+	return 0
+}
+
+func outputShedding(result BenchmarkResult) {
+	if !result.Config.ShedLoad {
+		return
+	}
+	fmt.Printf("\tLoad shed: %s of %s requests offered (%s) rejected at a queue depth of %d\n", formatCount(int64(result.Rejected)),
+		formatCount(int64(result.Iterations+result.Rejected)), formatPercent(result.RejectionRate()), result.Config.QueueDepth)
+}
+
+// plotShedding draws the rejection rate against the co-routine count for sweeps that shed load: the price in turned
+// away requests of keeping the latency of the admitted ones bounded.
+func plotShedding(results []BenchmarkResult, opts PlotOptions) error {
+	if !results[0].Config.ShedLoad {
+		return nil
+	}
+	plt := plot.New()
+	plt.Title.Text = "Rejected Requests vs. Number of Co-Routines"
+	plt.X.Label.Text = "Number of Co-Routines"
+	plt.Y.Label.Text = "Rejected (% of offered)"
+	labels, series := bySeries(results)
+	for i, label := range labels {
+		line, err := sweepLine(plt, series[label], func(r BenchmarkResult) float64 { return r.RejectionRate() * 100 }, plotutil.Color(i))
+		if err != nil {
+			return err
+		}
+		if line != nil && len(labels) > 1 {
+			plt.Legend.Add(label, line)
+		}
+	}
+	plt.Y.Min = 0
+	plt.Legend.Top = true
+	opts.fitCoroutines(&plt.X)
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("rejections_vs_coroutines.png"))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// pinnedCPUs is the CPU set the process was pinned to with -cpu-affinity, or nil when it runs wherever the OS puts it.
+var pinnedCPUs []int
+
+// parseCPUAffinity parses CPU sets in the kernel's cpulist format, such as 0-3,8, separated by slashes, such as
+// 0-7/8-15, where -processes pins each child process to the next set in turn.
+func parseCPUAffinity(s string) ([][]int, error) {
+	var sets [][]int
+	for _, spec := range strings.Split(s, "/") {
+		set, err := parseCPUList(spec)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// parseCPUList parses a cpulist, a comma-separated list of CPU numbers and ranges of them, into sorted CPU numbers.
+func parseCPUList(s string) ([]int, error) {
+	seen := map[int]bool{}
+	var cpus []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		from, to := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			from, to = part[:i], part[i+1:]
+		}
+		lo, err1 := strconv.Atoi(from)
+		hi, err2 := strconv.Atoi(to)
+		if err1 != nil || err2 != nil || lo < 0 || hi < lo {
+			return nil, fmt.Errorf("invalid CPU list %q: expected CPU numbers and ranges such as 0-3,8", s)
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			if !seen[cpu] {
+				seen[cpu] = true
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// formatCPUList writes sorted CPU numbers back as a cpulist, collapsing runs into ranges.
+func formatCPUList(cpus []int) string {
+	var parts []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		} else {
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// unionCPUs is every CPU in any of sets, sorted.
+func unionCPUs(sets [][]int) []int {
+	var all []int
+	seen := map[int]bool{}
+	for _, set := range sets {
+		for _, cpu := range set {
+			if !seen[cpu] {
+				seen[cpu] = true
+				all = append(all, cpu)
+			}
+		}
+	}
+	sort.Ints(all)
+	return all
+}
+
+// pinProcess pins every thread of the process to cpus, and so every thread it starts later, which inherit the
+// affinity of the thread that starts them, child processes included. GOMAXPROCS, which the runtime sized to the CPUs
+// the process could use at startup, is lowered to fit the set, unless it was set explicitly.
+func pinProcess(cpus []int) error {
+	if !affinitySupported {
+		return errors.New("-cpu-affinity needs sched_setaffinity, which only Linux has")
+	}
+	if err := setAffinity(cpus); err != nil {
+		return fmt.Errorf("pinning to CPUs %s: %w", formatCPUList(cpus), err)
+	}
+	pinnedCPUs = cpus
+	if runtime.GOMAXPROCS(0) > len(cpus) && os.Getenv("GOMAXPROCS") == "" {
+		runtime.GOMAXPROCS(len(cpus))
+	}
+	return nil
+}
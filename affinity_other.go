@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+const affinitySupported = false
+
+func getAffinity() ([]int, error) {
+	return nil, errors.New("not supported on this platform")
+}
+
+func setAffinity(cpus []int) error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// aggregationMechanism is a way of getting results from many goroutines into one place, reduced to the mechanism
+// itself, for the aggregation scenario to time: collect is handed the number of goroutines and results each sends, and
+// returns how many results it gathered.
+type aggregationMechanism struct {
+	name        string
+	description string
+	collect     func(goroutines, each int) int
+}
+
+// aggregationResult stands in for a WorkResult: about as large, and copied by value as one is.
+type aggregationResult struct {
+	request  int
+	duration time.Duration
+	finished time.Duration
+	_        [5]int64
+}
+
+var aggregationMechanisms = []aggregationMechanism{
+	{"channel", "single channel fan-in to one collecting goroutine", channelFanIn},
+	{"sharded", "a channel and collecting goroutine per P, merged at the end", shardedFanIn},
+	{"mutex", "a callback appending to a slice under a mutex", mutexSlice},
+	{"atomic", "atomic counter handing out slots of a preallocated array", atomicSlots},
+}
+
+func channelFanIn(goroutines, each int) int {
+	c := make(chan aggregationResult, runtime.GOMAXPROCS(0))
+	var results []aggregationResult
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for r := range c {
+			results = append(results, r)
+		}
+	}()
+	sendAll(goroutines, each, func(r aggregationResult) { c <- r })
+	close(c)
+	<-done
+	return len(results)
+}
+
+func shardedFanIn(goroutines, each int) int {
+	shards := make([]chan aggregationResult, runtime.GOMAXPROCS(0))
+	collected := make([][]aggregationResult, len(shards))
+	var collectors sync.WaitGroup
+	for i := range shards {
+		shards[i] = make(chan aggregationResult, 1)
+		collectors.Add(1)
+		go func(i int) {
+			defer collectors.Done()
+			for r := range shards[i] {
+				collected[i] = append(collected[i], r)
+			}
+		}(i)
+	}
+	sendAll(goroutines, each, func(r aggregationResult) { shards[r.request%len(shards)] <- r })
+	for _, c := range shards {
+		close(c)
+	}
+	collectors.Wait()
+	var results []aggregationResult
+	for _, s := range collected {
+		results = append(results, s...)
+	}
+	return len(results)
+}
+
+func mutexSlice(goroutines, each int) int {
+	var mu sync.Mutex
+	var results []aggregationResult
+	sendAll(goroutines, each, func(r aggregationResult) {
+		mu.Lock()
+		results = append(results, r)
+		mu.Unlock()
+	})
+	return len(results)
+}
+
+func atomicSlots(goroutines, each int) int {
+	results := make([]aggregationResult, goroutines*each)
+	var next int64
+	sendAll(goroutines, each, func(r aggregationResult) {
+		results[atomic.AddInt64(&next, 1)-1] = r
+	})
+	return int(next)
+}
+
+// sendAll starts goroutines goroutines, each sending each results as fast as it can, and waits for them. Request
+// indexes are spread over the goroutines as the harness's are.
+func sendAll(goroutines, each int, send func(aggregationResult)) {
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				send(aggregationResult{request: i*goroutines + g, duration: time.Duration(i)})
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// aggregationScenario compares the ways results can be aggregated from many goroutines: first the mechanisms on their
+// own, with nothing to do but deliver results, where their costs and how they scale with the number of senders show
+// plainly; then the harness's own -aggregation choices carrying the same simulated workload, so that what each adds
+// to the numbers a run measures, and what it costs per sample, can be read off side by side.
+func aggregationScenario(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("aggregation", flag.ExitOnError)
+	coroutines := fs.String("coroutines", "1,4,16,64,256", "numbers of sending goroutines, and co-routine counts for the workload, as for the sweep's -coroutines")
+	results := fs.Int("results", 200000, "results delivered to time each mechanism")
+	requests := fs.Int("requests", 1000, "requests per run of the workload")
+	workTime := fs.Duration("work-time", 100*time.Microsecond, "CPU time per request; short, so that aggregation is a visible share of it")
+	networkTime := fs.Duration("network-time", time.Millisecond, "network time per request")
+	seed := fs.Int64("seed", 1, "seed for the workload")
+	fs.Parse(args)
+
+	if *results < 1 || *requests < 1 {
+		return errors.New("results and requests must be positive")
+	}
+	counts, err := parseSweepValues(*coroutines, "co-routine count", 1)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Time per result delivered, %d results, GOMAXPROCS=%d:\n", *results, runtime.GOMAXPROCS(0))
+	fmt.Printf("%-10s", "mechanism")
+	for _, n := range counts {
+		fmt.Printf(" %10s", fmt.Sprintf("g=%d", n))
+	}
+	fmt.Println()
+	for _, m := range aggregationMechanisms {
+		// A first, discarded round warms up the scheduler and the allocator.
+		m.collect(int(counts[0]), *results/10/int(counts[0])+1)
+		fmt.Printf("%-10s", m.name)
+		for _, n := range counts {
+			each := *results / int(n)
+			if each < 1 {
+				each = 1
+			}
+			start := time.Now()
+			got := m.collect(int(n), each)
+			elapsed := time.Since(start)
+			if got != int(n)*each {
+				return fmt.Errorf("%s aggregation gathered %d of %d results", m.name, got, int(n)*each)
+			}
+			fmt.Printf(" %10s", formatDuration(elapsed/time.Duration(got)))
+			if err := ctx.Err(); err != nil {
+				fmt.Println()
+				return err
+			}
+		}
+		fmt.Println()
+	}
+	for _, m := range aggregationMechanisms {
+		fmt.Printf("  %-10s %s\n", m.name, m.description)
+	}
+
+	base := RunConfig{
+		WorkTime:           *workTime,
+		NetworkTime:        *networkTime,
+		Splits:             1,
+		CPUDist:            Distribution{Kind: "fixed"},
+		NetworkDist:        Distribution{Kind: "fixed"},
+		Collector:          "exact",
+		BaselineIterations: 10,
+		Iterations:         *requests,
+		Seed:               *seed,
+		ResultBatch:        1,
+		Scenario:           "aggregation",
+	}
+	fmt.Printf("\n%v CPU/%v Network per request, %d requests per run, by -aggregation:\n", base.WorkTime, base.NetworkTime, base.Iterations)
+	fmt.Printf("%-13s %8s %10s %10s %10s %12s %12s\n", "aggregation", "c", "rps", "p50 ms", "p99 ms", "deliver", "collect")
+	sweep := Sweep{Executors: []string{"semaphore"}, Coroutines: counts, GOMAXPROCS: []int{0}}
+	for _, aggregation := range []string{"channel", "sharded", "preallocated"} {
+		cfg := base
+		cfg.Aggregation = aggregation
+		recorder := &resultRecorder{}
+		if err := throughputBenchmark(ctx, cfg, sweep, recorder); err != nil {
+			return err
+		}
+		for _, r := range recorder.results {
+			fmt.Printf("%-13s %8d %10.1f %10.3f %10.3f %12s %12s\n", aggregation, r.NumCoroutines, r.ThroughputRps,
+				r.ResponseTimesPercentile(50), r.ResponseTimesPercentile(99), formatDuration(r.Harness.DeliverPerSample),
+				formatDuration(r.Harness.CollectPerSample))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// formatWorkLine is what a request handler formats per item: a log line or a row of a response.
+type formatWorkLine struct {
+	id      int
+	name    string
+	price   float64
+	elapsed time.Duration
+}
+
+var formatWorkLines = func() []formatWorkLine {
+	lines := make([]formatWorkLine, 16)
+	for i := range lines {
+		lines[i] = formatWorkLine{id: i, name: "item-" + strconv.Itoa(i), price: float64(i) * 1.25, elapsed: time.Duration(i) * time.Microsecond}
+	}
+	return lines
+}()
+
+// formatWork formats 16 lines into a fresh strings.Builder n times, each with fmt.Sprintf, as most handlers do: every
+// line is a new string and every builder a new, growing buffer, all garbage by the time the next iteration starts.
+func formatWork(n int64) {
+	var length int
+	for ; n > 0; n-- {
+		var b strings.Builder
+		for _, l := range formatWorkLines {
+			b.WriteString(fmt.Sprintf("id=%d name=%s price=%.2f elapsed=%v\n", l.id, l.name, l.price, l.elapsed))
+		}
+		length += len(b.String())
+	}
+	atomic.AddUint64(&cpuWorkSink, uint64(length))
+}
+
+var formatBuffers = sync.Pool{New: func() interface{} {
+	b := make([]byte, 0, 4<<10)
+	return &b
+}}
+
+// formatPooledWork formats the same 16 lines as formatWork, byte for byte, n times, appending them with strconv into a
+// buffer taken from a sync.Pool and returned to it afterwards, so that once the pool is warm an iteration allocates
+// nothing.
+func formatPooledWork(n int64) {
+	var length int
+	for ; n > 0; n-- {
+		p := formatBuffers.Get().(*[]byte)
+		b := (*p)[:0]
+		for _, l := range formatWorkLines {
+			b = append(b, "id="...)
+			b = strconv.AppendInt(b, int64(l.id), 10)
+			b = append(b, " name="...)
+			b = append(b, l.name...)
+			b = append(b, " price="...)
+			b = strconv.AppendFloat(b, l.price, 'f', 2, 64)
+			b = append(b, " elapsed="...)
+			b = append(b, l.elapsed.String()...)
+			b = append(b, '\n')
+		}
+		length += len(b)
+		*p = b
+		formatBuffers.Put(p)
+	}
+	atomic.AddUint64(&cpuWorkSink, uint64(length))
+}
+
+// allocSamples are the runtime metrics an allocation count is read from: the objects and bytes allocated on the heap
+// since the process started. Unlike runtime.ReadMemStats they are read without stopping the world, so that sampling
+// them around a run doesn't disturb it.
+var allocSamples = []metrics.Sample{{Name: "/gc/heap/allocs:objects"}, {Name: "/gc/heap/allocs:bytes"}}
+
+// heapAllocs returns the objects and bytes the process has allocated on the heap so far.
+func heapAllocs() (objects, bytes uint64) {
+	samples := append([]metrics.Sample(nil), allocSamples...)
+	metrics.Read(samples)
+	return samples[0].Value.Uint64(), samples[1].Value.Uint64()
+}
+
+// allocRecorder keeps every result of a sweep with the heap allocations made during its run, everything the process
+// allocated between the run starting and completing: the harness's own share is the same for every kernel, so the
+// differences between them are the kernels'.
+type allocRecorder struct {
+	resultRecorder
+	objects, bytes uint64
+	allocs         []allocCount
+}
+
+type allocCount struct {
+	objects, bytes uint64
+}
+
+func (r *allocRecorder) OnRunStart(cfg RunConfig) {
+	r.objects, r.bytes = heapAllocs()
+}
+
+func (r *allocRecorder) OnRunComplete(result BenchmarkResult) error {
+	objects, bytes := heapAllocs()
+	r.allocs = append(r.allocs, allocCount{objects - r.objects, bytes - r.bytes})
+	return r.resultRecorder.OnRunComplete(result)
+}
+
+// allocationsScenario compares the allocate-per-request pattern most handlers follow, a new strings.Builder filled with
+// fmt.Sprintf, against the same output appended with strconv into a pooled buffer: first what each costs per operation
+// on its own, then how requests that spend their CPU time on each scale with the co-routine count. Allocation costs
+// little on one goroutine; with many, the garbage they make together drives the collector, whose mark assists are
+// charged to whichever requests allocate during a cycle, and the allocating kernel falls behind as the count grows.
+func allocationsScenario(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("allocations", flag.ExitOnError)
+	coroutines := fs.String("coroutines", "1,4,16,64", "co-routine counts to compare the kernels at, as for the sweep's -coroutines")
+	ops := fs.Int("ops", 20000, "operations run to measure each kernel's allocations and time per operation")
+	requests := fs.Int("requests", 500, "requests per run of the workload")
+	workTime := fs.Duration("work-time", 2*time.Millisecond, "CPU time per request")
+	networkTime := fs.Duration("network-time", 10*time.Millisecond, "network time per request")
+	seed := fs.Int64("seed", 1, "seed for the workload")
+	fs.Parse(args)
+
+	if *ops < 1 || *requests < 1 {
+		return errors.New("ops and requests must be positive")
+	}
+	counts, err := parseSweepValues(*coroutines, "co-routine count", 1)
+	if err != nil {
+		return err
+	}
+	kernels := []*cpuKernel{cpuKernelNamed("format"), cpuKernelNamed("format-pooled")}
+
+	fmt.Printf("Per operation, %d operations on one goroutine:\n", *ops)
+	fmt.Printf("%-14s %12s %12s %12s\n", "kernel", "allocs/op", "bytes/op", "time/op")
+	for _, k := range kernels {
+		k.run(int64(*ops/10 + 1)) // warms up the pool, and the allocator's caches for both
+		objects, bytes := heapAllocs()
+		start := time.Now()
+		k.run(int64(*ops))
+		elapsed := time.Since(start)
+		afterObjects, afterBytes := heapAllocs()
+		fmt.Printf("%-14s %12.1f %12.0f %12s\n", k.Name, float64(afterObjects-objects)/float64(*ops),
+			float64(afterBytes-bytes)/float64(*ops), formatDuration(elapsed/time.Duration(*ops)))
+	}
+
+	base := RunConfig{
+		WorkTime:           *workTime,
+		NetworkTime:        *networkTime,
+		Splits:             5,
+		CPUDist:            Distribution{Kind: "fixed"},
+		NetworkDist:        Distribution{Kind: "fixed"},
+		Collector:          "exact",
+		BaselineIterations: 10,
+		Iterations:         *requests,
+		Seed:               *seed,
+		ResultBatch:        1,
+		Scenario:           "allocations",
+	}
+	fmt.Printf("\n%v CPU/%v Network per request, %d requests per run, GOMAXPROCS=%d:\n", base.WorkTime, base.NetworkTime,
+		base.Iterations, runtime.GOMAXPROCS(0))
+	fmt.Printf("%-14s %8s %12s %12s %12s %10s %10s %10s\n", "kernel", "c", "allocs/req", "KiB/req", "GC cycles", "rps", "p99 ms", "scaling")
+	sweep := Sweep{Executors: []string{"semaphore"}, Coroutines: counts, GOMAXPROCS: []int{0}}
+	for _, k := range kernels {
+		cfg := base
+		cfg.CPUKernel = k.Name
+		recorder := &allocRecorder{}
+		if err := throughputBenchmark(ctx, cfg, sweep, recorder); err != nil {
+			return err
+		}
+		// Scaling efficiency is the share of the throughput linear scaling from the smallest count would reach.
+		first := recorder.results[0]
+		for i, r := range recorder.results {
+			scaling := "-"
+			if i > 0 && first.ThroughputRps > 0 {
+				ideal := first.ThroughputRps * float64(r.NumCoroutines) / float64(first.NumCoroutines)
+				scaling = formatPercent(r.ThroughputRps / ideal)
+			}
+			n := float64(r.ResponseTimes.Count())
+			if n == 0 {
+				continue
+			}
+			a := recorder.allocs[i]
+			fmt.Printf("%-14s %8d %12.0f %12.1f %12d %10.1f %10.2f %10s\n", k.Name, r.NumCoroutines, float64(a.objects)/n,
+				float64(a.bytes)/n/(1<<10), r.GCCycles, r.ThroughputRps, r.ResponseTimesPercentile(99), scaling)
+		}
+	}
+	return nil
+}
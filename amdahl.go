@@ -0,0 +1,59 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// idealSpeedup is the speedup of n concurrent requests with nothing lost to scheduling or contention. Amdahl's law
+// with the network time as the part that parallelizes without bound and the CPU time as the part limited to cpus:
+// speedup grows with n until the CPUs are saturated, at cpus times the ratio of a request's total time to its CPU
+// time.
+func idealSpeedup(n, cpus float64, workTime, networkTime float64) float64 {
+	if workTime <= 0 {
+		return n
+	}
+	return math.Min(n, cpus*(workTime+networkTime)/workTime)
+}
+
+// addIdealSpeedup overlays the ideal speedup for the CPU/network split and CPUs of runs as a dotted line in c, so
+// that the gap to the measured curve shows what scheduler overhead and contention cost.
+func addIdealSpeedup(plt *plot.Plot, runs []BenchmarkResult, label string, c color.Color) error {
+	if len(runs) == 0 {
+		return nil
+	}
+	first := runs[0]
+	cpus := first.EffectiveCPUs
+	if cpus <= 0 {
+		cpus = float64(first.Config.GOMAXPROCS)
+	}
+	var highest float64
+	for _, r := range runs {
+		highest = math.Max(highest, float64(r.NumCoroutines))
+	}
+	work, network := float64(first.WorkTime), float64(first.NetworkTime)
+	// The curve bends where the CPUs saturate, so that point is drawn exactly.
+	pts := plotter.XYs{{X: 1, Y: idealSpeedup(1, cpus, work, network)}}
+	if knee := idealSpeedup(math.Inf(1), cpus, work, network); knee > 1 && knee < highest {
+		pts = append(pts, plotter.XY{X: knee, Y: knee})
+	}
+	pts = append(pts, plotter.XY{X: highest, Y: idealSpeedup(highest, cpus, work, network)})
+	line, err := plotter.NewLine(pts)
+	if err != nil {
+		return err
+	}
+	line.LineStyle.Color = c
+	line.LineStyle.Width = vg.Points(1)
+	line.LineStyle.Dashes = []vg.Length{vg.Points(1), vg.Points(2)}
+	plt.Add(line)
+	name := "Amdahl ideal, CPUs=" + formatFloat(cpus, 2)
+	if label != "" {
+		name = "Amdahl ideal " + label + ", CPUs=" + formatFloat(cpus, 2)
+	}
+	plt.Legend.Add(name, line)
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// ApdexScore rates a run's response times against a target T as users would: requests answered within T satisfy,
+// those within 4T are tolerated, and slower ones, failed ones and ones that timed out frustrate. The score is the
+// satisfied plus half the tolerating, over all requests, from 0 for none satisfied to 1 for all.
+type ApdexScore struct {
+	Threshold  time.Duration `json:"threshold_ns"`
+	Satisfied  int           `json:"satisfied"`
+	Tolerating int           `json:"tolerating"`
+	Frustrated int           `json:"frustrated"`
+	Score      float64       `json:"score"`
+}
+
+// Apdex scores the run against Config.Apdex; nil when it has no target. A failed request counts as frustrated where
+// the run kept its response time apart, which runs split over processes don't.
+func (b BenchmarkResult) Apdex() *ApdexScore {
+	t := b.Config.Apdex
+	if t <= 0 || b.ResponseTimes == nil {
+		return nil
+	}
+	satisfiedMs := float64(t) / float64(time.Millisecond)
+	toleratingMs := 4 * satisfiedMs
+	a := &ApdexScore{Threshold: t}
+	a.Satisfied = countWithin(b.ResponseTimes, satisfiedMs) - countWithin(b.ErrorTimes, satisfiedMs)
+	a.Tolerating = countWithin(b.ResponseTimes, toleratingMs) - countWithin(b.ErrorTimes, toleratingMs) - a.Satisfied
+	total := b.ResponseTimes.Count() + b.Timeouts
+	a.Frustrated = total - a.Satisfied - a.Tolerating
+	if total > 0 {
+		a.Score = (float64(a.Satisfied) + float64(a.Tolerating)/2) / float64(total)
+	}
+	return a
+}
+
+// countWithin is how many of the values c was given are at most ms, scaled up from the values it kept when it only
+// kept a sample of them.
+func countWithin(c Collector, ms float64) int {
+	if c == nil || c.Count() == 0 {
+		return 0
+	}
+	values := c.Values()
+	n := 0
+	for _, v := range values {
+		if v <= ms {
+			n++
+		}
+	}
+	if len(values) == c.Count() {
+		return n
+	}
+	return int(math.Round(float64(n) * float64(c.Count()) / float64(len(values))))
+}
+
+func outputApdex(result BenchmarkResult) {
+	a := result.Apdex()
+	if a == nil {
+		return
+	}
+	fmt.Printf("\tApdex(%s): %s (%s satisfied, %s tolerating, %s frustrated)\n", formatDuration(a.Threshold), formatFloat(a.Score, 2),
+		formatCount(int64(a.Satisfied)), formatCount(int64(a.Tolerating)), formatCount(int64(a.Frustrated)))
+}
+
+// plotApdex draws the Apdex score against the co-routine count for sweeps with a target: one number per
+// configuration, where 1 is every user satisfied, to read the sweep by.
+func plotApdex(results []BenchmarkResult, opts PlotOptions) error {
+	if results[0].Config.Apdex <= 0 {
+		return nil
+	}
+	plt := plot.New()
+	plt.Title.Text = fmt.Sprintf("Apdex(%s) vs. Number of Co-Routines", formatDuration(results[0].Config.Apdex))
+	plt.X.Label.Text = "Number of Co-Routines"
+	plt.Y.Label.Text = "Apdex score"
+	labels, series := bySeries(results)
+	for i, label := range labels {
+		line, err := sweepLine(plt, series[label], func(r BenchmarkResult) float64 {
+			if a := r.Apdex(); a != nil && a.Satisfied+a.Tolerating+a.Frustrated > 0 {
+				return a.Score
+			}
+			return math.NaN()
+		}, plotutil.Color(i))
+		if err != nil {
+			return err
+		}
+		if line != nil && len(labels) > 1 {
+			plt.Legend.Add(label, line)
+		}
+	}
+	plt.Y.Min, plt.Y.Max = 0, 1
+	plt.Legend.Top = true
+	opts.fitCoroutines(&plt.X)
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("apdex_vs_coroutines.png"))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// artifact is an entry of the index an artifactStore writes. Files made for a single run carry its parameters.
+type artifact struct {
+	File       string `json:"file"`
+	Executor   string `json:"executor,omitempty"`
+	Coroutines int64  `json:"num_coroutines,omitempty"`
+	GOMAXPROCS int    `json:"gomaxprocs,omitempty"`
+	Repetition int    `json:"repetition,omitempty"`
+	// Dims holds the run's value of each grid dimension the sweep varied, such as splits or work_time.
+	Dims map[string]string `json:"dims,omitempty"`
+}
+
+// artifactStore places the files a sweep produces in a directory of their own, named after the time the sweep
+// started, and names the files made for each run after its parameters so that later runs don't overwrite them. A nil
+// store leaves every file under its plain name in the working directory.
+type artifactStore struct {
+	dir   string
+	dims  []gridDim // name run files after their values of these too, since the sweep varies them
+	mu    sync.Mutex
+	index []artifact
+	seen  map[string]bool
+}
+
+func newArtifactStore(root string, started time.Time) (*artifactStore, error) {
+	dir := filepath.Join(root, "sweep-"+started.Format("20060102-150405"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &artifactStore{dir: dir, seen: map[string]bool{}}, nil
+}
+
+// path returns where to write the artifact called name, made for run or, if run is nil, for the whole sweep.
+func (s *artifactStore) path(name string, run *RunConfig) string {
+	if s == nil {
+		return name
+	}
+	entry := artifact{File: name}
+	if run != nil {
+		entry = artifact{File: runFileName(name, *run, s.dims), Executor: run.Executor, Coroutines: run.NumCoroutines,
+			GOMAXPROCS: run.GOMAXPROCS, Repetition: run.Repetition}
+		for _, d := range s.dims {
+			if entry.Dims == nil {
+				entry.Dims = map[string]string{}
+			}
+			entry.Dims[d.file] = d.value(*run)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.seen[entry.File] {
+		s.seen[entry.File] = true
+		s.index = append(s.index, entry)
+	}
+	return filepath.Join(s.dir, entry.File)
+}
+
+// runFileName names the file called name made for run after its executor, co-routine count, GOMAXPROCS, its values of
+// dims and its repetition, such as hist-pool-c8-p4-s3.png.
+func runFileName(name string, run RunConfig, dims []gridDim) string {
+	ext := filepath.Ext(name)
+	file := fmt.Sprintf("%s-%s-c%d-p%d", strings.TrimSuffix(name, ext), run.Executor, run.NumCoroutines, run.GOMAXPROCS)
+	for _, d := range dims {
+		file += "-" + d.short + d.value(run)
+	}
+	if run.Repetition > 0 {
+		file += fmt.Sprintf("-r%d", run.Repetition)
+	}
+	return file + ext
+}
+
+// Close writes index.json, listing every artifact the sweep produced.
+func (s *artifactStore) Close() error {
+	if s == nil {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, "index.json"), append(b, '\n'), 0o644)
+}
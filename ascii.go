@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+var barEighths = []rune(" ▏▎▍▌▋▊▉")
+
+// bar renders value/max as a bar of up to width cells, with eighth-cell resolution.
+func bar(value, max float64, width int) string {
+	if max <= 0 || value <= 0 {
+		return ""
+	}
+	eighths := int(math.Round(value / max * float64(width*8)))
+	s := strings.Repeat("█", eighths/8)
+	if eighths%8 > 0 {
+		s += string(barEighths[eighths%8])
+	}
+	return s
+}
+
+// asciiHistogram renders values in bins equal-width bins, one line per bin, for terminals where a PNG is no use.
+func asciiHistogram(values []float64, bins, width int) string {
+	if len(values) == 0 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	binWidth := (hi - lo) / float64(bins)
+	if binWidth == 0 {
+		bins, binWidth = 1, 1
+	}
+	counts := make([]int, bins)
+	for _, v := range values {
+		i := int((v - lo) / binWidth)
+		if i >= bins {
+			i = bins - 1
+		}
+		counts[i]++
+	}
+	var most int
+	for _, c := range counts {
+		if c > most {
+			most = c
+		}
+	}
+	var b strings.Builder
+	for i, c := range counts {
+		from := lo + float64(i)*binWidth
+		fmt.Fprintf(&b, "%9s - %9s ms |%-*s %d\n", formatFloat(from, 2), formatFloat(from+binWidth, 2), width, bar(float64(c), float64(most), width), c)
+	}
+	return b.String()
+}
+
+// outputAsciiResult prints the run's response-time histogram and percentiles as bars.
+func outputAsciiResult(result BenchmarkResult) {
+	values := result.ResponseTimes.Values()
+	if len(values) == 0 {
+		return
+	}
+	fmt.Println("\tResponse time histogram:")
+	for _, line := range strings.Split(strings.TrimSuffix(asciiHistogram(values, 20, 40), "\n"), "\n") {
+		fmt.Println("\t" + line)
+	}
+	pcts := []float64{50, 90, 95, 99, 99.9}
+	max := result.ResponseTimesPercentile(pcts[len(pcts)-1])
+	fmt.Println("\tPercentiles:")
+	for _, pct := range pcts {
+		v := result.ResponseTimesPercentile(pct)
+		fmt.Printf("\t%6s %9s ms |%s\n", fmt.Sprintf("p%g", pct), formatFloat(v, 2), bar(v, max, 40))
+	}
+}
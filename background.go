@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// backgroundAllocator stands in for batch work sharing the process with the server, such as a cache rebuild. Its
+// workers allocate continuously while keeping a live set around, so GC cycles come often and always have marking to
+// do. Rate caps the bytes allocated per second across all workers; zero leaves them unthrottled.
+type backgroundAllocator struct {
+	Workers   int
+	ChunkSize int
+	LiveBytes int
+	Rate      float64
+
+	allocated int64
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func (b *backgroundAllocator) Start() {
+	b.stop = make(chan struct{})
+	slots := b.LiveBytes / b.Workers / b.ChunkSize
+	if slots < 1 {
+		slots = 1
+	}
+	for i := 0; i < b.Workers; i++ {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			live := make([][]byte, slots)
+			var perChunk time.Duration
+			if b.Rate > 0 {
+				perChunk = time.Duration(float64(b.ChunkSize) / (b.Rate / float64(b.Workers)) * float64(time.Second))
+			}
+			next := time.Now()
+			for n := 0; ; n++ {
+				select {
+				case <-b.stop:
+					return
+				default:
+				}
+				chunk := make([]byte, b.ChunkSize)
+				for j := 0; j < len(chunk); j += 4096 {
+					chunk[j] = byte(n)
+				}
+				live[n%slots] = chunk
+				atomic.AddInt64(&b.allocated, int64(b.ChunkSize))
+				if perChunk > 0 {
+					next = next.Add(perChunk)
+					if wait := time.Until(next); wait > 0 {
+						select {
+						case <-b.stop:
+							return
+						case <-time.After(wait):
+						}
+					}
+				}
+			}
+		}()
+	}
+}
+
+// Stop waits for the workers to exit and returns the bytes they allocated.
+func (b *backgroundAllocator) Stop() int64 {
+	close(b.stop)
+	b.wg.Wait()
+	return atomic.LoadInt64(&b.allocated)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parseBaseline parses -baseline: a number of requests, a duration to run requests for, or off.
+func parseBaseline(s string) (iterations int, duration time.Duration, err error) {
+	s = strings.TrimSpace(s)
+	if s == "off" {
+		return 0, 0, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return n, 0, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return 0, d, nil
+	}
+	return 0, 0, fmt.Errorf("-baseline must be a number of requests, a duration or off, got %q", s)
+}
+
+// measuredBaseline is the serial throughput of a parameter set, as the sweep measured it or a baseline file holds it.
+type measuredBaseline struct {
+	ThroughputRps float64   `json:"throughput_rps"`
+	Requests      int       `json:"requests"`
+	Measured      time.Time `json:"measured"`
+}
+
+// baselineCache holds the baselines a sweep has measured, by parameter set, so that each is measured once however many
+// co-routine counts, executors and repetitions share it. With a file, it starts from the baselines the file holds and
+// writes back every one it measures, so that later sweeps of the same workload skip measuring them at all.
+type baselineCache struct {
+	mu        sync.Mutex
+	path      string
+	baselines map[string]measuredBaseline
+}
+
+// newBaselineCache returns a cache backed by path, which need not exist yet; "" keeps the baselines in memory only.
+func newBaselineCache(path string) (*baselineCache, error) {
+	c := &baselineCache{path: path, baselines: map[string]measuredBaseline{}}
+	if path == "" {
+		return c, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &c.baselines); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// baselineKey names the parameter set a run's baseline depends on: the serial run calls the workload directly, so the
+// executor, co-routine count and load play no part. The rest of the workload, such as its phases and middleware, is
+// not in the key; a baseline file is only to be reused with the same workload flags.
+func baselineKey(cfg RunConfig) string {
+	parts := []string{"gomaxprocs=" + strconv.Itoa(cfg.GOMAXPROCS)}
+	for _, d := range gridDims {
+		if d.file == "rate_limit" || d.file == "mq_consumers" {
+			continue // only shape the concurrent run
+		}
+		parts = append(parts, d.file+"="+d.value(cfg))
+	}
+	return strings.Join(parts, ",")
+}
+
+// baselineRps returns the serial throughput the run's speedup is relative to, measuring it on first use for each
+// parameter set, or 0 when the baseline is off.
+func (cfg RunConfig) baselineRps(ctx context.Context, clock Clock) (float64, error) {
+	if cfg.BaselineIterations <= 0 && cfg.BaselineDuration <= 0 {
+		return 0, nil
+	}
+	key := baselineKey(cfg)
+	if cfg.baselines != nil {
+		cfg.baselines.mu.Lock()
+		b, ok := cfg.baselines.baselines[key]
+		cfg.baselines.mu.Unlock()
+		if ok {
+			return b.ThroughputRps, nil
+		}
+	}
+	b, err := measureBaseline(ctx, cfg, clock)
+	if err != nil {
+		return 0, err
+	}
+	if cfg.baselines != nil {
+		if err := cfg.baselines.put(key, b); err != nil {
+			return 0, fmt.Errorf("-baseline-file: %w", err)
+		}
+	}
+	return b.ThroughputRps, nil
+}
+
+// speedup is throughput relative to the baseline's, or 0 without one.
+func speedup(rps, baselineRps float64) float64 {
+	if baselineRps <= 0 {
+		return 0
+	}
+	return rps / baselineRps
+}
+
+// measureBaseline runs the workload's requests one after another, BaselineIterations of them or for
+// BaselineDuration, and returns their throughput.
+func measureBaseline(ctx context.Context, cfg RunConfig, clock Clock) (measuredBaseline, error) {
+	workload, _, err := buildWorkload(simulatedWorkload(cfg), cfg.Middleware, clock)
+	if err != nil {
+		return measuredBaseline{}, err
+	}
+	start := clock.Now()
+	x := 0
+	for ; x < cfg.BaselineIterations || (cfg.BaselineDuration > 0 && clock.Now().Sub(start) < cfg.BaselineDuration); x++ {
+		if err := ctx.Err(); err != nil {
+			return measuredBaseline{}, err
+		}
+		requestCtx, _ := cfg.requestContext(context.Background(), x)
+		workload.Do(requestCtx, newRequestLog(x, cfg.LogLevel))
+	}
+	elapsed := clock.Now().Sub(start)
+	return measuredBaseline{ThroughputRps: float64(x) / elapsed.Seconds(), Requests: x, Measured: time.Now()}, nil
+}
+
+func (c *baselineCache) put(key string, b measuredBaseline) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.baselines[key] = b
+	if c.path == "" {
+		return nil
+	}
+	out, err := json.MarshalIndent(c.baselines, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, append(out, '\n'), 0o644)
+}
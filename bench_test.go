@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// BenchmarkExecutors runs the default workload with every executor at a few co-routine counts, e.g.
+//
+//	go test -run '^$' -bench Executors/pool -benchtime 200x
+func BenchmarkExecutors(b *testing.B) {
+	for _, executor := range executorNames {
+		for _, coroutines := range []int64{1, 8, 32} {
+			b.Run(fmt.Sprintf("%s/coroutines=%d", executor, coroutines), func(b *testing.B) {
+				runTestingBenchmark(b, benchConfig(executor, coroutines))
+			})
+		}
+	}
+}
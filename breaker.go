@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BreakerPolicy puts a circuit breaker in front of the downstream behind the network phases, as a client library
+// would: once at least ErrorRate of the last Window calls failed, it opens and fails every call at once for OpenFor,
+// then half-opens, letting HalfOpenCalls trial calls through. It closes again if they all succeed and reopens at the
+// first that fails. Unlike the breaker middleware, which trips on consecutive failed requests, it watches the calls
+// themselves, retries included. An ErrorRate of zero never opens.
+type BreakerPolicy struct {
+	ErrorRate     float64       `json:"error_rate"`
+	Window        int           `json:"window"`
+	OpenFor       time.Duration `json:"open_for"`
+	HalfOpenCalls int           `json:"half_open_calls"`
+}
+
+func (p BreakerPolicy) enabled() bool {
+	return p.ErrorRate > 0
+}
+
+func (p BreakerPolicy) validate() error {
+	if p.ErrorRate < 0 || p.ErrorRate > 1 {
+		return fmt.Errorf("breaker error rate must be between 0 and 1, got %g", p.ErrorRate)
+	}
+	if !p.enabled() {
+		return nil
+	}
+	if p.Window < 1 || p.HalfOpenCalls < 1 {
+		return fmt.Errorf("breaker window and half-open calls must be at least 1, got %d and %d", p.Window, p.HalfOpenCalls)
+	}
+	if p.OpenFor < 0 {
+		return fmt.Errorf("breaker open time must not be negative, got %v", p.OpenFor)
+	}
+	return nil
+}
+
+func (p BreakerPolicy) String() string {
+	return fmt.Sprintf("opens at %s of the last %d calls failing, for %v, then tries %d calls", formatPercent(p.ErrorRate),
+		p.Window, p.OpenFor, p.HalfOpenCalls)
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker is a run's breaker, shared by every request.
+type circuitBreaker struct {
+	policy BreakerPolicy
+	clock  Clock
+
+	mu       sync.Mutex
+	state    breakerState
+	outcomes []bool // of the last calls while closed, true for a failure; a ring once full
+	next     int
+	failures int
+	openedAt time.Time
+	trials   int // calls let through since half-opening
+	passed   int // of which succeeded
+	opened   int64
+	rejected int64
+	openTime time.Duration // spent open or half-open before the last opening
+}
+
+func newCircuitBreaker(policy BreakerPolicy, clock Clock) *circuitBreaker {
+	return &circuitBreaker{policy: policy, clock: clock, outcomes: make([]bool, 0, policy.Window)}
+}
+
+// allow reports whether a call may go ahead, counting those it turns away.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.policy.OpenFor {
+			b.rejected++
+			return false
+		}
+		b.state, b.trials, b.passed = breakerHalfOpen, 0, 0
+		fallthrough
+	case breakerHalfOpen:
+		if b.trials >= b.policy.HalfOpenCalls {
+			b.rejected++
+			return false
+		}
+		b.trials++
+	}
+	return true
+}
+
+// record notes how a call allow let through went. Calls that were already under way when the breaker opened don't
+// count.
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerHalfOpen:
+		if failed {
+			b.trip()
+			break
+		}
+		b.passed++
+		if b.passed == b.policy.HalfOpenCalls {
+			b.openTime += b.clock.Now().Sub(b.openedAt)
+			b.state, b.outcomes, b.next, b.failures = breakerClosed, b.outcomes[:0], 0, 0
+		}
+	case breakerClosed:
+		if len(b.outcomes) < b.policy.Window {
+			b.outcomes = append(b.outcomes, failed)
+		} else {
+			if b.outcomes[b.next] {
+				b.failures--
+			}
+			b.outcomes[b.next] = failed
+			b.next = (b.next + 1) % b.policy.Window
+		}
+		if failed {
+			b.failures++
+		}
+		if len(b.outcomes) == b.policy.Window && float64(b.failures) >= b.policy.ErrorRate*float64(b.policy.Window) {
+			b.trip()
+		}
+	}
+}
+
+func (b *circuitBreaker) trip() {
+	now := b.clock.Now()
+	if b.state == breakerHalfOpen {
+		b.openTime += now.Sub(b.openedAt)
+	}
+	b.state, b.openedAt = breakerOpen, now
+	b.opened++
+}
+
+// BreakerStats is what a run's circuit breaker did.
+type BreakerStats struct {
+	Opened   int64         // times it opened, reopening from half-open included
+	Rejected int64         // calls it failed without making them
+	OpenTime time.Duration // spent open or half-open
+}
+
+// stats returns what the breaker did so far, taking it as closing now if it is open; the zero value for no breaker.
+func (b *circuitBreaker) stats() BreakerStats {
+	if b == nil {
+		return BreakerStats{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	open := b.openTime
+	if b.state != breakerClosed {
+		open += b.clock.Now().Sub(b.openedAt)
+	}
+	return BreakerStats{Opened: b.opened, Rejected: b.rejected, OpenTime: open}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// LatencyBudget splits a request's time between where it was spent. Queue is the wait between the request being
+// issued and it starting to run; CPU, Network and OtherPhases are its recorded phases; Unrecorded is the rest of its
+// response time, mostly waiting to be scheduled; Harness is the harness's own cost of recording it.
+type LatencyBudget struct {
+	Queue       time.Duration
+	CPU         time.Duration
+	Network     time.Duration
+	OtherPhases time.Duration
+	Unrecorded  time.Duration
+	Harness     time.Duration
+}
+
+func (b LatencyBudget) Total() time.Duration {
+	return b.Queue + b.CPU + b.Network + b.OtherPhases + b.Unrecorded + b.Harness
+}
+
+// parts lists the budget's components in the order they stack.
+func (b LatencyBudget) parts() []time.Duration {
+	return []time.Duration{b.Queue, b.CPU, b.Network, b.OtherPhases, b.Unrecorded, b.Harness}
+}
+
+var budgetParts = []string{"queue wait", "CPU", "network", "other phases", "unrecorded", "harness"}
+
+// LatencyBudgets is the budget of the average request and that of the requests at or beyond the 99th percentile.
+type LatencyBudgets struct {
+	Mean LatencyBudget
+	P99  LatencyBudget
+}
+
+// budgetCollector breaks down every request of a run. It reads each request's log, so is only used for the run
+// selected with -budget.
+type budgetCollector struct {
+	requests []LatencyBudget
+}
+
+func (c *budgetCollector) add(result WorkResult) {
+	c.requests = append(c.requests, requestBudget(result))
+}
+
+// requestBudget splits one request's time between the phases in its log. The harness's share is left to
+// the caller, which knows it only for the run as a whole.
+func requestBudget(result WorkResult) LatencyBudget {
+	b := LatencyBudget{Queue: result.queueWait}
+	timeline := result.log.timeline(result.timeTaken)
+	for _, s := range timeline.Spans {
+		if !isLeafSpan(s, timeline.Spans) {
+			continue
+		}
+		switch s.Kind {
+		case "cpu":
+			b.CPU += s.End - s.Start
+		case "network":
+			b.Network += s.End - s.Start
+		default:
+			b.OtherPhases += s.End - s.Start
+		}
+	}
+	if rest := result.timeTaken - b.CPU - b.Network - b.OtherPhases; rest > 0 {
+		b.Unrecorded = rest
+	}
+	return b
+}
+
+// isLeafSpan reports whether no other span is nested directly inside s, so that enclosing spans such as middleware
+// are not counted twice.
+func isLeafSpan(s Span, spans []Span) bool {
+	for _, o := range spans {
+		if o.Depth == s.Depth+1 && o.Start >= s.Start && o.End <= s.End {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *budgetCollector) merge(other *budgetCollector) {
+	c.requests = append(c.requests, other.requests...)
+}
+
+// summarize averages the budgets over all requests and over the slowest percent of them, adding the harness's
+// per-request cost to both.
+func (c *budgetCollector) summarize(harness HarnessOverhead) *LatencyBudgets {
+	if len(c.requests) == 0 {
+		return nil
+	}
+	sort.Slice(c.requests, func(i, j int) bool { return c.requests[i].Total() < c.requests[j].Total() })
+	tail := len(c.requests) * 99 / 100
+	if tail == len(c.requests) {
+		tail--
+	}
+	budgets := &LatencyBudgets{Mean: meanBudget(c.requests), P99: meanBudget(c.requests[tail:])}
+	budgets.Mean.Harness = harness.DeliverPerSample
+	budgets.P99.Harness = harness.DeliverPerSample
+	return budgets
+}
+
+func meanBudget(requests []LatencyBudget) LatencyBudget {
+	var sum LatencyBudget
+	for _, b := range requests {
+		sum.Queue += b.Queue
+		sum.CPU += b.CPU
+		sum.Network += b.Network
+		sum.OtherPhases += b.OtherPhases
+		sum.Unrecorded += b.Unrecorded
+	}
+	n := time.Duration(len(requests))
+	return LatencyBudget{sum.Queue / n, sum.CPU / n, sum.Network / n, sum.OtherPhases / n, sum.Unrecorded / n, 0}
+}
+
+// saveBudget draws the run's average and p99 latency budgets as stacked bars.
+func saveBudget(result BenchmarkResult, opts PlotOptions) error {
+	if result.Budget == nil {
+		return nil
+	}
+	plt := plot.New()
+	plt.Title.Text = fmt.Sprintf("Latency Budget (%d co-routines, %s executor)", result.NumCoroutines, result.Config.Executor)
+	plt.Y.Label.Text = latencyLabel("Time")
+	latencyTicks(&plt.Y)
+
+	mean, p99 := result.Budget.Mean.parts(), result.Budget.P99.parts()
+	var below *plotter.BarChart
+	for i, name := range budgetParts {
+		values := plotter.Values{
+			float64(mean[i]) / float64(time.Millisecond),
+			float64(p99[i]) / float64(time.Millisecond),
+		}
+		bar, err := plotter.NewBarChart(values, vg.Points(40))
+		if err != nil {
+			return err
+		}
+		bar.LineStyle.Width = 0
+		bar.Color = plotutil.Color(i)
+		if below != nil {
+			bar.StackOn(below)
+		}
+		below = bar
+		plt.Add(bar)
+		plt.Legend.Add(name, bar)
+	}
+	plt.NominalX("average", "p99")
+	plt.Legend.Top = true
+	plt.Legend.Left = true
+
+	return opts.save(plt, 4*vg.Inch, 5*vg.Inch, opts.runFile(result, "latency_budget.png"))
+}
+
+func outputBudget(budgets *LatencyBudgets) {
+	fmt.Println("\tLatency budget (average / p99):")
+	mean, p99 := budgets.Mean.parts(), budgets.P99.parts()
+	for i, name := range budgetParts {
+		fmt.Printf("\t\t%-13s %s / %s\n", name, formatDuration(mean[i]), formatDuration(p99[i]))
+	}
+	fmt.Printf("\t\t%-13s %s / %s\n", "total", formatDuration(budgets.Mean.Total()), formatDuration(budgets.P99.Total()))
+}
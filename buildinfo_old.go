@@ -0,0 +1,9 @@
+//go:build !go1.18
+// +build !go1.18
+
+package main
+
+// Go releases before 1.18 don't stamp binaries with their VCS revision.
+func toolCommit() string {
+	return ""
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// burstGenerator issues requests in bursts of BurstSize, one starting every BurstInterval, each spread evenly over
+// BurstLength or, with none, all issued at once, leaving the rest of the interval idle. Arrivals are scheduled at
+// absolute times, as scheduledGenerator's are.
+type burstGenerator struct {
+	clock Clock
+	spec  LoadSpec
+	start time.Time
+	i     int
+}
+
+func (g *burstGenerator) Next(ctx context.Context) error {
+	if g.start.IsZero() {
+		g.start = g.clock.Now()
+	}
+	at := g.spec.burstOffset(g.i)
+	g.i++
+	return sleepUntil(ctx, g.clock, g.start.Add(at))
+}
+
+// burstOffset is when request x of a burst load is due, from the start of the run.
+func (s LoadSpec) burstOffset(x int) time.Duration {
+	return time.Duration(x/s.BurstSize)*s.BurstInterval + s.withinBurst(x)
+}
+
+// withinBurst is how long after its burst started request x of a burst load is due.
+func (s LoadSpec) withinBurst(x int) time.Duration {
+	return time.Duration(x%s.BurstSize) * s.BurstLength / time.Duration(s.BurstSize)
+}
+
+// BurstStats is how a run absorbed the bursts of a burst load. A burst's tail is the longest any of its requests took
+// as a caller saw it, queue wait included, and its drain time how long after it started its last request completed:
+// a run that keeps up drains each burst within a response time of its end, and one that can't carries a queue into
+// the next.
+type BurstStats struct {
+	Bursts      int     `json:"bursts"`
+	MaxQueue    int64   `json:"max_queue"`     // requests issued and waiting for a co-routine at once, at most
+	TailP50Ms   float64 `json:"tail_p50_ms"`   // the median burst's tail
+	TailMaxMs   float64 `json:"tail_max_ms"`   // the worst burst's tail
+	DrainMeanMs float64 `json:"drain_mean_ms"` // mean drain time
+	DrainMaxMs  float64 `json:"drain_max_ms"`  // the slowest burst's drain time
+	Overlapped  int     `json:"overlapped"`    // bursts still draining when the next one started
+}
+
+// burstTracker follows a burst load's queue and bursts as requests complete. It measures from when each request was
+// due by the schedule, not from when the load generator got round to issuing it, so that an executor whose Go blocks
+// while every co-routine is busy, holding the generator back, can't hide the queue that builds behind it.
+type burstTracker struct {
+	spec       LoadSpec
+	limit      int   // requests the run issues at most, which no queue grows past
+	coroutines int64 // requests that can run at once; zero for no limit, which nothing queues for
+	clock      Clock
+
+	mu              sync.Mutex
+	start           time.Time // when the first request was due, which the schedule is offset from
+	done, most      int64
+	tails, drains   []time.Duration // by burst
+	completedBursts map[int]bool    // the bursts at least one of whose requests completed
+}
+
+// newBurstTracker returns a tracker for cfg's bursts, or nil when its load is not bursty.
+func newBurstTracker(cfg RunConfig, limit int) *burstTracker {
+	if cfg.Load.Kind != "burst" {
+		return nil
+	}
+	t := &burstTracker{spec: cfg.Load, limit: limit, coroutines: cfg.NumCoroutines, clock: cfg.clock(),
+		completedBursts: map[int]bool{}}
+	if cfg.Executor == "unbounded" {
+		t.coroutines = 0
+	}
+	return t
+}
+
+// issued notes when the schedule started, as the first request is issued the moment it is due.
+func (t *burstTracker) issued() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.start.IsZero() {
+		t.start = t.clock.Now()
+	}
+	t.mu.Unlock()
+}
+
+// dueBy is how many requests the schedule has had due by elapsed from its start.
+func (s LoadSpec) dueBy(elapsed time.Duration) int64 {
+	bursts := int64(elapsed / s.BurstInterval)
+	into := elapsed % s.BurstInterval
+	due := int64(s.BurstSize)
+	if s.BurstLength > 0 && into < s.BurstLength {
+		due = int64(into*time.Duration(s.BurstSize)/s.BurstLength) + 1
+	}
+	return bursts*int64(s.BurstSize) + due
+}
+
+// leave counts a request as done with, whether it completed or was turned away. The queue only shrinks as requests
+// leave, so it is at its longest just before one does: every request due by then that is neither done with nor on one
+// of the co-routines.
+func (t *burstTracker) leave() {
+	due := t.spec.dueBy(t.clock.Now().Sub(t.start))
+	if due > int64(t.limit) {
+		due = int64(t.limit)
+	}
+	if waiting := due - t.done - t.coroutines; t.coroutines > 0 && waiting > t.most {
+		t.most = waiting
+	}
+	t.done++
+}
+
+// rejected counts a request turned away without running.
+func (t *burstTracker) rejected() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.leave()
+	t.mu.Unlock()
+}
+
+// completed records request x, which completed at end.
+func (t *burstTracker) completed(x int, end time.Time) {
+	if t == nil {
+		return
+	}
+	b := x / t.spec.BurstSize
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.leave()
+	burstStart := t.start.Add(time.Duration(b) * t.spec.BurstInterval)
+	caller, drain := end.Sub(burstStart.Add(t.spec.withinBurst(x))), end.Sub(burstStart)
+	for len(t.tails) <= b {
+		t.tails = append(t.tails, 0)
+		t.drains = append(t.drains, 0)
+	}
+	if caller > t.tails[b] {
+		t.tails[b] = caller
+	}
+	if drain > t.drains[b] {
+		t.drains[b] = drain
+	}
+	t.completedBursts[b] = true
+}
+
+func (t *burstTracker) stats() *BurstStats {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var tails, drains []float64
+	overlapped := 0
+	for b := range t.tails {
+		if !t.completedBursts[b] {
+			continue
+		}
+		tails = append(tails, float64(t.tails[b])/float64(time.Millisecond))
+		drains = append(drains, float64(t.drains[b])/float64(time.Millisecond))
+		if t.drains[b] > t.spec.BurstInterval {
+			overlapped++
+		}
+	}
+	s := &BurstStats{Bursts: len(tails), MaxQueue: t.most, Overlapped: overlapped}
+	if len(tails) == 0 {
+		return s
+	}
+	sort.Float64s(tails)
+	s.TailP50Ms, s.TailMaxMs = tails[len(tails)/2], tails[len(tails)-1]
+	for _, d := range drains {
+		s.DrainMeanMs += d / float64(len(drains))
+		s.DrainMaxMs = math.Max(s.DrainMaxMs, d)
+	}
+	return s
+}
+
+func outputBursts(result BenchmarkResult) {
+	b := result.Bursts
+	if b == nil || b.Bursts == 0 {
+		return
+	}
+	queued := "without queueing"
+	if b.MaxQueue > 0 {
+		queued = fmt.Sprintf("with at most %s requests queued", formatCount(b.MaxQueue))
+	}
+	fmt.Printf("\tBursts: %s absorbed %s; tail p50 %s, max %s; drained in %s on average, %s at worst",
+		formatCount(int64(b.Bursts)), queued, formatMs(b.TailP50Ms), formatMs(b.TailMaxMs), formatMs(b.DrainMeanMs),
+		formatMs(b.DrainMaxMs))
+	if b.Overlapped > 0 {
+		fmt.Printf("; %s still draining when the next began", formatCount(int64(b.Overlapped)))
+	}
+	fmt.Println()
+}
+
+// plotBurstTail draws, for burst loads, the median burst's tail latency and the most requests queued at once against
+// the co-routine count, a line for each executor and other swept setting: how much concurrency it takes to absorb a
+// burst rather than queue it, and how the strategies differ on the way there.
+func plotBurstTail(results []BenchmarkResult, opts PlotOptions) error {
+	if results[0].Bursts == nil {
+		return nil
+	}
+	labels, series := bySeries(results)
+	for _, panel := range []struct {
+		title, label, file string
+		latency            bool
+		y                  func(BenchmarkResult) float64
+	}{
+		{"Burst Tail Latency vs. Number of Co-Routines", latencyLabel("Median burst's slowest response, queue wait included"), "burst_tail_vs_coroutines.png", true,
+			func(r BenchmarkResult) float64 {
+				if r.Bursts == nil || r.Bursts.Bursts == 0 {
+					return math.NaN()
+				}
+				return r.Bursts.TailP50Ms
+			}},
+		{"Burst Queue Depth vs. Number of Co-Routines", "Most requests queued at once", "burst_queue_vs_coroutines.png", false,
+			func(r BenchmarkResult) float64 {
+				if r.Bursts == nil {
+					return math.NaN()
+				}
+				return float64(r.Bursts.MaxQueue)
+			}},
+	} {
+		plt := plot.New()
+		plt.Title.Text = panel.title
+		plt.X.Label.Text = "Number of Co-Routines"
+		plt.Y.Label.Text = panel.label
+		if panel.latency {
+			latencyTicks(&plt.Y)
+		}
+		for i, label := range labels {
+			line, err := sweepLine(plt, series[label], panel.y, plotutil.Color(i))
+			if err != nil {
+				return err
+			}
+			if line != nil && len(labels) > 1 {
+				plt.Legend.Add(label, line)
+			}
+		}
+		plt.Y.Min = 0
+		plt.Legend.Top = true
+		opts.fitCoroutines(&plt.X)
+		if err := opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile(panel.file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
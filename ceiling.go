@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/metrics"
+	"time"
+)
+
+// ResourceCeilings bound what a single run may consume, so that sweeps towards unbounded concurrency end in a
+// recorded outcome rather than the OOM killer. Zero leaves a resource unbounded.
+type ResourceCeilings struct {
+	MaxMemory uint64 // bytes mapped by the Go runtime and not released to the OS
+	MaxFDs    int
+}
+
+// CeilingExceeded is the Err of a run aborted by its resource ceilings.
+type CeilingExceeded struct {
+	Resource string
+	Limit    uint64
+	Observed uint64
+}
+
+func (e *CeilingExceeded) Error() string {
+	return fmt.Sprintf("%s ceiling exceeded: %d > %d", e.Resource, e.Observed, e.Limit)
+}
+
+var memoryMetrics = []metrics.Sample{
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/memory/classes/heap/released:bytes"},
+}
+
+// memoryInUse reads the process's Go memory without stopping the world, as runtime.ReadMemStats would.
+func memoryInUse() uint64 {
+	samples := append([]metrics.Sample(nil), memoryMetrics...)
+	metrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// openFDs counts the process's open file descriptors, or returns -1 where /proc/self/fd is not available.
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries) - 1 // the descriptor ReadDir itself held open
+}
+
+// ceilingMonitor samples resource usage during a run and calls abort the first time a ceiling is exceeded.
+type ceilingMonitor struct {
+	stop     chan struct{}
+	done     chan struct{}
+	exceeded *CeilingExceeded
+}
+
+// startCeilingMonitor returns nil when no ceiling is set; Stop is safe to call on nil.
+func startCeilingMonitor(c ResourceCeilings, interval time.Duration, abort func()) *ceilingMonitor {
+	if c.MaxMemory == 0 && c.MaxFDs == 0 {
+		return nil
+	}
+	m := &ceilingMonitor{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if c.MaxMemory > 0 {
+				if used := memoryInUse(); used > c.MaxMemory {
+					m.exceeded = &CeilingExceeded{"memory", c.MaxMemory, used}
+				}
+			}
+			if c.MaxFDs > 0 {
+				if fds := openFDs(); fds > c.MaxFDs {
+					m.exceeded = &CeilingExceeded{"file descriptor", uint64(c.MaxFDs), uint64(fds)}
+				}
+			}
+			if m.exceeded != nil {
+				abort()
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// Stop ends monitoring and returns the ceiling that was exceeded, if any.
+func (m *ceilingMonitor) Stop() error {
+	if m == nil {
+		return nil
+	}
+	close(m.stop)
+	<-m.done
+	if m.exceeded == nil {
+		return nil
+	}
+	return m.exceeded
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgroupCPULimit returns the number of CPUs the process's cgroup quota allows, checking cgroup v2 and then v1. ok is
+// false when there is no quota or no cgroup filesystem to read it from.
+func cgroupCPULimit() (cpus float64, ok bool) {
+	paths := cgroupPaths()
+	for _, dir := range []string{filepath.Join("/sys/fs/cgroup", paths[""]), "/sys/fs/cgroup"} {
+		if s, found := readSysfsString(filepath.Join(dir, "cpu.max")); found {
+			fields := strings.Fields(s)
+			if len(fields) != 2 || fields[0] == "max" {
+				return 0, false
+			}
+			quota, err1 := strconv.ParseFloat(fields[0], 64)
+			period, err2 := strconv.ParseFloat(fields[1], 64)
+			if err1 != nil || err2 != nil || period <= 0 {
+				return 0, false
+			}
+			return quota / period, true
+		}
+	}
+	for _, dir := range []string{filepath.Join("/sys/fs/cgroup/cpu", paths["cpu"]), "/sys/fs/cgroup/cpu"} {
+		quota, found := readSysfsInt(filepath.Join(dir, "cpu.cfs_quota_us"))
+		if !found {
+			continue
+		}
+		period, _ := readSysfsInt(filepath.Join(dir, "cpu.cfs_period_us"))
+		if quota <= 0 || period <= 0 {
+			return 0, false
+		}
+		return float64(quota) / float64(period), true
+	}
+	return 0, false
+}
+
+// cgroupPaths maps each cgroup v1 controller, and "" for the v2 unified hierarchy, to this process's cgroup.
+func cgroupPaths() map[string]string {
+	paths := map[string]string{}
+	b, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return paths
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths
+}
+
+// effectiveCPUs is how many CPUs a run can actually use: GOMAXPROCS, capped by the CPUs present and any cgroup quota.
+func effectiveCPUs(gomaxprocs int) float64 {
+	cpus := float64(gomaxprocs)
+	if n := float64(runtime.NumCPU()); n < cpus {
+		cpus = n
+	}
+	if n := float64(len(pinnedCPUs)); n > 0 && n < cpus {
+		cpus = n
+	}
+	if limit, ok := cgroupCPULimit(); ok && limit < cpus {
+		cpus = limit
+	}
+	return cpus
+}
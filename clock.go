@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time for anything that paces or waits, so it can be swapped for a virtualClock that only
+// moves when told to. The workload, the load generators and everything a run measures read it, so that the
+// statistics and reports built from a run under a virtualClock come out the same every time; only what guards the run
+// from outside it, the stall watchdog, the ceiling and hardware monitors and the harness's own overhead, stays on the
+// wall clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// virtualClock is a deterministic Clock for exercising load generators and execution strategies without real
+// sleeping. Time stands still until Advance or AdvanceToNext is called; BlockUntil lets the driver wait until the
+// goroutines under test have all gone to sleep before moving time forward.
+type virtualClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []virtualWaiter
+}
+
+type virtualWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newVirtualClock(start time.Time) *virtualClock {
+	c := &virtualClock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *virtualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, virtualWaiter{at: c.now.Add(d), c: ch})
+	c.cond.Broadcast()
+	return ch
+}
+
+func (c *virtualClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Advance moves time forward by d, waking every sleeper whose deadline has been reached in deadline order.
+func (c *virtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.advanceTo(c.now.Add(d))
+}
+
+// AdvanceToNext jumps to the earliest pending deadline and reports whether there was one.
+func (c *virtualClock) AdvanceToNext() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.waiters) == 0 {
+		return false
+	}
+	next := c.waiters[0].at
+	for _, w := range c.waiters[1:] {
+		if w.at.Before(next) {
+			next = w.at
+		}
+	}
+	c.advanceTo(next)
+	return true
+}
+
+func (c *virtualClock) advanceTo(t time.Time) {
+	if t.After(c.now) {
+		c.now = t
+	}
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].at.Before(c.waiters[j].at) })
+	fired := 0
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			break
+		}
+		w.c <- w.at
+		fired++
+	}
+	c.waiters = c.waiters[fired:]
+}
+
+// BlockUntil waits until at least n goroutines are sleeping on the clock.
+func (c *virtualClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// scaledClock runs factor times faster than the wall clock: sleeps are shortened by the factor and Now reports
+// elapsed time scaled back up, so durations measured against it come out in uncompressed terms.
+type scaledClock struct {
+	factor float64
+	origin time.Time
+}
+
+func newScaledClock(factor float64) *scaledClock {
+	return &scaledClock{factor: factor, origin: time.Now()}
+}
+
+func (c *scaledClock) Now() time.Time {
+	return c.origin.Add(time.Duration(float64(time.Since(c.origin)) * c.factor))
+}
+
+func (c *scaledClock) After(d time.Duration) <-chan time.Time {
+	return time.After(time.Duration(float64(d) / c.factor))
+}
+
+func (c *scaledClock) Sleep(d time.Duration) {
+	time.Sleep(time.Duration(float64(d) / c.factor))
+}
+
+// spin does the work of kernel that takes d as measured by clock on an idle CPU. A virtual clock would never move
+// while we spin, so the time is slept instead.
+func spin(clock Clock, kernel *cpuKernel, d time.Duration) {
+	switch c := clock.(type) {
+	case *virtualClock:
+		clock.Sleep(d)
+	case *scaledClock:
+		kernel.work(time.Duration(float64(d) / c.factor))
+	default:
+		kernel.work(d)
+	}
+}
+
+// withClockTimeout is context.WithTimeout driven by clock instead of the runtime timer.
+func withClockTimeout(ctx context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(realClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+	inner, cancel := context.WithCancel(ctx)
+	c := &clockTimeoutContext{Context: inner, deadline: clock.Now().Add(d)}
+	timer := clock.After(d)
+	go func() {
+		select {
+		case <-timer:
+			atomic.StoreInt32(&c.expired, 1)
+			cancel()
+		case <-inner.Done():
+		}
+	}()
+	return c, cancel
+}
+
+type clockTimeoutContext struct {
+	context.Context
+	deadline time.Time
+	expired  int32
+}
+
+func (c *clockTimeoutContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *clockTimeoutContext) Err() error {
+	if atomic.LoadInt32(&c.expired) == 1 {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
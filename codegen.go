@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// codegenSpec is the run a generated program reproduces.
+type codegenSpec struct {
+	Executor    string
+	Coroutines  int64
+	Requests    int
+	GOMAXPROCS  int
+	WorkTime    time.Duration
+	NetworkTime time.Duration
+	Splits      int
+	Load        string // closed, constant or poisson
+	Rate        float64
+	Seed        int64
+	Measured    string // what the run measured, when it came from a results file
+}
+
+// codegenCommand writes a single-file Go program that reproduces one run of the sweep with nothing but the standard
+// library, or golang.org/x/sync for the errgroup executor, so that a finding can be attached to a bug report or a post
+// as something anyone can run. The program issues the same requests as the sweep's default workload, sleeping for
+// network time and spinning for CPU time, with the chosen execution strategy; the harness's own machinery, such as
+// middleware, collectors and reporters, is left out.
+func codegenCommand(args []string) error {
+	fs := flag.NewFlagSet("codegen", flag.ExitOnError)
+	from := fs.String("from", "", "results file to take the run's parameters from; flags given explicitly override them")
+	run := fs.Int("run", 1, "which run of -from to reproduce, counting lines from 1")
+	out := fs.String("o", "", "file to write the program to (default: standard output)")
+	spec := codegenSpec{}
+	fs.StringVar(&spec.Executor, "executor", "semaphore", "execution strategy: "+strings.Join(executorNames, ", "))
+	fs.Int64Var(&spec.Coroutines, "coroutines", 8, "number of concurrent requests")
+	fs.IntVar(&spec.Requests, "requests", 100, "number of requests")
+	fs.IntVar(&spec.GOMAXPROCS, "gomaxprocs", 0, "GOMAXPROCS for the program (default: leave unchanged)")
+	fs.DurationVar(&spec.WorkTime, "work-time", 5*time.Millisecond, "CPU time per request")
+	fs.DurationVar(&spec.NetworkTime, "network-time", 55*time.Millisecond, "network time per request")
+	fs.IntVar(&spec.Splits, "splits", 5, "number of network calls the network time is split over")
+	fs.StringVar(&spec.Load, "load", "closed", "arrival process: closed, constant or poisson")
+	fs.Float64Var(&spec.Rate, "rate", 100, "requests per second for constant and poisson load")
+	fs.Int64Var(&spec.Seed, "seed", 1, "seed for poisson arrivals")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s codegen [-from results.jsonl [-run n]] [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *from != "" {
+		summaries, err := loadResults(*from)
+		if err != nil {
+			return err
+		}
+		if *run < 1 || *run > len(summaries) {
+			return fmt.Errorf("%s has %d runs, no run %d", *from, len(summaries), *run)
+		}
+		explicit := spec
+		spec = codegenFromSummary(summaries[*run-1])
+		if err := spec.parseLoad(summaries[*run-1].Load); err != nil {
+			return fmt.Errorf("%s run %d: %w", *from, *run, err)
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "executor":
+				spec.Executor = explicit.Executor
+			case "coroutines":
+				spec.Coroutines = explicit.Coroutines
+			case "requests":
+				spec.Requests = explicit.Requests
+			case "gomaxprocs":
+				spec.GOMAXPROCS = explicit.GOMAXPROCS
+			case "work-time":
+				spec.WorkTime = explicit.WorkTime
+			case "network-time":
+				spec.NetworkTime = explicit.NetworkTime
+			case "splits":
+				spec.Splits = explicit.Splits
+			case "load":
+				spec.Load = explicit.Load
+			case "rate":
+				spec.Rate = explicit.Rate
+			case "seed":
+				spec.Seed = explicit.Seed
+			}
+		})
+	}
+	if err := spec.validate(); err != nil {
+		return err
+	}
+
+	src, err := spec.generate()
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(*out, src, 0o644)
+}
+
+func codegenFromSummary(s jsonRunSummary) codegenSpec {
+	spec := codegenSpec{
+		Executor:    s.Executor,
+		Coroutines:  s.NumCoroutines,
+		Requests:    s.Iterations,
+		GOMAXPROCS:  s.GOMAXPROCS,
+		WorkTime:    time.Duration(s.WorkTimeMs * float64(time.Millisecond)),
+		NetworkTime: time.Duration(s.NetworkTimeMs * float64(time.Millisecond)),
+		Splits:      5,
+		Seed:        s.Seed,
+		Measured:    fmt.Sprintf("%.2f rps", s.ThroughputRps),
+	}
+	if p99, ok := s.LatencyMs["p99"]; ok {
+		spec.Measured += fmt.Sprintf(", p99 %.2fms", p99)
+	}
+	if s.Started.IsZero() {
+		spec.Measured += " in the original run"
+	} else {
+		spec.Measured += " in the run of " + s.Started.Format(time.RFC3339)
+	}
+	return spec
+}
+
+// parseLoad reads back the load a results file recorded, which is LoadSpec's String.
+func (spec *codegenSpec) parseLoad(load string) error {
+	if load == "closed loop" || load == "" {
+		spec.Load = "closed"
+		return nil
+	}
+	if _, err := fmt.Sscanf(load, "%s %f rps", &spec.Load, &spec.Rate); err != nil {
+		return fmt.Errorf("cannot reproduce %s load", load)
+	}
+	return nil
+}
+
+func (spec codegenSpec) validate() error {
+	known := false
+	for _, name := range executorNames {
+		known = known || name == spec.Executor
+	}
+	if !known {
+		return fmt.Errorf("unknown executor %q", spec.Executor)
+	}
+	if spec.Executor == "aimd" || spec.Executor == "gradient" {
+		return fmt.Errorf("codegen reproduces executors with a static limit, not the adaptive %s", spec.Executor)
+	}
+	switch spec.Load {
+	case "closed":
+	case "constant", "poisson":
+		if spec.Rate <= 0 {
+			return fmt.Errorf("%s load needs a positive rate, got %v", spec.Load, spec.Rate)
+		}
+	default:
+		return fmt.Errorf("codegen reproduces closed, constant and poisson load, not %q", spec.Load)
+	}
+	if spec.Coroutines <= 0 && spec.Executor != "unbounded" {
+		return fmt.Errorf("%s executor needs a positive number of co-routines, got %d", spec.Executor, spec.Coroutines)
+	}
+	if spec.Requests <= 0 || spec.Splits <= 0 {
+		return errors.New("requests and splits must be positive")
+	}
+	return nil
+}
+
+func (spec codegenSpec) generate() ([]byte, error) {
+	var b bytes.Buffer
+	if err := codegenTemplate.Execute(&b, spec); err != nil {
+		return nil, err
+	}
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("generated program does not parse: %w", err)
+	}
+	return src, nil
+}
+
+var codegenTemplate = template.Must(template.New("codegen").Parse(`// Command repro reproduces one run of a concurrency sweep: {{.Requests}} requests of {{.WorkTime}} CPU and
+// {{.NetworkTime}} network time, split over {{.Splits}} calls, with {{.Coroutines}} co-routines and the {{.Executor}} executor
+// under {{if eq .Load "closed"}}closed-loop{{else}}{{.Load}} {{.Rate}} rps{{end}} load.
+{{- if .Measured}}
+//
+// Measured {{.Measured}}.
+{{- end}}
+//
+// Network calls are simulated with sleeps and CPU work with a busy loop, so results depend on the machine and its load.
+{{- if eq .Executor "errgroup"}}
+// It needs golang.org/x/sync: go mod init repro && go get golang.org/x/sync/errgroup && go run .
+{{- else}}
+// Run it with go run on this file.
+{{- end}}
+package main
+
+import (
+	"fmt"
+{{- if eq .Load "poisson"}}
+	"math/rand"
+{{- end}}
+	"runtime"
+	"sort"
+{{- if ne .Executor "errgroup"}}
+	"sync"
+{{- end}}
+	"time"
+{{- if eq .Executor "errgroup"}}
+
+	"golang.org/x/sync/errgroup"
+{{- end}}
+)
+
+const (
+	coroutines  = {{.Coroutines}}
+	requests    = {{.Requests}}
+	gomaxprocs  = {{.GOMAXPROCS}} // 0 leaves GOMAXPROCS unchanged
+	workTime    = time.Duration({{printf "%d" .WorkTime}}) // {{.WorkTime}}
+	networkTime = time.Duration({{printf "%d" .NetworkTime}}) // {{.NetworkTime}}
+	splits      = {{.Splits}}
+{{- if ne .Load "closed"}}
+	rate        = {{.Rate}} // requests per second
+{{- end}}
+)
+
+// spin busies the CPU for d.
+func spin(d time.Duration) {
+	start := time.Now()
+	for time.Since(start) < d {
+	}
+}
+
+// request spends its CPU time in equal slices around each of its network calls.
+func request() {
+	spin(workTime / (splits + 1))
+	for i := 0; i < splits; i++ {
+		time.Sleep(networkTime / splits)
+		spin(workTime / (splits + 1))
+	}
+}
+
+func main() {
+	if gomaxprocs > 0 {
+		runtime.GOMAXPROCS(gomaxprocs)
+	}
+	latencies := make([]time.Duration, requests)
+	run := func(i int) {
+		begin := time.Now()
+		request()
+		latencies[i] = time.Since(begin)
+	}
+{{if eq .Load "poisson"}}
+	r := rand.New(rand.NewSource({{.Seed}}))
+{{- end}}
+{{- if eq .Executor "errgroup"}}
+	var g errgroup.Group
+	g.SetLimit(coroutines)
+{{- else}}
+	var wg sync.WaitGroup
+{{- end}}
+{{- if or (eq .Executor "semaphore") (eq .Executor "lockosthread")}}
+	sem := make(chan struct{}, coroutines)
+{{- end}}
+{{- if or (eq .Executor "pool") (eq .Executor "pinnedpool")}}
+	work := make(chan int)
+	for w := 0; w < coroutines; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+{{- if eq .Executor "pinnedpool"}}
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+{{- end}}
+			for i := range work {
+				run(i)
+			}
+		}()
+	}
+{{- end}}
+
+	start := time.Now()
+{{- if ne .Load "closed"}}
+	next := start
+{{- end}}
+	for i := 0; i < requests; i++ {
+{{- if eq .Load "constant"}}
+		time.Sleep(time.Until(next))
+		next = next.Add(time.Duration(float64(time.Second) / rate))
+{{- else if eq .Load "poisson"}}
+		time.Sleep(time.Until(next))
+		next = next.Add(time.Duration(r.ExpFloat64() * float64(time.Second) / rate))
+{{- end}}
+{{- if eq .Executor "errgroup"}}
+		i := i
+		g.Go(func() error {
+			run(i)
+			return nil
+		})
+{{- else if or (eq .Executor "pool") (eq .Executor "pinnedpool")}}
+		work <- i
+{{- else if eq .Executor "unbounded"}}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			run(i)
+		}(i)
+{{- else}}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+{{- if eq .Executor "lockosthread"}}
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+{{- end}}
+			run(i)
+		}(i)
+{{- end}}
+	}
+{{- if eq .Executor "errgroup"}}
+	g.Wait()
+{{- else}}
+{{- if or (eq .Executor "pool") (eq .Executor "pinnedpool")}}
+	close(work)
+{{- end}}
+	wg.Wait()
+{{- end}}
+	elapsed := time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	fmt.Printf("%d requests with %d co-routines, GOMAXPROCS=%d: %.2f rps\n", requests, coroutines, runtime.GOMAXPROCS(0),
+		float64(requests)/elapsed.Seconds())
+	for _, pct := range []int{50, 95, 99} {
+		fmt.Printf("\tp%d: %.2fms\n", pct, float64(latencies[(requests-1)*pct/100])/float64(time.Millisecond))
+	}
+}
+`))
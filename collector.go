@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/montanaflynn/stats"
+)
+
+// Collector aggregates response times (in milliseconds) for a run. Implementations trade memory for accuracy:
+// only the exact collector keeps every sample, so Values may return an approximation of the recorded data.
+// Merge folds in another collector of the same kind, so shards collected separately can be combined at the end.
+type Collector interface {
+	Add(ms float64)
+	Count() int
+	Percentile(pct float64) float64
+	Values() []float64
+	Merge(other Collector)
+}
+
+// LatencyStats summarizes a run's response times, in milliseconds, beyond its percentiles.
+type LatencyStats struct {
+	Min    float64
+	Mean   float64
+	StdDev float64 // sample standard deviation; zero for fewer than two values
+	Max    float64
+}
+
+// latencyStats computes the stats of everything c holds, which for the approximate collectors is an approximation too.
+func latencyStats(c Collector) LatencyStats {
+	values := c.Values()
+	if len(values) == 0 {
+		return LatencyStats{}
+	}
+	s := LatencyStats{Min: values[0], Max: values[0]}
+	var sum float64
+	for _, v := range values {
+		s.Min, s.Max = math.Min(s.Min, v), math.Max(s.Max, v)
+		sum += v
+	}
+	s.Mean = sum / float64(len(values))
+	if len(values) > 1 {
+		var squares float64
+		for _, v := range values {
+			squares += (v - s.Mean) * (v - s.Mean)
+		}
+		s.StdDev = math.Sqrt(squares / float64(len(values)-1))
+	}
+	return s
+}
+
+// defaultPercentiles are the response time percentiles reported unless -percentiles chooses others.
+var defaultPercentiles = []float64{50, 95, 99}
+
+// percentileName names a percentile as outputs label it and results files key it, such as p99 or p99.9.
+func percentileName(pct float64) string {
+	return "p" + strconv.FormatFloat(pct, 'f', -1, 64)
+}
+
+// parsePercentiles parses a comma-separated list of percentiles such as 50,99,99.9,100.
+func parsePercentiles(s string) ([]float64, error) {
+	var pcts []float64
+	for _, part := range strings.Split(s, ",") {
+		pct, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(part), "p"), 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return nil, fmt.Errorf("percentile %q is not a number above 0 and up to 100", part)
+		}
+		pcts = append(pcts, pct)
+	}
+	return pcts, nil
+}
+
+// newCollector returns the named collector. expected is the number of samples the run will record, used to size
+// buffers up front so that collecting never allocates mid-run.
+func newCollector(name string, reservoirSize int, expected int, r *rand.Rand) (Collector, error) {
+	switch name {
+	case "", "exact":
+		return &exactCollector{values: make([]float64, 0, expected)}, nil
+	case "hdr":
+		return newHdrCollector(), nil
+	case "tdigest":
+		return newTDigestCollector(100), nil
+	case "reservoir":
+		if reservoirSize <= 0 {
+			return nil, fmt.Errorf("reservoir size must be positive, got %d", reservoirSize)
+		}
+		return &reservoirCollector{size: reservoirSize, rand: r, sample: make([]float64, 0, reservoirSize)}, nil
+	}
+	return nil, fmt.Errorf("unknown collector %q", name)
+}
+
+type exactCollector struct {
+	values []float64
+}
+
+func (c *exactCollector) Add(ms float64) {
+	c.values = append(c.values, ms)
+}
+
+func (c *exactCollector) Count() int {
+	return len(c.values)
+}
+
+func (c *exactCollector) Percentile(pct float64) float64 {
+	val, _ := stats.Percentile(c.values, pct)
+	return val
+}
+
+func (c *exactCollector) Values() []float64 {
+	return c.values
+}
+
+func (c *exactCollector) Merge(other Collector) {
+	c.values = append(c.values, other.(*exactCollector).values...)
+}
+
+// hdrCollector is a log-linear histogram over microseconds: values below 2^(hdrSubBits+1) are counted exactly and
+// every larger power of two is split into 2^hdrSubBits equal buckets, bounding the relative error to under 1%.
+const hdrSubBits = 7
+
+type hdrCollector struct {
+	counts []uint64
+	count  int
+}
+
+func newHdrCollector() *hdrCollector {
+	return &hdrCollector{counts: make([]uint64, (64-hdrSubBits)<<hdrSubBits)}
+}
+
+func hdrIndex(us uint64) int {
+	if us < 1<<(hdrSubBits+1) {
+		return int(us)
+	}
+	shift := bits.Len64(us) - (hdrSubBits + 1)
+	mantissa := us >> uint(shift)
+	return (shift+1)<<hdrSubBits + int(mantissa) - 1<<hdrSubBits
+}
+
+func hdrValue(idx int) float64 {
+	if idx < 1<<(hdrSubBits+1) {
+		return float64(idx)
+	}
+	shift := uint(idx>>hdrSubBits - 1)
+	mantissa := uint64(idx&(1<<hdrSubBits-1) + 1<<hdrSubBits)
+	lower := mantissa << shift
+	upper := (mantissa + 1) << shift
+	return float64(lower+upper) / 2
+}
+
+func (c *hdrCollector) Add(ms float64) {
+	us := ms * 1000
+	if us < 0 {
+		us = 0
+	}
+	c.counts[hdrIndex(uint64(math.Round(us)))]++
+	c.count++
+}
+
+func (c *hdrCollector) Count() int {
+	return c.count
+}
+
+func (c *hdrCollector) Percentile(pct float64) float64 {
+	if c.count == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(pct / 100 * float64(c.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for idx, n := range c.counts {
+		seen += n
+		if seen >= rank {
+			return hdrValue(idx) / 1000
+		}
+	}
+	return 0
+}
+
+func (c *hdrCollector) Merge(other Collector) {
+	o := other.(*hdrCollector)
+	for idx, n := range o.counts {
+		c.counts[idx] += n
+	}
+	c.count += o.count
+}
+
+func (c *hdrCollector) Values() []float64 {
+	values := make([]float64, 0, c.count)
+	for idx, n := range c.counts {
+		for i := uint64(0); i < n; i++ {
+			values = append(values, hdrValue(idx)/1000)
+		}
+	}
+	return values
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// tDigestCollector is a merging t-digest using the k1 (arcsine) scale function, which keeps centroids small near
+// the tails where percentile accuracy matters most.
+type tDigestCollector struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	count       int
+}
+
+func newTDigestCollector(compression float64) *tDigestCollector {
+	return &tDigestCollector{compression: compression}
+}
+
+func (c *tDigestCollector) Add(ms float64) {
+	c.buffer = append(c.buffer, centroid{mean: ms, weight: 1})
+	c.count++
+	if len(c.buffer) >= int(5*c.compression) {
+		c.merge()
+	}
+}
+
+func (c *tDigestCollector) k(q float64) float64 {
+	return c.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func (c *tDigestCollector) merge() {
+	if len(c.buffer) == 0 {
+		return
+	}
+	all := append(c.centroids, c.buffer...)
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+	total := float64(c.count)
+
+	merged := []centroid{all[0]}
+	weightSoFar := 0.0
+	kLeft := c.k(0)
+	for _, next := range all[1:] {
+		cur := &merged[len(merged)-1]
+		q := (weightSoFar + cur.weight + next.weight) / total
+		if c.k(q)-kLeft <= 1 {
+			cur.mean += (next.mean - cur.mean) * next.weight / (cur.weight + next.weight)
+			cur.weight += next.weight
+			continue
+		}
+		weightSoFar += cur.weight
+		kLeft = c.k(weightSoFar / total)
+		merged = append(merged, next)
+	}
+	c.centroids = merged
+	c.buffer = c.buffer[:0]
+}
+
+func (c *tDigestCollector) Count() int {
+	return c.count
+}
+
+func (c *tDigestCollector) Percentile(pct float64) float64 {
+	c.merge()
+	if len(c.centroids) == 0 {
+		return 0
+	}
+	if len(c.centroids) == 1 {
+		return c.centroids[0].mean
+	}
+	target := pct / 100 * float64(c.count)
+	cumulative := 0.0
+	for i, cen := range c.centroids {
+		center := cumulative + cen.weight/2
+		if target < center {
+			if i == 0 {
+				return cen.mean
+			}
+			prev := c.centroids[i-1]
+			prevCenter := cumulative - prev.weight/2
+			return prev.mean + (cen.mean-prev.mean)*(target-prevCenter)/(center-prevCenter)
+		}
+		cumulative += cen.weight
+	}
+	return c.centroids[len(c.centroids)-1].mean
+}
+
+func (c *tDigestCollector) Merge(other Collector) {
+	o := other.(*tDigestCollector)
+	o.merge()
+	c.buffer = append(c.buffer, o.centroids...)
+	c.count += o.count
+	c.merge()
+}
+
+func (c *tDigestCollector) Values() []float64 {
+	c.merge()
+	var values []float64
+	for _, cen := range c.centroids {
+		for i := 0; i < int(math.Round(cen.weight)); i++ {
+			values = append(values, cen.mean)
+		}
+	}
+	return values
+}
+
+// reservoirCollector keeps a uniform random sample of at most size values (Vitter's algorithm R).
+type reservoirCollector struct {
+	size   int
+	rand   *rand.Rand
+	sample []float64
+	count  int
+}
+
+func (c *reservoirCollector) Add(ms float64) {
+	c.count++
+	if len(c.sample) < c.size {
+		c.sample = append(c.sample, ms)
+		return
+	}
+	if i := c.rand.Intn(c.count); i < c.size {
+		c.sample[i] = ms
+	}
+}
+
+func (c *reservoirCollector) Count() int {
+	return c.count
+}
+
+func (c *reservoirCollector) Percentile(pct float64) float64 {
+	val, _ := stats.Percentile(c.sample, pct)
+	return val
+}
+
+func (c *reservoirCollector) Values() []float64 {
+	return c.sample
+}
+
+// Merge draws a combined sample in which each side's values appear in proportion to the number of values it saw.
+func (c *reservoirCollector) Merge(other Collector) {
+	o := other.(*reservoirCollector)
+	if o.count == 0 {
+		return
+	}
+	total := c.count + o.count
+	mine := append([]float64(nil), c.sample...)
+	theirs := append([]float64(nil), o.sample...)
+	c.rand.Shuffle(len(mine), func(i, j int) { mine[i], mine[j] = mine[j], mine[i] })
+	c.rand.Shuffle(len(theirs), func(i, j int) { theirs[i], theirs[j] = theirs[j], theirs[i] })
+	c.sample = c.sample[:0]
+	for len(c.sample) < c.size && (len(mine) > 0 || len(theirs) > 0) {
+		if len(theirs) == 0 || (len(mine) > 0 && c.rand.Intn(total) < c.count) {
+			c.sample = append(c.sample, mine[0])
+			mine = mine[1:]
+		} else {
+			c.sample = append(c.sample, theirs[0])
+			theirs = theirs[1:]
+		}
+	}
+	c.count = total
+}
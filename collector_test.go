@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+// TestLatencyStats measures requests of known lengths on a virtualClock, so that the response times collected are
+// exactly those lengths, and checks the stats of them.
+func TestLatencyStats(t *testing.T) {
+	clock := newVirtualClock(time.Unix(0, 0))
+	c, _ := newCollector("exact", 0, 4, nil)
+	for _, d := range []time.Duration{2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond, 6 * time.Millisecond} {
+		start := clock.Now()
+		clock.Advance(d)
+		c.Add(float64(clock.Now().Sub(start)) / float64(time.Millisecond))
+	}
+	s := latencyStats(c)
+	want := LatencyStats{Min: 2, Mean: 4, StdDev: math.Sqrt(8.0 / 3), Max: 6}
+	if s.Min != want.Min || s.Mean != want.Mean || math.Abs(s.StdDev-want.StdDev) > 1e-12 || s.Max != want.Max {
+		t.Errorf("latencyStats = %+v, want %+v", s, want)
+	}
+
+	single, _ := newCollector("exact", 0, 1, nil)
+	single.Add(3)
+	if s := latencyStats(single); s.StdDev != 0 || s.Min != 3 || s.Max != 3 {
+		t.Errorf("latencyStats of one value = %+v, want min and max 3 and no stddev", s)
+	}
+	empty, _ := newCollector("exact", 0, 0, nil)
+	if s := latencyStats(empty); s != (LatencyStats{}) {
+		t.Errorf("latencyStats of nothing = %+v, want zero", s)
+	}
+}
+
+func TestLatencySummary(t *testing.T) {
+	c, _ := newCollector("exact", 0, 100, nil)
+	for i := 1; i <= 100; i++ {
+		c.Add(float64(i))
+	}
+	summary := latencySummary(c, []float64{50, 99.9, 100})
+	for _, key := range []string{"p50", "p99.9", "p100", "min", "mean", "stddev"} {
+		if _, ok := summary[key]; !ok {
+			t.Errorf("summary has no %s: %v", key, summary)
+		}
+	}
+	if summary["min"] != 1 || summary["mean"] != 50.5 || summary["p100"] != 100 {
+		t.Errorf("summary min %v, mean %v, p100 %v, want 1, 50.5 and 100", summary["min"], summary["mean"], summary["p100"])
+	}
+	empty, _ := newCollector("exact", 0, 0, nil)
+	if summary := latencySummary(empty, defaultPercentiles); len(summary) != 0 {
+		t.Errorf("summary of nothing = %v, want empty", summary)
+	}
+}
+
+// TestCollectorPercentiles fills every collector with the same 10,000 response times, uniform from 1 to 100ms, and
+// checks their percentiles against the exact ones, to within the error each collector promises: of the value for the
+// exact and hdr collectors, and of the rank for the t-digest and the sample, whose errors are in the quantile.
+func TestCollectorPercentiles(t *testing.T) {
+	const n = 10000
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = 1 + 99*float64(i)/float64(n-1)
+	}
+	shuffled := append([]float64(nil), values...)
+	rand.New(rand.NewSource(1)).Shuffle(n, func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	for _, tc := range []struct {
+		name     string
+		valueTol float64 // relative to the exact percentile
+		rankTol  float64 // of the fraction of values up to the percentile, for collectors with no valueTol
+	}{
+		{"exact", 1e-9, 0},
+		{"hdr", 0.01, 0},
+		{"tdigest", 0, 0.005},
+		{"reservoir", 0, 0.02},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := newCollector(tc.name, 2000, n, rand.New(rand.NewSource(1)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range shuffled {
+				c.Add(v)
+			}
+			if c.Count() != n {
+				t.Errorf("Count() = %d, want %d", c.Count(), n)
+			}
+			for _, pct := range []float64{1, 50, 90, 99} {
+				want, got := values[int(math.Ceil(pct/100*n))-1], c.Percentile(pct)
+				if tc.valueTol > 0 && math.Abs(got-want) > tc.valueTol*want {
+					t.Errorf("%s = %v, want %v to within %v%%", percentileName(pct), got, want, tc.valueTol*100)
+				}
+				rank := float64(sort.SearchFloat64s(values, got)) / n
+				if tc.valueTol == 0 && math.Abs(rank-pct/100) > tc.rankTol {
+					t.Errorf("%s = %v, ranked %v, want %v to within %v", percentileName(pct), got, rank, pct/100, tc.rankTol)
+				}
+			}
+		})
+	}
+}
+
+// TestCollectorMerge checks that merging two collectors counts everything both held.
+func TestCollectorMerge(t *testing.T) {
+	for _, name := range []string{"exact", "hdr", "tdigest", "reservoir"} {
+		a, _ := newCollector(name, 100, 0, rand.New(rand.NewSource(1)))
+		b, _ := newCollector(name, 100, 0, rand.New(rand.NewSource(2)))
+		for i := 1; i <= 50; i++ {
+			a.Add(float64(i))
+			b.Add(float64(50 + i))
+		}
+		a.Merge(b)
+		if a.Count() != 100 {
+			t.Errorf("%s: merged Count() = %d, want 100", name, a.Count())
+		}
+		if got := a.Percentile(100); math.Abs(got-100) > 1 {
+			t.Errorf("%s: merged p100 = %v, want 100", name, got)
+		}
+	}
+}
+
+func TestParsePercentiles(t *testing.T) {
+	pcts, err := parsePercentiles("50, p99,99.9,100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pcts) != 4 || pcts[1] != 99 || percentileName(pcts[2]) != "p99.9" {
+		t.Errorf("parsePercentiles = %v", pcts)
+	}
+	for _, bad := range []string{"0", "101", "x", ""} {
+		if _, err := parsePercentiles(bad); err == nil {
+			t.Errorf("parsePercentiles(%q) succeeded", bad)
+		}
+	}
+}
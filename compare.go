@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// compareSide is what one results file measured for a configuration, one value per run.
+type compareSide struct {
+	rps []float64
+	p99 []float64
+}
+
+// compareCommand sets the runs of a results file against a baseline, configuration by configuration, and fails when
+// throughput fell or p99 grew by more than the allowed fraction. When both files repeated a configuration, with
+// -repeat, a change only counts if Welch's t-test finds it significant; a single run on either side leaves nothing to
+// test, so any change beyond the threshold counts.
+func compareCommand(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	maxDrop := fs.Float64("max-throughput-drop", 0.05, "largest acceptable fall in throughput, as a fraction of the baseline")
+	maxGrowth := fs.Float64("max-p99-increase", 0.10, "largest acceptable growth in p99, as a fraction of the baseline")
+	alpha := fs.Float64("alpha", 0.05, "significance level a change of repeated runs must reach to count")
+	applyUnits := unitFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s compare [flags] baseline.jsonl current.jsonl\n\n"+
+			"Either file may instead be a sweep saved with -save. Runs are matched on executor, GOMAXPROCS, load, CPU and network time and co-routine count; tags and notes are ignored.\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := applyUnits(); err != nil {
+		return err
+	}
+
+	baseline, _, err := loadCompareSides(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	current, labels, err := loadCompareSides(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	regressions, compared := 0, 0
+	for _, label := range labels {
+		base, ok := baseline[label]
+		if !ok {
+			fmt.Printf("%s: not in the baseline\n", label)
+			continue
+		}
+		cur := current[label]
+		compared++
+		fmt.Println(label + ":")
+		rpsDelta, rpsP := compareValues(base.rps, cur.rps)
+		p99Delta, p99P := compareValues(base.p99, cur.p99)
+		rpsRegressed := rpsDelta < -*maxDrop && (math.IsNaN(rpsP) || rpsP < *alpha)
+		p99Regressed := p99Delta > *maxGrowth && (math.IsNaN(p99P) || p99P < *alpha)
+		fmt.Printf("\tthroughput %s -> %s (%s%s)%s\n", formatRps(mean(base.rps)), formatRps(mean(cur.rps)),
+			formatChange(rpsDelta), significance(rpsP), verdict(rpsRegressed))
+		if len(base.p99) > 0 && len(cur.p99) > 0 {
+			fmt.Printf("\tp99 %s -> %s (%s%s)%s\n", formatMs(mean(base.p99)), formatMs(mean(cur.p99)),
+				formatChange(p99Delta), significance(p99P), verdict(p99Regressed))
+		}
+		if rpsRegressed || p99Regressed {
+			regressions++
+		}
+	}
+	if compared == 0 {
+		return fmt.Errorf("no configuration of %s is in %s", fs.Arg(1), fs.Arg(0))
+	}
+	if regressions > 0 {
+		return fmt.Errorf("%d of %d configurations regressed", regressions, compared)
+	}
+	fmt.Printf("No regressions in %d configurations.\n", compared)
+	return nil
+}
+
+// loadCompareSides groups the successful runs of a results file by configuration, ordered by co-routine count within
+// each configuration.
+func loadCompareSides(path string) (map[string]*compareSide, []string, error) {
+	summaries, err := loadResults(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	sides := map[string]*compareSide{}
+	var labels []string
+	firsts := map[string]jsonRunSummary{}
+	for _, s := range summaries {
+		if s.Failure != nil {
+			continue // a failed run measured nothing to compare
+		}
+		label := fmt.Sprintf("%s, %d co-routines", configLabel(s), s.NumCoroutines)
+		side, ok := sides[label]
+		if !ok {
+			side = &compareSide{}
+			sides[label] = side
+			firsts[label] = s
+			labels = append(labels, label)
+		}
+		side.rps = append(side.rps, s.ThroughputRps)
+		if p99, ok := s.LatencyMs["p99"]; ok {
+			side.p99 = append(side.p99, p99)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := firsts[labels[i]], firsts[labels[j]]
+		if ca, cb := configLabel(a), configLabel(b); ca != cb {
+			return ca < cb
+		}
+		return a.NumCoroutines < b.NumCoroutines
+	})
+	return sides, labels, nil
+}
+
+// compareValues returns the relative change in mean from base to cur and the two-sided p-value of Welch's t-test,
+// or NaN when either side has fewer than two values.
+func compareValues(base, cur []float64) (delta, p float64) {
+	if len(base) == 0 || len(cur) == 0 {
+		return 0, math.NaN()
+	}
+	delta = mean(cur)/mean(base) - 1
+	return delta, welchTTest(base, cur)
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func sampleVariance(values []float64) float64 {
+	m := mean(values)
+	var sum float64
+	for _, v := range values {
+		sum += (v - m) * (v - m)
+	}
+	return sum / float64(len(values)-1)
+}
+
+// welchTTest is the two-sided p-value for the means of a and b differing, without assuming equal variances.
+func welchTTest(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return math.NaN()
+	}
+	na, nb := float64(len(a)), float64(len(b))
+	va, vb := sampleVariance(a)/na, sampleVariance(b)/nb
+	if va+vb == 0 {
+		if mean(a) == mean(b) {
+			return 1
+		}
+		return 0
+	}
+	t := (mean(b) - mean(a)) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/(na-1) + vb*vb/(nb-1))
+	// The two-sided tail of Student's t with df degrees of freedom.
+	return regularizedIncompleteBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// regularizedIncompleteBeta is I_x(a, b), evaluated by its continued fraction (Numerical Recipes, 6.4).
+func regularizedIncompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	if x > (a+1)/(a+b+2) {
+		return 1 - regularizedIncompleteBeta(b, a, 1-x)
+	}
+	const tiny = 1e-30
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	f := d
+	for m := 1; m <= 200; m++ {
+		fm := float64(m)
+		for _, num := range []float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			f *= c * d
+		}
+		if math.Abs(c*d-1) < 1e-12 {
+			break
+		}
+	}
+	return front * f / a
+}
+
+func significance(p float64) string {
+	if math.IsNaN(p) {
+		return ", single runs"
+	}
+	return ", p=" + formatFloat(p, 3)
+}
+
+func verdict(regressed bool) string {
+	if regressed {
+		return " REGRESSED"
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRegularizedIncompleteBeta(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, x, want float64
+	}{
+		{2, 1, 0.3, 0.09},                              // I_x(a, 1) = x^a
+		{1, 3, 0.2, 1 - math.Pow(0.8, 3)},              // I_x(1, b) = 1 - (1-x)^b
+		{4.5, 4.5, 0.5, 0.5},                           // symmetric about a half
+		{0.5, 0.5, 0.25, 2 / math.Pi * math.Asin(0.5)}, // the arcsine distribution
+		{3, 2, 0, 0},
+		{3, 2, 1, 1},
+	} {
+		if got := regularizedIncompleteBeta(tc.a, tc.b, tc.x); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("I_%v(%v, %v) = %v, want %v", tc.x, tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+// TestWelchTTest checks p-values against Student's t distributions with closed forms: for two samples of two with
+// equal variances the test has 2 degrees of freedom, whose two-sided tail is 1 - |t|/sqrt(2+t²).
+func TestWelchTTest(t *testing.T) {
+	a := []float64{0, 2}
+	for _, shift := range []float64{0.5, 2, 10} {
+		b := []float64{shift, shift + 2}
+		tstat := shift / math.Sqrt2
+		want := 1 - tstat/math.Sqrt(2+tstat*tstat)
+		if got := welchTTest(a, b); math.Abs(got-want) > 1e-9 {
+			t.Errorf("welchTTest(%v, %v) = %v, want %v", a, b, got, want)
+		}
+		if got, back := welchTTest(a, b), welchTTest(b, a); got != back {
+			t.Errorf("welchTTest is not symmetric: %v one way, %v the other", got, back)
+		}
+	}
+	if got := welchTTest([]float64{1, 2, 3}, []float64{1, 2, 3}); got != 1 {
+		t.Errorf("welchTTest of identical samples = %v, want 1", got)
+	}
+	if got := welchTTest([]float64{5, 5}, []float64{6, 6}); got != 0 {
+		t.Errorf("welchTTest of different constant samples = %v, want 0", got)
+	}
+	if got := welchTTest([]float64{1}, []float64{1, 2}); !math.IsNaN(got) {
+		t.Errorf("welchTTest of a single run = %v, want NaN", got)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"compress/zlib"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CompressWorkload replaces simulated CPU time with real work standing in for image processing: every request
+// zlib-compresses a buffer of random bytes. Random data never compresses, so the encoder does its full match search
+// on every block and the cost per request stays steady.
+type CompressWorkload struct {
+	Size  int
+	Level int
+
+	once  sync.Once
+	input []byte
+}
+
+func (w *CompressWorkload) prepare() {
+	w.once.Do(func() {
+		w.input = make([]byte, w.Size)
+		rand.New(rand.NewSource(1)).Read(w.input)
+	})
+}
+
+type countingWriter struct {
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += len(p)
+	return len(p), nil
+}
+
+func (w *CompressWorkload) compress() (int, error) {
+	w.prepare()
+	var out countingWriter
+	zw, err := zlib.NewWriterLevel(&out, w.Level)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := zw.Write(w.input); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	return out.n, nil
+}
+
+// Calibrate returns the median time one request takes on an idle CPU, to serve as the run's WorkTime so that CPU
+// utilization and speedup keep their meaning.
+func (w *CompressWorkload) Calibrate() (time.Duration, error) {
+	var samples []time.Duration
+	for i := 0; i < 9; i++ {
+		start := time.Now()
+		if _, err := w.compress(); err != nil {
+			return 0, err
+		}
+		samples = append(samples, time.Since(start))
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[len(samples)/2], nil
+}
+
+func doCompressWork(clock Clock, w *CompressWorkload, log *RequestLog) error {
+	log.Begin(log.now(clock), "cpu", "compress", 0)
+	n, err := w.compress()
+	if err != nil {
+		return err
+	}
+	end := clock.Now()
+	if log.Enabled(LogDebug) {
+		log.Debugf(end, "compressed %d bytes to %d at level %d", w.Size, n, w.Level)
+	}
+	log.End(end)
+	return nil
+}
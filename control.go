@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"perf/controlpb"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative controlpb/control.proto
+
+// controlServer offers the server command's runs over gRPC, for Go services that orchestrate sweeps, as the Control
+// service of controlpb/control.proto.
+type controlServer struct {
+	controlpb.UnimplementedControlServer
+	s *apiServer
+}
+
+// controlPollInterval is how often StreamProgress looks for progress to send.
+const controlPollInterval = 250 * time.Millisecond
+
+func (c controlServer) StartRun(ctx context.Context, in *controlpb.StartRunRequest) (*controlpb.RunStatus, error) {
+	req := serverRequest{Scenario: in.Scenario, Args: in.Args}
+	if err := req.validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	run, err := c.s.enqueue(req)
+	if errors.Is(err, errQueueFull) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+	return runStatus(c.s.snapshot(run)), nil
+}
+
+func (c controlServer) GetResult(ctx context.Context, in *controlpb.RunRef) (*controlpb.RunResult, error) {
+	run := c.s.find(in.Id)
+	if run == nil {
+		return nil, status.Errorf(codes.NotFound, "no run %q", in.Id)
+	}
+	// The snapshot comes first, so that a finished run's results are all there.
+	return &controlpb.RunResult{Run: runStatus(c.s.snapshot(run)), Results: readResults(run.dir)}, nil
+}
+
+// StreamProgress sends run's progress each time it changes, until the run finishes or the client goes away.
+func (c controlServer) StreamProgress(in *controlpb.RunRef, stream controlpb.Control_StreamProgressServer) error {
+	run := c.s.find(in.Id)
+	if run == nil {
+		return status.Errorf(codes.NotFound, "no run %q", in.Id)
+	}
+	var last *controlpb.RunProgress
+	ticker := time.NewTicker(controlPollInterval)
+	defer ticker.Stop()
+	for {
+		snapshot := c.s.snapshot(run)
+		results := readResults(run.dir)
+		p := &controlpb.RunProgress{Status: snapshot.Status, Error: snapshot.Error, Completed: int32(len(results))}
+		if len(results) > 0 {
+			p.Latest = results[len(results)-1]
+		}
+		if last == nil || p.Status != last.Status || p.Completed != last.Completed {
+			if err := stream.Send(p); err != nil {
+				return err
+			}
+			last = p
+		}
+		if snapshot.Finished != nil {
+			return nil
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// runStatus is run as the control API gives it.
+func runStatus(run serverRun) *controlpb.RunStatus {
+	out := &controlpb.RunStatus{
+		Id:        run.ID,
+		Request:   &controlpb.StartRunRequest{Scenario: run.Request.Scenario, Args: run.Request.Args},
+		Status:    run.Status,
+		Error:     run.Error,
+		Submitted: timestamppb.New(run.Submitted),
+	}
+	if run.Started != nil {
+		out.Started = timestamppb.New(*run.Started)
+	}
+	if run.Finished != nil {
+		out.Finished = timestamppb.New(*run.Finished)
+	}
+	return out
+}
+
+// snapshot copies run as it stands, for encoding without holding s.mu.
+func (s *apiServer) snapshot(run *serverRun) serverRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *run
+}
+
+// readResults reads the results a run has written so far, each the JSON object the json reporter wrote, leaving out a
+// last line still being written.
+func readResults(dir string) []string {
+	b, err := os.ReadFile(filepath.Join(dir, "results.jsonl"))
+	if err != nil {
+		return nil
+	}
+	var results []string
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if len(line) > 0 && json.Valid(line) {
+			results = append(results, string(line))
+		}
+	}
+	return results
+}
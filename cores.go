@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// CoreSample is how busy each CPU of the machine was over the interval of a run ending at Elapsed, as a fraction,
+// whatever kept it busy: this process, its target or anything else on the machine.
+type CoreSample struct {
+	Elapsed time.Duration `json:"elapsed_ns"`
+	Busy    []float64     `json:"busy"` // by CPU, in the order /proc/stat lists them
+}
+
+// CoreUsage summarizes a run's core trace. A throughput plateau with one core near 100% and the rest with time to
+// spare is a single goroutine or lock serializing the run, not the machine running out of CPU.
+type CoreUsage struct {
+	CPUs           []int   `json:"cpus"`
+	BusiestCPU     int     `json:"busiest_cpu"`
+	BusiestUtil    float64 `json:"busiest_utilization"` // the busiest CPU's average over the run
+	MeanUtil       float64 `json:"mean_utilization"`    // over every CPU
+	SaturatedShare float64 `json:"saturated_share"`     // of the samples in which some CPU was at least 95% busy
+}
+
+// cpuTimes is a CPU's counters from /proc/stat, in clock ticks.
+type cpuTimes struct {
+	id         int
+	busy, idle uint64
+}
+
+// readCPUTimes reads every CPU's counters from /proc/stat, or returns nil where that is not available.
+func readCPUTimes() []cpuTimes {
+	b, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return nil
+	}
+	var times []cpuTimes
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+		id, err := strconv.Atoi(fields[0][3:])
+		if err != nil {
+			continue
+		}
+		t := cpuTimes{id: id}
+		// user nice system idle iowait irq softirq steal; guest time is already counted in user.
+		for i, f := range fields[1:] {
+			if i >= 8 {
+				break
+			}
+			v, _ := strconv.ParseUint(f, 10, 64)
+			if i == 3 || i == 4 {
+				t.idle += v
+			} else {
+				t.busy += v
+			}
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// coreMonitor samples how busy each CPU is in the background during a run.
+type coreMonitor struct {
+	start   time.Time
+	cpus    []int
+	last    []cpuTimes
+	samples []CoreSample
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// startCoreMonitor starts sampling every interval, or returns nil where /proc/stat is unavailable.
+func startCoreMonitor(interval time.Duration) *coreMonitor {
+	last := readCPUTimes()
+	if len(last) == 0 {
+		return nil
+	}
+	m := &coreMonitor{start: time.Now(), last: last, stop: make(chan struct{}), done: make(chan struct{})}
+	for _, t := range last {
+		m.cpus = append(m.cpus, t.id)
+	}
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.sample()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+func (m *coreMonitor) sample() {
+	now := readCPUTimes()
+	if len(now) != len(m.last) {
+		return // a CPU went on or offline; the next interval compares like with like
+	}
+	s := CoreSample{Elapsed: time.Since(m.start), Busy: make([]float64, len(now))}
+	for i, t := range now {
+		busy, idle := float64(t.busy-m.last[i].busy), float64(t.idle-m.last[i].idle)
+		if busy+idle > 0 {
+			s.Busy[i] = busy / (busy + idle)
+		}
+	}
+	m.last = now
+	m.samples = append(m.samples, s)
+}
+
+// Stop ends sampling, taking one last sample, and returns the CPUs sampled and the trace; nil for a nil monitor.
+func (m *coreMonitor) Stop() ([]int, []CoreSample) {
+	if m == nil {
+		return nil, nil
+	}
+	close(m.stop)
+	<-m.done
+	m.sample()
+	return m.cpus, m.samples
+}
+
+// coreUsage summarizes a core trace; nil for an empty one.
+func coreUsage(cpus []int, trace []CoreSample) *CoreUsage {
+	if len(trace) == 0 || len(cpus) == 0 {
+		return nil
+	}
+	sums := make([]float64, len(cpus))
+	saturated := 0
+	for _, s := range trace {
+		max := 0.0
+		for i, busy := range s.Busy {
+			if i < len(sums) {
+				sums[i] += busy
+			}
+			max = math.Max(max, busy)
+		}
+		if max >= 0.95 {
+			saturated++
+		}
+	}
+	u := &CoreUsage{CPUs: cpus, SaturatedShare: float64(saturated) / float64(len(trace))}
+	var total float64
+	for i, sum := range sums {
+		avg := sum / float64(len(trace))
+		total += avg
+		if i == 0 || avg > u.BusiestUtil {
+			u.BusiestCPU, u.BusiestUtil = cpus[i], avg
+		}
+	}
+	u.MeanUtil = total / float64(len(cpus))
+	return u
+}
+
+func outputCores(result BenchmarkResult) {
+	u := coreUsage(result.CoreCPUs, result.CoreTrace)
+	if u == nil || len(u.CPUs) < 2 {
+		return // with one CPU, the busiest is all of them
+	}
+	line := fmt.Sprintf("\tCores: busiest CPU %d at %s, mean %s over %d CPUs", u.BusiestCPU, formatPercent(u.BusiestUtil),
+		formatPercent(u.MeanUtil), len(u.CPUs))
+	// One core pinned while the others have room to spare is serialization rather than a lack of CPU.
+	if u.BusiestUtil >= 0.9 && u.MeanUtil < 0.6*u.BusiestUtil {
+		line += "; one core saturated while the rest are not, so a single goroutine or thread may be the bottleneck"
+	}
+	fmt.Println(line)
+}
+
+// saveCoreTrace draws how busy each CPU was over the run, stacked, with -plot-cores: the top of the stack is the
+// machine's CPUs busy in all, and a band that stays at its full height while the others are thin is a core
+// saturated on its own.
+func saveCoreTrace(result BenchmarkResult, opts PlotOptions) error {
+	if !opts.Cores || len(result.CoreTrace) < 2 {
+		return nil
+	}
+	p := plot.New()
+	p.Title.Text = "CPU Utilization by Core over Time"
+	p.X.Label.Text = "Time since start (s)"
+	p.Y.Label.Text = "CPUs busy"
+	p.Y.Min, p.Y.Max = 0, float64(len(result.CoreCPUs))
+	below := make([]float64, len(result.CoreTrace))
+	for i, cpu := range result.CoreCPUs {
+		var top, bottom plotter.XYs
+		for j, s := range result.CoreTrace {
+			busy := 0.0
+			if i < len(s.Busy) {
+				busy = s.Busy[i]
+			}
+			x := s.Elapsed.Seconds()
+			bottom = append(bottom, plotter.XY{X: x, Y: below[j]})
+			below[j] += busy
+			top = append(top, plotter.XY{X: x, Y: below[j]})
+		}
+		band := top
+		for k := len(bottom) - 1; k >= 0; k-- {
+			band = append(band, bottom[k])
+		}
+		poly, err := plotter.NewPolygon(band)
+		if err != nil {
+			return err
+		}
+		c := plotutil.Color(i)
+		r, g, b, _ := c.RGBA()
+		poly.Color = color.NRGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 160}
+		poly.LineStyle.Width = vg.Points(0.5)
+		poly.LineStyle.Color = c
+		p.Add(poly)
+		// The legend gets crowded past a machine's worth of colours; the bands are still told apart by their edges.
+		if i < 16 {
+			p.Legend.Add(fmt.Sprintf("CPU %d", cpu), poly)
+		}
+	}
+	p.Legend.Top = true
+	return opts.save(p, 6*vg.Inch, 4*vg.Inch, opts.runFile(result, "cores_time.png"))
+}
+
+// plotCores draws each run's busiest core and mean core utilization against the co-routine count, with -plot-cores:
+// where throughput flattens, the busiest core reaching 100% well ahead of the mean points at one goroutine or thread
+// rather than the machine. Series keep their colour, and the mean is dashed.
+func plotCores(results []BenchmarkResult, opts PlotOptions) error {
+	if !opts.Cores {
+		return nil
+	}
+	p := plot.New()
+	p.Title.Text = "Core Utilization vs. Number of Co-Routines"
+	p.X.Label.Text = "Number of Co-Routines"
+	p.Y.Label.Text = "CPU utilization (%)"
+	p.Y.Min, p.Y.Max = 0, 100
+	busiest := func(r BenchmarkResult) float64 {
+		if u := coreUsage(r.CoreCPUs, r.CoreTrace); u != nil {
+			return u.BusiestUtil * 100
+		}
+		return math.NaN()
+	}
+	mean := func(r BenchmarkResult) float64 {
+		if u := coreUsage(r.CoreCPUs, r.CoreTrace); u != nil {
+			return u.MeanUtil * 100
+		}
+		return math.NaN()
+	}
+	labels, series := bySeries(results)
+	drawn := false
+	for i, label := range labels {
+		for j, y := range []func(BenchmarkResult) float64{busiest, mean} {
+			line, err := sweepLine(p, series[label], y, plotutil.Color(i))
+			if err != nil {
+				return err
+			}
+			if line == nil {
+				continue
+			}
+			drawn = true
+			name := []string{"busiest core", "mean of cores"}[j]
+			if j == 1 {
+				line.LineStyle.Dashes = plotutil.Dashes(1)
+			}
+			if len(labels) > 1 {
+				name = label + ", " + name
+			}
+			p.Legend.Add(name, line)
+		}
+	}
+	if !drawn {
+		return nil
+	}
+	p.Legend.Top = true
+	p.Legend.Left = true
+	opts.fitCoroutines(&p.X)
+	return opts.save(p, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("cores_vs_coroutines.png"))
+}
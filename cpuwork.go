@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cpuKernel is a kind of CPU work: a fixed number of iterations of a loop, calibrated once so that on an idle CPU it
+// takes the time asked for. Unlike polling the clock until the time is up, the work is the same however long it
+// takes: when requests compete for a CPU each one's work is stretched out, as real computation is, rather than being
+// cut short by a deadline it shares with the others. Kernels differ in what else they compete for: spin only for the
+// ALU, the others for the caches, memory bandwidth and, in json's case, the allocator and garbage collector.
+type cpuKernel struct {
+	Name        string
+	Description string
+	run         func(n int64)
+
+	once sync.Once
+	rate float64 // iterations per nanosecond
+}
+
+var cpuKernels = []*cpuKernel{
+	{Name: "spin", Description: "xorshift arithmetic in registers, touching no memory", run: burn},
+	{Name: "sha256", Description: "SHA-256 over a 4MiB buffer 1KiB at a time, streaming through the caches", run: hashWork},
+	{Name: "matmul", Description: "32x32 matrix multiplications, within the L1 and L2 caches", run: matmulWork},
+	{Name: "json", Description: "encoding and decoding a small JSON document, allocating as request handlers do", run: jsonWork},
+	{Name: "format", Description: "formatting lines with fmt.Sprintf into a new strings.Builder, allocating per request", run: formatWork},
+	{Name: "format-pooled", Description: "the same lines appended with strconv into a pooled buffer, allocating nothing", run: formatPooledWork},
+}
+
+// cpuKernelNamed returns the kernel called name, spin for an empty name, or nil if there is none.
+func cpuKernelNamed(name string) *cpuKernel {
+	if name == "" {
+		return cpuKernels[0]
+	}
+	for _, k := range cpuKernels {
+		if k.Name == name {
+			return k
+		}
+	}
+	return nil
+}
+
+func cpuKernelNames() string {
+	var names []string
+	for _, k := range cpuKernels {
+		names = append(names, k.Name+" ("+k.Description+")")
+	}
+	return strings.Join(names, ", ")
+}
+
+// calibrate measures how many iterations of the kernel take a nanosecond. It first grows the iteration count until
+// it takes 10ms, then keeps the fastest of five runs of that many, the one least disturbed by interrupts and other
+// processes.
+func (k *cpuKernel) calibrate() float64 {
+	k.once.Do(func() {
+		n := int64(1)
+		for {
+			start := time.Now()
+			k.run(n)
+			if time.Since(start) >= 10*time.Millisecond {
+				break
+			}
+			n *= 2
+		}
+		fastest := time.Duration(1<<63 - 1)
+		for i := 0; i < 5; i++ {
+			start := time.Now()
+			k.run(n)
+			if d := time.Since(start); d < fastest {
+				fastest = d
+			}
+		}
+		k.rate = float64(n) / float64(fastest)
+	})
+	return k.rate
+}
+
+// work burns CPU for d of wall-clock time on an idle CPU. Kernels whose iterations are long round to the nearest
+// whole number of them.
+func (k *cpuKernel) work(d time.Duration) {
+	k.run(int64(float64(d)*k.calibrate() + 0.5))
+}
+
+func (k *cpuKernel) String() string {
+	return fmt.Sprintf("%s: %s iterations per ms", k.Name, formatFloat(k.calibrate()*float64(time.Millisecond), 1))
+}
+
+var cpuWorkSink uint64 // keeps the compiler from discarding the kernels' results
+
+// burn runs n iterations of a xorshift generator, which keeps the ALU busy without touching memory.
+func burn(n int64) {
+	x := uint64(n) | 1
+	for i := int64(0); i < n; i++ {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+	}
+	atomic.AddUint64(&cpuWorkSink, x)
+}
+
+var (
+	hashInputOnce sync.Once
+	hashInput     []byte
+)
+
+// hashWork hashes n consecutive 1KiB chunks of a shared 4MiB buffer, larger than most CPUs' L2 cache, so that the
+// work keeps pulling data from the last-level cache or memory.
+func hashWork(n int64) {
+	hashInputOnce.Do(func() {
+		hashInput = make([]byte, 4<<20)
+		rand.New(rand.NewSource(1)).Read(hashInput)
+	})
+	chunks := int64(len(hashInput) / 1024)
+	offset := rand.Int63n(chunks)
+	var sum [sha256.Size]byte
+	for i := int64(0); i < n; i++ {
+		c := (offset + i) % chunks
+		sum = sha256.Sum256(hashInput[c*1024 : (c+1)*1024])
+	}
+	atomic.AddUint64(&cpuWorkSink, uint64(sum[0]))
+}
+
+const matmulSize = 32
+
+// matmulWork multiplies two 32x32 matrices n times. The three matrices take 24KiB, allocated per call so that
+// concurrent requests each bring their own working set into the caches.
+func matmulWork(n int64) {
+	a := make([]float64, matmulSize*matmulSize)
+	b := make([]float64, matmulSize*matmulSize)
+	c := make([]float64, matmulSize*matmulSize)
+	for i := range a {
+		a[i], b[i] = float64(i%7), float64(i%5)
+	}
+	for ; n > 0; n-- {
+		for i := 0; i < matmulSize; i++ {
+			for k := 0; k < matmulSize; k++ {
+				aik := a[i*matmulSize+k]
+				for j := 0; j < matmulSize; j++ {
+					c[i*matmulSize+j] += aik * b[k*matmulSize+j]
+				}
+			}
+		}
+	}
+	atomic.AddUint64(&cpuWorkSink, uint64(c[0]))
+}
+
+type jsonWorkItem struct {
+	ID       int               `json:"id"`
+	Name     string            `json:"name"`
+	Price    float64           `json:"price"`
+	Tags     []string          `json:"tags"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// jsonWork encodes and decodes a document of 16 items, about 2KiB of JSON, n times.
+func jsonWork(n int64) {
+	items := make([]jsonWorkItem, 16)
+	for i := range items {
+		items[i] = jsonWorkItem{ID: i, Name: fmt.Sprintf("item-%d", i), Price: float64(i) * 1.25,
+			Tags: []string{"a", "b", "c"}, Metadata: map[string]string{"color": "red", "size": "m"}}
+	}
+	var decoded []jsonWorkItem
+	for ; n > 0; n-- {
+		// Neither can fail for this type and the document it encodes to.
+		b, _ := json.Marshal(items)
+		decoded = decoded[:0]
+		json.Unmarshal(b, &decoded)
+	}
+	atomic.AddUint64(&cpuWorkSink, uint64(len(decoded)))
+}
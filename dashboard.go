@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dashboardInterval = 250 * time.Millisecond
+	dashboardWindow   = 1024 // recent samples the rolling percentiles are computed over
+	dashboardHistory  = 40   // RPS readings shown in the sparkline
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// dashboardReporter redraws a live summary of the current run in place on a terminal: its parameters, progress,
+// instantaneous RPS with a sparkline of recent readings, and percentiles over the most recent samples.
+type dashboardReporter struct {
+	out io.Writer
+
+	mu        sync.Mutex
+	cfg       RunConfig
+	clock     Clock
+	start     time.Time
+	completed int
+	window    []float64
+	next      int
+	lastCount int
+	lastTick  time.Time
+	rps       []float64
+	lines     int
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newDashboardReporter(out io.Writer) *dashboardReporter {
+	return &dashboardReporter{out: out}
+}
+
+func (d *dashboardReporter) OnRunStart(cfg RunConfig) {
+	d.stopTicker()
+	d.mu.Lock()
+	d.cfg = cfg
+	d.clock = cfg.clock()
+	d.start = d.clock.Now()
+	d.completed, d.lastCount, d.next = 0, 0, 0
+	d.lastTick = d.start
+	d.window = d.window[:0]
+	d.rps = nil
+	d.lines = 0
+	stop, done := make(chan struct{}), make(chan struct{})
+	d.stop, d.done = stop, done
+	d.mu.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(dashboardInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.mu.Lock()
+				d.tick()
+				d.draw()
+				d.mu.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (d *dashboardReporter) OnSample(sample Sample) {
+	ms := float64(sample.Latency) / float64(time.Millisecond)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.completed++
+	if len(d.window) < dashboardWindow {
+		d.window = append(d.window, ms)
+	} else {
+		d.window[d.next] = ms
+		d.next = (d.next + 1) % dashboardWindow
+	}
+}
+
+func (d *dashboardReporter) OnRunComplete(result BenchmarkResult) error {
+	d.stopTicker()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tick()
+	d.draw()
+	d.lines = 0 // leave the final state on screen and draw the next run below it
+	return nil
+}
+
+func (d *dashboardReporter) Close() error {
+	d.stopTicker()
+	return nil
+}
+
+func (d *dashboardReporter) stopTicker() {
+	if d.stop == nil {
+		return
+	}
+	close(d.stop)
+	<-d.done
+	d.stop = nil
+}
+
+// tick records the RPS since the previous tick. Times are on the run's clock so that compressed runs show nominal
+// rates.
+func (d *dashboardReporter) tick() {
+	now := d.clock.Now()
+	if elapsed := now.Sub(d.lastTick); elapsed > 0 {
+		d.rps = append(d.rps, float64(d.completed-d.lastCount)/elapsed.Seconds())
+		if len(d.rps) > dashboardHistory {
+			d.rps = d.rps[1:]
+		}
+	}
+	d.lastCount, d.lastTick = d.completed, now
+}
+
+func (d *dashboardReporter) draw() {
+	var b strings.Builder
+	if d.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA\x1b[J", d.lines)
+	}
+	cfg := d.cfg
+	executor := cfg.Executor
+	if executor == "" {
+		executor = "semaphore"
+	}
+	fmt.Fprintf(&b, "Run: %s CPU/%s Network, %s co-routines, GOMAXPROCS=%d, %s executor, %v\n",
+		formatDuration(cfg.WorkTime), formatDuration(cfg.NetworkTime), formatCount(cfg.NumCoroutines), cfg.GOMAXPROCS, executor, cfg.Load)
+	fmt.Fprintf(&b, "Completed: %s/%s (%s), elapsed %v\n", formatCount(int64(d.completed)), formatCount(int64(cfg.Iterations)),
+		formatPercent(float64(d.completed)/math.Max(float64(cfg.Iterations), 1)), d.clock.Now().Sub(d.start).Round(100*time.Millisecond))
+	var current float64
+	if len(d.rps) > 0 {
+		current = d.rps[len(d.rps)-1]
+	}
+	fmt.Fprintf(&b, "RPS: %10s %s\n", formatFloat(current, 1), sparkline(d.rps))
+	if len(d.window) == 0 {
+		b.WriteString("Latency: no requests completed yet\n")
+	} else {
+		recent := &exactCollector{values: d.window}
+		fmt.Fprintf(&b, "Latency (last %d): p50 %s, p95 %s, p99 %s\n", len(d.window),
+			formatMs(recent.Percentile(50)), formatMs(recent.Percentile(95)), formatMs(recent.Percentile(99)))
+	}
+	d.lines = 4
+	io.WriteString(d.out, b.String())
+}
+
+func sparkline(values []float64) string {
+	var max float64
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if max > 0 {
+			i = int(v / max * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
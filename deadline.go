@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// parsePropagation parses -propagate-deadline: a comma-separated list of off and on to sweep.
+func parsePropagation(s string) ([]bool, error) {
+	var values []bool
+	for _, v := range strings.Split(s, ",") {
+		switch strings.TrimSpace(v) {
+		case "off":
+			values = append(values, false)
+		case "on":
+			values = append(values, true)
+		default:
+			return nil, fmt.Errorf("invalid -propagate-deadline %q, expected off, on or both", v)
+		}
+	}
+	return values, nil
+}
+
+// formatPropagation names whether a run propagated its requests' deadlines, as the deadline dimension's value.
+func formatPropagation(on bool) string {
+	if on {
+		return "propagated"
+	}
+	return "ignored"
+}
+
+// withDeadline returns cfg carrying ctx's deadline down to the request's phases, when cfg.PropagateDeadline has them
+// honor it.
+func (cfg RunConfig) withDeadline(ctx context.Context) RunConfig {
+	if cfg.PropagateDeadline {
+		cfg.deadline, _ = ctx.Deadline()
+	}
+	return cfg
+}
+
+// checkDeadline returns context.DeadlineExceeded once the request's propagated deadline has passed, so that the
+// phases it has left are never started. A service checks its context between steps the same way; one that doesn't
+// runs them all for a caller that has stopped waiting.
+func (cfg RunConfig) checkDeadline(log *RequestLog) error {
+	if cfg.deadline.IsZero() {
+		return nil
+	}
+	if now := cfg.clock().Now(); !now.Before(cfg.deadline) {
+		log.Debugf(now, "deadline passed %v ago; the remaining phases are cancelled", now.Sub(cfg.deadline))
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// untilDeadline cuts a network wait of d short at the request's propagated deadline, as a call that is cancelled
+// with its context would be, and reports whether it did.
+func (cfg RunConfig) untilDeadline(d time.Duration) (time.Duration, bool) {
+	if cfg.deadline.IsZero() {
+		return d, false
+	}
+	left := cfg.deadline.Sub(cfg.clock().Now())
+	if left < 0 {
+		left = 0
+	}
+	if d <= left {
+		return d, false
+	}
+	return left, true
+}
+
+// wastedWork is how long a request ran past its deadline: work done for a caller that had already given up on it.
+// With the deadline propagated it is at most the tail of the CPU phase under way when the deadline passed, and of a
+// real network call, which can't be cut short; without, it is everything the request did after it.
+func wastedWork(ctx context.Context, end time.Time) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok || !end.After(deadline) {
+		return 0
+	}
+	return end.Sub(deadline)
+}
+
+// wastedFraction is wasted as a share of busy, the co-routine time all of a run's requests took.
+func wastedFraction(wasted, busy time.Duration) float64 {
+	if busy <= 0 {
+		return 0
+	}
+	return float64(wasted) / float64(busy)
+}
+
+func outputWastedWork(result BenchmarkResult) {
+	cfg := result.Config
+	if cfg.RequestTimeout <= 0 {
+		return
+	}
+	line := fmt.Sprintf("\tWasted work: %s past deadlines, %s of co-routine time", formatDuration(result.WastedWork),
+		formatPercent(result.WastedFraction))
+	if result.Timeouts > 0 {
+		line += fmt.Sprintf(", %s per timed-out request", formatDuration(result.WastedWork/time.Duration(result.Timeouts)))
+	}
+	if cfg.PropagateDeadline {
+		line += "; remaining phases cancelled at the deadline"
+	} else {
+		line += "; deadline not propagated"
+	}
+	fmt.Println(line)
+}
+
+// plotWastedWork draws the share of co-routine time spent on requests past their deadline against the co-routine
+// count, for sweeps with -request-timeout, so that what propagating the deadline saves can be read off as the load
+// grows and more requests miss it.
+func plotWastedWork(results []BenchmarkResult, opts PlotOptions) error {
+	if results[0].Config.RequestTimeout <= 0 {
+		return nil
+	}
+	plt := plot.New()
+	plt.Title.Text = "Wasted Work vs. Number of Co-Routines"
+	plt.X.Label.Text = "Number of Co-Routines"
+	plt.Y.Label.Text = "Co-routine time past deadlines (%)"
+	labels, series := bySeries(results)
+	for i, label := range labels {
+		line, err := sweepLine(plt, series[label], func(r BenchmarkResult) float64 { return r.WastedFraction * 100 }, plotutil.Color(i))
+		if err != nil {
+			return err
+		}
+		if line != nil && len(labels) > 1 {
+			plt.Legend.Add(label, line)
+		}
+	}
+	plt.Y.Min = 0
+	plt.Legend.Top = true
+	plt.Legend.Left = true
+	opts.fitCoroutines(&plt.X)
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("wasted_work_vs_coroutines.png"))
+}
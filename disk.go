@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type DiskWorkload struct {
+	Dir          string
+	FileSize     int64
+	BlockSize    int
+	Blocks       int
+	Pattern      string  // one of diskPatterns
+	ReadFraction float64 // of the mixed pattern's blocks
+	Fsync        bool
+
+	file   *os.File
+	cursor int64
+
+	mu    sync.Mutex
+	ops   []time.Duration // how long each block took, in the order the phases finished
+	reads int64
+}
+
+// diskPatterns are the ways a disk phase can go through the file's blocks. Sequential ones share a cursor across
+// requests, so that together they walk the file in order, wrapping around at its end; appends extend it instead, and
+// mixed reads or writes random blocks.
+var diskPatterns = []string{"sequential-read", "random-read", "sequential-write", "random-write", "append", "mixed"}
+
+// Open creates and fills the backing file so that reads hit real data rather than a sparse hole.
+func (d *DiskWorkload) Open() error {
+	if d.BlockSize <= 0 || d.FileSize < int64(d.BlockSize) {
+		return fmt.Errorf("disk file size %d must hold at least one %d byte block", d.FileSize, d.BlockSize)
+	}
+	known := false
+	for _, p := range diskPatterns {
+		known = known || p == d.Pattern
+	}
+	if !known {
+		return fmt.Errorf("unknown disk access pattern %q, expected one of %v", d.Pattern, diskPatterns)
+	}
+	if d.Pattern == "mixed" && (d.ReadFraction < 0 || d.ReadFraction > 1) {
+		return fmt.Errorf("disk read fraction must be between 0 and 1, got %v", d.ReadFraction)
+	}
+	f, err := os.CreateTemp(d.Dir, "perf-disk-*")
+	if err != nil {
+		return err
+	}
+	chunk := make([]byte, 1<<20)
+	rand.Read(chunk)
+	for written := int64(0); written < d.FileSize; {
+		n := int64(len(chunk))
+		if d.FileSize-written < n {
+			n = d.FileSize - written
+		}
+		if _, err := f.Write(chunk[:n]); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return err
+		}
+		written += n
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	d.file = f
+	return nil
+}
+
+func (d *DiskWorkload) Close() error {
+	if d.file == nil {
+		return nil
+	}
+	err := d.file.Close()
+	os.Remove(d.file.Name())
+	d.file = nil
+	return err
+}
+
+func (d *DiskWorkload) mode() string {
+	if d.Pattern == "mixed" {
+		return fmt.Sprintf("mixed, %s reads", formatPercent(d.ReadFraction))
+	}
+	if d.Fsync && d.Pattern != "sequential-read" && d.Pattern != "random-read" {
+		return d.Pattern + "+fsync"
+	}
+	return d.Pattern
+}
+
+func (d *DiskWorkload) String() string {
+	return fmt.Sprintf("%d x %dB %s per phase in a file of %s", d.Blocks, d.BlockSize, d.mode(), formatMiB(uint64(d.FileSize)))
+}
+
+// nextOp picks the next block's offset and whether to write it.
+func (d *DiskWorkload) nextOp(r *rand.Rand) (offset int64, write bool) {
+	numBlocks := d.FileSize / int64(d.BlockSize)
+	switch d.Pattern {
+	case "random-read", "random-write":
+		return r.Int63n(numBlocks) * int64(d.BlockSize), d.Pattern == "random-write"
+	case "append":
+		return d.FileSize + (atomic.AddInt64(&d.cursor, 1)-1)*int64(d.BlockSize), true
+	case "mixed":
+		return r.Int63n(numBlocks) * int64(d.BlockSize), r.Float64() >= d.ReadFraction
+	}
+	block := atomic.AddInt64(&d.cursor, 1) - 1
+	return (block % numBlocks) * int64(d.BlockSize), d.Pattern == "sequential-write"
+}
+
+// done records the latencies of a phase's blocks, and how many of them were reads.
+func (d *DiskWorkload) done(ops []time.Duration, reads int) {
+	d.mu.Lock()
+	d.ops = append(d.ops, ops...)
+	d.reads += int64(reads)
+	d.mu.Unlock()
+}
+
+// DiskStats is how the disk phases' blocks fared over a run.
+type DiskStats struct {
+	Reads  int64         `json:"reads"`
+	Writes int64         `json:"writes"`
+	IOPS   float64       `json:"iops"` // blocks read or written per second of the run
+	P50    time.Duration `json:"p50_ns"`
+	P99    time.Duration `json:"p99_ns"`
+	Max    time.Duration `json:"max_ns"`
+}
+
+// diskMark is how far into its record of blocks the workload was at some point, for stats over a run.
+type diskMark struct {
+	ops   int
+	reads int64
+}
+
+func (d *DiskWorkload) mark() diskMark {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return diskMark{ops: len(d.ops), reads: d.reads}
+}
+
+// stats summarizes the blocks done since from, over a run that took duration.
+func (d *DiskWorkload) stats(from diskMark, duration time.Duration) *DiskStats {
+	d.mu.Lock()
+	ops := append([]time.Duration(nil), d.ops[from.ops:]...)
+	reads := d.reads - from.reads
+	d.mu.Unlock()
+	stats := &DiskStats{Reads: reads, Writes: int64(len(ops)) - reads}
+	if len(ops) == 0 {
+		return stats
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+	stats.IOPS = float64(len(ops)) / duration.Seconds()
+	stats.P50 = ops[len(ops)*50/100]
+	stats.P99 = ops[len(ops)*99/100]
+	stats.Max = ops[len(ops)-1]
+	return stats
+}
+
+func doDiskWork(clock Clock, disk *DiskWorkload, r *rand.Rand, log *RequestLog) error {
+	start := clock.Now()
+	log.Begin(start, "disk", "disk ops", 0)
+	if log.Enabled(LogDebug) {
+		log.Debugf(start, "%d x %dB %s", disk.Blocks, disk.BlockSize, disk.mode())
+	}
+	buf := make([]byte, disk.BlockSize)
+	ops := make([]time.Duration, 0, disk.Blocks)
+	reads := 0
+	for i := 0; i < disk.Blocks; i++ {
+		offset, write := disk.nextOp(r)
+		opStart := clock.Now()
+		var err error
+		if write {
+			_, err = disk.file.WriteAt(buf, offset)
+			if err == nil && disk.Fsync {
+				err = disk.file.Sync()
+			}
+		} else {
+			_, err = disk.file.ReadAt(buf, offset)
+			reads++
+		}
+		if err != nil {
+			return err
+		}
+		ops = append(ops, clock.Now().Sub(opStart))
+	}
+	disk.done(ops, reads)
+	log.End(clock.Now())
+	return nil
+}
+
+func outputDisk(result BenchmarkResult) {
+	if result.Config.Disk != nil {
+		fmt.Printf("\tDisk: %v\n", result.Config.Disk)
+	}
+	s := result.DiskStats
+	if s == nil || s.Reads+s.Writes == 0 {
+		return
+	}
+	fmt.Printf("\tDisk IOPS: %s (%s reads, %s writes); per block p50 %s, p99 %s, max %s\n", formatFloat(s.IOPS, 2),
+		formatCount(s.Reads), formatCount(s.Writes), formatDuration(s.P50), formatDuration(s.P99), formatDuration(s.Max))
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// A distributed run splits each run across load generator agents on other machines, for when one machine cannot
+// generate enough load: the coordinator shares the run out as runMultiProcess does, each agent runs its share as a
+// load-generating child of its own, and the coordinator merges the latencies they return into one result. The agents
+// must run the same build, and be able to read any file the coordinator's arguments name.
+//
+// Agents serve two unary gRPC methods, both taking and returning JSON in a BytesValue: Run, which takes an agentRun
+// and returns its childResult once the share has run, and Interrupt, which takes a run's ID and has its child drain
+// and return what it measured so far, as an interrupted run would.
+//
+// Coordinators and agents share a token, from agentTokenEnv on both sides, which agents refuse calls without. Agents
+// only run a coordinator's arguments for the flags in agentFlags.
+const (
+	agentRunMethod       = "/perf.Agent/Run"
+	agentInterruptMethod = "/perf.Agent/Interrupt"
+)
+
+// agentTokenEnv holds the token coordinators present to agents.
+const agentTokenEnv = "PERF_AGENT_TOKEN"
+
+// agentFlags are the flags agents run a share with: those that shape the load and the work its requests do. The
+// rest are the coordinator's own, for reporting on and sweeping the runs, and are left out of what it sends.
+var agentFlags = map[string]bool{
+	// load and executor
+	"aggregation": true, "burst-interval": true, "burst-length": true, "burst-size": true, "collector": true,
+	"compensate-timer": true, "coroutines": true, "dispatch": true, "executor": true, "gogc": true, "gomaxprocs": true,
+	"load": true, "low-overhead": true, "max-error-rate": true, "max-fds": true, "max-memory": true,
+	"max-timeout-rate": true, "memory-limit": true, "mix": true, "priority": true, "propagate-deadline": true,
+	"queue-depth": true, "ramp-duration": true, "ramp-from": true, "ramp-to": true, "rate": true, "rate-limit": true,
+	"request-timeout": true, "reservoir-size": true, "result-batch": true, "seed": true, "session": true,
+	"stall-timeout": true, "think-dist": true, "think-time": true, "time-compression": true, "trace": true,
+	// simulated work
+	"cpu-dist": true, "cpu-kernel": true, "network-dist": true, "network-time": true, "splits": true,
+	"splits-dist": true, "work-time": true,
+	// workloads
+	"disk": true, "disk-block-size": true, "disk-blocks": true, "disk-file-size": true, "disk-fsync": true,
+	"disk-pattern": true, "disk-random": true, "disk-read-fraction": true, "disk-write": true, "dns": true,
+	"dns-delay": true, "dns-name": true, "dns-resolver": true, "fanout": true, "fanout-limit": true,
+	"grpc-payload-size": true, "http-max-idle-conns": true, "lock": true, "lock-critical-section": true,
+	"lock-read-fraction": true, "lock-rw": true, "middleware": true, "mq": true, "mq-capacity": true,
+	"mq-consumers": true, "mq-message-size": true, "network": true, "pipeline-buffer": true, "pipeline-stages": true,
+	"pipeline-workers": true, "redis": true, "redis-commands": true, "redis-conns": true, "redis-get-fraction": true,
+	"redis-keys": true, "redis-pipeline": true, "redis-value-size": true, "sql": true, "sql-args": true,
+	"sql-conn-lifetime": true, "sql-driver": true, "sql-max-idle": true, "sql-max-open": true, "sql-query": true,
+	"tcp-message-size": true, "tcp-pool-size": true, "tls-resume": true, "url": true, "url-body": true,
+	"url-header": true, "url-method": true, "ws-conns": true, "ws-message-size": true,
+	// failures
+	"breaker-error-rate": true, "breaker-half-open-calls": true, "breaker-open": true, "breaker-window": true,
+	"fault-duration": true, "fault-error-rate": true, "fault-slow-delay": true, "fault-slow-rate": true,
+	"fault-start": true, "hedge-after": true, "hedge-max": true, "retry-attempts": true, "retry-backoff": true,
+	"retry-jitter": true, "retry-max-backoff": true,
+}
+
+// agentRefusedFlags shape the load too, but have an agent run code or write files of the caller's choosing, so a
+// distributed run cannot have them.
+var agentRefusedFlags = []string{"exec", "exec-shell", "plugin", "disk-dir"}
+
+// agentArgs picks out of args, the coordinator's, parsed with fs, the flags to send agents, each as -name=value. It
+// fails if args set any of agentRefusedFlags.
+func agentArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if name == "" || name == args[i] {
+			break // -- or the first argument that isn't a flag, where parsing stopped
+		}
+		value := "true" // a boolean flag given bare
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value = name[:eq], name[eq+1:]
+		} else if f := fs.Lookup(name); f != nil && !isBoolFlag(f) && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		for _, refused := range agentRefusedFlags {
+			if name == refused {
+				return nil, fmt.Errorf("-%s cannot be combined with -agents, which refuse to run it", name)
+			}
+		}
+		if agentFlags[name] {
+			kept = append(kept, "-"+name+"="+value)
+		}
+	}
+	return kept, nil
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// checkAgentArgs refuses a run whose arguments are anything but flags in agentFlags, as agentArgs sends them.
+func checkAgentArgs(args []string) error {
+	for _, arg := range args {
+		eq := strings.Index(arg, "=")
+		if !strings.HasPrefix(arg, "-") || eq < 0 || !agentFlags[strings.TrimLeft(arg[:eq], "-")] {
+			return fmt.Errorf("agents do not run %q", arg)
+		}
+	}
+	return nil
+}
+
+// agentRun is one agent's share of a run.
+type agentRun struct {
+	ID   string   // names the run for Interrupt
+	Args []string // the coordinator's, from which the child builds the same base configuration
+	Run  childRun
+}
+
+// agent runs the shares coordinators send it.
+type agent struct {
+	mu      sync.Mutex
+	running map[string]*exec.Cmd
+	token   string
+}
+
+var agentService = grpc.ServiceDesc{
+	ServiceName: "perf.Agent",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Run",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				if err := srv.(*agent).authorize(ctx); err != nil {
+					return nil, err
+				}
+				in := &wrapperspb.BytesValue{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				var run agentRun
+				if err := json.Unmarshal(in.Value, &run); err != nil {
+					return nil, err
+				}
+				if err := checkAgentArgs(run.Args); err != nil {
+					return nil, status.Error(codes.PermissionDenied, err.Error())
+				}
+				out, err := srv.(*agent).run(run)
+				if err != nil {
+					return nil, err
+				}
+				return wrapperspb.Bytes(out), nil
+			},
+		},
+		{
+			MethodName: "Interrupt",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				if err := srv.(*agent).authorize(ctx); err != nil {
+					return nil, err
+				}
+				in := &wrapperspb.BytesValue{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				srv.(*agent).interrupt(string(in.Value))
+				return &wrapperspb.BytesValue{}, nil
+			},
+		},
+	},
+}
+
+// run runs a share as a child process and returns the childResult it wrote. The share runs to the end even if the
+// coordinator goes away; Interrupt is what cuts it short.
+func (a *agent) run(run agentRun) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "perf-agent")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	run.Run.Out = filepath.Join(dir, "result.json")
+	spec, err := json.Marshal(run.Run)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(os.Args[0], run.Args...)
+	cmd.Env = append(os.Environ(), childRunEnv+"="+string(spec))
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.running[run.ID] = cmd
+	a.mu.Unlock()
+	err = cmd.Wait()
+	a.mu.Lock()
+	delete(a.running, run.ID)
+	a.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return os.ReadFile(run.Run.Out)
+}
+
+func (a *agent) interrupt(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cmd, ok := a.running[id]; ok {
+		cmd.Process.Signal(os.Interrupt)
+	}
+}
+
+// authorize refuses calls that don't carry the agent's token.
+func (a *agent) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, token := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(token), []byte("Bearer "+a.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "no valid agent token")
+}
+
+// agentCommand serves as a load generator agent until interrupted, finishing the shares it is running first.
+func agentCommand(args []string) error {
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:7070", "address to serve coordinators on; give a host of its own, or none, to serve other machines")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s agent [-listen address]\n\n"+
+			"Generates load for a coordinator started elsewhere with -agents, running the coordinator's arguments.\n"+
+			"Coordinators must present the token in $%s, which the agent's must be set to as well.\n", os.Args[0], agentTokenEnv)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	token := os.Getenv(agentTokenEnv)
+	if token == "" {
+		return fmt.Errorf("agent: set $%s to the token coordinators are to present", agentTokenEnv)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	a := &agent{running: map[string]*exec.Cmd{}, token: token}
+	server := grpc.NewServer()
+	server.RegisterService(&agentService, a)
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+	fmt.Printf("Agent listening on %s\n", ln.Addr())
+	return server.Serve(ln)
+}
+
+// callAgent has the agent at address run its share and returns what it measured. Cancelling ctx interrupts the share,
+// which still returns what it measured so far.
+func callAgent(ctx context.Context, address string, run agentRun) (*childResult, error) {
+	// A share returns every latency it measured, which for long runs is well past gRPC's default limit.
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(math.MaxInt32)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	spec, err := json.Marshal(run)
+	if err != nil {
+		return nil, err
+	}
+	callCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+os.Getenv(agentTokenEnv))
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Invoke(callCtx, agentInterruptMethod, wrapperspb.Bytes([]byte(run.ID)), &wrapperspb.BytesValue{})
+		case <-done:
+		}
+	}()
+	out := &wrapperspb.BytesValue{}
+	if err := conn.Invoke(callCtx, agentRunMethod, wrapperspb.Bytes(spec), out); err != nil {
+		return nil, err
+	}
+	var child childResult
+	if err := json.Unmarshal(out.Value, &child); err != nil {
+		return nil, err
+	}
+	return &child, nil
+}
+
+// runDistributed splits cfg's co-routines and requests over cfg.Agents and merges what they measured. Each agent
+// runs at its own GOMAXPROCS unless cfg sets one, and the run has every CPU they had between them.
+func runDistributed(ctx context.Context, cfg RunConfig, reporter Reporter) (BenchmarkResult, error) {
+	started := time.Now()
+	id := fmt.Sprintf("%d-%d", os.Getpid(), started.UnixNano())
+
+	var runs []childRun
+	var hosts []string
+	for i, run := range shareRun(cfg, int64(len(cfg.Agents))) {
+		if run.Coroutines > 0 && run.Iterations > 0 {
+			runs = append(runs, run)
+			hosts = append(hosts, cfg.Agents[i])
+		}
+	}
+	children := make([]*childResult, len(runs))
+	errs := make([]error, len(runs))
+	var wg sync.WaitGroup
+	for i := range runs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			children[i], errs[i] = callAgent(ctx, hosts[i], agentRun{ID: fmt.Sprintf("%s-%d", id, i), Args: cfg.childArgs, Run: runs[i]})
+		}(i)
+	}
+	wg.Wait()
+
+	runErr := ctx.Err()
+	var cpus float64
+	for i, child := range children {
+		if errs[i] != nil {
+			if runErr == nil {
+				runErr = fmt.Errorf("agent %s: %w", hosts[i], errs[i])
+			}
+			continue
+		}
+		if child.Err != "" && runErr == nil {
+			runErr = fmt.Errorf("agent %s: %s", hosts[i], child.Err)
+		}
+		cpus += child.EffectiveCPUs
+		if cfg.GOMAXPROCS == 0 {
+			cfg.GOMAXPROCS = child.GOMAXPROCS
+		}
+	}
+	// Only now that the agents have said what GOMAXPROCS they ran at, which reporters label the run by. Nothing is
+	// reported before: the samples come back with the shares and mergeChildren hands them on.
+	reporter.OnRunStart(cfg)
+	return mergeChildren(cfg, reporter, started, runs, children, hosts, cpus, runErr)
+}
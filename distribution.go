@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Distribution draws a duration around a configured mean, so the mean CPU and network times keep their meaning
+// when the per-request times are randomized.
+type Distribution struct {
+	Kind  string
+	Param float64
+}
+
+var distributionDefaults = map[string]float64{
+	"fixed":       0,
+	"uniform":     1,
+	"exponential": 0,
+	"lognormal":   1,
+	"pareto":      2,
+}
+
+// parseDistribution parses "kind" or "kind:param". The parameter is the relative half-width for uniform, sigma for
+// lognormal and the shape alpha for pareto.
+func parseDistribution(s string) (Distribution, error) {
+	kind, param := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, param = s[:i], s[i+1:]
+	}
+	if kind == "" {
+		kind = "fixed"
+	}
+	def, ok := distributionDefaults[kind]
+	if !ok {
+		return Distribution{}, fmt.Errorf("unknown distribution %q", kind)
+	}
+	d := Distribution{Kind: kind, Param: def}
+	if param != "" {
+		v, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return Distribution{}, fmt.Errorf("distribution %q: %w", s, err)
+		}
+		d.Param = v
+	}
+	switch {
+	case d.Kind == "uniform" && (d.Param < 0 || d.Param > 1):
+		return Distribution{}, fmt.Errorf("uniform half-width must be within [0, 1], got %v", d.Param)
+	case d.Kind == "lognormal" && d.Param <= 0:
+		return Distribution{}, fmt.Errorf("lognormal sigma must be positive, got %v", d.Param)
+	case d.Kind == "pareto" && d.Param <= 1:
+		return Distribution{}, fmt.Errorf("pareto alpha must be greater than 1 for a finite mean, got %v", d.Param)
+	}
+	return d, nil
+}
+
+func (d Distribution) String() string {
+	switch d.Kind {
+	case "", "fixed":
+		return "fixed"
+	case "exponential":
+		return d.Kind
+	}
+	return fmt.Sprintf("%s:%g", d.Kind, d.Param)
+}
+
+func (d Distribution) Sample(r *rand.Rand, mean time.Duration) time.Duration {
+	m := float64(mean)
+	switch d.Kind {
+	case "uniform":
+		return time.Duration(m * (1 - d.Param + 2*d.Param*r.Float64()))
+	case "exponential":
+		return time.Duration(m * r.ExpFloat64())
+	case "lognormal":
+		mu := math.Log(m) - d.Param*d.Param/2
+		return time.Duration(math.Exp(mu + d.Param*r.NormFloat64()))
+	case "pareto":
+		xm := m * (d.Param - 1) / d.Param
+		return time.Duration(xm / math.Pow(1-r.Float64(), 1/d.Param))
+	}
+	return mean
+}
+
+// SplitsDist draws how many network phases a request makes, for endpoints whose number of downstream calls varies.
+// Fixed has every request make RunConfig.Splits; uniform draws evenly from Min to Max; empirical draws Values in
+// proportion to their weights. Each phase takes the network time divided by the mean count, so that requests making
+// more calls spend longer on the network while the mean network time keeps its meaning; CPU time is shared out between
+// the phases whatever their number.
+type SplitsDist struct {
+	Kind       string // fixed, uniform or empirical
+	Min, Max   int
+	Values     []int
+	Cumulative []float64 // of the Values' weights, normalized to end at 1
+}
+
+// parseSplitsDist parses "fixed", "uniform:<min>-<max>" or "empirical:<splits>=<weight>,...".
+func parseSplitsDist(s string) (SplitsDist, error) {
+	kind, param := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, param = s[:i], s[i+1:]
+	}
+	switch kind {
+	case "", "fixed":
+		if param != "" {
+			return SplitsDist{}, fmt.Errorf("splits distribution %q: fixed takes no parameter; use -splits", s)
+		}
+		return SplitsDist{Kind: "fixed"}, nil
+	case "uniform":
+		i := strings.Index(param, "-")
+		if i < 0 {
+			return SplitsDist{}, fmt.Errorf("splits distribution %q: expected uniform:<min>-<max>", s)
+		}
+		min, err1 := strconv.Atoi(param[:i])
+		max, err2 := strconv.Atoi(param[i+1:])
+		if err1 != nil || err2 != nil || min < 0 || max < min || max == 0 {
+			return SplitsDist{}, fmt.Errorf("splits distribution %q: expected uniform:<min>-<max> with 0 <= min <= max and max > 0", s)
+		}
+		return SplitsDist{Kind: kind, Min: min, Max: max}, nil
+	case "empirical":
+		d := SplitsDist{Kind: kind}
+		total, weighted := 0.0, 0.0
+		for _, item := range strings.Split(param, ",") {
+			i := strings.Index(item, "=")
+			if i < 0 {
+				return SplitsDist{}, fmt.Errorf("splits distribution %q: expected empirical:<splits>=<weight>,...", s)
+			}
+			n, err1 := strconv.Atoi(item[:i])
+			w, err2 := strconv.ParseFloat(item[i+1:], 64)
+			if err1 != nil || err2 != nil || n < 0 || w <= 0 {
+				return SplitsDist{}, fmt.Errorf("splits distribution %q: invalid entry %q", s, item)
+			}
+			total += w
+			weighted += w * float64(n)
+			d.Values = append(d.Values, n)
+			d.Cumulative = append(d.Cumulative, total)
+		}
+		if weighted == 0 {
+			return SplitsDist{}, fmt.Errorf("splits distribution %q: requests must make some network phases on average", s)
+		}
+		for i := range d.Cumulative {
+			d.Cumulative[i] /= total
+		}
+		return d, nil
+	}
+	return SplitsDist{}, fmt.Errorf("unknown splits distribution %q", kind)
+}
+
+func (d SplitsDist) fixed() bool {
+	return d.Kind == "" || d.Kind == "fixed"
+}
+
+func (d SplitsDist) String() string {
+	switch d.Kind {
+	case "uniform":
+		return fmt.Sprintf("uniform:%d-%d", d.Min, d.Max)
+	case "empirical":
+		parts := make([]string, len(d.Values))
+		prev := 0.0
+		for i, n := range d.Values {
+			parts[i] = fmt.Sprintf("%d=%g", n, math.Round((d.Cumulative[i]-prev)*1e4)/1e4)
+			prev = d.Cumulative[i]
+		}
+		return "empirical:" + strings.Join(parts, ",")
+	}
+	return "fixed"
+}
+
+// Mean is how many network phases requests make on average, given the run's fixed count.
+func (d SplitsDist) Mean(splits int) float64 {
+	switch d.Kind {
+	case "uniform":
+		return float64(d.Min+d.Max) / 2
+	case "empirical":
+		mean, prev := 0.0, 0.0
+		for i, n := range d.Values {
+			mean += float64(n) * (d.Cumulative[i] - prev)
+			prev = d.Cumulative[i]
+		}
+		return mean
+	}
+	return float64(splits)
+}
+
+// Sample draws a request's number of network phases, given the run's fixed count. A fixed distribution draws nothing
+// from r.
+func (d SplitsDist) Sample(r *rand.Rand, splits int) int {
+	switch d.Kind {
+	case "uniform":
+		return d.Min + r.Intn(d.Max-d.Min+1)
+	case "empirical":
+		u := r.Float64()
+		for i, c := range d.Cumulative {
+			if u < c {
+				return d.Values[i]
+			}
+		}
+		return d.Values[len(d.Values)-1]
+	}
+	return splits
+}
+
+// phaseTime is how long each of a request's network phases takes, given its network time and the run's fixed count.
+func (d SplitsDist) phaseTime(networkTime time.Duration, splits int) time.Duration {
+	if d.fixed() {
+		return networkTime / time.Duration(splits)
+	}
+	return time.Duration(float64(networkTime) / d.Mean(splits))
+}
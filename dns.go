@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSWorkload adds a DNS lookup after every network phase, either against a built-in resolver on localhost that
+// answers every A query with 127.0.0.1 after Delay, or against the system's own resolvers. Go resolves names itself
+// or through the C library, by cgo, which runs every lookup on a thread of its own and caps how many run at once;
+// Resolver chooses which for the system's resolvers, while the built-in one can only be reached by Go's.
+type DNSWorkload struct {
+	Server   string // bundled or system
+	Resolver string // go or cgo, for the system's resolvers; empty for whichever Go picks
+	Name     string
+	Delay    time.Duration // before the built-in resolver answers
+
+	conn     net.PacketConn
+	resolver *net.Resolver
+}
+
+func (d *DNSWorkload) Start() error {
+	switch d.Server {
+	case "bundled":
+		if d.Resolver == "cgo" {
+			return fmt.Errorf("the cgo resolver only asks the system's resolvers; use -dns system")
+		}
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		d.conn = conn
+		go d.serve()
+		addr := conn.LocalAddr().String()
+		d.resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		}}
+	case "system":
+		switch d.Resolver {
+		case "go":
+			d.resolver = &net.Resolver{PreferGo: true}
+		case "cgo":
+			// There is no API for insisting on cgo; the setting is read at the process's first lookup, and child
+			// processes inherit it.
+			godebug := os.Getenv("GODEBUG")
+			if godebug != "" {
+				godebug += ","
+			}
+			if err := os.Setenv("GODEBUG", godebug+"netdns=cgo"); err != nil {
+				return err
+			}
+			d.resolver = net.DefaultResolver
+		case "":
+			d.resolver = net.DefaultResolver
+		default:
+			return fmt.Errorf("unknown DNS resolver %q, expected go or cgo", d.Resolver)
+		}
+	default:
+		return fmt.Errorf("unknown DNS server %q, expected bundled or system", d.Server)
+	}
+	// A first lookup checks the name resolves at all.
+	if _, err := d.resolver.LookupHost(context.Background(), d.Name); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *DNSWorkload) Close() error {
+	if d.conn == nil {
+		return nil
+	}
+	return d.conn.Close()
+}
+
+// serve answers every A query with 127.0.0.1 and any other with no records, each after the delay.
+func (d *DNSWorkload) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) == 0 {
+			continue
+		}
+		go func(msg dnsmessage.Message) {
+			q := msg.Questions[0]
+			msg.Header.Response, msg.Header.Authoritative, msg.Header.RCode = true, true, dnsmessage.RCodeSuccess
+			msg.Questions = msg.Questions[:1]
+			msg.Answers, msg.Authorities, msg.Additionals = nil, nil, nil
+			if q.Type == dnsmessage.TypeA {
+				msg.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				}}
+			}
+			reply, err := msg.Pack()
+			if err != nil {
+				return
+			}
+			time.Sleep(d.Delay)
+			d.conn.WriteTo(reply, addr)
+		}(msg)
+	}
+}
+
+func (d *DNSWorkload) String() string {
+	s := fmt.Sprintf("lookups of %s against ", d.Name)
+	if d.Server == "bundled" {
+		s += fmt.Sprintf("a built-in resolver answering after %v", d.Delay)
+	} else {
+		s += "the system's resolvers"
+	}
+	if d.Resolver != "" {
+		s += " by the " + d.Resolver + " resolver"
+	}
+	return s
+}
+
+// DNS lookups go over the network and so always run on wall-clock time.
+func doDNSWork(d *DNSWorkload, log *RequestLog) error {
+	log.Begin(log.now(realClock{}), "dns", "DNS lookup", 0)
+	addrs, err := d.resolver.LookupHost(context.Background(), d.Name)
+	if err != nil {
+		return err
+	}
+	end := log.now(realClock{})
+	if log.Enabled(LogDebug) {
+		log.Debugf(end, "%s resolved to %s", d.Name, strings.Join(addrs, ", "))
+	}
+	log.End(end)
+	return nil
+}
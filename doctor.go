@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// doctorCheck inspects one condition that can invalidate benchmark results. Run reports ok=false with a detail of
+// what is wrong, or skip=true when the condition cannot be determined on this machine.
+type doctorCheck struct {
+	Name   string
+	Weight int
+	Run    func() (ok, skip bool, detail string)
+}
+
+type doctorFinding struct {
+	Check  string
+	OK     bool
+	Skip   bool
+	Detail string
+}
+
+var doctorChecks = []doctorCheck{
+	{"cpu governor", 25, checkGovernor},
+	{"background load", 30, checkBackgroundLoad},
+	{"cpu quota", 25, checkCPUQuota},
+	{"power source", 10, checkPowerSource},
+	{"smt", 10, checkSMT},
+}
+
+// runDoctor runs every check and returns a readiness score from 0 to 100: the weighted share of the checks that could
+// be evaluated which passed.
+func runDoctor() (int, []doctorFinding) {
+	var passed, evaluated int
+	var findings []doctorFinding
+	for _, check := range doctorChecks {
+		ok, skip, detail := check.Run()
+		findings = append(findings, doctorFinding{Check: check.Name, OK: ok, Skip: skip, Detail: detail})
+		if skip {
+			continue
+		}
+		evaluated += check.Weight
+		if ok {
+			passed += check.Weight
+		}
+	}
+	if evaluated == 0 {
+		return 100, findings
+	}
+	return passed * 100 / evaluated, findings
+}
+
+func doctorCommand(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s doctor\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	score, findings := runDoctor()
+	for _, f := range findings {
+		status := "ok"
+		if f.Skip {
+			status = "skip"
+		} else if !f.OK {
+			status = "WARN"
+		}
+		fmt.Printf("%-4s  %-16s %s\n", status, f.Check, f.Detail)
+	}
+	fmt.Printf("Readiness: %d/100\n", score)
+	return nil
+}
+
+func readSysfsString(path string) (string, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(b)), true
+}
+
+func checkGovernor() (bool, bool, string) {
+	paths, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor")
+	if len(paths) == 0 {
+		return false, true, "cpufreq not available"
+	}
+	governors := map[string]int{}
+	for _, path := range paths {
+		if g, ok := readSysfsString(path); ok {
+			governors[g]++
+		}
+	}
+	if len(governors) == 1 && governors["performance"] > 0 {
+		return true, false, "performance on all cpus"
+	}
+	var parts []string
+	for g, n := range governors {
+		parts = append(parts, fmt.Sprintf("%s on %d cpus", g, n))
+	}
+	return false, false, strings.Join(parts, ", ") + "; set the performance governor for stable clocks"
+}
+
+func checkBackgroundLoad() (bool, bool, string) {
+	s, ok := readSysfsString("/proc/loadavg")
+	if !ok {
+		return false, true, "/proc/loadavg not available"
+	}
+	fields := strings.Fields(s)
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return false, true, "cannot parse /proc/loadavg"
+	}
+	limit := 0.25 * float64(runtime.NumCPU())
+	detail := fmt.Sprintf("1 minute load average %.2f on %d cpus", load, runtime.NumCPU())
+	return load <= limit, false, detail
+}
+
+func checkCPUQuota() (bool, bool, string) {
+	if limit, ok := cgroupCPULimit(); ok {
+		return false, false, fmt.Sprintf("cgroup limits cpu to %.2f cpus", limit)
+	}
+	return true, false, "no cgroup cpu limit"
+}
+
+func checkPowerSource() (bool, bool, string) {
+	supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+	var sawBattery bool
+	for _, dir := range supplies {
+		kind, _ := readSysfsString(filepath.Join(dir, "type"))
+		switch kind {
+		case "Mains":
+			if online, ok := readSysfsInt(filepath.Join(dir, "online")); ok && online == 1 {
+				return true, false, "on mains power"
+			}
+		case "Battery":
+			sawBattery = true
+		}
+	}
+	if sawBattery {
+		return false, false, "on battery power; plug in to avoid power-saving clock limits"
+	}
+	return false, true, "no power supply information"
+}
+
+func checkSMT() (bool, bool, string) {
+	active, ok := readSysfsInt("/sys/devices/system/cpu/smt/active")
+	if !ok {
+		return false, true, "smt status not available"
+	}
+	if active == 1 {
+		return false, false, "smt is on; sibling hyperthreads share a core, so per-cpu capacity is uneven"
+	}
+	return true, false, "smt is off"
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// raplZone is one package-level RAPL energy counter. The counter wraps around at maxRange.
+type raplZone struct {
+	energyPath string
+	maxRange   int64
+	start      int64
+}
+
+// energyMeter measures the CPU time the process uses during a run and, on Linux machines exposing Intel or AMD RAPL
+// through powercap, the energy the CPU packages draw. Energy is machine-wide, so other load on the machine counts
+// against the run.
+type energyMeter struct {
+	cpuStart time.Duration
+	zones    []raplZone
+}
+
+func startEnergyMeter() *energyMeter {
+	m := &energyMeter{cpuStart: processCPUTime()}
+	// Subzones such as intel-rapl:0:0 (cores) are already included in their package's counter.
+	paths, _ := filepath.Glob("/sys/class/powercap/intel-rapl:*")
+	for _, dir := range paths {
+		if strings.Count(filepath.Base(dir), ":") != 1 {
+			continue
+		}
+		energy, ok := readSysfsInt(filepath.Join(dir, "energy_uj"))
+		if !ok {
+			continue // since 5.10 only root may read the counters
+		}
+		maxRange, _ := readSysfsInt(filepath.Join(dir, "max_energy_range_uj"))
+		m.zones = append(m.zones, raplZone{filepath.Join(dir, "energy_uj"), maxRange, energy})
+	}
+	return m
+}
+
+// Stop returns the CPU time used since the meter started and the energy drawn in joules, zero where RAPL is not
+// available.
+func (m *energyMeter) Stop() (time.Duration, float64) {
+	cpu := processCPUTime() - m.cpuStart
+	var microjoules int64
+	for _, z := range m.zones {
+		end, ok := readSysfsInt(z.energyPath)
+		if !ok {
+			return cpu, 0
+		}
+		if end < z.start {
+			end += z.maxRange
+		}
+		microjoules += end - z.start
+	}
+	return cpu, float64(microjoules) / 1e6
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// Executor runs requests on goroutines. Go blocks the caller while the executor is at capacity, which is what makes
+// a closed-loop run closed, and reports whether it took fn, which it doesn't once the run is cancelled while it
+// waits; Wait blocks until every function it took has returned and releases the executor.
+// Only the errgroup executor acts on the errors functions return: the others just count them as failed requests.
+type Executor interface {
+	Go(fn func() error) bool
+	Wait() error
+}
+
+// cancellingExecutor is implemented by executors that abort the run on the first error. Requests must be issued
+// under the returned context so that the abort reaches them.
+type cancellingExecutor interface {
+	Context() context.Context
+}
+
+var executorNames = []string{"semaphore", "channel", "pool", "errgroup", "unbounded", "lockosthread", "pinnedpool", "aimd", "gradient"}
+
+func newExecutor(ctx context.Context, kind string, limit int64) (Executor, error) {
+	if limit <= 0 && kind != "unbounded" {
+		return nil, fmt.Errorf("%s executor needs a positive limit, got %d", kind, limit)
+	}
+	switch kind {
+	case "", "semaphore":
+		return &semaphoreExecutor{ctx: ctx, sem: semaphore.NewWeighted(limit)}, nil
+	case "channel":
+		return &channelExecutor{ctx: ctx, tokens: make(chan struct{}, limit)}, nil
+	case "pool":
+		return newPoolExecutor(int(limit), false), nil
+	case "pinnedpool":
+		return newPoolExecutor(int(limit), true), nil
+	case "errgroup":
+		g, ctx := errgroup.WithContext(ctx)
+		g.SetLimit(int(limit))
+		return errgroupExecutor{g, ctx}, nil
+	case "unbounded":
+		return &unboundedExecutor{}, nil
+	case "lockosthread":
+		return &semaphoreExecutor{ctx: ctx, sem: semaphore.NewWeighted(limit), lockOSThread: true}, nil
+	case "aimd", "gradient":
+		return newAdaptiveExecutor(ctx, kind, limit), nil
+	}
+	return nil, fmt.Errorf("unknown executor %q", kind)
+}
+
+// semaphoreExecutor starts a goroutine per request once a slot in the weighted semaphore frees up. With lockOSThread
+// set each request holds its OS thread for its whole lifetime, modelling thread-per-request servers.
+type semaphoreExecutor struct {
+	ctx          context.Context
+	sem          *semaphore.Weighted
+	lockOSThread bool
+	wg           sync.WaitGroup
+}
+
+func (e *semaphoreExecutor) Go(fn func() error) bool {
+	if err := e.sem.Acquire(e.ctx, 1); err != nil {
+		return false // the run was cancelled while waiting for a slot
+	}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		defer e.sem.Release(1)
+		if e.lockOSThread {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+		}
+		fn()
+	}()
+	return true
+}
+
+func (e *semaphoreExecutor) Wait() error {
+	e.wg.Wait()
+	return nil
+}
+
+// channelExecutor bounds concurrency with a buffered channel of tokens, the idiom hand-written Go reaches for instead
+// of a semaphore: a request takes a token by sending into the channel before its goroutine starts and gives it back
+// by receiving once it returns.
+type channelExecutor struct {
+	ctx    context.Context
+	tokens chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (e *channelExecutor) Go(fn func() error) bool {
+	select {
+	case e.tokens <- struct{}{}:
+	case <-e.ctx.Done():
+		return false // the run was cancelled while waiting for a token
+	}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		defer func() { <-e.tokens }()
+		fn()
+	}()
+	return true
+}
+
+func (e *channelExecutor) Wait() error {
+	e.wg.Wait()
+	return nil
+}
+
+// poolExecutor hands requests to a fixed set of long-lived workers over an unbuffered channel. With pinned set every
+// worker locks itself to an OS thread for its whole life, giving a classic thread-per-worker server on the same
+// workload, to contrast with goroutines multiplexed over GOMAXPROCS threads.
+type poolExecutor struct {
+	work  chan func() error
+	wg    sync.WaitGroup
+	loads []workerLoad // by worker
+}
+
+func newPoolExecutor(workers int, pinned bool) *poolExecutor {
+	e := &poolExecutor{work: make(chan func() error), loads: make([]workerLoad, workers)}
+	for i := 0; i < workers; i++ {
+		e.wg.Add(1)
+		load := &e.loads[i]
+		go func() {
+			defer e.wg.Done()
+			if pinned {
+				runtime.LockOSThread()
+				defer runtime.UnlockOSThread()
+			}
+			for fn := range e.work {
+				start := time.Now()
+				fn()
+				load.requests++
+				load.busy += time.Since(start)
+			}
+		}()
+	}
+	return e
+}
+
+// fairness is how evenly the workers shared the requests, once Wait has returned.
+func (e *poolExecutor) fairness() *WorkerFairness {
+	return workerFairness(e.loads)
+}
+
+func (e *poolExecutor) Go(fn func() error) bool {
+	e.work <- fn
+	return true
+}
+
+func (e *poolExecutor) Wait() error {
+	close(e.work)
+	e.wg.Wait()
+	return nil
+}
+
+// errgroupExecutor bounds concurrency with errgroup.Group.SetLimit. The first request to fail cancels the group's
+// context, which stops the run, and is returned from Wait.
+type errgroupExecutor struct {
+	g   *errgroup.Group
+	ctx context.Context
+}
+
+func (e errgroupExecutor) Go(fn func() error) bool {
+	e.g.Go(fn)
+	return true
+}
+
+func (e errgroupExecutor) Wait() error {
+	return e.g.Wait()
+}
+
+func (e errgroupExecutor) Context() context.Context {
+	return e.ctx
+}
+
+type unboundedExecutor struct {
+	wg sync.WaitGroup
+}
+
+func (e *unboundedExecutor) Go(fn func() error) bool {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		fn()
+	}()
+	return true
+}
+
+func (e *unboundedExecutor) Wait() error {
+	e.wg.Wait()
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestExecutorsOnVirtualClock runs requests that each sleep 10ms on a virtualClock through the bounded executors, two
+// at a time, moving the clock a wave of requests at a time: every executor must hold concurrency to its limit, start
+// the requests in the order they were submitted, a wave every 10ms, and run them all.
+func TestExecutorsOnVirtualClock(t *testing.T) {
+	const (
+		limit    = 2
+		requests = 7
+		work     = 10 * time.Millisecond
+	)
+	for _, kind := range []string{"semaphore", "pool", "errgroup"} {
+		t.Run(kind, func(t *testing.T) {
+			start := time.Unix(0, 0)
+			clock := newVirtualClock(start)
+			e, err := newExecutor(context.Background(), kind, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var mu sync.Mutex
+			running, most := 0, 0
+			started := make([]time.Duration, requests)
+			done := make(chan error, 1)
+			go func() {
+				for i := 0; i < requests; i++ {
+					i := i
+					e.Go(func() error {
+						mu.Lock()
+						started[i] = clock.Now().Sub(start)
+						if running++; running > most {
+							most = running
+						}
+						mu.Unlock()
+						clock.Sleep(work)
+						mu.Lock()
+						running--
+						mu.Unlock()
+						return nil
+					})
+				}
+				done <- e.Wait()
+			}()
+
+			for left := requests; left > 0; left -= limit {
+				wave := limit
+				if left < wave {
+					wave = left
+				}
+				clock.BlockUntil(wave)
+				clock.Advance(work)
+			}
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+
+			if most != limit {
+				t.Errorf("at most %d requests ran at once, want %d", most, limit)
+			}
+			for i, at := range started {
+				if want := time.Duration(i/limit) * work; at != want {
+					t.Errorf("request %d started at %v, want %v", i, at, want)
+				}
+			}
+			if elapsed, want := clock.Now().Sub(start), time.Duration((requests+limit-1)/limit)*work; elapsed != want {
+				t.Errorf("requests took %v of virtual time, want %v", elapsed, want)
+			}
+		})
+	}
+}
+
+// TestPoolExecutorFairness checks that a pool counts every request it ran against the worker that ran it.
+func TestPoolExecutorFairness(t *testing.T) {
+	e := newPoolExecutor(4, false)
+	for i := 0; i < 100; i++ {
+		e.Go(func() error { return nil })
+	}
+	e.Wait()
+	f := e.fairness()
+	if f == nil || f.Workers != 4 {
+		t.Fatalf("fairness %+v, want 4 workers", f)
+	}
+	total := 0
+	for _, l := range e.loads {
+		total += l.requests
+	}
+	if total != 100 {
+		t.Errorf("workers ran %d requests, want 100", total)
+	}
+}
+
+// TestExecutorsRefuseOnceCancelled checks that the bounded executors report a function they never took, so the run
+// doesn't count it as submitted.
+func TestExecutorsRefuseOnceCancelled(t *testing.T) {
+	for _, kind := range []string{"semaphore", "channel", "aimd"} {
+		t.Run(kind, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			e, err := newExecutor(ctx, kind, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			release := make(chan struct{})
+			if !e.Go(func() error { <-release; return nil }) {
+				t.Fatal("the first function was refused with the executor idle")
+			}
+			cancel()
+			if e.Go(func() error { return nil }) {
+				t.Error("a function was taken with the executor full and the run cancelled")
+			}
+			close(release)
+			e.Wait()
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ExecWorkload replaces a request's simulated work with running an external command, CGI-style: every request forks
+// and execs it and waits for it to exit, so that designs paying for a process per request can be compared with the
+// goroutine-based ones. A command that exits non-zero fails its request, and the run counts the exit statuses.
+type ExecWorkload struct {
+	Command string
+	Shell   bool // run Command with sh -c rather than splitting it into arguments on spaces
+}
+
+func (w *ExecWorkload) command() *exec.Cmd {
+	if w.Shell {
+		return exec.Command("sh", "-c", w.Command)
+	}
+	args := strings.Fields(w.Command)
+	return exec.Command(args[0], args[1:]...)
+}
+
+// Calibrate returns the median time one request takes on an idle machine, to serve as the run's WorkTime so that CPU
+// utilization and speedup keep their meaning.
+func (w *ExecWorkload) Calibrate() (time.Duration, error) {
+	var samples []time.Duration
+	for i := 0; i < 9; i++ {
+		start := time.Now()
+		// A command that fails some of the time still times; only one that can't be run at all is an error.
+		if err := w.command().Run(); err != nil && execStatus(err) < 0 {
+			return 0, fmt.Errorf("%s: %w", w.Command, err)
+		}
+		samples = append(samples, time.Since(start))
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[len(samples)/2], nil
+}
+
+func (w *ExecWorkload) String() string {
+	if w.Shell {
+		return "sh -c " + w.Command
+	}
+	return w.Command
+}
+
+// Commands do real work in processes of their own and so always run on wall-clock time.
+func doExecWork(w *ExecWorkload, log *RequestLog) error {
+	log.Begin(log.now(realClock{}), "exec", "exec", 0)
+	err := w.command().Run()
+	end := log.now(realClock{})
+	if log.Enabled(LogDebug) {
+		log.Debugf(end, "%s: %v", w, execStatus(err))
+	}
+	log.End(end)
+	return err
+}
+
+// execStatus is how a command that returned err exited: 0 for success, its exit status if it ran and failed, and
+// -1 if it could not be run or was killed by a signal.
+func execStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode()
+	}
+	return -1
+}
+
+func outputExitStatuses(result BenchmarkResult) {
+	if result.Config.Exec == nil {
+		return
+	}
+	fmt.Printf("\tCommand: %v\n", result.Config.Exec)
+	if len(result.ExitStatuses) == 0 {
+		return
+	}
+	statuses := make([]int, 0, len(result.ExitStatuses))
+	for status := range result.ExitStatuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	parts := make([]string, len(statuses))
+	for i, status := range statuses {
+		name := fmt.Sprintf("status %d", status)
+		if status < 0 {
+			name = "not run or killed"
+		}
+		parts[i] = fmt.Sprintf("%s ×%s", name, formatCount(int64(result.ExitStatuses[status])))
+	}
+	fmt.Printf("\tExited non-zero: %s\n", strings.Join(parts, ", "))
+}
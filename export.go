@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tsdbPoint is a measurement at a moment, as a time-series database stores it: named, keyed by tags and holding one
+// or more fields.
+type tsdbPoint struct {
+	measurement string
+	tags        [][2]string
+	fields      [][2]string // values already formatted
+	time        time.Time
+}
+
+// tsdbPoints describes a run as the points an exporter pushes: a perf_run point summarising it, at its start, then a
+// perf_throughput point per interval of its throughput series and a perf_latency point per request of its latency
+// trace. Every point carries the run's configuration as tags, every grid dimension among them whether swept or not,
+// so that a series stays one series from sweep to sweep.
+func tsdbPoints(result BenchmarkResult) []tsdbPoint {
+	cfg := result.Config
+	tags := [][2]string{
+		{"executor", cfg.Executor},
+		{"gomaxprocs", strconv.Itoa(cfg.GOMAXPROCS)},
+		{"coroutines", strconv.FormatInt(result.NumCoroutines, 10)},
+	}
+	if cfg.Load.Kind != "" {
+		tags = append(tags, [2]string{"load", cfg.Load.String()})
+	}
+	for _, d := range gridDims {
+		if v := d.value(cfg); v != "" {
+			tags = append(tags, [2]string{d.file, v})
+		}
+	}
+	if cfg.Repetition > 0 {
+		tags = append(tags, [2]string{"repetition", strconv.Itoa(cfg.Repetition)})
+	}
+	if result.Host != nil {
+		if result.Host.Hostname != "" {
+			tags = append(tags, [2]string{"host", result.Host.Hostname})
+		}
+		if result.Host.ToolCommit != "" {
+			tags = append(tags, [2]string{"commit", result.Host.ToolCommit})
+		}
+	}
+	var keys []string
+	for k := range cfg.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if cfg.Tags[k] != "" { // neither database takes an empty tag
+			tags = append(tags, [2]string{k, cfg.Tags[k]})
+		}
+	}
+
+	float := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	integer := func(v int64) string { return strconv.FormatInt(v, 10) + "i" }
+	summary := tsdbPoint{measurement: "perf_run", tags: tags, time: result.Started, fields: [][2]string{
+		{"throughput_rps", float(result.ThroughputRps)},
+		{"speedup", float(result.Speedup)},
+		{"cpu_utilization", float(result.CpuUtilization)},
+		{"requests", integer(int64(result.Iterations))},
+		{"errors", integer(int64(result.Errors))},
+		{"timeouts", integer(int64(result.Timeouts))},
+		{"failed", strconv.FormatBool(result.Failure != nil)},
+	}}
+	if result.ResponseTimes != nil && result.ResponseTimes.Count() > 0 {
+		for _, pct := range cfg.percentiles() {
+			summary.fields = append(summary.fields, [2]string{percentileName(pct) + "_ms", float(result.ResponseTimesPercentile(pct))})
+		}
+	}
+	points := []tsdbPoint{summary}
+	for i, rps := range result.ThroughputSeries {
+		points = append(points, tsdbPoint{measurement: "perf_throughput", tags: tags,
+			time: result.Started.Add(time.Duration(i+1) * cfg.ThroughputInterval), fields: [][2]string{{"rps", float(rps)}}})
+	}
+	for _, p := range result.LatencyTrace {
+		points = append(points, tsdbPoint{measurement: "perf_latency", tags: tags,
+			time:   result.Started.Add(time.Duration(p.StartMs * float64(time.Millisecond))),
+			fields: [][2]string{{"latency_ms", float(p.LatencyMs)}, {"timed_out", strconv.FormatBool(p.TimedOut)}}})
+	}
+	return points
+}
+
+// influxReporter writes each run's points to InfluxDB in its line protocol once the run completes. url is the full
+// write endpoint, with its database or bucket, e.g. http://localhost:8086/api/v2/write?org=perf&bucket=bench or
+// http://localhost:8086/write?db=perf, and the INFLUX_TOKEN environment variable, if set, authorizes the writes.
+type influxReporter struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newInfluxReporter(url string) *influxReporter {
+	return &influxReporter{url: url, token: os.Getenv("INFLUX_TOKEN"), client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (r *influxReporter) OnRunStart(cfg RunConfig) {}
+
+func (r *influxReporter) OnSample(sample Sample) {}
+
+func (r *influxReporter) OnRunComplete(result BenchmarkResult) error {
+	var body bytes.Buffer
+	for _, p := range tsdbPoints(result) {
+		body.WriteString(influxEscape(p.measurement, ", "))
+		for _, t := range p.tags {
+			body.WriteString("," + influxEscape(t[0], ",= ") + "=" + influxEscape(t[1], ",= "))
+		}
+		for i, f := range p.fields {
+			sep := ","
+			if i == 0 {
+				sep = " "
+			}
+			body.WriteString(sep + influxEscape(f[0], ",= ") + "=" + f[1])
+		}
+		fmt.Fprintf(&body, " %d\n", p.time.UnixNano())
+	}
+	req, err := http.NewRequest(http.MethodPost, r.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Token "+r.token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("-influx: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("-influx: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (r *influxReporter) Close() error { return nil }
+
+// influxEscape backslash-escapes the characters of special that line protocol gives meaning to where s goes.
+func influxEscape(s, special string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(special, c) || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// graphiteReporter sends each run's points to Graphite's plaintext listener once the run completes, as tagged
+// series, "perf_run.throughput_rps;executor=pool;... 1234.5 1700000000", which needs Graphite 1.1 or later. Graphite
+// keeps time to the second, so its storage schema decides what becomes of the latency trace's many points a second.
+type graphiteReporter struct {
+	addr   string
+	prefix string
+}
+
+func (r *graphiteReporter) OnRunStart(cfg RunConfig) {}
+
+func (r *graphiteReporter) OnSample(sample Sample) {}
+
+func (r *graphiteReporter) OnRunComplete(result BenchmarkResult) error {
+	var body bytes.Buffer
+	for _, p := range tsdbPoints(result) {
+		var tags strings.Builder
+		for _, t := range p.tags {
+			tags.WriteString(";" + graphiteEscape(t[0]) + "=" + graphiteEscape(t[1]))
+		}
+		for _, f := range p.fields {
+			value := strings.TrimSuffix(f[1], "i")
+			switch value {
+			case "true":
+				value = "1"
+			case "false":
+				value = "0"
+			}
+			fmt.Fprintf(&body, "%s%s.%s%s %s %d\n", r.prefix, p.measurement, f[0], tags.String(), value, p.time.Unix())
+		}
+	}
+	// A connection a run: one held open over a long sweep would be closed under it.
+	conn, err := net.DialTimeout("tcp", r.addr, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("-graphite: %w", err)
+	}
+	if _, err := body.WriteTo(conn); err != nil {
+		conn.Close()
+		return fmt.Errorf("-graphite: %w", err)
+	}
+	return conn.Close()
+}
+
+func (r *graphiteReporter) Close() error { return nil }
+
+// graphiteEscape replaces what Graphite doesn't allow in tags, and spaces, which end a metric, with underscores.
+func graphiteEscape(s string) string {
+	return strings.Map(func(c rune) rune {
+		if strings.ContainsRune(" ;!^=~", c) {
+			return '_'
+		}
+		return c
+	}, s)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// ExternalHTTPBackend spends every network phase on a request to a service of the user's, turning the sweep into a
+// concurrency sweep of that service with all the same reports. The URL, headers and body are text/templates, given an
+// externalCall, so that requests can vary; the phase takes as long as the service does, the configured network time
+// only reaching it through the templates. Responses of 400 and up fail the phase.
+type ExternalHTTPBackend struct {
+	URL          string
+	Method       string
+	Headers      []string // "Name: value"
+	Body         string
+	MaxIdleConns int
+
+	url     *template.Template
+	body    *template.Template
+	headers []externalHeader
+	client  *http.Client
+	calls   int64
+}
+
+// externalCall is what the templates of an external request are executed with.
+type externalCall struct {
+	Call    int64         // numbers the run's calls from 0
+	Delay   time.Duration // the network time the phase would have spent
+	DelayMs float64
+}
+
+type externalHeader struct {
+	name  string
+	value *template.Template
+}
+
+// HeaderFlags is a flag.Value, so that -url-header can be repeated.
+type HeaderFlags []string
+
+func (h *HeaderFlags) Set(s string) error {
+	if !strings.Contains(s, ":") {
+		return fmt.Errorf("invalid header %q, expected Name: value", s)
+	}
+	*h = append(*h, s)
+	return nil
+}
+
+func (h *HeaderFlags) String() string {
+	if h == nil {
+		return ""
+	}
+	return strings.Join(*h, ", ")
+}
+
+func (e *ExternalHTTPBackend) Start() error {
+	var err error
+	if e.url, err = template.New("url").Parse(e.URL); err != nil {
+		return fmt.Errorf("-url: %w", err)
+	}
+	if e.body, err = template.New("body").Parse(e.Body); err != nil {
+		return fmt.Errorf("-url-body: %w", err)
+	}
+	for _, h := range e.Headers {
+		colon := strings.Index(h, ":")
+		value, err := template.New(h[:colon]).Parse(strings.TrimSpace(h[colon+1:]))
+		if err != nil {
+			return fmt.Errorf("-url-header %q: %w", h, err)
+		}
+		e.headers = append(e.headers, externalHeader{name: strings.TrimSpace(h[:colon]), value: value})
+	}
+	// A first request checks the URL and warms up a connection, as the built-in backends' servers are warm.
+	req, err := e.request(externalCall{Call: -1})
+	if err != nil {
+		return err
+	}
+	e.client = &http.Client{Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        e.MaxIdleConns,
+		MaxIdleConnsPerHost: e.MaxIdleConns,
+	}}
+	if err := e.do(req); err != nil {
+		return fmt.Errorf("checking %s: %w", e.URL, err)
+	}
+	return nil
+}
+
+func (e *ExternalHTTPBackend) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+func (e *ExternalHTTPBackend) request(call externalCall) (*http.Request, error) {
+	var url, body bytes.Buffer
+	if err := e.url.Execute(&url, call); err != nil {
+		return nil, err
+	}
+	if err := e.body.Execute(&body, call); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(e.Method, url.String(), &body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range e.headers {
+		var value strings.Builder
+		if err := h.value.Execute(&value, call); err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(h.name, "Host") {
+			req.Host = value.String()
+			continue
+		}
+		req.Header.Add(h.name, value.String())
+	}
+	return req, nil
+}
+
+func (e *ExternalHTTPBackend) do(req *http.Request) error {
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+func (e *ExternalHTTPBackend) Call(networkTime time.Duration, log *RequestLog) error {
+	call := externalCall{
+		Call:    atomic.AddInt64(&e.calls, 1) - 1,
+		Delay:   networkTime,
+		DelayMs: float64(networkTime) / float64(time.Millisecond),
+	}
+	req, err := e.request(call)
+	if err != nil {
+		return err
+	}
+	log.Begin(log.now(realClock{}), "network", "request to "+req.URL.Host, networkTime)
+	if err := e.do(req); err != nil {
+		return err
+	}
+	log.End(log.now(realClock{}))
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// FailureKind classifies why a run's result should not be read as a clean measurement.
+type FailureKind string
+
+const (
+	FailureInterrupted     FailureKind = "interrupted"      // stopped by SIGINT or SIGTERM
+	FailureAborted         FailureKind = "aborted"          // a request error aborted the run
+	FailureResourceCeiling FailureKind = "resource_ceiling" // memory or file descriptors exceeded their ceiling
+	FailureStall           FailureKind = "stall"            // no request completed within the stall timeout
+	FailureErrorRate       FailureKind = "error_rate"       // more requests failed than allowed
+	FailureTimeoutRate     FailureKind = "timeout_rate"     // more requests missed their deadline than allowed
+)
+
+type Failure struct {
+	Kind   FailureKind `json:"kind"`
+	Detail string      `json:"detail"`
+}
+
+func (f Failure) String() string {
+	return fmt.Sprintf("%s: %s", f.Kind, f.Detail)
+}
+
+// classifyFailure returns why result failed, or nil if it ran to completion within the configured thresholds.
+func classifyFailure(result BenchmarkResult) *Failure {
+	cfg := result.Config
+	if result.Err != nil {
+		var ceiling *CeilingExceeded
+		var stall *StallError
+		kind := FailureAborted
+		switch {
+		case errors.As(result.Err, &ceiling):
+			kind = FailureResourceCeiling
+		case errors.As(result.Err, &stall):
+			kind = FailureStall
+		case errors.Is(result.Err, context.Canceled):
+			kind = FailureInterrupted
+		}
+		return &Failure{kind, result.Err.Error()}
+	}
+	if result.Iterations == 0 {
+		return nil
+	}
+	if errorRate := float64(result.Errors) / float64(result.Iterations); cfg.MaxErrorRate > 0 && errorRate > cfg.MaxErrorRate {
+		return &Failure{FailureErrorRate, fmt.Sprintf("%.2f%% of requests failed, more than %.2f%%", errorRate*100, cfg.MaxErrorRate*100)}
+	}
+	if timeoutRate := result.TimeoutRate(); cfg.MaxTimeoutRate > 0 && timeoutRate > cfg.MaxTimeoutRate {
+		return &Failure{FailureTimeoutRate, fmt.Sprintf("%.2f%% of requests timed out, more than %.2f%%", timeoutRate*100, cfg.MaxTimeoutRate*100)}
+	}
+	return nil
+}
+
+// StallError is the Err of a run abandoned by its watchdog.
+type StallError struct {
+	Timeout  time.Duration
+	InFlight int64
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("no request completed for %v with %d in flight", e.Timeout, e.InFlight)
+}
+
+// watchdog abandons a run in which requests are in flight but none has completed for the timeout, wall-clock, so
+// that a deadlocked or wedged workload fails the run instead of hanging the sweep.
+type watchdog struct {
+	timeout   time.Duration
+	inFlight  int64
+	completed int64
+	stalled   chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	err       *StallError
+}
+
+// startWatchdog returns nil when timeout is zero; all methods are safe to call on nil.
+func startWatchdog(timeout time.Duration, abort func()) *watchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	w := &watchdog{timeout: timeout, stalled: make(chan struct{}), stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(timeout / 4)
+		defer ticker.Stop()
+		last, lastProgress := int64(-1), time.Now()
+		for {
+			select {
+			case now := <-ticker.C:
+				completed, inFlight := atomic.LoadInt64(&w.completed), atomic.LoadInt64(&w.inFlight)
+				if completed != last || inFlight == 0 {
+					last, lastProgress = completed, now
+				} else if now.Sub(lastProgress) >= timeout {
+					w.err = &StallError{timeout, inFlight}
+					abort()
+					close(w.stalled)
+					return
+				}
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+func (w *watchdog) begin() {
+	if w != nil {
+		atomic.AddInt64(&w.inFlight, 1)
+	}
+}
+
+func (w *watchdog) end() {
+	if w != nil {
+		atomic.AddInt64(&w.inFlight, -1)
+		atomic.AddInt64(&w.completed, 1)
+	}
+}
+
+// Stalled is closed once the watchdog gives up on the run.
+func (w *watchdog) Stalled() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.stalled
+}
+
+// Stop ends monitoring and returns the stall that abandoned the run, if any.
+func (w *watchdog) Stop() error {
+	if w == nil {
+		return nil
+	}
+	close(w.stop)
+	<-w.done
+	if w.err == nil {
+		return nil
+	}
+	return w.err
+}
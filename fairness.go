@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// workerLoad is what one worker of a fixed pool handled over a run: the requests it ran and the time it spent running
+// them. Each worker writes only its own, and it is read once the pool's Wait has returned.
+type workerLoad struct {
+	requests int
+	busy     time.Duration
+}
+
+// WorkerFairness is how evenly a fixed pool's workers shared a run's requests. The pool's channel hands each request
+// to whichever idle worker the scheduler wakes first, which is not necessarily the one that has waited longest, so at
+// high concurrency some workers can take far more than an even share while others sit idle. The CVs are the
+// coefficients of variation of the requests and busy time per worker, zero for a perfectly even split; the shares are
+// the least and most any one worker took of the run's requests, against an even share of 1/Workers.
+type WorkerFairness struct {
+	Workers      int     `json:"workers"`
+	RequestsCV   float64 `json:"requests_cv"`
+	BusyCV       float64 `json:"busy_cv"`
+	MinShare     float64 `json:"min_share"`
+	MaxShare     float64 `json:"max_share"`
+	IdleWorkers  int     `json:"idle_workers,omitempty"` // ran no requests at all
+	MinBusyShare float64 `json:"min_busy_share"`
+	MaxBusyShare float64 `json:"max_busy_share"`
+	MinRequests  int     `json:"min_requests"` // by any one worker
+	MaxRequests  int     `json:"max_requests"`
+}
+
+// workerFairness summarizes loads, one per worker, or returns nil for a pool that ran nothing.
+func workerFairness(loads []workerLoad) *WorkerFairness {
+	total, busy := 0, time.Duration(0)
+	for _, l := range loads {
+		total += l.requests
+		busy += l.busy
+	}
+	if len(loads) == 0 || total == 0 || busy <= 0 {
+		return nil
+	}
+	f := &WorkerFairness{Workers: len(loads), MinShare: 1, MinBusyShare: 1, MinRequests: total}
+	requests, busyTimes := make([]float64, len(loads)), make([]float64, len(loads))
+	for i, l := range loads {
+		requests[i], busyTimes[i] = float64(l.requests), float64(l.busy)
+		share, busyShare := float64(l.requests)/float64(total), float64(l.busy)/float64(busy)
+		f.MinShare, f.MaxShare = math.Min(f.MinShare, share), math.Max(f.MaxShare, share)
+		f.MinBusyShare, f.MaxBusyShare = math.Min(f.MinBusyShare, busyShare), math.Max(f.MaxBusyShare, busyShare)
+		if l.requests < f.MinRequests {
+			f.MinRequests = l.requests
+		}
+		if l.requests > f.MaxRequests {
+			f.MaxRequests = l.requests
+		}
+		if l.requests == 0 {
+			f.IdleWorkers++
+		}
+	}
+	f.RequestsCV, f.BusyCV = coefficientOfVariation(requests), coefficientOfVariation(busyTimes)
+	return f
+}
+
+func outputFairness(result BenchmarkResult) {
+	f := result.Fairness
+	if f == nil {
+		return
+	}
+	even := 1 / float64(f.Workers)
+	fmt.Printf("\tWorker fairness: %s workers ran %s to %s requests each (%s× to %s× an even share), CV %s; busy time CV %s, %s× to %s× an even share",
+		formatCount(int64(f.Workers)), formatCount(int64(f.MinRequests)), formatCount(int64(f.MaxRequests)),
+		formatFloat(f.MinShare/even, 2), formatFloat(f.MaxShare/even, 2), formatFloat(f.RequestsCV, 3),
+		formatFloat(f.BusyCV, 3), formatFloat(f.MinBusyShare/even, 2), formatFloat(f.MaxBusyShare/even, 2))
+	if f.IdleWorkers > 0 {
+		fmt.Printf("; %s never ran a request", formatCount(int64(f.IdleWorkers)))
+	}
+	fmt.Println()
+}
+
+// plotFairness draws the coefficient of variation of the requests each pool worker ran against the co-routine count,
+// for sweeps with a fixed worker pool: a line rising with the count is the pool's handoff favoring some workers over
+// others as it grows.
+func plotFairness(results []BenchmarkResult, opts PlotOptions) error {
+	found := false
+	for _, r := range results {
+		found = found || r.Fairness != nil
+	}
+	if !found {
+		return nil
+	}
+	plt := plot.New()
+	plt.Title.Text = "Worker Fairness vs. Number of Co-Routines"
+	plt.X.Label.Text = "Number of Co-Routines"
+	plt.Y.Label.Text = "CV of requests per worker"
+	labels, series := bySeries(results)
+	color := 0
+	for _, label := range labels {
+		if series[label][0].Fairness == nil {
+			continue // not a pool
+		}
+		line, err := sweepLine(plt, series[label], func(r BenchmarkResult) float64 {
+			if r.Fairness == nil {
+				return math.NaN()
+			}
+			return r.Fairness.RequestsCV
+		}, plotutil.Color(color))
+		color++
+		if err != nil {
+			return err
+		}
+		if line != nil && len(labels) > 1 {
+			plt.Legend.Add(label, line)
+		}
+	}
+	plt.Y.Min = 0
+	plt.Legend.Top = true
+	plt.Legend.Left = true
+	opts.fitCoroutines(&plt.X)
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("worker_fairness_vs_coroutines.png"))
+}
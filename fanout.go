@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// doFanOutPhase spends a network phase as a scatter-gather service does: cfg.FanOut downstream calls made in
+// parallel, at most cfg.FanOutLimit of them in flight at once (all of them for zero), and the phase over when the last
+// returns. Each call draws its own network time around phaseTime, so the phase takes as long as the slowest of them
+// and a heavy-tailed -network-dist shows up in far more requests than one call in a hundred. Each call is a network
+// phase of its own, retried, broken and faulted as cfg says, and the first to fail fails the request once all are
+// back.
+func doFanOutPhase(cfg RunConfig, r *rand.Rand, phaseTime time.Duration, log *RequestLog) error {
+	clock := cfg.clock()
+	calls := make([]struct {
+		d   time.Duration
+		r   *rand.Rand
+		err error
+	}, cfg.FanOut)
+	// The request's random stream is not safe to share, so each call's draws are made here, in order.
+	for i := range calls {
+		calls[i].d = cfg.NetworkDist.Sample(r, phaseTime)
+		calls[i].r = rand.New(&splitMix64{state: r.Uint64()})
+	}
+	limit := cfg.FanOutLimit
+	if limit <= 0 || limit > cfg.FanOut {
+		limit = cfg.FanOut
+	}
+	log.Begin(log.now(clock), "network", fmt.Sprintf("fan-out of %d calls, %d at a time", cfg.FanOut, limit), phaseTime)
+	start := clock.Now()
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	took := make([]time.Duration, len(calls))
+	for i := range calls {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			callStart := clock.Now()
+			// A call's own log would interleave with its siblings', so calls are timed here instead.
+			calls[i].err = doNetworkPhase(cfg, calls[i].r, calls[i].d, nil)
+			took[i] = clock.Now().Sub(callStart)
+		}(i)
+	}
+	wg.Wait()
+	if log.Enabled(LogDebug) {
+		slowest := 0
+		for i := range took {
+			if took[i] > took[slowest] {
+				slowest = i
+			}
+		}
+		log.Debugf(clock.Now(), "slowest of the %d calls was #%d at %v; the fan-out took %v", len(calls), slowest+1,
+			took[slowest], clock.Now().Sub(start))
+	}
+	log.End(log.now(clock))
+	for _, c := range calls {
+		if c.err != nil {
+			return c.err
+		}
+	}
+	return nil
+}
+
+func outputFanOut(cfg RunConfig) {
+	if cfg.FanOut <= 1 {
+		return
+	}
+	limit := "all at once"
+	if cfg.FanOutLimit > 0 && cfg.FanOutLimit < cfg.FanOut {
+		limit = fmt.Sprintf("%d at a time", cfg.FanOutLimit)
+	}
+	fmt.Printf("\tFan-out: %d parallel downstream calls a network phase, %s\n", cfg.FanOut, limit)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Faults make the downstream behind the simulated network phases flaky: each phase independently fails with
+// probability ErrorRate, once its network time is up, or is degraded with probability SlowRate, taking SlowDelay
+// longer. With a Duration, faults only strike from Start into the run for that long, a brownout the run has to ride
+// out. The zero value injects nothing.
+type Faults struct {
+	ErrorRate float64       `json:"error_rate,omitempty"`
+	SlowRate  float64       `json:"slow_rate,omitempty"`
+	SlowDelay time.Duration `json:"slow_delay,omitempty"`
+	Start     time.Duration `json:"start,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+}
+
+var errInjectedFault = errors.New("injected downstream failure")
+
+func (f Faults) enabled() bool {
+	return f.ErrorRate > 0 || (f.SlowRate > 0 && f.SlowDelay > 0)
+}
+
+func (f Faults) validate() error {
+	if f.ErrorRate < 0 || f.ErrorRate > 1 || f.SlowRate < 0 || f.SlowRate > 1 {
+		return fmt.Errorf("fault rates must be between 0 and 1, got %g and %g", f.ErrorRate, f.SlowRate)
+	}
+	if f.SlowDelay < 0 || f.Start < 0 || f.Duration < 0 {
+		return errors.New("fault delay and window must not be negative")
+	}
+	return nil
+}
+
+// windowed reports whether faults only strike for part of the run.
+func (f Faults) windowed() bool {
+	return f.enabled() && f.Duration > 0
+}
+
+// within reports whether a call made elapsed into the run falls in the fault window.
+func (f Faults) within(elapsed time.Duration) bool {
+	return !f.windowed() || (elapsed >= f.Start && elapsed < f.Start+f.Duration)
+}
+
+// draw decides the fate of a network call made elapsed into the run: how much longer than asked it takes, and whether
+// it fails. It draws from r only when faults are enabled, but then whether or not the call falls in the window, so
+// that a request's fate doesn't depend on when it ran.
+func (f Faults) draw(r *rand.Rand, elapsed time.Duration) (time.Duration, error) {
+	if !f.enabled() {
+		return 0, nil
+	}
+	slow, fail := r.Float64() < f.SlowRate, r.Float64() < f.ErrorRate
+	if !f.within(elapsed) {
+		return 0, nil
+	}
+	var extra time.Duration
+	if slow {
+		extra = f.SlowDelay
+	}
+	if fail {
+		return extra, errInjectedFault
+	}
+	return extra, nil
+}
+
+func (f Faults) String() string {
+	var parts []string
+	if f.ErrorRate > 0 {
+		parts = append(parts, formatPercent(f.ErrorRate)+" of network phases fail")
+	}
+	if f.SlowRate > 0 && f.SlowDelay > 0 {
+		parts = append(parts, fmt.Sprintf("%s take %v longer", formatPercent(f.SlowRate), f.SlowDelay))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	s := strings.Join(parts, ", ")
+	if f.windowed() {
+		s += fmt.Sprintf(" for %v from %v into the run", f.Duration, f.Start)
+	}
+	return s
+}
+
+// Brownout is how the requests that started during a fault window fared, for comparing a run's behaviour under
+// faults with the rest of it: with a circuit breaker, say, against without.
+type Brownout struct {
+	Start, Duration time.Duration // of the window, from Faults
+	Requests        int
+	Errors          int
+	Timeouts        int
+	ResponseTimes   Collector // of the requests that didn't time out, in ms
+}
+
+func newBrownout(cfg RunConfig, expected int) (*Brownout, error) {
+	if !cfg.Faults.windowed() {
+		return nil, nil
+	}
+	c, err := newCollector(cfg.Collector, cfg.ReservoirSize, expected, newRand(cfg.Seed, collectorRandStream))
+	if err != nil {
+		return nil, err
+	}
+	return &Brownout{Start: cfg.Faults.Start, Duration: cfg.Faults.Duration, ResponseTimes: c}, nil
+}
+
+func (b *Brownout) add(result WorkResult) {
+	if started := result.finished - result.timeTaken; started < b.Start || started >= b.Start+b.Duration {
+		return
+	}
+	b.Requests++
+	switch {
+	case result.timedOut:
+		b.Timeouts++
+		return
+	case result.err != nil:
+		b.Errors++
+	}
+	b.ResponseTimes.Add(float64(result.timeTaken) / float64(time.Millisecond))
+}
+
+func (b *Brownout) merge(other *Brownout) {
+	b.Requests += other.Requests
+	b.Errors += other.Errors
+	b.Timeouts += other.Timeouts
+	b.ResponseTimes.Merge(other.ResponseTimes)
+}
+
+// GoodputRps is the rate at which requests that started during the window succeeded.
+func (b *Brownout) GoodputRps() float64 {
+	return float64(b.Requests-b.Errors-b.Timeouts) / b.Duration.Seconds()
+}
+
+func outputBrownout(b *Brownout) {
+	if b == nil {
+		return
+	}
+	if b.Requests == 0 {
+		fmt.Printf("\tDuring the brownout: no requests started\n")
+		return
+	}
+	fmt.Printf("\tDuring the brownout: %s requests started, %s failed", formatCount(int64(b.Requests)),
+		formatPercent(float64(b.Errors)/float64(b.Requests)))
+	if b.Timeouts > 0 {
+		fmt.Printf(", %s timed out", formatPercent(float64(b.Timeouts)/float64(b.Requests)))
+	}
+	fmt.Printf(", succeeding at %s", formatRps(b.GoodputRps()))
+	if b.ResponseTimes != nil && b.ResponseTimes.Count() > 0 {
+		fmt.Printf(", p50 %s, p99 %s", formatMs(b.ResponseTimes.Percentile(50)), formatMs(b.ResponseTimes.Percentile(99)))
+	}
+	fmt.Println()
+}
+
+type jsonBrownout struct {
+	StartMs    float64            `json:"start_ms"`
+	DurationMs float64            `json:"duration_ms"`
+	Requests   int                `json:"requests"`
+	Errors     int                `json:"errors"`
+	Timeouts   int                `json:"timeouts,omitempty"`
+	GoodputRps float64            `json:"goodput_rps"`
+	LatencyMs  map[string]float64 `json:"latency_ms,omitempty"`
+}
+
+func newJsonBrownout(b *Brownout, pcts []float64) *jsonBrownout {
+	if b == nil {
+		return nil
+	}
+	j := &jsonBrownout{
+		StartMs:    float64(b.Start) / float64(time.Millisecond),
+		DurationMs: float64(b.Duration) / float64(time.Millisecond),
+		Requests:   b.Requests,
+		Errors:     b.Errors,
+		Timeouts:   b.Timeouts,
+		GoodputRps: b.GoodputRps(),
+	}
+	if b.ResponseTimes != nil && b.ResponseTimes.Count() > 0 {
+		j.LatencyMs = latencySummary(b.ResponseTimes, pcts)
+	}
+	return j
+}
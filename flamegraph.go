@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"os"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// startCPUProfile profiles the process's CPU until the returned function is called, which returns the profile as
+// runtime/pprof encodes it, gzipped protobuf.
+func startCPUProfile() (stop func() []byte, err error) {
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return nil, fmt.Errorf("-cpu-profile: %w", err)
+	}
+	return func() []byte {
+		pprof.StopCPUProfile()
+		return buf.Bytes()
+	}, nil
+}
+
+// foldedStacks is a CPU profile folded as Brendan Gregg's flame graph tools take it: for each distinct stack, its
+// frames from the root down, separated by semicolons, and the CPU time spent in it in nanoseconds.
+type foldedStacks map[string]int64
+
+// foldProfile folds a profile written by runtime/pprof. There is no pprof parser this module can import, so it reads
+// the few fields of profile.proto a fold needs itself: the samples' location IDs and values, the locations' lines,
+// inlined calls among them, and the functions' names.
+func foldProfile(profile []byte) (foldedStacks, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(profile))
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		strs      []string
+		valueType []int64 // string index of each sample value's type
+		samples   [][]byte
+		locations = map[uint64][]uint64{} // ID → function IDs, innermost first
+		functions = map[uint64]int64{}    // ID → string index of the name
+	)
+	err = protoFields(b, func(num protowire.Number, v uint64, data []byte) error {
+		switch num {
+		case 1: // sample_type
+			return protoFields(data, func(num protowire.Number, v uint64, _ []byte) error {
+				if num == 1 {
+					valueType = append(valueType, int64(v))
+				}
+				return nil
+			})
+		case 2: // sample
+			samples = append(samples, data)
+		case 4: // location
+			var id uint64
+			var funcs []uint64
+			err := protoFields(data, func(num protowire.Number, v uint64, line []byte) error {
+				switch num {
+				case 1:
+					id = v
+				case 4:
+					return protoFields(line, func(num protowire.Number, v uint64, _ []byte) error {
+						if num == 1 {
+							funcs = append(funcs, v)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+			locations[id] = funcs
+			return err
+		case 5: // function
+			var id uint64
+			var name int64
+			err := protoFields(data, func(num protowire.Number, v uint64, _ []byte) error {
+				switch num {
+				case 1:
+					id = v
+				case 2:
+					name = int64(v)
+				}
+				return nil
+			})
+			functions[id] = name
+			return err
+		case 6: // string_table
+			strs = append(strs, string(data))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading CPU profile: %w", err)
+	}
+	str := func(i int64) string {
+		if i < 0 || i >= int64(len(strs)) {
+			return "?"
+		}
+		return strs[i]
+	}
+	// CPU time rather than the sample count, where the profile has it.
+	value := len(valueType) - 1
+	for i, t := range valueType {
+		if str(t) == "cpu" {
+			value = i
+		}
+	}
+
+	folded := foldedStacks{}
+	for _, sample := range samples {
+		var locs, values []uint64
+		err := protoFields(sample, func(num protowire.Number, v uint64, packed []byte) error {
+			var dst *[]uint64
+			switch num {
+			case 1:
+				dst = &locs
+			case 2:
+				dst = &values
+			default:
+				return nil
+			}
+			if packed == nil {
+				*dst = append(*dst, v)
+				return nil
+			}
+			for len(packed) > 0 {
+				v, n := protowire.ConsumeVarint(packed)
+				if n < 0 {
+					return protowire.ParseError(n)
+				}
+				*dst = append(*dst, v)
+				packed = packed[n:]
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading CPU profile: %w", err)
+		}
+		if value < 0 || value >= len(values) {
+			continue
+		}
+		// Locations run from the leaf up, and each location's lines from the innermost inlined call out.
+		var frames []string
+		for i := len(locs) - 1; i >= 0; i-- {
+			funcs := locations[locs[i]]
+			for j := len(funcs) - 1; j >= 0; j-- {
+				frames = append(frames, str(functions[funcs[j]]))
+			}
+		}
+		if len(frames) > 0 {
+			folded[strings.Join(frames, ";")] += int64(values[value])
+		}
+	}
+	return folded, nil
+}
+
+// protoFields calls f with each field of a protobuf message: the value of a varint or fixed-width field, or the
+// contents of a length-delimited one.
+func protoFields(b []byte, f func(num protowire.Number, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v uint64
+		var data []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(b)
+			v = uint64(v32)
+		case protowire.BytesType:
+			data, n = protowire.ConsumeBytes(b)
+			if data == nil && n >= 0 {
+				data = []byte{}
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := f(num, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes the stacks one a line, sorted, as flamegraph.pl and speedscope read them.
+func (f foldedStacks) write(path string) error {
+	stacks := make([]string, 0, len(f))
+	for stack := range f {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	var b strings.Builder
+	for _, stack := range stacks {
+		fmt.Fprintf(&b, "%s %d\n", stack, f[stack])
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// flameNode is a frame of a flame graph and the CPU time spent in it and below it.
+type flameNode struct {
+	name     string
+	value    int64
+	children map[string]*flameNode
+}
+
+const (
+	flameWidth      = 1200
+	flameFrame      = 16 // height of a frame
+	flameMargin     = 10
+	flameTitle      = 30
+	flameCharWidth  = 6.5 // of the 11px labels, near enough to fit them to their frames
+	flameMinVisible = 0.1 // frames narrower than this many pixels are left out
+)
+
+// flameGraph renders stacks as a flame graph: each frame a box as wide as the CPU time spent in it and in what it
+// called, stacked on its caller, with the root at the bottom and siblings in alphabetical order, as flamegraph.pl
+// draws them. Hovering over a frame shows its name and share of the time.
+func flameGraph(stacks foldedStacks, title string) ([]byte, error) {
+	root := &flameNode{name: "all", children: map[string]*flameNode{}}
+	depth := 0
+	for stack, v := range stacks {
+		node := root
+		node.value += v
+		frames := strings.Split(stack, ";")
+		if len(frames) > depth {
+			depth = len(frames)
+		}
+		for _, frame := range frames {
+			child, ok := node.children[frame]
+			if !ok {
+				child = &flameNode{name: frame, children: map[string]*flameNode{}}
+				node.children[frame] = child
+			}
+			child.value += v
+			node = child
+		}
+	}
+	if root.value == 0 {
+		return nil, errors.New("the CPU profile holds no samples")
+	}
+
+	height := flameTitle + (depth+1)*flameFrame + 2*flameMargin
+	scale := float64(flameWidth-2*flameMargin) / float64(root.value)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg" font-family="Verdana, sans-serif" font-size="11">
+<rect width="100%%" height="100%%" fill="#f8f8f8"/>
+<text x="%d" y="%d" text-anchor="middle" font-size="15">%s</text>
+`, flameWidth, height, flameWidth, height, flameWidth/2, flameTitle-8, html.EscapeString(title))
+	var draw func(n *flameNode, x float64, level int)
+	draw = func(n *flameNode, x float64, level int) {
+		w := float64(n.value) * scale
+		if w < flameMinVisible {
+			return
+		}
+		y := height - flameMargin - (level+1)*flameFrame
+		name := html.EscapeString(n.name)
+		fmt.Fprintf(&b, `<g><title>%s (%s, %s)</title><rect x="%.1f" y="%d" width="%.1f" height="%d" fill="%s" rx="2"/>`,
+			name, formatDuration(time.Duration(n.value)), formatPercent(float64(n.value)/float64(root.value)),
+			x, y, w, flameFrame-1, flameColor(n.name))
+		if chars := int((w - 6) / flameCharWidth); chars >= 3 {
+			label := n.name
+			if len(label) > chars {
+				label = label[:chars-2] + ".."
+			}
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d">%s</text>`, x+3, y+flameFrame-4, html.EscapeString(label))
+		}
+		b.WriteString("</g>\n")
+		names := make([]string, 0, len(n.children))
+		for name := range n.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := n.children[name]
+			draw(child, x, level+1)
+			x += float64(child.value) * scale
+		}
+	}
+	draw(root, flameMargin, 0)
+	b.WriteString("</svg>\n")
+	return b.Bytes(), nil
+}
+
+// flameColor picks a warm colour for a frame from its name, so that a function keeps its colour across the graphs of
+// a sweep.
+func flameColor(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	v := h.Sum32()
+	return fmt.Sprintf("rgb(%d,%d,%d)", 205+v%50, 80+(v>>8)%130, (v>>16)%55)
+}
+
+// saveFlameGraph writes the folded stacks and the flame graph of a run's CPU profile, when it has one.
+func saveFlameGraph(result BenchmarkResult, opts PlotOptions) error {
+	if result.CPUProfile == nil {
+		return nil
+	}
+	stacks, err := foldProfile(result.CPUProfile)
+	if err != nil {
+		return err
+	}
+	if err := stacks.write(opts.Out.path("cpu.folded", &result.Config)); err != nil {
+		return err
+	}
+	svg, err := flameGraph(stacks, flameGraphTitle(result))
+	if err != nil {
+		// A run too short for the profiler to sample draws no graph, and is no reason to fail the sweep.
+		return nil
+	}
+	return os.WriteFile(opts.Out.path("flamegraph.svg", &result.Config), svg, 0o644)
+}
+
+func flameGraphTitle(result BenchmarkResult) string {
+	return fmt.Sprintf("CPU of %s, GOMAXPROCS=%d, %d co-routines", result.Config.Executor, result.Config.GOMAXPROCS, result.NumCoroutines)
+}
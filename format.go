@@ -0,0 +1,280 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+)
+
+// Numbers in every output, console, HTML and plot labels alike, go through these functions so that the same value
+// reads the same everywhere and on every machine: by default a '.' decimal point, ',' between thousands, and
+// scientific notation once a value is too large or too small for fixed decimals to show it.
+
+// OutputUnits are the units and number formatting of everything written for people to read. Results files and the
+// exporters keep their own fixed units, milliseconds and requests per second as their field names say, so that they
+// read back the same whatever was chosen here; the sweep's environment records the choice.
+type OutputUnits struct {
+	Latency     string `json:"latency"`    // us, ms or s
+	Throughput  string `json:"throughput"` // rps or kps
+	Decimals    int    `json:"decimals"`   // of latencies, throughputs and percentages; negative for each unit's own
+	Thousands   string `json:"thousands_separator"`
+	DecimalMark string `json:"decimal_mark"`
+}
+
+var defaultUnits = OutputUnits{Latency: "ms", Throughput: "rps", Decimals: -1, Thousands: ",", DecimalMark: "."}
+
+// units is set once from the command line's flags, before anything is written.
+var units = defaultUnits
+
+// latencyUnits are the units -latency-unit accepts: how many of each make a millisecond, and the decimals written by
+// default.
+var latencyUnits = map[string]struct {
+	perMs    float64
+	decimals int
+}{
+	"us": {1000, 0},
+	"ms": {1, 2},
+	"s":  {0.001, 3},
+}
+
+var throughputUnits = map[string]struct {
+	perRps   float64
+	decimals int
+}{
+	"rps": {1, 2},
+	"kps": {0.001, 3},
+}
+
+// unitFlags declares the flags that choose units and number formatting on fs and returns a function applying them,
+// to be called once fs is parsed.
+func unitFlags(fs *flag.FlagSet) func() error {
+	latency := fs.String("latency-unit", defaultUnits.Latency, "unit of latencies in console output, reports and plot axes: us, ms or s")
+	throughput := fs.String("throughput-unit", defaultUnits.Throughput, "unit of throughputs in console output, reports and plot axes: rps or kps")
+	decimals := fs.Int("decimals", defaultUnits.Decimals, "decimal places of latencies, throughputs and percentages (default: 0 for us, 2 for ms and rps, 3 for s and kps)")
+	thousands := fs.String("thousands-separator", defaultUnits.Thousands, "written between groups of thousands; empty for none")
+	mark := fs.String("decimal-mark", defaultUnits.DecimalMark, "written before the decimal places")
+	return func() error {
+		u := OutputUnits{Latency: *latency, Throughput: *throughput, Decimals: *decimals, Thousands: *thousands,
+			DecimalMark: *mark}
+		if err := u.validate(); err != nil {
+			return err
+		}
+		units = u
+		return nil
+	}
+}
+
+func (u OutputUnits) validate() error {
+	if _, ok := latencyUnits[u.Latency]; !ok {
+		return fmt.Errorf("unknown latency unit %q, expected us, ms or s", u.Latency)
+	}
+	if _, ok := throughputUnits[u.Throughput]; !ok {
+		return fmt.Errorf("unknown throughput unit %q, expected rps or kps", u.Throughput)
+	}
+	if u.DecimalMark == "" || u.DecimalMark == u.Thousands || strings.ContainsAny(u.DecimalMark+u.Thousands, "0123456789-") {
+		return fmt.Errorf("-decimal-mark %q and -thousands-separator %q must differ, and neither can be a digit or sign",
+			u.DecimalMark, u.Thousands)
+	}
+	return nil
+}
+
+// unitFlagsSet reports whether any of unitFlags' flags were given on fs.
+func unitFlagsSet(fs *flag.FlagSet) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "latency-unit", "throughput-unit", "decimals", "thousands-separator", "decimal-mark":
+			set = true
+		}
+	})
+	return set
+}
+
+// latencyUnit writes ms, a latency held in milliseconds as the collectors hold them, in the chosen unit, and
+// throughputUnit rps in its.
+func latencyUnit(ms float64) float64 {
+	return ms * latencyUnits[units.Latency].perMs
+}
+
+func throughputUnit(rps float64) float64 {
+	return rps * throughputUnits[units.Throughput].perRps
+}
+
+// unitDecimals is the decimals to write a value in a unit with, which are the unit's own unless -decimals chose.
+func unitDecimals(own int) int {
+	if units.Decimals >= 0 {
+		return units.Decimals
+	}
+	return own
+}
+
+// latencyLabel and throughputLabel name a plot axis, with the unit its ticks are labelled in.
+func latencyLabel(what string) string {
+	return what + " (" + latencySuffix() + ")"
+}
+
+func throughputLabel(what string) string {
+	return what + " (" + units.Throughput + ")"
+}
+
+// latencyTicks and throughputTicks label an axis plotting milliseconds or requests per second in the chosen units and
+// number formatting. They wrap the axis's tick marker as it is, so they are set once any log scale is.
+func latencyTicks(a *plot.Axis) {
+	unitTicks(a, latencyUnits[units.Latency].perMs)
+}
+
+func throughputTicks(a *plot.Axis) {
+	unitTicks(a, throughputUnits[units.Throughput].perRps)
+}
+
+func unitTicks(a *plot.Axis, factor float64) {
+	// Ticks in the default units and formatting are left as gonum labels them.
+	plain := factor == 1 && units.Thousands == defaultUnits.Thousands && units.DecimalMark == defaultUnits.DecimalMark
+	if _, ok := a.Tick.Marker.(scaledTicks); ok || plain {
+		return
+	}
+	a.Tick.Marker = scaledTicks{a.Tick.Marker, factor}
+}
+
+// scaledTicks places and labels ticks as marker would for an axis in units factor times those plotted, so that they
+// fall on round numbers of the unit they are labelled in.
+type scaledTicks struct {
+	marker plot.Ticker
+	factor float64
+}
+
+func (t scaledTicks) Ticks(min, max float64) []plot.Tick {
+	ticks := t.marker.Ticks(min*t.factor, max*t.factor)
+	for i := range ticks {
+		ticks[i].Value /= t.factor
+		if ticks[i].Label == "" {
+			continue
+		}
+		// Relabelled with the decimals the marker chose, in the chosen formatting.
+		decimals := 0
+		if dot := strings.IndexByte(ticks[i].Label, '.'); dot >= 0 {
+			decimals = len(ticks[i].Label) - dot - 1
+		}
+		if v, err := strconv.ParseFloat(ticks[i].Label, 64); err == nil && !strings.ContainsAny(ticks[i].Label, "eE") {
+			ticks[i].Label = formatFloat(v, decimals)
+		}
+	}
+	return ticks
+}
+
+// formatFloat writes v with the given number of decimals.
+func formatFloat(v float64, decimals int) string {
+	switch {
+	case math.IsNaN(v):
+		return "n/a"
+	case math.IsInf(v, 0):
+		if v > 0 {
+			return "∞"
+		}
+		return "-∞"
+	}
+	// Tiny values that fixed decimals would round away entirely are written in scientific notation.
+	if abs := math.Abs(v); abs >= 1e12 || (abs > 0 && abs < 1e-3 && abs < math.Pow(10, -float64(decimals))/2) {
+		return strconv.FormatFloat(v, 'e', 2, 64)
+	}
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	whole, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, fraction = s[:i], units.DecimalMark+s[i+1:]
+	}
+	return sign + groupThousands(whole) + fraction
+}
+
+// formatCount writes a count with thousands separators.
+func formatCount(n int64) string {
+	if n < 0 {
+		return "-" + groupThousands(strconv.FormatInt(-n, 10))
+	}
+	return groupThousands(strconv.FormatInt(n, 10))
+}
+
+func groupThousands(digits string) string {
+	if len(digits) <= 3 {
+		return digits
+	}
+	var b strings.Builder
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if b.Len() > 0 {
+			b.WriteString(units.Thousands)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
+
+// formatMs writes a latency held in milliseconds, as the collectors hold them, in the chosen unit.
+func formatMs(ms float64) string {
+	return formatLatency(ms) + latencySuffix()
+}
+
+// formatLatency is formatMs without the unit, for tables that name it in their heading.
+func formatLatency(ms float64) string {
+	return formatFloat(latencyUnit(ms), unitDecimals(latencyUnits[units.Latency].decimals))
+}
+
+func latencySuffix() string {
+	if units.Latency == "us" {
+		return "µs"
+	}
+	return units.Latency
+}
+
+func formatRps(rps float64) string {
+	return formatThroughput(rps) + " " + units.Throughput
+}
+
+func formatThroughput(rps float64) string {
+	return formatFloat(throughputUnit(rps), unitDecimals(throughputUnits[units.Throughput].decimals))
+}
+
+// formatPercent writes a fraction as a percentage.
+func formatPercent(fraction float64) string {
+	return formatFloat(fraction*100, unitDecimals(2)) + "%"
+}
+
+// formatDuration rounds d to three or more significant digits, so that measured durations don't print to the
+// nanosecond.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "-" + formatDuration(-d)
+	case d >= 10*time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= 10*time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	case d >= 10*time.Microsecond:
+		return d.Round(time.Microsecond).String()
+	}
+	return d.String()
+}
+
+// formatSigned writes v with an explicit sign, for deviations and changes.
+func formatSigned(v float64, decimals int) string {
+	if v >= 0 {
+		return "+" + formatFloat(v, decimals)
+	}
+	return formatFloat(v, decimals)
+}
+
+// formatChange writes a relative change, such as 0.05 for five percent more, as a signed percentage.
+func formatChange(fraction float64) string {
+	return formatSigned(fraction*100, 1) + "%"
+}
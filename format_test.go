@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFormatFloat(t *testing.T) {
+	for _, tc := range []struct {
+		v        float64
+		decimals int
+		want     string
+	}{
+		{0, 2, "0.00"},
+		{1234.5678, 2, "1,234.57"},
+		{-1234567.891, 1, "-1,234,567.9"},
+		{999.999, 2, "1,000.00"},
+		{12, 0, "12"},
+		{0.0001234, 2, "1.23e-04"},
+		{0.004, 2, "0.00"},
+		{2e12, 0, "2.00e+12"},
+		{math.NaN(), 2, "n/a"},
+		{math.Inf(1), 2, "∞"},
+		{math.Inf(-1), 2, "-∞"},
+	} {
+		if got := formatFloat(tc.v, tc.decimals); got != tc.want {
+			t.Errorf("formatFloat(%v, %d) = %q, want %q", tc.v, tc.decimals, got, tc.want)
+		}
+	}
+}
+
+func TestGroupThousands(t *testing.T) {
+	for digits, want := range map[string]string{
+		"":           "",
+		"1":          "1",
+		"123":        "123",
+		"1234":       "1,234",
+		"123456":     "123,456",
+		"1234567890": "1,234,567,890",
+	} {
+		if got := groupThousands(digits); got != want {
+			t.Errorf("groupThousands(%q) = %q, want %q", digits, got, want)
+		}
+	}
+	if got := formatCount(-1234567); got != "-1,234,567" {
+		t.Errorf("formatCount(-1234567) = %q", got)
+	}
+}
+
+// TestOutputUnits checks that the chosen units and formatting reach every formatter, and are undone afterwards.
+func TestOutputUnits(t *testing.T) {
+	defer func() { units = defaultUnits }()
+	units = OutputUnits{Latency: "us", Throughput: "kps", Decimals: -1, Thousands: ".", DecimalMark: ","}
+	if got := formatMs(12.3456); got != "12.346µs" {
+		t.Errorf("formatMs(12.3456) = %q, want 12.346µs", got)
+	}
+	if got := formatRps(1500); got != "1,500 kps" {
+		t.Errorf("formatRps(1500) = %q, want 1,500 kps", got)
+	}
+	units.Decimals = 1
+	if got := formatPercent(0.12345); got != "12,3%" {
+		t.Errorf("formatPercent(0.12345) = %q, want 12,3%%", got)
+	}
+	for _, bad := range []OutputUnits{
+		{Latency: "min", Throughput: "rps", DecimalMark: "."},
+		{Latency: "ms", Throughput: "rps", Thousands: ".", DecimalMark: "."},
+		{Latency: "ms", Throughput: "rps", DecimalMark: "1"},
+	} {
+		if bad.validate() == nil {
+			t.Errorf("%+v validated", bad)
+		}
+	}
+}
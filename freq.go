@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FreqSample is the CPU frequency across all cores at one point during a run.
+type FreqSample struct {
+	Elapsed time.Duration
+	MinMHz  float64
+	AvgMHz  float64
+	MaxMHz  float64
+}
+
+// freqMonitor samples Linux cpufreq's scaling_cur_freq for every core in the background and counts thermal throttling
+// events, so that results skewed by turbo transitions or throttling can be flagged.
+type freqMonitor struct {
+	freqFiles     []string
+	throttleFiles []string
+	throttleStart int64
+	start         time.Time
+	samples       []FreqSample
+	stop          chan struct{}
+	done          chan struct{}
+}
+
+// startFreqMonitor returns nil where cpufreq is unavailable, such as on other platforms or in most containers.
+func startFreqMonitor(interval time.Duration) *freqMonitor {
+	freqFiles, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_cur_freq")
+	if len(freqFiles) == 0 {
+		return nil
+	}
+	throttleFiles, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/thermal_throttle/core_throttle_count")
+	m := &freqMonitor{
+		freqFiles:     freqFiles,
+		throttleFiles: throttleFiles,
+		throttleStart: sumSysfs(throttleFiles),
+		start:         time.Now(),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			m.sample()
+			select {
+			case <-ticker.C:
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+func (m *freqMonitor) sample() {
+	s := FreqSample{Elapsed: time.Since(m.start)}
+	n := 0
+	for _, path := range m.freqFiles {
+		khz, ok := readSysfsInt(path)
+		if !ok {
+			continue
+		}
+		mhz := float64(khz) / 1000
+		if n == 0 || mhz < s.MinMHz {
+			s.MinMHz = mhz
+		}
+		if mhz > s.MaxMHz {
+			s.MaxMHz = mhz
+		}
+		s.AvgMHz += mhz
+		n++
+	}
+	if n > 0 {
+		s.AvgMHz /= float64(n)
+		m.samples = append(m.samples, s)
+	}
+}
+
+// Stop ends sampling and returns the frequency trace and the number of throttling events seen during it.
+func (m *freqMonitor) Stop() ([]FreqSample, int64) {
+	if m == nil {
+		return nil, 0
+	}
+	close(m.stop)
+	<-m.done
+	return m.samples, sumSysfs(m.throttleFiles) - m.throttleStart
+}
+
+// freqWarnings describes why a frequency trace makes a run's results suspect.
+func freqWarnings(trace []FreqSample, throttleEvents int64) []string {
+	var warnings []string
+	if throttleEvents > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d thermal throttling events during the run", throttleEvents))
+	}
+	if len(trace) < 2 {
+		return warnings
+	}
+	lowest, highest := trace[0].AvgMHz, trace[0].AvgMHz
+	for _, s := range trace[1:] {
+		if s.AvgMHz < lowest {
+			lowest = s.AvgMHz
+		}
+		if s.AvgMHz > highest {
+			highest = s.AvgMHz
+		}
+	}
+	if highest > lowest*1.1 {
+		warnings = append(warnings, fmt.Sprintf("average CPU frequency moved between %.0f and %.0f MHz during the run", lowest, highest))
+	}
+	return warnings
+}
+
+func readSysfsInt(path string) (int64, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	return v, err == nil
+}
+
+func sumSysfs(paths []string) int64 {
+	var total int64
+	for _, path := range paths {
+		v, _ := readSysfsInt(path)
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"strings"
+	"time"
+)
+
+// Span is one phase of a request, from its begin event to the matching end event. Start and End are offsets from the
+// request's first recorded event; Depth counts the spans enclosing it.
+type Span struct {
+	Kind  string
+	Label string
+	Start time.Duration
+	End   time.Duration
+	Depth int
+}
+
+// RequestTimeline is a request's log turned into spans.
+type RequestTimeline struct {
+	Name  string
+	Total time.Duration
+	Spans []Span
+}
+
+// timeline pairs the log's begin and end events into spans, innermost first to close. Phases begun but never ended,
+// as when a call fails part way, are dropped.
+func (l *RequestLog) timeline(total time.Duration) RequestTimeline {
+	t := RequestTimeline{Total: total}
+	if l == nil {
+		return t
+	}
+	t.Name = l.name()
+	var stack []Event
+	var origin time.Time
+	for _, e := range l.Events {
+		if e.Kind == eventNote {
+			continue
+		}
+		if origin.IsZero() {
+			origin = e.At
+		}
+		if e.Kind == eventBegin {
+			stack = append(stack, e)
+			continue
+		}
+		if len(stack) == 0 {
+			continue
+		}
+		begin := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		t.Spans = append(t.Spans, Span{
+			Kind:  begin.Phase,
+			Label: fmt.Sprintf("%s took %v", begin.label(), e.At.Sub(begin.At)),
+			Start: begin.At.Sub(origin),
+			End:   e.At.Sub(origin),
+			Depth: len(stack),
+		})
+	}
+	return t
+}
+
+var spanColors = map[string]string{
+	"cpu":        "#d62728",
+	"network":    "#1f77b4",
+	"disk":       "#8c564b",
+	"lock":       "#ff7f0e",
+	"pipeline":   "#9467bd",
+	"backoff":    "#bcbd22",
+	"middleware": "#c7c7c7",
+	"other":      "#7f7f7f",
+}
+
+// saveGantt writes the run's slowest requests as an HTML Gantt chart, one row per request on a shared time axis.
+// Gaps between phases are time the request spent waiting to be scheduled, or otherwise not recorded.
+func saveGantt(result BenchmarkResult, out *artifactStore) error {
+	if len(result.Slowest) == 0 {
+		return nil
+	}
+	var longest time.Duration
+	for _, t := range result.Slowest {
+		for _, s := range t.Spans {
+			if s.End > longest {
+				longest = s.End
+			}
+		}
+		if t.Total > longest {
+			longest = t.Total
+		}
+	}
+	if longest <= 0 {
+		return nil
+	}
+	pct := func(d time.Duration) float64 { return float64(d) * 100 / float64(longest) }
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Slowest requests</title><style>\n" +
+		"body { font-family: sans-serif; margin: 2em; }\n" +
+		".row { display: flex; align-items: flex-start; margin: 4px 0; }\n" +
+		".name { width: 16em; font-size: 12px; }\n" +
+		".track { position: relative; flex: 1; background: #f4f4f4; }\n" +
+		".span { position: absolute; height: 12px; opacity: 0.85; }\n" +
+		".key { display: inline-block; width: 12px; height: 12px; margin: 0 4px 0 12px; }\n" +
+		"</style></head><body>\n")
+	fmt.Fprintf(&b, "<h1>Slowest requests</h1>\n<p>%s requests with %s co-routines, %s executor. Gaps between phases are time spent waiting to run.</p>\n<p>",
+		formatCount(int64(result.Iterations)), formatCount(result.NumCoroutines), result.Config.Executor)
+	for _, kind := range []string{"cpu", "network", "disk", "lock", "pipeline", "backoff", "middleware", "other"} {
+		fmt.Fprintf(&b, "<span class=\"key\" style=\"background:%s\"></span>%s", spanColors[kind], kind)
+	}
+	b.WriteString("</p>\n")
+	for _, t := range result.Slowest {
+		depth := 0
+		for _, s := range t.Spans {
+			if s.Depth > depth {
+				depth = s.Depth
+			}
+		}
+		fmt.Fprintf(&b, "<div class=\"row\"><div class=\"name\">%s (%v)</div><div class=\"track\" style=\"height:%dpx\">",
+			html.EscapeString(t.Name), formatDuration(t.Total), (depth+1)*14)
+		for _, s := range t.Spans {
+			fmt.Fprintf(&b, "<div class=\"span\" style=\"left:%.3f%%;width:%.3f%%;top:%dpx;background:%s\" title=\"%s (%v)\"></div>",
+				pct(s.Start), pct(s.End-s.Start), s.Depth*14, spanColors[s.Kind], html.EscapeString(s.Label), formatDuration(s.End-s.Start))
+		}
+		b.WriteString("</div></div>\n")
+	}
+	fmt.Fprintf(&b, "<p>Axis spans %s.</p>\n</body></html>\n", formatDuration(longest))
+	return os.WriteFile(out.path("slowest_requests.html", &result.Config), []byte(b.String()), 0o644)
+}
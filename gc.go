@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+)
+
+// gogcOff is the GOGC value that turns the collector off, as GOGC=off does, leaving only a memory limit to trigger it.
+const gogcOff = -1
+
+// parseGOGC parses a comma-separated list of GOGC percentages to sweep, "off" among them for gogcOff.
+func parseGOGC(s string) ([]int, error) {
+	var values []int
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "off" {
+			values = append(values, gogcOff)
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid GOGC %q: it must be a positive percentage or off", v)
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
+
+func formatGOGC(gogc int) string {
+	if gogc == gogcOff {
+		return "off"
+	}
+	return strconv.Itoa(gogc)
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}, {"B", 1}}
+
+// parseMemoryLimits parses a comma-separated list of memory limits to sweep, in bytes or with a KiB, MiB or GiB
+// suffix as GOMEMLIMIT takes them.
+func parseMemoryLimits(s string) ([]int64, error) {
+	var values []int64
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		size, number := int64(1), v
+		for _, u := range byteUnits {
+			if strings.HasSuffix(v, u.suffix) {
+				size, number = u.size, strings.TrimSuffix(v, u.suffix)
+				break
+			}
+		}
+		n, err := strconv.ParseInt(number, 10, 64)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid memory limit %q: it must be a positive size such as 512MiB", v)
+		}
+		values = append(values, n*size)
+	}
+	return values, nil
+}
+
+// formatMemoryLimit writes a limit in the largest unit that divides it, as parseMemoryLimits reads it back.
+func formatMemoryLimit(bytes int64) string {
+	for _, u := range byteUnits {
+		if bytes%u.size == 0 {
+			return strconv.FormatInt(bytes/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10) + "B"
+}
+
+// applyGC sets the garbage collector up for a run of cfg, returning what puts the process's own settings back.
+func applyGC(cfg RunConfig) (restore func()) {
+	gogc, limit := 0, int64(-1)
+	if cfg.GOGC != 0 {
+		gogc = debug.SetGCPercent(cfg.GOGC)
+	}
+	if cfg.MemoryLimit > 0 {
+		limit = setMemoryLimit(cfg.MemoryLimit)
+	}
+	return func() {
+		if cfg.GOGC != 0 {
+			debug.SetGCPercent(gogc)
+		}
+		if cfg.MemoryLimit > 0 {
+			setMemoryLimit(limit)
+		}
+	}
+}
+
+var gcCyclesMetric = []metrics.Sample{{Name: "/gc/cycles/total:gc-cycles"}}
+
+// gcCycles counts the collections the process has completed.
+func gcCycles() uint64 {
+	samples := append([]metrics.Sample(nil), gcCyclesMetric...)
+	metrics.Read(samples)
+	return samples[0].Value.Uint64()
+}
+
+func outputGC(result BenchmarkResult) {
+	cfg := result.Config
+	if cfg.GOGC == 0 && cfg.MemoryLimit == 0 {
+		return
+	}
+	var settings []string
+	if cfg.GOGC != 0 {
+		settings = append(settings, "GOGC="+formatGOGC(cfg.GOGC))
+	}
+	if cfg.MemoryLimit > 0 {
+		settings = append(settings, "GOMEMLIMIT="+formatMemoryLimit(cfg.MemoryLimit))
+	}
+	fmt.Printf("\tGC: %s, %s collections during the run\n", strings.Join(settings, ", "), formatCount(int64(result.GCCycles)))
+}
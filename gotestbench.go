@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// benchConfig is the sweep's default workload, 5ms of CPU and 55ms of network time per request, for go test
+// benchmarks of the given executor and co-routine count.
+func benchConfig(executor string, coroutines int64) RunConfig {
+	return RunConfig{
+		WorkTime:      5 * time.Millisecond,
+		NetworkTime:   55 * time.Millisecond,
+		Splits:        5,
+		CPUDist:       Distribution{Kind: "fixed"},
+		NetworkDist:   Distribution{Kind: "fixed"},
+		Executor:      executor,
+		NumCoroutines: coroutines,
+		Collector:     "exact",
+		Seed:          1,
+		ResultBatch:   1,
+	}
+}
+
+// runTestingBenchmark runs cfg as a go test benchmark, with b.N requests, and reports its throughput and response
+// time percentiles through b.ReportMetric, so that sweeps can be tracked by tools built around go test -bench, such
+// as benchstat. The serial baseline behind the sweep's speedup is skipped: go test calls a benchmark several times
+// while it sizes b.N, and the baseline would dominate each call.
+func runTestingBenchmark(b *testing.B, cfg RunConfig) {
+	cfg.Iterations = b.N
+	cfg.BaselineIterations = 0
+	b.ResetTimer()
+	result, err := runBenchmark(context.Background(), cfg, multiReporter{})
+	b.StopTimer()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if result.Failure != nil {
+		b.Fatal(result.Failure)
+	}
+	b.ReportMetric(result.ThroughputRps, "req/s")
+	for _, pct := range cfg.percentiles() {
+		b.ReportMetric(result.ResponseTimesPercentile(pct), percentileName(pct)+"-ms")
+	}
+	b.ReportMetric(result.Latency.Mean, "mean-ms")
+	b.ReportMetric(result.Latency.StdDev, "stddev-ms")
+}
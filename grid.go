@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// gridDim is a dimension of the workload a sweep can vary besides the executor, GOMAXPROCS and co-routine count.
+type gridDim struct {
+	name  string // in labels and titles
+	file  string // in file names
+	short string // prefix of its value in the names of per-run artifacts
+	value func(RunConfig) string
+}
+
+var gridDims = []gridDim{
+	{"CPU time", "work_time", "w", func(cfg RunConfig) string { return formatDuration(cfg.WorkTime) }},
+	{"network time", "network_time", "n", func(cfg RunConfig) string { return formatDuration(cfg.NetworkTime) }},
+	{"splits", "splits", "s", func(cfg RunConfig) string { return strconv.Itoa(cfg.Splits) }},
+	{"rate limiter", "rate_limit", "rl", func(cfg RunConfig) string { return cfg.RateLimit.key() }},
+	{"max open SQL connections", "sql_max_open", "sql", func(cfg RunConfig) string { return strconv.Itoa(cfg.SQLMaxOpen) }},
+	{"consumers", "mq_consumers", "mq", func(cfg RunConfig) string { return strconv.Itoa(cfg.MQConsumers) }},
+	{"calls in flight", "fanout_limit", "fo", func(cfg RunConfig) string { return strconv.Itoa(cfg.FanOutLimit) }},
+	{"hedge delay", "hedge_after", "hd", func(cfg RunConfig) string { return cfg.Hedge.key() }},
+	{"dispatch", "dispatch", "dp", func(cfg RunConfig) string { return cfg.Dispatch }},
+	{"deadline", "propagate_deadline", "dl", func(cfg RunConfig) string { return formatPropagation(cfg.PropagateDeadline) }},
+	{"GOGC", "gogc", "gc", func(cfg RunConfig) string { return formatGOGC(cfg.GOGC) }},
+	{"memory limit", "memory_limit", "ml", func(cfg RunConfig) string { return formatMemoryLimit(cfg.MemoryLimit) }},
+	{"NUMA placement", "numa_placement", "numa", func(cfg RunConfig) string { return cfg.Placement }},
+}
+
+// label names the dimension's value in a run, such as "5ms CPU time" or "3 splits".
+func (d gridDim) label(cfg RunConfig) string {
+	return d.value(cfg) + " " + d.name
+}
+
+// sweptDims returns the grid dimensions that take more than one value over the configurations.
+func sweptDims(cfgs []RunConfig) []gridDim {
+	var swept []gridDim
+	for _, d := range gridDims {
+		for _, cfg := range cfgs {
+			if d.value(cfg) != d.value(cfgs[0]) {
+				swept = append(swept, d)
+				break
+			}
+		}
+	}
+	return swept
+}
+
+// parseDurations parses a comma-separated list of positive durations; what names them in errors.
+func parseDurations(s, what string) ([]time.Duration, error) {
+	var values []time.Duration
+	for _, v := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", what, v)
+		}
+		values = append(values, d)
+	}
+	return values, nil
+}
+
+// configs lists the configurations the sweep runs, once each whatever the repeat count: the cartesian product of
+// every dimension, in the order they are nested, with the co-routine count varying fastest. With Sample set, only
+// that many of them, drawn at random from the base configuration's seed, are kept in the same order.
+func (s Sweep) configs(base RunConfig) []RunConfig {
+	coroutines := s.Coroutines
+	if coroutines == nil {
+		coroutines = defaultCoroutines
+	}
+	splits := s.Splits
+	if splits == nil {
+		splits = []int{base.Splits}
+	}
+	workTimes, networkTimes := s.WorkTimes, s.NetworkTimes
+	if workTimes == nil {
+		workTimes = []time.Duration{base.WorkTime}
+	}
+	if networkTimes == nil {
+		networkTimes = []time.Duration{base.NetworkTime}
+	}
+	rateLimits := s.RateLimits
+	if rateLimits == nil {
+		rateLimits = []RateLimit{base.RateLimit}
+	}
+	maxOpens := s.SQLMaxOpen
+	if maxOpens == nil {
+		maxOpens = []int{base.SQLMaxOpen}
+	}
+	consumers := s.MQConsumers
+	if consumers == nil {
+		consumers = []int{base.MQConsumers}
+	}
+	fanLimits := s.FanOutLimits
+	if fanLimits == nil {
+		fanLimits = []int{base.FanOutLimit}
+	}
+	hedgeAfters := s.HedgeAfters
+	if hedgeAfters == nil {
+		hedgeAfters = []time.Duration{base.Hedge.After}
+	}
+	propagation := s.Propagation
+	if propagation == nil {
+		propagation = []bool{base.PropagateDeadline}
+	}
+	dispatches := s.Dispatch
+	if dispatches == nil {
+		dispatches = []string{base.Dispatch}
+	}
+	gogcs, memoryLimits := s.GOGC, s.MemoryLimits
+	if gogcs == nil {
+		gogcs = []int{base.GOGC}
+	}
+	if memoryLimits == nil {
+		memoryLimits = []int64{base.MemoryLimit}
+	}
+	placements := s.Placements
+	if placements == nil {
+		placements = []string{base.Placement}
+	}
+	var cfgs []RunConfig
+	for _, executor := range s.Executors {
+		for _, p := range s.GOMAXPROCS {
+			for _, placement := range placements {
+				for _, gogc := range gogcs {
+					for _, memoryLimit := range memoryLimits {
+						for _, work := range workTimes {
+							for _, network := range networkTimes {
+								for _, limit := range rateLimits {
+									for _, maxOpen := range maxOpens {
+										for _, consumer := range consumers {
+											for _, fanLimit := range fanLimits {
+												for _, hedgeAfter := range hedgeAfters {
+													for _, propagate := range propagation {
+														for _, dispatch := range dispatches {
+															for _, split := range splits {
+																for _, n := range coroutines {
+																	cfg := base
+																	cfg.Executor = executor
+																	cfg.GOMAXPROCS = p
+																	cfg.Placement = placement
+																	cfg.GOGC, cfg.MemoryLimit = gogc, memoryLimit
+																	cfg.WorkTime, cfg.NetworkTime = work, network
+																	cfg.RateLimit = limit
+																	cfg.SQLMaxOpen = maxOpen
+																	cfg.MQConsumers = consumer
+																	cfg.FanOutLimit = fanLimit
+																	cfg.Hedge.After = hedgeAfter
+																	cfg.PropagateDeadline = propagate
+																	cfg.Dispatch = dispatch
+																	cfg.Splits = split
+																	cfg.NumCoroutines = n
+																	cfgs = append(cfgs, cfg)
+																}
+															}
+														}
+													}
+												}
+											}
+										}
+									}
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	if s.Sample <= 0 || s.Sample >= len(cfgs) {
+		return cfgs
+	}
+	picked := newRand(base.Seed, gridRandStream).Perm(len(cfgs))[:s.Sample]
+	sort.Ints(picked)
+	sampled := make([]RunConfig, len(picked))
+	for i, j := range picked {
+		sampled[i] = cfgs[j]
+	}
+	return sampled
+}
+
+// worstTimerCase returns the configuration with the shortest network phases, for warning about timer resolution
+// once rather than for every configuration.
+func worstTimerCase(cfgs []RunConfig) RunConfig {
+	worst := cfgs[0]
+	for _, cfg := range cfgs {
+		if cfg.Splits > 0 && (worst.Splits == 0 || cfg.NetworkTime/time.Duration(cfg.Splits) < worst.NetworkTime/time.Duration(worst.Splits)) {
+			worst = cfg
+		}
+	}
+	return worst
+}
+
+// plotGridFacets draws, for each grid dimension the sweep varied, a figure of small multiples with a column per value
+// of that dimension: throughput and p99 against the co-routine count, one line per combination of the other
+// dimensions. Reading across a row shows the dimension's effect with everything else held equal.
+func plotGridFacets(results []BenchmarkResult, opts PlotOptions) error {
+	cfgs := make([]RunConfig, len(results))
+	for i, r := range results {
+		cfgs[i] = r.Config
+	}
+	swept := sweptDims(cfgs)
+	executors, procs := map[string]bool{}, map[int]bool{}
+	for _, cfg := range cfgs {
+		executors[cfg.Executor], procs[cfg.GOMAXPROCS] = true, true
+	}
+	for _, facet := range swept {
+		var values []string
+		facets := map[string][]BenchmarkResult{}
+		for _, r := range results {
+			v := facet.value(r.Config)
+			if _, ok := facets[v]; !ok {
+				values = append(values, v)
+			}
+			facets[v] = append(facets[v], r)
+		}
+		// Lines are labelled by the other dimensions, and keep their colour from one facet to the next.
+		lineLabel := func(cfg RunConfig) string {
+			var parts []string
+			if len(executors) > 1 {
+				parts = append(parts, cfg.Executor)
+			}
+			if len(procs) > 1 {
+				parts = append(parts, fmt.Sprintf("GOMAXPROCS=%d", cfg.GOMAXPROCS))
+			}
+			for _, d := range swept {
+				if d.name != facet.name {
+					parts = append(parts, d.label(cfg))
+				}
+			}
+			return strings.Join(parts, ", ")
+		}
+		colors := map[string]int{}
+		for _, r := range results {
+			if _, ok := colors[lineLabel(r.Config)]; !ok {
+				colors[lineLabel(r.Config)] = len(colors)
+			}
+		}
+
+		plots := [][]*plot.Plot{make([]*plot.Plot, len(values)), make([]*plot.Plot, len(values))}
+		legended := map[string]bool{}
+		for col, v := range values {
+			throughput, latency := plot.New(), plot.New()
+			throughput.Title.Text = v + " " + facet.name
+			throughput.Y.Label.Text = throughputLabel("Throughput")
+			throughputTicks(&throughput.Y)
+			throughput.Y.Min = 0
+			latency.Y.Label.Text = latencyLabel("p99 response time")
+			opts.latencyAxis(&latency.Y, lowestLatency(results))
+			var labels []string
+			lines := map[string][]BenchmarkResult{}
+			for _, r := range facets[v] {
+				label := lineLabel(r.Config)
+				if _, ok := lines[label]; !ok {
+					labels = append(labels, label)
+				}
+				lines[label] = append(lines[label], r)
+			}
+			for _, label := range labels {
+				c := plotutil.Color(colors[label])
+				line, err := sweepLine(throughput, lines[label], func(r BenchmarkResult) float64 { return r.ThroughputRps }, c)
+				if err != nil {
+					return err
+				}
+				if _, err := sweepLine(latency, lines[label], func(r BenchmarkResult) float64 { return r.ResponseTimesPercentile(99) }, c); err != nil {
+					return err
+				}
+				// Each line is named once, in the first facet it appears in.
+				if line != nil && label != "" && !legended[label] {
+					legended[label] = true
+					throughput.Legend.Add(label, line)
+				}
+			}
+			throughput.Legend.Top = true
+			throughput.Legend.Left = true
+			for _, plt := range []*plot.Plot{throughput, latency} {
+				plt.X.Label.Text = "Number of Co-Routines"
+				opts.fitCoroutines(&plt.X)
+			}
+			opts.fitLatency(&latency.Y)
+			plots[0][col], plots[1][col] = throughput, latency
+		}
+
+		width := vg.Length(len(values)) * 3.5 * vg.Inch
+		err := opts.saveCanvas(width, 7*vg.Inch, opts.sweepFile("grid_by_"+facet.file+".png"), func(dc draw.Canvas) {
+			tiles := draw.Tiles{Rows: 2, Cols: len(values), PadX: vg.Millimeter * 6, PadY: vg.Millimeter * 6,
+				PadTop: vg.Millimeter * 2, PadBottom: vg.Millimeter * 2, PadLeft: vg.Millimeter * 2, PadRight: vg.Millimeter * 2}
+			canvases := plot.Align(plots, tiles, dc)
+			for i := range plots {
+				for j := range plots[i] {
+					plots[i][j].Draw(canvases[i][j])
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
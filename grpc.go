@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const grpcEchoMethod = "/perf.Echo/Echo"
+
+// GRPCBackend spends network time on unary gRPC calls to a built-in localhost server, which echoes a protobuf
+// payload back after the delay passed in the request metadata. All calls share one HTTP/2 connection, as a real
+// client would.
+type GRPCBackend struct {
+	PayloadSize int
+
+	server  *grpc.Server
+	conn    *grpc.ClientConn
+	payload []byte
+}
+
+var grpcEchoService = grpc.ServiceDesc{
+	ServiceName: "perf.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Echo",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := &wrapperspb.BytesValue{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			md, _ := metadata.FromIncomingContext(ctx)
+			if values := md.Get("x-delay"); len(values) > 0 {
+				d, err := time.ParseDuration(values[0])
+				if err != nil {
+					return nil, err
+				}
+				time.Sleep(d)
+			}
+			return in, nil
+		},
+	}},
+}
+
+func (g *GRPCBackend) Start() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	g.server = grpc.NewServer()
+	g.server.RegisterService(&grpcEchoService, struct{}{})
+	go g.server.Serve(ln)
+
+	g.conn, err = grpc.Dial(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		g.server.Stop()
+		return err
+	}
+	g.payload = make([]byte, g.PayloadSize)
+	return nil
+}
+
+func (g *GRPCBackend) Close() error {
+	err := g.conn.Close()
+	g.server.Stop()
+	return err
+}
+
+func (g *GRPCBackend) Call(networkTime time.Duration, log *RequestLog) error {
+	log.Begin(log.now(realClock{}), "network", "network time over gRPC", networkTime)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-delay", networkTime.String())
+	out := &wrapperspb.BytesValue{}
+	if err := g.conn.Invoke(ctx, grpcEchoMethod, wrapperspb.Bytes(g.payload), out); err != nil {
+		return err
+	}
+	log.End(log.now(realClock{}))
+	return nil
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// HarnessOverhead is the time the harness itself spent measuring a run, which should stay negligible next to the
+// latencies it reports. DeliverPerSample is paid by request goroutines handing results over, CollectPerSample by the
+// collecting goroutine.
+type HarnessOverhead struct {
+	Batch            int
+	DeliverPerSample time.Duration
+	CollectPerSample time.Duration
+}
+
+// resultSink delivers completed requests to the collector. At high request rates a send per request makes the results
+// channel a point of contention, so results are buffered in shards and sent batch at a time. Requests are spread over
+// the shards by index, which with one shard per P keeps most appends uncontended.
+type resultSink struct {
+	c      chan []WorkResult
+	batch  int
+	shards []resultShard
+	nanos  int64
+}
+
+type resultShard struct {
+	resultShardState
+	_ [cacheLineSize - unsafe.Sizeof(resultShardState{})%cacheLineSize]byte // keep shards on separate cache lines
+}
+
+type resultShardState struct {
+	mu  sync.Mutex
+	buf []WorkResult
+}
+
+// cacheLineSize is what shards are padded to, so that neighbouring shards written by different Ps don't share a line.
+const cacheLineSize = 64
+
+func newResultSink(expected, batch int) *resultSink {
+	if batch < 1 {
+		batch = 1
+	}
+	s := &resultSink{
+		c:      make(chan []WorkResult, expected/batch+runtime.GOMAXPROCS(0)),
+		batch:  batch,
+		shards: make([]resultShard, runtime.GOMAXPROCS(0)),
+	}
+	for i := range s.shards {
+		s.shards[i].buf = make([]WorkResult, 0, batch)
+	}
+	return s
+}
+
+func (s *resultSink) send(result WorkResult) {
+	begin := time.Now()
+	shard := &s.shards[result.request%len(s.shards)]
+	shard.mu.Lock()
+	shard.buf = append(shard.buf, result)
+	var full []WorkResult
+	if len(shard.buf) >= s.batch {
+		full = shard.buf
+		shard.buf = make([]WorkResult, 0, s.batch)
+	}
+	shard.mu.Unlock()
+	if full != nil {
+		s.c <- full
+	}
+	atomic.AddInt64(&s.nanos, int64(time.Since(begin)))
+}
+
+// close flushes partially filled batches once every request has finished.
+func (s *resultSink) close() {
+	for i := range s.shards {
+		if len(s.shards[i].buf) > 0 {
+			s.c <- s.shards[i].buf
+		}
+	}
+	close(s.c)
+}
+
+// runCollection accumulates a run's results.
+type runCollection struct {
+	responseTimes  Collector
+	queueWaits     Collector // of the requests in responseTimes
+	callerTimes    Collector // their queue waits plus response times
+	classes        map[string]Collector
+	classCallers   map[string]Collector // caller times, queue wait included, by class
+	errorTimes     Collector            // of the requests that failed
+	longestRequest WorkResult
+	slowest        []WorkResult // the slowestN slowest requests, slowest first
+	slowestN       int
+	errors         int
+	timeouts       int
+	busy           time.Duration       // response times of every completed request, timed out or not
+	wasted         time.Duration       // spent by those requests past their deadlines
+	queued         time.Duration       // queue waits of every completed request
+	budget         *budgetCollector    // nil unless the run's latency budget is broken down
+	interval       time.Duration       // of completions; zero for none
+	completions    []int               // requests completed, timed out or not, in each interval of the run
+	trace          *latencyTrace       // nil unless the run's requests are traced
+	phases         *phaseCollector     // nil unless the run's phases are recorded
+	requests       *requestTraceWriter // shared by every shard; nil unless the run's requests are traced
+	sampler        *traceSampler       // shared by every shard; nil unless some requests' full traces are kept
+	brownout       *Brownout           // nil unless faults only strike for part of the run
+	steady         []steadySample      // of the requests in responseTimes; nil unless Config.SteadyOnly
+	keepSteady     bool
+	events         int         // log events every completed request recorded
+	exitStatuses   map[int]int // of the commands that failed, by status; nil unless the run execs commands
+}
+
+func newRunCollection(cfg RunConfig, expected int) (*runCollection, error) {
+	responseTimes, err := newCollector(cfg.Collector, cfg.ReservoirSize, expected, newRand(cfg.Seed, collectorRandStream))
+	if err != nil {
+		return nil, err
+	}
+	rc := &runCollection{responseTimes: responseTimes, classes: map[string]Collector{}, classCallers: map[string]Collector{},
+		slowestN: cfg.SlowestRequests, interval: cfg.ThroughputInterval}
+	for _, c := range []*Collector{&rc.queueWaits, &rc.callerTimes, &rc.errorTimes} {
+		if *c, err = newCollector(cfg.Collector, cfg.ReservoirSize, expected, newRand(cfg.Seed, collectorRandStream)); err != nil {
+			return nil, err
+		}
+	}
+	rc.trace = newLatencyTrace(cfg.TracePoints)
+	if cfg.Exec != nil {
+		rc.exitStatuses = map[int]int{}
+	}
+	if rc.brownout, err = newBrownout(cfg, expected); err != nil {
+		return nil, err
+	}
+	rc.requests = cfg.requestTrace
+	rc.sampler = cfg.traceSampler
+	rc.keepSteady = cfg.SteadyOnly
+	if cfg.Phases {
+		if rc.phases, err = newPhaseCollector(cfg, expected); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.BudgetAt > 0 && cfg.BudgetAt == cfg.NumCoroutines {
+		rc.budget = &budgetCollector{}
+	}
+	for _, class := range cfg.Mix {
+		rc.classes[class.Name], err = newCollector(cfg.Collector, cfg.ReservoirSize, expected, newRand(cfg.Seed, collectorRandStream))
+		if err != nil {
+			return nil, err
+		}
+		rc.classCallers[class.Name], err = newCollector(cfg.Collector, cfg.ReservoirSize, expected, newRand(cfg.Seed, collectorRandStream))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+func (rc *runCollection) add(result WorkResult, reporter Reporter) {
+	if result.timedOut {
+		rc.timeouts++
+	} else if result.err != nil {
+		rc.errors++
+	}
+	if result.timeTaken > rc.longestRequest.timeTaken {
+		rc.longestRequest = result
+	}
+	rc.keepSlowest(result)
+	rc.busy += result.timeTaken
+	rc.wasted += result.wasted
+	rc.queued += result.queueWait
+	if result.log != nil {
+		rc.events += len(result.log.Events)
+	}
+	if rc.exitStatuses != nil && result.err != nil {
+		rc.exitStatuses[execStatus(result.err)]++
+	}
+	if rc.interval > 0 {
+		i := int(result.finished / rc.interval)
+		for len(rc.completions) <= i {
+			rc.completions = append(rc.completions, 0)
+		}
+		rc.completions[i]++
+	}
+	if rc.trace != nil {
+		rc.trace.add(result)
+	}
+	if rc.requests != nil {
+		rc.requests.write(result)
+	}
+	if rc.sampler != nil {
+		rc.sampler.add(result)
+	}
+	if rc.brownout != nil {
+		rc.brownout.add(result)
+	}
+	if result.timedOut {
+		return
+	}
+	if rc.budget != nil {
+		rc.budget.add(result)
+	}
+	if rc.phases != nil {
+		rc.phases.add(result)
+	}
+	ms := float64(result.timeTaken) / float64(time.Millisecond)
+	rc.responseTimes.Add(ms)
+	if rc.keepSteady {
+		rc.steady = append(rc.steady, steadySample{finished: result.finished, ms: ms})
+	}
+	wait := float64(result.queueWait) / float64(time.Millisecond)
+	rc.queueWaits.Add(wait)
+	rc.callerTimes.Add(wait + ms)
+	if result.err != nil {
+		rc.errorTimes.Add(ms)
+	}
+	if result.class != "" {
+		rc.classes[result.class].Add(ms)
+		rc.classCallers[result.class].Add(wait + ms)
+	}
+	if reporter == nil {
+		return // the caller delivers the sample itself
+	}
+	reporter.OnSample(Sample{Request: result.request, Latency: result.timeTaken})
+}
+
+func (rc *runCollection) keepSlowest(result WorkResult) {
+	n := len(rc.slowest)
+	if rc.slowestN == 0 || (n == rc.slowestN && result.timeTaken <= rc.slowest[n-1].timeTaken) {
+		return
+	}
+	i := sort.Search(n, func(i int) bool { return rc.slowest[i].timeTaken < result.timeTaken })
+	rc.slowest = append(rc.slowest, WorkResult{})
+	copy(rc.slowest[i+1:], rc.slowest[i:])
+	rc.slowest[i] = result
+	if len(rc.slowest) > rc.slowestN {
+		rc.slowest = rc.slowest[:rc.slowestN]
+	}
+}
+
+func (rc *runCollection) merge(other *runCollection) {
+	rc.responseTimes.Merge(other.responseTimes)
+	rc.queueWaits.Merge(other.queueWaits)
+	rc.callerTimes.Merge(other.callerTimes)
+	rc.errorTimes.Merge(other.errorTimes)
+	if other.brownout != nil {
+		rc.brownout.merge(other.brownout)
+	}
+	for name, c := range other.classes {
+		rc.classes[name].Merge(c)
+		rc.classCallers[name].Merge(other.classCallers[name])
+	}
+	if other.longestRequest.timeTaken > rc.longestRequest.timeTaken {
+		rc.longestRequest = other.longestRequest
+	}
+	for _, result := range other.slowest {
+		rc.keepSlowest(result)
+	}
+	rc.steady = append(rc.steady, other.steady...)
+	rc.errors += other.errors
+	rc.timeouts += other.timeouts
+	rc.busy += other.busy
+	rc.wasted += other.wasted
+	rc.queued += other.queued
+	rc.events += other.events
+	for status, n := range other.exitStatuses {
+		rc.exitStatuses[status] += n
+	}
+	for i, n := range other.completions {
+		for len(rc.completions) <= i {
+			rc.completions = append(rc.completions, 0)
+		}
+		rc.completions[i] += n
+	}
+	if rc.trace != nil {
+		rc.trace.merge(other.trace)
+	}
+	if rc.budget != nil {
+		rc.budget.merge(other.budget)
+	}
+	if rc.phases != nil {
+		rc.phases.merge(other.phases)
+	}
+}
+
+// expectedRequests is the most requests a run may complete, which its collectors are sized for up front so that
+// recording a sample never grows them mid-run.
+func (cfg RunConfig) expectedRequests() int {
+	if cfg.Precision > 0 {
+		return cfg.MaxIterations
+	}
+	return cfg.Iterations
+}
+
+// maxInFlight bounds how many requests can run at once, and so how many worker slots they take.
+func (cfg RunConfig) maxInFlight() int {
+	n := cfg.expectedRequests()
+	if cfg.Executor != "unbounded" && int(cfg.NumCoroutines) < n {
+		n = int(cfg.NumCoroutines)
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// aggregation is how completed requests reach the run's collection: send is called by every request goroutine and
+// finish, once all of them are done, returns the collection.
+type aggregation interface {
+	send(result WorkResult)
+	finish() (*runCollection, HarnessOverhead)
+}
+
+func newAggregation(cfg RunConfig, reporter Reporter) (aggregation, error) {
+	switch cfg.Aggregation {
+	case "", "channel":
+		rc, err := newRunCollection(cfg, cfg.expectedRequests())
+		if err != nil {
+			return nil, err
+		}
+		a := &channelAggregation{sink: newResultSink(cfg.expectedRequests(), cfg.ResultBatch), collection: rc}
+		a.start(reporter)
+		return a, nil
+	case "sharded":
+		return newShardedAggregation(cfg, reporter)
+	case "preallocated":
+		rc, err := newRunCollection(cfg, cfg.expectedRequests())
+		if err != nil {
+			return nil, err
+		}
+		n := cfg.expectedRequests()
+		return &preallocatedAggregation{results: make([]WorkResult, n), done: make([]bool, n), collection: rc, reporter: reporter}, nil
+	}
+	return nil, fmt.Errorf("unknown aggregation %q", cfg.Aggregation)
+}
+
+// channelAggregation funnels results through a resultSink to a dedicated collecting goroutine, so that recording a
+// sample never competes with the dispatch loop and the dispatch loop never delays recording.
+type channelAggregation struct {
+	sink       *resultSink
+	collection *runCollection
+	busy       time.Duration
+	done       chan struct{}
+}
+
+// start collects until the sink is closed. The collecting goroutine holds its own OS thread, the nearest Go gets to
+// giving it priority over request goroutines, and times itself so its cost can be reported.
+func (a *channelAggregation) start(reporter Reporter) {
+	a.done = make(chan struct{})
+	go func() {
+		defer close(a.done)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		for batch := range a.sink.c {
+			begin := time.Now()
+			for _, result := range batch {
+				a.collection.add(result, reporter)
+			}
+			a.busy += time.Since(begin)
+		}
+	}()
+}
+
+func (a *channelAggregation) send(result WorkResult) {
+	a.sink.send(result)
+}
+
+func (a *channelAggregation) finish() (*runCollection, HarnessOverhead) {
+	a.sink.close()
+	<-a.done
+	overhead := HarnessOverhead{Batch: a.sink.batch}
+	if n := a.collection.responseTimes.Count(); n > 0 {
+		overhead.DeliverPerSample = time.Duration(atomic.LoadInt64(&a.sink.nanos)) / time.Duration(n)
+		overhead.CollectPerSample = a.busy / time.Duration(n)
+	}
+	return a.collection, overhead
+}
+
+// shardedAggregation has request goroutines record straight into a padded shard of their own, each with its own
+// collectors, which are merged once the run is over. Shards are keyed by worker slot, which a request holds until its
+// result is recorded, so every shard has exactly one writer at a time and needs no lock: the measurement path shares
+// nothing between workers, and does not serialize them the way a single channel does. Reporters see OnSample calls
+// from many goroutines at once.
+type shardedAggregation struct {
+	shards   []aggregationShard // by worker slot
+	reporter Reporter
+}
+
+type aggregationShard struct {
+	aggregationShardState
+	_ [cacheLineSize - unsafe.Sizeof(aggregationShardState{})%cacheLineSize]byte // keep shards on separate cache lines
+}
+
+type aggregationShardState struct {
+	collection *runCollection
+	nanos      int64 // spent recording into this shard
+}
+
+func newShardedAggregation(cfg RunConfig, reporter Reporter) (*shardedAggregation, error) {
+	n := cfg.maxInFlight()
+	a := &shardedAggregation{shards: make([]aggregationShard, n), reporter: reporter}
+	for i := range a.shards {
+		rc, err := newRunCollection(cfg, cfg.expectedRequests()/n+1)
+		if err != nil {
+			return nil, err
+		}
+		a.shards[i].collection = rc
+	}
+	return a, nil
+}
+
+// send must be called by the request holding result.worker, before it gives the slot up.
+func (a *shardedAggregation) send(result WorkResult) {
+	begin := time.Now()
+	shard := &a.shards[result.worker]
+	shard.collection.add(result, nil)
+	shard.nanos += int64(time.Since(begin))
+	if !result.timedOut {
+		a.reporter.OnSample(Sample{Request: result.request, Latency: result.timeTaken})
+	}
+}
+
+func (a *shardedAggregation) finish() (*runCollection, HarnessOverhead) {
+	begin := time.Now()
+	rc := a.shards[0].collection
+	deliver := a.shards[0].nanos
+	for i := 1; i < len(a.shards); i++ {
+		rc.merge(a.shards[i].collection)
+		deliver += a.shards[i].nanos
+	}
+	overhead := HarnessOverhead{Batch: 1}
+	if n := rc.responseTimes.Count(); n > 0 {
+		overhead.DeliverPerSample = time.Duration(deliver) / time.Duration(n)
+		overhead.CollectPerSample = time.Since(begin) / time.Duration(n)
+	}
+	return rc, overhead
+}
+
+// preallocatedAggregation gives every request a slot of its own, by request index, in an array sized for the whole
+// run, and only collects once the run is over. Request goroutines share no lock and no channel, and never allocate,
+// so that even at very high co-routine counts recording a result can't serialize them; the price is that reporters
+// see no samples until the run has finished, and the array holds every result at once.
+type preallocatedAggregation struct {
+	results    []WorkResult
+	done       []bool // which slots hold a result
+	collection *runCollection
+	reporter   Reporter
+	nanos      int64
+}
+
+func (a *preallocatedAggregation) send(result WorkResult) {
+	begin := time.Now()
+	a.results[result.request] = result
+	a.done[result.request] = true
+	atomic.AddInt64(&a.nanos, int64(time.Since(begin)))
+}
+
+func (a *preallocatedAggregation) finish() (*runCollection, HarnessOverhead) {
+	begin := time.Now()
+	for i := range a.results {
+		if a.done[i] {
+			a.collection.add(a.results[i], a.reporter)
+		}
+	}
+	a.results = nil
+	overhead := HarnessOverhead{Batch: 1}
+	if n := a.collection.responseTimes.Count(); n > 0 {
+		overhead.DeliverPerSample = time.Duration(atomic.LoadInt64(&a.nanos)) / time.Duration(n)
+		overhead.CollectPerSample = time.Since(begin) / time.Duration(n)
+	}
+	return a.collection, overhead
+}
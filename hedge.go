@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// HedgePolicy hedges the calls of a network phase, as a tail-tolerant client would: when a call has not answered
+// After it was sent, a duplicate goes out, up to Max of them one After apart, and the first to succeed answers for the
+// phase. The others are left to finish on their own, so that the downstream does all their work and the load it sees
+// grows with every hedge sent. Each duplicate draws its own network time from -network-dist, so that hedging pays off
+// where that is heavy-tailed and a second draw is unlikely to be slow too. An After of zero never hedges.
+type HedgePolicy struct {
+	After time.Duration `json:"after"`
+	Max   int           `json:"max"`
+}
+
+func (p HedgePolicy) enabled() bool {
+	return p.After > 0
+}
+
+func (p HedgePolicy) validate() error {
+	if p.After < 0 {
+		return fmt.Errorf("hedge delay must not be negative, got %v", p.After)
+	}
+	if p.Max < 1 {
+		return fmt.Errorf("hedging needs at least one duplicate call, got %d", p.Max)
+	}
+	return nil
+}
+
+func (p HedgePolicy) String() string {
+	if p.Max == 1 {
+		return fmt.Sprintf("a duplicate call after %v", p.After)
+	}
+	return fmt.Sprintf("up to %d duplicate calls, %v apart", p.Max, p.After)
+}
+
+// key names the hedge delay a run was swept at, for file names and labels.
+func (p HedgePolicy) key() string {
+	if !p.enabled() {
+		return "off"
+	}
+	return formatDuration(p.After)
+}
+
+// parseHedgeDelays parses -hedge-after: a comma-separated list of delays to sweep, where off or 0 does not hedge.
+func parseHedgeDelays(s string) ([]time.Duration, error) {
+	var values []time.Duration
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "off" || v == "0" {
+			values = append(values, 0)
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid hedge delay %q", v)
+		}
+		values = append(values, d)
+	}
+	return values, nil
+}
+
+// HedgeStats is what hedging did over a run.
+type HedgeStats struct {
+	Sent int64 `json:"sent"` // duplicate calls made
+	Won  int64 `json:"won"`  // network phases a duplicate answered for, rather than the first call
+}
+
+type hedgeOutcome struct {
+	call int
+	err  error
+}
+
+// callHedged makes one call of a network phase, hedging it as cfg.Hedge has it, and returns how many calls that took
+// and the phase's outcome: the first success, or the last failure when every call sent failed. Each call's outcome is
+// recorded with the breaker as it comes in, the late ones included, and a duplicate the breaker turns away is not
+// sent.
+func callHedged(cfg RunConfig, r *rand.Rand, networkTime time.Duration, log *RequestLog) (int, error) {
+	if !cfg.Hedge.enabled() {
+		err := callDownstream(cfg, r, networkTime, log)
+		cfg.downstream.record(err != nil)
+		return 1, err
+	}
+	clock := cfg.clock()
+	// Buffered for every call there can be, so that the ones that lose never block on a phase that is over.
+	done := make(chan hedgeOutcome, cfg.Hedge.Max+1)
+	send := func(call int, d time.Duration) {
+		// The request's random stream is not safe to share, so each call gets one of its own, seeded from it.
+		cr := rand.New(&splitMix64{state: r.Uint64()})
+		go func() {
+			// A call's own log would interleave with its siblings', so the phase is logged here as a whole.
+			err := callDownstream(cfg, cr, d, nil)
+			cfg.downstream.record(err != nil)
+			done <- hedgeOutcome{call: call, err: err}
+		}()
+	}
+	log.Begin(log.now(clock), "network", "hedged network time", networkTime)
+	send(0, networkTime)
+	sent, pending, hedging := 1, 1, true
+	var err error
+	for {
+		var hedge <-chan time.Time
+		if hedging && sent <= cfg.Hedge.Max {
+			hedge = clock.After(cfg.Hedge.After)
+		}
+		select {
+		case o := <-done:
+			pending--
+			if err = o.err; err == nil || pending == 0 {
+				log.End(log.now(clock))
+				cfg.downstream.hedged(sent-1, err == nil && o.call > 0)
+				if o.call > 0 && log.Enabled(LogDebug) {
+					log.Debugf(clock.Now(), "duplicate call #%d answered first, of %d sent", o.call, sent)
+				}
+				return sent, err
+			}
+			// Another call is still out and may yet succeed; hedging on is left to it.
+			hedging = false
+		case <-hedge:
+			if !cfg.downstream.allow() {
+				hedging = false
+				log.Debugf(clock.Now(), "duplicate call rejected by the open circuit breaker")
+				continue
+			}
+			// A duplicate is a fresh call, so its time is drawn around the phase's mean rather than this call's draw.
+			send(sent, cfg.NetworkDist.Sample(r, cfg.SplitsDist.phaseTime(cfg.NetworkTime, cfg.Splits)))
+			sent++
+			pending++
+		}
+	}
+}
+
+func (d *downstream) hedged(sent int, won bool) {
+	if d == nil {
+		return
+	}
+	atomic.AddInt64(&d.hedges, int64(sent))
+	if won {
+		atomic.AddInt64(&d.hedgeWins, 1)
+	}
+}
+
+// hedgeStats returns what hedging did so far, or nil when the run did not hedge.
+func (d *downstream) hedgeStats(cfg RunConfig) *HedgeStats {
+	if d == nil || !cfg.Hedge.enabled() {
+		return nil
+	}
+	return &HedgeStats{Sent: atomic.LoadInt64(&d.hedges), Won: atomic.LoadInt64(&d.hedgeWins)}
+}
+
+func outputHedging(result BenchmarkResult) {
+	h := result.Hedges
+	if h == nil {
+		return
+	}
+	fmt.Printf("\tHedging: %v\n", result.Config.Hedge)
+	if result.NetworkPhases == 0 {
+		return
+	}
+	fmt.Printf("\tHedged %s network phases of %s, a duplicate answering first for %s; downstream load %s× (%s calls)\n",
+		formatPercent(float64(h.Sent)/float64(result.NetworkPhases)), formatCount(result.NetworkPhases),
+		formatPercent(float64(h.Won)/float64(result.NetworkPhases)), formatFloat(result.Amplification(), 3),
+		formatCount(result.DownstreamCalls))
+}
+
+// plotHedging draws the cost and benefit of hedging for sweeps of several -hedge-after delays: p99 response time
+// against the downstream calls made per network phase, a line for each co-routine count through its delays, so that
+// how much tail latency each extra call buys can be read off as the line falls and moves right.
+func plotHedging(results []BenchmarkResult, opts PlotOptions) error {
+	delays := map[time.Duration]bool{}
+	for _, r := range results {
+		delays[r.Config.Hedge.After] = true
+	}
+	if len(delays) < 2 {
+		return nil
+	}
+	// A line is every run that differs only in its hedge delay, and its repetition.
+	type lineKey struct {
+		series     string
+		coroutines int64
+	}
+	lines := map[lineKey][]BenchmarkResult{}
+	var keys []lineKey
+	for _, r := range results {
+		if r.ResponseTimes == nil || r.ResponseTimes.Count() == 0 {
+			continue
+		}
+		cfg := r.Config
+		cfg.Hedge = HedgePolicy{}
+		k := lineKey{speedupSeriesKey(cfg), r.NumCoroutines}
+		if lines[k] == nil {
+			keys = append(keys, k)
+		}
+		lines[k] = append(lines[k], r)
+	}
+	plt := plot.New()
+	plt.Title.Text = "Tail Latency vs. Downstream Load with Hedging"
+	plt.X.Label.Text = "Downstream calls per network phase"
+	plt.Y.Label.Text = latencyLabel("p99 response time")
+	opts.latencyAxis(&plt.Y, lowestLatency(results))
+	for i, k := range keys {
+		runs := lines[k]
+		// From not hedging at all to hedging soonest, as the load grows.
+		sort.SliceStable(runs, func(a, b int) bool {
+			da, db := runs[a].Config.Hedge.After, runs[b].Config.Hedge.After
+			return db != 0 && (da == 0 || da > db)
+		})
+		var pts plotter.XYs
+		var names []string
+		for _, r := range runs {
+			load := r.Amplification()
+			if load == 0 {
+				load = 1 // a run that did not hedge, retry or fault made one call a phase and did not count them
+			}
+			pts = append(pts, plotter.XY{X: load, Y: r.ResponseTimesPercentile(99)})
+			names = append(names, r.Config.Hedge.key())
+		}
+		line, points, err := plotter.NewLinePoints(pts)
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = plotutil.Color(i)
+		points.GlyphStyle.Color = plotutil.Color(i)
+		points.GlyphStyle.Shape = draw.CircleGlyph{}
+		plt.Add(line, points)
+		plt.Legend.Add(fmt.Sprintf("%d co-routines", k.coroutines), line, points)
+		annotations, err := plotter.NewLabels(plotter.XYLabels{XYs: pts, Labels: names})
+		if err != nil {
+			return err
+		}
+		for j := range annotations.TextStyle {
+			annotations.TextStyle[j].XAlign = draw.XLeft
+			annotations.TextStyle[j].YAlign = draw.YBottom
+		}
+		annotations.Offset = vg.Point{X: vg.Points(3), Y: vg.Points(3)}
+		plt.Add(annotations)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	plt.X.Min = 1
+	opts.fitLatency(&plt.Y)
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("hedging.png"))
+}
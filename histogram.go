@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// defaultHistBins is the number of bins a histogram has when neither -plot-hist-bins nor -plot-hist-bin-width says.
+const defaultHistBins = 20
+
+// histogramRange is the range a histogram of values spans: -plot-hist-range's bounds where set, and the values' own
+// lowest and highest otherwise.
+func (o PlotOptions) histogramRange(values []float64) (lo, hi float64) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if o.HistMin != 0 {
+		lo = o.HistMin
+	}
+	if o.HistMax != 0 {
+		hi = o.HistMax
+	}
+	return lo, hi
+}
+
+// newHistogram bins values over lo to hi, in bins HistBinWidth ms wide where that is set and HistBins of them (or
+// defaultHistBins) otherwise, and returns the histogram and how many values fell outside the range and were left out.
+func (o PlotOptions) newHistogram(values []float64, lo, hi float64) (*plotter.Histogram, int) {
+	n := o.HistBins
+	if n <= 0 {
+		n = defaultHistBins
+	}
+	width := (hi - lo) / float64(n)
+	if o.HistBinWidth > 0 {
+		width = o.HistBinWidth
+		n = int(math.Ceil((hi - lo) / width))
+	}
+	if n < 1 || !(width > 0) {
+		n, width = 1, math.Max(hi-lo, 1)
+	}
+	bins := make([]plotter.HistogramBin, n)
+	for i := range bins {
+		bins[i].Min = lo + float64(i)*width
+		bins[i].Max = lo + float64(i+1)*width
+	}
+	outside := 0
+	for _, v := range values {
+		if v < lo || v > hi {
+			outside++
+			continue
+		}
+		i := int((v - lo) / width)
+		if i >= n {
+			i = n - 1 // the highest value closes the last bin
+		}
+		bins[i].Weight++
+	}
+	return &plotter.Histogram{Bins: bins, Width: width, FillColor: color.Gray{Y: 128}, LineStyle: plotter.DefaultLineStyle}, outside
+}
+
+// plotHistogramGrid draws the response time histogram of every run as small multiples, with -plot-hist-grid: one
+// panel a run, series by series, on the same bins and axes, so that the distribution can be watched changing shape as
+// the co-routine count grows.
+func plotHistogramGrid(results []BenchmarkResult, opts PlotOptions) error {
+	if !opts.HistGrid {
+		return nil
+	}
+	labels, series := bySeries(results)
+	var runs []BenchmarkResult
+	var runLabels []string
+	var all []float64
+	for _, label := range labels {
+		for _, r := range series[label] {
+			if r.ResponseTimes != nil && r.ResponseTimes.Count() > 0 {
+				runs = append(runs, r)
+				runLabels = append(runLabels, label)
+				all = append(all, r.ResponseTimes.Values()...)
+			}
+		}
+	}
+	if len(runs) < 2 {
+		return nil
+	}
+	lo, hi := opts.histogramRange(all)
+	cols := int(math.Ceil(math.Sqrt(float64(len(runs)))))
+	rows := (len(runs) + cols - 1) / cols
+	plots := make([][]*plot.Plot, rows)
+	for i := range plots {
+		plots[i] = make([]*plot.Plot, cols)
+	}
+	top := 0.0
+	for i, r := range runs {
+		p := plot.New()
+		hist, outside := opts.newHistogram(r.ResponseTimes.Values(), lo, hi)
+		p.Add(hist)
+		p.Title.Text = fmt.Sprintf("%d co-routines", r.NumCoroutines)
+		if len(labels) > 1 {
+			p.Title.Text = runLabels[i] + ", " + p.Title.Text
+		}
+		if r.Config.Repetition > 0 {
+			p.Title.Text += fmt.Sprintf(" (#%d)", r.Config.Repetition+1)
+		}
+		if outside > 0 {
+			p.Title.Text += fmt.Sprintf(", %d outside", outside)
+		}
+		p.X.Min, p.X.Max = lo, hi
+		p.Y.Min = 0
+		for _, b := range hist.Bins {
+			top = math.Max(top, b.Weight)
+		}
+		if i/cols == rows-1 {
+			p.X.Label.Text = latencyLabel("Response time")
+		}
+		if i%cols == 0 {
+			p.Y.Label.Text = "Requests"
+		}
+		plots[i/cols][i%cols] = p
+	}
+	for _, row := range plots {
+		for _, p := range row {
+			if p != nil {
+				p.Y.Max = top
+			}
+		}
+	}
+	return opts.saveCanvas(vg.Length(cols)*3*vg.Inch, vg.Length(rows)*2.5*vg.Inch, opts.sweepFile("hist_grid.png"), func(dc draw.Canvas) {
+		tiles := draw.Tiles{Rows: rows, Cols: cols, PadX: vg.Millimeter * 4, PadY: vg.Millimeter * 4, PadTop: vg.Millimeter * 2,
+			PadBottom: vg.Millimeter * 2, PadLeft: vg.Millimeter * 2, PadRight: vg.Millimeter * 2}
+		canvases := plot.Align(plots, tiles, dc)
+		for i := range plots {
+			for j, p := range plots[i] {
+				if p != nil {
+					p.Draw(canvases[i][j])
+				}
+			}
+		}
+	})
+}
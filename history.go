@@ -0,0 +1,342 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// parseDate accepts a date such as 2024-05-01, taken as midnight local time, or a full RFC 3339 timestamp.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// hasTags reports whether every one of want is among the run's tags; a wanted tag without a value matches any value.
+func hasTags(have map[string]string, want Tags) bool {
+	for k, v := range want {
+		got, ok := have[k]
+		if !ok || (v != "" && got != v) {
+			return false
+		}
+	}
+	return true
+}
+
+// ewma tracks an exponentially weighted moving mean and variance of a series, so that a run can be compared against
+// the recent trend rather than the whole history.
+type ewma struct {
+	alpha    float64
+	mean     float64
+	variance float64
+	n        int
+}
+
+// observe returns how many standard deviations v lies from the trend so far, then folds v into it. ok is false until
+// warmup values have been seen. The deviation is floored at 5% of the mean, so that a series that has been perfectly
+// steady does not flag the first ordinary wobble.
+func (e *ewma) observe(v float64, warmup int) (z float64, ok bool) {
+	if e.n == 0 {
+		e.mean = v
+	}
+	if e.n >= warmup {
+		std := math.Max(math.Sqrt(e.variance), math.Abs(e.mean)*0.05)
+		if std > 0 {
+			z, ok = (v-e.mean)/std, true
+		}
+	}
+	diff := v - e.mean
+	e.mean += e.alpha * diff
+	e.variance = (1 - e.alpha) * (e.variance + e.alpha*diff*diff)
+	e.n++
+	return z, ok
+}
+
+// historyCommand turns accumulated results files into a performance trend: for each configuration and coroutine count,
+// the throughput and p99 of every matching run in the order they ran.
+func historyCommand(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	scenario := fs.String("scenario", "", "only runs of this scenario (default: all runs)")
+	tags := Tags{}
+	fs.Var(tags, "tag", "only runs carrying this tag, as key=value or a bare key for any value; may be repeated")
+	since := fs.String("since", "", "only runs started on or after this date, as 2006-01-02 or RFC 3339")
+	until := fs.String("until", "", "only runs started before this date, as 2006-01-02 or RFC 3339")
+	coroutines := fs.Int64("coroutines", 0, "only runs with this many co-routines (default: all)")
+	plotTrend := fs.Bool("plot", false, "also plot the trends to history_throughput.png and history_p99.png, or history_<metric>.png with -metric")
+	metric := fs.String("metric", "", "plot only this metric: "+strings.Join(historyMetricNames, ", "))
+	by := fs.String("by", "time", "x axis of the plots: time, or commit to place runs at the commit they measured, in the order the commits first ran")
+	dbPath := fs.String("db", "", "read runs from this SQLite database, recorded by sweeps with -history-db, as well as from any results files")
+	threshold := fs.Float64("anomaly-threshold", 0, "flag runs whose throughput or p99 lies more than this many standard deviations from the series' moving average, and exit non-zero if any do (default: off)")
+	alpha := fs.Float64("anomaly-alpha", 0.3, "weight of each new run in the moving average used by -anomaly-threshold")
+	warmup := fs.Int("anomaly-warmup", 3, "runs of a series seen before -anomaly-threshold starts flagging")
+	applyUnits := unitFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s history [flags] [-db history.db] [results.jsonl...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 && *dbPath == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := applyUnits(); err != nil {
+		return err
+	}
+	if *by != "time" && *by != "commit" {
+		return fmt.Errorf("-by must be time or commit, not %q", *by)
+	}
+	if _, ok := historyMetrics[*metric]; *metric != "" && !ok {
+		return fmt.Errorf("unknown metric %q, expected one of %s", *metric, strings.Join(historyMetricNames, ", "))
+	}
+	var from, to time.Time
+	var err error
+	if *since != "" {
+		if from, err = parseDate(*since); err != nil {
+			return fmt.Errorf("-since: %w", err)
+		}
+	}
+	if *until != "" {
+		if to, err = parseDate(*until); err != nil {
+			return fmt.Errorf("-until: %w", err)
+		}
+	}
+
+	var all []jsonRunSummary
+	if *dbPath != "" {
+		db, err := openHistoryDB(*dbPath)
+		if err != nil {
+			return err
+		}
+		all, err = db.runs()
+		db.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", *dbPath, err)
+		}
+	}
+	for _, path := range fs.Args() {
+		summaries, err := loadResults(path)
+		if err != nil {
+			return err
+		}
+		all = append(all, summaries...)
+	}
+	var runs []jsonRunSummary
+	for _, s := range all {
+		// Runs recorded before start times were saved can't be placed in a date range.
+		dated := !s.Started.IsZero()
+		switch {
+		case *scenario != "" && s.Scenario != *scenario,
+			!hasTags(s.Tags, tags),
+			*coroutines != 0 && s.NumCoroutines != *coroutines,
+			!from.IsZero() && (!dated || s.Started.Before(from)),
+			!to.IsZero() && (!dated || !s.Started.Before(to)):
+			continue
+		}
+		runs = append(runs, s)
+	}
+	if len(runs) == 0 {
+		return errors.New("no runs match")
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].Started.Before(runs[j].Started) })
+
+	var labels []string
+	series := map[string][]jsonRunSummary{}
+	for _, s := range runs {
+		label := fmt.Sprintf("%s, %d co-routines", configLabel(s), s.NumCoroutines)
+		if _, ok := series[label]; !ok {
+			labels = append(labels, label)
+		}
+		series[label] = append(series[label], s)
+	}
+	sort.SliceStable(labels, func(i, j int) bool {
+		a, b := series[labels[i]][0], series[labels[j]][0]
+		if ca, cb := configLabel(a), configLabel(b); ca != cb {
+			return ca < cb
+		}
+		return a.NumCoroutines < b.NumCoroutines
+	})
+
+	anomalies := 0
+	for _, label := range labels {
+		fmt.Println(label + ":")
+		var prev *jsonRunSummary
+		rpsTrend, p99Trend := &ewma{alpha: *alpha}, &ewma{alpha: *alpha}
+		for i, s := range series[label] {
+			when := "unknown time    "
+			if !s.Started.IsZero() {
+				when = s.Started.Local().Format("2006-01-02 15:04")
+			}
+			line := fmt.Sprintf("\t%s  %14s", when, formatRps(s.ThroughputRps))
+			if prev != nil && prev.ThroughputRps > 0 {
+				line += fmt.Sprintf(" (%8s)", formatChange(s.ThroughputRps/prev.ThroughputRps-1))
+			} else {
+				line += "          "
+			}
+			if p99, ok := summaryP99(s); ok {
+				line += fmt.Sprintf("  p99 %10s", formatMs(p99))
+				if prev != nil {
+					if prevP99, ok := summaryP99(*prev); ok && prevP99 > 0 {
+						line += fmt.Sprintf(" (%8s)", formatChange(p99/prevP99-1))
+					}
+				}
+			}
+			if s.Failure != nil {
+				line += "  FAILED (" + string(s.Failure.Kind) + ")"
+			} else if *threshold > 0 {
+				// Failed runs are already flagged, and would drag the trend with them.
+				var deviations []string
+				if z, ok := rpsTrend.observe(s.ThroughputRps, *warmup); ok && math.Abs(z) > *threshold {
+					deviations = append(deviations, fmt.Sprintf("throughput %sσ", formatSigned(z, 1)))
+				}
+				if p99, ok := summaryP99(s); ok {
+					if z, ok := p99Trend.observe(p99, *warmup); ok && math.Abs(z) > *threshold {
+						deviations = append(deviations, fmt.Sprintf("p99 %sσ", formatSigned(z, 1)))
+					}
+				}
+				if len(deviations) > 0 {
+					line += "  ANOMALY (" + strings.Join(deviations, ", ") + ")"
+					anomalies++
+				}
+			}
+			if s.Commit != "" {
+				line += "  @" + s.Commit
+			}
+			if len(s.Tags) > 0 {
+				line += "  [" + Tags(s.Tags).String() + "]"
+			}
+			if s.Note != "" {
+				line += "  " + s.Note
+			}
+			fmt.Println(line)
+			prev = &series[label][i]
+		}
+	}
+
+	if *plotTrend {
+		metrics := []string{"throughput", "p99"}
+		if *metric != "" {
+			metrics = []string{*metric}
+		}
+		for _, name := range metrics {
+			m := historyMetrics[name]
+			if err := plotHistory(labels, series, m, "history_"+name+".png", *by == "commit"); err != nil {
+				return err
+			}
+		}
+	}
+	if anomalies > 0 {
+		return fmt.Errorf("%d runs deviate more than %gσ from their trend", anomalies, *threshold)
+	}
+	return nil
+}
+
+func summaryP99(s jsonRunSummary) (float64, bool) {
+	p99, ok := s.LatencyMs["p99"]
+	return p99, ok
+}
+
+func summaryPercentile(key string) func(jsonRunSummary) (float64, bool) {
+	return func(s jsonRunSummary) (float64, bool) {
+		v, ok := s.LatencyMs[key]
+		return v, ok
+	}
+}
+
+type historyMetric struct {
+	label string
+	unit  string // latency or throughput for a metric written in the chosen units; empty for one without
+	value func(jsonRunSummary) (float64, bool)
+}
+
+// historyMetrics are the metrics history can plot, by the name -metric takes.
+var historyMetrics = map[string]historyMetric{
+	"throughput": {"Throughput", "throughput", func(s jsonRunSummary) (float64, bool) { return s.ThroughputRps, true }},
+	"speedup":    {"Speedup", "", func(s jsonRunSummary) (float64, bool) { return s.Speedup, true }},
+	"cpu":        {"CPU utilization (%)", "", func(s jsonRunSummary) (float64, bool) { return s.CpuUtilization, true }},
+	"cpu-cost":   {"CPU-seconds per 1000 requests", "", func(s jsonRunSummary) (float64, bool) { return s.CPUSecondsPer1000, true }},
+	"errors":     {"Errors", "", func(s jsonRunSummary) (float64, bool) { return float64(s.Errors), true }},
+	"p50":        {"p50 response time", "latency", summaryPercentile("p50")},
+	"p95":        {"p95 response time", "latency", summaryPercentile("p95")},
+	"p99":        {"p99 response time", "latency", summaryP99},
+}
+
+// axis labels and ticks a plot's axis for m.
+func (m historyMetric) axis(a *plot.Axis) {
+	switch m.unit {
+	case "latency":
+		a.Label.Text = latencyLabel(m.label)
+		latencyTicks(a)
+	case "throughput":
+		a.Label.Text = throughputLabel(m.label)
+		throughputTicks(a)
+	default:
+		a.Label.Text = m.label
+	}
+}
+
+var historyMetricNames = []string{"throughput", "speedup", "cpu", "cpu-cost", "errors", "p50", "p95", "p99"}
+
+// plotHistory draws m over time, one line per series, or with byCommit against the commits the runs measured, in the
+// order they first ran. Runs without a start time, or without a commit when plotting by commit, are left out.
+func plotHistory(labels []string, series map[string][]jsonRunSummary, m historyMetric, file string, byCommit bool) error {
+	plt := plot.New()
+	plt.X.Label.Text = "Run started"
+	plt.X.Tick.Marker = plot.TimeTicks{Format: "2006-01-02\n15:04"}
+	commits := map[string]int{}
+	if byCommit {
+		var runs []jsonRunSummary
+		for _, label := range labels {
+			runs = append(runs, series[label]...)
+		}
+		sort.SliceStable(runs, func(i, j int) bool { return runs[i].Started.Before(runs[j].Started) })
+		var ticks []plot.Tick
+		for _, s := range runs {
+			if _, ok := commits[s.Commit]; !ok && s.Commit != "" {
+				commits[s.Commit] = len(ticks)
+				ticks = append(ticks, plot.Tick{Value: float64(len(ticks)), Label: s.Commit})
+			}
+		}
+		plt.X.Label.Text = "Commit"
+		plt.X.Tick.Marker = plot.ConstantTicks(ticks)
+	}
+	m.axis(&plt.Y)
+	plt.Y.Min = 0
+	for i, label := range labels {
+		var pts plotter.XYs
+		for _, s := range series[label] {
+			v, ok := m.value(s)
+			switch {
+			case !ok:
+			case byCommit && s.Commit != "":
+				pts = append(pts, plotter.XY{X: float64(commits[s.Commit]), Y: v})
+			case !byCommit && !s.Started.IsZero():
+				pts = append(pts, plotter.XY{X: float64(s.Started.Unix()), Y: v})
+			}
+		}
+		if len(pts) == 0 {
+			continue
+		}
+		line, points, err := plotter.NewLinePoints(pts)
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = plotutil.Color(i)
+		points.GlyphStyle.Color = plotutil.Color(i)
+		plt.Add(line, points)
+		plt.Legend.Add(label, line, points)
+	}
+	plt.Legend.Left = true
+	return plt.Save(8*vg.Inch, 5*vg.Inch, file)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// historyDB is a local SQLite database recording every run of every sweep, so that performance can be followed across
+// days and commits without keeping results files around. Each row holds the run's parameters and headline metrics in
+// columns, for querying with sqlite3 directly, and its whole summary as JSON, from which the history command reads it
+// back.
+type historyDB struct {
+	db *sql.DB
+}
+
+const historySchema = `CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	started TEXT NOT NULL,
+	git_commit TEXT NOT NULL,
+	scenario TEXT NOT NULL,
+	executor TEXT NOT NULL,
+	gomaxprocs INTEGER NOT NULL,
+	load TEXT NOT NULL,
+	work_time_ms REAL NOT NULL,
+	network_time_ms REAL NOT NULL,
+	num_coroutines INTEGER NOT NULL,
+	iterations INTEGER NOT NULL,
+	seed INTEGER NOT NULL,
+	note TEXT NOT NULL,
+	failure TEXT NOT NULL,
+	throughput_rps REAL NOT NULL,
+	speedup REAL NOT NULL,
+	cpu_utilization REAL NOT NULL,
+	errors INTEGER NOT NULL,
+	timeouts INTEGER NOT NULL,
+	p50_ms REAL,
+	p95_ms REAL,
+	p99_ms REAL,
+	summary TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS runs_started ON runs (started);`
+
+func openHistoryDB(path string) (*historyDB, error) {
+	if sqliteDriver == "" {
+		return nil, errors.New("this build has no SQLite support; rebuild with -tags sqlite, which needs cgo")
+	}
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &historyDB{db: db}, nil
+}
+
+func (h *historyDB) insert(s jsonRunSummary) error {
+	summary, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var failure string
+	if s.Failure != nil {
+		failure = string(s.Failure.Kind)
+	}
+	// Percentiles are NULL for a run in which no request completed.
+	percentile := func(key string) interface{} {
+		if v, ok := s.LatencyMs[key]; ok {
+			return v
+		}
+		return nil
+	}
+	_, err = h.db.Exec(`INSERT INTO runs (started, git_commit, scenario, executor, gomaxprocs, load, work_time_ms,
+		network_time_ms, num_coroutines, iterations, seed, note, failure, throughput_rps, speedup, cpu_utilization, errors,
+		timeouts, p50_ms, p95_ms, p99_ms, summary) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.Started.UTC().Format("2006-01-02T15:04:05.000Z"), s.Commit, s.Scenario, s.Executor, s.GOMAXPROCS, s.Load, s.WorkTimeMs,
+		s.NetworkTimeMs, s.NumCoroutines, s.Iterations, s.Seed, s.Note, failure, s.ThroughputRps, s.Speedup, s.CpuUtilization,
+		s.Errors, s.Timeouts, percentile("p50"), percentile("p95"), percentile("p99"), string(summary))
+	return err
+}
+
+// runs returns every recorded run in the order they started.
+func (h *historyDB) runs() ([]jsonRunSummary, error) {
+	rows, err := h.db.Query(`SELECT id, summary FROM runs ORDER BY started, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var summaries []jsonRunSummary
+	for rows.Next() {
+		var id int64
+		var summary string
+		if err := rows.Scan(&id, &summary); err != nil {
+			return nil, err
+		}
+		s, err := decodeSummary([]byte(summary))
+		if err != nil {
+			return nil, fmt.Errorf("run %d: %w", id, err)
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+func (h *historyDB) Close() error {
+	return h.db.Close()
+}
+
+// historyDBReporter records each run in a historyDB as it completes, so that an interrupted sweep keeps the runs it
+// finished.
+type historyDBReporter struct {
+	db *historyDB
+}
+
+func (r *historyDBReporter) OnRunStart(cfg RunConfig) {}
+
+func (r *historyDBReporter) OnSample(sample Sample) {}
+
+func (r *historyDBReporter) OnRunComplete(result BenchmarkResult) error {
+	return r.db.insert(newRunSummary(result))
+}
+
+func (r *historyDBReporter) Close() error {
+	return r.db.Close()
+}
+
+// gitCommit describes the revision checked out in the working directory, with -dirty appended when it has
+// uncommitted changes, or returns empty outside a git repository.
+func gitCommit() string {
+	out, err := exec.Command("git", "describe", "--always", "--dirty").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
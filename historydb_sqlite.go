@@ -0,0 +1,9 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+import _ "github.com/mattn/go-sqlite3"
+
+// sqliteDriver is the database/sql driver behind -history-db. It needs cgo, so it is only built in with -tags sqlite.
+const sqliteDriver = "sqlite3"
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPBackend replaces the simulated network sleep with a real round-trip to a built-in localhost server that waits
+// for the requested delay before responding, exercising connection pooling, TCP and the runtime's network poller.
+type HTTPBackend struct {
+	MaxIdleConns int
+
+	server *http.Server
+	url    string
+	client *http.Client
+}
+
+func (h *HTTPBackend) Start() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/delay", func(w http.ResponseWriter, r *http.Request) {
+		d, err := time.ParseDuration(r.URL.Query().Get("d"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		time.Sleep(d)
+		io.WriteString(w, "ok")
+	})
+	h.server = &http.Server{Handler: mux}
+	go h.server.Serve(ln)
+
+	h.url = "http://" + ln.Addr().String() + "/delay"
+	h.client = &http.Client{Transport: &http.Transport{
+		MaxIdleConns:        h.MaxIdleConns,
+		MaxIdleConnsPerHost: h.MaxIdleConns,
+	}}
+	return nil
+}
+
+func (h *HTTPBackend) Close() error {
+	h.client.CloseIdleConnections()
+	return h.server.Close()
+}
+
+func (h *HTTPBackend) Call(networkTime time.Duration, log *RequestLog) error {
+	log.Begin(log.now(realClock{}), "network", "network time over HTTP", networkTime)
+	resp, err := h.client.Get(h.url + "?d=" + url.QueryEscape(networkTime.String()))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP backend returned %s", resp.Status)
+	}
+	log.End(log.now(realClock{}))
+	return nil
+}
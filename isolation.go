@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Isolation is what a sweep does between its runs so that one doesn't contaminate the next: a heap grown by an
+// earlier run makes later ones collect garbage less often, timers and the scheduler are still settling right after a
+// busy run, and caches warmed by one configuration flatter the next.
+type Isolation struct {
+	Cooldown    time.Duration // wall-clock pause, so that the machine settles, CPU frequency included
+	GC          bool          // collect garbage and return freed memory to the OS, so that every run starts on an equal heap
+	FlushCaches bool          // evict the CPU caches by writing through a buffer larger than the last-level cache
+}
+
+// between runs before every run of a sweep but the first.
+func (iso Isolation) between() {
+	if iso.GC {
+		runtime.GC()
+		debug.FreeOSMemory()
+	}
+	if iso.FlushCaches {
+		flushCPUCaches()
+	}
+	if iso.Cooldown > 0 {
+		time.Sleep(iso.Cooldown)
+	}
+}
+
+// flushCPUCaches writes through a buffer four times the size of the last-level cache, or of 64MiB where that isn't
+// known, and lets it go. The buffer is allocated anew each time so that it holds nothing the runs use.
+func flushCPUCaches() {
+	size := 4 * lastLevelCacheSize()
+	buf := make([]byte, size)
+	for i := 0; i < len(buf); i += 64 {
+		buf[i] = byte(i)
+	}
+	runtime.KeepAlive(buf)
+}
+
+// lastLevelCacheSize reads the size of the largest CPU cache from sysfs, defaulting to 16MiB elsewhere.
+func lastLevelCacheSize() int {
+	largest := 16 << 20
+	found := false
+	for index := 0; index < 8; index++ {
+		b, err := os.ReadFile("/sys/devices/system/cpu/cpu0/cache/index" + strconv.Itoa(index) + "/size")
+		if err != nil {
+			break
+		}
+		s := strings.TrimSpace(string(b))
+		unit := 1
+		switch {
+		case strings.HasSuffix(s, "K"):
+			unit, s = 1<<10, strings.TrimSuffix(s, "K")
+		case strings.HasSuffix(s, "M"):
+			unit, s = 1<<20, strings.TrimSuffix(s, "M")
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			continue
+		}
+		if !found || n*unit > largest {
+			largest, found = n*unit, true
+		}
+	}
+	return largest
+}
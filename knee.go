@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// Knee is where a series' throughput stops paying for more co-routines: the last co-routine count before the
+// throughput each added co-routine brings falls below Threshold times what the first co-routines brought each.
+// Past it, more concurrency mostly buys queueing, so it is the concurrency to run at.
+type Knee struct {
+	Coroutines    int64
+	ThroughputRps float64 // mean of the runs at Coroutines
+	Speedup       float64 // likewise
+	Threshold     float64
+}
+
+// findKnee finds the knee of a series' successful runs, averaging runs repeated at the same co-routine count. It
+// reports false when fewer than two counts were run, or when throughput was still rising fast enough at the
+// highest count, which leaves the knee past the sweep.
+func findKnee(runs []BenchmarkResult, threshold float64) (Knee, bool) {
+	type point struct {
+		n                   int64
+		throughput, speedup float64
+		runs                int
+	}
+	byCount := map[int64]*point{}
+	var points []*point
+	for _, r := range runs {
+		if r.Failure != nil || r.Err != nil || r.ThroughputRps <= 0 || r.NumCoroutines < 1 {
+			continue
+		}
+		p, ok := byCount[r.NumCoroutines]
+		if !ok {
+			p = &point{n: r.NumCoroutines}
+			byCount[r.NumCoroutines] = p
+			points = append(points, p)
+		}
+		p.throughput += r.ThroughputRps
+		p.speedup += r.Speedup
+		p.runs++
+	}
+	if len(points) < 2 {
+		return Knee{}, false
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].n < points[j].n })
+	for _, p := range points {
+		p.throughput /= float64(p.runs)
+		p.speedup /= float64(p.runs)
+	}
+	perCoroutine := points[0].throughput / float64(points[0].n)
+	for i := 1; i < len(points); i++ {
+		prev, p := points[i-1], points[i]
+		marginal := (p.throughput - prev.throughput) / float64(p.n-prev.n)
+		if marginal < threshold*perCoroutine {
+			return Knee{Coroutines: prev.n, ThroughputRps: prev.throughput, Speedup: prev.speedup, Threshold: threshold}, true
+		}
+	}
+	return Knee{}, false
+}
+
+// reportKnees prints the knee of every series of a sweep, as the concurrency it recommends.
+func reportKnees(results []BenchmarkResult, threshold float64) {
+	if threshold <= 0 || len(results) == 0 {
+		return
+	}
+	labels, series := bySeries(results)
+	fmt.Printf("Saturation knee (each added co-routine bringing under %s of the throughput the first ones brought):\n", formatPercent(threshold))
+	for _, label := range labels {
+		name := label
+		if len(labels) == 1 {
+			name = "sweep"
+		}
+		knee, ok := findKnee(series[label], threshold)
+		if !ok {
+			fmt.Printf("\t%s: not reached; throughput was still rising at the highest co-routine count\n", name)
+			continue
+		}
+		fmt.Printf("\t%s: recommended concurrency %d co-routines, at %s\n", name, knee.Coroutines, formatRps(knee.ThroughputRps))
+	}
+}
+
+// addKnee marks a series' knee on a speedup plot in c, labelled with its co-routine count.
+func addKnee(plt *plot.Plot, runs []BenchmarkResult, threshold float64, c color.Color) error {
+	if threshold <= 0 {
+		return nil
+	}
+	knee, ok := findKnee(runs, threshold)
+	if !ok {
+		return nil
+	}
+	pts := plotter.XYs{{X: float64(knee.Coroutines), Y: knee.Speedup}}
+	mark, err := plotter.NewScatter(pts)
+	if err != nil {
+		return err
+	}
+	mark.GlyphStyle.Shape = draw.BoxGlyph{}
+	mark.GlyphStyle.Color = c
+	mark.GlyphStyle.Radius = vg.Points(4)
+	label, err := plotter.NewLabels(plotter.XYLabels{XYs: pts, Labels: []string{fmt.Sprintf("knee: %d", knee.Coroutines)}})
+	if err != nil {
+		return err
+	}
+	label.TextStyle[0].XAlign = draw.XLeft
+	label.TextStyle[0].YAlign = draw.YTop
+	label.Offset = vg.Point{X: vg.Points(5), Y: -vg.Points(5)}
+	plt.Add(mark, label)
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+// LoadGenerator decides when the next request is issued. Next blocks until then; the concurrency limit is applied
+// separately, so with a closed-loop generator a new request is only issued once a running one completes.
+type LoadGenerator interface {
+	Next(ctx context.Context) error
+}
+
+type LoadSpec struct {
+	Kind         string          `json:"kind"`
+	Rate         float64         `json:"rate,omitempty"`
+	RampFrom     float64         `json:"ramp_from,omitempty"`
+	RampTo       float64         `json:"ramp_to,omitempty"`
+	RampDuration time.Duration   `json:"ramp_duration,omitempty"`
+	Trace        []time.Duration `json:"trace,omitempty"`
+	ThinkTime    time.Duration   `json:"think_time,omitempty"` // mean pause of each virtual user between requests
+	ThinkDist    Distribution    `json:"think_dist,omitempty"`
+	// A burst load issues BurstSize requests every BurstInterval, spread over BurstLength, idle for the rest.
+	BurstSize     int           `json:"burst_size,omitempty"`
+	BurstInterval time.Duration `json:"burst_interval,omitempty"`
+	BurstLength   time.Duration `json:"burst_length,omitempty"`
+}
+
+func (s LoadSpec) String() string {
+	switch s.Kind {
+	case "constant", "poisson":
+		return fmt.Sprintf("%s %.1f rps", s.Kind, s.Rate)
+	case "ramp":
+		return fmt.Sprintf("ramp %.1f->%.1f rps over %v", s.RampFrom, s.RampTo, s.RampDuration)
+	case "trace":
+		return fmt.Sprintf("trace of %d arrivals", len(s.Trace))
+	case "users":
+		return fmt.Sprintf("users thinking %v (%v)", s.ThinkTime, s.ThinkDist)
+	case "burst":
+		if s.BurstLength == 0 {
+			return fmt.Sprintf("bursts of %d every %v", s.BurstSize, s.BurstInterval)
+		}
+		return fmt.Sprintf("bursts of %d over %v every %v", s.BurstSize, s.BurstLength, s.BurstInterval)
+	}
+	return "closed loop"
+}
+
+// think returns how long a virtual user pauses after a request, drawn from r; zero for every other kind of load.
+func (s LoadSpec) think(r *rand.Rand) time.Duration {
+	if s.Kind != "users" {
+		return 0
+	}
+	return s.ThinkDist.Sample(r, s.ThinkTime)
+}
+
+func newLoadGenerator(spec LoadSpec, clock Clock, r *rand.Rand) (LoadGenerator, error) {
+	switch spec.Kind {
+	case "", "closed":
+		return closedLoopGenerator{}, nil
+	case "users":
+		// Each co-routine is a virtual user, which holds on to it while thinking after a request; see runBenchmark.
+		if spec.ThinkTime < 0 {
+			return nil, fmt.Errorf("virtual users need a think time of zero or more, got %v", spec.ThinkTime)
+		}
+		return closedLoopGenerator{}, nil
+	case "constant":
+		if spec.Rate <= 0 {
+			return nil, fmt.Errorf("constant load needs a positive rate, got %v", spec.Rate)
+		}
+		interval := time.Duration(float64(time.Second) / spec.Rate)
+		return &scheduledGenerator{clock: clock, interval: func(time.Duration) time.Duration { return interval }}, nil
+	case "poisson":
+		if spec.Rate <= 0 {
+			return nil, fmt.Errorf("poisson load needs a positive rate, got %v", spec.Rate)
+		}
+		return &scheduledGenerator{clock: clock, interval: func(time.Duration) time.Duration {
+			return time.Duration(r.ExpFloat64() / spec.Rate * float64(time.Second))
+		}}, nil
+	case "ramp":
+		if spec.RampFrom <= 0 || spec.RampTo <= 0 || spec.RampDuration <= 0 {
+			return nil, fmt.Errorf("ramp load needs positive rates and duration")
+		}
+		return &scheduledGenerator{clock: clock, interval: func(elapsed time.Duration) time.Duration {
+			progress := float64(elapsed) / float64(spec.RampDuration)
+			if progress > 1 {
+				progress = 1
+			}
+			rate := spec.RampFrom + (spec.RampTo-spec.RampFrom)*progress
+			return time.Duration(float64(time.Second) / rate)
+		}}, nil
+	case "trace":
+		if len(spec.Trace) == 0 {
+			return nil, fmt.Errorf("trace load needs at least one arrival")
+		}
+		return &traceGenerator{clock: clock, offsets: spec.Trace}, nil
+	case "burst":
+		if spec.BurstSize < 1 || spec.BurstInterval <= 0 {
+			return nil, fmt.Errorf("burst load needs a positive burst size and interval")
+		}
+		if spec.BurstLength < 0 || spec.BurstLength >= spec.BurstInterval {
+			return nil, fmt.Errorf("a burst's length must be shorter than the interval between bursts, got %v every %v",
+				spec.BurstLength, spec.BurstInterval)
+		}
+		return &burstGenerator{clock: clock, spec: spec}, nil
+	}
+	return nil, fmt.Errorf("unknown load generator %q", spec.Kind)
+}
+
+type closedLoopGenerator struct{}
+
+func (closedLoopGenerator) Next(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// scheduledGenerator issues requests open-loop at absolute times, so a late wakeup does not shift later arrivals.
+type scheduledGenerator struct {
+	clock    Clock
+	interval func(elapsed time.Duration) time.Duration
+	start    time.Time
+	next     time.Time
+}
+
+func (g *scheduledGenerator) Next(ctx context.Context) error {
+	if g.start.IsZero() {
+		g.start = g.clock.Now()
+		g.next = g.start
+	}
+	err := sleepUntil(ctx, g.clock, g.next)
+	g.next = g.next.Add(g.interval(g.next.Sub(g.start)))
+	return err
+}
+
+// traceGenerator replays recorded arrival offsets, wrapping around to repeat the trace if it is shorter than the run.
+type traceGenerator struct {
+	clock   Clock
+	offsets []time.Duration
+	start   time.Time
+	i       int
+}
+
+func (g *traceGenerator) Next(ctx context.Context) error {
+	if g.start.IsZero() {
+		g.start = g.clock.Now()
+	}
+	period := g.offsets[len(g.offsets)-1]
+	at := g.offsets[g.i%len(g.offsets)] + time.Duration(g.i/len(g.offsets))*period
+	g.i++
+	return sleepUntil(ctx, g.clock, g.start.Add(at))
+}
+
+func sleepUntil(ctx context.Context, clock Clock, t time.Time) error {
+	d := t.Sub(clock.Now())
+	if d <= 0 {
+		return ctx.Err()
+	}
+	select {
+	case <-clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loadTrace reads arrival offsets from the start of the run, one Go duration per line.
+func loadTrace(path string) ([]time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var offsets []time.Duration
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		d, err := time.ParseDuration(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(offsets) > 0 && d < offsets[len(offsets)-1] {
+			return nil, fmt.Errorf("%s: arrival offsets must be non-decreasing", path)
+		}
+		offsets = append(offsets, d)
+	}
+	return offsets, scanner.Err()
+}
+
+// outputVirtualUsers checks a virtual-user run's throughput against the interactive response time law: N users each
+// taking R to be served and thinking Z between requests complete N/(R+Z) requests per second.
+func outputVirtualUsers(result BenchmarkResult) {
+	spec := result.Config.Load
+	if spec.Kind != "users" || result.Iterations == 0 {
+		return
+	}
+	n := float64(result.NumCoroutines)
+	cycle := result.Latency.Mean/1000 + spec.ThinkTime.Seconds()
+	fmt.Printf("\tVirtual users: %s thinking %v on average (%v); %s per user, %s predicted by N/(R+Z)\n",
+		formatCount(result.NumCoroutines), spec.ThinkTime, spec.ThinkDist, formatRps(result.ThroughputRps/n), formatRps(n/cycle))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// arrivalsOn draws n arrivals from the load generator spec builds on a virtualClock, advancing the clock to each
+// deadline the generator sleeps until, and returns when each arrived, from the first call.
+func arrivalsOn(t *testing.T, spec LoadSpec, r *rand.Rand, n int) []time.Duration {
+	t.Helper()
+	start := time.Unix(0, 0)
+	clock := newVirtualClock(start)
+	g, err := newLoadGenerator(spec, clock, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var at []time.Duration
+	for i := 0; i < n; i++ {
+		next := make(chan error, 1)
+		go func() { next <- g.Next(context.Background()) }()
+		for waiting := true; waiting; {
+			select {
+			case err := <-next:
+				if err != nil {
+					t.Fatal(err)
+				}
+				waiting = false
+			case <-time.After(time.Millisecond):
+				// The generator is asleep on the clock, or about to be: only its own deadline moves it.
+				clock.AdvanceToNext()
+			}
+		}
+		at = append(at, clock.Now().Sub(start))
+	}
+	return at
+}
+
+func TestConstantLoadArrivals(t *testing.T) {
+	at := arrivalsOn(t, LoadSpec{Kind: "constant", Rate: 100}, nil, 20)
+	for i, got := range at {
+		if want := time.Duration(i) * 10 * time.Millisecond; got != want {
+			t.Errorf("arrival %d at %v, want %v", i, got, want)
+		}
+	}
+}
+
+// TestPoissonLoadArrivals checks that poisson arrivals are the sums of the exponential gaps drawn from the generator's
+// random stream, in order, and come at about the rate asked for.
+func TestPoissonLoadArrivals(t *testing.T) {
+	const rate, n = 1000.0, 200
+	at := arrivalsOn(t, LoadSpec{Kind: "poisson", Rate: rate}, rand.New(rand.NewSource(1)), n)
+	gaps := rand.New(rand.NewSource(1))
+	want := time.Duration(0)
+	for i, got := range at {
+		if got != want {
+			t.Fatalf("arrival %d at %v, want %v", i, got, want)
+		}
+		want += time.Duration(gaps.ExpFloat64() / rate * float64(time.Second))
+	}
+	if measured := float64(n-1) / at[n-1].Seconds(); measured < rate*0.7 || measured > rate*1.3 {
+		t.Errorf("arrivals came at %.0f rps, want about %.0f", measured, rate)
+	}
+}
+
+func TestBurstLoadArrivals(t *testing.T) {
+	ms := time.Millisecond
+	for _, tc := range []struct {
+		name string
+		spec LoadSpec
+		want []time.Duration
+	}{
+		{"at once", LoadSpec{Kind: "burst", BurstSize: 3, BurstInterval: 100 * ms},
+			[]time.Duration{0, 0, 0, 100 * ms, 100 * ms, 100 * ms, 200 * ms}},
+		{"spread", LoadSpec{Kind: "burst", BurstSize: 3, BurstInterval: 100 * ms, BurstLength: 30 * ms},
+			[]time.Duration{0, 10 * ms, 20 * ms, 100 * ms, 110 * ms, 120 * ms, 200 * ms}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			at := arrivalsOn(t, tc.spec, nil, len(tc.want))
+			for i, got := range at {
+				if got != tc.want[i] {
+					t.Errorf("arrival %d at %v, want %v", i, got, tc.want[i])
+				}
+				if due := tc.spec.dueBy(got); due < int64(i+1) {
+					t.Errorf("arrival %d came at %v, by which dueBy counts only %d due", i, got, due)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// LockWorkload models shared state guarded by a single lock: every request busy-waits inside the critical section
+// while holding it, so the lock serializes that slice of work across all coroutines.
+type LockWorkload struct {
+	CriticalSection time.Duration
+	RWMutex         bool
+	ReadFraction    float64
+
+	mu   sync.Mutex
+	rwMu sync.RWMutex
+}
+
+func (l *LockWorkload) lock(r *rand.Rand) (unlock func(), mode string) {
+	if !l.RWMutex {
+		l.mu.Lock()
+		return l.mu.Unlock, "mutex"
+	}
+	if r.Float64() < l.ReadFraction {
+		l.rwMu.RLock()
+		return l.rwMu.RUnlock, "read lock"
+	}
+	l.rwMu.Lock()
+	return l.rwMu.Unlock, "write lock"
+}
+
+func doLockWork(clock Clock, lock *LockWorkload, r *rand.Rand, log *RequestLog) {
+	start := clock.Now()
+	log.Begin(start, "lock", "critical section", lock.CriticalSection)
+	unlock, mode := lock.lock(r)
+	acquired := clock.Now()
+	if log.Enabled(LogDebug) {
+		log.Debugf(acquired, "waited %v for the %s", acquired.Sub(start), mode)
+	}
+	spin(clock, cpuKernelNamed(""), lock.CriticalSection)
+	unlock()
+	log.End(clock.Now())
+}
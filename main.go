@@ -2,243 +2,2830 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"github.com/montanaflynn/stats"
-	"golang.org/x/sync/semaphore"
 	"gonum.org/v1/plot"
 	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
 	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
 	"image/color"
+	"math"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type WorkResult struct {
+	request   int
+	class     string
 	timeTaken time.Duration
-	output    string
+	log       *RequestLog // nil with LogOff
+	err       error
+	timedOut  bool
+	queueWait time.Duration // between being issued and starting to run
+	finished  time.Duration // since the run started
+	worker    int           // the co-routine it ran on, numbered by workerSlots; zero unless Config.RequestTrace
+	wasted    time.Duration // spent past the request's deadline
 }
 
-func doCpuWork(workTime time.Duration, name string, sb *strings.Builder) {
-	start := time.Now()
-	var end time.Time
-	var duration time.Duration
-	for true {
-		end = time.Now()
-		duration = end.Sub(start)
-		if duration >= workTime {
-			break
+func doCpuWork(clock Clock, kernel *cpuKernel, workTime time.Duration, log *RequestLog) {
+	log.Begin(log.now(clock), "cpu", "CPU time", workTime)
+	spin(clock, kernel, workTime)
+	log.End(log.now(clock))
+}
+
+// doNetworkWork spends a network phase of networkTime sleeping for sleepTime of it, which is shorter when the sleep
+// is compensated for the timer's overshoot.
+func doNetworkWork(clock Clock, networkTime, sleepTime time.Duration, log *RequestLog) {
+	log.Begin(log.now(clock), "network", "network time", networkTime)
+	clock.Sleep(sleepTime) // Simulate Network Work by calling sleep
+	log.End(log.now(clock))
+}
+
+// NetworkBackend spends a network phase on a real round-trip instead of sleeping. Backends do real I/O and so always
+// run on wall-clock time, whatever Clock the run is configured with.
+type NetworkBackend interface {
+	Call(networkTime time.Duration, log *RequestLog) error
+	Close() error
+}
+
+// doNetworkPhase spends a network phase, retrying it as cfg.Retry has it and hedging each attempt as cfg.Hedge has it.
+// An attempt the circuit breaker turns away fails at once without calling the downstream, and is retried like any
+// other.
+func doNetworkPhase(cfg RunConfig, r *rand.Rand, networkTime time.Duration, log *RequestLog) error {
+	attempts := 1
+	if cfg.Retry.enabled() {
+		attempts = cfg.Retry.MaxAttempts
+	}
+	var err error
+	calls := 0
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			clock := cfg.clock()
+			wait := cfg.Retry.backoff(attempt, r)
+			log.Begin(log.now(clock), "backoff", "retry backoff", wait)
+			clock.Sleep(wait)
+			log.End(log.now(clock))
+		}
+		if !cfg.downstream.allow() {
+			err = errCircuitOpen
+			log.Debugf(cfg.clock().Now(), "network call rejected by the open circuit breaker")
+			continue
+		}
+		var made int
+		made, err = callHedged(cfg, r, networkTime, log)
+		calls += made
+		if err == nil || errors.Is(err, context.DeadlineExceeded) {
+			break // the request no longer wants the call retried
 		}
 	}
-	sb.WriteString(fmt.Sprintf("[%s] %s: + %v CPU time\n", start.Format(time.StampMicro), name, workTime))
-	sb.WriteString(fmt.Sprintf("[%s] %s: - %v CPU work took %v\n", end.Format(time.StampMicro), name, workTime, duration))
+	cfg.downstream.add(calls)
+	return err
 }
 
-func doNetworkWork(networkTime time.Duration, name string, sb *strings.Builder) {
-	start := time.Now()
-	sb.WriteString(fmt.Sprintf("[%s] %s: + %v network time\n", start.Format(time.StampMicro), name, networkTime))
-	time.Sleep(networkTime) // Simulate Network Work by calling sleep
-	end := time.Now()
-	duration := end.Sub(start)
-	sb.WriteString(fmt.Sprintf("[%s] %s: - %v Network time took %v\n", end.Format(time.StampMicro), name, networkTime, duration))
+// callDownstream makes one call of a network phase, degrading or failing it as cfg.Faults has it.
+func callDownstream(cfg RunConfig, r *rand.Rand, networkTime time.Duration, log *RequestLog) error {
+	extra, fault := cfg.Faults.draw(r, cfg.downstream.elapsed())
+	if cfg.Network != nil {
+		if err := cfg.Network.Call(networkTime+extra, log); err != nil {
+			return err
+		}
+	} else {
+		d, cut := cfg.untilDeadline(networkTime + extra)
+		doNetworkWork(cfg.clock(), d, cfg.sleepTime(d), log)
+		if cut {
+			log.Debugf(cfg.clock().Now(), "network call cancelled at the deadline")
+			return context.DeadlineExceeded
+		}
+	}
+	if extra > 0 && log.Enabled(LogDebug) {
+		log.Debugf(cfg.clock().Now(), "network phase degraded by %v", extra)
+	}
+	if fault != nil {
+		log.Debugf(cfg.clock().Now(), "network phase failed by injection")
+	}
+	return fault
 }
 
-func doWork(workTime time.Duration, networkTime time.Duration, splits int, name string, sb *strings.Builder) time.Duration {
-	start := time.Now()
-	doCpuWork(workTime/time.Duration(splits+1), name, sb)
+func doWork(cfg RunConfig, r *rand.Rand, log *RequestLog) error {
+	clock := cfg.clock()
+	if cfg.Pipeline != nil {
+		return doPipelineWork(clock, cfg.Pipeline, r, log)
+	}
+	if cfg.MQ != nil {
+		return doMQWork(clock, cfg.MQ, r, log)
+	}
+	if cfg.Compress != nil {
+		return doCompressWork(clock, cfg.Compress, log)
+	}
+	if cfg.Exec != nil {
+		return doExecWork(cfg.Exec, log)
+	}
+	if cfg.Plugin != nil {
+		return doPluginWork(cfg.Plugin, log)
+	}
+	workTime := cfg.CPUDist.Sample(r, cfg.WorkTime)
+	networkTime := cfg.NetworkDist.Sample(r, cfg.NetworkTime)
+	splits := cfg.SplitsDist.Sample(r, cfg.Splits)
+	phaseTime := cfg.SplitsDist.phaseTime(networkTime, cfg.Splits)
+	kernel := cpuKernelNamed(cfg.CPUKernel)
+	doCpuWork(clock, kernel, workTime/time.Duration(splits+1), log)
 	for i := 0; i < splits; i++ {
-		doNetworkWork(networkTime/time.Duration(splits), name, sb)
-		doCpuWork(workTime/time.Duration(splits+1), name, sb)
+		if err := cfg.checkDeadline(log); err != nil {
+			return err
+		}
+		if cfg.FanOut > 1 {
+			// Each call draws its own time around the phase's mean rather than sharing the request's draw.
+			if err := doFanOutPhase(cfg, r, cfg.SplitsDist.phaseTime(cfg.NetworkTime, cfg.Splits), log); err != nil {
+				return err
+			}
+		} else if err := doNetworkPhase(cfg, r, phaseTime, log); err != nil {
+			return err
+		}
+		if cfg.Disk != nil {
+			if err := doDiskWork(clock, cfg.Disk, r, log); err != nil {
+				return err
+			}
+		}
+		if cfg.Lock != nil {
+			doLockWork(clock, cfg.Lock, r, log)
+		}
+		if cfg.DNS != nil {
+			if err := doDNSWork(cfg.DNS, log); err != nil {
+				return err
+			}
+		}
+		if cfg.SQL != nil {
+			if err := doSQLWork(cfg.SQL, r, log); err != nil {
+				return err
+			}
+		}
+		if cfg.Redis != nil {
+			if err := doRedisWork(cfg.Redis, r, log); err != nil {
+				return err
+			}
+		}
+		if err := cfg.checkDeadline(log); err != nil {
+			return err
+		}
+		doCpuWork(clock, kernel, workTime/time.Duration(splits+1), log)
 	}
-	return time.Since(start)
+	return nil
 }
 
 type BenchmarkResult struct {
-	WorkTime        time.Duration
-	NetworkTime     time.Duration
-	Iterations      int
-	NumCoroutines   int64
-	ThroughputRps   float64
-	Speedup         float64
-	CpuUtilization  float64
-	ResponseTimesMs []float64
-	LongestRequest  string
+	WorkTime         time.Duration
+	NetworkTime      time.Duration
+	Iterations       int
+	NumCoroutines    int64
+	Config           RunConfig
+	ThroughputRps    float64
+	Speedup          float64
+	CpuUtilization   float64
+	EffectiveCPUs    float64 // CPUs available to the run, after GOMAXPROCS and any cgroup quota
+	ResponseTimes    Collector
+	Latency          LatencyStats   // of ResponseTimes
+	Outliers         *OutlierReport // nil unless Config.Outliers chose a method
+	ThroughputSeries []float64      // requests completed per second in each Config.ThroughputInterval of the run
+	SteadyState      *SteadyState   // nil unless the throughput series settled within Config.SteadyCV
+	LatencyTrace     []TracePoint   // sampled requests in the order they started, at most Config.TracePoints
+	Phases           []PhaseTimes   // nil unless Config.Phases
+	QueueWaits       Collector      // how long each request in ResponseTimes waited for a free co-routine, in ms
+	CallerTimes      Collector      // queue wait plus response time, the latency a caller would have seen
+	QueueLength      float64        // requests waiting for a co-routine on average, by Little's law
+	LongestRequest   string
+	Errors           int
+	ErrorTimes       Collector // response times of the requests that failed, in ms
+	NetworkPhases    int64     // network phases the requests made, counted when Config has faults, retries, a breaker or hedging
+	DownstreamCalls  int64     // calls those phases took, retries and hedges included and calls the breaker rejected left out
+	Breaker          BreakerStats
+	Hedges           *HedgeStats // nil unless Config.Hedge is enabled
+	Bursts           *BurstStats // nil unless Config.Load is a burst load
+	Brownout         *Brownout   // nil unless Config.Faults only struck for part of the run
+	Middleware       []MiddlewareOverhead
+	Classes          []ClassResult
+	FreqTrace        []FreqSample
+	MemoryTrace      []MemSample
+	CoreCPUs         []int        // the CPUs CoreTrace samples, by ID; nil where /proc/stat is unavailable
+	CoreTrace        []CoreSample // how busy each of CoreCPUs was over the run
+	ThrottleEvents   int64
+	CPUTime          time.Duration // CPU time the process used during the run
+	EnergyJoules     float64       // energy the CPU packages drew during the run, zero where RAPL is unavailable
+	PrecisionRSE     float64       // relative standard error of the tracked percentile when the run ended; zero for fixed-length runs
+	QueueModel       *QueueModel
+	AvgConcurrency   float64 // requests in flight on average, by Little's law
+	Err              error   // why the run was aborted early, if it was
+	Harness          HarnessOverhead
+	Measurement      MeasurementOverhead
+	ThreadsCreated   int
+	Timeouts         int           // requests that missed Config.RequestTimeout, left out of ResponseTimes
+	WastedWork       time.Duration // co-routine time requests spent past Config.RequestTimeout
+	WastedFraction   float64       // of all the co-routine time requests took
+	Rejected         int           // requests the admission queue turned away, left out of Iterations
+	Accounting       RequestAccounting
+	Fairness         *WorkerFairness  // how evenly a fixed pool's workers shared the requests; nil for other executors
+	RateLimitStats   *RateLimitStats  // nil unless Config.RateLimit is enabled
+	Simulation       *SimulationStats // nil unless Config.Simulate
+	Failure          *Failure         // why the run failed, if it did
+	Slowest          []RequestTimeline
+	Started          time.Time // wall-clock time the run began
+	Processes        []ProcessResult
+	ExitStatuses     map[int]int      // how often each command that failed exited with each status, when Config.Exec
+	SQLPool          *SQLPoolStats    // nil unless Config.SQL, or the run was split over processes
+	Delivery         *DeliveryStats   // nil unless Config.MQ, or the run was split over processes
+	DiskStats        *DiskStats       // nil unless Config.Disk, or the run was split over processes
+	Sessions         *SessionStats    // nil unless Config.Session, or the run was split over processes
+	GCCycles         uint64           // collections the process completed during the run
+	Scheduler        *SchedulerStats  // nil without -runtime-trace
+	CPUProfile       []byte           // the process's CPU profile over the run, as runtime/pprof writes it; nil unless Config.CPUProfile
+	Host             *hostEnvironment // the machine and build the run was measured on
+	LimitTrace       []LimitSample    // how an adaptive executor's limit moved over the run; nil for the others
+	Budget           *LatencyBudgets  // where the time went, for the run selected with Config.BudgetAt
 }
 
 func (b BenchmarkResult) ResponseTimesPercentile(pct float64) float64 {
-	val, _ := stats.Percentile(b.ResponseTimesMs, pct)
-	return val
+	return b.ResponseTimes.Percentile(pct)
+}
+
+// CPUSecondsPer1000 is the CPU time spent per 1000 completed requests.
+func (b BenchmarkResult) CPUSecondsPer1000() float64 {
+	if b.Iterations == 0 {
+		return 0
+	}
+	return b.CPUTime.Seconds() * 1000 / float64(b.Iterations)
+}
+
+// JoulesPer1000 is the energy drawn per 1000 completed requests, zero where it was not measured.
+func (b BenchmarkResult) JoulesPer1000() float64 {
+	if b.Iterations == 0 {
+		return 0
+	}
+	return b.EnergyJoules * 1000 / float64(b.Iterations)
+}
+
+// TimeoutRate is the fraction of completed requests that missed their deadline.
+func (b BenchmarkResult) TimeoutRate() float64 {
+	if b.Iterations == 0 {
+		return 0
+	}
+	return float64(b.Timeouts) / float64(b.Iterations)
+}
+
+type RunConfig struct {
+	WorkTime            time.Duration
+	NetworkTime         time.Duration
+	CPUDist             Distribution
+	NetworkDist         Distribution
+	NumCoroutines       int64
+	Splits              int
+	SplitsDist          SplitsDist // of each request's splits; fixed has them all make Splits
+	CPUKernel           string     // how CPU time is spent, one of cpuKernels; empty for spin
+	Disk                *DiskWorkload
+	Lock                *LockWorkload
+	DNS                 *DNSWorkload
+	SQL                 *SQLWorkload
+	SQLMaxOpen          int // cap on SQL's open connections, swept; zero for none
+	Redis               *RedisWorkload
+	Pipeline            *PipelineWorkload
+	MQ                  *MQWorkload // requests as messages through a broker, instead of work on their own co-routines
+	MQConsumers         int         // goroutines consuming MQ's messages, swept
+	FanOut              int         // parallel downstream calls each network phase makes; 0 or 1 makes one
+	FanOutLimit         int         // of those calls in flight at once, swept; zero for all of them
+	Compress            *CompressWorkload
+	Exec                *ExecWorkload   // a command run per request, instead of simulated work
+	Plugin              *PluginWorkload // a plugin process asked to do each request, likewise
+	Network             NetworkBackend
+	Load                LoadSpec
+	Executor            string
+	Middleware          []MiddlewareSpec
+	Collector           string
+	ReservoirSize       int
+	BaselineIterations  int           // serial requests the speedup is relative to
+	BaselineDuration    time.Duration // or how long to run them for; with neither, there is no baseline and no speedup
+	Iterations          int
+	Clock               Clock
+	Seed                int64
+	Mix                 TrafficMix
+	Dispatch            string        // which waiting request runs next, queued ahead of the executor; "" leaves it to the executor
+	Session             SessionScript // each virtual user's requests in order, whose classes make up Mix
+	GOMAXPROCS          int
+	GOGC                int    // collector's target heap growth in percent, or gogcOff; zero keeps the process's
+	MemoryLimit         int64  // runtime's soft memory limit in bytes; zero keeps the process's
+	Placement           string // NUMA placement the run is pinned to, confined or spread; empty leaves it be
+	Readiness           int
+	ResultBatch         int
+	Aggregation         string
+	RequestTimeout      time.Duration
+	PropagateDeadline   bool          // cancel a request's remaining phases, and its sleep-based network call, at its deadline
+	CompensateTimer     bool          // shorten sleep-based network calls by the overshoot the host's timer was calibrated at
+	Apdex               time.Duration // target response time the run's Apdex score is computed against; zero for none
+	Ceilings            ResourceCeilings
+	StallTimeout        time.Duration
+	MaxErrorRate        float64
+	Faults              Faults        // injected into every network phase
+	Retry               RetryPolicy   // for failed network phases
+	Hedge               HedgePolicy   // for slow network calls
+	Breaker             BreakerPolicy // in front of the downstream, shared by the run's requests
+	ShedLoad            bool          // reject requests that arrive while QueueDepth already wait for a co-routine
+	RateLimit           RateLimit     // client-side, on top of Load
+	QueueDepth          int
+	MaxTimeoutRate      float64
+	SlowestRequests     int
+	LogLevel            LogLevel // what each request records about itself
+	LowOverhead         bool     // LogLevel, TracePoints, SlowestRequests and ResultBatch were chosen to measure as little as possible
+	Simulate            bool     // model the run in virtual time instead of running it; see simulateBenchmark
+	RequestTrace        bool     // write a record of every completed request to requests.jsonl
+	RuntimeTrace        bool     // write the run's runtime trace to trace.out
+	CPUProfile          bool     // profile the run's CPU, to cpu.pprof and as a flame graph
+	SteadyCV            float64  // coefficient of variation of throughput under which a run is in steady state; zero not to look
+	SteadyWindow        int      // throughput intervals that must vary by under SteadyCV
+	SteadyOnly          bool     // report throughput and response times from the steady state on alone
+	TraceSample         float64  // fraction of requests whose full timelines are kept in traces.jsonl
+	TraceSlow           float64  // percentile of the run above which requests' full timelines are kept; zero for none
+	BudgetAt            int64    // coroutine count whose runs break down their latency budget; zero for none
+	Tags                Tags
+	Note                string        // free-form annotation, such as what changed since the last sweep
+	Commit              string        // revision of the working directory's git repository, if it is one
+	Scenario            string        // the scenario that ran the sweep, if any
+	Repetition          int           // which of the repeated runs of this configuration, from 0
+	Processes           int           // child processes generating the load; 0 or 1 runs in this process
+	CPUSets             [][]int       // CPU sets the child processes are pinned to in turn, when there are several
+	Agents              []string      // addresses of the agents generating the load instead, for a distributed run
+	Target              *RemoteTarget // the simulated service's own process; nil runs it in the generator's
+	Percentiles         []float64     // response time percentiles to report; nil for defaultPercentiles
+	Outliers            string        // how to detect outlying response times, iqr or mad; empty for not at all
+	ThroughputInterval  time.Duration // width of the intervals throughput is recorded over; zero for none
+	TracePoints         int           // most requests per run to place in time, for the latency trace; zero for none
+	Phases              bool          // record the distribution of each phase of the requests
+	Precision           float64       // relative standard error of PrecisionPercentile to run until; zero runs Iterations requests
+	PrecisionPercentile float64
+	MaxIterations       int // cap on requests when running to a precision
+	childArgs           []string
+	artifacts           *artifactStore      // where per-run files such as the request trace go
+	requestTrace        *requestTraceWriter // the run's, while RequestTrace has it writing
+	traceSampler        *traceSampler       // the run's, while TraceSample or TraceSlow has it keeping traces
+	runID               string              // random, for the IDs of the run's requests
+	baselines           *baselineCache      // shared by the sweep's runs; nil measures the baseline every run
+	downstream          *downstream         // the run's, while faults, retries, the breaker or hedging need one
+	deadline            time.Time           // the request's, on its own copy, while PropagateDeadline has it honored
+}
+
+// requestContext carries request x's random stream and, when there is a traffic mix, its request class.
+func (cfg RunConfig) requestContext(ctx context.Context, x int) (context.Context, string) {
+	r := newRand(cfg.Seed, int64(x))
+	ctx = withRequestIndex(withRand(ctx, r), x)
+	if len(cfg.Mix) == 0 {
+		return ctx, ""
+	}
+	class := cfg.Mix.pick(r)
+	return withRequestClass(ctx, class), class.Name
+}
+
+func (cfg RunConfig) percentiles() []float64 {
+	if len(cfg.Percentiles) == 0 {
+		return defaultPercentiles
+	}
+	return cfg.Percentiles
+}
+
+func (cfg RunConfig) clock() Clock {
+	if cfg.Clock == nil {
+		return realClock{}
+	}
+	return cfg.Clock
 }
 
-func runBenchmark(workTime, networkTime time.Duration, numGreenThreads int64, splits int, baselineIterations int, iterations int) BenchmarkResult {
+// runBenchmark runs cfg and reports the result. Cancelling ctx stops issuing requests: those already running are
+// drained and the partial result is still reported, with its Err set.
+func runBenchmark(ctx context.Context, cfg RunConfig, reporter Reporter) (BenchmarkResult, error) {
+	if cfg.Simulate {
+		return simulateBenchmark(ctx, cfg, reporter)
+	}
 	var start time.Time
+	if cfg.Placement != "" {
+		cpus, restore, err := applyPlacement(cfg)
+		if err != nil {
+			return BenchmarkResult{}, err
+		}
+		defer restore()
+		if cfg.GOMAXPROCS == 0 {
+			cfg.GOMAXPROCS = len(cpus) // as many Ps as the placement has CPUs, whichever it is
+		}
+	}
+	if cfg.GOMAXPROCS > 0 {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(cfg.GOMAXPROCS))
+	} else {
+		cfg.GOMAXPROCS = runtime.GOMAXPROCS(0)
+	}
+	defer applyGC(cfg)()
+	reporter.OnRunStart(cfg)
+	started := time.Now()
+
+	if cfg.Pipeline != nil {
+		cfg.Pipeline.Start(cfg)
+		defer cfg.Pipeline.Stop()
+	}
+	if cfg.MQ != nil {
+		if err := cfg.MQ.Start(cfg); err != nil {
+			return BenchmarkResult{}, err
+		}
+		defer cfg.MQ.Stop()
+	}
+	if cfg.SQL != nil {
+		cfg.SQL.setMaxOpen(cfg.SQLMaxOpen)
+	}
 
-	// Compute baseline
-	start = time.Now()
-	var dummySb strings.Builder
-	for x := 0; x < baselineIterations; x++ {
-		doWork(workTime, networkTime, splits, fmt.Sprintf("Request %d", x), &dummySb)
+	clock := cfg.clock()
+
+	baselineRps, err := cfg.baselineRps(ctx, clock)
+	if err != nil {
+		return BenchmarkResult{}, err
 	}
-	baselineDuration := time.Since(start)
 
 	// Run benchmark
-	start = time.Now()
-	c := make(chan WorkResult, iterations)
-	sem := semaphore.NewWeighted(numGreenThreads)
-	ctx := context.Background()
+	gen, err := newLoadGenerator(cfg.Load, clock, newRand(cfg.Seed, loadRandStream))
+	if err != nil {
+		return BenchmarkResult{}, err
+	}
+	var limited *limitedGenerator
+	if cfg.RateLimit.enabled() {
+		limited = newLimitedGenerator(gen, cfg.RateLimit, clock)
+		gen = limited
+	}
+	// A run that exceeds its resource ceilings stops issuing requests the same way an interrupted one does.
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+	executor, err := newExecutor(ctx, cfg.Executor, cfg.NumCoroutines)
+	if err != nil {
+		return BenchmarkResult{}, err
+	}
+	// Requests are not cancelled along with ctx, so that an interrupted run drains cleanly, unless the executor itself
+	// aborts them.
+	runCtx := context.Background()
+	if e, ok := executor.(cancellingExecutor); ok {
+		runCtx = e.Context()
+	}
+	if cfg.Faults.enabled() || cfg.Retry.enabled() || cfg.Breaker.enabled() || cfg.Hedge.enabled() {
+		cfg.downstream = newDownstream(cfg) // after the baseline, which neither counts nor trips the breaker
+	}
+	workload, layerTimers, err := buildWorkload(simulatedWorkload(cfg), cfg.Middleware, clock)
+	if err != nil {
+		return BenchmarkResult{}, err
+	}
+	var slots *workerSlots
+	cfg.runID = newRunID()
+	if cfg.RequestTrace {
+		if cfg.requestTrace, err = newRequestTraceWriter(cfg.artifacts.path("requests.jsonl", &cfg), cfg.runID); err != nil {
+			return BenchmarkResult{}, err
+		}
+		defer cfg.requestTrace.Close() // in case the run ends early; closing again below is harmless
+		slots = &workerSlots{}
+	}
+	if cfg.TraceSample > 0 || cfg.TraceSlow > 0 {
+		if cfg.traceSampler, err = newTraceSampler(cfg.artifacts.path("traces.jsonl", &cfg), cfg); err != nil {
+			return BenchmarkResult{}, err
+		}
+		defer cfg.traceSampler.Close(nil) // in case the run ends early
+		if slots == nil {
+			slots = &workerSlots{}
+		}
+	}
+	if cfg.Aggregation == "sharded" && slots == nil {
+		slots = &workerSlots{} // the shards are keyed by them
+	}
+	agg, err := newAggregation(cfg, reporter)
+	if err != nil {
+		return BenchmarkResult{}, err
+	}
 
-	for x := 0; x < iterations; x++ {
-		err := sem.Acquire(ctx, 1)
-		if err != nil {
-			panic(err)
+	threadsBefore := pprof.Lookup("threadcreate").Count()
+	freq := startFreqMonitor(100 * time.Millisecond)
+	memory := startMemoryMonitor(50 * time.Millisecond)
+	cores := startCoreMonitor(100 * time.Millisecond)
+	energy := startEnergyMeter()
+	ceilings := startCeilingMonitor(cfg.Ceilings, 50*time.Millisecond, abort)
+	stalls := startWatchdog(cfg.StallTimeout, abort)
+	precision := newPrecisionTracker(cfg)
+	limit := cfg.Iterations
+	if precision != nil {
+		limit = cfg.MaxIterations
+	}
+	var poolBefore SQLPoolStats
+	if cfg.SQL != nil {
+		poolBefore = cfg.SQL.poolStats()
+	}
+	deliveredBefore := 0
+	if cfg.MQ != nil {
+		deliveredBefore = cfg.MQ.delivered()
+	}
+	var diskBefore diskMark
+	if cfg.Disk != nil {
+		diskBefore = cfg.Disk.mark()
+	}
+	var stopTrace func() error
+	if cfg.RuntimeTrace {
+		if stopTrace, err = startRuntimeTrace(cfg.artifacts.path("trace.out", &cfg)); err != nil {
+			return BenchmarkResult{}, err
 		}
-		go func(x int) {
-			var sb strings.Builder
-			timeTaken := doWork(workTime, networkTime, splits, fmt.Sprintf("Request %d", x), &sb)
-			c <- WorkResult{
-				timeTaken: timeTaken,
-				output:    sb.String(),
+		defer func() {
+			if stopTrace != nil {
+				stopTrace()
 			}
-			sem.Release(1)
-		}(x)
+		}()
+	}
+	var stopProfile func() []byte
+	if cfg.CPUProfile {
+		if stopProfile, err = startCPUProfile(); err != nil {
+			return BenchmarkResult{}, err
+		}
+	}
+	gcBefore := gcCycles()
+	start = clock.Now()
+	if cfg.downstream != nil {
+		cfg.downstream.start = start
+	}
+	adaptive, _ := executor.(*adaptiveExecutor)
+	if adaptive != nil {
+		adaptive.begin()
+	}
+
+	// submitted counts the requests the executor took: one it refuses once the run is cancelled is taken back off,
+	// along with its place in flight.
+	var submitted int64
+	refused := func() {
+		atomic.AddInt64(&submitted, -1)
+		stalls.end()
+	}
+	// Requests a stalled run leaves behind may still complete after it has been reported; their results are dropped.
+	var admission *admissionQueue
+	if cfg.ShedLoad {
+		admission = newAdmissionQueue(cfg.NumCoroutines, cfg.QueueDepth)
+	}
+	var dispatcher *priorityDispatcher
+	if cfg.Dispatch != "" {
+		dispatcher = newPriorityDispatcher(cfg, executor, refused)
+	}
+	var sendMu sync.RWMutex
+	abandoned := false
+	send := func(result WorkResult) {
+		sendMu.RLock()
+		defer sendMu.RUnlock()
+		if !abandoned {
+			agg.send(result)
+		}
 	}
 
-	var responseTimesMs []float64
-	var longestRequest WorkResult
-	for result := range c {
-		if result.timeTaken > longestRequest.timeTaken {
-			longestRequest = result
+	bursts := newBurstTracker(cfg, limit)
+	var sessions *sessionUsers
+	if cfg.Session != nil {
+		sessions = newSessionUsers(cfg.Session, cfg.NumCoroutines)
+	}
+	goroutinesBefore := takeGoroutineSnapshot()
+	var finishedErr error
+	var fairness *WorkerFairness // set along with finishedErr
+	finished := make(chan struct{})
+	dispatched := make(chan struct{}) // closed once no more requests will be issued, ending virtual users' thinking
+	go func() {
+		defer close(finished)
+		var dispatchErr error
+		for x := 0; x < limit; x++ {
+			if precision.Reached() {
+				break
+			}
+			if dispatchErr = gen.Next(ctx); dispatchErr != nil || runCtx.Err() != nil {
+				break
+			}
+			x := x
+			issued := clock.Now()
+			bursts.issued()
+			var think time.Duration // drawn from the request's random stream once it has drawn everything else
+			var user *sessionUser   // making the request, when the virtual users follow a session script
+			request := func() error {
+				defer stalls.end()
+				log := newRequestLog(x, cfg.LogLevel)
+				requestCtx, class := cfg.requestContext(runCtx, x)
+				if user != nil {
+					step := sessions.begin(user, clock.Now())
+					requestCtx, class = withRequestClass(requestCtx, step), step.Name
+				}
+				if cfg.RequestTimeout > 0 {
+					var cancel context.CancelFunc
+					requestCtx, cancel = withClockTimeout(requestCtx, clock, cfg.RequestTimeout)
+					defer cancel()
+				}
+				worker := 0
+				if slots != nil {
+					worker = slots.take()
+				}
+				requestStart := clock.Now()
+				queueWait := requestStart.Sub(issued)
+				if cfg.Load.Kind == "users" {
+					queueWait = 0 // the dispatcher waited out another user's think time, not a queue
+				}
+				err := workload.Do(requestCtx, log)
+				requestEnd := clock.Now()
+				think = cfg.Load.think(randFrom(requestCtx))
+				if user != nil {
+					sessions.end(user, requestEnd)
+				}
+				timeTaken := requestEnd.Sub(requestStart)
+				var wasted time.Duration
+				if cfg.RequestTimeout > 0 {
+					wasted = wastedWork(requestCtx, requestEnd)
+				}
+				timedOut := cfg.RequestTimeout > 0 && (timeTaken > cfg.RequestTimeout || errors.Is(err, context.DeadlineExceeded))
+				if err == nil && !timedOut {
+					precision.observe(float64(timeTaken) / float64(time.Millisecond))
+				}
+				send(WorkResult{
+					request:   x,
+					class:     class,
+					timeTaken: timeTaken,
+					log:       log,
+					err:       err,
+					timedOut:  timedOut,
+					queueWait: queueWait,
+					finished:  requestEnd.Sub(start),
+					worker:    worker,
+					wasted:    wasted,
+				})
+				if slots != nil {
+					slots.put(worker) // only once the result is in, so that no other request sends from the slot meanwhile
+				}
+				bursts.completed(x, requestEnd)
+				if timedOut {
+					return nil // a missed deadline is accounted for, not a failure that should abort the run
+				}
+				return err
+			}
+			if cfg.Load.Kind == "users" {
+				// A virtual user thinks on its co-routine once its request is done, keeping the next one waiting.
+				run := request
+				request = func() error {
+					user = sessions.take()
+					err := run()
+					select {
+					case <-clock.After(think):
+					case <-dispatched:
+					}
+					sessions.put(user)
+					return err
+				}
+			}
+			atomic.AddInt64(&submitted, 1)
+			stalls.begin()
+			if dispatcher != nil {
+				dispatcher.submit(cfg.classOf(x), request)
+			} else if admission == nil {
+				if !executor.Go(request) {
+					refused()
+				}
+			} else if !admission.admit(executor, request, refused) {
+				stalls.end() // turned away without running
+				bursts.rejected()
+			}
+		}
+		close(dispatched)
+		if admission != nil {
+			admission.wait()
+		}
+		if dispatcher != nil {
+			dispatcher.close()
+		}
+		finishedErr = executor.Wait()
+		if pool, ok := executor.(*poolExecutor); ok {
+			fairness = pool.fairness()
+		}
+		if finishedErr == nil {
+			finishedErr = dispatchErr
+		}
+	}()
+	var runErr error
+	select {
+	case <-finished:
+		runErr = finishedErr
+	case <-stalls.Stalled():
+		sendMu.Lock()
+		abandoned = true
+		sendMu.Unlock()
+	}
+	ceilingErr := ceilings.Stop()
+	if err := stalls.Stop(); err != nil {
+		runErr = err
+	} else if ceilingErr != nil {
+		runErr = ceilingErr
+	}
+	totalDuration := clock.Now().Sub(start)
+	var schedStats *SchedulerStats
+	if stopTrace != nil {
+		err := stopTrace()
+		stopTrace = nil
+		if err != nil {
+			return BenchmarkResult{}, err
 		}
-		responseTimesMs = append(responseTimesMs, float64(result.timeTaken)/float64(time.Millisecond))
-		if len(responseTimesMs) == iterations {
-			close(c)
+		if schedStats, err = readSchedulerStats(cfg.artifacts.path("trace.out", &cfg), cfg.GOMAXPROCS); err != nil {
+			return BenchmarkResult{}, fmt.Errorf("-runtime-trace: %w", err)
 		}
 	}
+	var cpuProfile []byte
+	if stopProfile != nil {
+		cpuProfile = stopProfile()
+		if err := os.WriteFile(cfg.artifacts.path("cpu.pprof", &cfg), cpuProfile, 0o644); err != nil {
+			return BenchmarkResult{}, err
+		}
+	}
+	threadsCreated := pprof.Lookup("threadcreate").Count() - threadsBefore
+	var sqlPool *SQLPoolStats
+	if cfg.SQL != nil {
+		sqlPool = cfg.SQL.poolStats().since(poolBefore)
+	}
+	var delivery *DeliveryStats
+	if cfg.MQ != nil {
+		delivery = cfg.MQ.deliveryStats(deliveredBefore)
+	}
+	sessionStats := sessions.stats()
+	var diskStats *DiskStats
+	if cfg.Disk != nil {
+		diskStats = cfg.Disk.stats(diskBefore, totalDuration)
+	}
+	collection, harness := agg.finish()
+	if err := cfg.requestTrace.Close(); err != nil {
+		return BenchmarkResult{}, fmt.Errorf("request trace: %w", err)
+	}
+	if err := cfg.traceSampler.Close(collection.responseTimes); err != nil {
+		return BenchmarkResult{}, fmt.Errorf("sampled traces: %w", err)
+	}
 
-	totalDuration := time.Since(start)
-	// avgBaselineDurationS := baselineDurationS / float64(baselineIterations)
-	baselineRps := float64(baselineIterations) / baselineDuration.Seconds()
-	resultRps := float64(iterations) / totalDuration.Seconds()
-	maxRps := 1 / workTime.Seconds()
+	completed := collection.responseTimes.Count() + collection.timeouts
+	freqTrace, throttleEvents := freq.Stop()
+	memoryTrace := memory.Stop()
+	coreCPUs, coreTrace := cores.Stop()
+	cpuTime, joules := energy.Stop()
+	accounting := RequestAccounting{
+		Submitted: int(atomic.LoadInt64(&submitted)),
+		Succeeded: collection.responseTimes.Count() - collection.errors,
+		Failed:    collection.errors,
+		TimedOut:  collection.timeouts,
+		Rejected:  admission.rejections(),
+	}
+	accounting.Lost = accounting.Submitted - accounting.Succeeded - accounting.Failed - accounting.TimedOut - accounting.Rejected
+	accounting.LeakedGoroutines, accounting.Leaks = checkLeaks(goroutinesBefore)
+	if c, ok := clock.(*scaledClock); ok {
+		// Compressed runs do a fraction of the work of the run they stand in for.
+		cpuTime = time.Duration(float64(cpuTime) * c.factor)
+		joules *= c.factor
+	}
+	resultRps := float64(completed) / totalDuration.Seconds()
+	cpus := effectiveCPUs(cfg.GOMAXPROCS)
+	maxRps := cpus / cfg.WorkTime.Seconds()
 
-	return BenchmarkResult{
-		WorkTime:        workTime,
-		NetworkTime:     networkTime,
-		Iterations:      iterations,
-		NumCoroutines:   numGreenThreads,
-		ThroughputRps:   resultRps,
-		Speedup:         resultRps / baselineRps,
-		CpuUtilization:  resultRps * 100.0 / maxRps,
-		ResponseTimesMs: responseTimesMs,
-		LongestRequest:  longestRequest.output,
+	result := BenchmarkResult{
+		WorkTime:         cfg.WorkTime,
+		NetworkTime:      cfg.NetworkTime,
+		Iterations:       completed,
+		NumCoroutines:    cfg.NumCoroutines,
+		Config:           cfg,
+		ThroughputRps:    resultRps,
+		Speedup:          speedup(resultRps, baselineRps),
+		CpuUtilization:   resultRps * 100.0 / maxRps,
+		EffectiveCPUs:    cpus,
+		ResponseTimes:    collection.responseTimes,
+		Latency:          latencyStats(collection.responseTimes),
+		Outliers:         detectOutliers(cfg.Outliers, collection.responseTimes),
+		ThroughputSeries: throughputSeries(collection.completions, cfg.ThroughputInterval, totalDuration),
+		LatencyTrace:     collection.trace.sorted(),
+		Phases:           collection.phases.results(),
+		QueueWaits:       collection.queueWaits,
+		CallerTimes:      collection.callerTimes,
+		QueueLength:      collection.queued.Seconds() / totalDuration.Seconds(),
+		LongestRequest:   collection.longestRequest.log.String(),
+		Errors:           collection.errors,
+		ErrorTimes:       collection.errorTimes,
+		NetworkPhases:    cfg.downstream.phasesMade(),
+		DownstreamCalls:  cfg.downstream.callsMade(),
+		Breaker:          cfg.downstream.breakerStats(),
+		Hedges:           cfg.downstream.hedgeStats(cfg),
+		Bursts:           bursts.stats(),
+		Brownout:         collection.brownout,
+		ExitStatuses:     collection.exitStatuses,
+		SQLPool:          sqlPool,
+		Delivery:         delivery,
+		DiskStats:        diskStats,
+		Sessions:         sessionStats,
+		LimitTrace:       adaptive.limitTrace(),
+		GCCycles:         gcCycles() - gcBefore,
+		Scheduler:        schedStats,
+		CPUProfile:       cpuProfile,
+		Host:             currentHost(),
+		Middleware:       middlewareOverhead(layerTimers, completed),
+		Err:              runErr,
+		Harness:          harness,
+		Measurement:      newMeasurementOverhead(cfg, collection, completed, harness),
+		ThreadsCreated:   threadsCreated,
+		Timeouts:         collection.timeouts,
+		Rejected:         admission.rejections(),
+		Accounting:       accounting,
+		Fairness:         fairness,
+		RateLimitStats:   limited.stats(),
+		FreqTrace:        freqTrace,
+		MemoryTrace:      memoryTrace,
+		CoreCPUs:         coreCPUs,
+		CoreTrace:        coreTrace,
+		ThrottleEvents:   throttleEvents,
+		Started:          started,
+		CPUTime:          cpuTime,
+		EnergyJoules:     joules,
+		PrecisionRSE:     precision.RSE(),
+		QueueModel:       modelQueue(cfg, collection, completed, resultRps, totalDuration),
+		AvgConcurrency:   collection.busy.Seconds() / totalDuration.Seconds(),
+		WastedWork:       collection.wasted,
+		WastedFraction:   wastedFraction(collection.wasted, collection.busy),
+	}
+	for _, class := range cfg.Mix {
+		result.Classes = append(result.Classes, ClassResult{Name: class.Name, Priority: class.Priority,
+			ResponseTimes: collection.classes[class.Name], CallerTimes: collection.classCallers[class.Name]})
+	}
+	for _, slow := range collection.slowest {
+		if slow.log != nil {
+			result.Slowest = append(result.Slowest, slow.log.timeline(slow.timeTaken))
+		}
 	}
+	if collection.budget != nil {
+		result.Budget = collection.budget.summarize(harness)
+	}
+	if err := applySteadyState(&result, collection); err != nil {
+		return BenchmarkResult{}, err
+	}
+	result.Failure = classifyFailure(result)
+	return result, reporter.OnRunComplete(result)
 }
 
-func outputBenchmarkResult(result BenchmarkResult, printDetails bool) {
-	fmt.Printf("%v CPU/%v Network per request (%d requests with %d co-routines)\n", result.WorkTime, result.NetworkTime, result.Iterations, result.NumCoroutines)
-	fmt.Printf("\tThroughput: %.2f rps (%.2fX Speedup)\n", result.ThroughputRps, result.Speedup)
-	fmt.Printf("\tCPU Utilization: %.2f%%\n", result.CpuUtilization)
-	for _, pct := range []float64{50, 95, 99} {
-		fmt.Printf("\tp%.0f: %.2fms\n", pct, result.ResponseTimesPercentile(pct))
+// outputBenchmarkResult prints result, with its Speedup taken to be over the given basis.
+func outputBenchmarkResult(result BenchmarkResult, printDetails, ascii bool, basis string) {
+	fmt.Printf("%s CPU/%s Network per request in %d splits (%s requests with %s co-routines, GOMAXPROCS=%d, %s executor, %v)\n", formatDuration(result.WorkTime), formatDuration(result.NetworkTime), result.Config.Splits, formatCount(int64(result.Iterations)), formatCount(result.NumCoroutines), result.Config.GOMAXPROCS, result.Config.Executor, result.Config.Load)
+	if result.Config.CPUDist.String() != "fixed" || result.Config.NetworkDist.String() != "fixed" {
+		fmt.Printf("\tCPU time %v, network time %v\n", result.Config.CPUDist, result.Config.NetworkDist)
+	}
+	if !result.Config.SplitsDist.fixed() {
+		fmt.Printf("\tSplits per request: %v, %s on average\n", result.Config.SplitsDist, formatFloat(result.Config.SplitsDist.Mean(result.Config.Splits), 2))
+	}
+	if len(result.Config.Mix) > 0 {
+		fmt.Printf("\tTraffic mix: %v\n", result.Config.Mix)
 	}
-	if printDetails {
+	if len(result.Config.Tags) > 0 {
+		fmt.Printf("\tTags: %v\n", result.Config.Tags)
+	}
+	if result.Config.Note != "" {
+		fmt.Printf("\tNote: %s\n", result.Config.Note)
+	}
+	if result.Config.Target != nil {
+		fmt.Printf("\tTarget: %v\n", result.Config.Target)
+	}
+	switch {
+	case basis == speedupIdeal && result.Speedup > 0:
+		fmt.Printf("\tThroughput: %s (%s of ideal)\n", formatRps(result.ThroughputRps), formatPercent(result.Speedup))
+	case basis == speedupSingle && result.Speedup > 0:
+		fmt.Printf("\tThroughput: %s (%sX Speedup over 1 co-routine)\n", formatRps(result.ThroughputRps), formatFloat(result.Speedup, 2))
+	case result.Speedup > 0:
+		fmt.Printf("\tThroughput: %s (%sX Speedup)\n", formatRps(result.ThroughputRps), formatFloat(result.Speedup, 2))
+	default:
+		fmt.Printf("\tThroughput: %s\n", formatRps(result.ThroughputRps))
+	}
+	fmt.Printf("\tCPU Utilization: %s of %s CPUs\n", formatPercent(result.CpuUtilization/100), formatFloat(result.EffectiveCPUs, 2))
+	if result.Err != nil {
+		fmt.Printf("\tAborted after %s of %s requests: %v\n", formatCount(int64(result.Iterations)), formatCount(int64(result.Config.Iterations)), result.Err)
+	}
+	if result.Failure != nil {
+		fmt.Printf("\tFAILED (%s)\n", result.Failure.Kind)
+	}
+	if result.Config.Faults.enabled() {
+		fmt.Printf("\tInjected faults: %v\n", result.Config.Faults)
+	}
+	if result.Errors > 0 {
+		fmt.Printf("\tErrors: %s (%s)", formatCount(int64(result.Errors)), formatPercent(float64(result.Errors)/float64(result.Iterations)))
+		if result.ErrorTimes != nil && result.ErrorTimes.Count() > 0 {
+			fmt.Printf(", failing after p50 %s, p99 %s", formatMs(result.ErrorTimes.Percentile(50)), formatMs(result.ErrorTimes.Percentile(99)))
+		}
+		fmt.Printf("; %s succeeded\n", formatCount(int64(result.Iterations-result.Errors-result.Timeouts)))
+	}
+	if result.Config.Retry.enabled() {
+		fmt.Printf("\tRetries: %v\n", result.Config.Retry)
+		if result.NetworkPhases > 0 {
+			fmt.Printf("\tAmplification: %s× (%s downstream calls for %s network phases)\n", formatFloat(result.Amplification(), 3),
+				formatCount(result.DownstreamCalls), formatCount(result.NetworkPhases))
+		}
+	}
+	if result.Config.Breaker.enabled() {
+		fmt.Printf("\tCircuit breaker: %v\n", result.Config.Breaker)
+		b := result.Breaker
+		fmt.Printf("\tBreaker opened %s times, for %s in all, rejecting %s calls\n", formatCount(b.Opened),
+			formatDuration(b.OpenTime), formatCount(b.Rejected))
+	}
+	outputBrownout(result.Brownout)
+	outputFanOut(result.Config)
+	outputHedging(result)
+	outputBursts(result)
+	outputSteadyState(result)
+	outputShedding(result)
+	outputRateLimit(result)
+	outputSimulation(result)
+	outputExitStatuses(result)
+	outputDisk(result)
+	outputSQLPool(result)
+	outputDelivery(result)
+	outputAdaptiveLimit(result)
+	outputGC(result)
+	outputScheduler(result.Scheduler)
+	outputApdex(result)
+	if result.Config.RequestTimeout > 0 {
+		fmt.Printf("\tTimeouts: %s (%s) over %v, excluded from the percentiles below\n", formatCount(int64(result.Timeouts)),
+			formatPercent(result.TimeoutRate()), result.Config.RequestTimeout)
+		outputWastedWork(result)
+	}
+	for _, pct := range result.Config.percentiles() {
+		fmt.Printf("\t%s: %s\n", percentileName(pct), formatMs(result.ResponseTimesPercentile(pct)))
+	}
+	if result.ResponseTimes.Count() > 0 {
+		fmt.Printf("\tmin %s, mean %s, stddev %s, max %s\n", formatMs(result.Latency.Min), formatMs(result.Latency.Mean),
+			formatMs(result.Latency.StdDev), formatMs(result.Latency.Max))
+	}
+	if result.Outliers != nil {
+		outputOutliers(result.Outliers, result.Config.percentiles())
+	}
+	outputQueueWait(result, result.Config.percentiles())
+	outputPhases(result.Phases, result.Config.percentiles())
+	if cfg := result.Config; cfg.Precision > 0 {
+		verdict := "reached"
+		if result.PrecisionRSE > cfg.Precision {
+			verdict = fmt.Sprintf("not reached within %s requests", formatCount(int64(cfg.MaxIterations)))
+		}
+		fmt.Printf("\tPrecision: p%g known to ±%s, target ±%s %s\n", cfg.PrecisionPercentile, formatPercent(result.PrecisionRSE),
+			formatPercent(cfg.Precision), verdict)
+	}
+	prioritized := result.Config.prioritized()
+	openLoop := result.Config.Load.Kind != "" && result.Config.Load.Kind != "closed"
+	for _, class := range result.Classes {
+		var parts, caller []string
+		for _, pct := range result.Config.percentiles() {
+			parts = append(parts, percentileName(pct)+" "+formatMs(class.ResponseTimes.Percentile(pct)))
+			if class.CallerTimes != nil {
+				caller = append(caller, percentileName(pct)+" "+formatMs(class.CallerTimes.Percentile(pct)))
+			}
+		}
+		requests := formatCount(int64(class.ResponseTimes.Count())) + " requests"
+		if prioritized {
+			requests = fmt.Sprintf("priority %d, %s", class.Priority, requests)
+		}
+		fmt.Printf("\t%s (%s): %s, mean %s\n", class.Name, requests, strings.Join(parts, ", "),
+			formatMs(latencyStats(class.ResponseTimes).Mean))
+		if openLoop && len(caller) > 0 && class.CallerTimes.Count() > 0 {
+			fmt.Printf("\t\tqueue wait included: %s\n", strings.Join(caller, ", "))
+		}
+	}
+	for _, m := range result.Middleware {
+		fmt.Printf("\tMiddleware %s overhead: %s per request\n", m.Name, formatDuration(m.PerRequest))
+	}
+	for i, p := range result.Processes {
+		if p.Host != "" {
+			fmt.Printf("\tAgent %s: %s co-routines, %s requests, %s, p50 %s, p99 %s\n", p.Host, formatCount(p.Coroutines), formatCount(int64(p.Iterations)),
+				formatRps(p.ThroughputRps), formatMs(p.P50), formatMs(p.P99))
+			continue
+		}
+		fmt.Printf("\tProcess %d: %s co-routines, %s requests, %s, p50 %s, p99 %s\n", i, formatCount(p.Coroutines), formatCount(int64(p.Iterations)),
+			formatRps(p.ThroughputRps), formatMs(p.P50), formatMs(p.P99))
+	}
+	if result.ThreadsCreated > 0 {
+		fmt.Printf("\tOS threads created: %s\n", formatCount(int64(result.ThreadsCreated)))
+	}
+	if result.Harness.Batch > 0 {
+		fmt.Printf("\tHarness overhead: %s delivering, %s collecting per sample (batches of %d)\n",
+			formatDuration(result.Harness.DeliverPerSample), formatDuration(result.Harness.CollectPerSample), result.Harness.Batch)
+	}
+	outputMeasurementOverhead(result)
+	if result.Budget != nil {
+		outputBudget(result.Budget)
+	}
+	if result.QueueModel != nil {
+		outputQueueModel(result.QueueModel)
+	}
+	if result.AvgConcurrency > 0 {
+		fmt.Printf("\tLittle's law: %s × %s mean response time = %s requests in flight on average\n",
+			formatRps(result.ThroughputRps), formatMs(result.AvgConcurrency/result.ThroughputRps*1000), formatFloat(result.AvgConcurrency, 2))
+		if w := littlesLawWarning(result); w != "" {
+			fmt.Printf("\tWarning: %s\n", w)
+		}
+	}
+	outputVirtualUsers(result)
+	outputSessions(result)
+	outputAccounting(result)
+	outputFairness(result)
+	if result.Iterations > 0 {
+		efficiency := formatFloat(result.CPUSecondsPer1000(), 3) + " CPU-seconds"
+		if result.EnergyJoules > 0 {
+			efficiency += ", " + formatFloat(result.JoulesPer1000(), 1) + " J"
+		}
+		fmt.Printf("\tEfficiency: %s per 1000 requests\n", efficiency)
+	}
+	outputMemory(result.MemoryTrace)
+	outputCores(result)
+	for _, w := range freqWarnings(result.FreqTrace, result.ThrottleEvents) {
+		fmt.Printf("\tWarning: %s\n", w)
+	}
+	if ascii {
+		outputAsciiResult(result)
+	}
+	if printDetails && result.LongestRequest != "" {
 		fmt.Println("=========================================")
 		fmt.Println("Longest Request:")
 		for _, line := range strings.Split(result.LongestRequest, "\n") {
 			fmt.Println("\t" + line)
 		}
 	}
-
-	saveHistogram(result)
 }
 
-func saveHistogram(result BenchmarkResult) {
+func histogramPlot(result BenchmarkResult, opts PlotOptions) (*plot.Plot, error) {
 	p := plot.New()
-	hist, err := plotter.NewHist(plotter.Values(result.ResponseTimesMs), 20)
-	if err != nil {
-		panic(err)
-	}
+	values := result.ResponseTimes.Values()
+	lo, hi := opts.histogramRange(values)
+	hist, outside := opts.newHistogram(values, lo, hi)
 	p.Add(hist)
-	err = p.Save(4*vg.Inch, 4*vg.Inch, "hist.png")
+	if outside > 0 {
+		p.Title.Text = fmt.Sprintf("%d requests outside %s to %s not shown", outside, formatMs(lo), formatMs(hi))
+	}
+	opts.fitLatency(&p.X)
+	latencyTicks(&p.X)
+	return p, nil
+}
+
+func saveHistogram(result BenchmarkResult, opts PlotOptions) error {
+	if result.ResponseTimes.Count() == 0 {
+		return nil
+	}
+	p, err := histogramPlot(result, opts)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	return opts.save(p, 4*vg.Inch, 4*vg.Inch, opts.histFile(result))
+}
+
+// cdfPoints returns the empirical CDF of values or, with complementary set, the fraction of requests slower than each
+// value. The complementary curve drops its final zero so that it can be drawn on a log scale.
+func cdfPoints(values []float64, complementary bool) plotter.XYs {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	n := float64(len(sorted))
+	var pts plotter.XYs
+	for i, v := range sorted {
+		y := float64(i+1) / n
+		if complementary {
+			if y = 1 - y; y <= 0 {
+				break
+			}
+		}
+		pts = append(pts, plotter.XY{X: v, Y: y})
 	}
+	return pts
 }
 
-func throughputBenchmark() {
-	var results []BenchmarkResult
-	for _, numGreenThreads := range []int64{1, 3, 5, 7, 9, 11, 13, 15, 17, 19, 21, 23} {
-		result := runBenchmark(
-			time.Duration(5)*time.Millisecond,
-			time.Duration(55)*time.Millisecond,
-			numGreenThreads, 5, 100, 100)
-		outputBenchmarkResult(result, true)
-		results = append(results, result)
+// saveCDF plots the run's response time CDF, and its complement on a log scale where the tail is easy to read.
+func saveCDF(result BenchmarkResult, opts PlotOptions) error {
+	values := result.ResponseTimes.Values()
+	if len(values) == 0 {
+		return nil
+	}
+	for _, complementary := range []bool{false, true} {
+		p := plot.New()
+		p.X.Label.Text = latencyLabel("Response time")
+		p.Y.Label.Text = "Fraction of requests"
+		opts.latencyAxis(&p.X, lowestLatency([]BenchmarkResult{result}))
+		file := "cdf.png"
+		if complementary {
+			p.Y.Label.Text = "Fraction of requests slower"
+			p.Y.Scale = plot.LogScale{}
+			p.Y.Tick.Marker = plot.LogTicks{}
+			file = "ccdf.png"
+		}
+		pts := cdfPoints(values, complementary)
+		if len(pts) == 0 {
+			continue
+		}
+		line, err := plotter.NewLine(pts)
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = color.RGBA{B: 255, A: 255}
+		p.Add(line)
+		opts.fitLatency(&p.X)
+		if err := opts.save(p, 4*vg.Inch, 4*vg.Inch, opts.runFile(result, file)); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	plotThroughput(results)
-	plotLatency(results)
+// Sweep lists the dimensions swept alongside the coroutine count.
+type Sweep struct {
+	Executors    []string
+	GOMAXPROCS   []int   // 0 keeps the current setting
+	Coroutines   []int64 // defaults to defaultCoroutines
+	Splits       []int   // nil keeps the base configuration's, as do nil WorkTimes, NetworkTimes, RateLimits, SQLMaxOpen, MQConsumers, FanOutLimits, HedgeAfters, Propagation, Dispatch, GOGC, MemoryLimits and Placements
+	WorkTimes    []time.Duration
+	NetworkTimes []time.Duration
+	RateLimits   []RateLimit
+	SQLMaxOpen   []int
+	MQConsumers  []int
+	FanOutLimits []int
+	HedgeAfters  []time.Duration // 0 does not hedge
+	Propagation  []bool          // whether requests' deadlines are propagated
+	Dispatch     []string
+	GOGC         []int
+	MemoryLimits []int64
+	Placements   []string
+	Sample       int // run only this many configurations drawn at random from the grid; 0 runs them all
+	Repeat       int // runs of each configuration, back to back; 0 runs each once
+	Isolation    Isolation
+	Tune         *Tuner // search the co-routine counts' range for the highest meeting an SLO instead of running each; nil to sweep
 }
 
-func plotThroughput(results []BenchmarkResult) {
-	plt := plot.New()
-	plt.Title.Text = "Throughput Increase vs. Number of Co-Routines"
-	plt.X.Label.Text = "Number of Co-Routines"
-	plt.Y.Label.Text = "Speedup"
-	plt.Y.Min = 0
+var defaultCoroutines = []int64{1, 3, 5, 7, 9, 11, 13, 15, 17, 19, 21, 23}
 
-	var pts plotter.XYs
+// throughputBenchmark runs the sweep until it finishes or ctx is cancelled, in which case the interrupted run is still
+// reported and ctx's error returned.
+func throughputBenchmark(ctx context.Context, base RunConfig, sweep Sweep, reporter Reporter) error {
+	first := true
+	runConfig := func(cfg RunConfig) ([]BenchmarkResult, error) {
+		var results []BenchmarkResult
+		for rep := 0; rep < sweep.Repeat || rep == 0; rep++ {
+			if !first && !cfg.Simulate {
+				sweep.Isolation.between()
+			}
+			first = false
+			cfg.Repetition = rep
+			run := runBenchmark
+			if cfg.Processes > 1 {
+				run = runMultiProcess
+			} else if len(cfg.Agents) > 0 {
+				run = runDistributed
+			}
+			result, err := run(ctx, cfg, reporter)
+			if err != nil {
+				return nil, err
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		return results, nil
+	}
+	cfgs := sweep.configs(base)
+	if sweep.Tune != nil {
+		// The co-routine count varies fastest, so each group of that many configurations differs in nothing else.
+		coroutines := len(sweep.Coroutines)
+		if coroutines == 0 {
+			coroutines = len(defaultCoroutines)
+		}
+		return sweep.Tune.tune(cfgs, coroutines, runConfig)
+	}
+	for _, cfg := range cfgs {
+		if _, err := runConfig(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseGOMAXPROCS(s string) ([]int, error) {
+	var values []int
+	for _, v := range strings.Split(s, ",") {
+		p, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || p < 1 {
+			return nil, fmt.Errorf("invalid GOMAXPROCS value %q", v)
+		}
+		values = append(values, p)
+	}
+	return values, nil
+}
+
+// flagGiven reports whether the flag name was given on fs, whatever its value.
+func flagGiven(fs *flag.FlagSet, name string) bool {
+	given := false
+	fs.Visit(func(f *flag.Flag) { given = given || f.Name == name })
+	return given
+}
+
+// parseSweepValues parses the values of a swept dimension, such as the co-routine counts: a comma-separated list of
+// values, arithmetic ranges such as 1..64:4 (1, 5, 9, ... 61; the step defaults to 1) and geometric ones such as
+// 1..256*2 (1, 2, 4, ... 256). Ranges include their upper bound when a step lands on it. The values are run in
+// increasing order, each once; what names them in errors and min is the lowest allowed.
+func parseSweepValues(s, what string, min int64) ([]int64, error) {
+	seen := map[int64]bool{}
+	var values []int64
+	add := func(n int64) {
+		if !seen[n] {
+			seen[n] = true
+			values = append(values, n)
+		}
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		dots := strings.Index(item, "..")
+		if dots < 0 {
+			n, err := strconv.ParseInt(item, 10, 64)
+			if err != nil || n < min {
+				return nil, fmt.Errorf("invalid %s %q", what, item)
+			}
+			add(n)
+			continue
+		}
+		from, err := strconv.ParseInt(item[:dots], 10, 64)
+		if err != nil || from < min {
+			return nil, fmt.Errorf("invalid %s range %q: it must start at %d or more", what, item, min)
+		}
+		rest, step, geometric := item[dots+2:], int64(1), false
+		if i := strings.IndexAny(rest, ":*"); i >= 0 {
+			geometric = rest[i] == '*'
+			if step, err = strconv.ParseInt(rest[i+1:], 10, 64); err != nil || step < 1 || (geometric && step < 2) {
+				return nil, fmt.Errorf("invalid %s range %q: the step must be a positive integer, and a factor at least 2", what, item)
+			}
+			if geometric && from < 1 {
+				return nil, fmt.Errorf("invalid %s range %q: a geometric progression must start at 1 or more", what, item)
+			}
+			rest = rest[:i]
+		}
+		to, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil || to < from {
+			return nil, fmt.Errorf("invalid %s range %q: it must end no lower than it starts", what, item)
+		}
+		for n := from; n <= to; {
+			add(n)
+			if len(values) > 10000 {
+				return nil, fmt.Errorf("%s range %q has more than 10,000 values", what, item)
+			}
+			if geometric {
+				n *= step
+			} else {
+				n += step
+			}
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values, nil
+}
+
+// bySeries splits sweep results into one series per executor, GOMAXPROCS setting and value of each grid dimension, in
+// the order they ran, each in increasing co-routine count and runs of the same count in the order they ran. Series
+// are labelled by whichever of them were swept.
+func bySeries(results []BenchmarkResult) (labels []string, series map[string][]BenchmarkResult) {
+	executors := map[string]bool{}
+	procs := map[int]bool{}
+	cfgs := make([]RunConfig, len(results))
+	for i, result := range results {
+		executors[result.Config.Executor] = true
+		procs[result.Config.GOMAXPROCS] = true
+		cfgs[i] = result.Config
+	}
+	dims := sweptDims(cfgs)
+	series = map[string][]BenchmarkResult{}
 	for _, result := range results {
-		pts = append(pts, plotter.XY{X: float64(result.NumCoroutines), Y: result.Speedup})
+		label := seriesLabel(result.Config, len(executors) > 1 || len(procs) == 1, len(procs) > 1, dims)
+		if _, ok := series[label]; !ok {
+			labels = append(labels, label)
+		}
+		series[label] = append(series[label], result)
 	}
-	line, err := plotter.NewLine(pts)
-	if err != nil {
-		panic(err)
+	// Sweeps run their counts in order already; a tuning search jumps about the range.
+	for _, runs := range series {
+		sort.SliceStable(runs, func(i, j int) bool { return runs[i].NumCoroutines < runs[j].NumCoroutines })
 	}
-	line.LineStyle.Width = vg.Points(3)
-	line.LineStyle.Color = color.RGBA{B: 255, A: 255}
-	plt.Add(line)
+	return labels, series
+}
 
-	plt.Legend.Add("line", line)
-	err = plt.Save(4*vg.Inch, 4*vg.Inch, "throughput_vs_coroutines.png")
-	if err != nil {
-		panic(err)
+// seriesLabel names the series cfg belongs to, by its executor and GOMAXPROCS setting if asked to, its value of each
+// of dims and its tags.
+func seriesLabel(cfg RunConfig, executor, procs bool, dims []gridDim) string {
+	var parts []string
+	if executor {
+		parts = append(parts, cfg.Executor)
 	}
+	if procs {
+		parts = append(parts, fmt.Sprintf("GOMAXPROCS=%d", cfg.GOMAXPROCS))
+	}
+	for _, d := range dims {
+		parts = append(parts, d.label(cfg))
+	}
+	label := strings.Join(parts, ", ")
+	if len(cfg.Tags) > 0 {
+		label += " [" + cfg.Tags.String() + "]"
+	}
+	return label
 }
 
-func plotLatency(results []BenchmarkResult) {
+func throughputPlot(results []BenchmarkResult, opts PlotOptions) (*plot.Plot, error) {
 	plt := plot.New()
 	plt.Title.Text = "Throughput Increase vs. Number of Co-Routines"
 	plt.X.Label.Text = "Number of Co-Routines"
-	plt.Y.Label.Text = "Latency"
+	plt.Y.Label.Text = speedupLabel(opts.Speedup)
 	plt.Y.Min = 0
+	results = normalizeSpeedups(results, opts.Speedup)
+	y := func(r BenchmarkResult) float64 { return r.Speedup }
+	baseline := false
+	for _, r := range results {
+		baseline = baseline || r.Speedup > 0
+	}
+	if !baseline {
+		// With -baseline off there is nothing to be a speedup over, so throughput itself is drawn, without the models.
+		plt.Y.Label.Text = throughputLabel("Throughput")
+		throughputTicks(&plt.Y)
+		y = func(r BenchmarkResult) float64 { return r.ThroughputRps }
+	}
 
-	for _, percentile := range []float64{50, 95, 99} {
-		var pts plotter.XYs
-		for _, result := range results {
-			latency := result.ResponseTimesPercentile(percentile)
-			if latency > plt.Y.Max {
-				plt.Y.Max = latency + 20
+	labels, series := bySeries(results)
+	for i, label := range labels {
+		var c color.Color = color.RGBA{B: 255, A: 255}
+		if len(labels) > 1 {
+			c = plotutil.Color(i)
+		}
+		line, err := sweepLine(plt, series[label], y, c)
+		if err != nil {
+			return nil, err
+		}
+		if line == nil {
+			continue // no run at one co-routine to be a speedup over
+		}
+		line.LineStyle.Width = vg.Points(3)
+		plt.Legend.Add(label, line)
+		if !baseline {
+			continue
+		}
+		if opts.Speedup == speedupIdeal {
+			// Against the ideal, the models are the line at 1.
+			if err := addKnee(plt, series[label], opts.KneeThreshold, c); err != nil {
+				return nil, err
 			}
-			pts = append(pts, plotter.XY{X: float64(result.NumCoroutines), Y: latency})
+			continue
+		}
+		// The models share their series' colour, so a lone series needs no label on them.
+		modelLabel := label
+		if len(labels) == 1 {
+			modelLabel = ""
 		}
+		if err := addUSLFit(plt, series[label], modelLabel, c); err != nil {
+			return nil, err
+		}
+		if err := addIdealSpeedup(plt, series[label], modelLabel, c); err != nil {
+			return nil, err
+		}
+		if err := addKnee(plt, series[label], opts.KneeThreshold, c); err != nil {
+			return nil, err
+		}
+	}
+	plt.Legend.Top = true
+	plt.Legend.Left = true
+	if opts.Speedup == speedupIdeal && baseline {
+		// The curves run along the top, under 1, so the legend goes beneath them.
+		plt.Legend.Top = false
+		plt.Y.Max = math.Max(plt.Y.Max, 1.1)
+	}
 
-		line, _ := plotter.NewLine(pts)
-		line.LineStyle.Width = vg.Points(1)
-		line.LineStyle.Color = map[float64]color.RGBA{
-			50: {R: 255, A: 255},
-			95: {G: 255, A: 255},
-			99: {B: 255, A: 255},
-		}[percentile]
-		plt.Add(line)
-		plt.Legend.Add(fmt.Sprintf("p%.0f response time", percentile), line)
+	if err := addFailedMarks(plt, results, y); err != nil {
+		return nil, err
 	}
 
-	err := plt.Save(4*vg.Inch, 4*vg.Inch, "latency_vs_coroutines.png")
+	opts.fitCoroutines(&plt.X)
+	return plt, nil
+}
+
+func plotThroughput(results []BenchmarkResult, opts PlotOptions) error {
+	plt, err := throughputPlot(results, opts)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	return opts.save(plt, 4*vg.Inch, 4*vg.Inch, opts.sweepFile("throughput_vs_coroutines.png"))
 }
 
-func main() {
-	throughputBenchmark()
+func latencyPlot(results []BenchmarkResult, opts PlotOptions) (*plot.Plot, error) {
+	plt := plot.New()
+	plt.Title.Text = "Throughput Increase vs. Number of Co-Routines"
+	plt.X.Label.Text = "Number of Co-Routines"
+	plt.Y.Label.Text = latencyLabel("Latency")
+	opts.latencyAxis(&plt.Y, lowestLatency(results))
+
+	for _, result := range results {
+		if latency := result.ResponseTimesPercentile(99); latency > plt.Y.Max {
+			plt.Y.Max = latency + 20
+		}
+	}
+
+	// When comparing several series, plot p99 once per series rather than every percentile.
+	labels, series := bySeries(results)
+	if len(labels) > 1 {
+		for i, label := range labels {
+			line, err := sweepLine(plt, series[label], func(r BenchmarkResult) float64 { return r.ResponseTimesPercentile(99) }, plotutil.Color(i))
+			if err != nil {
+				return nil, err
+			}
+			if line != nil {
+				line.LineStyle.Width = vg.Points(1)
+				plt.Legend.Add("p99 "+label, line)
+			}
+		}
+	} else {
+		for i, percentile := range results[0].Config.percentiles() {
+			percentile := percentile
+			var c color.Color = plotutil.Color(i)
+			// The default three keep the colors they have always been drawn in.
+			if rgb, ok := map[float64]color.RGBA{
+				50: {R: 255, A: 255},
+				95: {G: 255, A: 255},
+				99: {B: 255, A: 255},
+			}[percentile]; ok {
+				c = rgb
+			}
+			line, err := sweepLine(plt, results, func(r BenchmarkResult) float64 { return r.ResponseTimesPercentile(percentile) }, c)
+			if err != nil {
+				return nil, err
+			}
+			if line != nil {
+				line.LineStyle.Width = vg.Points(1)
+				plt.Legend.Add(percentileName(percentile)+" response time", line)
+			}
+		}
+	}
+
+	if err := addFailedMarks(plt, results, func(r BenchmarkResult) float64 { return r.ResponseTimesPercentile(99) }); err != nil {
+		return nil, err
+	}
+
+	opts.fitCoroutines(&plt.X)
+	opts.fitLatency(&plt.Y)
+	return plt, nil
+}
+
+func plotLatency(results []BenchmarkResult, opts PlotOptions) error {
+	plt, err := latencyPlot(results, opts)
+	if err != nil {
+		return err
+	}
+	return opts.save(plt, 4*vg.Inch, 4*vg.Inch, opts.sweepFile("latency_vs_coroutines.png"))
+}
+
+// plotSummary puts the sweep's throughput, latency and CPU utilization curves and the response time histogram of its
+// highest-throughput run into one figure, so that a single image tells the story of the experiment.
+func plotSummary(results []BenchmarkResult, opts PlotOptions) error {
+	throughput, err := throughputPlot(results, opts)
+	if err != nil {
+		return err
+	}
+	latency, err := latencyPlot(results, opts)
+	if err != nil {
+		return err
+	}
+	latency.Title.Text = "Response Time vs. Number of Co-Routines"
+
+	utilization := plot.New()
+	utilization.Title.Text = "CPU Utilization vs. Number of Co-Routines"
+	utilization.X.Label.Text = "Number of Co-Routines"
+	utilization.Y.Label.Text = "CPU utilization (%)"
+	utilization.Y.Min = 0
+	labels, series := bySeries(results)
+	for i, label := range labels {
+		line, err := sweepLine(utilization, series[label], func(r BenchmarkResult) float64 { return r.CpuUtilization }, plotutil.Color(i))
+		if err != nil {
+			return err
+		}
+		if len(labels) > 1 {
+			utilization.Legend.Add(label, line)
+		}
+	}
+	opts.fitCoroutines(&utilization.X)
+
+	best := results[0]
+	for _, result := range results {
+		if result.ResponseTimes.Count() > 0 && (best.ResponseTimes.Count() == 0 || result.ThroughputRps > best.ThroughputRps) {
+			best = result
+		}
+	}
+	hist := plot.New()
+	if best.ResponseTimes.Count() > 0 {
+		if hist, err = histogramPlot(best, opts); err != nil {
+			return err
+		}
+	}
+	hist.Title.Text = fmt.Sprintf("Response Times at %d Co-Routines (highest throughput)", best.NumCoroutines)
+	hist.X.Label.Text = latencyLabel("Response time")
+	hist.Y.Label.Text = "Requests"
+
+	plots := [][]*plot.Plot{{throughput, latency}, {utilization, hist}}
+	return opts.saveCanvas(10*vg.Inch, 8*vg.Inch, opts.sweepFile("summary.png"), func(dc draw.Canvas) {
+		tiles := draw.Tiles{Rows: 2, Cols: 2, PadX: vg.Millimeter * 6, PadY: vg.Millimeter * 6, PadTop: vg.Millimeter * 2,
+			PadBottom: vg.Millimeter * 2, PadLeft: vg.Millimeter * 2, PadRight: vg.Millimeter * 2}
+		canvases := plot.Align(plots, tiles, dc)
+		for i := range plots {
+			for j := range plots[i] {
+				plots[i][j].Draw(canvases[i][j])
+			}
+		}
+	})
+}
+
+// sweepLine draws y against the coroutine count of a series' runs, through the mean where a configuration was run
+// more than once, and shades the band between the lowest and highest of those runs so that run-to-run noise can be
+// told apart from a trend. Runs where y is NaN are left out; the line is nil if that leaves nothing to draw.
+func sweepLine(plt *plot.Plot, runs []BenchmarkResult, y func(BenchmarkResult) float64, c color.Color) (*plotter.Line, error) {
+	var mean, low, high plotter.XYs
+	repeated := false
+	for i := 0; i < len(runs); {
+		x := runs[i].NumCoroutines
+		var sum float64
+		n := 0
+		lo, hi := math.Inf(1), math.Inf(-1)
+		j := i
+		for ; j < len(runs) && runs[j].NumCoroutines == x; j++ {
+			v := y(runs[j])
+			if math.IsNaN(v) {
+				continue
+			}
+			sum += v
+			n++
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		if n > 0 {
+			mean = append(mean, plotter.XY{X: float64(x), Y: sum / float64(n)})
+			low = append(low, plotter.XY{X: float64(x), Y: lo})
+			high = append(high, plotter.XY{X: float64(x), Y: hi})
+		}
+		repeated = repeated || j-i > 1
+		i = j
+	}
+	if len(mean) == 0 {
+		return nil, nil
+	}
+
+	if repeated {
+		band := append(plotter.XYs(nil), high...)
+		for k := len(low) - 1; k >= 0; k-- {
+			band = append(band, low[k])
+		}
+		poly, err := plotter.NewPolygon(band)
+		if err != nil {
+			return nil, err
+		}
+		r, g, b, _ := c.RGBA()
+		poly.Color = color.NRGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 48}
+		poly.LineStyle.Width = 0
+		plt.Add(poly)
+	}
+	line, err := plotter.NewLine(mean)
+	if err != nil {
+		return nil, err
+	}
+	line.LineStyle.Color = c
+	plt.Add(line)
+	return line, nil
+}
+
+// addFailedMarks crosses out the points of failed runs, so that a failed configuration is not mistaken for a
+// measurement.
+func addFailedMarks(plt *plot.Plot, results []BenchmarkResult, y func(BenchmarkResult) float64) error {
+	var pts plotter.XYs
+	for _, result := range results {
+		if result.Failure != nil {
+			pt := plotter.XY{X: float64(result.NumCoroutines), Y: y(result)}
+			if math.IsNaN(pt.Y) {
+				pt.Y = plt.Y.Min // no request completed to measure
+			}
+			pts = append(pts, pt)
+		}
+	}
+	if len(pts) == 0 {
+		return nil
+	}
+	marks, err := plotter.NewScatter(pts)
+	if err != nil {
+		return err
+	}
+	marks.GlyphStyle.Shape = draw.CrossGlyph{}
+	marks.GlyphStyle.Radius = vg.Points(5)
+	marks.GlyphStyle.Color = color.RGBA{R: 255, A: 255}
+	plt.Add(marks)
+	plt.Legend.Add("failed", marks)
+	return nil
+}
+
+// plotTradeoff plots p99 latency against throughput with one point per run, labelled with its coroutine count, so an
+// operating point can be read off a single chart.
+func plotTradeoff(results []BenchmarkResult, opts PlotOptions) error {
+	plt := plot.New()
+	plt.Title.Text = "p99 Latency vs. Throughput"
+	plt.X.Label.Text = throughputLabel("Throughput")
+	plt.Y.Label.Text = latencyLabel("p99 response time")
+	plt.X.Min = 0
+	throughputTicks(&plt.X)
+	opts.latencyAxis(&plt.Y, lowestLatency(results))
+
+	labels, series := bySeries(results)
+	for i, label := range labels {
+		var pts plotter.XYs
+		var names []string
+		for _, result := range series[label] {
+			if result.ResponseTimes.Count() == 0 {
+				continue
+			}
+			pts = append(pts, plotter.XY{X: result.ThroughputRps, Y: result.ResponseTimesPercentile(99)})
+			name := strconv.FormatInt(result.NumCoroutines, 10)
+			if result.Failure != nil {
+				name += " (failed)"
+			}
+			names = append(names, name)
+		}
+		if len(pts) == 0 {
+			continue
+		}
+		line, points, err := plotter.NewLinePoints(pts)
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = plotutil.Color(i)
+		points.GlyphStyle.Color = plotutil.Color(i)
+		points.GlyphStyle.Shape = draw.CircleGlyph{}
+		plt.Add(line, points)
+		if len(labels) > 1 {
+			plt.Legend.Add(label, line, points)
+		}
+
+		annotations, err := plotter.NewLabels(plotter.XYLabels{XYs: pts, Labels: names})
+		if err != nil {
+			return err
+		}
+		for j := range annotations.TextStyle {
+			annotations.TextStyle[j].XAlign = draw.XLeft
+			annotations.TextStyle[j].YAlign = draw.YBottom
+		}
+		annotations.Offset = vg.Point{X: vg.Points(3), Y: vg.Points(3)}
+		plt.Add(annotations)
+	}
+	plt.Legend.Top = true
+	plt.Legend.Left = true
+
+	opts.fitLatency(&plt.Y)
+	return opts.save(plt, 5*vg.Inch, 4*vg.Inch, opts.sweepFile("latency_vs_throughput.png"))
+}
+
+// plotBoxes draws the response time distribution of every run as a box at its coroutine count, with series side by
+// side when several were swept.
+func plotBoxes(results []BenchmarkResult, opts PlotOptions) error {
+	plt := plot.New()
+	plt.Title.Text = "Response Time Distribution vs. Number of Co-Routines"
+	plt.X.Label.Text = "Number of Co-Routines"
+	plt.Y.Label.Text = latencyLabel("Response time")
+	opts.latencyAxis(&plt.Y, lowestLatency(results))
+
+	labels, series := bySeries(results)
+	for i, label := range labels {
+		offset := (float64(i) - float64(len(labels)-1)/2) * 0.5
+		for j, result := range series[label] {
+			values := result.ResponseTimes.Values()
+			if len(values) == 0 {
+				continue
+			}
+			box, err := plotter.NewBoxPlot(vg.Points(6), float64(result.NumCoroutines)+offset, plotter.Values(values))
+			if err != nil {
+				return err
+			}
+			if len(labels) > 1 {
+				box.FillColor = plotutil.Color(i)
+				if j == 0 {
+					swatch, err := plotter.NewPolygon()
+					if err != nil {
+						return err
+					}
+					swatch.Color = box.FillColor
+					plt.Legend.Add(label, swatch)
+				}
+			}
+			plt.Add(box)
+		}
+	}
+
+	var ticks []plot.Tick
+	for _, result := range series[labels[0]] {
+		ticks = append(ticks, plot.Tick{Value: float64(result.NumCoroutines), Label: strconv.FormatInt(result.NumCoroutines, 10)})
+	}
+	plt.X.Tick.Marker = plot.ConstantTicks(ticks)
+	plt.Legend.Top = true
+
+	opts.fitCoroutines(&plt.X)
+	opts.fitLatency(&plt.Y)
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("latency_box_vs_coroutines.png"))
+}
+
+// plotCDF overlays the response time CDFs of every run of the sweep.
+func plotCDF(results []BenchmarkResult, opts PlotOptions) error {
+	plt := plot.New()
+	plt.Title.Text = "Response Time CDF by Number of Co-Routines"
+	plt.X.Label.Text = latencyLabel("Response time")
+	plt.Y.Label.Text = "Fraction of requests"
+	opts.latencyAxis(&plt.X, lowestLatency(results))
+
+	labels, _ := bySeries(results)
+	for i, result := range results {
+		values := result.ResponseTimes.Values()
+		if len(values) == 0 {
+			continue
+		}
+		line, err := plotter.NewLine(cdfPoints(values, false))
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Width = vg.Points(1)
+		line.LineStyle.Color = plotutil.Color(i)
+		line.LineStyle.Dashes = plotutil.Dashes(i / len(plotutil.DefaultColors))
+		plt.Add(line)
+		label := fmt.Sprintf("%d co-routines", result.NumCoroutines)
+		if len(labels) > 1 {
+			label = fmt.Sprintf("%s, %s", result.Config.Executor, label)
+		}
+		plt.Legend.Add(label, line)
+	}
+	plt.Legend.Top = false
+	plt.Legend.Left = false
+
+	opts.fitLatency(&plt.X)
+	return opts.save(plt, 6*vg.Inch, 6*vg.Inch, opts.sweepFile("cdf_vs_coroutines.png"))
+}
+
+var commands = map[string]func(args []string) error{
+	"migrate":  migrateCommand,
+	"doctor":   doctorCommand,
+	"overhead": overheadCommand,
+	"scenario": scenarioCommand,
+	"query":    queryCommand,
+	"history":  historyCommand,
+	"codegen":  codegenCommand,
+	"compare":  compareCommand,
+	"agent":    agentCommand,
+	"render":   renderCommand,
+	"plot":     plotCommand,
+	"server":   serverCommand,
+}
+
+func main() {
+	run := runSweep
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if command, ok := commands[args[0]]; ok {
+			run, args = command, args[1:]
+		}
+	}
+	if err := run(args); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "Interrupted; results up to this point have been reported.")
+			os.Exit(130)
+		}
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// runSweep is the default command: it runs the throughput sweep described by the flags. SIGINT or SIGTERM stops it
+// gracefully, reporting whatever has been measured so far.
+func runSweep(args []string) error {
+	return sweep(args, nil, "")
+}
+
+// sweep runs the sweep args describe or, with -smoke, each smokePass; given pass, it runs that one, into dir.
+func sweep(args []string, pass *smokePass, dir string) (err error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	diskEnabled := fs.Bool("disk", false, "add a disk I/O phase after every network phase")
+	diskDir := fs.String("disk-dir", "", "directory for the disk workload file (default: system temp dir)")
+	diskFileSize := fs.Int64("disk-file-size", 64<<20, "size in bytes of the disk workload file")
+	diskBlockSize := fs.Int("disk-block-size", 4096, "block size in bytes of each disk operation")
+	diskBlocks := fs.Int("disk-blocks", 16, "number of blocks read or written per disk phase")
+	diskPattern := fs.String("disk-pattern", "", "how disk phases go through the file: sequential-read, random-read, sequential-write, random-write, append, or mixed random reads and writes (default: as -disk-random and -disk-write have it)")
+	diskReadFraction := fs.Float64("disk-read-fraction", 0.7, "fraction of the -disk-pattern mixed blocks that are reads")
+	diskRandom := fs.Bool("disk-random", false, "use random instead of sequential block offsets; short for a random -disk-pattern")
+	diskWrite := fs.Bool("disk-write", false, "write blocks instead of reading them; short for a -disk-pattern that writes")
+	diskFsync := fs.Bool("disk-fsync", false, "fsync after every block write")
+	dnsServer := fs.String("dns", "", "add a DNS lookup after every network phase, against a bundled resolver on localhost or the system's resolvers (default: none)")
+	dnsResolver := fs.String("dns-resolver", "", "resolver making the -dns system lookups: go, or cgo for the C library's (default: whichever Go picks)")
+	dnsName := fs.String("dns-name", "perf.test", "name looked up by -dns; with -dns system, one the system's resolvers know")
+	dnsDelay := fs.Duration("dns-delay", time.Millisecond, "time the -dns bundled resolver takes to answer")
+	sqlDSN := fs.String("sql", "", "add a query after every network phase against the database at this data source name, through database/sql (default: none)")
+	sqlDriver := fs.String("sql-driver", "postgres", "database/sql driver for -sql: postgres, mysql, or sqlite3 when built with -tags sqlite")
+	sqlQuery := fs.String("sql-query", "SELECT 1", "query -sql runs, with placeholders in the driver's syntax for -sql-args")
+	sqlArgs := fs.String("sql-args", "", "comma-separated parameters of -sql-query, each a literal or rand:<n> for a random integer below n drawn from the request's random stream")
+	sqlMaxOpen := fs.String("sql-max-open", "0", "caps on the -sql connection pool's open connections to sweep, as for -coroutines, e.g. 1..64*2; 0 for no cap. Shared out between -processes")
+	sqlMaxIdle := fs.Int("sql-max-idle", 2, "idle connections the -sql pool keeps")
+	sqlLifetime := fs.Duration("sql-conn-lifetime", 0, "longest the -sql pool reuses a connection for (default: as long as it stays healthy)")
+	redisAddr := fs.String("redis", "", "add Redis GETs and SETs after every network phase, against the server at this host:port (default: none)")
+	redisConns := fs.Int("redis-conns", 8, "connections to -redis, shared by the requests, which wait for a free one")
+	redisCommands := fs.Int("redis-commands", 1, "-redis commands per phase")
+	redisPipeline := fs.Bool("redis-pipeline", false, "send each phase's -redis commands together and then read the replies, in one round trip")
+	redisKeys := fs.Int64("redis-keys", 1000, "distinct keys the -redis commands pick from at random, all set before the sweep")
+	redisValueSize := fs.Int("redis-value-size", 100, "bytes of each value -redis sets")
+	redisGets := fs.Float64("redis-get-fraction", 0.9, "fraction of the -redis commands that are GETs rather than SETs")
+	lockEnabled := fs.Bool("lock", false, "add a critical section on a lock shared by all requests after every network phase")
+	lockCriticalSection := fs.Duration("lock-critical-section", 500*time.Microsecond, "time spent busy inside the critical section")
+	lockRW := fs.Bool("lock-rw", false, "use a sync.RWMutex instead of a sync.Mutex")
+	lockReadFraction := fs.Float64("lock-read-fraction", 0.8, "fraction of critical sections taking the read lock when -lock-rw is set")
+	execCommand := fs.String("exec", "", "run this command per request instead of the simulated work, CGI-style, to compare designs that pay for a process per request; split into arguments on spaces, and failing the request if it exits non-zero")
+	plugin := fs.String("plugin", "", "start this command, split into arguments on spaces, as a plugin that does each request instead of the simulated work, answering requests as line-delimited JSON on its standard input and output; see PluginWorkload for the protocol")
+	execShell := fs.Bool("exec-shell", false, "run -exec with sh -c instead of splitting it into arguments")
+	pipelineStages := fs.Int("pipeline-stages", 0, "process requests through a channel pipeline with this many stages instead of a goroutine per request")
+	pipelineBuffer := fs.Int("pipeline-buffer", 0, "buffer size of the channels between pipeline stages")
+	pipelineWorkers := fs.Int("pipeline-workers", 1, "number of goroutines serving each pipeline stage")
+	mqBroker := fs.String("mq", "", "publish every request as a message through this broker, inproc, for consumers that do its work, reporting the delivery latency (default: none)")
+	fanOut := fs.Int("fanout", 0, "make each network phase a scatter-gather of this many downstream calls in parallel, each drawing its own network time from -network-dist, the phase over when the last returns")
+	fanOutLimit := fs.String("fanout-limit", "", "counts of -fanout calls a request has in flight at once to sweep, as for -coroutines, showing how the inner concurrency interacts with the co-routine count (default: all of them at once)")
+	mqConsumers := fs.String("mq-consumers", "4", "counts of -mq consumers to sweep, as for -coroutines, whose count is the producers'; shared out between -processes")
+	mqCapacity := fs.Int("mq-capacity", 1000, "messages the -mq broker holds before publishing waits for consumers to take some")
+	mqMessageSize := fs.Int("mq-message-size", 256, "bytes of each -mq message")
+	network := fs.String("network", "sleep", "how network time is spent: sleep, or http, grpc, tcp or websocket for round-trips to a built-in localhost server, or tls for a round-trip on a new TLS connection each, handshake included")
+	externalURL := fs.String("url", "", "benchmark a service of your own: every network phase requests this URL instead, taking as long as the service does; the URL, -url-body and -url-header are Go templates given .Call, the call's number, and .Delay and .DelayMs, the network time the phase would have slept")
+	urlMethod := fs.String("url-method", "GET", "HTTP method of the requests to -url")
+	urlBody := fs.String("url-body", "", "body of the requests to -url, a template as -url is")
+	var urlHeaders HeaderFlags
+	fs.Var(&urlHeaders, "url-header", "header of the requests to -url, as Name: value, the value a template as -url is; may be repeated")
+	httpMaxIdleConns := fs.Int("http-max-idle-conns", 100, "idle connections kept by the HTTP client when -network=http or with -url")
+	middleware := fs.String("middleware", "", "comma-separated workload middleware, outermost first: timeout=<d>, retry=<attempts>, breaker=<failures>/<cooldown>, tracing, metrics")
+	grpcPayloadSize := fs.Int("grpc-payload-size", 1024, "size in bytes of the payload echoed by each gRPC call when -network=grpc")
+	tcpMessageSize := fs.Int("tcp-message-size", 512, "size in bytes of each message echoed when -network=tcp")
+	tlsResume := fs.Bool("tls-resume", false, "resume TLS sessions when -network=tls, instead of a full handshake for every connection")
+	wsConns := fs.Int("ws-conns", 0, "persistent connections the calls share when -network=websocket (0 opens one whenever all are busy and keeps it, so there are as many as calls in flight)")
+	wsMessageSize := fs.Int("ws-message-size", 512, "size in bytes of each message echoed when -network=websocket")
+	tcpPoolSize := fs.Int("tcp-pool-size", 0, "idle TCP connections kept for reuse when -network=tcp (0 dials a connection per call)")
+	cpuKernel := fs.String("cpu-kernel", "spin", "how requests spend their CPU time: "+cpuKernelNames())
+	cpuDist := fs.String("cpu-dist", "fixed", "distribution of per-request CPU time around its mean: fixed, uniform[:halfwidth], exponential, lognormal[:sigma], pareto[:alpha]")
+	networkDist := fs.String("network-dist", "fixed", "distribution of per-request network time around its mean, as for -cpu-dist")
+	compensateTimer := fs.Bool("compensate-timer", false, "shorten each sleep-based network call by how late the host's timer was calibrated to wake a sleep that long at startup, so that network time means the same across operating systems; real -network backends are left be")
+	baseline := fs.String("baseline", "100", "serial baseline each run's speedup is relative to: a number of requests run one after another, a duration to run them for, such as 2s, or off to skip it and report no speedup. Measured once for each GOMAXPROCS and workload the sweep runs, not for each co-routine count")
+	baselineFile := fs.String("baseline-file", "", "reuse the baselines saved in this JSON file, and save every baseline measured to it, so that later sweeps of the same workload skip measuring them; the file is keyed by GOMAXPROCS and workload dimensions, so use it only with the same workload flags")
+	seed := fs.Int64("seed", 0, "seed for all randomized behavior, making runs reproducible (default: random, printed at startup)")
+	executor := fs.String("executor", "semaphore", "comma-separated execution strategies to compare: "+strings.Join(executorNames, ", "))
+	load := fs.String("load", "closed", "load generator: closed, constant, poisson, ramp, trace, burst, or users for a virtual user per co-routine that thinks for -think-time between its requests")
+	burstSize := fs.Int("burst-size", 50, "requests in each burst of burst load")
+	burstInterval := fs.Duration("burst-interval", time.Second, "time from the start of one burst of burst load to the start of the next")
+	burstLength := fs.Duration("burst-length", 0, "time each burst of burst load is spread over, the rest of -burst-interval being idle (default: all of a burst's requests at once)")
+	thinkTime := fs.Duration("think-time", time.Second, "mean time a -load users virtual user thinks between requests")
+	thinkDist := fs.String("think-dist", "exponential", "distribution of -load users think times around -think-time, as for -cpu-dist")
+	rate := fs.Float64("rate", 100, "arrival rate in requests per second for constant and poisson load")
+	rampFrom := fs.Float64("ramp-from", 10, "initial arrival rate in requests per second for ramp load")
+	rampTo := fs.Float64("ramp-to", 200, "final arrival rate in requests per second for ramp load")
+	rampDuration := fs.Duration("ramp-duration", 10*time.Second, "time taken by ramp load to reach its final rate")
+	tracePath := fs.String("trace", "", "file of arrival offsets, one duration per line, replayed by trace load")
+	collector := fs.String("collector", "exact", "response time collector: exact, hdr, tdigest, reservoir")
+	reservoirSize := fs.Int("reservoir-size", 1024, "number of samples kept by the reservoir collector")
+	progress := fs.Bool("progress", true, "keep a line on stderr up to date with the run in progress, how many of its requests are done and how long the rest of the sweep should take; only drawn when stderr is a terminal and the tui reporter is off")
+	reporters := fs.String("report", "console,plots", "comma-separated reporters to enable: console, json, prometheus, plots, tui (a live dashboard on stderr)")
+	jsonOut := fs.String("json-out", "results.jsonl", "file the json reporter writes one result per line to; relative to the sweep's directory with -out-dir")
+	outDir := fs.String("out-dir", "", "write artifacts to a new directory per sweep under this one, naming per-run files after the run's parameters and listing everything in index.json (default: fixed names in the working directory, overwritten by each run)")
+	promAddr := fs.String("prom-addr", ":9100", "address the prometheus reporter serves /metrics on")
+	plotOptions := plotFlags(fs)
+	applyUnits := unitFlags(fs)
+	ascii := fs.Bool("ascii", false, "render histograms and percentiles in the terminal instead of writing PNG plots, for headless machines")
+	maxMemory := fs.Uint64("max-memory", 0, "abort a run once the Go runtime holds more than this many bytes of memory (default: no limit)")
+	maxFDs := fs.Int("max-fds", 0, "abort a run once the process has more than this many open file descriptors (default: no limit)")
+	stallTimeout := fs.Duration("stall-timeout", 0, "abandon a run when requests are in flight but none completes for this long, wall-clock (default: wait forever)")
+	maxErrorRate := fs.Float64("max-error-rate", 0, "mark a run failed when more than this fraction of its requests fail (default: never)")
+	faultErrorRate := fs.Float64("fault-error-rate", 0, "probability that a network phase fails once its time is up, failing its request, to model a flaky downstream (default: never)")
+	faultSlowRate := fs.Float64("fault-slow-rate", 0, "probability that a network phase is degraded, taking -fault-slow-delay longer (default: never)")
+	faultSlowDelay := fs.Duration("fault-slow-delay", 100*time.Millisecond, "extra time a degraded network phase takes")
+	retryAttempts := fs.Int("retry-attempts", 1, "calls a network phase makes before giving up on a failing downstream, reporting how many calls that took per phase; 1 never retries. Not counted with -target")
+	retryBackoff := fs.Duration("retry-backoff", 10*time.Millisecond, "wait before the first retry of a network phase, doubling with each retry after it")
+	retryMaxBackoff := fs.Duration("retry-max-backoff", time.Second, "longest wait between retries; 0 lets it grow without bound")
+	retryJitter := fs.Float64("retry-jitter", 0.5, "fraction by which each retry's wait is shortened at random, from 0 for none to 1 for full jitter")
+	hedgeAfter := fs.String("hedge-after", "off", "hedge each downstream call of a network phase, sending a duplicate once it has taken this long and taking the first to succeed, reporting the duplicates sent and the load they add to the downstream; a comma-separated list is swept, off or 0 not hedging, drawn against p99 in hedging.png. Duplicates draw their own time from -network-dist. Not counted with -target")
+	hedgeMax := fs.Int("hedge-max", 1, "duplicates a hedged call sends at most, each -hedge-after after the one before")
+	faultStart := fs.Duration("fault-start", 0, "time into each run at which injected faults begin, with -fault-duration")
+	faultDuration := fs.Duration("fault-duration", 0, "make injected faults a brownout lasting this long from -fault-start, reporting how the requests that started during it fared (default: the whole run)")
+	breakerErrorRate := fs.Float64("breaker-error-rate", 0, "open a circuit breaker in front of the network phases' downstream once this fraction of the last -breaker-window calls failed, failing calls at once while it is open (default: no breaker). Not counted with -target")
+	breakerWindow := fs.Int("breaker-window", 20, "calls the circuit breaker's error rate is measured over")
+	breakerOpen := fs.Duration("breaker-open", time.Second, "time the circuit breaker stays open before letting trial calls through")
+	queueDepth := fs.Int("queue-depth", -1, "shed load: reject requests that arrive while this many already wait for a co-routine, reporting the rejection rate, instead of queueing them without bound. Needs an open-loop -load (default: no limit)")
+	breakerHalfOpen := fs.Int("breaker-half-open-calls", 3, "trial calls a half-open circuit breaker lets through, closing if all succeed")
+	maxTimeoutRate := fs.Float64("max-timeout-rate", 0, "mark a run failed when more than this fraction of its requests miss -request-timeout (default: never)")
+	slowest := fs.Int("slowest", 10, "number of slowest requests per run drawn as a timeline in slowest_requests.html by the plots reporter")
+	budgetAt := fs.Int64("budget", 0, "co-routine count whose runs break each request's latency down into queue wait, CPU, network and harness time, drawn in latency_budget.png (default: none)")
+	percentiles := fs.String("percentiles", "50,95,99", "comma-separated response time percentiles to report, e.g. 50,99,99.9,100 where 100 is the slowest request")
+	outliers := fs.String("outliers", "", "detect outlying response times and report them apart, with percentiles and mean trimmed of them: "+strings.Join(outlierMethods, " (interquartile range) or ")+" (median absolute deviation) (default: off)")
+	steadyCV := fs.Float64("steady-cv", 0.1, "report when each run's throughput settled: the start of the first -steady-window intervals of -throughput-interval whose throughput varied by under this coefficient of variation; 0 disables")
+	steadyWindow := fs.Int("steady-window", 3, "throughput intervals that must vary by under -steady-cv for a run to be in steady state")
+	steadyOnly := fs.Bool("steady-only", false, "report each run's throughput and response times from its steady state on, leaving out the warm-up before it; runs that never settle are reported whole")
+	throughputInterval := fs.Duration("throughput-interval", time.Second, "record the throughput of each interval this long of a run, saved with the results and drawn in throughput_time.png by the plots reporter; 0 disables. Not recorded with -processes")
+	tracePoints := fs.Int("trace-points", 2000, "most requests per run whose response time is drawn against their start time in latency_time.png by the plots reporter; past it, requests are sampled evenly over the run. 0 disables. Not recorded with -processes")
+	phases := fs.Bool("phases", false, "report the distribution of each request's queue wait, CPU, network and other phases, and draw their tails in phases_vs_coroutines.png, to show which dominates tail latency; reads every request's log, adding to the harness's overhead")
+	cpuProfile := fs.Bool("cpu-profile", false, "profile each run's CPU to cpu.pprof, for go tool pprof, and with the plots reporter fold it to cpu.folded and draw it as flamegraph.svg, linked from the -serve report; one set per run with -out-dir, otherwise each run overwrites the last")
+	runtimeTrace := fs.Bool("runtime-trace", false, "write each run's Go runtime trace to trace.out, for go tool trace; one file per run with -out-dir, otherwise each run overwrites it. The run's scheduler statistics are read back from the trace")
+	requestTrace := fs.Bool("request-trace", false, "stream a JSON record of every completed request, with its start, queue wait, latency, time in each phase and the co-routine it ran on, to requests.jsonl as the run goes; one file per run with -out-dir, otherwise each run overwrites it. Not written with -processes")
+	traceSample := fs.Float64("trace-sample", 0, "keep the full phase timeline of this fraction of requests, such as 0.01, chosen at random but the same requests for the same -seed, in traces.jsonl with the request's ID; one file per run with -out-dir. Not written with -processes")
+	traceSlow := fs.Float64("trace-slow", 0, "also keep the full phase timeline of every request slower than this percentile of its run, such as 99, and of every request that timed out, in traces.jsonl")
+	simulate := fs.Bool("simulate", false, "model each run as a discrete-event simulation in virtual time instead of running it, so that sweeps of millions of requests, or of more GOMAXPROCS than the machine has CPUs, finish in seconds; models CPU and sleep-based network time only")
+	simulateRequests := fs.Int("simulate-requests", 0, "requests per simulated run (default: as many as a real run)")
+	lowOverhead := fs.Bool("low-overhead", false, "measure with as little overhead per request as the harness allows, for sub-millisecond workloads: records no request logs or per-request strings (-request-log off, -slowest 0, -trace-points 0) and collects results with -aggregation preallocated")
+	requestLog := fs.String("request-log", "", "what each request records about itself: off (nothing, for the least overhead; disables -slowest, -budget and -phases), info (the start and end of each phase) or debug (also details within phases, such as lock waits) (default: info when something reads the logs, that is -v, -budget, -phases, -request-trace, -trace-sample, -trace-slow or the plots reporter's slowest requests, and off otherwise)")
+	verbose := fs.Bool("v", false, "verbose: also print each run's longest request, phase by phase")
+	quiet := fs.Bool("q", false, "quiet: print no per-run summaries, only a table of every run once the sweep is done")
+	cooldown := fs.Duration("cooldown", 0, "pause this long, wall-clock, between runs so that the machine settles (default: none)")
+	gcBetween := fs.Bool("gc-between-runs", false, "collect garbage and return freed memory to the OS between runs, so that no run inherits the heap of the one before")
+	flushCaches := fs.Bool("flush-caches", false, "evict the CPU caches between runs by writing through a buffer larger than the last-level cache, so that no run starts warm from the one before")
+	repeat := fs.Int("repeat", 1, "number of times to run each configuration; with more than one, sweep plots show the mean and shade the range between the lowest and highest run")
+	tags := Tags{}
+	fs.Var(tags, "tag", "tag every run of the sweep, as key=value or a bare key; may be repeated. Tags are saved with the results and shown in plot legends")
+	var slos SLOs
+	fs.Var(&slos, "slo", "service level objective checked once the sweep is done, as metric<bound[@co-routines], e.g. p99<120ms@15 or throughput>=150; the sweep exits non-zero if any is missed. Metrics: throughput, error-rate, timeout-rate, or a percentile such as p99.9; may be repeated")
+	kneeThreshold := fs.Float64("knee-threshold", 0.1, "once the sweep is done, find where each added co-routine brings under this fraction of the throughput the first ones brought each, print it as the recommended concurrency and mark it on the throughput plot; 0 turns it off")
+	tune := fs.String("tune", "", "instead of running every co-routine count, search their range for the highest whose runs all meet this latency SLO, such as p99<50ms, for each other configuration of the sweep (default: sweep)")
+	tuneStrategy := fs.String("tune-strategy", "binary", "how -tune searches: binary bisects the range; hill doubles from its lowest count while the SLO holds, then bisects")
+	sloFile := fs.String("slo-file", "", "file of SLOs as for -slo, one per line, # starting a comment")
+	note := fs.String("note", "", "free-form annotation saved with every run of the sweep, e.g. \"after removing lock\"")
+	precision := fs.Float64("precision", 0, "instead of a fixed 100 requests, keep each run going until the relative standard error of -precision-percentile falls to this fraction, e.g. 0.02; every response time is kept while the run lasts (default: fixed count)")
+	precisionPercentile := fs.Float64("precision-percentile", 99, "percentile whose precision -precision tracks")
+	maxRequests := fs.Int("max-requests", 100000, "most requests a run with -precision may issue")
+	agents := fs.String("agents", "", "comma-separated host:port of load generator agents, each started with '"+os.Args[0]+" agent', to split each run's co-routines and requests over and merge their results, for load one machine cannot generate")
+	processes := fs.Int("processes", 1, "split each run's co-routines and requests over this many child processes and merge their results, so load generation doesn't share a scheduler and garbage collector; request timelines and latency budgets are not collected")
+	target := fs.String("target", "in-process", "where the simulated service runs: in-process, or in a separate process reached over unix or tcp, so the load generator's garbage collector and scheduler are not shared with it")
+	save := fs.String("save", "", "save every run of the sweep, with its response times and the environment it ran on, to this file, from which the render command draws the reports again; relative to the sweep's directory with -out-dir")
+	saveHistogram := fs.Bool("save-histogram", false, "save response times compressed into histogram buckets, accurate to under 1%, instead of as recorded")
+	historyDBPath := fs.String("history-db", "", "also record every run in this SQLite database, created if missing, for the history command's -db; needs a build with -tags sqlite")
+	influx := fs.String("influx", "", "push each run's summary, throughput series and latency trace to InfluxDB at this write URL, e.g. http://localhost:8086/api/v2/write?org=perf&bucket=bench, authorized by $INFLUX_TOKEN if set")
+	templates := fs.String("template", "", "comma-separated text/template files to render once the sweep is done, each into a file named after it less a .tmpl suffix (html/template for .html), with the sweep's Environment, every run's summary as Runs, named as in the json output, and every run's full result as Results; for report formats of your own such as wiki markup or chat messages")
+	graphite := fs.String("graphite", "", "push each run's summary, throughput series and latency trace to this Graphite plaintext listener as tagged series, e.g. localhost:2003")
+	graphitePrefix := fs.String("graphite-prefix", "", "prefix of the metric names sent to -graphite, e.g. bench.")
+	statsd := fs.String("statsd", "", "emit live request counts, latencies and each run's throughput over StatsD to this UDP address while the sweep runs, e.g. localhost:8125")
+	dogstatsd := fs.Bool("dogstatsd", false, "tag the -statsd metrics with the run's executor, GOMAXPROCS, co-routines and -tag values, as DogStatsD takes them")
+	statsdSampleRate := fs.Float64("statsd-sample-rate", 1, "fraction of requests whose latency is sent to -statsd")
+	statsdPrefix := fs.String("statsd-prefix", "perf.", "prefix of the metric names sent to -statsd")
+	serve := fs.String("serve", "", "serve a web UI charting the sweep live on this address, e.g. :8080, and keep serving the report until interrupted")
+	session := fs.String("session", "", "script of the requests each -load users virtual user makes in order, as comma-separated name[*repeat]:cpu/network steps, e.g. login:5ms/20ms,browse*3:2ms/10ms,checkout:10ms/50ms; reports each step's latency as for -mix")
+	mix := fs.String("mix", "", "traffic mix of weighted request classes, e.g. read=80:2ms/20ms,write=20:10ms/100ms (default: every request is 5ms/55ms)")
+	priority := fs.String("priority", "", "priorities of the -mix classes for -dispatch, e.g. write=3,read=1; higher runs sooner, and classes left out have 1")
+	dispatch := fs.String("dispatch", "", "comma-separated policies to sweep for which request waiting for a co-routine runs next, queueing requests ahead of the executor without holding up the load generator: fifo in arrival order, strict by -priority, starving lower classes while higher ones wait, or weighted, sharing co-routines among the waiting classes in proportion to their priorities. Needs an open-loop -load, and -mix for strict and weighted; each class's latency, queue wait included, is drawn in class_latency_vs_coroutines.png (default: the executor's own queue)")
+	coroutines := fs.String("coroutines", "1..23:2", "co-routine counts to sweep: comma-separated counts, ranges with a step such as 1..64:4, and geometric progressions such as 1..256*2")
+	splitsDist := fs.String("splits-dist", "fixed", "distribution of each request's number of network phases: fixed at -splits, uniform:<min>-<max>, or empirical:<splits>=<weight>,... such as empirical:1=0.6,4=0.3,12=0.1; phases take the network time over the mean number, so requests making more spend longer on the network")
+	splits := fs.String("splits", "5", "number of network phases each request's CPU work is interleaved with, swept as for -coroutines, e.g. 1..9:2 or 1..64*2; with several, splits_effect.png shows their effect on throughput and p99")
+	workTimes := fs.String("work-time", "5ms", "comma-separated CPU times per request to sweep, e.g. 1ms,5ms,20ms")
+	networkTimes := fs.String("network-time", "55ms", "comma-separated network times per request to sweep")
+	rateLimits := fs.String("rate-limit", "none", "comma-separated client-side rate limiters to sweep, holding back requests the load issues: none, token:<rps>[:<burst>] for a token bucket (x/time/rate), leaky:<rps> for a leaky bucket; reports how bursty the requests they let through were. Not measured with -processes")
+	gridSample := fs.Int("grid-sample", 0, "run only this many configurations of the grid of executors, GOMAXPROCS, CPU and network times, splits and co-routine counts, drawn at random with -seed; with more than one CPU time, network time or splits, grid_by_*.png draws throughput and p99 faceted by each (default: the whole grid)")
+	gomaxprocs := fs.String("gomaxprocs", "", "comma-separated GOMAXPROCS values to sweep alongside the coroutine count (default: leave unchanged)")
+	gogc := fs.String("gogc", "", "comma-separated GOGC values to sweep, as the garbage collector's target heap growth in percent or off, set for each run with debug.SetGCPercent (default: leave unchanged)")
+	numaPlacement := fs.String("numa-placement", "", "comma-separated NUMA placements to sweep, pinning each run to the CPUs of one node (confined) or to as many CPUs taken from every node in turn (spread), and comparing their throughput after the sweep; a memory-heavy -cpu-kernel such as sha256 shows the difference best. Needs more than one NUMA node, on Linux (default: leave placement to the OS)")
+	cpuAffinity := fs.String("cpu-affinity", "", "pin the process to a set of CPUs with sched_setaffinity, in cpulist format such as 0-7,16-23, to keep runs off other CCXs or NUMA nodes; with -processes, slash-separated sets such as 0-7/8-15 pin each child process to the next set in turn. GOMAXPROCS is lowered to fit. Linux only (default: no pinning)")
+	memoryLimit := fs.String("memory-limit", "", "comma-separated soft memory limits to sweep, such as 256MiB,1GiB, set for each run with debug.SetMemoryLimit as GOMEMLIMIT would; each of -processes gets the whole limit (default: leave unchanged)")
+	resultBatch := fs.Int("result-batch", 1, "number of results each shard buffers before handing them to the collector in one channel send")
+	aggregation := fs.String("aggregation", "channel", "how results reach the collector: channel (one collecting goroutine), sharded (per-shard collectors merged at the end) or preallocated (a slot per request, lock-free, collected once the run is over; live reporters see nothing until then)")
+	apdex := fs.Duration("apdex", 0, "target response time T to score each run's Apdex against, counting requests within T as satisfied, within 4T as tolerating and the rest, failures and timeouts as frustrated, drawn in apdex_vs_coroutines.png (default: none)")
+	requestTimeout := fs.Duration("request-timeout", 0, "deadline for each request; requests that miss it are counted as timeouts and left out of the latency percentiles, and the time they ran past it reported as wasted work (default: none)")
+	propagateDeadline := fs.String("propagate-deadline", "off", "with -request-timeout, on propagates each request's deadline into its phases, cancelling the ones left once it passes and cutting short the sleep-based network call under way, as a service that checks its context would; off runs them all. Both, as off,on, sweeps the two, drawing the work each wastes in wasted_work_vs_coroutines.png")
+	timeCompression := fs.Float64("time-compression", 1, "run every duration this many times faster and scale results back up; only for sleep-based network time")
+	smoke := fs.Bool("smoke", false, "run drastically reduced sweeps for CI that check the tool end to end in seconds, 20 requests a run of microseconds of work: every executor at 1 and 4 co-routines through every reporter but tui and with the optional in-process analyses and their plots on; then a run each that is profiled, and with the poisson, constant, burst, users and trace loads, among which -template, -ascii and the influx, graphite and statsd exporters, pushing to sinks in the process, are spread. Each writes to a directory of its own under -out-dir, or a new temporary directory without it, and fails unless it leaves its artifacts behind. Flags given as well override what it sets")
+	fs.Parse(args)
+	if *smoke {
+		if pass == nil {
+			stop()
+			return runSmoke(fs, args)
+		}
+		if err := applySmoke(fs, pass, dir); err != nil {
+			return err
+		}
+	}
+
+	if _, err := newCollector(*collector, *reservoirSize, 0, nil); err != nil {
+		return err
+	}
+	pcts, err := parsePercentiles(*percentiles)
+	if err != nil {
+		return err
+	}
+	switch *outliers {
+	case "", "iqr", "mad":
+	default:
+		return fmt.Errorf("unknown outlier method %q, expected %s", *outliers, strings.Join(outlierMethods, " or "))
+	}
+	if *sloFile != "" {
+		if err := loadSLOs(*sloFile, &slos); err != nil {
+			return err
+		}
+	}
+
+	executors := strings.Split(*executor, ",")
+	for _, name := range executors {
+		if e, err := newExecutor(context.Background(), name, 1); err != nil {
+			return err
+		} else {
+			e.Wait()
+		}
+	}
+
+	if *aggregation != "channel" && *aggregation != "sharded" && *aggregation != "preallocated" {
+		return fmt.Errorf("unknown aggregation %q", *aggregation)
+	}
+
+	middlewareSpecs, err := parseMiddleware(*middleware)
+	if err != nil {
+		return err
+	}
+	baselineIterations, baselineDuration, err := parseBaseline(*baseline)
+	if err != nil {
+		return err
+	}
+
+	if *steadyCV < 0 || *steadyWindow < 2 {
+		return errors.New("-steady-cv must not be negative and -steady-window must be at least 2")
+	}
+	if *steadyOnly && (*steadyCV == 0 || *throughputInterval <= 0) {
+		return errors.New("-steady-only needs -steady-cv and -throughput-interval to find the steady state")
+	}
+	if *traceSample < 0 || *traceSample > 1 {
+		return fmt.Errorf("-trace-sample must be a fraction between 0 and 1, got %g", *traceSample)
+	}
+	if *traceSlow < 0 || *traceSlow >= 100 {
+		return fmt.Errorf("-trace-slow must be a percentile below 100, got %g", *traceSlow)
+	}
+	if *lowOverhead {
+		if *requestTrace || *traceSample > 0 || *traceSlow > 0 || *budgetAt > 0 || *phases {
+			return errors.New("-low-overhead records nothing per request; it cannot be combined with -request-trace, -trace-sample, -trace-slow, -budget or -phases")
+		}
+		*requestLog, *slowest, *tracePoints, *aggregation = "off", 0, 0, "preallocated"
+	}
+	if *verbose && *quiet {
+		return errors.New("-v and -q cannot be combined")
+	}
+	if *requestLog == "" {
+		// Only what reads the logs pays for them.
+		*requestLog = "off"
+		if *verbose || *budgetAt > 0 || *phases || *requestTrace || *traceSample > 0 || *traceSlow > 0 || (*slowest > 0 && strings.Contains(*reporters, "plots") && !*ascii) {
+			*requestLog = "info"
+		}
+	}
+	logLevel, err := parseLogLevel(*requestLog)
+	if err != nil {
+		return err
+	}
+	if logLevel == LogOff && (*budgetAt > 0 || *phases) {
+		return errors.New("-budget and -phases need each request's log; -request-log off records none")
+	}
+	faults := Faults{ErrorRate: *faultErrorRate, SlowRate: *faultSlowRate, SlowDelay: *faultSlowDelay, Start: *faultStart, Duration: *faultDuration}
+	if err := faults.validate(); err != nil {
+		return err
+	}
+	retry := RetryPolicy{MaxAttempts: *retryAttempts, Backoff: *retryBackoff, MaxBackoff: *retryMaxBackoff, Jitter: *retryJitter}
+	if err := retry.validate(); err != nil {
+		return err
+	}
+	hedge := HedgePolicy{Max: *hedgeMax}
+	if err := hedge.validate(); err != nil {
+		return err
+	}
+	breaker := BreakerPolicy{ErrorRate: *breakerErrorRate, Window: *breakerWindow, OpenFor: *breakerOpen, HalfOpenCalls: *breakerHalfOpen}
+	if err := breaker.validate(); err != nil {
+		return err
+	}
+	if cpuKernelNamed(*cpuKernel) == nil {
+		return fmt.Errorf("unknown CPU kernel %q; want one of %s", *cpuKernel, cpuKernelNames())
+	}
+	cpuDistribution, err := parseDistribution(*cpuDist)
+	if err != nil {
+		return err
+	}
+	networkDistribution, err := parseDistribution(*networkDist)
+	if err != nil {
+		return err
+	}
+	splitsDistribution, err := parseSplitsDist(*splitsDist)
+	if err != nil {
+		return err
+	}
+
+	thinkDistribution, err := parseDistribution(*thinkDist)
+	if err != nil {
+		return err
+	}
+	loadSpec := LoadSpec{
+		Kind:         *load,
+		Rate:         *rate,
+		RampFrom:     *rampFrom,
+		RampTo:       *rampTo,
+		RampDuration: *rampDuration,
+	}
+	if loadSpec.Kind == "burst" {
+		loadSpec.BurstSize, loadSpec.BurstInterval, loadSpec.BurstLength = *burstSize, *burstInterval, *burstLength
+	}
+	if loadSpec.Kind == "users" {
+		loadSpec.ThinkTime, loadSpec.ThinkDist = *thinkTime, thinkDistribution
+	}
+	if *tracePath != "" {
+		trace, err := loadTrace(*tracePath)
+		if err != nil {
+			return err
+		}
+		loadSpec.Trace = trace
+	}
+	if _, err := newLoadGenerator(loadSpec, realClock{}, nil); err != nil {
+		return err
+	}
+	shedLoad := *queueDepth >= 0
+	if shedLoad && (loadSpec.Kind == "" || loadSpec.Kind == "closed") {
+		return errors.New("-queue-depth needs an open-loop -load; a closed loop never queues more requests than it has co-routines")
+	}
+	if !shedLoad {
+		*queueDepth = 0
+	}
+
+	// A child process only measures; the parent reports.
+	childSpec := os.Getenv(childRunEnv)
+	if childSpec != "" || os.Getenv(targetEnv) != "" {
+		*reporters, *outDir, *serve, *save, *historyDBPath, *influx, *graphite, *statsd = "", "", "", "", "", "", "", ""
+		*baselineFile = ""
+		*requestTrace, *traceSample, *traceSlow = false, 0, 0
+	}
+	reporterNames := strings.Split(*reporters, ",")
+	if *ascii {
+		var names []string
+		for _, name := range reporterNames {
+			if strings.TrimSpace(name) != "plots" {
+				names = append(names, name)
+			}
+		}
+		reporterNames = names
+	}
+	plots, err := plotOptions()
+	if err != nil {
+		return err
+	}
+	if err := applyUnits(); err != nil {
+		return err
+	}
+	if *kneeThreshold < 0 || *kneeThreshold >= 1 {
+		return fmt.Errorf("-knee-threshold must be at least 0 and below 1, got %g", *kneeThreshold)
+	}
+	plots.KneeThreshold = *kneeThreshold
+	jsonPath := *jsonOut
+	if *outDir != "" {
+		if plots.Out, err = newArtifactStore(*outDir, time.Now()); err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := plots.Out.Close(); err == nil {
+				err = closeErr
+			}
+		}()
+		if !filepath.IsAbs(jsonPath) && strings.Contains(*reporters, "json") {
+			jsonPath = plots.Out.path(jsonPath, nil)
+		}
+		fmt.Printf("Writing artifacts to %s\n", plots.Out.dir)
+	}
+	reporter, err := newReporter(reporterNames, jsonPath, *promAddr, plots)
+	if err != nil {
+		return err
+	}
+	for _, r := range reporter {
+		if c, ok := r.(*consoleReporter); ok {
+			c.ascii = *ascii
+			switch {
+			case *verbose:
+				c.verbosity = verbosityVerbose
+			case *quiet:
+				c.verbosity = verbosityQuiet
+			}
+		}
+	}
+	defer func() {
+		if closeErr := reporter.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if *save != "" {
+		savePath := *save
+		if plots.Out != nil && !filepath.IsAbs(savePath) {
+			savePath = plots.Out.path(savePath, nil)
+		}
+		reporter = append(reporter, newSaveReporter(savePath, *saveHistogram, args))
+	}
+	if *historyDBPath != "" {
+		db, err := openHistoryDB(*historyDBPath)
+		if err != nil {
+			return err
+		}
+		reporter = append(reporter, &historyDBReporter{db: db})
+	}
+	if *influx != "" {
+		reporter = append(reporter, newInfluxReporter(*influx))
+	}
+	if *graphite != "" {
+		reporter = append(reporter, &graphiteReporter{addr: *graphite, prefix: *graphitePrefix})
+	}
+	if *statsd != "" {
+		r, err := newStatsdReporter(*statsd, *statsdPrefix, *dogstatsd, *statsdSampleRate)
+		if err != nil {
+			return err
+		}
+		reporter = append(reporter, r)
+	}
+	if *templates != "" {
+		r, err := newTemplateReporter(strings.Split(*templates, ","), currentEnvironment(args), plots.Out)
+		if err != nil {
+			return err
+		}
+		reporter = append(reporter, r)
+	}
+	recorder := &resultRecorder{}
+	if len(slos) > 0 || *kneeThreshold > 0 || *numaPlacement != "" {
+		reporter = append(reporter, recorder)
+	}
+	var web *webReporter
+	if *serve != "" {
+		if web, err = newWebReporter(*serve); err != nil {
+			return err
+		}
+		reporter = append(reporter, web)
+	}
+
+	readiness, _ := runDoctor()
+	if !*quiet {
+		fmt.Printf("Readiness: %d/100 (see %s doctor)\n", readiness, os.Args[0])
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	if !*quiet {
+		fmt.Printf("Seed: %d\n", *seed)
+	}
+
+	var agentAddrs []string
+	for _, a := range strings.Split(*agents, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			agentAddrs = append(agentAddrs, a)
+		}
+	}
+	base := RunConfig{
+		WorkTime:            time.Duration(5) * time.Millisecond,
+		NetworkTime:         time.Duration(55) * time.Millisecond,
+		Splits:              5,
+		CPUKernel:           *cpuKernel,
+		CPUDist:             cpuDistribution,
+		SplitsDist:          splitsDistribution,
+		NetworkDist:         networkDistribution,
+		Load:                loadSpec,
+		Middleware:          middlewareSpecs,
+		Collector:           *collector,
+		ReservoirSize:       *reservoirSize,
+		BaselineIterations:  baselineIterations,
+		BaselineDuration:    baselineDuration,
+		Iterations:          100,
+		Seed:                *seed,
+		Readiness:           readiness,
+		ResultBatch:         *resultBatch,
+		Aggregation:         *aggregation,
+		RequestTimeout:      *requestTimeout,
+		CompensateTimer:     *compensateTimer,
+		Apdex:               *apdex,
+		Ceilings:            ResourceCeilings{MaxMemory: *maxMemory, MaxFDs: *maxFDs},
+		StallTimeout:        *stallTimeout,
+		MaxErrorRate:        *maxErrorRate,
+		Faults:              faults,
+		Retry:               retry,
+		Breaker:             breaker,
+		Hedge:               hedge,
+		ShedLoad:            shedLoad,
+		QueueDepth:          *queueDepth,
+		MaxTimeoutRate:      *maxTimeoutRate,
+		SlowestRequests:     *slowest,
+		LogLevel:            logLevel,
+		LowOverhead:         *lowOverhead,
+		Simulate:            *simulate,
+		RequestTrace:        *requestTrace,
+		TraceSample:         *traceSample,
+		TraceSlow:           *traceSlow,
+		RuntimeTrace:        *runtimeTrace,
+		CPUProfile:          *cpuProfile,
+		artifacts:           plots.Out,
+		BudgetAt:            *budgetAt,
+		Tags:                tags,
+		Note:                *note,
+		Commit:              gitCommit(),
+		Processes:           *processes,
+		Agents:              agentAddrs,
+		Percentiles:         pcts,
+		Outliers:            *outliers,
+		ThroughputInterval:  *throughputInterval,
+		SteadyCV:            *steadyCV,
+		SteadyWindow:        *steadyWindow,
+		SteadyOnly:          *steadyOnly,
+		TracePoints:         *tracePoints,
+		Phases:              *phases,
+		Precision:           *precision,
+		PrecisionPercentile: *precisionPercentile,
+		MaxIterations:       *maxRequests,
+		childArgs:           args,
+	}
+	if *smoke {
+		base.Iterations = smokeRequests
+	}
+	if *cpuAffinity != "" {
+		if len(base.Agents) > 0 {
+			return errors.New("-cpu-affinity pins this machine's CPUs and cannot be combined with -agents")
+		}
+		if base.CPUSets, err = parseCPUAffinity(*cpuAffinity); err != nil {
+			return err
+		}
+		if len(base.CPUSets) > 1 && *processes < 2 {
+			return errors.New("-cpu-affinity with several CPU sets needs -processes to pin them to")
+		}
+		// A child pins itself to its own set.
+		if childSpec == "" {
+			if err := pinProcess(unionCPUs(base.CPUSets)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(base.Agents) > 0 && (*processes > 1 || *simulate) {
+		return errors.New("-agents cannot be combined with -processes or -simulate")
+	}
+	if len(base.Agents) > 0 && childSpec == "" {
+		if os.Getenv(agentTokenEnv) == "" {
+			return fmt.Errorf("-agents needs $%s set to the token the agents were started with", agentTokenEnv)
+		}
+		if base.childArgs, err = agentArgs(fs, args); err != nil {
+			return err
+		}
+	}
+	if *precision > 0 {
+		if *processes > 1 || len(base.Agents) > 0 {
+			return errors.New("-precision cannot be combined with -processes, whose children each run a fixed share of requests")
+		}
+		if *precisionPercentile <= 0 || *precisionPercentile >= 100 || *maxRequests < base.Iterations {
+			return fmt.Errorf("-precision-percentile must be between 0 and 100 and -max-requests at least %d", base.Iterations)
+		}
+	}
+	if *maxFDs > 0 && openFDs() < 0 {
+		return errors.New("-max-fds needs /proc/self/fd, which this system does not have")
+	}
+
+	if *diskEnabled {
+		pattern := *diskPattern
+		if pattern == "" {
+			pattern = "sequential-read"
+			switch {
+			case *diskRandom && *diskWrite:
+				pattern = "random-write"
+			case *diskRandom:
+				pattern = "random-read"
+			case *diskWrite:
+				pattern = "sequential-write"
+			}
+		} else if *diskRandom || *diskWrite {
+			return errors.New("-disk-pattern replaces -disk-random and -disk-write")
+		}
+		disk := &DiskWorkload{
+			Dir:          *diskDir,
+			FileSize:     *diskFileSize,
+			BlockSize:    *diskBlockSize,
+			Blocks:       *diskBlocks,
+			Pattern:      pattern,
+			ReadFraction: *diskReadFraction,
+			Fsync:        *diskFsync,
+		}
+		if err := disk.Open(); err != nil {
+			return err
+		}
+		defer disk.Close()
+		base.Disk = disk
+	}
+
+	if *dnsServer != "" {
+		dns := &DNSWorkload{Server: *dnsServer, Resolver: *dnsResolver, Name: *dnsName, Delay: *dnsDelay}
+		if err := dns.Start(); err != nil {
+			return fmt.Errorf("-dns: %w", err)
+		}
+		defer dns.Close()
+		base.DNS = dns
+		fmt.Printf("DNS: %v\n", dns)
+	}
+
+	if *sqlDSN != "" {
+		args, err := parseSQLArgs(*sqlArgs)
+		if err != nil {
+			return err
+		}
+		sql := &SQLWorkload{Driver: *sqlDriver, DSN: *sqlDSN, Query: *sqlQuery, Args: args, MaxIdle: *sqlMaxIdle, Lifetime: *sqlLifetime}
+		if err := sql.Open(); err != nil {
+			return fmt.Errorf("-sql: %w", err)
+		}
+		defer sql.Close()
+		base.SQL = sql
+		fmt.Printf("SQL: %v\n", sql)
+	} else if flagGiven(fs, "sql-max-open") {
+		return errors.New("-sql-max-open needs -sql")
+	}
+
+	if *redisAddr != "" {
+		if *redisGets < 0 || *redisGets > 1 {
+			return fmt.Errorf("-redis-get-fraction must be between 0 and 1, got %v", *redisGets)
+		}
+		redis := &RedisWorkload{Addr: *redisAddr, Conns: *redisConns, Commands: *redisCommands, Pipeline: *redisPipeline,
+			Keys: *redisKeys, ValueSize: *redisValueSize, GetFraction: *redisGets}
+		if err := redis.Start(); err != nil {
+			return fmt.Errorf("-redis: %w", err)
+		}
+		defer redis.Close()
+		base.Redis = redis
+		fmt.Printf("Redis: %v\n", redis)
+	}
+
+	if *lockEnabled {
+		base.Lock = &LockWorkload{
+			CriticalSection: *lockCriticalSection,
+			RWMutex:         *lockRW,
+			ReadFraction:    *lockReadFraction,
+		}
+	}
+
+	if *externalURL != "" && *network != "sleep" {
+		return errors.New("-url spends the network time on requests of its own; it cannot be combined with -network")
+	}
+	switch *network {
+	case "sleep":
+		if *externalURL != "" {
+			backend := &ExternalHTTPBackend{URL: *externalURL, Method: *urlMethod, Headers: urlHeaders, Body: *urlBody,
+				MaxIdleConns: *httpMaxIdleConns}
+			if err := backend.Start(); err != nil {
+				return err
+			}
+			defer backend.Close()
+			base.Network = backend
+		}
+	case "http":
+		backend := &HTTPBackend{MaxIdleConns: *httpMaxIdleConns}
+		if err := backend.Start(); err != nil {
+			return err
+		}
+		defer backend.Close()
+		base.Network = backend
+	case "tcp":
+		backend := &TCPBackend{MessageSize: *tcpMessageSize, PoolSize: *tcpPoolSize}
+		if err := backend.Start(); err != nil {
+			return err
+		}
+		defer backend.Close()
+		base.Network = backend
+	case "tls":
+		backend := &TLSBackend{Resume: *tlsResume}
+		if err := backend.Start(); err != nil {
+			return err
+		}
+		defer backend.Close()
+		base.Network = backend
+	case "websocket":
+		backend := &WebSocketBackend{Conns: *wsConns, MessageSize: *wsMessageSize}
+		if err := backend.Start(); err != nil {
+			return err
+		}
+		defer backend.Close()
+		base.Network = backend
+	case "grpc":
+		backend := &GRPCBackend{PayloadSize: *grpcPayloadSize}
+		if err := backend.Start(); err != nil {
+			return err
+		}
+		defer backend.Close()
+		base.Network = backend
+	default:
+		return fmt.Errorf("unknown network %q", *network)
+	}
+
+	if *mix != "" {
+		trafficMix, err := parseTrafficMix(*mix)
+		if err != nil {
+			return err
+		}
+		if err := trafficMix.applyPriorities(*priority); err != nil {
+			return err
+		}
+		base.Mix = trafficMix
+		base.WorkTime, base.NetworkTime = trafficMix.mean()
+	} else if *priority != "" {
+		return errors.New("-priority needs -mix")
+	}
+	var dispatches []string
+	for _, policy := range strings.Split(*dispatch, ",") {
+		if *dispatch == "" {
+			break
+		}
+		policy = strings.TrimSpace(policy)
+		if err := validDispatch(policy); err != nil {
+			return err
+		}
+		if policy != dispatchFIFO && base.Mix == nil {
+			return errors.New("-dispatch " + policy + " picks requests by their -mix class")
+		}
+		if base.Load.Kind == "" || base.Load.Kind == "closed" || base.Load.Kind == "users" {
+			return errors.New("-dispatch needs an open-loop -load; a closed loop never has requests waiting to pick from")
+		}
+		if base.ShedLoad {
+			return errors.New("-dispatch cannot be combined with -queue-depth")
+		}
+		dispatches = append(dispatches, policy)
+	}
+	if *session != "" {
+		if base.Mix != nil {
+			return errors.New("-session makes its own traffic mix; it cannot be combined with -mix")
+		}
+		if base.Load.Kind != "users" {
+			return errors.New("-session scripts virtual users; it needs -load users")
+		}
+		script, err := parseSessionScript(*session)
+		if err != nil {
+			return err
+		}
+		base.Session = script
+		base.Mix = script.mix()
+		base.WorkTime, base.NetworkTime = base.Mix.mean()
+	}
+
+	var execWorkTime time.Duration
+	if *execCommand != "" {
+		if base.Mix != nil || *pipelineStages > 0 || *mqBroker != "" || base.Network != nil || base.Disk != nil || base.Lock != nil || base.DNS != nil || base.SQL != nil || base.Redis != nil {
+			return errors.New("-exec replaces the simulated work; it cannot be combined with a traffic mix, the pipeline, -mq, -network, -url, -disk, -lock, -dns, -sql or -redis")
+		}
+		base.Exec = &ExecWorkload{Command: *execCommand, Shell: *execShell}
+		if execWorkTime, err = base.Exec.Calibrate(); err != nil {
+			return err
+		}
+		fmt.Printf("Command: %v takes %v on an idle machine\n", base.Exec, execWorkTime)
+	}
+	if *plugin != "" {
+		if base.Exec != nil || base.Mix != nil || *pipelineStages > 0 || *mqBroker != "" || base.Network != nil || base.Disk != nil || base.Lock != nil || base.DNS != nil || base.SQL != nil || base.Redis != nil {
+			return errors.New("-plugin replaces the simulated work; it cannot be combined with -exec, a traffic mix, the pipeline, -mq, -network, -url, -disk, -lock, -dns, -sql or -redis")
+		}
+		if base.Plugin, err = startPlugin(*plugin); err != nil {
+			return err
+		}
+		defer base.Plugin.Close()
+		// The plugin's requests take the time they take, measured as -exec's commands are.
+		if execWorkTime, err = base.Plugin.Calibrate(); err != nil {
+			return err
+		}
+		fmt.Printf("Plugin: %v takes %v on an idle machine\n", base.Plugin, execWorkTime)
+	}
+
+	if *mqBroker != "" {
+		if base.Mix != nil || *pipelineStages > 0 {
+			return errors.New("-mq cannot be combined with a traffic mix or the pipeline")
+		}
+		if *target != "in-process" {
+			return errors.New("-mq consumers run in the load generator's process; it cannot be combined with -target")
+		}
+		base.MQ = &MQWorkload{Broker: *mqBroker, Capacity: *mqCapacity, MessageSize: *mqMessageSize}
+	} else if flagGiven(fs, "mq-consumers") {
+		return errors.New("-mq-consumers needs -mq")
+	}
+
+	if *pipelineStages > 0 {
+		if base.Mix != nil {
+			return errors.New("a traffic mix cannot be combined with the pipeline workload")
+		}
+		base.Pipeline = &PipelineWorkload{
+			Stages:          *pipelineStages,
+			Buffer:          *pipelineBuffer,
+			WorkersPerStage: *pipelineWorkers,
+		}
+	}
+
+	if *timeCompression != 1 {
+		if *timeCompression < 1 {
+			return fmt.Errorf("time compression must be at least 1, got %v", *timeCompression)
+		}
+		if base.Network != nil || base.Disk != nil || base.DNS != nil || base.SQL != nil || base.Redis != nil {
+			return errors.New("time compression only works when all network time is simulated sleep and there is no disk work, DNS lookup, SQL query or Redis command")
+		}
+		fmt.Fprintf(os.Stderr, "Warning: time compressed %vx. Scheduler, timer and CPU overheads are magnified by the same "+
+			"factor, so results are only good for exploration; rerun uncompressed before drawing conclusions.\n", *timeCompression)
+		base.Clock = newScaledClock(*timeCompression)
+	}
+	if base.baselines, err = newBaselineCache(*baselineFile); err != nil {
+		return fmt.Errorf("-baseline-file: %w", err)
+	}
+
+	if *simulate {
+		if *target != "in-process" {
+			return errors.New("-simulate models the service in this process; it cannot be combined with -target")
+		}
+		if what := base.simulationUnsupported(); what != "" {
+			return fmt.Errorf("-simulate does not model %s", what)
+		}
+		if *simulateRequests > 0 {
+			base.Iterations = *simulateRequests
+		}
+	} else if *simulateRequests > 0 {
+		return errors.New("-simulate-requests needs -simulate")
+	}
+	for _, name := range executors {
+		if (name == "aimd" || name == "gradient") && (*simulate || base.Clock != nil) {
+			return fmt.Errorf("the %s executor adapts to the latencies it measures on the wall clock; it cannot be simulated or time compressed", name)
+		}
+	}
+
+	sweep := Sweep{Executors: executors, GOMAXPROCS: []int{0}, Sample: *gridSample, Repeat: *repeat,
+		Isolation: Isolation{Cooldown: *cooldown, GC: *gcBetween, FlushCaches: *flushCaches}}
+	if sweep.Coroutines, err = parseSweepValues(*coroutines, "co-routine count", 1); err != nil {
+		return err
+	}
+	splitValues, err := parseSweepValues(*splits, "splits", 1)
+	if err != nil {
+		return err
+	}
+	for _, n := range splitValues {
+		sweep.Splits = append(sweep.Splits, int(n))
+	}
+	if !base.SplitsDist.fixed() {
+		// Requests draw their own; the run's count stands for them where one number is needed, as in file names.
+		if len(sweep.Splits) > 1 {
+			return errors.New("-splits-dist cannot be combined with several -splits values")
+		}
+		sweep.Splits = []int{int(math.Max(1, math.Round(base.SplitsDist.Mean(0))))}
+	}
+	if sweep.WorkTimes, err = parseDurations(*workTimes, "CPU time"); err != nil {
+		return err
+	}
+	if sweep.NetworkTimes, err = parseDurations(*networkTimes, "network time"); err != nil {
+		return err
+	}
+	for _, s := range strings.Split(*rateLimits, ",") {
+		l, err := parseRateLimit(s)
+		if err != nil {
+			return err
+		}
+		sweep.RateLimits = append(sweep.RateLimits, l)
+	}
+	maxOpenValues, err := parseSweepValues(*sqlMaxOpen, "SQL open connection cap", 0)
+	if err != nil {
+		return err
+	}
+	for _, n := range maxOpenValues {
+		sweep.SQLMaxOpen = append(sweep.SQLMaxOpen, int(n))
+	}
+	if *fanOut < 0 {
+		return errors.New("-fanout must not be negative")
+	}
+	if *fanOutLimit != "" {
+		if *fanOut < 2 {
+			return errors.New("-fanout-limit needs -fanout of 2 or more")
+		}
+		limitValues, err := parseSweepValues(*fanOutLimit, "fan-out limit", 1)
+		if err != nil {
+			return err
+		}
+		for _, n := range limitValues {
+			sweep.FanOutLimits = append(sweep.FanOutLimits, int(n))
+		}
+	}
+	base.FanOut = *fanOut
+	if sweep.HedgeAfters, err = parseHedgeDelays(*hedgeAfter); err != nil {
+		return err
+	}
+	sweep.Dispatch = dispatches
+	if sweep.Propagation, err = parsePropagation(*propagateDeadline); err != nil {
+		return err
+	}
+	for _, on := range sweep.Propagation {
+		if on && *requestTimeout <= 0 {
+			return errors.New("-propagate-deadline needs -request-timeout")
+		}
+	}
+	if base.MQ != nil {
+		consumerValues, err := parseSweepValues(*mqConsumers, "consumer count", 1)
+		if err != nil {
+			return err
+		}
+		for _, n := range consumerValues {
+			sweep.MQConsumers = append(sweep.MQConsumers, int(n))
+		}
+	}
+	if base.Exec != nil || base.Plugin != nil {
+		// The command takes the time it takes.
+		if len(sweep.WorkTimes) > 1 || len(sweep.NetworkTimes) > 1 {
+			return errors.New("-exec and -plugin cannot be combined with several -work-time or -network-time values")
+		}
+		sweep.WorkTimes, sweep.NetworkTimes = []time.Duration{execWorkTime}, []time.Duration{0}
+	}
+	if base.Mix != nil {
+		// The mix's classes set their own times.
+		if len(sweep.WorkTimes) > 1 || len(sweep.NetworkTimes) > 1 {
+			return errors.New("a traffic mix cannot be combined with several -work-time or -network-time values")
+		}
+		sweep.WorkTimes, sweep.NetworkTimes = nil, nil
+	}
+	if *gomaxprocs != "" {
+		if sweep.GOMAXPROCS, err = parseGOMAXPROCS(*gomaxprocs); err != nil {
+			return err
+		}
+	}
+	if (*gogc != "" || *memoryLimit != "") && *simulate {
+		return errors.New("-simulate does not model the garbage collector; it cannot be combined with -gogc or -memory-limit")
+	}
+	if *numaPlacement != "" {
+		if *simulate || *processes > 1 || len(base.Agents) > 0 {
+			return errors.New("-numa-placement pins this process's CPUs and cannot be combined with -simulate, -processes or -agents")
+		}
+		if sweep.Placements, err = parseNUMAPlacements(*numaPlacement); err != nil {
+			return err
+		}
+	}
+	if *gogc != "" {
+		if sweep.GOGC, err = parseGOGC(*gogc); err != nil {
+			return err
+		}
+	}
+	if *memoryLimit != "" {
+		if !memoryLimitSupported {
+			return errors.New("-memory-limit needs a build with Go 1.19 or later")
+		}
+		if sweep.MemoryLimits, err = parseMemoryLimits(*memoryLimit); err != nil {
+			return err
+		}
+	}
+	if *gridSample < 0 {
+		return fmt.Errorf("-grid-sample must not be negative, got %d", *gridSample)
+	}
+	if *tune != "" {
+		if *gridSample > 0 {
+			return errors.New("-tune searches the co-routine counts of every configuration and cannot be combined with -grid-sample")
+		}
+		if sweep.Tune, err = parseTuner(*tune, *tuneStrategy); err != nil {
+			return err
+		}
+	}
+	dims := sweptDims(sweep.configs(base))
+	if plots.Out != nil {
+		plots.Out.dims = dims
+	}
+	for _, r := range reporter {
+		if p, ok := r.(*prometheusReporter); ok {
+			p.dims = dims
+		}
+	}
+	if *progress && childSpec == "" && os.Getenv(targetEnv) == "" && !strings.Contains(*reporters, "tui") && isTerminal(os.Stderr) {
+		// First, so that the line is cleared before the other reporters print a run's results.
+		reporter = append(multiReporter{newProgressReporter(os.Stderr, sweep.configs(base), sweep.Repeat, sweep.Tune != nil)}, reporter...)
+	}
+
+	calibration := timerCalibration()
+	if !*quiet {
+		fmt.Printf("Environment: %v\n", currentHost())
+		fmt.Printf("Timer calibration: %v", calibration)
+		if base.CompensateTimer {
+			fmt.Printf("; network sleeps shortened to match")
+		}
+		fmt.Println()
+		fmt.Printf("CPU work: %v\n", cpuKernelNamed(base.CPUKernel))
+	}
+	checkTimerResolution(worstTimerCase(sweep.configs(base)), calibration.granularity())
+
+	if spec := os.Getenv(targetEnv); spec != "" {
+		return serveTarget(ctx, base, spec)
+	}
+	if *target != "in-process" {
+		t, err := startRemoteTarget(*target, args)
+		if err != nil {
+			return err
+		}
+		defer t.Close()
+		base.Target = t
+	}
+	if childSpec != "" {
+		return runChild(ctx, base, childSpec)
+	}
+	if err := throughputBenchmark(ctx, base, sweep, reporter); err != nil {
+		return err
+	}
+	reportKnees(recorder.results, *kneeThreshold)
+	reportPlacements(recorder.results)
+	if failed := checkSLOs(slos, recorder.results); failed > 0 {
+		err = fmt.Errorf("%d SLO checks failed", failed)
+	}
+	if web == nil {
+		return err
+	}
+	web.finish()
+	fmt.Println("Sweep complete; the report stays available in the browser until interrupted.")
+	<-ctx.Done()
+	return err
 }
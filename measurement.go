@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MeasurementOverhead estimates what measuring a run cost each of its requests, in the request's own time: the clock
+// reads that timestamp it, one per log event on top of the harness's own, the events its log recorded, and handing
+// its result over. Clock reads and events are costed by calibration rather than timed in place, which would cost as
+// much again; delivery is timed, as HarnessOverhead has it.
+type MeasurementOverhead struct {
+	ClockReads float64       // per request
+	ClockRead  time.Duration // cost of one
+	Events     float64       // log events per request
+	Event      time.Duration // cost of recording one at the run's log level
+	Deliver    time.Duration // per request
+}
+
+// harnessClockReads are the clock reads the harness makes around every request: when it was issued, when it
+// started and when it ended.
+const harnessClockReads = 3
+
+// PerRequest is the estimated overhead each request paid.
+func (m MeasurementOverhead) PerRequest() time.Duration {
+	return time.Duration(m.ClockReads*float64(m.ClockRead)+m.Events*float64(m.Event)) + m.Deliver
+}
+
+func newMeasurementOverhead(cfg RunConfig, rc *runCollection, completed int, harness HarnessOverhead) MeasurementOverhead {
+	costs := calibrateMeasurement(cfg.LogLevel)
+	m := MeasurementOverhead{ClockRead: costs.clockRead, Event: costs.event, Deliver: harness.DeliverPerSample}
+	if completed > 0 {
+		m.Events = float64(rc.events) / float64(completed)
+		m.ClockReads = harnessClockReads + m.Events
+	}
+	return m
+}
+
+type measurementCosts struct {
+	clockRead time.Duration
+	event     time.Duration
+}
+
+var (
+	calibrationMu sync.Mutex
+	calibrations  = map[LogLevel]measurementCosts{}
+)
+
+// calibrateMeasurement times clock reads and log events once per log level and process.
+func calibrateMeasurement(level LogLevel) measurementCosts {
+	calibrationMu.Lock()
+	defer calibrationMu.Unlock()
+	if costs, ok := calibrations[level]; ok {
+		return costs
+	}
+	const n = 20000
+	var costs measurementCosts
+	clock := realClock{}
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		clock.Now()
+	}
+	costs.clockRead = time.Since(start) / n
+	if level > LogOff {
+		// A phase as the workload records it: its start and end, and at debug level a note within it. The clock
+		// reads are left out, being costed separately.
+		at := time.Now()
+		var events int
+		start = time.Now()
+		for i := 0; i < n; i++ {
+			log := newRequestLog(i, level)
+			log.Begin(at, "network", "network time", time.Millisecond)
+			if log.Enabled(LogDebug) {
+				log.Debugf(at, "waited %v for the lock", time.Millisecond)
+			}
+			log.End(at)
+			events += len(log.Events)
+		}
+		costs.event = time.Since(start) / time.Duration(events)
+	}
+	calibrations[level] = costs
+	return costs
+}
+
+func outputMeasurementOverhead(result BenchmarkResult) {
+	m := result.Measurement
+	perRequest := m.PerRequest()
+	if perRequest <= 0 {
+		return
+	}
+	fmt.Printf("\tMeasurement overhead: ~%s per request (%s clock reads at %s, ", formatDuration(perRequest),
+		formatFloat(m.ClockReads, 1), formatDuration(m.ClockRead))
+	if m.Events > 0 {
+		fmt.Printf("%s log events at %s, ", formatFloat(m.Events, 1), formatDuration(m.Event))
+	}
+	fmt.Printf("%s delivering the result)", formatDuration(m.Deliver))
+	if mean := result.Latency.Mean; mean > 0 {
+		share := float64(perRequest) / float64(time.Millisecond) / mean
+		fmt.Printf(", %s of the mean response time", formatPercent(share))
+		if share > 0.01 && !result.Config.LowOverhead {
+			fmt.Print("; try -low-overhead")
+		}
+	}
+	fmt.Println()
+}
+
+type jsonMeasurement struct {
+	ClockReads   float64 `json:"clock_reads_per_request"`
+	ClockReadNs  int64   `json:"clock_read_ns"`
+	Events       float64 `json:"events_per_request"`
+	EventNs      int64   `json:"event_ns"`
+	DeliverNs    int64   `json:"deliver_ns"`
+	PerRequestNs int64   `json:"per_request_ns"`
+}
+
+func newJsonMeasurement(m MeasurementOverhead) *jsonMeasurement {
+	if m.PerRequest() <= 0 {
+		return nil
+	}
+	return &jsonMeasurement{
+		ClockReads:   m.ClockReads,
+		ClockReadNs:  int64(m.ClockRead),
+		Events:       m.Events,
+		EventNs:      int64(m.Event),
+		DeliverNs:    int64(m.Deliver),
+		PerRequestNs: int64(m.PerRequest()),
+	}
+}
+
+func (j *jsonMeasurement) overhead() MeasurementOverhead {
+	if j == nil {
+		return MeasurementOverhead{}
+	}
+	return MeasurementOverhead{ClockReads: j.ClockReads, ClockRead: time.Duration(j.ClockReadNs), Events: j.Events,
+		Event: time.Duration(j.EventNs), Deliver: time.Duration(j.DeliverNs)}
+}
@@ -0,0 +1,14 @@
+//go:build go1.19
+// +build go1.19
+
+package main
+
+import "runtime/debug"
+
+// memoryLimitSupported is whether the Go release the tool was built with has a soft memory limit.
+const memoryLimitSupported = true
+
+// setMemoryLimit sets the runtime's soft memory limit and returns the previous one.
+func setMemoryLimit(bytes int64) int64 {
+	return debug.SetMemoryLimit(bytes)
+}
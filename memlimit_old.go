@@ -0,0 +1,11 @@
+//go:build !go1.19
+// +build !go1.19
+
+package main
+
+// Go releases before 1.19 have no soft memory limit; -memory-limit is rejected before a run could need one.
+const memoryLimitSupported = false
+
+func setMemoryLimit(bytes int64) int64 {
+	return -1
+}
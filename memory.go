@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// MemSample is the process's memory at one point during a run, in bytes. RSS is zero where /proc is unavailable.
+type MemSample struct {
+	Elapsed   time.Duration
+	RSS       uint64
+	HeapAlloc uint64 // bytes of live and not yet swept heap objects, as runtime.MemStats.HeapAlloc
+	HeapInuse uint64 // bytes of heap spans in use, as runtime.MemStats.HeapInuse
+}
+
+// MemoryUsage summarizes a run's memory trace, in bytes.
+type MemoryUsage struct {
+	PeakRSS       uint64 `json:"peak_rss_bytes,omitempty"`
+	AvgRSS        uint64 `json:"avg_rss_bytes,omitempty"`
+	PeakHeapAlloc uint64 `json:"peak_heap_alloc_bytes"`
+	AvgHeapAlloc  uint64 `json:"avg_heap_alloc_bytes"`
+	PeakHeapInuse uint64 `json:"peak_heap_inuse_bytes"`
+	AvgHeapInuse  uint64 `json:"avg_heap_inuse_bytes"`
+}
+
+var heapMetrics = []metrics.Sample{
+	{Name: "/memory/classes/heap/objects:bytes"},
+	{Name: "/memory/classes/heap/unused:bytes"},
+}
+
+// readMemory samples memory without stopping the world, as runtime.ReadMemStats would in the middle of a run.
+func readMemory() MemSample {
+	samples := append([]metrics.Sample(nil), heapMetrics...)
+	metrics.Read(samples)
+	objects, unused := samples[0].Value.Uint64(), samples[1].Value.Uint64()
+	return MemSample{RSS: residentMemory(), HeapAlloc: objects, HeapInuse: objects + unused}
+}
+
+// residentMemory reads the process's resident set size from /proc/self/statm, or returns zero where that is not
+// available.
+func residentMemory() uint64 {
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * uint64(os.Getpagesize())
+}
+
+// memoryMonitor samples the process's memory in the background during a run. A spike shorter than the interval can
+// slip between samples, so the peak is a lower bound.
+type memoryMonitor struct {
+	start   time.Time
+	samples []MemSample
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func startMemoryMonitor(interval time.Duration) *memoryMonitor {
+	m := &memoryMonitor{start: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			m.sample()
+			select {
+			case <-ticker.C:
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+func (m *memoryMonitor) sample() {
+	s := readMemory()
+	s.Elapsed = time.Since(m.start)
+	m.samples = append(m.samples, s)
+}
+
+// Stop ends sampling, taking one last sample so that even a run shorter than the interval has two, and returns the
+// trace.
+func (m *memoryMonitor) Stop() []MemSample {
+	close(m.stop)
+	<-m.done
+	m.sample()
+	return m.samples
+}
+
+// memoryUsage returns the peak and average of each measure over the trace; the zero value for an empty one.
+func memoryUsage(trace []MemSample) MemoryUsage {
+	var u MemoryUsage
+	if len(trace) == 0 {
+		return u
+	}
+	var rss, alloc, inuse float64
+	for _, s := range trace {
+		if s.RSS > u.PeakRSS {
+			u.PeakRSS = s.RSS
+		}
+		if s.HeapAlloc > u.PeakHeapAlloc {
+			u.PeakHeapAlloc = s.HeapAlloc
+		}
+		if s.HeapInuse > u.PeakHeapInuse {
+			u.PeakHeapInuse = s.HeapInuse
+		}
+		rss += float64(s.RSS)
+		alloc += float64(s.HeapAlloc)
+		inuse += float64(s.HeapInuse)
+	}
+	n := float64(len(trace))
+	u.AvgRSS, u.AvgHeapAlloc, u.AvgHeapInuse = uint64(rss/n), uint64(alloc/n), uint64(inuse/n)
+	return u
+}
+
+func outputMemory(trace []MemSample) {
+	if len(trace) == 0 {
+		return
+	}
+	u := memoryUsage(trace)
+	var parts []string
+	if u.PeakRSS > 0 {
+		parts = append(parts, fmt.Sprintf("RSS peak %s, average %s", formatMiB(u.PeakRSS), formatMiB(u.AvgRSS)))
+	}
+	parts = append(parts,
+		fmt.Sprintf("heap allocated peak %s, average %s", formatMiB(u.PeakHeapAlloc), formatMiB(u.AvgHeapAlloc)),
+		fmt.Sprintf("heap in use peak %s, average %s", formatMiB(u.PeakHeapInuse), formatMiB(u.AvgHeapInuse)))
+	fmt.Printf("\tMemory: %s\n", strings.Join(parts, "; "))
+}
+
+func formatMiB(bytes uint64) string {
+	return formatFloat(float64(bytes)/(1<<20), 1) + " MiB"
+}
+
+// memorySeries are the measures the memory plots draw, in MiB.
+var memorySeries = []struct {
+	name string
+	peak func(MemoryUsage) uint64
+	at   func(MemSample) uint64
+}{
+	{"RSS", func(u MemoryUsage) uint64 { return u.PeakRSS }, func(s MemSample) uint64 { return s.RSS }},
+	{"heap allocated", func(u MemoryUsage) uint64 { return u.PeakHeapAlloc }, func(s MemSample) uint64 { return s.HeapAlloc }},
+	{"heap in use", func(u MemoryUsage) uint64 { return u.PeakHeapInuse }, func(s MemSample) uint64 { return s.HeapInuse }},
+}
+
+// saveMemoryTrace draws the run's memory over time, with -plot-memory.
+func saveMemoryTrace(result BenchmarkResult, opts PlotOptions) error {
+	if !opts.Memory || len(result.MemoryTrace) < 2 {
+		return nil
+	}
+	p := plot.New()
+	p.Title.Text = "Memory over Time"
+	p.X.Label.Text = "Time since start (s)"
+	p.Y.Label.Text = "Memory (MiB)"
+	p.Y.Min = 0
+	for i, series := range memorySeries {
+		pts := make(plotter.XYs, len(result.MemoryTrace))
+		for j, s := range result.MemoryTrace {
+			pts[j] = plotter.XY{X: s.Elapsed.Seconds(), Y: float64(series.at(s)) / (1 << 20)}
+		}
+		if series.name == "RSS" && pts[0].Y == 0 {
+			continue
+		}
+		line, err := plotter.NewLine(pts)
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = plotutil.Color(i)
+		p.Add(line)
+		p.Legend.Add(series.name, line)
+	}
+	p.Legend.Top = true
+	p.Legend.Left = true
+	return opts.save(p, 6*vg.Inch, 4*vg.Inch, opts.runFile(result, "memory_time.png"))
+}
+
+// plotMemory draws each run's peak memory against the co-routine count, with -plot-memory: what a goroutine and its
+// request cost in memory as concurrency rises. Measures keep their colour across series, which are told apart by
+// dashes.
+func plotMemory(results []BenchmarkResult, opts PlotOptions) error {
+	if !opts.Memory {
+		return nil
+	}
+	plt := plot.New()
+	plt.Title.Text = "Peak Memory vs. Number of Co-Routines"
+	plt.X.Label.Text = "Number of Co-Routines"
+	plt.Y.Label.Text = "Peak memory (MiB)"
+	plt.Y.Min = 0
+	labels, series := bySeries(results)
+	for s, label := range labels {
+		for i, m := range memorySeries {
+			m := m
+			// Runs saved before memory was tracked, and RSS where it can't be read, are left out.
+			line, err := sweepLine(plt, series[label], func(r BenchmarkResult) float64 {
+				if peak := m.peak(memoryUsage(r.MemoryTrace)); peak > 0 {
+					return float64(peak) / (1 << 20)
+				}
+				return math.NaN()
+			}, plotutil.Color(i))
+			if err != nil {
+				return err
+			}
+			if line == nil {
+				continue
+			}
+			line.LineStyle.Dashes = plotutil.Dashes(s)
+			name := m.name
+			if len(labels) > 1 {
+				name += " " + label
+			}
+			plt.Legend.Add(name, line)
+		}
+	}
+	plt.Legend.Top = true
+	plt.Legend.Left = true
+	opts.fitCoroutines(&plt.X)
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("memory_vs_coroutines.png"))
+}
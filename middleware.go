@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Workload performs a single request.
+type Workload interface {
+	Do(ctx context.Context, log *RequestLog) error
+}
+
+type WorkloadFunc func(ctx context.Context, log *RequestLog) error
+
+func (f WorkloadFunc) Do(ctx context.Context, log *RequestLog) error {
+	return f(ctx, log)
+}
+
+func simulatedWorkload(cfg RunConfig) Workload {
+	if cfg.Target != nil {
+		return cfg.Target.workload(cfg.Seed)
+	}
+	return WorkloadFunc(func(ctx context.Context, log *RequestLog) error {
+		cfg := cfg
+		if class, ok := requestClassFrom(ctx); ok {
+			cfg.WorkTime = class.WorkTime
+			cfg.NetworkTime = class.NetworkTime
+		}
+		if err := doWork(cfg.withDeadline(ctx), randFrom(ctx), log); err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+}
+
+type Middleware func(next Workload) Workload
+
+type MiddlewareSpec struct {
+	Name string
+	Arg  string
+}
+
+func (s MiddlewareSpec) String() string {
+	if s.Arg == "" {
+		return s.Name
+	}
+	return s.Name + "=" + s.Arg
+}
+
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// parseMiddleware parses a comma-separated chain such as "tracing,timeout=50ms,retry=3,breaker=5/1s,metrics".
+// The first entry is the outermost wrapper.
+func parseMiddleware(chain string) ([]MiddlewareSpec, error) {
+	var specs []MiddlewareSpec
+	for _, entry := range strings.Split(chain, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		spec := MiddlewareSpec{Name: entry}
+		if i := strings.Index(entry, "="); i >= 0 {
+			spec = MiddlewareSpec{Name: entry[:i], Arg: entry[i+1:]}
+		}
+		if _, err := spec.build(realClock{}); err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+func (s MiddlewareSpec) build(clock Clock) (Middleware, error) {
+	switch s.Name {
+	case "timeout":
+		d, err := time.ParseDuration(s.Arg)
+		if err != nil {
+			return nil, fmt.Errorf("timeout middleware: %w", err)
+		}
+		return timeoutMiddleware(clock, d), nil
+	case "retry":
+		attempts, err := strconv.Atoi(s.Arg)
+		if err != nil || attempts < 1 {
+			return nil, fmt.Errorf("retry middleware needs a positive attempt count, got %q", s.Arg)
+		}
+		return retryMiddleware(attempts), nil
+	case "breaker":
+		parts := strings.SplitN(s.Arg, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("breaker middleware needs failures/cooldown, got %q", s.Arg)
+		}
+		failures, err := strconv.Atoi(parts[0])
+		if err != nil || failures < 1 {
+			return nil, fmt.Errorf("breaker middleware needs a positive failure threshold, got %q", parts[0])
+		}
+		cooldown, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("breaker middleware: %w", err)
+		}
+		return breakerMiddleware(clock, failures, cooldown), nil
+	case "tracing":
+		return tracingMiddleware(clock), nil
+	case "metrics":
+		return metricsMiddleware(clock), nil
+	}
+	return nil, fmt.Errorf("unknown middleware %q", s.Name)
+}
+
+func timeoutMiddleware(clock Clock, d time.Duration) Middleware {
+	return func(next Workload) Workload {
+		return WorkloadFunc(func(ctx context.Context, log *RequestLog) error {
+			ctx, cancel := withClockTimeout(ctx, clock, d)
+			defer cancel()
+			err := next.Do(ctx, log)
+			if err == nil {
+				err = ctx.Err()
+			}
+			return err
+		})
+	}
+}
+
+func retryMiddleware(attempts int) Middleware {
+	return func(next Workload) Workload {
+		return WorkloadFunc(func(ctx context.Context, log *RequestLog) error {
+			var err error
+			for i := 0; i < attempts; i++ {
+				if err = next.Do(ctx, log); err == nil || errors.Is(err, context.Canceled) {
+					return err
+				}
+			}
+			return err
+		})
+	}
+}
+
+// breakerMiddleware opens after the given number of consecutive failures, rejects requests for the cooldown and
+// then lets a single trial request through to decide whether to close again.
+func breakerMiddleware(clock Clock, failures int, cooldown time.Duration) Middleware {
+	return func(next Workload) Workload {
+		var mu sync.Mutex
+		var consecutive int
+		var openUntil time.Time
+		var trialInFlight bool
+		return WorkloadFunc(func(ctx context.Context, log *RequestLog) error {
+			mu.Lock()
+			trial := false
+			if consecutive >= failures {
+				if clock.Now().Before(openUntil) || trialInFlight {
+					mu.Unlock()
+					return errCircuitOpen
+				}
+				trial = true
+				trialInFlight = true
+			}
+			mu.Unlock()
+
+			err := next.Do(ctx, log)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if trial {
+				trialInFlight = false
+			}
+			if err != nil {
+				consecutive++
+				if consecutive >= failures {
+					openUntil = clock.Now().Add(cooldown)
+				}
+			} else {
+				consecutive = 0
+			}
+			return err
+		})
+	}
+}
+
+func tracingMiddleware(clock Clock) Middleware {
+	return func(next Workload) Workload {
+		return WorkloadFunc(func(ctx context.Context, log *RequestLog) error {
+			log.Begin(clock.Now(), "middleware", "span", 0)
+			err := next.Do(ctx, log)
+			end := clock.Now()
+			if err != nil {
+				log.Debugf(end, "span failed: %v", err)
+			}
+			log.End(end)
+			return err
+		})
+	}
+}
+
+// metricsMiddleware maintains per-call counters the way a service's metrics library would; only its cost is of
+// interest here.
+func metricsMiddleware(clock Clock) Middleware {
+	return func(next Workload) Workload {
+		var calls, failures, nanos int64
+		return WorkloadFunc(func(ctx context.Context, log *RequestLog) error {
+			start := clock.Now()
+			err := next.Do(ctx, log)
+			atomic.AddInt64(&nanos, int64(clock.Now().Sub(start)))
+			atomic.AddInt64(&calls, 1)
+			if err != nil {
+				atomic.AddInt64(&failures, 1)
+			}
+			return err
+		})
+	}
+}
+
+// layerTimer accumulates the time spent inside one layer of the middleware chain, including everything it wraps.
+type layerTimer struct {
+	name  string
+	clock Clock
+	nanos int64
+}
+
+func (t *layerTimer) wrap(next Workload) Workload {
+	return WorkloadFunc(func(ctx context.Context, log *RequestLog) error {
+		start := t.clock.Now()
+		err := next.Do(ctx, log)
+		atomic.AddInt64(&t.nanos, int64(t.clock.Now().Sub(start)))
+		return err
+	})
+}
+
+type MiddlewareOverhead struct {
+	Name       string
+	PerRequest time.Duration
+}
+
+// buildWorkload wraps base in the configured middleware. Every layer, and the base itself, is timed so that a layer's
+// overhead is the time spent in it minus the time spent in the layer it wraps.
+func buildWorkload(base Workload, specs []MiddlewareSpec, clock Clock) (Workload, []*layerTimer, error) {
+	timers := []*layerTimer{{name: "workload", clock: clock}}
+	w := timers[0].wrap(base)
+	for i := len(specs) - 1; i >= 0; i-- {
+		mw, err := specs[i].build(clock)
+		if err != nil {
+			return nil, nil, err
+		}
+		t := &layerTimer{name: specs[i].String(), clock: clock}
+		w = t.wrap(mw(w))
+		timers = append(timers, t)
+	}
+	return w, timers, nil
+}
+
+func middlewareOverhead(timers []*layerTimer, requests int) []MiddlewareOverhead {
+	if len(timers) < 2 || requests == 0 {
+		return nil
+	}
+	var overhead []MiddlewareOverhead
+	for i := len(timers) - 1; i >= 1; i-- {
+		inner := atomic.LoadInt64(&timers[i-1].nanos)
+		outer := atomic.LoadInt64(&timers[i].nanos)
+		overhead = append(overhead, MiddlewareOverhead{
+			Name:       timers[i].name,
+			PerRequest: time.Duration((outer - inner) / int64(requests)),
+		})
+	}
+	return overhead
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestClass is one kind of request in a traffic mix, picked for a request with probability proportional to Weight.
+// Priority orders the classes for a -dispatch other than fifo.
+type RequestClass struct {
+	Name        string
+	Weight      float64
+	WorkTime    time.Duration
+	NetworkTime time.Duration
+	Priority    int
+}
+
+type TrafficMix []RequestClass
+
+// parseTrafficMix parses a comma-separated list of name=weight:cpu/network classes, such as
+// "read=80:2ms/20ms,write=20:10ms/100ms".
+func parseTrafficMix(s string) (TrafficMix, error) {
+	var mix TrafficMix
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		eq := strings.Index(entry, "=")
+		colon := strings.Index(entry, ":")
+		slash := strings.Index(entry, "/")
+		if eq <= 0 || colon < eq || slash < colon {
+			return nil, fmt.Errorf("request class %q is not of the form name=weight:cpu/network", entry)
+		}
+		weight, err := strconv.ParseFloat(entry[eq+1:colon], 64)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("request class %q needs a positive weight", entry)
+		}
+		work, err := time.ParseDuration(entry[colon+1 : slash])
+		if err != nil {
+			return nil, fmt.Errorf("request class %q: %w", entry, err)
+		}
+		network, err := time.ParseDuration(entry[slash+1:])
+		if err != nil {
+			return nil, fmt.Errorf("request class %q: %w", entry, err)
+		}
+		mix = append(mix, RequestClass{Name: entry[:eq], Weight: weight, WorkTime: work, NetworkTime: network})
+	}
+	return mix, nil
+}
+
+func (m TrafficMix) totalWeight() float64 {
+	var total float64
+	for _, class := range m {
+		total += class.Weight
+	}
+	return total
+}
+
+func (m TrafficMix) pick(r *rand.Rand) RequestClass {
+	x := r.Float64() * m.totalWeight()
+	for _, class := range m {
+		if x < class.Weight {
+			return class
+		}
+		x -= class.Weight
+	}
+	return m[len(m)-1]
+}
+
+// mean returns the weighted average CPU and network time of a request.
+func (m TrafficMix) mean() (work, network time.Duration) {
+	total := m.totalWeight()
+	var w, n float64
+	for _, class := range m {
+		w += float64(class.WorkTime) * class.Weight / total
+		n += float64(class.NetworkTime) * class.Weight / total
+	}
+	return time.Duration(w), time.Duration(n)
+}
+
+func (m TrafficMix) String() string {
+	var parts []string
+	total := m.totalWeight()
+	for _, class := range m {
+		parts = append(parts, fmt.Sprintf("%.0f%% %s (%v/%v)", class.Weight*100/total, class.Name, class.WorkTime, class.NetworkTime))
+	}
+	return strings.Join(parts, ", ")
+}
+
+type requestClassKey struct{}
+
+func withRequestClass(ctx context.Context, class RequestClass) context.Context {
+	return context.WithValue(ctx, requestClassKey{}, class)
+}
+
+func requestClassFrom(ctx context.Context) (RequestClass, bool) {
+	class, ok := ctx.Value(requestClassKey{}).(RequestClass)
+	return class, ok
+}
+
+type ClassResult struct {
+	Name          string
+	Priority      int
+	ResponseTimes Collector
+	CallerTimes   Collector // queue wait plus response time; nil in results saved before they were kept
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Broker carries messages from producers to consumers. Messages are opaque bytes, so that a broker need not be in the
+// process: the in-process one is the only one built in, and adapters for external ones such as NATS or Kafka implement
+// the same interface.
+type Broker interface {
+	// Publish hands a message to the broker, waiting while it has no room for it.
+	Publish(msg []byte) error
+	// Subscribe starts consumers goroutines each handing the messages it receives to handle, one at a time, until
+	// the broker is closed.
+	Subscribe(consumers int, handle func(msg []byte)) error
+	// Close stops the consumers once they have handled every message already published.
+	Close() error
+}
+
+// newBroker returns a broker of the named kind, holding at most capacity messages that no consumer has taken yet.
+func newBroker(kind string, capacity int) (Broker, error) {
+	switch kind {
+	case "inproc":
+		return &inprocBroker{queue: make(chan []byte, capacity)}, nil
+	}
+	return nil, fmt.Errorf("unknown message broker %q, expected inproc", kind)
+}
+
+// inprocBroker is a buffered channel: consumers take messages in the order they were published, and producers wait
+// when the buffer is full.
+type inprocBroker struct {
+	queue     chan []byte
+	consumers sync.WaitGroup
+}
+
+func (b *inprocBroker) Publish(msg []byte) error {
+	b.queue <- msg
+	return nil
+}
+
+func (b *inprocBroker) Subscribe(consumers int, handle func(msg []byte)) error {
+	for i := 0; i < consumers; i++ {
+		b.consumers.Add(1)
+		go func() {
+			defer b.consumers.Done()
+			for msg := range b.queue {
+				handle(msg)
+			}
+		}()
+	}
+	return nil
+}
+
+func (b *inprocBroker) Close() error {
+	close(b.queue)
+	b.consumers.Wait()
+	return nil
+}
+
+// MQWorkload turns every request into a message: the request publishes it through a Broker and waits for a consumer
+// to handle it, and handling it is the request's CPU work and then its network time. Producers are the run's
+// co-routines and consumers RunConfig.MQConsumers goroutines, so that the two concurrencies can be swept against each
+// other. Besides the response time, from publishing to handled, the run records the delivery latency, from
+// publishing to a consumer taking the message, which is where a backlog shows.
+type MQWorkload struct {
+	Broker      string
+	Capacity    int // messages the broker holds that no consumer has taken yet
+	MessageSize int
+
+	broker  Broker
+	nextID  uint64
+	waiting sync.Map // message ID to its *mqWaiter
+
+	mu         sync.Mutex
+	deliveries []float64 // delivery latencies in ms, in the order the messages were taken
+}
+
+// A message starts with its ID and the time it was published, which is all a consumer needs from it.
+const mqHeaderSize = 16
+
+// mqWaiter is a request waiting for its message to be handled.
+type mqWaiter struct {
+	rand *rand.Rand
+	log  *RequestLog
+	err  error
+	done chan struct{}
+}
+
+// Start opens the broker and starts the run's consumers, which do the work of cfg's requests.
+func (m *MQWorkload) Start(cfg RunConfig) error {
+	if m.MessageSize < mqHeaderSize {
+		return fmt.Errorf("message size must be at least %d bytes, got %d", mqHeaderSize, m.MessageSize)
+	}
+	broker, err := newBroker(m.Broker, m.Capacity)
+	if err != nil {
+		return err
+	}
+	m.broker = broker
+	m.deliveries = nil
+	clock := cfg.clock()
+	kernel := cpuKernelNamed(cfg.CPUKernel)
+	return broker.Subscribe(cfg.MQConsumers, func(msg []byte) {
+		taken := clock.Now()
+		id := binary.BigEndian.Uint64(msg)
+		published := time.Unix(0, int64(binary.BigEndian.Uint64(msg[8:])))
+		m.mu.Lock()
+		m.deliveries = append(m.deliveries, float64(taken.Sub(published))/float64(time.Millisecond))
+		m.mu.Unlock()
+		w, ok := m.waiting.Load(id)
+		if !ok {
+			return
+		}
+		waiter := w.(*mqWaiter)
+		waiter.log.Debugf(taken, "message taken by a consumer after %v", taken.Sub(published))
+		doCpuWork(clock, kernel, cfg.CPUDist.Sample(waiter.rand, cfg.WorkTime), waiter.log)
+		waiter.err = doNetworkPhase(cfg, waiter.rand, cfg.NetworkDist.Sample(waiter.rand, cfg.NetworkTime), waiter.log)
+		m.waiting.Delete(id)
+		close(waiter.done)
+	})
+}
+
+func (m *MQWorkload) Stop() {
+	m.broker.Close()
+}
+
+// delivered returns how many messages consumers have taken so far in the run.
+func (m *MQWorkload) delivered() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.deliveries)
+}
+
+// deliveryStats summarizes the delivery latencies of the messages taken since the first from messages.
+func (m *MQWorkload) deliveryStats(from int) *DeliveryStats {
+	m.mu.Lock()
+	latencies := append([]float64(nil), m.deliveries[from:]...)
+	m.mu.Unlock()
+	stats := &DeliveryStats{Messages: len(latencies)}
+	if len(latencies) == 0 {
+		return stats
+	}
+	sort.Float64s(latencies)
+	sum := 0.0
+	for _, l := range latencies {
+		sum += l
+	}
+	stats.MeanMs = sum / float64(len(latencies))
+	stats.P50Ms = latencies[len(latencies)*50/100]
+	stats.P99Ms = latencies[len(latencies)*99/100]
+	stats.MaxMs = latencies[len(latencies)-1]
+	return stats
+}
+
+func (m *MQWorkload) String() string {
+	return fmt.Sprintf("%d byte messages through the %s broker holding up to %d", m.MessageSize, m.Broker, m.Capacity)
+}
+
+// DeliveryStats is how long messages took from being published to being taken by a consumer.
+type DeliveryStats struct {
+	Messages int     `json:"messages"`
+	MeanMs   float64 `json:"mean_ms"`
+	P50Ms    float64 `json:"p50_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	MaxMs    float64 `json:"max_ms"`
+}
+
+func doMQWork(clock Clock, m *MQWorkload, r *rand.Rand, log *RequestLog) error {
+	published := log.now(clock)
+	log.Begin(published, "mq", "message", 0)
+	id := atomic.AddUint64(&m.nextID, 1)
+	waiter := &mqWaiter{rand: r, log: log, done: make(chan struct{})}
+	m.waiting.Store(id, waiter)
+	msg := make([]byte, m.MessageSize)
+	binary.BigEndian.PutUint64(msg, id)
+	binary.BigEndian.PutUint64(msg[8:], uint64(clock.Now().UnixNano()))
+	if err := m.broker.Publish(msg); err != nil {
+		m.waiting.Delete(id)
+		return err
+	}
+	<-waiter.done
+	log.End(log.now(clock))
+	return waiter.err
+}
+
+func outputDelivery(result BenchmarkResult) {
+	if result.Config.MQ != nil {
+		consumers := "1 consumer"
+		if result.Config.MQConsumers != 1 {
+			consumers = formatCount(int64(result.Config.MQConsumers)) + " consumers"
+		}
+		fmt.Printf("\tMessages: %v, to %s\n", result.Config.MQ, consumers)
+	}
+	d := result.Delivery
+	if d == nil || d.Messages == 0 {
+		return
+	}
+	fmt.Printf("\tDelivery latency: p50 %s, p99 %s, max %s, mean %s over %s messages\n", formatMs(d.P50Ms),
+		formatMs(d.P99Ms), formatMs(d.MaxMs), formatMs(d.MeanMs), formatCount(int64(d.Messages)))
+}
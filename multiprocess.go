@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// childRunEnv carries a childRun to a load-generating child process. The child is started with the parent's own
+// arguments, so it builds the same base configuration and differs only in what the childRun overrides.
+const childRunEnv = "PERF_CHILD_RUN"
+
+// childRun is one child process's share of a multi-process run.
+type childRun struct {
+	Executor    string
+	GOMAXPROCS  int
+	Coroutines  int64
+	Splits      int
+	WorkTime    time.Duration
+	NetworkTime time.Duration
+	RateLimit   RateLimit // the child's share of the run's
+	SQLMaxOpen  int       // likewise, as each child has a connection pool of its own
+	MQConsumers int       // and a broker
+	FanOutLimit int       // per request, so not shared out
+	HedgeAfter  time.Duration
+	Propagate   bool
+	Dispatch    string
+	GOGC        int
+	MemoryLimit int64 // each child's, not shared out: the limit is on a process's own heap
+	CPUs        []int // the CPU set to pin the child to, if any of its own
+	Iterations  int
+	Seed        int64
+	Out         string // file the child writes its childResult to
+}
+
+// childResult is what a child process measured, with every latency so that the parent can merge the distributions.
+type childResult struct {
+	Iterations    int
+	Errors        int
+	Timeouts      int
+	ThroughputRps float64
+	BaselineRps   float64
+	LatenciesMs   []float64
+	CPUTime       time.Duration
+	EffectiveCPUs float64
+	GOMAXPROCS    int
+	Err           string
+}
+
+// ProcessResult is one child process's part of a multi-process run.
+type ProcessResult struct {
+	Coroutines    int64
+	Iterations    int
+	Errors        int
+	ThroughputRps float64
+	P50           float64
+	P99           float64
+	Host          string // the agent that ran it, for a distributed run
+}
+
+// runChild runs the share of a run described in the environment and writes its result for the parent.
+func runChild(ctx context.Context, base RunConfig, spec string) error {
+	var run childRun
+	if err := json.Unmarshal([]byte(spec), &run); err != nil {
+		return fmt.Errorf("%s: %w", childRunEnv, err)
+	}
+	cfg := base
+	cfg.Executor = run.Executor
+	cfg.GOMAXPROCS = run.GOMAXPROCS
+	cfg.NumCoroutines = run.Coroutines
+	cfg.Splits = run.Splits
+	cfg.WorkTime, cfg.NetworkTime = run.WorkTime, run.NetworkTime
+	cfg.RateLimit = run.RateLimit
+	cfg.SQLMaxOpen = run.SQLMaxOpen
+	cfg.MQConsumers = run.MQConsumers
+	cfg.FanOutLimit = run.FanOutLimit
+	cfg.Hedge.After = run.HedgeAfter
+	cfg.PropagateDeadline = run.Propagate
+	cfg.Dispatch = run.Dispatch
+	cfg.GOGC, cfg.MemoryLimit = run.GOGC, run.MemoryLimit
+	cfg.Iterations = run.Iterations
+	cfg.Seed = run.Seed
+	cfg.Collector = "exact"
+	if len(run.CPUs) > 0 {
+		if err := pinProcess(run.CPUs); err != nil {
+			return err
+		}
+	}
+	result, err := runBenchmark(ctx, cfg, multiReporter{})
+	if err != nil {
+		return err
+	}
+	out := childResult{
+		Iterations:    result.Iterations,
+		Errors:        result.Errors,
+		Timeouts:      result.Timeouts,
+		ThroughputRps: result.ThroughputRps,
+		LatenciesMs:   result.ResponseTimes.Values(),
+		CPUTime:       result.CPUTime,
+		EffectiveCPUs: result.EffectiveCPUs,
+		GOMAXPROCS:    result.Config.GOMAXPROCS,
+	}
+	if result.Speedup > 0 {
+		out.BaselineRps = result.ThroughputRps / result.Speedup
+	}
+	if result.Err != nil {
+		out.Err = result.Err.Error()
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(run.Out, b, 0o644)
+}
+
+// shareRun splits cfg's load n ways, for n load generators. Requests follow co-routines, so that the generators
+// finish at about the same time.
+func shareRun(cfg RunConfig, n int64) []childRun {
+	var runs []childRun
+	var coroutinesSoFar int64
+	assigned := 0
+	for i := int64(0); i < n; i++ {
+		run := childRun{
+			Executor:    cfg.Executor,
+			GOMAXPROCS:  cfg.GOMAXPROCS,
+			Coroutines:  cfg.NumCoroutines / n,
+			Splits:      cfg.Splits,
+			WorkTime:    cfg.WorkTime,
+			NetworkTime: cfg.NetworkTime,
+			GOGC:        cfg.GOGC,
+			MemoryLimit: cfg.MemoryLimit,
+			Seed:        cfg.Seed + i,
+		}
+		if len(cfg.CPUSets) > 1 {
+			run.CPUs = cfg.CPUSets[i%int64(len(cfg.CPUSets))]
+		}
+		if i < cfg.NumCoroutines%n {
+			run.Coroutines++
+		}
+		coroutinesSoFar += run.Coroutines
+		run.RateLimit = cfg.RateLimit
+		run.RateLimit.Rate *= float64(run.Coroutines) / float64(cfg.NumCoroutines)
+		if cfg.SQLMaxOpen > 0 {
+			// Every child needs a connection; zero would lift its cap altogether.
+			run.SQLMaxOpen = (cfg.SQLMaxOpen + int(i)) / int(n)
+			if run.SQLMaxOpen < 1 {
+				run.SQLMaxOpen = 1
+			}
+		}
+		run.FanOutLimit = cfg.FanOutLimit
+		run.HedgeAfter = cfg.Hedge.After
+		run.Propagate = cfg.PropagateDeadline
+		run.Dispatch = cfg.Dispatch
+		if cfg.MQConsumers > 0 {
+			run.MQConsumers = (cfg.MQConsumers + int(i)) / int(n)
+			if run.MQConsumers < 1 {
+				run.MQConsumers = 1
+			}
+		}
+		run.Iterations = int(int64(cfg.Iterations)*coroutinesSoFar/cfg.NumCoroutines) - assigned
+		assigned += run.Iterations
+		runs = append(runs, run)
+	}
+	return runs
+}
+
+// runMultiProcess splits cfg's coroutines and requests over cfg.Processes child processes, so that load generation
+// and the measured work aren't coupled through one scheduler and one garbage collector, and merges what they measured.
+func runMultiProcess(ctx context.Context, cfg RunConfig, reporter Reporter) (BenchmarkResult, error) {
+	if cfg.GOMAXPROCS == 0 {
+		cfg.GOMAXPROCS = runtime.GOMAXPROCS(0)
+	}
+	reporter.OnRunStart(cfg)
+	started := time.Now()
+	dir, err := os.MkdirTemp("", "perf-children")
+	if err != nil {
+		return BenchmarkResult{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	var cmds []*exec.Cmd
+	var runs []childRun
+	for i, run := range shareRun(cfg, int64(cfg.Processes)) {
+		if run.Coroutines == 0 || run.Iterations == 0 {
+			continue
+		}
+		run.Out = filepath.Join(dir, fmt.Sprintf("child-%d.json", i))
+		spec, err := json.Marshal(run)
+		if err != nil {
+			return BenchmarkResult{}, err
+		}
+		// Children stay in the parent's process group, so an interrupt reaches them too and they drain like the
+		// parent would.
+		cmd := exec.Command(os.Args[0], cfg.childArgs...)
+		cmd.Env = append(os.Environ(), childRunEnv+"="+string(spec))
+		// The children repeat the parent's warnings; their output is only worth showing when one fails.
+		cmd.Stderr = &bytes.Buffer{}
+		if err := cmd.Start(); err != nil {
+			return BenchmarkResult{}, err
+		}
+		cmds = append(cmds, cmd)
+		runs = append(runs, run)
+	}
+	var runErr error
+	for i, cmd := range cmds {
+		if err := cmd.Wait(); err != nil && runErr == nil {
+			runErr = fmt.Errorf("load generator process %d: %w: %s", i, err, bytes.TrimSpace(cmd.Stderr.(*bytes.Buffer).Bytes()))
+		}
+	}
+	if runErr == nil {
+		runErr = ctx.Err()
+	}
+
+	children := make([]*childResult, len(runs))
+	for i, run := range runs {
+		var child childResult
+		b, err := os.ReadFile(run.Out)
+		if err == nil {
+			err = json.Unmarshal(b, &child)
+		}
+		if err != nil {
+			if runErr == nil {
+				runErr = fmt.Errorf("load generator process %d: %w", i, err)
+			}
+			continue
+		}
+		if child.Err != "" && runErr == nil {
+			runErr = fmt.Errorf("load generator process %d: %s", i, child.Err)
+		}
+		children[i] = &child
+	}
+	return mergeChildren(cfg, reporter, started, runs, children, nil, effectiveCPUs(cfg.GOMAXPROCS*len(runs)), runErr)
+}
+
+// mergeChildren makes one result of what the load generators given runs measured, reporting it. Children that
+// measured nothing are nil; hosts, if the generators ran elsewhere, names where. The generators run side by side, so
+// throughput is every request they completed over the time the slowest took.
+func mergeChildren(cfg RunConfig, reporter Reporter, started time.Time, runs []childRun, children []*childResult, hosts []string, cpus float64, runErr error) (BenchmarkResult, error) {
+	responseTimes, err := newCollector(cfg.Collector, cfg.ReservoirSize, cfg.Iterations, newRand(cfg.Seed, collectorRandStream))
+	if err != nil {
+		return BenchmarkResult{}, err
+	}
+	result := BenchmarkResult{
+		WorkTime:      cfg.WorkTime,
+		NetworkTime:   cfg.NetworkTime,
+		NumCoroutines: cfg.NumCoroutines,
+		Config:        cfg,
+		ResponseTimes: responseTimes,
+		Started:       started,
+		Host:          currentHost(),
+	}
+	var baselineRps float64
+	var longest time.Duration
+	sample := 0
+	for i, child := range children {
+		if child == nil {
+			continue
+		}
+		processTimes, _ := newCollector("exact", 0, len(child.LatenciesMs), nil)
+		for _, ms := range child.LatenciesMs {
+			responseTimes.Add(ms)
+			processTimes.Add(ms)
+			reporter.OnSample(Sample{Request: sample, Latency: time.Duration(ms * float64(time.Millisecond))})
+			sample++
+		}
+		p := ProcessResult{
+			Coroutines:    runs[i].Coroutines,
+			Iterations:    child.Iterations,
+			Errors:        child.Errors,
+			ThroughputRps: child.ThroughputRps,
+			P50:           processTimes.Percentile(50),
+			P99:           processTimes.Percentile(99),
+		}
+		if hosts != nil {
+			p.Host = hosts[i]
+		}
+		result.Processes = append(result.Processes, p)
+		result.Iterations += child.Iterations
+		result.Errors += child.Errors
+		result.Timeouts += child.Timeouts
+		if child.ThroughputRps > 0 {
+			if d := time.Duration(float64(child.Iterations) / child.ThroughputRps * float64(time.Second)); d > longest {
+				longest = d
+			}
+		}
+		result.CPUTime += child.CPUTime
+		baselineRps += child.BaselineRps / float64(len(runs))
+	}
+	result.Err = runErr
+	result.Latency = latencyStats(responseTimes)
+	result.Outliers = detectOutliers(cfg.Outliers, responseTimes)
+	if longest > 0 {
+		result.ThroughputRps = float64(result.Iterations) / longest.Seconds()
+	}
+	if baselineRps > 0 {
+		result.Speedup = result.ThroughputRps / baselineRps
+	}
+	result.EffectiveCPUs = cpus
+	result.CpuUtilization = result.ThroughputRps * 100.0 / (result.EffectiveCPUs / cfg.WorkTime.Seconds())
+	result.Failure = classifyFailure(result)
+	return result, reporter.OnRunComplete(result)
+}
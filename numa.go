@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NUMANode is one of the machine's NUMA nodes and the CPUs on it.
+type NUMANode struct {
+	ID   int    `json:"id"`
+	CPUs string `json:"cpus"` // as a cpulist
+	cpus []int
+}
+
+// numaTopology reads the machine's NUMA nodes that have CPUs from sysfs, in order of ID; nil where there is no sysfs to
+// read them from. Memory-only nodes are left out, as nothing can be placed on them.
+func numaTopology() []NUMANode {
+	dirs, _ := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	var nodes []NUMANode
+	for _, dir := range dirs {
+		id, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "node"))
+		if err != nil {
+			continue
+		}
+		s, ok := readSysfsString(filepath.Join(dir, "cpulist"))
+		if !ok || s == "" {
+			continue
+		}
+		cpus, err := parseCPUList(s)
+		if err != nil {
+			continue
+		}
+		nodes = append(nodes, NUMANode{ID: id, CPUs: formatCPUList(cpus), cpus: cpus})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+var numaPlacements = []string{"confined", "spread"}
+
+// parseNUMAPlacements parses a comma-separated list of placements to sweep: confined runs on the CPUs of one NUMA
+// node, spread on as many CPUs taken from every node in turn. The machine must have more than one node, among the
+// CPUs -cpu-affinity left the process.
+func parseNUMAPlacements(s string) ([]string, error) {
+	var placements []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "confined" && v != "spread" {
+			return nil, fmt.Errorf("unknown NUMA placement %q, expected one of %s", v, strings.Join(numaPlacements, ", "))
+		}
+		placements = append(placements, v)
+	}
+	if n := len(usableNUMANodes()); n < 2 {
+		return nil, fmt.Errorf("-numa-placement compares NUMA nodes, and this machine has %d the process can run on", n)
+	}
+	return placements, nil
+}
+
+// usableNUMANodes is the machine's NUMA nodes cut down to the CPUs the process was pinned to, dropping any left empty.
+func usableNUMANodes() [][]int {
+	allowed := map[int]bool{}
+	for _, cpu := range pinnedCPUs {
+		allowed[cpu] = true
+	}
+	var nodes [][]int
+	for _, node := range numaTopology() {
+		var cpus []int
+		for _, cpu := range node.cpus {
+			if len(pinnedCPUs) == 0 || allowed[cpu] {
+				cpus = append(cpus, cpu)
+			}
+		}
+		if len(cpus) > 0 {
+			nodes = append(nodes, cpus)
+		}
+	}
+	return nodes
+}
+
+// placementCPUs is the CPU set a run with placement is pinned to. Both placements get as many CPUs as the first
+// node has, so that they differ only in how many nodes the CPUs, and the memory the run first touches from them, are
+// on. Memory the process touched before the run stays on whichever node it was first touched from.
+func placementCPUs(placement string) []int {
+	nodes := usableNUMANodes()
+	if len(nodes) == 0 {
+		return nil
+	}
+	if placement == "confined" {
+		return nodes[0]
+	}
+	var cpus []int
+	for i := 0; len(cpus) < len(nodes[0]); i++ {
+		for _, node := range nodes {
+			if i < len(node) && len(cpus) < len(nodes[0]) {
+				cpus = append(cpus, node[i])
+			}
+		}
+	}
+	sort.Ints(cpus)
+	return cpus
+}
+
+// applyPlacement pins the process to the CPUs of cfg's NUMA placement for a run, returning them and what puts the
+// affinity it had back.
+func applyPlacement(cfg RunConfig) (cpus []int, restore func(), err error) {
+	previous, err := getAffinity()
+	if err != nil {
+		return nil, nil, err
+	}
+	cpus = placementCPUs(cfg.Placement)
+	if err := setAffinity(cpus); err != nil {
+		return nil, nil, fmt.Errorf("placing the run %s on CPUs %s: %w", cfg.Placement, formatCPUList(cpus), err)
+	}
+	return cpus, func() { setAffinity(previous) }, nil
+}
+
+// reportPlacements compares the throughput of runs that differ only in their NUMA placement, for sweeps of it.
+func reportPlacements(results []BenchmarkResult) {
+	type pair struct{ confined, spread []float64 }
+	pairs := map[string]*pair{}
+	var keys []string
+	for _, r := range results {
+		if r.Config.Placement == "" || r.Failure != nil || r.Err != nil {
+			continue
+		}
+		parts := []string{r.Config.Executor, strconv.Itoa(r.Config.GOMAXPROCS), strconv.FormatInt(r.NumCoroutines, 10)}
+		for _, d := range gridDims {
+			if d.file != "numa_placement" {
+				parts = append(parts, d.value(r.Config))
+			}
+		}
+		key := strings.Join(parts, "|")
+		p, ok := pairs[key]
+		if !ok {
+			p = &pair{}
+			pairs[key] = p
+			keys = append(keys, key)
+		}
+		if r.Config.Placement == "confined" {
+			p.confined = append(p.confined, r.ThroughputRps)
+		} else {
+			p.spread = append(p.spread, r.ThroughputRps)
+		}
+	}
+	var logSum, lo, hi float64
+	matched := 0
+	for _, key := range keys {
+		p := pairs[key]
+		if len(p.confined) == 0 || len(p.spread) == 0 {
+			continue
+		}
+		ratio := mean(p.spread) / mean(p.confined)
+		if ratio <= 0 || math.IsInf(ratio, 0) || math.IsNaN(ratio) {
+			continue
+		}
+		if matched == 0 || ratio < lo {
+			lo = ratio
+		}
+		if matched == 0 || ratio > hi {
+			hi = ratio
+		}
+		logSum += math.Log(ratio)
+		matched++
+	}
+	if matched == 0 {
+		return
+	}
+	fmt.Printf("NUMA placement: spread over nodes ran at %s× the throughput of confined to one (geometric mean of %d configurations, from %s× to %s×)\n",
+		formatFloat(math.Exp(logSum/float64(matched)), 3), matched, formatFloat(lo, 3), formatFloat(hi, 3))
+}
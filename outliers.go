@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/montanaflynn/stats"
+)
+
+var outlierMethods = []string{"iqr", "mad"}
+
+// OutlierReport sets apart the response times of a run that lie outside fences drawn from the bulk of the others, so
+// that a single OS hiccup doesn't distort the statistics of a small run. With iqr the fences are 1.5 interquartile
+// ranges beyond the quartiles (Tukey); with mad they are 3.5 scaled median absolute deviations from the median
+// (Iglewicz and Hoaglin), which tolerates more outliers before the fences themselves move.
+type OutlierReport struct {
+	Method    string
+	Low, High float64   // fences in ms; values strictly outside them are outliers
+	Outliers  []float64 // in ms, slowest last
+	Total     int
+	Trimmed   Collector // the response times within the fences
+}
+
+// detectOutliers returns nil when method is empty or c holds too few values to draw fences from.
+func detectOutliers(method string, c Collector) *OutlierReport {
+	values := append([]float64(nil), c.Values()...)
+	if method == "" || len(values) < 4 {
+		return nil
+	}
+	sort.Float64s(values)
+	r := &OutlierReport{Method: method, Total: len(values)}
+	switch method {
+	case "iqr":
+		q1, _ := stats.Percentile(values, 25)
+		q3, _ := stats.Percentile(values, 75)
+		r.Low, r.High = q1-1.5*(q3-q1), q3+1.5*(q3-q1)
+	case "mad":
+		median, _ := stats.Median(values)
+		deviations := make([]float64, len(values))
+		for i, v := range values {
+			deviations[i] = math.Abs(v - median)
+		}
+		mad, _ := stats.Median(deviations)
+		// 0.6745 is the MAD of a standard normal distribution, making the fences comparable to 3.5 standard deviations.
+		r.Low, r.High = median-3.5*mad/0.6745, median+3.5*mad/0.6745
+	}
+	trimmed := &exactCollector{}
+	for _, v := range values {
+		if v < r.Low || v > r.High {
+			r.Outliers = append(r.Outliers, v)
+		} else {
+			trimmed.Add(v)
+		}
+	}
+	r.Trimmed = trimmed
+	return r
+}
+
+// outputOutliers prints the report, listing at most the ten slowest outliers.
+func outputOutliers(r *OutlierReport, pcts []float64) {
+	line := fmt.Sprintf("\tOutliers (%s, outside %s to %s): %s of %s (%s)", r.Method, formatMs(r.Low), formatMs(r.High),
+		formatCount(int64(len(r.Outliers))), formatCount(int64(r.Total)), formatPercent(float64(len(r.Outliers))/float64(r.Total)))
+	var slowest []string
+	for i := len(r.Outliers) - 1; i >= 0 && len(slowest) < 10; i-- {
+		slowest = append(slowest, formatMs(r.Outliers[i]))
+	}
+	if len(slowest) < len(r.Outliers) {
+		slowest = append(slowest, "...")
+	}
+	if len(slowest) > 0 {
+		line += ": " + strings.Join(slowest, ", ")
+	}
+	fmt.Println(line)
+	if len(r.Outliers) == 0 || r.Trimmed.Count() == 0 {
+		return
+	}
+	var parts []string
+	for _, pct := range pcts {
+		parts = append(parts, percentileName(pct)+" "+formatMs(r.Trimmed.Percentile(pct)))
+	}
+	trimmed := latencyStats(r.Trimmed)
+	fmt.Printf("\t\twithout them: %s, mean %s, stddev %s\n", strings.Join(parts, ", "), formatMs(trimmed.Mean), formatMs(trimmed.StdDev))
+}
+
+type jsonOutliers struct {
+	Method           string             `json:"method"`
+	LowFenceMs       float64            `json:"low_fence_ms"`
+	HighFenceMs      float64            `json:"high_fence_ms"`
+	Count            int                `json:"count"`
+	ValuesMs         []float64          `json:"values_ms,omitempty"`
+	TrimmedLatencyMs map[string]float64 `json:"trimmed_latency_ms"`
+}
+
+func newJsonOutliers(r *OutlierReport, pcts []float64) *jsonOutliers {
+	if r == nil {
+		return nil
+	}
+	return &jsonOutliers{
+		Method:           r.Method,
+		LowFenceMs:       r.Low,
+		HighFenceMs:      r.High,
+		Count:            len(r.Outliers),
+		ValuesMs:         r.Outliers,
+		TrimmedLatencyMs: latencySummary(r.Trimmed, pcts),
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// naiveRun is the measurement a hand-written benchmark would make: a buffered channel bounding concurrency, a
+// WaitGroup, and a time.Since per request into a preallocated slice.
+type naiveRun struct {
+	throughputRps float64
+	responseTimes Collector
+}
+
+func runNaive(cfg RunConfig) naiveRun {
+	sem := make(chan struct{}, cfg.NumCoroutines)
+	latencies := make([]time.Duration, cfg.Iterations)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for x := 0; x < cfg.Iterations; x++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(x int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			begin := time.Now()
+			doWork(cfg, newRand(cfg.Seed, int64(x)), newRequestLog(x, cfg.LogLevel))
+			latencies[x] = time.Since(begin)
+		}(x)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	responseTimes, _ := newCollector("exact", 0, cfg.Iterations, nil)
+	for _, d := range latencies {
+		responseTimes.Add(float64(d) / float64(time.Millisecond))
+	}
+	return naiveRun{float64(cfg.Iterations) / elapsed.Seconds(), responseTimes}
+}
+
+// overheadCommand runs the same workload through the full harness and through runNaive, alternating between them so
+// that drift in the machine affects both alike, and reports how far apart they measure. It bounds what the harness's
+// executors, aggregation and middleware plumbing cost on this machine.
+func overheadCommand(args []string) error {
+	fs := flag.NewFlagSet("overhead", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s overhead [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	coroutines := fs.Int64("coroutines", 16, "number of concurrent requests")
+	requests := fs.Int("requests", 1000, "requests per round")
+	rounds := fs.Int("rounds", 3, "number of harness and naive runs to alternate")
+	workTime := fs.Duration("work-time", 5*time.Millisecond, "CPU time per request")
+	networkTime := fs.Duration("network-time", 55*time.Millisecond, "network time per request")
+	seed := fs.Int64("seed", 1, "seed for the workload")
+	fs.Parse(args)
+
+	if *coroutines < 1 || *requests < 1 || *rounds < 1 {
+		return errors.New("coroutines, requests and rounds must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg := RunConfig{
+		WorkTime:           *workTime,
+		NetworkTime:        *networkTime,
+		Splits:             5,
+		CPUDist:            Distribution{Kind: "fixed"},
+		NetworkDist:        Distribution{Kind: "fixed"},
+		NumCoroutines:      *coroutines,
+		Collector:          "exact",
+		BaselineIterations: 1,
+		Iterations:         *requests,
+		Seed:               *seed,
+		ResultBatch:        1,
+	}
+
+	fmt.Printf("Harness vs naive WaitGroup loop: %v CPU/%v Network per request, %d requests with %d co-routines\n",
+		cfg.WorkTime, cfg.NetworkTime, cfg.Iterations, cfg.NumCoroutines)
+	fmt.Printf("%-6s %12s %12s %8s %12s %12s %8s\n", "round", "harness rps", "naive rps", "delta", "harness p99", "naive p99", "delta")
+	var sumRps, sumP99 float64
+	for i := 1; i <= *rounds; i++ {
+		harness, err := runBenchmark(ctx, cfg, multiReporter{})
+		if err != nil {
+			return err
+		}
+		naive := runNaive(cfg)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		harnessP99, naiveP99 := harness.ResponseTimesPercentile(99), naive.responseTimes.Percentile(99)
+		rpsDelta := (harness.ThroughputRps/naive.throughputRps - 1) * 100
+		p99Delta := (harnessP99/naiveP99 - 1) * 100
+		sumRps += rpsDelta
+		sumP99 += p99Delta
+		fmt.Printf("%-6d %12.2f %12.2f %+7.2f%% %10.2fms %10.2fms %+7.2f%%\n",
+			i, harness.ThroughputRps, naive.throughputRps, rpsDelta, harnessP99, naiveP99, p99Delta)
+	}
+	fmt.Printf("Mean delta: throughput %+.2f%%, p99 %+.2f%%\n", sumRps/float64(*rounds), sumP99/float64(*rounds))
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// phaseNames are the parts of a request's response time whose distributions are reported with -phases: those of a
+// LatencyBudget, less the harness's cost, which is only known for the run as a whole.
+var phaseNames = budgetParts[:len(budgetParts)-1]
+
+// PhaseTimes is the distribution of the time a run's requests spent in one phase, in ms.
+type PhaseTimes struct {
+	Name  string
+	Times Collector
+}
+
+// phaseCollector records every completed request's time in each phase. Like the budget, it reads each request's
+// log, so it only runs with -phases.
+type phaseCollector struct {
+	phases []PhaseTimes
+}
+
+func newPhaseCollector(cfg RunConfig, expected int) (*phaseCollector, error) {
+	c := &phaseCollector{}
+	for _, name := range phaseNames {
+		times, err := newCollector(cfg.Collector, cfg.ReservoirSize, expected, newRand(cfg.Seed, collectorRandStream))
+		if err != nil {
+			return nil, err
+		}
+		c.phases = append(c.phases, PhaseTimes{Name: name, Times: times})
+	}
+	return c, nil
+}
+
+func (c *phaseCollector) add(result WorkResult) {
+	for i, d := range requestBudget(result).parts()[:len(c.phases)] {
+		c.phases[i].Times.Add(float64(d) / float64(time.Millisecond))
+	}
+}
+
+func (c *phaseCollector) merge(other *phaseCollector) {
+	for i := range c.phases {
+		c.phases[i].Times.Merge(other.phases[i].Times)
+	}
+}
+
+// results returns the phases any request spent time in, leaving out those that don't figure in the workload.
+func (c *phaseCollector) results() []PhaseTimes {
+	if c == nil {
+		return nil
+	}
+	var phases []PhaseTimes
+	for _, p := range c.phases {
+		if p.Times.Count() > 0 && p.Times.Percentile(100) > 0 {
+			phases = append(phases, p)
+		}
+	}
+	return phases
+}
+
+func outputPhases(phases []PhaseTimes, pcts []float64) {
+	if len(phases) == 0 {
+		return
+	}
+	fmt.Println("\tPhases:")
+	for _, p := range phases {
+		var parts []string
+		for _, pct := range pcts {
+			parts = append(parts, percentileName(pct)+" "+formatMs(p.Times.Percentile(pct)))
+		}
+		fmt.Printf("\t\t%-12s  %s, mean %s\n", p.Name, strings.Join(parts, ", "), formatMs(latencyStats(p.Times).Mean))
+	}
+}
+
+// phaseLatency keys each phase's latencySummary by its name, for the JSON summary.
+func phaseLatency(phases []PhaseTimes, pcts []float64) map[string]map[string]float64 {
+	if len(phases) == 0 {
+		return nil
+	}
+	summary := map[string]map[string]float64{}
+	for _, p := range phases {
+		summary[p.Name] = latencySummary(p.Times, pcts)
+	}
+	return summary
+}
+
+// plotPhases draws the tail of each phase, at the highest of the reported percentiles, against the coroutine count,
+// so that it shows which phase the tail latency grows in as concurrency rises. Phases keep their colour across series,
+// which are told apart by dashes.
+func plotPhases(results []BenchmarkResult, opts PlotOptions) error {
+	if len(results[0].Phases) == 0 {
+		return nil
+	}
+	pcts := results[0].Config.percentiles()
+	pct := pcts[len(pcts)-1]
+	plt := plot.New()
+	plt.Title.Text = percentileName(pct) + " of Each Phase vs. Number of Co-Routines"
+	plt.X.Label.Text = "Number of Co-Routines"
+	plt.Y.Label.Text = latencyLabel("Time in phase")
+	plt.Y.Min = 0
+	latencyTicks(&plt.Y)
+	labels, series := bySeries(results)
+	for s, label := range labels {
+		for i, name := range phaseNames {
+			name := name
+			line, err := sweepLine(plt, series[label], func(r BenchmarkResult) float64 { return r.phasePercentile(name, pct) }, plotutil.Color(i))
+			if err != nil {
+				return err
+			}
+			if line == nil {
+				continue
+			}
+			line.LineStyle.Width = vg.Points(1.5)
+			line.LineStyle.Dashes = plotutil.Dashes(s)
+			if len(labels) > 1 {
+				name += " " + label
+			}
+			plt.Legend.Add(name, line)
+		}
+	}
+	plt.Legend.Top = true
+	plt.Legend.Left = true
+	opts.fitCoroutines(&plt.X)
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("phases_vs_coroutines.png"))
+}
+
+// phasePercentile is NaN for a phase the run didn't record, which sweepLine leaves out.
+func (r BenchmarkResult) phasePercentile(name string, pct float64) float64 {
+	for _, p := range r.Phases {
+		if p.Name == name {
+			return p.Times.Percentile(pct)
+		}
+	}
+	return math.NaN()
+}
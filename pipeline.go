@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// PipelineWorkload processes requests with a fixed set of long-lived stage goroutines connected by channels instead of
+// running all of a request's work on its own goroutine. Each stage performs an equal share of the request's CPU and
+// network time and hands the request on to the next stage.
+type PipelineWorkload struct {
+	Stages          int
+	Buffer          int
+	WorkersPerStage int
+
+	input chan *pipelineMessage
+	wg    sync.WaitGroup
+}
+
+type pipelineMessage struct {
+	err  error
+	rand *rand.Rand
+	log  *RequestLog
+	done chan struct{}
+}
+
+func (p *PipelineWorkload) Start(cfg RunConfig) {
+	stageWork := cfg.WorkTime / time.Duration(p.Stages)
+	stageNetwork := cfg.NetworkTime / time.Duration(p.Stages)
+	clock := cfg.clock()
+	kernel := cpuKernelNamed(cfg.CPUKernel)
+
+	p.input = make(chan *pipelineMessage, p.Buffer)
+	in := p.input
+	for i := 0; i < p.Stages; i++ {
+		var out chan *pipelineMessage
+		if i < p.Stages-1 {
+			out = make(chan *pipelineMessage, p.Buffer)
+		}
+		var stageWg sync.WaitGroup
+		for w := 0; w < p.WorkersPerStage; w++ {
+			stageWg.Add(1)
+			go func(in <-chan *pipelineMessage, out chan<- *pipelineMessage) {
+				defer stageWg.Done()
+				for msg := range in {
+					if msg.err == nil {
+						doCpuWork(clock, kernel, cfg.CPUDist.Sample(msg.rand, stageWork), msg.log)
+						msg.err = doNetworkPhase(cfg, msg.rand, cfg.NetworkDist.Sample(msg.rand, stageNetwork), msg.log)
+					}
+					if out != nil {
+						out <- msg
+					} else {
+						close(msg.done)
+					}
+				}
+			}(in, out)
+		}
+		p.wg.Add(1)
+		go func(out chan *pipelineMessage) {
+			defer p.wg.Done()
+			stageWg.Wait()
+			if out != nil {
+				close(out)
+			}
+		}(out)
+		in = out
+	}
+}
+
+func (p *PipelineWorkload) Stop() {
+	close(p.input)
+	p.wg.Wait()
+}
+
+func doPipelineWork(clock Clock, p *PipelineWorkload, r *rand.Rand, log *RequestLog) error {
+	log.Begin(log.now(clock), "pipeline", "pipeline", 0)
+	msg := &pipelineMessage{rand: r, log: log, done: make(chan struct{})}
+	p.input <- msg
+	<-msg.done
+	log.End(log.now(clock))
+	return msg.err
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// comparedSweep is one of the sweeps plotted against each other: its runs and the label they are drawn under.
+type comparedSweep struct {
+	label   string
+	results []BenchmarkResult
+}
+
+// plotCommand draws sweeps saved with -save against each other: with -compare, the throughput and latency curves of
+// every sweep it is given go on the same axes, one colour per sweep, so that a before and after of a code or runtime
+// change can be read off one chart. Each sweep is labelled by its note, or else its file name, and the commit the tool
+// was built from; where a sweep has several series, they are told apart by dashes.
+func plotCommand(args []string) error {
+	fs := flag.NewFlagSet("plot", flag.ExitOnError)
+	compare := fs.Bool("compare", false, "overlay the throughput and latency curves of every sweep given on shared axes")
+	labels := fs.String("labels", "", "comma-separated labels for the sweeps, in the order given (default: each sweep's note, or else its file name, and its commit)")
+	pct := fs.Float64("percentile", 99, "latency percentile to compare")
+	outDir := fs.String("out-dir", "", "write plots to a new directory under this one, as a sweep does (default: fixed names in the working directory)")
+	plotOptions := plotFlags(fs)
+	applyUnits := unitFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s plot -compare [flags] a.json b.json...\n\n"+
+			"Overlays sweeps saved with -save on shared axes. To render the plots of one sweep, use render.\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if !*compare || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *pct <= 0 || *pct >= 100 {
+		return errors.New("-percentile must be between 0 and 100")
+	}
+	opts, err := plotOptions()
+	if err != nil {
+		return err
+	}
+	if err := applyUnits(); err != nil {
+		return err
+	}
+	var names []string
+	if *labels != "" {
+		names = strings.Split(*labels, ",")
+		if len(names) != fs.NArg() {
+			return fmt.Errorf("-labels names %d sweeps, but %d were given", len(names), fs.NArg())
+		}
+	}
+
+	var sweeps []comparedSweep
+	seen := map[string]bool{}
+	for i, path := range fs.Args() {
+		sweep, err := loadSweep(path)
+		if err != nil {
+			return err
+		}
+		if len(sweep.Runs) == 0 {
+			return fmt.Errorf("%s holds no runs", path)
+		}
+		c := comparedSweep{label: sweepLabel(path, sweep)}
+		if names != nil {
+			c.label = strings.TrimSpace(names[i])
+		} else if seen[c.label] {
+			// Two sweeps with the same note on the same commit; their files at least differ.
+			c.label = filepath.Base(path)
+		}
+		seen[c.label] = true
+		for _, run := range sweep.Runs {
+			c.results = append(c.results, run.result())
+		}
+		sweeps = append(sweeps, c)
+		env := sweep.Environment
+		fmt.Printf("%s: %s, %d runs, started %s: %s\n", c.label, filepath.Base(path), len(sweep.Runs), env.Started.Format(time.RFC3339), env)
+	}
+
+	if *outDir != "" {
+		if opts.Out, err = newArtifactStore(*outDir, time.Now()); err != nil {
+			return err
+		}
+		defer opts.Out.Close()
+		fmt.Printf("Writing artifacts to %s\n", opts.Out.dir)
+	}
+	if err := plotCompared(sweeps, "Throughput", throughputLabel("Throughput"), "throughput_compare.png", false, opts,
+		func(r BenchmarkResult) float64 { return r.ThroughputRps }); err != nil {
+		return err
+	}
+	name := percentileName(*pct)
+	return plotCompared(sweeps, name+" Response Time", latencyLabel(name+" response time"), "latency_compare.png", true, opts,
+		func(r BenchmarkResult) float64 { return r.ResponseTimesPercentile(*pct) })
+}
+
+// sweepLabel names a saved sweep after its note, if every run has the same one, or else its file, followed by the
+// commit it was measured on where that was recorded.
+func sweepLabel(path string, sweep savedSweep) string {
+	label := sweep.Runs[0].Note
+	for _, run := range sweep.Runs {
+		if run.Note != label {
+			label = ""
+			break
+		}
+	}
+	if label == "" {
+		label = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if commit := sweep.Environment.ToolCommit; commit != "" {
+		label += " @ " + commit
+	}
+	return label
+}
+
+// plotCompared draws y against the co-routine count for every series of every sweep on one plot and writes it to file.
+// With latency, y goes on a latency axis, scaled as the plot options say, and is otherwise a throughput.
+func plotCompared(sweeps []comparedSweep, title, yLabel, file string, latency bool, opts PlotOptions, y func(BenchmarkResult) float64) error {
+	plt := plot.New()
+	plt.Title.Text = title + " vs. Number of Co-Routines"
+	plt.X.Label.Text = "Number of Co-Routines"
+	plt.Y.Label.Text = yLabel
+	var all []BenchmarkResult
+	for _, s := range sweeps {
+		all = append(all, s.results...)
+	}
+	if latency {
+		opts.latencyAxis(&plt.Y, lowestLatency(all))
+	} else {
+		plt.Y.Min = 0
+		throughputTicks(&plt.Y)
+	}
+
+	for i, s := range sweeps {
+		labels, series := bySeries(s.results)
+		for j, label := range labels {
+			line, err := sweepLine(plt, series[label], y, plotutil.Color(i))
+			if err != nil {
+				return err
+			}
+			if line == nil {
+				continue
+			}
+			line.LineStyle.Width = vg.Points(2)
+			if len(labels) > 1 {
+				line.LineStyle.Dashes = plotutil.Dashes(j)
+				label = s.label + ": " + label
+			} else {
+				label = s.label
+			}
+			plt.Legend.Add(label, line)
+		}
+	}
+	plt.Legend.Top = true
+	plt.Legend.Left = true
+
+	if err := addFailedMarks(plt, all, y); err != nil {
+		return err
+	}
+	opts.fitCoroutines(&plt.X)
+	if latency {
+		opts.fitLatency(&plt.Y)
+	}
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile(file))
+}
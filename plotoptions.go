@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+)
+
+// PlotOptions adjust the PNGs the plots reporter writes. The zero value draws every plot at its default size, on
+// linear axes fitted to the data.
+type PlotOptions struct {
+	Width, Height              vg.Length // zero keeps each plot's default size
+	LogLatency                 bool      // latency axes on a log scale, which keeps the tail readable next to the median
+	LatencyMin, LatencyMax     float64   // latency axis range in ms; zero leaves that bound to the data
+	CoroutineMin, CoroutineMax float64   // coroutine count axis range; zero leaves that bound to the data
+	Format                     string    // png, svg or pdf; empty for png
+	DPI                        int       // resolution of PNGs; zero for gonum's default of 96
+	Memory                     bool      // also plot memory over each run and its peak against the co-routine count
+	Cores                      bool      // also plot each CPU's utilization over each run, and the busiest against the co-routine count
+	KneeThreshold              float64   // marks each series' saturation knee on the throughput plot, as found by findKnee; zero for none
+	HistBins                   int       // bins of response time histograms; zero for defaultHistBins
+	HistBinWidth               float64   // or the width of each bin in ms, overriding HistBins; zero to divide the range
+	HistMin, HistMax           float64   // range of response time histograms in ms; zero leaves that bound to the data
+	HistGrid                   bool      // also draw every run's histogram as small multiples
+	Speedup                    string    // basis of the speedups drawn and printed, one of speedupBases; empty for speedupSerial
+	Out                        *artifactStore
+}
+
+var plotFormats = []string{"png", "svg", "pdf"}
+
+// plotFlags declares the flags that adjust plots on fs and returns a function building the PlotOptions they select,
+// to be called once fs is parsed.
+func plotFlags(fs *flag.FlagSet) func() (PlotOptions, error) {
+	size := fs.String("plot-size", "", "size of every plot in inches, e.g. 8x5 (default: each plot's own size)")
+	format := fs.String("plot-format", "png", "format of the plots: "+strings.Join(plotFormats, ", "))
+	dpi := fs.Int("plot-dpi", 0, "resolution of PNG plots in dots per inch (default: 96)")
+	logLatency := fs.Bool("plot-log-latency", false, "draw latency axes on a log scale, which keeps the tail readable next to the median")
+	latencyRange := fs.String("plot-latency-range", "", "latency axis range in ms as min:max, either bound may be left empty, e.g. :200")
+	coroutineRange := fs.String("plot-coroutine-range", "", "co-routine axis range as min:max, either bound may be left empty")
+	memory := fs.Bool("plot-memory", false, "also plot RSS and heap over each run and their peaks against the co-routine count")
+	speedup := fs.String("speedup", speedupSerial, "what speedups are relative to, in plots and console output: serial (the serial baseline), single (the same configuration's run at one co-routine, so that n at n co-routines is perfect scaling) or ideal (the throughput Amdahl's law allows at the run's co-routine count, so that 1 is all the machine could give). Results files always record the serial speedup")
+	histBins := fs.Int("plot-hist-bins", defaultHistBins, "number of bins of the response time histograms")
+	histBinWidth := fs.Float64("plot-hist-bin-width", 0, "width of each bin of the response time histograms in ms, instead of a number of them")
+	histRange := fs.String("plot-hist-range", "", "range of the response time histograms in ms as min:max, either bound may be left empty; requests outside it are counted in the title, not drawn")
+	histGrid := fs.Bool("plot-hist-grid", false, "also draw every run's response time histogram as small multiples on shared axes, to hist_grid.png")
+	cores := fs.Bool("plot-cores", false, "also plot each CPU's utilization over each run, stacked, and the busiest and mean core against the co-routine count, which tells a single saturated core from the machine running out of CPU; Linux only")
+	return func() (PlotOptions, error) {
+		o := PlotOptions{LogLatency: *logLatency, Format: *format, DPI: *dpi, Memory: *memory, Cores: *cores,
+			HistBins: *histBins, HistBinWidth: *histBinWidth, HistGrid: *histGrid, Speedup: *speedup}
+		if err := validSpeedupBasis(*speedup); err != nil {
+			return o, err
+		}
+		switch *format {
+		case "png", "svg", "pdf":
+		default:
+			return o, fmt.Errorf("unknown plot format %q, expected one of %s", *format, strings.Join(plotFormats, ", "))
+		}
+		if *dpi < 0 {
+			return o, errors.New("-plot-dpi must not be negative")
+		}
+		if *histBins < 1 || *histBinWidth < 0 {
+			return o, errors.New("-plot-hist-bins must be at least 1 and -plot-hist-bin-width must not be negative")
+		}
+		var err error
+		if *size != "" {
+			if o.Width, o.Height, err = parsePlotSize(*size); err != nil {
+				return o, err
+			}
+		}
+		if *latencyRange != "" {
+			if o.LatencyMin, o.LatencyMax, err = parseAxisRange(*latencyRange); err != nil {
+				return o, err
+			}
+		}
+		if *histRange != "" {
+			if o.HistMin, o.HistMax, err = parseAxisRange(*histRange); err != nil {
+				return o, err
+			}
+		}
+		if *coroutineRange != "" {
+			if o.CoroutineMin, o.CoroutineMax, err = parseAxisRange(*coroutineRange); err != nil {
+				return o, err
+			}
+		}
+		return o, nil
+	}
+}
+
+// latencyAxis sets up a latency axis before anything is plotted on it. floor is the lowest latency to be drawn,
+// where a log scale starts instead of at zero. Latencies are plotted in ms whatever -latency-unit is, and ticked in it.
+func (o PlotOptions) latencyAxis(a *plot.Axis, floor float64) {
+	a.Min = 0
+	if o.LogLatency && floor > 0 {
+		a.Min = floor
+		a.Scale = plot.LogScale{}
+		a.Tick.Marker = plot.LogTicks{}
+	}
+	latencyTicks(a)
+}
+
+// fitLatency and fitCoroutines apply the explicit axis ranges once everything is plotted.
+func (o PlotOptions) fitLatency(a *plot.Axis) {
+	fitAxis(a, o.LatencyMin, o.LatencyMax)
+}
+
+func (o PlotOptions) fitCoroutines(a *plot.Axis) {
+	fitAxis(a, o.CoroutineMin, o.CoroutineMax)
+}
+
+func fitAxis(a *plot.Axis, min, max float64) {
+	if min != 0 {
+		a.Min = min
+	}
+	if max != 0 {
+		a.Max = max
+	}
+}
+
+// runFile and sweepFile return where to write the plot called name, made for one run or for the whole sweep, with
+// its extension replaced to match the configured format.
+func (o PlotOptions) runFile(result BenchmarkResult, name string) string {
+	return o.Out.path(o.withFormat(name), &result.Config)
+}
+
+// histFile is where a run's histogram goes: named after the run even without an artifact directory, so that the runs
+// of a sweep each keep their own.
+func (o PlotOptions) histFile(result BenchmarkResult) string {
+	if o.Out == nil {
+		return o.withFormat(runFileName("hist.png", result.Config, nil))
+	}
+	return o.runFile(result, "hist.png")
+}
+
+func (o PlotOptions) sweepFile(name string) string {
+	return o.Out.path(o.withFormat(name), nil)
+}
+
+func (o PlotOptions) withFormat(name string) string {
+	if o.Format == "" {
+		return name
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + o.Format
+}
+
+// save writes plt to file at the configured size, or width by height if none was set.
+func (o PlotOptions) save(plt *plot.Plot, width, height vg.Length, file string) error {
+	return o.saveCanvas(width, height, file, plt.Draw)
+}
+
+// saveCanvas is save for figures that draw more than one plot.
+func (o PlotOptions) saveCanvas(width, height vg.Length, file string, paint func(draw.Canvas)) error {
+	if o.Width > 0 && o.Height > 0 {
+		width, height = o.Width, o.Height
+	}
+	var c vg.CanvasWriterTo
+	if o.DPI > 0 && filepath.Ext(file) == ".png" {
+		c = vgimg.PngCanvas{Canvas: vgimg.NewWith(vgimg.UseWH(width, height), vgimg.UseDPI(o.DPI))}
+	} else {
+		var err error
+		if c, err = draw.NewFormattedCanvas(width, height, strings.TrimPrefix(filepath.Ext(file), ".")); err != nil {
+			return err
+		}
+	}
+	paint(draw.New(c))
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := c.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// lowestLatency is the fastest response time of any run, or zero if none completed a request.
+func lowestLatency(results []BenchmarkResult) float64 {
+	lowest := math.Inf(1)
+	for _, result := range results {
+		for _, v := range result.ResponseTimes.Values() {
+			if v > 0 && v < lowest {
+				lowest = v
+			}
+		}
+	}
+	if math.IsInf(lowest, 1) {
+		return 0
+	}
+	return lowest
+}
+
+// parsePlotSize parses a size in inches such as "6x4".
+func parsePlotSize(s string) (vg.Length, vg.Length, error) {
+	x := strings.Index(s, "x")
+	if x < 0 {
+		return 0, 0, fmt.Errorf("plot size %q is not of the form WIDTHxHEIGHT", s)
+	}
+	w, err := strconv.ParseFloat(s[:x], 64)
+	if err != nil || w <= 0 {
+		return 0, 0, fmt.Errorf("plot size %q needs a positive width", s)
+	}
+	h, err := strconv.ParseFloat(s[x+1:], 64)
+	if err != nil || h <= 0 {
+		return 0, 0, fmt.Errorf("plot size %q needs a positive height", s)
+	}
+	return vg.Length(w) * vg.Inch, vg.Length(h) * vg.Inch, nil
+}
+
+// parseAxisRange parses "min:max", where either bound may be left empty.
+func parseAxisRange(s string) (float64, float64, error) {
+	colon := strings.Index(s, ":")
+	if colon < 0 {
+		return 0, 0, fmt.Errorf("axis range %q is not of the form min:max", s)
+	}
+	var bounds [2]float64
+	for i, part := range []string{s[:colon], s[colon+1:]} {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("axis range %q: %w", s, err)
+		}
+		bounds[i] = v
+	}
+	if bounds[1] != 0 && bounds[0] >= bounds[1] {
+		return 0, 0, fmt.Errorf("axis range %q is empty", s)
+	}
+	return bounds[0], bounds[1], nil
+}
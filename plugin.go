@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pluginProtocol is the version of the plugin protocol this build speaks.
+const pluginProtocol = 1
+
+// PluginWorkload replaces a request's simulated work with a request to a plugin: a long-running process, in any
+// language, that does whatever a request should, so that proprietary request logic can be measured without forking
+// this repository. The plugin is started once, and speaks line-delimited JSON on its standard input and output:
+//
+//	plugin → perf, first:   {"protocol": 1, "name": "checkout"}
+//	perf → plugin, per request: {"id": 17}
+//	plugin → perf, per reply:   {"id": 17} or {"id": 17, "error": "out of stock"}
+//
+// Requests are sent as the co-routines issue them, without waiting for earlier replies, so a plugin that handles them
+// concurrently is measured at the run's concurrency; replies may come in any order. A reply with an error fails its
+// request. The plugin's standard error is passed through, and closing its standard input asks it to exit.
+type PluginWorkload struct {
+	Command string
+	Name    string // as the plugin announced itself
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu      sync.Mutex
+	next    int64
+	pending map[int64]chan error
+	err     error // why the plugin can no longer serve requests, once it can't
+}
+
+type pluginHello struct {
+	Protocol int    `json:"protocol"`
+	Name     string `json:"name"`
+}
+
+type pluginRequest struct {
+	ID int64 `json:"id"`
+}
+
+type pluginReply struct {
+	ID    int64  `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// startPlugin starts command, split into arguments on spaces, and waits for it to announce itself.
+func startPlugin(command string) (*PluginWorkload, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("empty -plugin command")
+	}
+	p := &PluginWorkload{Command: command, cmd: exec.Command(args[0], args[1:]...), pending: map[int64]chan error{}}
+	p.cmd.Stderr = os.Stderr
+	var err error
+	if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", command, err)
+	}
+	replies := bufio.NewScanner(stdout)
+	replies.Buffer(make([]byte, 64<<10), 1<<20)
+	var hello pluginHello
+	if !replies.Scan() {
+		p.Close()
+		return nil, fmt.Errorf("plugin %s exited without announcing itself", command)
+	}
+	if err := json.Unmarshal(replies.Bytes(), &hello); err != nil || hello.Protocol != pluginProtocol {
+		p.Close()
+		return nil, fmt.Errorf("plugin %s announced %q, expected protocol %d", command, replies.Bytes(), pluginProtocol)
+	}
+	p.Name = hello.Name
+	go p.read(replies)
+	return p, nil
+}
+
+// read hands each reply to the request waiting for it, until the plugin's output ends, which fails every request
+// still waiting and every one after.
+func (p *PluginWorkload) read(replies *bufio.Scanner) {
+	for replies.Scan() {
+		var reply pluginReply
+		if err := json.Unmarshal(replies.Bytes(), &reply); err != nil {
+			p.fail(fmt.Errorf("plugin %s replied %q: %w", p, replies.Bytes(), err))
+			return
+		}
+		p.mu.Lock()
+		done, ok := p.pending[reply.ID]
+		delete(p.pending, reply.ID)
+		p.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if reply.Error != "" {
+			done <- errors.New(reply.Error)
+		} else {
+			done <- nil
+		}
+	}
+	err := replies.Err()
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	p.fail(fmt.Errorf("plugin %s stopped replying: %w", p, err))
+}
+
+func (p *PluginWorkload) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+	for id, done := range p.pending {
+		done <- p.err
+		delete(p.pending, id)
+	}
+}
+
+// do sends the plugin a request and waits for its reply.
+func (p *PluginWorkload) do() error {
+	done := make(chan error, 1)
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return p.err
+	}
+	p.next++
+	id := p.next
+	p.pending[id] = done
+	b, _ := json.Marshal(pluginRequest{ID: id})
+	// Writes are whole lines under the lock, so that concurrent requests don't interleave.
+	_, err := p.stdin.Write(append(b, '\n'))
+	p.mu.Unlock()
+	if err != nil {
+		p.fail(fmt.Errorf("plugin %s: %w", p, err))
+	}
+	return <-done
+}
+
+// Calibrate returns the median time one request takes on an idle machine, to serve as the run's WorkTime so that CPU
+// utilization and speedup keep their meaning, as ExecWorkload's does.
+func (p *PluginWorkload) Calibrate() (time.Duration, error) {
+	var samples []time.Duration
+	for i := 0; i < 9; i++ {
+		start := time.Now()
+		// A request may fail; only a plugin that has stopped replying is an error.
+		p.do()
+		samples = append(samples, time.Since(start))
+		p.mu.Lock()
+		err := p.err
+		p.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[len(samples)/2], nil
+}
+
+// Close asks the plugin to exit by closing its input, and waits for it to.
+func (p *PluginWorkload) Close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+func (p *PluginWorkload) String() string {
+	if p.Name != "" {
+		return p.Name + " (" + p.Command + ")"
+	}
+	return p.Command
+}
+
+// Plugins do their work in a process of their own and so always run on wall-clock time.
+func doPluginWork(p *PluginWorkload, log *RequestLog) error {
+	log.Begin(log.now(realClock{}), "plugin", "plugin", 0)
+	err := p.do()
+	end := log.now(realClock{})
+	if err != nil && log.Enabled(LogDebug) {
+		log.Debugf(end, "%s: %v", p, err)
+	}
+	log.End(end)
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// precisionTracker lets a run keep issuing requests until the chosen percentile of its response times is known
+// precisely enough, the way testing.B grows b.N until a benchmark has run long enough. The percentile's standard
+// error is estimated from the spread of the order statistics within one binomial standard error of its rank, which
+// needs no assumption about the shape of the distribution.
+type precisionTracker struct {
+	percentile float64
+	target     float64 // relative standard error at which the run may stop
+
+	mu      sync.Mutex
+	values  []float64
+	next    int // count at which precision is next estimated
+	rse     float64
+	reached int32
+}
+
+// tailSamples is how many requests must be slower than the tracked percentile before its precision is estimated at
+// all; with fewer, the spread of the tail says nothing.
+const tailSamples = 10
+
+// newPrecisionTracker returns nil when cfg runs a fixed number of requests; all methods are safe to call on nil.
+func newPrecisionTracker(cfg RunConfig) *precisionTracker {
+	if cfg.Precision <= 0 {
+		return nil
+	}
+	minimum := cfg.Iterations
+	if tail := int(math.Ceil(tailSamples / (1 - cfg.PrecisionPercentile/100))); tail > minimum {
+		minimum = tail
+	}
+	return &precisionTracker{
+		percentile: cfg.PrecisionPercentile,
+		target:     cfg.Precision,
+		next:       minimum, // requests that must complete first, however precise they look
+		rse:        math.Inf(1),
+	}
+}
+
+// observe records a completed request's response time. Precision is re-estimated each time the count grows by a
+// tenth, so that sorting stays a small part of the run, until it is reached; requests still in flight then don't
+// change the estimate the run stopped on.
+func (t *precisionTracker) observe(ms float64) {
+	if t == nil || t.Reached() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.values = append(t.values, ms)
+	if len(t.values) < t.next {
+		return
+	}
+	t.next = len(t.values) + len(t.values)/10 + 1
+	sort.Float64s(t.values)
+	t.rse = percentileRSE(t.values, t.percentile)
+	if t.rse <= t.target {
+		atomic.StoreInt32(&t.reached, 1)
+	}
+}
+
+// Reached reports whether the percentile is known to within the target.
+func (t *precisionTracker) Reached() bool {
+	return t != nil && atomic.LoadInt32(&t.reached) == 1
+}
+
+// RSE is the relative standard error last estimated, infinite before the first estimate, or zero for a run of fixed
+// length.
+func (t *precisionTracker) RSE() float64 {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rse
+}
+
+// percentileRSE estimates the relative standard error of percentile pct of sorted: the number of values below the
+// true percentile is binomial, so the values a standard error of rank either side of it bound the estimate.
+func percentileRSE(sorted []float64, pct float64) float64 {
+	n := len(sorted)
+	if n < 2 {
+		return math.Inf(1)
+	}
+	q := pct / 100
+	rank := q * float64(n-1)
+	se := math.Sqrt(float64(n) * q * (1 - q))
+	lo := int(math.Max(math.Floor(rank-se), 0))
+	hi := int(math.Min(math.Ceil(rank+se), float64(n-1)))
+	v := sorted[int(math.Round(rank))]
+	if v <= 0 {
+		return math.Inf(1)
+	}
+	return (sorted[hi] - sorted[lo]) / 2 / v
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// primitiveExecutors are the bounded-concurrency primitives the primitives scenario compares, by executor name.
+var primitiveExecutors = []struct {
+	executor  string
+	primitive string
+}{
+	{"semaphore", "semaphore.Weighted"},
+	{"channel", "buffered-channel token pool"},
+	{"pool", "sync.WaitGroup + worker pool"},
+	{"errgroup", "errgroup.SetLimit"},
+}
+
+// dispatchOverhead is what it costs executor to run a function that does nothing, per function: the dispatch, the
+// goroutine or handoff it takes, and the bookkeeping around it, averaged over n functions at the given limit.
+func dispatchOverhead(ctx context.Context, executor string, limit int64, n int) (time.Duration, error) {
+	e, err := newExecutor(ctx, executor, limit)
+	if err != nil {
+		return 0, err
+	}
+	noop := func() error { return nil }
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		e.Go(noop)
+	}
+	if err := e.Wait(); err != nil {
+		return 0, err
+	}
+	return time.Since(start) / time.Duration(n), nil
+}
+
+// primitivesScenario compares the ways Go code commonly bounds its concurrency: first what each costs per dispatch
+// with nothing to run, which is where they differ most, then how each carries the same simulated workload as the
+// co-routine count rises, where that cost is usually lost in the requests' own time.
+func primitivesScenario(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("primitives", flag.ExitOnError)
+	coroutines := fs.String("coroutines", "1,4,16,64,256", "co-routine counts to compare the primitives at, as for the sweep's -coroutines")
+	dispatches := fs.Int("dispatches", 200000, "no-op functions dispatched to measure each primitive's overhead")
+	requests := fs.Int("requests", 500, "requests per run of the workload")
+	workTime := fs.Duration("work-time", time.Millisecond, "CPU time per request")
+	networkTime := fs.Duration("network-time", 20*time.Millisecond, "network time per request")
+	reporters := fs.String("report", "", "comma-separated reporters to enable for the workload's runs: console, json, prometheus, plots, tui (default: only the summary below)")
+	jsonOut := fs.String("json-out", "results.jsonl", "file the json reporter writes one result per line to")
+	seed := fs.Int64("seed", 1, "seed for the workload")
+	fs.Parse(args)
+
+	if *dispatches < 1 || *requests < 1 {
+		return errors.New("dispatches and requests must be positive")
+	}
+	counts, err := parseSweepValues(*coroutines, "co-routine count", 1)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Dispatch overhead per no-op function, %d dispatches:\n", *dispatches)
+	fmt.Printf("%-30s", "primitive")
+	for _, n := range counts {
+		fmt.Printf(" %10s", fmt.Sprintf("c=%d", n))
+	}
+	fmt.Println()
+	for _, p := range primitiveExecutors {
+		// A first, discarded round warms up the scheduler's goroutine and channel caches.
+		if _, err := dispatchOverhead(ctx, p.executor, counts[0], *dispatches/10+1); err != nil {
+			return err
+		}
+		fmt.Printf("%-30s", p.primitive)
+		for _, n := range counts {
+			d, err := dispatchOverhead(ctx, p.executor, n, *dispatches)
+			if err != nil {
+				return err
+			}
+			fmt.Printf(" %10s", formatDuration(d))
+			if err := ctx.Err(); err != nil {
+				fmt.Println()
+				return err
+			}
+		}
+		fmt.Println()
+	}
+
+	reporter, err := newReporter(strings.Split(*reporters, ","), *jsonOut, "", PlotOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := reporter.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	recorder := &resultRecorder{}
+	sweep := Sweep{Coroutines: counts, GOMAXPROCS: []int{0}}
+	for _, p := range primitiveExecutors {
+		sweep.Executors = append(sweep.Executors, p.executor)
+	}
+	base := RunConfig{
+		WorkTime:           *workTime,
+		NetworkTime:        *networkTime,
+		Splits:             5,
+		CPUDist:            Distribution{Kind: "fixed"},
+		NetworkDist:        Distribution{Kind: "fixed"},
+		Collector:          "exact",
+		BaselineIterations: 10,
+		Iterations:         *requests,
+		Seed:               *seed,
+		ResultBatch:        1,
+		Scenario:           "primitives",
+	}
+	fmt.Printf("\n%v CPU/%v Network per request, %d requests per run:\n", base.WorkTime, base.NetworkTime, base.Iterations)
+	if err := throughputBenchmark(ctx, base, sweep, append(reporter, recorder)); err != nil {
+		return err
+	}
+
+	// Throughput is shown against the first primitive's at the same count, and as scaling efficiency: the share of the
+	// throughput linear scaling from the smallest count would reach.
+	runs := map[string]map[int64]BenchmarkResult{}
+	for _, r := range recorder.results {
+		if runs[r.Config.Executor] == nil {
+			runs[r.Config.Executor] = map[int64]BenchmarkResult{}
+		}
+		runs[r.Config.Executor][r.NumCoroutines] = r
+	}
+	fmt.Printf("%-30s %8s %12s %12s %10s %10s\n", "primitive", "c", "rps", "vs "+primitiveExecutors[0].executor, "p99 ms", "scaling")
+	reference := runs[primitiveExecutors[0].executor]
+	for _, p := range primitiveExecutors {
+		first := runs[p.executor][counts[0]]
+		for _, n := range counts {
+			r, ok := runs[p.executor][n]
+			if !ok {
+				continue
+			}
+			scaling := "-"
+			if n != counts[0] && first.ThroughputRps > 0 {
+				ideal := first.ThroughputRps * float64(n) / float64(counts[0])
+				scaling = formatPercent(r.ThroughputRps / ideal)
+			}
+			fmt.Printf("%-30s %8d %12.1f %12s %10.2f %10s\n", p.primitive, n, r.ThroughputRps,
+				formatPercent(r.ThroughputRps/reference[n].ThroughputRps), r.ResponseTimesPercentile(99), scaling)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// How requests waiting for a co-routine are picked to run next, with -dispatch. Without it they wait in the executor's
+// own queue, where the load generator waits with them.
+const (
+	// dispatchFIFO runs them in the order they arrived, whatever their class.
+	dispatchFIFO = "fifo"
+	// dispatchStrict always runs the waiting request of the highest priority class first, in arrival order within a
+	// class, so that a busy high-priority class can starve the others.
+	dispatchStrict = "strict"
+	// dispatchWeighted shares co-routines out among the classes with requests waiting in proportion to their
+	// priorities, by smooth weighted round robin, so that every class keeps moving however busy the others are.
+	dispatchWeighted = "weighted"
+)
+
+var dispatchPolicies = []string{dispatchFIFO, dispatchStrict, dispatchWeighted}
+
+func validDispatch(policy string) error {
+	for _, p := range dispatchPolicies {
+		if policy == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown dispatch policy %q, expected one of %s", policy, strings.Join(dispatchPolicies, ", "))
+}
+
+// applyPriorities parses -priority, a comma-separated list of class=priority pairs such as "checkout=3,browse=1",
+// into the priorities of the traffic mix's classes. Classes left out have a priority of one; higher runs sooner.
+func (m TrafficMix) applyPriorities(s string) error {
+	for i := range m {
+		m[i].Priority = 1
+	}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		eq := strings.Index(entry, "=")
+		if eq <= 0 {
+			return fmt.Errorf("priority %q is not of the form class=priority", entry)
+		}
+		priority, err := strconv.Atoi(entry[eq+1:])
+		if err != nil || priority < 1 {
+			return fmt.Errorf("priority %q must be a positive integer", entry)
+		}
+		found := false
+		for i := range m {
+			if m[i].Name == entry[:eq] {
+				m[i].Priority, found = priority, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("priority for %q, which is not a class of the traffic mix", entry[:eq])
+		}
+	}
+	return nil
+}
+
+// prioritized reports whether the run dispatches requests by the priorities of their classes.
+func (cfg RunConfig) prioritized() bool {
+	return cfg.Dispatch == dispatchStrict || cfg.Dispatch == dispatchWeighted
+}
+
+// classOf is the class request x is of, the same requestContext gives it when it runs, for dispatching it before then,
+// or "" when the run doesn't dispatch by class.
+func (cfg RunConfig) classOf(x int) string {
+	if !cfg.prioritized() {
+		return ""
+	}
+	return cfg.Mix.pick(newRand(cfg.Seed, int64(x))).Name
+}
+
+// priorityDispatcher queues requests by class in front of the executor, handing it the next one by cfg.Dispatch
+// whenever a co-routine frees up. Like the admission queue, dispatching never blocks the load generator, so that each
+// request's queue wait runs from when it was due; unlike the executor's own queue, a request that arrives later can
+// run sooner than the ones already waiting.
+type priorityDispatcher struct {
+	policy   string
+	executor Executor
+	classes  map[string]*dispatchClass
+	order    []*dispatchClass // highest priority first, in mix order among equals
+	slots    chan struct{}    // one per co-routine, held from dispatch until the request returns
+	refused  func()           // called for a request the executor refuses
+
+	mu     sync.Mutex
+	ready  *sync.Cond
+	queued int
+	closed bool
+	done   chan struct{}
+}
+
+type dispatchClass struct {
+	priority int
+	waiting  []func() error
+	credit   int // for smooth weighted round robin
+}
+
+func newPriorityDispatcher(cfg RunConfig, executor Executor, refused func()) *priorityDispatcher {
+	d := &priorityDispatcher{policy: cfg.Dispatch, executor: executor, classes: map[string]*dispatchClass{},
+		slots: make(chan struct{}, cfg.NumCoroutines), refused: refused, done: make(chan struct{})}
+	d.ready = sync.NewCond(&d.mu)
+	classes := cfg.Mix
+	if !cfg.prioritized() {
+		classes = TrafficMix{{Priority: 1}} // one queue, in arrival order
+	}
+	for _, class := range classes {
+		c := &dispatchClass{priority: class.Priority}
+		d.classes[class.Name] = c
+		i := len(d.order)
+		for i > 0 && d.order[i-1].priority < c.priority {
+			i--
+		}
+		d.order = append(d.order, nil)
+		copy(d.order[i+1:], d.order[i:])
+		d.order[i] = c
+	}
+	go d.feed()
+	return d
+}
+
+// submit queues fn, a request of the named class.
+func (d *priorityDispatcher) submit(class string, fn func() error) {
+	d.mu.Lock()
+	c := d.classes[class]
+	c.waiting = append(c.waiting, fn)
+	d.queued++
+	d.mu.Unlock()
+	d.ready.Signal()
+}
+
+// close stops taking requests and returns once every queued one has reached the executor, whose Wait covers it from
+// there.
+func (d *priorityDispatcher) close() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.ready.Broadcast()
+	<-d.done
+}
+
+func (d *priorityDispatcher) feed() {
+	defer close(d.done)
+	for {
+		d.slots <- struct{}{}
+		d.mu.Lock()
+		for d.queued == 0 && !d.closed {
+			d.ready.Wait()
+		}
+		if d.queued == 0 {
+			d.mu.Unlock()
+			return
+		}
+		fn := d.next()
+		d.mu.Unlock()
+		if !d.executor.Go(func() error {
+			defer func() { <-d.slots }()
+			return fn()
+		}) {
+			<-d.slots
+			d.refused()
+		}
+	}
+}
+
+// next takes the request to run next off its class's queue. The caller holds mu, and at least one is queued.
+func (d *priorityDispatcher) next() func() error {
+	var pick *dispatchClass
+	if d.policy == dispatchStrict {
+		for _, c := range d.order {
+			if len(c.waiting) > 0 {
+				pick = c
+				break
+			}
+		}
+	} else {
+		total := 0
+		for _, c := range d.order {
+			if len(c.waiting) > 0 {
+				c.credit += c.priority
+				total += c.priority
+				if pick == nil || c.credit > pick.credit {
+					pick = c
+				}
+			}
+		}
+		pick.credit -= total
+	}
+	fn := pick.waiting[0]
+	pick.waiting[0] = nil
+	pick.waiting = pick.waiting[1:]
+	d.queued--
+	return fn
+}
+
+// plotClassLatency draws each request class's p99 latency as a caller saw it, queue wait included, against the
+// co-routine count, for open-loop sweeps with a traffic mix: where the dispatch policy favors one class over another,
+// and where a low priority class starves, shows up as the lines part.
+func plotClassLatency(results []BenchmarkResult, opts PlotOptions) error {
+	if len(results[0].Classes) < 2 || results[0].Classes[0].CallerTimes == nil {
+		return nil
+	}
+	plt := plot.New()
+	plt.Title.Text = "Latency by Request Class vs. Number of Co-Routines"
+	plt.X.Label.Text = "Number of Co-Routines"
+	plt.Y.Label.Text = latencyLabel("p99 response time, queue wait included")
+	labels, series := bySeries(results)
+	color := 0
+	for _, label := range labels {
+		for i, class := range results[0].Classes {
+			i := i
+			line, err := sweepLine(plt, series[label], func(r BenchmarkResult) float64 {
+				if i >= len(r.Classes) || r.Classes[i].CallerTimes == nil {
+					return math.NaN()
+				}
+				return r.Classes[i].CallerTimes.Percentile(99)
+			}, plotutil.Color(color))
+			color++
+			if err != nil {
+				return err
+			}
+			if line == nil {
+				continue
+			}
+			name := class.Name
+			if series[label][0].Config.prioritized() {
+				name += fmt.Sprintf(" (priority %d)", class.Priority)
+			}
+			if len(labels) > 1 {
+				name = label + ", " + name
+			}
+			plt.Legend.Add(name, line)
+		}
+	}
+	// Latency falls as co-routines are added, leaving the top right clear.
+	plt.Legend.Top = true
+	opts.latencyAxis(&plt.Y, lowestLatency(results))
+	opts.fitCoroutines(&plt.X)
+	opts.fitLatency(&plt.Y)
+	return opts.save(plt, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("class_latency_vs_coroutines.png"))
+}
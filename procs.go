@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// plotGOMAXPROCS draws throughput and p99 against GOMAXPROCS, one line per co-routine count, for sweeps over more
+// than one value of it: how a fixed load scales with the processors it is given, where the co-routine plots show how
+// it scales with concurrency on a fixed number. Repeated runs are averaged; failed ones are left out.
+func plotGOMAXPROCS(results []BenchmarkResult, opts PlotOptions) error {
+	cfgs := make([]RunConfig, len(results))
+	for i, result := range results {
+		cfgs[i] = result.Config
+	}
+	dims := sweptDims(cfgs)
+	var keys []string
+	runs := map[string][]BenchmarkResult{}
+	procs, executors := map[int]bool{}, map[string]bool{}
+	for _, result := range results {
+		procs[result.Config.GOMAXPROCS], executors[result.Config.Executor] = true, true
+	}
+	if len(procs) < 2 {
+		return nil
+	}
+	for _, result := range results {
+		parts := []string{fmt.Sprintf("%d co-routines", result.NumCoroutines)}
+		if len(executors) > 1 {
+			parts = append([]string{result.Config.Executor}, parts...)
+		}
+		for _, d := range dims {
+			parts = append(parts, d.label(result.Config))
+		}
+		k := strings.Join(parts, ", ")
+		if _, ok := runs[k]; !ok {
+			keys = append(keys, k)
+		}
+		runs[k] = append(runs[k], result)
+	}
+
+	throughput, latency := plot.New(), plot.New()
+	throughput.Title.Text = "Throughput vs. GOMAXPROCS"
+	throughput.Y.Label.Text = throughputLabel("Throughput")
+	throughputTicks(&throughput.Y)
+	throughput.Y.Min = 0
+	latency.Title.Text = "p99 Response Time vs. GOMAXPROCS"
+	latency.Y.Label.Text = latencyLabel("p99 response time")
+	opts.latencyAxis(&latency.Y, lowestLatency(results))
+	for _, plt := range []*plot.Plot{throughput, latency} {
+		plt.X.Label.Text = "GOMAXPROCS"
+		plt.X.Min = 0
+	}
+	// Throughput lines sit high and p99 lines rise, so their legends go where the lines are not.
+	throughput.Legend.Top = true
+	for i, k := range keys {
+		for _, panel := range []struct {
+			plt *plot.Plot
+			y   func(BenchmarkResult) float64
+		}{
+			{throughput, func(r BenchmarkResult) float64 { return r.ThroughputRps }},
+			{latency, func(r BenchmarkResult) float64 { return r.ResponseTimesPercentile(99) }},
+		} {
+			pts := meanPoints(runs[k], func(cfg RunConfig) int { return cfg.GOMAXPROCS }, panel.y)
+			if len(pts) == 0 {
+				continue
+			}
+			line, points, err := plotter.NewLinePoints(pts)
+			if err != nil {
+				return err
+			}
+			line.LineStyle.Color = plotutil.Color(i)
+			line.LineStyle.Dashes = plotutil.Dashes(i / len(plotutil.DefaultColors))
+			points.GlyphStyle.Color = plotutil.Color(i)
+			panel.plt.Add(line, points)
+			panel.plt.Legend.Add(k, line, points)
+		}
+	}
+	throughput.Y.Max *= 1.25
+	opts.fitLatency(&latency.Y)
+
+	plots := [][]*plot.Plot{{throughput, latency}}
+	return opts.saveCanvas(10*vg.Inch, 4*vg.Inch, opts.sweepFile("gomaxprocs_effect.png"), func(dc draw.Canvas) {
+		tiles := draw.Tiles{Rows: 1, Cols: 2, PadX: vg.Millimeter * 6, PadTop: vg.Millimeter * 2, PadBottom: vg.Millimeter * 2,
+			PadLeft: vg.Millimeter * 2, PadRight: vg.Millimeter * 2}
+		canvases := plot.Align(plots, tiles, dc)
+		throughput.Draw(canvases[0][0])
+		latency.Draw(canvases[0][1])
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const progressInterval = 500 * time.Millisecond
+
+// progressReporter keeps one line on a terminal up to date with how far a sweep has got: the configuration running,
+// how many of its requests have completed, and the time the rest of the sweep should take, estimated from the rate
+// requests have completed at so far. Runs at low concurrency come first and complete requests slowest, so the
+// estimate starts high and comes down. It is cleared before each run's results are printed.
+type progressReporter struct {
+	out       io.Writer
+	dims      []gridDim
+	runs      int   // in the sweep, counting repetitions; zero when unknown, as for a tuning search
+	requests  int64 // likewise
+	completed int64 // requests of the current run, updated atomically
+
+	mu      sync.Mutex
+	cfg     RunConfig
+	run     int
+	done    int64     // requests of the runs that have finished
+	started time.Time // when the sweep's requests started completing, give or take a tick
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// newProgressReporter plans the progress of a sweep of cfgs, each run repeat times; tuning leaves the number of runs
+// open.
+func newProgressReporter(out io.Writer, cfgs []RunConfig, repeat int, tuning bool) *progressReporter {
+	if repeat < 1 {
+		repeat = 1
+	}
+	p := &progressReporter{out: out, dims: sweptDims(cfgs)}
+	if !tuning {
+		p.runs = len(cfgs) * repeat
+		for _, cfg := range cfgs {
+			p.requests += int64(cfg.Iterations) * int64(repeat)
+		}
+	}
+	return p
+}
+
+// isTerminal reports whether f is a terminal, which a line redrawn in place needs.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progressReporter) OnRunStart(cfg RunConfig) {
+	p.mu.Lock()
+	p.cfg = cfg
+	p.run++
+	p.stop, p.stopped = make(chan struct{}), make(chan struct{})
+	stop, stopped := p.stop, p.stopped
+	p.mu.Unlock()
+	atomic.StoreInt64(&p.completed, 0)
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.draw()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (p *progressReporter) OnSample(sample Sample) {
+	atomic.AddInt64(&p.completed, 1)
+}
+
+func (p *progressReporter) OnRunComplete(result BenchmarkResult) error {
+	p.stopTicker()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += int64(result.Iterations)
+	fmt.Fprint(p.out, "\r\033[K")
+	return nil
+}
+
+func (p *progressReporter) Close() error {
+	p.stopTicker()
+	return nil
+}
+
+func (p *progressReporter) stopTicker() {
+	p.mu.Lock()
+	stop, stopped := p.stop, p.stopped
+	p.stop = nil
+	p.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-stopped
+	}
+}
+
+func (p *progressReporter) draw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	completed := atomic.LoadInt64(&p.completed)
+	if p.started.IsZero() && completed > 0 {
+		// From about when the first request completed: the first run's setup and baseline would skew every estimate.
+		p.started = time.Now()
+	}
+	parts := []string{p.cfg.Executor}
+	for _, d := range p.dims {
+		parts = append(parts, d.label(p.cfg))
+	}
+	parts = append(parts, fmt.Sprintf("%d co-routines", p.cfg.NumCoroutines))
+	line := fmt.Sprintf("Run %d", p.run)
+	if p.runs > 0 {
+		line += fmt.Sprintf("/%d", p.runs)
+	}
+	line += " (" + strings.Join(parts, ", ") + "): " + formatCount(completed)
+	if p.cfg.Iterations > 0 && p.cfg.Precision == 0 {
+		line += fmt.Sprintf("/%s requests, %s", formatCount(int64(p.cfg.Iterations)),
+			formatPercent(float64(completed)/float64(p.cfg.Iterations)))
+	} else {
+		line += " requests"
+	}
+	if done := p.done + completed; p.requests > 0 && done > 0 {
+		fraction := float64(done) / float64(p.requests)
+		if fraction > 1 {
+			fraction = 1
+		}
+		line += "; sweep " + formatPercent(fraction)
+		// A second of requests is the least to estimate the rest of the sweep from.
+		if elapsed := time.Since(p.started); elapsed >= time.Second {
+			remaining := time.Duration(float64(elapsed) * (1 - fraction) / fraction).Round(time.Second)
+			line += fmt.Sprintf(", about %v left", remaining)
+		}
+	}
+	fmt.Fprint(p.out, "\r\033[K"+line)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// sweepPoint is one run of a series, as far as the query needs it.
+type sweepPoint struct {
+	coroutines float64
+	p99        float64
+	rps        float64
+}
+
+// interpolate returns p99 and throughput at coroutine count c by linear interpolation between the two runs around it.
+// points must be sorted by coroutine count and c within their range.
+func interpolate(points []sweepPoint, c float64) (p99, rps float64) {
+	i := sort.Search(len(points), func(i int) bool { return points[i].coroutines >= c })
+	if points[i].coroutines == c || i == 0 {
+		return points[i].p99, points[i].rps
+	}
+	a, b := points[i-1], points[i]
+	t := (c - a.coroutines) / (b.coroutines - a.coroutines)
+	return a.p99 + t*(b.p99-a.p99), a.rps + t*(b.rps-a.rps)
+}
+
+// configLabel describes the configuration a run swept its coroutine count over.
+func configLabel(s jsonRunSummary) string {
+	label := fmt.Sprintf("%s executor, GOMAXPROCS=%d, %s, %gms CPU/%gms network in %d splits", s.Executor, s.GOMAXPROCS, s.Load, s.WorkTimeMs, s.NetworkTimeMs, s.Splits)
+	if s.SplitsDist != "" {
+		label += " (" + s.SplitsDist + ")"
+	}
+	if s.CPUKernel != "" && s.CPUKernel != "spin" {
+		label += ", " + s.CPUKernel + " CPU work"
+	}
+	if s.Faults != nil {
+		label += ", faults: " + s.Faults.String()
+	}
+	if s.Retry != nil {
+		label += ", retries: " + s.Retry.String()
+	}
+	if s.Breaker != nil {
+		label += ", breaker: " + s.Breaker.String()
+	}
+	if s.RateLimit != nil {
+		label += ", rate limited by a " + s.RateLimit.String()
+	}
+	if s.QueueDepth != nil {
+		label += fmt.Sprintf(", shedding past %d queued", *s.QueueDepth)
+	}
+	if s.MQConsumers > 0 {
+		label += fmt.Sprintf(", %d consumers", s.MQConsumers)
+	}
+	if s.SQLMaxOpen > 0 {
+		label += fmt.Sprintf(", at most %d open SQL connections", s.SQLMaxOpen)
+	}
+	if s.GOGC != "" {
+		label += ", GOGC=" + s.GOGC
+	}
+	if s.MemoryLimit > 0 {
+		label += ", GOMEMLIMIT=" + formatMemoryLimit(s.MemoryLimit)
+	}
+	if s.Placement != "" {
+		label += ", NUMA placement " + s.Placement
+	}
+	return label
+}
+
+// queryCommand inverts a sweep: given a p99 target and a throughput target, it reports for every series in a results
+// file the coroutine counts that meet both, interpolating between the counts that were actually run.
+func queryCommand(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	p99Target := fs.Duration("p99", 0, "highest acceptable p99 response time (default: any)")
+	rpsTarget := fs.Float64("throughput", 0, "lowest acceptable throughput in requests per second (default: any)")
+	applyUnits := unitFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s query [-p99 d] [-throughput rps] results.jsonl\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := applyUnits(); err != nil {
+		return err
+	}
+	if *p99Target <= 0 && *rpsTarget <= 0 {
+		return errors.New("query needs a -p99 or -throughput target")
+	}
+	p99Ms := float64(*p99Target) / float64(time.Millisecond)
+
+	summaries, err := loadResults(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var labels []string
+	series := map[string][]sweepPoint{}
+	for _, s := range summaries {
+		p99, ok := s.LatencyMs["p99"]
+		if s.Failure != nil || !ok {
+			continue // a failed run says nothing about what the configuration can sustain
+		}
+		label := configLabel(s)
+		if len(s.Tags) > 0 {
+			label += " [" + Tags(s.Tags).String() + "]"
+		}
+		if s.Note != "" {
+			label += " (" + s.Note + ")"
+		}
+		if _, ok := series[label]; !ok {
+			labels = append(labels, label)
+		}
+		series[label] = append(series[label], sweepPoint{float64(s.NumCoroutines), p99, s.ThroughputRps})
+	}
+	if len(labels) == 0 {
+		return fmt.Errorf("%s: no successful runs with latency percentiles", fs.Arg(0))
+	}
+
+	meets := func(p99, rps float64) bool {
+		return (p99Ms <= 0 || p99 <= p99Ms) && (*rpsTarget <= 0 || rps >= *rpsTarget)
+	}
+	for _, label := range labels {
+		points := series[label]
+		sort.Slice(points, func(i, j int) bool { return points[i].coroutines < points[j].coroutines })
+		fmt.Println(label + ":")
+
+		// Walk every whole coroutine count the sweep spans, collecting the ranges that meet both targets.
+		lo, hi := int(points[0].coroutines), int(points[len(points)-1].coroutines)
+		var ranges [][2]int
+		bestRps, bestAt := -1.0, 0
+		for c := lo; c <= hi; c++ {
+			p99, rps := interpolate(points, float64(c))
+			if !meets(p99, rps) {
+				continue
+			}
+			if n := len(ranges); n > 0 && ranges[n-1][1] == c-1 {
+				ranges[n-1][1] = c
+			} else {
+				ranges = append(ranges, [2]int{c, c})
+			}
+			if rps > bestRps {
+				bestRps, bestAt = rps, c
+			}
+		}
+		if len(ranges) == 0 {
+			minP99, maxRps := points[0].p99, points[0].rps
+			for _, p := range points {
+				if p.p99 < minP99 {
+					minP99 = p.p99
+				}
+				if p.rps > maxRps {
+					maxRps = p.rps
+				}
+			}
+			fmt.Printf("\tinfeasible between %s and %s co-routines: the lowest p99 is %s and the highest throughput %s\n",
+				formatCount(int64(lo)), formatCount(int64(hi)), formatMs(minP99), formatRps(maxRps))
+			continue
+		}
+		for _, r := range ranges {
+			fmt.Printf("\tfeasible with %s to %s co-routines\n", formatCount(int64(r[0])), formatCount(int64(r[1])))
+		}
+		p99, _ := interpolate(points, float64(bestAt))
+		fmt.Printf("\thighest throughput within the targets: %s at %s co-routines, p99 %s\n", formatRps(bestRps), formatCount(int64(bestAt)), formatMs(p99))
+	}
+	fmt.Println("Values between measured coroutine counts are interpolated; confirm the chosen count with a run of its own.")
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strings"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// QueueModel sets a run beside the M/M/c queue it would be in theory: requests arriving at random at the offered
+// rate, each holding one of c servers, the co-routines, for an exponentially distributed time averaging the
+// configured CPU plus network time. Where the run departs from the model, something the model leaves out, such as
+// CPU contention, scheduling delay or the load generator itself, is at work.
+type QueueModel struct {
+	Servers              int64
+	ArrivalRps           float64 // the offered rate, or the measured throughput for a closed loop
+	ClosedLoop           bool    // arrivals wait on completions, so the model's waiting time does not apply
+	ServiceTime          time.Duration
+	MeasuredServiceTime  time.Duration
+	PredictedUtilization float64
+	MeasuredUtilization  float64
+	PredictedWait        time.Duration // meaningless unless Stable
+	MeasuredWait         time.Duration
+	Stable               bool // offered load below capacity, so that the queue does not grow without bound
+}
+
+// modelQueue returns nil for runs the model does not describe: unbounded executors have no fixed number of servers
+// and pipeline and message queue requests are not served by the co-routine that issued them.
+func modelQueue(cfg RunConfig, rc *runCollection, completed int, throughputRps float64, duration time.Duration) *QueueModel {
+	if cfg.Executor == "unbounded" || cfg.Pipeline != nil || cfg.MQ != nil || cfg.NumCoroutines <= 0 || completed == 0 || duration <= 0 {
+		return nil
+	}
+	m := &QueueModel{
+		Servers:             cfg.NumCoroutines,
+		ArrivalRps:          throughputRps,
+		ServiceTime:         cfg.WorkTime + cfg.NetworkTime,
+		MeasuredServiceTime: rc.busy / time.Duration(completed),
+		MeasuredWait:        rc.queued / time.Duration(completed),
+		MeasuredUtilization: float64(rc.busy) / (float64(cfg.NumCoroutines) * float64(duration)),
+	}
+	switch cfg.Load.Kind {
+	case "constant", "poisson":
+		m.ArrivalRps = cfg.Load.Rate
+	case "burst":
+		m.ArrivalRps = float64(cfg.Load.BurstSize) / cfg.Load.BurstInterval.Seconds()
+	case "", "closed", "users":
+		m.ClosedLoop = true
+	}
+	if l := cfg.RateLimit; l.enabled() && !m.ClosedLoop && l.Rate < m.ArrivalRps {
+		m.ArrivalRps = l.Rate // the limiter holds back the rest
+	}
+	c := float64(m.Servers)
+	offered := m.ArrivalRps * m.ServiceTime.Seconds() // in Erlangs
+	m.PredictedUtilization = offered / c
+	m.Stable = m.PredictedUtilization < 1
+	if m.Stable {
+		m.PredictedWait = time.Duration(erlangC(m.Servers, offered) * float64(m.ServiceTime) / (c - offered))
+	}
+	return m
+}
+
+// erlangC is the probability that a request arriving at c servers offered the given load in Erlangs has to wait,
+// computed through the Erlang B recursion so that large c doesn't overflow.
+func erlangC(c int64, offered float64) float64 {
+	b := 1.0
+	for k := int64(1); k <= c; k++ {
+		b = offered * b / (float64(k) + offered*b)
+	}
+	rho := offered / float64(c)
+	return b / (1 - rho*(1-b))
+}
+
+// divergences describes where the run departs from the model by more than measurement noise would explain.
+func (m QueueModel) divergences() []string {
+	var d []string
+	if ratio := float64(m.MeasuredServiceTime) / float64(m.ServiceTime); m.ServiceTime > 0 && ratio > 1.2 {
+		d = append(d, fmt.Sprintf("requests take %sX their configured time, so they compete for CPU or wait to be scheduled", formatFloat(ratio, 2)))
+	}
+	if diff := m.MeasuredUtilization - m.PredictedUtilization; m.Stable && math.Abs(diff) > 0.1 {
+		d = append(d, fmt.Sprintf("co-routines are busy %s of the time, not the %s the model predicts", formatPercent(m.MeasuredUtilization), formatPercent(m.PredictedUtilization)))
+	}
+	if !m.ClosedLoop && m.Stable {
+		// Waits of a millisecond or less are within scheduling noise either way.
+		slack := time.Millisecond
+		if m.MeasuredWait > 2*m.PredictedWait+slack {
+			d = append(d, "requests wait longer for a co-routine than the model predicts, so service times are more variable or arrivals burstier than exponential")
+		} else if m.PredictedWait > 2*m.MeasuredWait+slack {
+			d = append(d, "requests wait less for a co-routine than the model predicts, so service times are more regular than exponential")
+		}
+	}
+	if !m.ClosedLoop && !m.Stable {
+		d = append(d, fmt.Sprintf("the offered load exceeds the capacity of %s co-routines, so the queue grows for as long as the run lasts", formatCount(m.Servers)))
+	}
+	return d
+}
+
+func outputQueueModel(m *QueueModel) {
+	fmt.Printf("\tM/M/%d model at %s offered, %s service time (measured %s):\n", m.Servers, formatRps(m.ArrivalRps),
+		formatDuration(m.ServiceTime), formatDuration(m.MeasuredServiceTime))
+	fmt.Printf("\t\tutilization predicted %s, measured %s\n", formatPercent(m.PredictedUtilization), formatPercent(m.MeasuredUtilization))
+	switch {
+	case m.ClosedLoop:
+		fmt.Printf("\t\twait measured %s; a closed loop only issues a request once another completes, so the model's wait does not apply\n",
+			formatDuration(m.MeasuredWait))
+	case m.Stable:
+		fmt.Printf("\t\twait predicted %s, measured %s\n", formatDuration(m.PredictedWait), formatDuration(m.MeasuredWait))
+	default:
+		fmt.Printf("\t\twait measured %s; the model predicts no steady state\n", formatDuration(m.MeasuredWait))
+	}
+	for _, d := range m.divergences() {
+		fmt.Printf("\t\tDiverges: %s\n", d)
+	}
+}
+
+type jsonQueueModel struct {
+	ArrivalRps           float64  `json:"arrival_rps"`
+	ServiceTimeMs        float64  `json:"service_time_ms"`
+	MeasuredServiceMs    float64  `json:"measured_service_time_ms"`
+	PredictedUtilization float64  `json:"predicted_utilization"`
+	MeasuredUtilization  float64  `json:"measured_utilization"`
+	PredictedWaitMs      *float64 `json:"predicted_wait_ms,omitempty"` // absent when the model has no steady state or does not apply
+	MeasuredWaitMs       float64  `json:"measured_wait_ms"`
+}
+
+func newJsonQueueModel(m *QueueModel) *jsonQueueModel {
+	if m == nil {
+		return nil
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	j := &jsonQueueModel{
+		ArrivalRps:           m.ArrivalRps,
+		ServiceTimeMs:        ms(m.ServiceTime),
+		MeasuredServiceMs:    ms(m.MeasuredServiceTime),
+		PredictedUtilization: m.PredictedUtilization,
+		MeasuredUtilization:  m.MeasuredUtilization,
+		MeasuredWaitMs:       ms(m.MeasuredWait),
+	}
+	if m.Stable && !m.ClosedLoop {
+		wait := ms(m.PredictedWait)
+		j.PredictedWaitMs = &wait
+	}
+	return j
+}
+
+// littlesLawWarning checks the average number of requests in flight, which by Little's law is the throughput times
+// the mean response time, against the co-routine limit. A closed loop should keep every co-routine busy but for the
+// moments it fills and drains, so fewer in flight means the load generator could not keep up; more than the limit in
+// flight at any load is impossible, so it means the throughput or response times are mismeasured.
+func littlesLawWarning(result BenchmarkResult) string {
+	l, c := result.AvgConcurrency, float64(result.NumCoroutines)
+	if l <= 0 || c <= 0 {
+		return ""
+	}
+	if result.Config.Executor != "unbounded" && l > c*1.02 {
+		return fmt.Sprintf("%s requests were in flight on average, more than the %s co-routines allow: throughput or response times are mismeasured",
+			formatFloat(l, 2), formatCount(result.NumCoroutines))
+	}
+	if kind := result.Config.Load.Kind; (kind == "" || kind == "closed") && l < c*0.9 {
+		return fmt.Sprintf("only %s of %s co-routines were busy on average: the load generator did not keep the closed loop full, or the run was too short for filling and draining it to be negligible",
+			formatFloat(l, 2), formatCount(result.NumCoroutines))
+	}
+	return ""
+}
+
+// outputQueueWait prints how long requests waited for a free co-routine before they started, which their response
+// times leave out, and the latency a caller issuing them would have seen with that wait included.
+func outputQueueWait(result BenchmarkResult, pcts []float64) {
+	if result.QueueWaits == nil || result.QueueWaits.Count() == 0 {
+		return
+	}
+	var waits, caller []string
+	for _, pct := range pcts {
+		waits = append(waits, percentileName(pct)+" "+formatMs(result.QueueWaits.Percentile(pct)))
+		caller = append(caller, percentileName(pct)+" "+formatMs(result.CallerTimes.Percentile(pct)))
+	}
+	fmt.Printf("\tQueue wait: %s, mean %s; %s requests waiting on average\n", strings.Join(waits, ", "),
+		formatMs(latencyStats(result.QueueWaits).Mean), formatFloat(result.QueueLength, 2))
+	fmt.Printf("\tAs seen by the caller, queue wait included: %s\n", strings.Join(caller, ", "))
+}
+
+// saveQueueWaitCDF draws, for an open-loop run, the fraction of requests slower than each latency twice over: by
+// their service time, from when they started, and by the response time a caller saw, from when they were meant to
+// start. Where the two curves part, requests queued for a co-routine; a closed loop would only ever have measured the
+// first, which is coordinated omission, and the gap widening towards the tail is the queue growing.
+func saveQueueWaitCDF(result BenchmarkResult, opts PlotOptions) error {
+	if result.QueueWaits == nil || result.QueueWaits.Count() == 0 {
+		return nil
+	}
+	p := plot.New()
+	p.Title.Text = "Service vs. Response Time"
+	p.X.Label.Text = latencyLabel("Latency")
+	p.Y.Label.Text = "Fraction of requests slower"
+	p.Y.Scale = plot.LogScale{}
+	p.Y.Tick.Marker = plot.LogTicks{}
+	opts.latencyAxis(&p.X, lowestLatency([]BenchmarkResult{result}))
+	for _, series := range []struct {
+		name   string
+		values []float64
+		color  color.Color
+	}{
+		{"service time", result.ResponseTimes.Values(), color.RGBA{B: 255, A: 255}},
+		{"response time, queue wait included", result.CallerTimes.Values(), color.RGBA{R: 255, A: 255}},
+	} {
+		pts := cdfPoints(series.values, true)
+		if len(pts) == 0 {
+			continue
+		}
+		line, err := plotter.NewLine(pts)
+		if err != nil {
+			return err
+		}
+		line.LineStyle.Color = series.color
+		line.LineStyle.Width = vg.Points(1.5)
+		p.Add(line)
+		p.Legend.Add(series.name, line)
+	}
+	p.Legend.Top = true
+	opts.fitLatency(&p.X)
+	return opts.save(p, 6*vg.Inch, 4*vg.Inch, opts.runFile(result, "service_vs_response.png"))
+}
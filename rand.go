@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Every source of randomness in a run draws from its own stream derived from the run's seed, so a run is reproducible
+// even though its requests execute concurrently and in no fixed order. Requests use their index as the stream.
+const (
+	loadRandStream      = -1
+	collectorRandStream = -2
+	gridRandStream      = -3
+)
+
+// splitMix64 is a tiny rand.Source64, cheap enough to create one per request.
+type splitMix64 struct {
+	state uint64
+}
+
+func (s *splitMix64) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *splitMix64) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitMix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+func newRand(seed int64, stream int64) *rand.Rand {
+	return rand.New(&splitMix64{state: uint64(seed) ^ uint64(stream)*0xd1b54a32d192ed03})
+}
+
+type randKey struct{}
+
+func withRand(ctx context.Context, r *rand.Rand) context.Context {
+	return context.WithValue(ctx, randKey{}, r)
+}
+
+func randFrom(ctx context.Context) *rand.Rand {
+	if r, ok := ctx.Value(randKey{}).(*rand.Rand); ok {
+		return r
+	}
+	return newRand(time.Now().UnixNano(), 0)
+}
+
+type requestIndexKey struct{}
+
+// withRequestIndex records which request of the run ctx belongs to, for workloads that rebuild its random stream
+// elsewhere, such as in a separate target process.
+func withRequestIndex(ctx context.Context, x int) context.Context {
+	return context.WithValue(ctx, requestIndexKey{}, x)
+}
+
+func requestIndexFrom(ctx context.Context) (int, bool) {
+	x, ok := ctx.Value(requestIndexKey{}).(int)
+	return x, ok
+}
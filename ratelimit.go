@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/montanaflynn/stats"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit throttles the load generator as a client-side rate limiter would, on top of whatever pace the load sets:
+// a token bucket lets up to Burst requests through at once after a lull, while a leaky bucket spaces every request
+// exactly 1/Rate apart. The zero value doesn't limit.
+type RateLimit struct {
+	Strategy string  `json:"strategy"` // token or leaky
+	Rate     float64 `json:"rate"`
+	Burst    int     `json:"burst,omitempty"` // token bucket only
+}
+
+// parseRateLimit parses none, token:<rps>[:<burst>] or leaky:<rps>. A token bucket's burst defaults to 1.
+func parseRateLimit(s string) (RateLimit, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if parts[0] == "none" && len(parts) == 1 {
+		return RateLimit{}, nil
+	}
+	if (parts[0] != "token" && parts[0] != "leaky") || len(parts) < 2 || len(parts) > 3 || (parts[0] == "leaky" && len(parts) > 2) {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q, expected none, token:<rps>[:<burst>] or leaky:<rps>", s)
+	}
+	l := RateLimit{Strategy: parts[0]}
+	var err error
+	if l.Rate, err = strconv.ParseFloat(parts[1], 64); err != nil || l.Rate <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q needs a positive rate", s)
+	}
+	if l.Strategy == "token" {
+		l.Burst = 1
+		if len(parts) == 3 {
+			if l.Burst, err = strconv.Atoi(parts[2]); err != nil || l.Burst < 1 {
+				return RateLimit{}, fmt.Errorf("rate limit %q needs a burst of at least 1", s)
+			}
+		}
+	}
+	return l, nil
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Strategy != ""
+}
+
+// key names the limit in labels and file names, such as token-100rps-b10.
+func (l RateLimit) key() string {
+	switch l.Strategy {
+	case "token":
+		return fmt.Sprintf("token-%grps-b%d", l.Rate, l.Burst)
+	case "leaky":
+		return fmt.Sprintf("leaky-%grps", l.Rate)
+	}
+	return "none"
+}
+
+func (l RateLimit) String() string {
+	switch l.Strategy {
+	case "token":
+		return fmt.Sprintf("token bucket at %s, bursts of up to %d", formatRps(l.Rate), l.Burst)
+	case "leaky":
+		return fmt.Sprintf("leaky bucket at %s", formatRps(l.Rate))
+	}
+	return "none"
+}
+
+// rateLimiter decides how long a request that is ready to go at now waits for the limiter.
+type rateLimiter interface {
+	reserve(now time.Time) time.Duration
+}
+
+func newRateLimiter(l RateLimit, now time.Time) rateLimiter {
+	if l.Strategy == "leaky" {
+		return &leakyBucket{interval: time.Duration(float64(time.Second) / l.Rate), next: now}
+	}
+	// The bucket starts full, as a client's would after being idle; the run's first requests may burst.
+	return tokenBucket{rate.NewLimiter(rate.Limit(l.Rate), l.Burst)}
+}
+
+// tokenBucket reserves tokens at the clock's times rather than the wall clock's, so that it paces compressed runs too.
+type tokenBucket struct {
+	limiter *rate.Limiter
+}
+
+func (b tokenBucket) reserve(now time.Time) time.Duration {
+	return b.limiter.ReserveN(now, 1).DelayFrom(now)
+}
+
+// leakyBucket lets requests out at a constant rate, however they arrive: one that is ready after a lull goes at once,
+// but a backlog drains one interval apart.
+type leakyBucket struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func (b *leakyBucket) reserve(now time.Time) time.Duration {
+	if b.next.Before(now) {
+		b.next = now
+	}
+	wait := b.next.Sub(now)
+	b.next = b.next.Add(b.interval)
+	return wait
+}
+
+// limitedGenerator holds each request the load generator issues until the rate limiter lets it go, recording when
+// requests went and how long they were held.
+type limitedGenerator struct {
+	gen     LoadGenerator
+	limiter rateLimiter
+	clock   Clock
+	start   time.Time
+	sent    []time.Duration // since start
+	waits   []float64       // in ms
+}
+
+func newLimitedGenerator(gen LoadGenerator, l RateLimit, clock Clock) *limitedGenerator {
+	now := clock.Now()
+	return &limitedGenerator{gen: gen, limiter: newRateLimiter(l, now), clock: clock, start: now}
+}
+
+func (g *limitedGenerator) Next(ctx context.Context) error {
+	if err := g.gen.Next(ctx); err != nil {
+		return err
+	}
+	now := g.clock.Now()
+	wait := g.limiter.reserve(now)
+	if err := sleepUntil(ctx, g.clock, now.Add(wait)); err != nil {
+		return err
+	}
+	g.sent = append(g.sent, g.clock.Now().Sub(g.start))
+	g.waits = append(g.waits, float64(wait)/float64(time.Millisecond))
+	return nil
+}
+
+// burstWindow is the window RateLimitStats counts the busiest stretch of a run's requests over.
+const burstWindow = 100 * time.Millisecond
+
+// RateLimitStats is how bursty the requests a rate limiter let through were, and what it cost them.
+type RateLimitStats struct {
+	GapCV        float64 `json:"gap_cv"`         // coefficient of variation of the gaps between requests: 0 for even spacing, 1 for Poisson arrivals
+	PeakInWindow int     `json:"peak_per_100ms"` // most requests let through within any 100ms
+	MeanInWindow float64 `json:"mean_per_100ms"` // requests let through per 100ms on average
+	WaitP50Ms    float64 `json:"wait_p50_ms"`    // time requests were held by the limiter
+	WaitP99Ms    float64 `json:"wait_p99_ms"`
+	WaitMaxMs    float64 `json:"wait_max_ms"`
+	AchievedRps  float64 `json:"achieved_rps"` // requests let through per second, from the first to the last
+}
+
+// stats summarizes the requests the generator let through; nil for fewer than two.
+func (g *limitedGenerator) stats() *RateLimitStats {
+	if g == nil || len(g.sent) < 2 {
+		return nil
+	}
+	s := &RateLimitStats{}
+	gaps := make([]float64, len(g.sent)-1)
+	for i := range gaps {
+		gaps[i] = float64(g.sent[i+1] - g.sent[i])
+	}
+	mean, _ := stats.Mean(gaps)
+	stddev, _ := stats.StandardDeviation(gaps)
+	if mean > 0 {
+		s.GapCV = stddev / mean
+	}
+	for i, j := 0, 0; i < len(g.sent); i++ {
+		for g.sent[i]-g.sent[j] >= burstWindow {
+			j++
+		}
+		if n := i - j + 1; n > s.PeakInWindow {
+			s.PeakInWindow = n
+		}
+	}
+	if span := g.sent[len(g.sent)-1] - g.sent[0]; span > 0 {
+		s.AchievedRps = float64(len(g.sent)-1) / span.Seconds()
+		s.MeanInWindow = s.AchievedRps * burstWindow.Seconds()
+	}
+	s.WaitP50Ms, _ = stats.Percentile(g.waits, 50)
+	s.WaitP99Ms, _ = stats.Percentile(g.waits, 99)
+	s.WaitMaxMs, _ = stats.Max(g.waits)
+	return s
+}
+
+func outputRateLimit(result BenchmarkResult) {
+	if !result.Config.RateLimit.enabled() {
+		return
+	}
+	fmt.Printf("\tRate limiter: %v\n", result.Config.RateLimit)
+	if s := result.RateLimitStats; s != nil {
+		peak := math.NaN()
+		if s.MeanInWindow > 0 {
+			peak = float64(s.PeakInWindow) / s.MeanInWindow
+		}
+		fmt.Printf("\tLet through %s, gap CV %s, busiest 100ms had %d requests (%s× the mean); held p50 %s, p99 %s, max %s\n",
+			formatRps(s.AchievedRps), formatFloat(s.GapCV, 2), s.PeakInWindow, formatFloat(peak, 2),
+			formatMs(s.WaitP50Ms), formatMs(s.WaitP99Ms), formatMs(s.WaitMaxMs))
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RedisWorkload adds cache calls to a Redis server after every network phase: Commands GETs and SETs of random keys,
+// GetFraction of them GETs, over a pool of Conns connections shared by the requests. Pipelined, a phase writes all
+// its commands before reading any reply, paying for one round trip instead of one per command. It speaks the
+// protocol itself, so that nothing but the connections stands between the requests and the server.
+type RedisWorkload struct {
+	Addr        string
+	Conns       int
+	Commands    int // per phase
+	Pipeline    bool
+	Keys        int64 // distinct keys, all set before the first run so that GETs hit
+	ValueSize   int
+	GetFraction float64
+
+	pool  chan *redisConn // nil for a connection that failed, to be dialled again by whoever takes it
+	value []byte
+}
+
+const redisKeyPrefix = "perf:"
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func dialRedis(addr string) (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// write buffers a command as an array of bulk strings.
+func (c *redisConn) write(args ...[]byte) {
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(c.w, "$%d\r\n", len(arg))
+		c.w.Write(arg)
+		c.w.WriteString("\r\n")
+	}
+}
+
+// errRedisNil stands for a nil reply, a GET of a key that is not set.
+var errRedisNil = errors.New("redis: nil")
+
+// redisError is an error the server replied with, after which the connection is still good.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// read reads a reply, discarding its value, and returns the error the server replied with, if any.
+func (c *redisConn) read() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if len(line) < 3 {
+		return fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+', ':':
+		return nil
+	case '-':
+		return redisError(body)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return fmt.Errorf("redis: malformed reply %q", line)
+		}
+		if n < 0 {
+			return errRedisNil
+		}
+		_, err = c.r.Discard(n + 2)
+		return err
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return fmt.Errorf("redis: malformed reply %q", line)
+		}
+		for i := 0; i < n; i++ {
+			if err := c.read(); err != nil && err != errRedisNil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("redis: malformed reply %q", line)
+}
+
+func (w *RedisWorkload) Start() error {
+	if w.Conns < 1 || w.Commands < 1 || w.Keys < 1 {
+		return fmt.Errorf("Redis connections, commands and keys must be positive, got %d, %d and %d", w.Conns, w.Commands, w.Keys)
+	}
+	w.value = make([]byte, w.ValueSize)
+	rand.Read(w.value)
+	w.pool = make(chan *redisConn, w.Conns)
+	for i := 0; i < w.Conns; i++ {
+		c, err := dialRedis(w.Addr)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		w.pool <- c
+	}
+	// Setting every key up front, a thousand to a round trip, is also the check that the server is there.
+	c := <-w.pool
+	defer func() { w.pool <- c }()
+	for from := int64(0); from < w.Keys; from += 1000 {
+		to := from + 1000
+		if to > w.Keys {
+			to = w.Keys
+		}
+		for k := from; k < to; k++ {
+			c.write([]byte("SET"), redisKey(k), w.value)
+		}
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+		for k := from; k < to; k++ {
+			if err := c.read(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func redisKey(k int64) []byte {
+	return strconv.AppendInt([]byte(redisKeyPrefix), k, 10)
+}
+
+func (w *RedisWorkload) Close() error {
+	for {
+		select {
+		case c := <-w.pool:
+			if c != nil {
+				c.conn.Close()
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (w *RedisWorkload) String() string {
+	s := fmt.Sprintf("%d commands per phase, %s GETs, of %s keys of %d bytes at %s over %d connections",
+		w.Commands, formatPercent(w.GetFraction), formatCount(w.Keys), w.ValueSize, w.Addr, w.Conns)
+	if w.Pipeline {
+		s += ", pipelined"
+	}
+	return s
+}
+
+// do runs a phase's commands on c, drawing each one's kind and key from r. The first error the server replies with is
+// returned once every reply has been read, so that the connection stays in step.
+func (w *RedisWorkload) do(c *redisConn, r *rand.Rand) (misses int, err error) {
+	var replied error
+	send := func() {
+		key := redisKey(r.Int63n(w.Keys))
+		if r.Float64() < w.GetFraction {
+			c.write([]byte("GET"), key)
+		} else {
+			c.write([]byte("SET"), key, w.value)
+		}
+	}
+	receive := func() error {
+		err := c.read()
+		var reply redisError
+		switch {
+		case err == errRedisNil:
+			misses++
+			return nil
+		case errors.As(err, &reply):
+			if replied == nil {
+				replied = err
+			}
+			return nil
+		}
+		return err
+	}
+	if w.Pipeline {
+		for i := 0; i < w.Commands; i++ {
+			send()
+		}
+		if err := c.w.Flush(); err != nil {
+			return misses, err
+		}
+		for i := 0; i < w.Commands; i++ {
+			if err := receive(); err != nil {
+				return misses, err
+			}
+		}
+		return misses, replied
+	}
+	for i := 0; i < w.Commands; i++ {
+		send()
+		if err := c.w.Flush(); err != nil {
+			return misses, err
+		}
+		if err := receive(); err != nil {
+			return misses, err
+		}
+	}
+	return misses, replied
+}
+
+// Commands go over the network and so always run on wall-clock time. A connection that fails is dialled again by the
+// next request to take it, so that one bad reply doesn't shrink the pool for the rest of the sweep.
+func doRedisWork(w *RedisWorkload, r *rand.Rand, log *RequestLog) error {
+	start := log.now(realClock{})
+	log.Begin(start, "redis", "Redis commands", 0)
+	c := <-w.pool
+	if c == nil {
+		var err error
+		if c, err = dialRedis(w.Addr); err != nil {
+			w.pool <- nil
+			return err
+		}
+	}
+	if log.Enabled(LogDebug) {
+		now := log.now(realClock{})
+		log.Debugf(now, "got a connection after %v", now.Sub(start))
+	}
+	misses, err := w.do(c, r)
+	var reply redisError
+	if err != nil && !errors.As(err, &reply) {
+		c.conn.Close()
+		w.pool <- nil
+		return err
+	}
+	w.pool <- c
+	if err != nil {
+		return err
+	}
+	end := log.now(realClock{})
+	if misses > 0 && log.Enabled(LogDebug) {
+		log.Debugf(end, "%d GETs missed", misses)
+	}
+	log.End(end)
+	return nil
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Sample struct {
+	Request int
+	Latency time.Duration
+}
+
+// Reporter receives benchmark progress. OnSample is called for every completed request, from the collecting goroutine
+// or, with sharded aggregation, concurrently from the request goroutines, so implementations must keep it cheap and
+// safe for concurrent use.
+type Reporter interface {
+	OnRunStart(cfg RunConfig)
+	OnSample(sample Sample)
+	OnRunComplete(result BenchmarkResult) error
+}
+
+type multiReporter []Reporter
+
+func (m multiReporter) OnRunStart(cfg RunConfig) {
+	for _, r := range m {
+		r.OnRunStart(cfg)
+	}
+}
+
+func (m multiReporter) OnSample(sample Sample) {
+	for _, r := range m {
+		r.OnSample(sample)
+	}
+}
+
+func (m multiReporter) OnRunComplete(result BenchmarkResult) error {
+	var firstErr error
+	for _, r := range m {
+		if err := r.OnRunComplete(result); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close finalizes every reporter that holds resources or renders output only once the sweep is done.
+func (m multiReporter) Close() error {
+	var firstErr error
+	for _, r := range m {
+		if c, ok := r.(io.Closer); ok {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func newReporter(names []string, jsonOut string, promAddr string, plots PlotOptions) (multiReporter, error) {
+	var m multiReporter
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "console":
+			m = append(m, &consoleReporter{speedup: plots.Speedup})
+		case "plots":
+			m = append(m, &plotReporter{opts: plots})
+		case "tui":
+			m = append(m, newDashboardReporter(os.Stderr))
+		case "json":
+			r, err := newJsonReporter(jsonOut)
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			m = append(m, r)
+		case "prometheus":
+			r, err := newPrometheusReporter(promAddr)
+			if err != nil {
+				m.Close()
+				return nil, err
+			}
+			m = append(m, r)
+		default:
+			m.Close()
+			return nil, fmt.Errorf("unknown reporter %q", name)
+		}
+	}
+	return m, nil
+}
+
+// How much the console reporter prints: a table of the sweep once it is done, a summary of each run, or each run's
+// summary and its longest request too.
+const (
+	verbosityQuiet = iota - 1
+	verbosityNormal
+	verbosityVerbose
+)
+
+type consoleReporter struct {
+	verbosity int
+	ascii     bool   // render the histogram and percentiles in the terminal
+	speedup   string // basis of the speedups printed; empty for speedupSerial
+	singles   *speedupNormalizer
+	results   []BenchmarkResult
+}
+
+func (r *consoleReporter) OnRunStart(cfg RunConfig) {}
+
+func (r *consoleReporter) OnSample(sample Sample) {}
+
+func (r *consoleReporter) OnRunComplete(result BenchmarkResult) error {
+	if r.verbosity == verbosityQuiet {
+		r.results = append(r.results, result)
+		return nil
+	}
+	if r.speedup != "" && r.speedup != speedupSerial {
+		if r.singles == nil {
+			r.singles = newSpeedupNormalizer(r.speedup)
+		}
+		r.singles.add(result)
+		result.Speedup = r.singles.of(result)
+		if math.IsNaN(result.Speedup) {
+			result.Speedup = 0 // printed without one
+		}
+	}
+	outputBenchmarkResult(result, r.verbosity >= verbosityVerbose, r.ascii, r.speedup)
+	return nil
+}
+
+// Close prints the quiet reporter's table: a row per run, in the order the series ran.
+func (r *consoleReporter) Close() error {
+	if len(r.results) == 0 {
+		return nil
+	}
+	labels, series := bySeries(normalizeSpeedups(r.results, r.speedup))
+	width := len("series")
+	for _, label := range labels {
+		if len(label) > width {
+			width = len(label)
+		}
+	}
+	fmt.Printf("%-*s %11s %12s %8s %10s %10s %8s\n", width, "series", "co-routines", units.Throughput, "speedup",
+		"p50 "+latencySuffix(), "p99 "+latencySuffix(), "errors")
+	for _, label := range labels {
+		for _, result := range series[label] {
+			errors := formatPercent(float64(result.Errors) / math.Max(1, float64(result.Iterations)))
+			if result.Failure != nil {
+				errors = "FAILED"
+			}
+			fmt.Printf("%-*s %11s %12s %8s %10s %10s %8s\n", width, label, formatCount(result.NumCoroutines),
+				formatThroughput(result.ThroughputRps), formatFloat(result.Speedup, 2), formatLatency(result.ResponseTimesPercentile(50)),
+				formatLatency(result.ResponseTimesPercentile(99)), errors)
+		}
+	}
+	return nil
+}
+
+type plotReporter struct {
+	opts    PlotOptions
+	results []BenchmarkResult
+}
+
+func (r *plotReporter) OnRunStart(cfg RunConfig) {}
+
+func (r *plotReporter) OnSample(sample Sample) {}
+
+func (r *plotReporter) OnRunComplete(result BenchmarkResult) error {
+	r.results = append(r.results, result)
+	if err := saveHistogram(result, r.opts); err != nil {
+		return err
+	}
+	if err := saveCDF(result, r.opts); err != nil {
+		return err
+	}
+	if err := saveQueueWaitCDF(result, r.opts); err != nil {
+		return err
+	}
+	if err := saveBudget(result, r.opts); err != nil {
+		return err
+	}
+	if err := saveThroughputSeries(result, r.opts); err != nil {
+		return err
+	}
+	if err := saveLatencyTrace(result, r.opts); err != nil {
+		return err
+	}
+	if err := saveMemoryTrace(result, r.opts); err != nil {
+		return err
+	}
+	if err := saveCoreTrace(result, r.opts); err != nil {
+		return err
+	}
+	if err := saveFlameGraph(result, r.opts); err != nil {
+		return err
+	}
+	return saveGantt(result, r.opts.Out)
+}
+
+func (r *plotReporter) Close() error {
+	if len(r.results) == 0 {
+		return nil
+	}
+	if err := plotThroughput(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotLatency(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotBoxes(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotTradeoff(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotCDF(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotPhases(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotMemory(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotCores(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotAmplification(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotClassLatency(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotWastedWork(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotHedging(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotBurstTail(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotFairness(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotShedding(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotApdex(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotAdaptiveLimit(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotSplits(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotGOMAXPROCS(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotGridFacets(r.results, r.opts); err != nil {
+		return err
+	}
+	if err := plotHistogramGrid(r.results, r.opts); err != nil {
+		return err
+	}
+	return plotSummary(r.results, r.opts)
+}
+
+type jsonReporter struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func newJsonReporter(path string) (*jsonReporter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonReporter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (r *jsonReporter) OnRunStart(cfg RunConfig) {}
+
+func (r *jsonReporter) OnSample(sample Sample) {}
+
+func (r *jsonReporter) OnRunComplete(result BenchmarkResult) error {
+	return r.enc.Encode(newRunSummary(result))
+}
+
+func (r *jsonReporter) Close() error {
+	return r.f.Close()
+}
+
+var promLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type promSeries struct {
+	buckets        []uint64
+	count          uint64
+	sum            float64
+	throughputRps  float64
+	speedup        float64
+	cpuUtilization float64
+	timeoutRate    float64
+}
+
+// prometheusReporter serves the samples seen so far in the Prometheus text exposition format, labelled by the
+// executor, GOMAXPROCS setting and co-routine count of the run they belong to and, when the sweep varies more than
+// those, by the label bySeries gives its series.
+type prometheusReporter struct {
+	mu      sync.Mutex
+	dims    []gridDim // swept, set before the first run
+	current *promSeries
+	series  map[string]*promSeries // by labels
+	keys    []string               // in the order the runs started
+	server  *http.Server
+}
+
+func newPrometheusReporter(addr string) (*prometheusReporter, error) {
+	r := &prometheusReporter{series: map[string]*promSeries{}}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", r.serveMetrics)
+	r.server = &http.Server{Handler: mux}
+	go r.server.Serve(ln)
+	return r, nil
+}
+
+func (r *prometheusReporter) OnRunStart(cfg RunConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	labels := r.labels(cfg)
+	if _, ok := r.series[labels]; !ok {
+		r.keys = append(r.keys, labels)
+	}
+	r.current = &promSeries{buckets: make([]uint64, len(promLatencyBuckets))}
+	r.series[labels] = r.current
+}
+
+// labels are a run's labels, in the exposition format.
+func (r *prometheusReporter) labels(cfg RunConfig) string {
+	labels := fmt.Sprintf("executor=%q,gomaxprocs=\"%d\",coroutines=\"%d\"", cfg.Executor, cfg.GOMAXPROCS, cfg.NumCoroutines)
+	if series := seriesLabel(cfg, false, false, r.dims); series != "" {
+		labels += fmt.Sprintf(",series=%q", series)
+	}
+	return labels
+}
+
+func (r *prometheusReporter) OnSample(sample Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.current
+	seconds := sample.Latency.Seconds()
+	for i, le := range promLatencyBuckets {
+		if seconds <= le {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.sum += seconds
+}
+
+func (r *prometheusReporter) OnRunComplete(result BenchmarkResult) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.current
+	s.throughputRps = result.ThroughputRps
+	s.speedup = result.Speedup
+	s.cpuUtilization = result.CpuUtilization
+	s.timeoutRate = result.TimeoutRate()
+	return nil
+}
+
+func (r *prometheusReporter) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP perf_request_duration_seconds Response time of completed benchmark requests.")
+	fmt.Fprintln(w, "# TYPE perf_request_duration_seconds histogram")
+	for _, k := range r.keys {
+		s := r.series[k]
+		for i, le := range promLatencyBuckets {
+			fmt.Fprintf(w, "perf_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", k, le, s.buckets[i])
+		}
+		fmt.Fprintf(w, "perf_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", k, s.count)
+		fmt.Fprintf(w, "perf_request_duration_seconds_sum{%s} %g\n", k, s.sum)
+		fmt.Fprintf(w, "perf_request_duration_seconds_count{%s} %d\n", k, s.count)
+	}
+	for _, g := range []struct {
+		name, help string
+		value      func(*promSeries) float64
+	}{
+		{"perf_throughput_rps", "Throughput of the completed run.", func(s *promSeries) float64 { return s.throughputRps }},
+		{"perf_speedup", "Speedup of the completed run over the serial baseline.", func(s *promSeries) float64 { return s.speedup }},
+		{"perf_cpu_utilization_percent", "CPU utilization of the completed run.", func(s *promSeries) float64 { return s.cpuUtilization }},
+		{"perf_timeout_ratio", "Fraction of the completed run's requests that missed their deadline.", func(s *promSeries) float64 { return s.timeoutRate }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, k := range r.keys {
+			fmt.Fprintf(w, "%s{%s} %g\n", g.name, k, g.value(r.series[k]))
+		}
+	}
+}
+
+func (r *prometheusReporter) Close() error {
+	return r.server.Close()
+}
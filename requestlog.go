@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LogLevel selects how much each request records about itself.
+type LogLevel int
+
+const (
+	LogOff   LogLevel = iota // nothing, so that requests allocate nothing for their log
+	LogInfo                  // the start and end of each phase, which budgets, phases and timelines are built from
+	LogDebug                 // also what happened within a phase, such as how long a lock was waited for
+)
+
+var logLevelNames = []string{"off", "info", "debug"}
+
+func parseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if s == name {
+			return LogLevel(i), nil
+		}
+	}
+	return LogOff, fmt.Errorf("unknown log level %q, expected one of %s", s, strings.Join(logLevelNames, ", "))
+}
+
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LogLevel) UnmarshalText(b []byte) error {
+	level, err := parseLogLevel(string(b))
+	*l = level
+	return err
+}
+
+// Kinds of Event.
+const (
+	eventBegin = "begin"
+	eventEnd   = "end"
+	eventNote  = "note"
+)
+
+// Event is one entry of a request's log: the start of a phase, the end of the innermost one still open, or a note
+// about what happened within it.
+type Event struct {
+	At     time.Time     `json:"at"`
+	Level  LogLevel      `json:"level"`
+	Kind   string        `json:"kind"`
+	Phase  string        `json:"phase,omitempty"`  // what a begun phase spends its time on: cpu, network, disk, lock, pipeline or middleware
+	Msg    string        `json:"msg,omitempty"`    // describes a begun phase, or is the note
+	Amount time.Duration `json:"amount,omitempty"` // the time a begun phase was asked to take, zero where it wasn't
+}
+
+// RequestLog is what a request records about itself as it runs. A nil log records nothing, which is what requests
+// get with LogOff; every method is safe to call on it.
+type RequestLog struct {
+	Request int
+	Level   LogLevel
+	Events  []Event
+}
+
+// newRequestLog returns the log for request x, or nil if level records nothing.
+func newRequestLog(x int, level LogLevel) *RequestLog {
+	if level <= LogOff {
+		return nil
+	}
+	return &RequestLog{Request: x, Level: level}
+}
+
+// Enabled reports whether the log records events of level. Callers check it before formatting a note, so that a log
+// that records nothing costs nothing.
+func (l *RequestLog) Enabled(level LogLevel) bool {
+	return l != nil && level <= l.Level
+}
+
+// now reads clock for an event the log is about to record, and doesn't read it at all for a log that records
+// nothing, so that a request without one pays for no timestamps.
+func (l *RequestLog) now(clock Clock) time.Time {
+	if !l.Enabled(LogInfo) {
+		return time.Time{}
+	}
+	return clock.Now()
+}
+
+// Begin records the start of a phase of the given kind, which was asked to take amount, if that is known.
+func (l *RequestLog) Begin(at time.Time, phase, msg string, amount time.Duration) {
+	if l.Enabled(LogInfo) {
+		l.Events = append(l.Events, Event{At: at, Level: LogInfo, Kind: eventBegin, Phase: phase, Msg: msg, Amount: amount})
+	}
+}
+
+// End records the end of the innermost phase still open.
+func (l *RequestLog) End(at time.Time) {
+	if l.Enabled(LogInfo) {
+		l.Events = append(l.Events, Event{At: at, Level: LogInfo, Kind: eventEnd})
+	}
+}
+
+// Debugf records a note within the current phase.
+func (l *RequestLog) Debugf(at time.Time, format string, args ...interface{}) {
+	if l.Enabled(LogDebug) {
+		l.Events = append(l.Events, Event{At: at, Level: LogDebug, Kind: eventNote, Msg: fmt.Sprintf(format, args...)})
+	}
+}
+
+// Append records events another log recorded, such as a remote target's, keeping those this log's level records.
+func (l *RequestLog) Append(events []Event) {
+	for _, e := range events {
+		if l.Enabled(e.Level) {
+			l.Events = append(l.Events, e)
+		}
+	}
+}
+
+func (l *RequestLog) name() string {
+	return fmt.Sprintf("Request %d", l.Request)
+}
+
+// String writes the log one event per line, with the time each phase took on its closing line.
+func (l *RequestLog) String() string {
+	if l == nil {
+		return ""
+	}
+	var b strings.Builder
+	var open []Event
+	for _, e := range l.Events {
+		fmt.Fprintf(&b, "[%s] %s: ", e.At.Format(time.StampMicro), l.name())
+		switch e.Kind {
+		case eventBegin:
+			open = append(open, e)
+			b.WriteString("+ " + e.label())
+		case eventEnd:
+			if len(open) == 0 {
+				b.WriteString("- ?")
+				break
+			}
+			begin := open[len(open)-1]
+			open = open[:len(open)-1]
+			fmt.Fprintf(&b, "- %s took %v", begin.label(), e.At.Sub(begin.At))
+		default:
+			b.WriteString("  " + e.Msg)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func (e Event) label() string {
+	if e.Amount > 0 {
+		return fmt.Sprintf("%v %s", e.Amount, e.Msg)
+	}
+	return e.Msg
+}
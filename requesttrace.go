@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// requestRecord is the line -request-trace writes for each completed request, for analysis the reports don't cover.
+// ID is unique to the request across runs and sweeps, so that traces can be joined with the target's own logs. Times are in ms; Start is from the start of the run. Phases holds the time spent in each kind of phase the request's
+// log recorded, leaving out enclosing spans such as middleware so that nothing is counted twice.
+type requestRecord struct {
+	ID          string             `json:"id"`
+	Request     int                `json:"request"`
+	Class       string             `json:"class,omitempty"`
+	Worker      int                `json:"worker"`
+	StartMs     float64            `json:"start_ms"`
+	QueueWaitMs float64            `json:"queue_wait_ms"`
+	LatencyMs   float64            `json:"latency_ms"`
+	Phases      map[string]float64 `json:"phases_ms,omitempty"`
+	TimedOut    bool               `json:"timed_out,omitempty"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// requestTraceWriter streams a requestRecord per completed request to a file as the run goes. It is called from the
+// collecting goroutine or, with sharded aggregation, from every shard, so it serializes writes itself; records are
+// buffered and may be out of request order.
+type requestTraceWriter struct {
+	runID string
+	mu    sync.Mutex
+	f     *os.File
+	w     *bufio.Writer
+	enc   *json.Encoder
+	err   error
+}
+
+func newRequestTraceWriter(path, runID string) (*requestTraceWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriterSize(f, 64<<10)
+	return &requestTraceWriter{runID: runID, f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (t *requestTraceWriter) write(result WorkResult) {
+	record := newRequestRecord(t.runID, result)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = t.enc.Encode(record)
+	}
+}
+
+// newRequestRecord describes a completed request of the run with the given ID.
+func newRequestRecord(runID string, result WorkResult) requestRecord {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	record := requestRecord{
+		ID:          requestID(runID, result.request),
+		Request:     result.request,
+		Class:       result.class,
+		Worker:      result.worker,
+		StartMs:     ms(result.finished - result.timeTaken),
+		QueueWaitMs: ms(result.queueWait),
+		LatencyMs:   ms(result.timeTaken),
+		Phases:      phaseDurations(result),
+		TimedOut:    result.timedOut,
+	}
+	if result.err != nil {
+		record.Error = result.err.Error()
+	}
+	return record
+}
+
+// Close flushes the records and closes the file, returning the first error writing them met.
+func (t *requestTraceWriter) Close() error {
+	if t == nil {
+		return nil
+	}
+	err := t.err
+	if flushErr := t.w.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := t.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// phaseDurations sums the request's innermost spans by kind, in ms; nil if it recorded none.
+func phaseDurations(result WorkResult) map[string]float64 {
+	timeline := result.log.timeline(result.timeTaken)
+	if len(timeline.Spans) == 0 {
+		return nil
+	}
+	phases := map[string]float64{}
+	for _, s := range timeline.Spans {
+		if isLeafSpan(s, timeline.Spans) {
+			phases[s.Kind] += float64(s.End-s.Start) / float64(time.Millisecond)
+		}
+	}
+	return phases
+}
+
+// workerSlots numbers the co-routines requests run on, whatever the executor: each request takes the lowest free
+// number for as long as it runs, so that with n requests in flight at most numbers 0 to n-1 are in use.
+type workerSlots struct {
+	mu   sync.Mutex
+	free []int // kept sorted, lowest last
+	next int
+}
+
+func (s *workerSlots) take() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n := len(s.free); n > 0 {
+		slot := s.free[n-1]
+		s.free = s.free[:n-1]
+		return slot
+	}
+	s.next++
+	return s.next - 1
+}
+
+func (s *workerSlots) put(slot int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := len(s.free)
+	s.free = append(s.free, slot)
+	for i > 0 && s.free[i-1] < slot {
+		s.free[i] = s.free[i-1]
+		i--
+	}
+	s.free[i] = slot
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// resultSchemaVersion is the version of jsonRunSummary written by this build. Fields may be added without a version
+// bump, since older readers ignore fields they don't know; renaming a field or changing its meaning requires bumping
+// the version and registering a migration from the previous one in resultMigrations.
+const resultSchemaVersion = 2
+
+type jsonRunSummary struct {
+	SchemaVersion        int                           `json:"schema_version"`
+	Started              time.Time                     `json:"started"`
+	Scenario             string                        `json:"scenario,omitempty"`
+	WorkTimeMs           float64                       `json:"work_time_ms"`
+	NetworkTimeMs        float64                       `json:"network_time_ms"`
+	Iterations           int                           `json:"iterations"`
+	NumCoroutines        int64                         `json:"num_coroutines"`
+	Splits               int                           `json:"splits"`
+	SplitsDist           string                        `json:"splits_dist,omitempty"` // empty for fixed
+	CPUKernel            string                        `json:"cpu_kernel,omitempty"`
+	GOMAXPROCS           int                           `json:"gomaxprocs,omitempty"`
+	Executor             string                        `json:"executor"`
+	Load                 string                        `json:"load"`
+	Seed                 int64                         `json:"seed,omitempty"`
+	Tags                 map[string]string             `json:"tags,omitempty"`
+	Note                 string                        `json:"note,omitempty"`
+	Commit               string                        `json:"commit,omitempty"`
+	Target               string                        `json:"target,omitempty"`
+	ThroughputRps        float64                       `json:"throughput_rps"`
+	Speedup              float64                       `json:"speedup"`
+	CpuUtilization       float64                       `json:"cpu_utilization"`
+	EffectiveCPUs        float64                       `json:"effective_cpus,omitempty"`
+	Errors               int                           `json:"errors"`
+	AbortError           string                        `json:"abort_error,omitempty"`
+	Failure              *Failure                      `json:"failure,omitempty"`
+	RequestTimeoutMs     float64                       `json:"request_timeout_ms,omitempty"`
+	Timeouts             int                           `json:"timeouts,omitempty"`
+	PropagateDeadline    bool                          `json:"propagate_deadline,omitempty"`
+	CompensateTimer      bool                          `json:"compensate_timer,omitempty"`
+	Dispatch             string                        `json:"dispatch,omitempty"`
+	ClassPriorities      map[string]int                `json:"class_priorities,omitempty"`
+	WastedWorkMs         float64                       `json:"wasted_work_ms,omitempty"`
+	WastedFraction       float64                       `json:"wasted_fraction,omitempty"`
+	CPUFreqMHz           []float64                     `json:"cpu_freq_mhz,omitempty"`
+	Memory               *MemoryUsage                  `json:"memory,omitempty"`
+	Cores                *CoreUsage                    `json:"cores,omitempty"`
+	ThrottleEvents       int64                         `json:"throttle_events,omitempty"`
+	PercentileRSE        float64                       `json:"percentile_rse,omitempty"`
+	QueueModel           *jsonQueueModel               `json:"mmc,omitempty"`
+	AvgConcurrency       float64                       `json:"avg_concurrency,omitempty"`
+	CPUSecondsPer1000    float64                       `json:"cpu_seconds_per_1000"`
+	JoulesPer1000        float64                       `json:"joules_per_1000,omitempty"`
+	Readiness            int                           `json:"readiness,omitempty"`
+	DeliverNsPerSample   int64                         `json:"deliver_ns_per_sample,omitempty"`
+	CollectNsPerSample   int64                         `json:"collect_ns_per_sample,omitempty"`
+	ResultBatch          int                           `json:"result_batch,omitempty"`
+	Measurement          *jsonMeasurement              `json:"measurement,omitempty"`
+	LowOverhead          bool                          `json:"low_overhead,omitempty"`
+	Simulated            bool                          `json:"simulated,omitempty"`
+	LatencyMs            map[string]float64            `json:"latency_ms"`
+	Outliers             *jsonOutliers                 `json:"outliers,omitempty"`
+	ThroughputIntervalMs float64                       `json:"throughput_interval_ms,omitempty"`
+	ThroughputSeries     []float64                     `json:"throughput_series_rps,omitempty"`
+	ClassLatencyMs       map[string]map[string]float64 `json:"class_latency_ms,omitempty"`
+	ClassCallerLatencyMs map[string]map[string]float64 `json:"class_caller_latency_ms,omitempty"`
+	PhaseLatencyMs       map[string]map[string]float64 `json:"phase_latency_ms,omitempty"`
+	QueueWaitMs          map[string]float64            `json:"queue_wait_ms,omitempty"`
+	ErrorLatencyMs       map[string]float64            `json:"error_latency_ms,omitempty"`
+	Faults               *Faults                       `json:"faults,omitempty"`
+	Retry                *RetryPolicy                  `json:"retry,omitempty"`
+	NetworkPhases        int64                         `json:"network_phases,omitempty"`
+	DownstreamCalls      int64                         `json:"downstream_calls,omitempty"`
+	Amplification        float64                       `json:"amplification,omitempty"`
+	Breaker              *BreakerPolicy                `json:"breaker,omitempty"`
+	Hedge                *HedgePolicy                  `json:"hedge,omitempty"`
+	Hedges               *HedgeStats                   `json:"hedges,omitempty"`
+	Bursts               *BurstStats                   `json:"bursts,omitempty"`
+	BreakerOpened        int64                         `json:"breaker_opened,omitempty"`
+	BreakerRejected      int64                         `json:"breaker_rejected,omitempty"`
+	BreakerOpenMs        float64                       `json:"breaker_open_ms,omitempty"`
+	Brownout             *jsonBrownout                 `json:"brownout,omitempty"`
+	QueueDepth           *int                          `json:"queue_depth,omitempty"`
+	Rejected             int                           `json:"rejected,omitempty"`
+	Accounting           *RequestAccounting            `json:"accounting,omitempty"`
+	Fairness             *WorkerFairness               `json:"worker_fairness,omitempty"`
+	RejectionRate        float64                       `json:"rejection_rate,omitempty"`
+	RateLimit            *RateLimit                    `json:"rate_limit,omitempty"`
+	RateLimitStats       *RateLimitStats               `json:"rate_limit_stats,omitempty"`
+	SQLMaxOpen           int                           `json:"sql_max_open,omitempty"`
+	SQLPool              *SQLPoolStats                 `json:"sql_pool,omitempty"`
+	MQConsumers          int                           `json:"mq_consumers,omitempty"`
+	FanOut               int                           `json:"fanout,omitempty"`
+	FanOutLimit          int                           `json:"fanout_limit,omitempty"`
+	Delivery             *DeliveryStats                `json:"delivery,omitempty"`
+	Disk                 *DiskStats                    `json:"disk,omitempty"`
+	Sessions             *SessionStats                 `json:"sessions,omitempty"`
+	Apdex                *ApdexScore                   `json:"apdex,omitempty"`
+	AdaptiveLimit        *AdaptiveLimitStats           `json:"adaptive_limit,omitempty"`
+	GOGC                 string                        `json:"gogc,omitempty"`
+	Placement            string                        `json:"numa_placement,omitempty"`
+	MemoryLimit          int64                         `json:"memory_limit_bytes,omitempty"`
+	GCCycles             uint64                        `json:"gc_cycles,omitempty"`
+	Scheduler            *SchedulerStats               `json:"scheduler,omitempty"`
+	SteadyState          *SteadyState                  `json:"steady_state,omitempty"`
+	Host                 *hostEnvironment              `json:"environment,omitempty"`
+	CallerLatencyMs      map[string]float64            `json:"caller_latency_ms,omitempty"`
+	MeanQueueLength      float64                       `json:"mean_queue_length,omitempty"`
+}
+
+func newRunSummary(result BenchmarkResult) jsonRunSummary {
+	pcts := result.Config.percentiles()
+	latency := latencySummary(result.ResponseTimes, pcts)
+	var classLatency, classCallerLatency map[string]map[string]float64
+	for _, class := range result.Classes {
+		if classLatency == nil {
+			classLatency = map[string]map[string]float64{}
+		}
+		classLatency[class.Name] = latencySummary(class.ResponseTimes, pcts)
+		if class.CallerTimes != nil && class.CallerTimes.Count() > 0 {
+			if classCallerLatency == nil {
+				classCallerLatency = map[string]map[string]float64{}
+			}
+			classCallerLatency[class.Name] = latencySummary(class.CallerTimes, pcts)
+		}
+	}
+	var classPriorities map[string]int
+	if result.Config.prioritized() {
+		classPriorities = map[string]int{}
+		for _, class := range result.Classes {
+			classPriorities[class.Name] = class.Priority
+		}
+	}
+	var queueWait, callerLatency map[string]float64
+	if result.QueueWaits != nil && result.QueueWaits.Count() > 0 {
+		queueWait = latencySummary(result.QueueWaits, pcts)
+		callerLatency = latencySummary(result.CallerTimes, pcts)
+	}
+	var errorLatency map[string]float64
+	if result.ErrorTimes != nil && result.ErrorTimes.Count() > 0 {
+		errorLatency = latencySummary(result.ErrorTimes, pcts)
+	}
+	var faults *Faults
+	if result.Config.Faults.enabled() {
+		faults = &result.Config.Faults
+	}
+	var retry *RetryPolicy
+	if result.Config.Retry.enabled() {
+		retry = &result.Config.Retry
+	}
+	var hedge *HedgePolicy
+	if result.Config.Hedge.enabled() {
+		hedge = &result.Config.Hedge
+	}
+	var breaker *BreakerPolicy
+	if result.Config.Breaker.enabled() {
+		breaker = &result.Config.Breaker
+	}
+	var rateLimit *RateLimit
+	if result.Config.RateLimit.enabled() {
+		rateLimit = &result.Config.RateLimit
+	}
+	var splitsDist string
+	if !result.Config.SplitsDist.fixed() {
+		splitsDist = result.Config.SplitsDist.String()
+	}
+	var gogc string
+	if result.Config.GOGC != 0 {
+		gogc = formatGOGC(result.Config.GOGC)
+	}
+	var queueDepth *int
+	if result.Config.ShedLoad {
+		queueDepth = &result.Config.QueueDepth
+	}
+	var accounting *RequestAccounting
+	if result.Accounting.Submitted > 0 {
+		accounting = &result.Accounting
+	}
+	var freqTrace []float64
+	for _, s := range result.FreqTrace {
+		freqTrace = append(freqTrace, s.AvgMHz)
+	}
+	var memory *MemoryUsage
+	if len(result.MemoryTrace) > 0 {
+		u := memoryUsage(result.MemoryTrace)
+		memory = &u
+	}
+	var abortError string
+	if result.Err != nil {
+		abortError = result.Err.Error()
+	}
+	executor := result.Config.Executor
+	if executor == "" {
+		executor = "semaphore"
+	}
+	// A run whose precision could never be estimated has an infinite error, which JSON cannot hold.
+	rse := result.PrecisionRSE
+	if math.IsInf(rse, 0) {
+		rse = 0
+	}
+	return jsonRunSummary{
+		SchemaVersion:        resultSchemaVersion,
+		WorkTimeMs:           float64(result.WorkTime) / float64(time.Millisecond),
+		NetworkTimeMs:        float64(result.NetworkTime) / float64(time.Millisecond),
+		Iterations:           result.Iterations,
+		NumCoroutines:        result.NumCoroutines,
+		Splits:               result.Config.Splits,
+		SplitsDist:           splitsDist,
+		CPUKernel:            result.Config.CPUKernel,
+		GOMAXPROCS:           result.Config.GOMAXPROCS,
+		Executor:             executor,
+		Load:                 result.Config.Load.String(),
+		Seed:                 result.Config.Seed,
+		Started:              result.Started,
+		Scenario:             result.Config.Scenario,
+		Tags:                 result.Config.Tags,
+		Note:                 result.Config.Note,
+		Commit:               result.Config.Commit,
+		Target:               targetName(result.Config.Target),
+		ThroughputRps:        result.ThroughputRps,
+		Speedup:              result.Speedup,
+		CpuUtilization:       result.CpuUtilization,
+		EffectiveCPUs:        result.EffectiveCPUs,
+		Errors:               result.Errors,
+		AbortError:           abortError,
+		Failure:              result.Failure,
+		RequestTimeoutMs:     float64(result.Config.RequestTimeout) / float64(time.Millisecond),
+		Timeouts:             result.Timeouts,
+		PropagateDeadline:    result.Config.PropagateDeadline,
+		CompensateTimer:      result.Config.CompensateTimer,
+		Dispatch:             result.Config.Dispatch,
+		ClassPriorities:      classPriorities,
+		WastedWorkMs:         float64(result.WastedWork) / float64(time.Millisecond),
+		WastedFraction:       result.WastedFraction,
+		CPUFreqMHz:           freqTrace,
+		Memory:               memory,
+		Cores:                coreUsage(result.CoreCPUs, result.CoreTrace),
+		ThrottleEvents:       result.ThrottleEvents,
+		PercentileRSE:        rse,
+		QueueModel:           newJsonQueueModel(result.QueueModel),
+		AvgConcurrency:       result.AvgConcurrency,
+		CPUSecondsPer1000:    result.CPUSecondsPer1000(),
+		JoulesPer1000:        result.JoulesPer1000(),
+		Readiness:            result.Config.Readiness,
+		DeliverNsPerSample:   int64(result.Harness.DeliverPerSample),
+		CollectNsPerSample:   int64(result.Harness.CollectPerSample),
+		ResultBatch:          result.Harness.Batch,
+		Measurement:          newJsonMeasurement(result.Measurement),
+		LowOverhead:          result.Config.LowOverhead,
+		Simulated:            result.Config.Simulate,
+		LatencyMs:            latency,
+		Outliers:             newJsonOutliers(result.Outliers, pcts),
+		ThroughputIntervalMs: float64(result.Config.ThroughputInterval) / float64(time.Millisecond),
+		ThroughputSeries:     result.ThroughputSeries,
+		ClassLatencyMs:       classLatency,
+		ClassCallerLatencyMs: classCallerLatency,
+		PhaseLatencyMs:       phaseLatency(result.Phases, pcts),
+		QueueWaitMs:          queueWait,
+		ErrorLatencyMs:       errorLatency,
+		Faults:               faults,
+		Retry:                retry,
+		NetworkPhases:        result.NetworkPhases,
+		DownstreamCalls:      result.DownstreamCalls,
+		Amplification:        result.Amplification(),
+		Breaker:              breaker,
+		Hedge:                hedge,
+		Hedges:               result.Hedges,
+		Bursts:               result.Bursts,
+		BreakerOpened:        result.Breaker.Opened,
+		BreakerRejected:      result.Breaker.Rejected,
+		BreakerOpenMs:        float64(result.Breaker.OpenTime) / float64(time.Millisecond),
+		Brownout:             newJsonBrownout(result.Brownout, pcts),
+		QueueDepth:           queueDepth,
+		Rejected:             result.Rejected,
+		Accounting:           accounting,
+		Fairness:             result.Fairness,
+		RejectionRate:        result.RejectionRate(),
+		RateLimit:            rateLimit,
+		RateLimitStats:       result.RateLimitStats,
+		SQLMaxOpen:           result.Config.SQLMaxOpen,
+		SQLPool:              result.SQLPool,
+		MQConsumers:          result.Config.MQConsumers,
+		FanOut:               result.Config.FanOut,
+		FanOutLimit:          result.Config.FanOutLimit,
+		Delivery:             result.Delivery,
+		Disk:                 result.DiskStats,
+		Sessions:             result.Sessions,
+		Apdex:                result.Apdex(),
+		AdaptiveLimit:        adaptiveLimitStats(result.LimitTrace),
+		GOGC:                 gogc,
+		Placement:            result.Config.Placement,
+		MemoryLimit:          result.Config.MemoryLimit,
+		GCCycles:             result.GCCycles,
+		Scheduler:            result.Scheduler,
+		SteadyState:          result.SteadyState,
+		Host:                 result.Host,
+		CallerLatencyMs:      callerLatency,
+		MeanQueueLength:      result.QueueLength,
+	}
+}
+
+// latencySummary keys the chosen percentiles of c by their names, such as p99.9, next to its min, mean and stddev. A
+// collector holding no response times, as in a run where none completed in time, gives an empty map rather than NaNs.
+func latencySummary(c Collector, pcts []float64) map[string]float64 {
+	summary := map[string]float64{}
+	if c.Count() == 0 {
+		return summary
+	}
+	for _, pct := range pcts {
+		summary[percentileName(pct)] = c.Percentile(pct)
+	}
+	stats := latencyStats(c)
+	summary["min"], summary["mean"], summary["stddev"] = stats.Min, stats.Mean, stats.StdDev
+	return summary
+}
+
+// resultMigrations[v] upgrades a raw record from schema version v to v+1. Records written before versioning was
+// introduced have no schema_version and are treated as version 0.
+var resultMigrations = map[int]func(record map[string]interface{}){
+	// Version 0 always ran a closed-loop semaphore executor and had no error accounting.
+	0: func(record map[string]interface{}) {
+		setDefault(record, "executor", "semaphore")
+		setDefault(record, "load", LoadSpec{}.String())
+		setDefault(record, "errors", 0)
+	},
+	// Version 1 always interleaved each request's CPU work with five network phases.
+	1: func(record map[string]interface{}) {
+		setDefault(record, "splits", 5)
+	},
+}
+
+func setDefault(record map[string]interface{}, key string, value interface{}) {
+	if _, ok := record[key]; !ok {
+		record[key] = value
+	}
+}
+
+// migrateRecord brings a raw record up to resultSchemaVersion. Records from a newer version are passed through
+// unchanged: their known fields still decode, and fields added since are ignored.
+func migrateRecord(record map[string]interface{}) error {
+	version := 0
+	if v, ok := record["schema_version"]; ok {
+		f, ok := v.(float64)
+		if !ok {
+			return fmt.Errorf("invalid schema_version %v", v)
+		}
+		version = int(f)
+	}
+	for ; version < resultSchemaVersion; version++ {
+		migrate, ok := resultMigrations[version]
+		if !ok {
+			return fmt.Errorf("no migration from result schema version %d", version)
+		}
+		migrate(record)
+		record["schema_version"] = version + 1
+	}
+	return nil
+}
+
+// errSavedSweep is decodeSummary's error for a sweep saved with -save, which holds whole runs rather than summaries.
+var errSavedSweep = errors.New("a sweep saved with -save, not a result")
+
+// loadResults reads the summaries of a results file written by the json reporter or, given a sweep saved with -save,
+// the summaries of its runs.
+func loadResults(path string) ([]jsonRunSummary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var summaries []jsonRunSummary
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		summary, err := decodeSummary(scanner.Bytes())
+		if err == errSavedSweep && len(summaries) == 0 {
+			return loadSweepSummaries(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, scanner.Err()
+}
+
+func loadSweepSummaries(path string) ([]jsonRunSummary, error) {
+	sweep, err := loadSweep(path)
+	if err != nil {
+		return nil, err
+	}
+	var summaries []jsonRunSummary
+	for _, run := range sweep.Runs {
+		summaries = append(summaries, newRunSummary(run.result()))
+	}
+	return summaries, nil
+}
+
+// decodeSummary reads one JSON-encoded result of any schema version. A record missing what every version records,
+// its co-routine count and throughput, is refused rather than read as a run that measured nothing, so that a file
+// of something else can't pass for results of zero.
+func decodeSummary(b []byte) (jsonRunSummary, error) {
+	var summary jsonRunSummary
+	var record map[string]interface{}
+	if err := json.Unmarshal(b, &record); err != nil {
+		return summary, err
+	}
+	if _, ok := record["runs"]; ok {
+		if _, ok := record["environment"]; ok {
+			return summary, errSavedSweep
+		}
+	}
+	for _, field := range []string{"num_coroutines", "throughput_rps"} {
+		if _, ok := record[field]; !ok {
+			return summary, fmt.Errorf("not a result: no %s", field)
+		}
+	}
+	if err := migrateRecord(record); err != nil {
+		return summary, err
+	}
+	if executor, _ := record["executor"].(string); executor == "" {
+		return summary, errors.New("not a result: no executor")
+	}
+	migrated, err := json.Marshal(record)
+	if err != nil {
+		return summary, err
+	}
+	err = json.Unmarshal(migrated, &summary)
+	return summary, err
+}
+
+func writeResults(path string, summaries []jsonRunSummary) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, s := range summaries {
+		if err := enc.Encode(s); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func migrateCommand(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	out := fs.String("o", "", "output file (default: rewrite the input in place)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s migrate [-o out.jsonl] results.jsonl\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	summaries, err := loadResults(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dest := *out
+	if dest == "" {
+		dest = fs.Arg(0)
+	}
+	for i, s := range summaries {
+		if s.SchemaVersion > resultSchemaVersion {
+			return fmt.Errorf("%s: result %d has schema version %d, newer than %d; rewriting it would drop fields", fs.Arg(0), i+1, s.SchemaVersion, resultSchemaVersion)
+		}
+	}
+	if err := writeResults(dest, summaries); err != nil {
+		return err
+	}
+	fmt.Printf("Migrated %d results to schema version %d in %s\n", len(summaries), resultSchemaVersion, dest)
+	return nil
+}
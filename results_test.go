@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestDecodeSummary(t *testing.T) {
+	s, err := decodeSummary([]byte(`{"schema_version":0,"num_coroutines":4,"throughput_rps":12.5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Executor != "semaphore" || s.NumCoroutines != 4 || s.ThroughputRps != 12.5 {
+		t.Errorf("version 0 record decoded as %+v", s)
+	}
+	if _, err := decodeSummary([]byte(`{"environment":{},"runs":[]}`)); err != errSavedSweep {
+		t.Errorf("saved sweep decoded with error %v, want errSavedSweep", err)
+	}
+	for _, bad := range []string{
+		`{}`,
+		`{"executor":"pool","num_coroutines":4}`,
+		`{"schema_version":1,"executor":"","num_coroutines":4,"throughput_rps":1}`,
+	} {
+		if _, err := decodeSummary([]byte(bad)); err == nil {
+			t.Errorf("decodeSummary(%s) succeeded", bad)
+		}
+	}
+}
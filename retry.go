@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// RetryPolicy retries a failed network phase, as a client library would retry a call to a flaky downstream: up to
+// MaxAttempts calls in all, waiting Backoff after the first failure and twice as long after each one after that, up to
+// MaxBackoff. Jitter shortens each wait by up to that fraction at random, so that requests that failed together don't
+// retry together. A MaxAttempts of one or less never retries.
+type RetryPolicy struct {
+	MaxAttempts int           `json:"max_attempts"`
+	Backoff     time.Duration `json:"backoff"`
+	MaxBackoff  time.Duration `json:"max_backoff,omitempty"`
+	Jitter      float64       `json:"jitter,omitempty"`
+}
+
+func (p RetryPolicy) enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+func (p RetryPolicy) validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("retries need at least one attempt, got %d", p.MaxAttempts)
+	}
+	if p.Backoff < 0 || p.MaxBackoff < 0 {
+		return errors.New("retry backoff must not be negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be between 0 and 1, got %g", p.Jitter)
+	}
+	return nil
+}
+
+// backoff is the wait before the given retry, counted from 1.
+func (p RetryPolicy) backoff(retry int, r *rand.Rand) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 - p.Jitter*r.Float64()))
+	}
+	return d
+}
+
+func (p RetryPolicy) String() string {
+	s := fmt.Sprintf("up to %d attempts, backing off from %v", p.MaxAttempts, p.Backoff)
+	if p.MaxBackoff > 0 {
+		s += fmt.Sprintf(" to %v", p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		s += " with " + formatPercent(p.Jitter) + " jitter"
+	}
+	return s
+}
+
+// downstream is a run's view of the downstream behind its network phases, which retries, the circuit breaker and
+// fault windows share: it counts the network phases the requests made and the calls it took to make them once
+// retries are counted, and holds the breaker and the time the run started. A nil downstream counts nothing and lets
+// every call through.
+type downstream struct {
+	start   time.Time       // set as the run starts, before any request
+	clock   Clock           // that start was read from
+	breaker *circuitBreaker // nil unless Config.Breaker is enabled
+	phases  int64
+	calls   int64
+	// duplicate calls hedging sent, and the phases one of them answered for
+	hedges    int64
+	hedgeWins int64
+}
+
+func newDownstream(cfg RunConfig) *downstream {
+	d := &downstream{clock: cfg.clock()}
+	if cfg.Breaker.enabled() {
+		d.breaker = newCircuitBreaker(cfg.Breaker, d.clock)
+	}
+	return d
+}
+
+// elapsed is the time since the run started.
+func (d *downstream) elapsed() time.Duration {
+	if d == nil {
+		return 0
+	}
+	return d.clock.Now().Sub(d.start)
+}
+
+func (d *downstream) allow() bool {
+	return d == nil || d.breaker == nil || d.breaker.allow()
+}
+
+func (d *downstream) record(failed bool) {
+	if d != nil && d.breaker != nil {
+		d.breaker.record(failed)
+	}
+}
+
+func (d *downstream) add(calls int) {
+	if d != nil {
+		atomic.AddInt64(&d.phases, 1)
+		atomic.AddInt64(&d.calls, int64(calls))
+	}
+}
+
+func (d *downstream) phasesMade() int64 {
+	if d == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&d.phases)
+}
+
+func (d *downstream) callsMade() int64 {
+	if d == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&d.calls)
+}
+
+func (d *downstream) breakerStats() BreakerStats {
+	if d == nil {
+		return BreakerStats{}
+	}
+	return d.breaker.stats()
+}
+
+// Amplification is how many calls the downstream saw for each one the requests meant to make: one without retries,
+// more the more of them were retried. Zero where it was not counted.
+func (b BenchmarkResult) Amplification() float64 {
+	if b.NetworkPhases == 0 {
+		return 0
+	}
+	return float64(b.DownstreamCalls) / float64(b.NetworkPhases)
+}
+
+// plotAmplification draws the amplification and the throughput of successful requests against the co-routine count
+// for sweeps that retried: as load rises and calls fail more, retries add load of their own.
+func plotAmplification(results []BenchmarkResult, opts PlotOptions) error {
+	if !results[0].Config.Retry.enabled() {
+		return nil
+	}
+	amplification, goodput := plot.New(), plot.New()
+	amplification.Title.Text = "Retry Amplification vs. Number of Co-Routines"
+	amplification.Y.Label.Text = "Downstream calls per network phase"
+	goodput.Title.Text = "Successful Throughput vs. Number of Co-Routines"
+	goodput.Y.Label.Text = "Successful requests per second"
+	labels, series := bySeries(results)
+	for i, label := range labels {
+		for _, panel := range []struct {
+			plt *plot.Plot
+			y   func(BenchmarkResult) float64
+		}{
+			{amplification, BenchmarkResult.Amplification},
+			{goodput, func(r BenchmarkResult) float64 {
+				if r.Iterations == 0 {
+					return 0
+				}
+				return r.ThroughputRps * float64(r.Iterations-r.Errors-r.Timeouts) / float64(r.Iterations)
+			}},
+		} {
+			line, err := sweepLine(panel.plt, series[label], panel.y, plotutil.Color(i))
+			if err != nil {
+				return err
+			}
+			if line != nil && len(labels) > 1 {
+				panel.plt.Legend.Add(label, line)
+			}
+		}
+	}
+	for _, plt := range []*plot.Plot{amplification, goodput} {
+		plt.X.Label.Text = "Number of Co-Routines"
+		plt.Y.Min = 0
+		plt.Legend.Top = true
+		opts.fitCoroutines(&plt.X)
+	}
+	if err := opts.save(amplification, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("amplification_vs_coroutines.png")); err != nil {
+		return err
+	}
+	return opts.save(goodput, 6*vg.Inch, 4*vg.Inch, opts.sweepFile("goodput_vs_coroutines.png"))
+}
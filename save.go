@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// savedSweep is everything a sweep measured, so that its reports and plots can be rendered again later, or with other
+// plot options, without rerunning it. Each run carries the fields of its jsonRunSummary alongside what only a
+// rendering needs: its response times and the timelines and budgets drawn from them.
+type savedSweep struct {
+	Environment sweepEnvironment `json:"environment"`
+	Runs        []savedRun       `json:"runs"`
+}
+
+// sweepEnvironment records the machine and build a sweep ran on, which a result means nothing without.
+type sweepEnvironment struct {
+	hostEnvironment
+	Args    []string  `json:"args"`
+	Started time.Time `json:"started"`
+	// The units and formatting the sweep's console output and plots were written in, which render writes them in again
+	// unless told otherwise. The runs themselves are always in ms and rps.
+	Units *OutputUnits `json:"units,omitempty"`
+}
+
+// hostEnvironment is the part of the environment every run records too, so that results from different machines
+// can be told apart once they are mixed.
+type hostEnvironment struct {
+	GoVersion  string           `json:"go_version"`
+	OS         string           `json:"os"`
+	Arch       string           `json:"arch"`
+	CPUModel   string           `json:"cpu_model,omitempty"`
+	NumCPU     int              `json:"num_cpu"`
+	GOMAXPROCS int              `json:"gomaxprocs"`
+	CPUQuota   float64          `json:"cpu_quota,omitempty"`    // CPUs the container's cgroup allows; zero for no quota
+	CPUs       string           `json:"cpu_affinity,omitempty"` // the CPUs -cpu-affinity pinned the process to, as a cpulist
+	NUMANodes  []NUMANode       `json:"numa_nodes,omitempty"`
+	Hostname   string           `json:"hostname,omitempty"`
+	ToolCommit string           `json:"tool_commit,omitempty"` // revision this tool was built from, where the build recorded it
+	Timer      TimerCalibration `json:"timer_calibration,omitempty"`
+}
+
+func currentEnvironment(args []string) sweepEnvironment {
+	u := units
+	return sweepEnvironment{hostEnvironment: *currentHost(), Args: args, Started: time.Now(), Units: &u}
+}
+
+var (
+	hostOnce sync.Once
+	host     hostEnvironment
+)
+
+// currentHost describes the machine and build this process runs on, read once: none of it changes while it runs,
+// bar GOMAXPROCS, which runs record in their configuration.
+func currentHost() *hostEnvironment {
+	hostOnce.Do(func() {
+		hostname, _ := os.Hostname()
+		quota, _ := cgroupCPULimit()
+		host = hostEnvironment{
+			GoVersion:  runtime.Version(),
+			OS:         runtime.GOOS,
+			Arch:       runtime.GOARCH,
+			CPUModel:   cpuModel(),
+			NumCPU:     runtime.NumCPU(),
+			GOMAXPROCS: runtime.GOMAXPROCS(0),
+			CPUQuota:   quota,
+			CPUs:       formatCPUList(pinnedCPUs),
+			NUMANodes:  numaTopology(),
+			Hostname:   hostname,
+			ToolCommit: toolCommit(),
+			Timer:      timerCalibration(),
+		}
+	})
+	e := host
+	return &e
+}
+
+// cpuModel is the model name of the first CPU in /proc/cpuinfo, or empty on systems without it.
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if colon := strings.Index(line, ":"); colon >= 0 && strings.TrimSpace(line[:colon]) == "model name" {
+			return strings.TrimSpace(line[colon+1:])
+		}
+	}
+	return ""
+}
+
+func (e hostEnvironment) String() string {
+	cpu := e.CPUModel
+	if cpu == "" {
+		cpu = "unknown CPU"
+	}
+	s := fmt.Sprintf("%s %s/%s on %s, %d CPUs, GOMAXPROCS=%d", e.GoVersion, e.OS, e.Arch, cpu, e.NumCPU, e.GOMAXPROCS)
+	if e.CPUQuota > 0 {
+		s += ", CPU quota " + formatFloat(e.CPUQuota, 2)
+	}
+	if len(e.NUMANodes) > 1 {
+		nodes := make([]string, len(e.NUMANodes))
+		for i, node := range e.NUMANodes {
+			nodes[i] = fmt.Sprintf("%d: %s", node.ID, node.CPUs)
+		}
+		s += fmt.Sprintf(", %d NUMA nodes (%s)", len(nodes), strings.Join(nodes, "; "))
+	}
+	if e.CPUs != "" {
+		s += ", pinned to CPUs " + e.CPUs
+	}
+	if e.ToolCommit != "" {
+		s += ", built from " + e.ToolCommit
+	}
+	return s
+}
+
+type savedRun struct {
+	jsonRunSummary
+	Repetition     int               `json:"repetition,omitempty"`
+	LoadSpec       LoadSpec          `json:"load_spec"`
+	CPUDist        string            `json:"cpu_dist"`
+	NetworkDist    string            `json:"network_dist"`
+	Samples        savedSamples      `json:"samples"`
+	Classes        []savedClass      `json:"classes,omitempty"`
+	ClassCallers   []savedClass      `json:"class_callers,omitempty"` // caller times, queue wait included, of Classes
+	LongestRequest string            `json:"longest_request,omitempty"`
+	Slowest        []RequestTimeline `json:"slowest,omitempty"`
+	Budget         *LatencyBudgets   `json:"budget,omitempty"`
+	QueueModel     *QueueModel       `json:"queue_model,omitempty"`
+	FreqTrace      []FreqSample      `json:"freq_trace,omitempty"`
+	LimitTrace     []LimitSample     `json:"limit_trace,omitempty"`
+	MemoryTrace    []MemSample       `json:"memory_trace,omitempty"`
+	CoreCPUs       []int             `json:"core_cpus,omitempty"`
+	CoreTrace      []CoreSample      `json:"core_trace,omitempty"`
+	SteadyState    *SteadyState      `json:"steady_state,omitempty"`
+	ThreadsCreated int               `json:"threads_created,omitempty"`
+	Percentiles    []float64         `json:"percentiles,omitempty"`
+	OutlierMethod  string            `json:"outlier_method,omitempty"`
+	LatencyTrace   []TracePoint      `json:"latency_trace,omitempty"`
+	Phases         []savedClass      `json:"phases,omitempty"`
+	QueueWaits     *savedSamples     `json:"queue_waits,omitempty"`
+	CallerTimes    *savedSamples     `json:"caller_times,omitempty"`
+	ErrorTimes     *savedSamples     `json:"error_times,omitempty"`
+	BrownoutTimes  *savedSamples     `json:"brownout_times,omitempty"`
+}
+
+type savedClass struct {
+	Name    string       `json:"name"`
+	Samples savedSamples `json:"samples"`
+}
+
+// savedSamples holds a run's response times either as they were recorded or compressed into the buckets of the hdr
+// collector, which keeps the file small for long runs at the cost of under 1% error in every value.
+type savedSamples struct {
+	RawMs     []float64     `json:"raw_ms,omitempty"`
+	Histogram []savedBucket `json:"histogram,omitempty"`
+}
+
+type savedBucket struct {
+	Index int    `json:"i"`
+	Count uint64 `json:"n"`
+}
+
+func saveSamples(c Collector, histogram bool) savedSamples {
+	if h, ok := c.(*hdrCollector); ok || histogram {
+		if !ok {
+			h = newHdrCollector()
+			for _, v := range c.Values() {
+				h.Add(v)
+			}
+		}
+		var s savedSamples
+		for idx, n := range h.counts {
+			if n > 0 {
+				s.Histogram = append(s.Histogram, savedBucket{idx, n})
+			}
+		}
+		return s
+	}
+	return savedSamples{RawMs: c.Values()}
+}
+
+func (s savedSamples) collector() Collector {
+	if s.Histogram == nil {
+		return &exactCollector{values: s.RawMs}
+	}
+	h := newHdrCollector()
+	for _, b := range s.Histogram {
+		if b.Index < 0 || b.Index >= len(h.counts) {
+			continue
+		}
+		h.counts[b.Index] += b.Count
+		h.count += int(b.Count)
+	}
+	return h
+}
+
+func newSavedRun(result BenchmarkResult, histogram bool) savedRun {
+	run := savedRun{
+		jsonRunSummary: newRunSummary(result),
+		Repetition:     result.Config.Repetition,
+		LoadSpec:       result.Config.Load,
+		CPUDist:        result.Config.CPUDist.String(),
+		NetworkDist:    result.Config.NetworkDist.String(),
+		Samples:        saveSamples(result.ResponseTimes, histogram),
+		LongestRequest: result.LongestRequest,
+		Slowest:        result.Slowest,
+		Budget:         result.Budget,
+		QueueModel:     result.QueueModel,
+		FreqTrace:      result.FreqTrace,
+		LimitTrace:     result.LimitTrace,
+		MemoryTrace:    result.MemoryTrace,
+		CoreCPUs:       result.CoreCPUs,
+		CoreTrace:      result.CoreTrace,
+		SteadyState:    result.SteadyState,
+		ThreadsCreated: result.ThreadsCreated,
+		Percentiles:    result.Config.Percentiles,
+		OutlierMethod:  result.Config.Outliers,
+		LatencyTrace:   result.LatencyTrace,
+	}
+	for _, class := range result.Classes {
+		run.Classes = append(run.Classes, savedClass{class.Name, saveSamples(class.ResponseTimes, histogram)})
+		if class.CallerTimes != nil {
+			run.ClassCallers = append(run.ClassCallers, savedClass{class.Name, saveSamples(class.CallerTimes, histogram)})
+		}
+	}
+	for _, phase := range result.Phases {
+		run.Phases = append(run.Phases, savedClass{phase.Name, saveSamples(phase.Times, histogram)})
+	}
+	if result.QueueWaits != nil {
+		waits, caller := saveSamples(result.QueueWaits, histogram), saveSamples(result.CallerTimes, histogram)
+		run.QueueWaits, run.CallerTimes = &waits, &caller
+	}
+	if result.ErrorTimes != nil && result.ErrorTimes.Count() > 0 {
+		errorTimes := saveSamples(result.ErrorTimes, histogram)
+		run.ErrorTimes = &errorTimes
+	}
+	if b := result.Brownout; b != nil && b.ResponseTimes != nil && b.ResponseTimes.Count() > 0 {
+		brownoutTimes := saveSamples(b.ResponseTimes, histogram)
+		run.BrownoutTimes = &brownoutTimes
+	}
+	return run
+}
+
+// result rebuilds the BenchmarkResult the run was saved from, as far as reports and plots need it.
+func (r savedRun) result() BenchmarkResult {
+	ms := func(v float64) time.Duration { return time.Duration(v * float64(time.Millisecond)) }
+	responseTimes := r.Samples.collector()
+	result := BenchmarkResult{
+		WorkTime:         ms(r.WorkTimeMs),
+		NetworkTime:      ms(r.NetworkTimeMs),
+		Iterations:       r.Iterations,
+		NumCoroutines:    r.NumCoroutines,
+		ThroughputRps:    r.ThroughputRps,
+		Speedup:          r.Speedup,
+		CpuUtilization:   r.CpuUtilization,
+		EffectiveCPUs:    r.EffectiveCPUs,
+		ResponseTimes:    responseTimes,
+		Latency:          latencyStats(responseTimes),
+		Outliers:         detectOutliers(r.OutlierMethod, responseTimes),
+		ThroughputSeries: r.ThroughputSeries,
+		LatencyTrace:     r.LatencyTrace,
+		LongestRequest:   r.LongestRequest,
+		Errors:           r.Errors,
+		FreqTrace:        r.FreqTrace,
+		LimitTrace:       r.LimitTrace,
+		MemoryTrace:      r.MemoryTrace,
+		CoreCPUs:         r.CoreCPUs,
+		CoreTrace:        r.CoreTrace,
+		ThrottleEvents:   r.ThrottleEvents,
+		CPUTime:          time.Duration(r.CPUSecondsPer1000 * float64(r.Iterations) / 1000 * float64(time.Second)),
+		EnergyJoules:     r.JoulesPer1000 * float64(r.Iterations) / 1000,
+		PrecisionRSE:     r.PercentileRSE,
+		QueueModel:       r.QueueModel,
+		AvgConcurrency:   r.AvgConcurrency,
+		ThreadsCreated:   r.ThreadsCreated,
+		Harness: HarnessOverhead{
+			DeliverPerSample: time.Duration(r.DeliverNsPerSample),
+			CollectPerSample: time.Duration(r.CollectNsPerSample),
+			Batch:            r.ResultBatch,
+		},
+		Measurement:    r.Measurement.overhead(),
+		Timeouts:       r.Timeouts,
+		WastedWork:     ms(r.WastedWorkMs),
+		WastedFraction: r.WastedFraction,
+		Failure:        r.Failure,
+		Slowest:        r.Slowest,
+		Started:        r.Started,
+		Budget:         r.Budget,
+		Config: RunConfig{
+			WorkTime:           ms(r.WorkTimeMs),
+			NetworkTime:        ms(r.NetworkTimeMs),
+			NumCoroutines:      r.NumCoroutines,
+			Splits:             r.Splits,
+			CPUKernel:          r.CPUKernel,
+			Load:               r.LoadSpec,
+			Executor:           r.Executor,
+			Iterations:         r.Iterations,
+			Seed:               r.Seed,
+			GOMAXPROCS:         r.GOMAXPROCS,
+			Readiness:          r.Readiness,
+			RequestTimeout:     ms(r.RequestTimeoutMs),
+			Dispatch:           r.Dispatch,
+			PropagateDeadline:  r.PropagateDeadline,
+			CompensateTimer:    r.CompensateTimer,
+			Tags:               Tags(r.Tags),
+			Note:               r.Note,
+			Commit:             r.Commit,
+			Scenario:           r.Scenario,
+			Repetition:         r.Repetition,
+			Percentiles:        r.Percentiles,
+			Outliers:           r.OutlierMethod,
+			ThroughputInterval: ms(r.ThroughputIntervalMs),
+		},
+	}
+	// Both were written by Distribution's String, which parseDistribution reads back.
+	result.Config.CPUDist, _ = parseDistribution(r.CPUDist)
+	result.Config.NetworkDist, _ = parseDistribution(r.NetworkDist)
+	result.Config.SplitsDist, _ = parseSplitsDist(r.SplitsDist)
+	if r.AbortError != "" {
+		result.Err = errors.New(r.AbortError)
+	}
+	for i, class := range r.Classes {
+		result.Classes = append(result.Classes, ClassResult{Name: class.Name, Priority: r.ClassPriorities[class.Name],
+			ResponseTimes: class.Samples.collector()})
+		if i < len(r.ClassCallers) {
+			result.Classes[i].CallerTimes = r.ClassCallers[i].Samples.collector()
+		}
+	}
+	for _, phase := range r.Phases {
+		result.Phases = append(result.Phases, PhaseTimes{phase.Name, phase.Samples.collector()})
+	}
+	result.Config.Phases = len(result.Phases) > 0
+	if r.SchemaVersion < 2 {
+		result.Config.Splits = 5 // as every run did before the summary recorded it
+	}
+	if r.QueueWaits != nil && r.CallerTimes != nil {
+		result.QueueWaits, result.CallerTimes = r.QueueWaits.collector(), r.CallerTimes.collector()
+	}
+	if r.ErrorTimes != nil {
+		result.ErrorTimes = r.ErrorTimes.collector()
+	}
+	if r.Faults != nil {
+		result.Config.Faults = *r.Faults
+	}
+	if r.Retry != nil {
+		result.Config.Retry = *r.Retry
+	}
+	result.NetworkPhases, result.DownstreamCalls = r.NetworkPhases, r.DownstreamCalls
+	if r.Hedge != nil {
+		result.Config.Hedge = *r.Hedge
+	}
+	result.Hedges = r.Hedges
+	result.Bursts = r.Bursts
+	if r.Breaker != nil {
+		result.Config.Breaker = *r.Breaker
+	}
+	result.Breaker = BreakerStats{Opened: r.BreakerOpened, Rejected: r.BreakerRejected,
+		OpenTime: time.Duration(r.BreakerOpenMs * float64(time.Millisecond))}
+	if r.QueueDepth != nil {
+		result.Config.ShedLoad, result.Config.QueueDepth = true, *r.QueueDepth
+	}
+	result.Rejected = r.Rejected
+	result.Fairness = r.Fairness
+	if r.Accounting != nil {
+		result.Accounting = *r.Accounting
+	}
+	result.Config.LowOverhead = r.LowOverhead
+	result.Config.Simulate = r.Simulated
+	if r.RateLimit != nil {
+		result.Config.RateLimit = *r.RateLimit
+	}
+	result.RateLimitStats = r.RateLimitStats
+	result.Config.SQLMaxOpen = r.SQLMaxOpen
+	result.SQLPool = r.SQLPool
+	result.Config.MQConsumers = r.MQConsumers
+	result.Config.FanOut, result.Config.FanOutLimit = r.FanOut, r.FanOutLimit
+	result.Delivery = r.Delivery
+	result.DiskStats = r.Disk
+	result.Sessions = r.Sessions
+	result.Host = r.Host
+	result.Scheduler = r.Scheduler
+	result.SteadyState = r.SteadyState
+	result.Config.Placement = r.Placement
+	if r.GOGC != "" {
+		if gogc, err := parseGOGC(r.GOGC); err == nil {
+			result.Config.GOGC = gogc[0]
+		}
+	}
+	result.Config.MemoryLimit, result.GCCycles = r.MemoryLimit, r.GCCycles
+	if r.Apdex != nil {
+		result.Config.Apdex = r.Apdex.Threshold
+	}
+	if b := r.Brownout; b != nil {
+		result.Brownout = &Brownout{
+			Start:    time.Duration(b.StartMs * float64(time.Millisecond)),
+			Duration: time.Duration(b.DurationMs * float64(time.Millisecond)),
+			Requests: b.Requests,
+			Errors:   b.Errors,
+			Timeouts: b.Timeouts,
+		}
+		if r.BrownoutTimes != nil {
+			result.Brownout.ResponseTimes = r.BrownoutTimes.collector()
+		}
+	}
+	result.QueueLength = r.MeanQueueLength
+	return result
+}
+
+// saveReporter collects every run of the sweep and writes them, with the environment, to one file once it is done.
+type saveReporter struct {
+	path      string
+	histogram bool
+	sweep     savedSweep
+}
+
+func newSaveReporter(path string, histogram bool, args []string) *saveReporter {
+	return &saveReporter{path: path, histogram: histogram, sweep: savedSweep{Environment: currentEnvironment(args)}}
+}
+
+func (r *saveReporter) OnRunStart(cfg RunConfig) {}
+
+func (r *saveReporter) OnSample(sample Sample) {}
+
+func (r *saveReporter) OnRunComplete(result BenchmarkResult) error {
+	r.sweep.Runs = append(r.sweep.Runs, newSavedRun(result, r.histogram))
+	return nil
+}
+
+func (r *saveReporter) Close() error {
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := json.NewEncoder(w).Encode(r.sweep); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func loadSweep(path string) (savedSweep, error) {
+	var sweep savedSweep
+	f, err := os.Open(path)
+	if err != nil {
+		return sweep, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&sweep); err != nil {
+		return sweep, fmt.Errorf("%s: %w", path, err)
+	}
+	return sweep, nil
+}
+
+// renderCommand renders the reports and plots of a sweep saved with -save again, without rerunning it.
+func renderCommand(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	reporters := fs.String("report", "console,plots", "comma-separated reports to render: console, plots")
+	outDir := fs.String("out-dir", "", "write plots to a new directory under this one, as a sweep does (default: fixed names in the working directory)")
+	ascii := fs.Bool("ascii", false, "render histograms and percentiles in the terminal")
+	templates := fs.String("template", "", "comma-separated text/template files to render, as for the sweep's -template")
+	plotOptions := plotFlags(fs)
+	applyUnits := unitFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s render [flags] sweep.json\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	plots, err := plotOptions()
+	if err != nil {
+		return err
+	}
+	sweep, err := loadSweep(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(sweep.Runs) == 0 {
+		return fmt.Errorf("%s holds no runs", fs.Arg(0))
+	}
+	if err := applyUnits(); err != nil {
+		return err
+	}
+	if u := sweep.Environment.Units; u != nil && u.validate() == nil && !unitFlagsSet(fs) {
+		units = *u
+	}
+
+	env := sweep.Environment
+	fmt.Printf("Sweep of %s, started %s: %s\n", filepath.Base(fs.Arg(0)), env.Started.Format(time.RFC3339), env)
+	if len(env.Args) > 0 {
+		fmt.Printf("Flags: %s\n", strings.Join(env.Args, " "))
+	}
+	if *outDir != "" {
+		if plots.Out, err = newArtifactStore(*outDir, env.Started); err != nil {
+			return err
+		}
+		defer plots.Out.Close()
+		var cfgs []RunConfig
+		for _, run := range sweep.Runs {
+			cfgs = append(cfgs, run.result().Config)
+		}
+		plots.Out.dims = sweptDims(cfgs)
+		fmt.Printf("Writing artifacts to %s\n", plots.Out.dir)
+	}
+	var names []string
+	for _, name := range strings.Split(*reporters, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "console", "plots":
+			if name != "plots" || !*ascii {
+				names = append(names, name)
+			}
+		case "":
+		default:
+			return fmt.Errorf("unknown report %q, expected console or plots", name)
+		}
+	}
+	reporter, err := newReporter(names, "", "", plots)
+	if err != nil {
+		return err
+	}
+	for _, r := range reporter {
+		if c, ok := r.(*consoleReporter); ok {
+			c.ascii = *ascii
+		}
+	}
+	if *templates != "" {
+		r, err := newTemplateReporter(strings.Split(*templates, ","), env, plots.Out)
+		if err != nil {
+			reporter.Close()
+			return err
+		}
+		reporter = append(reporter, r)
+	}
+	for _, run := range sweep.Runs {
+		if err := reporter.OnRunComplete(run.result()); err != nil {
+			reporter.Close()
+			return err
+		}
+	}
+	return reporter.Close()
+}
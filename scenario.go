@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// scenario is a self-contained experiment with its own flags, for questions that don't fit the request sweep.
+type scenario struct {
+	Description string
+	Run         func(ctx context.Context, args []string) error
+}
+
+var scenarios = map[string]scenario{
+	"echo-server": {"capacity of a goroutine-per-connection TCP echo server", echoServerScenario},
+	"image-pool":  {"worker pool sizing for CPU-bound compression, across GOMAXPROCS settings", imagePoolScenario},
+	"gc-pressure": {"I/O-bound requests next to an allocation-heavy background job", gcPressureScenario},
+	"aggregation": {"result aggregation mechanisms compared on their own and as the harness's -aggregation", aggregationScenario},
+	"allocations": {"allocating and pooled request formatting compared on allocations per operation and scaling", allocationsScenario},
+	"primitives":  {"bounded-concurrency primitives compared on dispatch overhead and on identical workloads", primitivesScenario},
+}
+
+// resultRecorder keeps every result of a sweep for analysis once it is done.
+type resultRecorder struct {
+	results []BenchmarkResult
+}
+
+func (r *resultRecorder) OnRunStart(cfg RunConfig) {}
+
+func (r *resultRecorder) OnSample(sample Sample) {}
+
+func (r *resultRecorder) OnRunComplete(result BenchmarkResult) error {
+	r.results = append(r.results, result)
+	return nil
+}
+
+func scenarioCommand(args []string) error {
+	fs := flag.NewFlagSet("scenario", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s scenario <name> [flags]\n\nScenarios:\n", os.Args[0])
+		var names []string
+		for name := range scenarios {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(fs.Output(), "  %-16s %s\n", name, scenarios[name].Description)
+		}
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	s, ok := scenarios[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("unknown scenario %q", fs.Arg(0))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.Run(ctx, fs.Args()[1:])
+}
+
+// echoServerScenario opens connections to the TCP backend's goroutine-per-connection echo server at the rate set by
+// a load generator until the target count is reached or a dial fails, holds them open while every client echoes a
+// message at a fixed interval, and reports what each connection cost. Client and server share the process, so the
+// memory and goroutines per connection cover both ends.
+func echoServerScenario(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("echo-server", flag.ExitOnError)
+	conns := fs.Int("conns", 10000, "number of connections to open")
+	load := fs.String("load", "constant", "arrival process of new connections: constant, poisson, ramp")
+	rate := fs.Float64("rate", 2000, "new connections per second for constant and poisson load")
+	rampFrom := fs.Float64("ramp-from", 100, "initial connections per second for ramp load")
+	rampTo := fs.Float64("ramp-to", 5000, "final connections per second for ramp load")
+	rampDuration := fs.Duration("ramp-duration", 5*time.Second, "time taken by ramp load to reach its final rate")
+	interval := fs.Duration("interval", time.Second, "time between echoes on each connection")
+	messageSize := fs.Int("message-size", 64, "size in bytes of each echoed message")
+	hold := fs.Duration("hold", 5*time.Second, "how long to keep all connections open before measuring")
+	seed := fs.Int64("seed", 1, "seed for poisson arrivals")
+	fs.Parse(args)
+
+	spec := LoadSpec{Kind: *load, Rate: *rate, RampFrom: *rampFrom, RampTo: *rampTo, RampDuration: *rampDuration}
+	gen, err := newLoadGenerator(spec, realClock{}, newRand(*seed, loadRandStream))
+	if err != nil {
+		return err
+	}
+	if _, ok := gen.(closedLoopGenerator); ok {
+		return errors.New("echo-server needs an open-loop load to pace new connections")
+	}
+	server := &TCPBackend{MessageSize: *messageSize}
+	if err := server.Start(); err != nil {
+		return err
+	}
+	defer server.Close()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	goroutinesBefore := runtime.NumGoroutine()
+
+	clientCtx, cancel := context.WithCancel(context.Background())
+	var clients sync.WaitGroup
+	defer func() {
+		cancel()
+		clients.Wait()
+	}()
+	var mu sync.Mutex
+	roundTrips, _ := newCollector("exact", 0, 0, nil)
+	var echoErrors int
+
+	fmt.Printf("Echo server capacity: goroutine per connection, %d-byte messages every %v, %v\n", *messageSize, *interval, spec)
+	established := 0
+	var stopReason error
+	start := time.Now()
+	for established < *conns {
+		if stopReason = gen.Next(ctx); stopReason != nil {
+			break
+		}
+		conn, err := net.Dial("tcp", server.addr)
+		if err != nil {
+			stopReason = err
+			break
+		}
+		established++
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			defer conn.Close()
+			buf := make([]byte, *messageSize)
+			binary.BigEndian.PutUint64(buf, 0) // no server-side delay
+			timer := time.NewTimer(*interval)
+			defer timer.Stop()
+			for {
+				begin := time.Now()
+				_, err := conn.Write(buf)
+				if err == nil {
+					_, err = io.ReadFull(conn, buf)
+				}
+				mu.Lock()
+				if err != nil {
+					if clientCtx.Err() == nil {
+						echoErrors++
+					}
+				} else {
+					roundTrips.Add(float64(time.Since(begin)) / float64(time.Millisecond))
+				}
+				mu.Unlock()
+				if err != nil {
+					return
+				}
+				select {
+				case <-clientCtx.Done():
+					return
+				case <-timer.C:
+					timer.Reset(*interval)
+				}
+			}
+		}()
+	}
+	fmt.Printf("Established %d of %d connections in %v\n", established, *conns, time.Since(start).Round(time.Millisecond))
+	if stopReason != nil {
+		fmt.Printf("\tStopped opening connections: %v\n", stopReason)
+	}
+
+	select {
+	case <-time.After(*hold):
+	case <-ctx.Done():
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	goroutines := runtime.NumGoroutine() - goroutinesBefore
+
+	if established > 0 {
+		n := float64(established)
+		inUse := float64(after.HeapInuse+after.StackInuse) - float64(before.HeapInuse+before.StackInuse)
+		fromOS := float64(after.Sys) - float64(before.Sys)
+		fmt.Printf("\tGoroutines: %d (%.2f per connection, client and server ends)\n", goroutines, float64(goroutines)/n)
+		fmt.Printf("\tMemory per connection: %.1f KiB in use (heap and stacks), %.1f KiB obtained from the OS\n",
+			inUse/n/1024, fromOS/n/1024)
+	}
+	mu.Lock()
+	fmt.Printf("\tEcho round trips: %d (p50 %.2fms, p99 %.2fms), %d errors\n", roundTrips.Count(),
+		roundTrips.Percentile(50), roundTrips.Percentile(99), echoErrors)
+	mu.Unlock()
+	return ctx.Err()
+}
+
+// imagePoolScenario sweeps the size of a worker pool whose requests do real CPU-bound work, and reports for each
+// GOMAXPROCS setting the smallest pool that gets within 5% of the best throughput. With nothing to wait on, that
+// should be GOMAXPROCS workers; a bigger pool only adds queueing inside the scheduler.
+func imagePoolScenario(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("image-pool", flag.ExitOnError)
+	size := fs.Int("size", 256<<10, "bytes compressed by each request")
+	level := fs.Int("level", 6, "zlib compression level, 1 to 9")
+	requests := fs.Int("requests", 200, "requests per run")
+	executor := fs.String("executor", "pool", "comma-separated execution strategies to compare: "+strings.Join(executorNames, ", "))
+	gomaxprocs := fs.String("gomaxprocs", "", "comma-separated GOMAXPROCS values to sweep (default: half of and all CPUs)")
+	reporters := fs.String("report", "console,plots", "comma-separated reporters to enable: console, json, prometheus, plots, tui")
+	jsonOut := fs.String("json-out", "results.jsonl", "file the json reporter writes one result per line to")
+	fs.Parse(args)
+
+	cpus := runtime.NumCPU()
+	sweep := Sweep{Executors: strings.Split(*executor, ",")}
+	if *gomaxprocs != "" {
+		if sweep.GOMAXPROCS, err = parseGOMAXPROCS(*gomaxprocs); err != nil {
+			return err
+		}
+	} else {
+		if cpus > 1 {
+			sweep.GOMAXPROCS = append(sweep.GOMAXPROCS, cpus/2)
+		}
+		sweep.GOMAXPROCS = append(sweep.GOMAXPROCS, cpus)
+	}
+	for n := int64(1); n <= int64(4*cpus); n *= 2 {
+		if n > int64(cpus) && (len(sweep.Coroutines) == 0 || sweep.Coroutines[len(sweep.Coroutines)-1] < int64(cpus)) {
+			sweep.Coroutines = append(sweep.Coroutines, int64(cpus))
+		}
+		sweep.Coroutines = append(sweep.Coroutines, n)
+	}
+
+	work := &CompressWorkload{Size: *size, Level: *level}
+	workTime, err := work.Calibrate()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Image pool: compressing %d bytes at level %d takes %v on an idle CPU; %d CPUs\n", *size, *level, workTime, cpus)
+
+	reporter, err := newReporter(strings.Split(*reporters, ","), *jsonOut, "", PlotOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := reporter.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	recorder := &resultRecorder{}
+	base := RunConfig{
+		WorkTime:           workTime,
+		CPUDist:            Distribution{Kind: "fixed"},
+		NetworkDist:        Distribution{Kind: "fixed"},
+		Compress:           work,
+		Collector:          "exact",
+		BaselineIterations: 20,
+		Iterations:         *requests,
+		Seed:               1,
+		ResultBatch:        1,
+		Scenario:           "image-pool",
+	}
+	if err := throughputBenchmark(ctx, base, sweep, append(reporter, recorder)); err != nil {
+		return err
+	}
+
+	labels, series := bySeries(recorder.results)
+	for _, label := range labels {
+		runs := series[label]
+		best := runs[0]
+		for _, r := range runs {
+			if r.ThroughputRps > best.ThroughputRps {
+				best = r
+			}
+		}
+		for _, r := range runs {
+			if r.ThroughputRps >= 0.95*best.ThroughputRps {
+				fmt.Printf("%s executor, GOMAXPROCS=%d: throughput plateaus at %d workers, %.1f rps (best %.1f rps with %d)\n",
+					r.Config.Executor, r.Config.GOMAXPROCS, r.NumCoroutines, r.ThroughputRps, best.ThroughputRps, best.NumCoroutines)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// gcPressureScenario runs the same I/O-bound requests alone and next to a backgroundAllocator, then with the
+// background job confined to fewer workers than GOMAXPROCS so that some Ps stay free for requests, and with its
+// allocation rate capped. The background job steals CPU directly and through GC work, including the mark assists
+// charged to request goroutines that allocate during a cycle, and it shows up first in p99.
+func gcPressureScenario(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("gc-pressure", flag.ExitOnError)
+	coroutines := fs.Int64("coroutines", 32, "number of concurrent requests")
+	requests := fs.Int("requests", 500, "requests per run")
+	workTime := fs.Duration("work-time", time.Millisecond, "CPU time per request")
+	networkTime := fs.Duration("network-time", 20*time.Millisecond, "network time per request")
+	kernel := fs.String("cpu-kernel", "spin", "how requests spend their CPU time: "+cpuKernelNames())
+	bgWorkers := fs.Int("bg-workers", runtime.GOMAXPROCS(0), "goroutines allocating in the background")
+	bgChunk := fs.Int("bg-chunk", 64<<10, "bytes per background allocation")
+	bgLive := fs.Int("bg-live", 64<<20, "bytes the background job keeps live")
+	bgRate := fs.Float64("bg-rate", 64, "allocation rate of the rate-limited run, in MiB/s")
+	reserve := fs.Int("reserve", 1, "Ps left free for requests in the reserved run")
+	seed := fs.Int64("seed", 1, "seed for the workload")
+	fs.Parse(args)
+
+	if *bgWorkers < 1 || *bgChunk < 1 || *reserve < 0 {
+		return errors.New("bg-workers and bg-chunk must be positive and reserve not negative")
+	}
+	if cpuKernelNamed(*kernel) == nil {
+		return fmt.Errorf("unknown CPU kernel %q; want one of %s", *kernel, cpuKernelNames())
+	}
+	reserved := runtime.GOMAXPROCS(0) - *reserve
+	if reserved < 1 {
+		reserved = 1
+	}
+	variants := []struct {
+		name       string
+		background *backgroundAllocator
+	}{
+		{"no background", nil},
+		{"background", &backgroundAllocator{Workers: *bgWorkers, ChunkSize: *bgChunk, LiveBytes: *bgLive}},
+		{"reserved", &backgroundAllocator{Workers: reserved, ChunkSize: *bgChunk, LiveBytes: *bgLive}},
+		{"rate-limited", &backgroundAllocator{Workers: *bgWorkers, ChunkSize: *bgChunk, LiveBytes: *bgLive, Rate: *bgRate * (1 << 20)}},
+	}
+
+	cfg := RunConfig{
+		WorkTime:           *workTime,
+		NetworkTime:        *networkTime,
+		Splits:             5,
+		CPUKernel:          *kernel,
+		CPUDist:            Distribution{Kind: "fixed"},
+		NetworkDist:        Distribution{Kind: "fixed"},
+		NumCoroutines:      *coroutines,
+		Collector:          "exact",
+		BaselineIterations: 10,
+		Iterations:         *requests,
+		Seed:               *seed,
+		ResultBatch:        1,
+	}
+	fmt.Printf("GC pressure: %v %s CPU/%v Network per request, %d requests with %d co-routines, GOMAXPROCS=%d\n",
+		cfg.WorkTime, *kernel, cfg.NetworkTime, cfg.Iterations, cfg.NumCoroutines, runtime.GOMAXPROCS(0))
+	fmt.Printf("%-14s %10s %10s %10s %10s %10s %10s %10s\n", "variant", "bg workers", "bg MiB/s", "GC cycles", "GC pause", "rps", "p50 ms", "p99 ms")
+	for _, v := range variants {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		if v.background != nil {
+			v.background.Start()
+		}
+		result, err := runBenchmark(ctx, cfg, multiReporter{})
+		var allocated int64
+		workers := 0
+		if v.background != nil {
+			allocated = v.background.Stop()
+			workers = v.background.Workers
+		}
+		elapsed := time.Since(start)
+		if err != nil {
+			return err
+		}
+		runtime.ReadMemStats(&after)
+		fmt.Printf("%-14s %10d %10.1f %10d %10v %10.1f %10.2f %10.2f\n", v.name, workers,
+			float64(allocated)/(1<<20)/elapsed.Seconds(), after.NumGC-before.NumGC,
+			time.Duration(after.PauseTotalNs-before.PauseTotalNs).Round(time.Microsecond),
+			result.ThroughputRps, result.ResponseTimesPercentile(50), result.ResponseTimesPercentile(99))
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
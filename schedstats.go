@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime/trace"
+	"sort"
+	"time"
+
+	exptrace "golang.org/x/exp/trace"
+)
+
+// SchedulerStats is what the Go scheduler did during a run, read from the run's runtime trace, so only runs with
+// -runtime-trace have them.
+type SchedulerStats struct {
+	MeanRunnable      time.Duration `json:"mean_runnable_ns"` // time goroutines spent runnable before they got to run
+	P99Runnable       time.Duration `json:"p99_runnable_ns"`
+	ProcUtilization   float64       `json:"proc_utilization,omitempty"` // fraction of the Ps running goroutines, on average
+	MeanRunnableQueue float64       `json:"mean_runnable_goroutines,omitempty"`
+	SyscallBlocked    time.Duration `json:"syscall_blocked_ns,omitempty"` // goroutine time in system calls, summed over goroutines
+}
+
+// readSchedulerStats replays the goroutine state transitions in the runtime trace at path.
+func readSchedulerStats(path string, gomaxprocs int) (*SchedulerStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := exptrace.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	type goroutine struct {
+		state exptrace.GoState
+		since exptrace.Time
+	}
+	goroutines := map[exptrace.GoID]goroutine{}
+	var runnable []time.Duration
+	var runnableTotal, running, syscall time.Duration
+	account := func(state exptrace.GoState, d time.Duration, done bool) {
+		switch state {
+		case exptrace.GoRunnable:
+			if done {
+				runnable = append(runnable, d)
+			}
+			runnableTotal += d
+		case exptrace.GoRunning:
+			running += d
+		case exptrace.GoSyscall:
+			syscall += d
+		}
+	}
+	var first, last exptrace.Time
+	seen := false
+	for {
+		ev, err := r.ReadEvent()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !seen {
+			first, seen = ev.Time(), true
+		}
+		last = ev.Time()
+		if ev.Kind() != exptrace.EventStateTransition {
+			continue
+		}
+		st := ev.StateTransition()
+		if st.Resource.Kind != exptrace.ResourceGoroutine {
+			continue
+		}
+		id := st.Resource.Goroutine()
+		from, to := st.Goroutine()
+		if g, ok := goroutines[id]; ok && g.state == from {
+			account(from, ev.Time().Sub(g.since), to == exptrace.GoRunning)
+		}
+		goroutines[id] = goroutine{state: to, since: ev.Time()}
+	}
+	// Goroutines still runnable when the trace stopped never ran, so they count toward the queue but not the latencies.
+	for _, g := range goroutines {
+		account(g.state, last.Sub(g.since), false)
+	}
+	s := &SchedulerStats{SyscallBlocked: syscall}
+	if len(runnable) > 0 {
+		sort.Slice(runnable, func(i, j int) bool { return runnable[i] < runnable[j] })
+		var sum time.Duration
+		for _, d := range runnable {
+			sum += d
+		}
+		s.MeanRunnable = sum / time.Duration(len(runnable))
+		s.P99Runnable = runnable[int(math.Ceil(0.99*float64(len(runnable))))-1]
+	}
+	if elapsed := last.Sub(first); elapsed > 0 {
+		if gomaxprocs > 0 {
+			s.ProcUtilization = math.Min(1, float64(running)/float64(elapsed)/float64(gomaxprocs))
+		}
+		s.MeanRunnableQueue = float64(runnableTotal) / float64(elapsed)
+	}
+	return s, nil
+}
+
+func outputScheduler(s *SchedulerStats) {
+	if s == nil {
+		return
+	}
+	line := fmt.Sprintf("\tScheduler: runnable for %s on average before running, p99 %s", formatDuration(s.MeanRunnable), formatDuration(s.P99Runnable))
+	if s.ProcUtilization > 0 || s.MeanRunnableQueue > 0 || s.SyscallBlocked > 0 {
+		line += fmt.Sprintf("; Ps %s busy, %s goroutines runnable on average, %s blocked outside Go",
+			formatPercent(s.ProcUtilization), formatFloat(s.MeanRunnableQueue, 1), formatDuration(s.SyscallBlocked))
+	}
+	fmt.Println(line)
+}
+
+// startRuntimeTrace writes the runtime trace of a run to path until the returned function is called.
+func startRuntimeTrace(path string) (stop func() error, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := trace.Start(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("-runtime-trace: %w", err)
+	}
+	return func() error {
+		trace.Stop()
+		return f.Close()
+	}, nil
+}
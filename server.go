@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"perf/controlpb"
+)
+
+// The server command runs sweeps and scenarios submitted over HTTP, for dashboards and automation that would rather
+// not shell out. Each submission runs as a child process with the arguments it gives, in a directory of its own under
+// the server's, and only one runs at a time: two at once would each measure the other. Its API, in JSON:
+//
+//	POST   /runs                      submit a serverRequest; returns its serverRun, queued
+//	GET    /runs                      every run, oldest first
+//	GET    /runs/{id}                 a run's status
+//	DELETE /runs/{id}                 cancel a queued run, or interrupt a running one, which reports what it measured
+//	GET    /runs/{id}/results         the json reporter's results.jsonl
+//	GET    /runs/{id}/log             what the run printed
+//	GET    /runs/{id}/artifacts       the files the run wrote
+//	GET    /runs/{id}/artifacts/{path} one of them
+//
+// With -grpc-listen, the same runs can be started and followed over gRPC too; see controlServer.
+//
+// Runs are kept in memory only; their directories outlive the server.
+
+// serverRequest is a submitted run: the arguments of a sweep, or of a scenario when Scenario names one.
+type serverRequest struct {
+	Scenario string   `json:"scenario,omitempty"`
+	Args     []string `json:"args"`
+}
+
+// serverRun is a submitted run and how it is going.
+type serverRun struct {
+	ID        string        `json:"id"`
+	Request   serverRequest `json:"request"`
+	Status    string        `json:"status"` // queued, running, succeeded, failed or cancelled
+	Error     string        `json:"error,omitempty"`
+	Submitted time.Time     `json:"submitted"`
+	Started   *time.Time    `json:"started,omitempty"`
+	Finished  *time.Time    `json:"finished,omitempty"`
+
+	dir       string
+	cmd       *exec.Cmd
+	cancelled bool
+}
+
+type apiServer struct {
+	dir   string
+	exe   string // this binary, which runs start from their own directories
+	queue chan *serverRun
+
+	mu   sync.Mutex
+	runs []*serverRun
+	next int
+}
+
+func serverCommand(args []string) error {
+	fs := flag.NewFlagSet("server", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "address to serve the API on")
+	dir := fs.String("dir", "perf-runs", "directory each submitted run gets a directory of its own under")
+	grpcListen := fs.String("grpc-listen", "", "address to also serve the gRPC control API on (default: none)")
+	queueSize := fs.Int("queue", 100, "most runs waiting to start; submissions past it are turned away")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s server [-listen address] [-dir directory]\n\n"+
+			"Runs sweeps and scenarios submitted over HTTP one at a time, serving their status, results and artifacts.\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	s := &apiServer{dir: *dir, exe: exe, queue: make(chan *serverRun, *queueSize)}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: s}
+	go s.work(ctx)
+	if *grpcListen != "" {
+		grpcLn, err := net.Listen("tcp", *grpcListen)
+		if err != nil {
+			return err
+		}
+		control := grpc.NewServer()
+		controlpb.RegisterControlServer(control, controlServer{s: s})
+		go control.Serve(grpcLn)
+		defer control.Stop()
+		fmt.Printf("Serving the gRPC control API on %s\n", grpcLn.Addr())
+	}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	fmt.Printf("Serving the run API on http://%s/runs, running in %s\n", ln.Addr(), *dir)
+	if err := server.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// work runs queued runs one after another until ctx is cancelled, interrupting the one running then.
+func (s *apiServer) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case run := <-s.queue:
+			s.execute(ctx, run)
+		}
+	}
+}
+
+func (s *apiServer) execute(ctx context.Context, run *serverRun) {
+	s.mu.Lock()
+	if run.cancelled {
+		s.mu.Unlock()
+		return
+	}
+	args := run.Request.Args
+	if run.Request.Scenario != "" {
+		args = append([]string{"scenario", run.Request.Scenario}, args...)
+	} else if !hasFlag(args, "report") {
+		// Results are what a run is submitted for; the console's output still goes to the log.
+		args = append([]string{"-report", "console,json,plots"}, args...)
+	}
+	log, err := os.Create(filepath.Join(run.dir, "output.log"))
+	if err != nil {
+		s.finish(run, err)
+		s.mu.Unlock()
+		return
+	}
+	defer log.Close()
+	run.cmd = exec.Command(s.exe, args...)
+	run.cmd.Dir = run.dir
+	run.cmd.Stdout, run.cmd.Stderr = log, log
+	if err = run.cmd.Start(); err == nil {
+		now := time.Now()
+		run.Started = &now
+		run.Status = "running"
+	}
+	s.mu.Unlock()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				run.cmd.Process.Signal(os.Interrupt)
+			case <-done:
+			}
+		}()
+		err = run.cmd.Wait()
+		close(done)
+	}
+	s.mu.Lock()
+	s.finish(run, err)
+	s.mu.Unlock()
+}
+
+// finish records how run ended; s.mu must be held.
+func (s *apiServer) finish(run *serverRun, err error) {
+	now := time.Now()
+	run.Finished = &now
+	switch {
+	case run.cancelled:
+		run.Status = "cancelled"
+	case err != nil:
+		run.Status, run.Error = "failed", err.Error()
+	default:
+		run.Status = "succeeded"
+	}
+}
+
+// hasFlag reports whether args set the flag called name, in any of the forms the flag package takes.
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		arg = strings.TrimLeft(arg, "-")
+		if arg == name || strings.HasPrefix(arg, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 4)
+	if parts[0] != "runs" {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			writeAPIJSON(w, http.StatusOK, s.runs)
+		case http.MethodPost:
+			s.submit(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	run := s.find(parts[1])
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			writeAPIJSON(w, http.StatusOK, run)
+		case http.MethodDelete:
+			s.cancel(run)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			writeAPIJSON(w, http.StatusOK, run)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch parts[2] {
+	case "results":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		http.ServeFile(w, r, filepath.Join(run.dir, "results.jsonl"))
+	case "log":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeFile(w, r, filepath.Join(run.dir, "output.log"))
+	case "artifacts":
+		if len(parts) == 3 {
+			files, err := listArtifacts(run.dir)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, files)
+			return
+		}
+		// http.Dir keeps the path inside the run's directory.
+		http.StripPrefix("/runs/"+run.ID+"/artifacts", http.FileServer(http.Dir(run.dir))).ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *apiServer) submit(w http.ResponseWriter, r *http.Request) {
+	var req serverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid run: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	run, err := s.enqueue(req)
+	if errors.Is(err, errQueueFull) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", "/runs/"+run.ID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeAPIJSON(w, http.StatusAccepted, run)
+}
+
+var errQueueFull = errors.New("too many runs queued")
+
+func (req serverRequest) validate() error {
+	if _, ok := scenarios[req.Scenario]; req.Scenario != "" && !ok {
+		return fmt.Errorf("unknown scenario %q", req.Scenario)
+	}
+	return nil
+}
+
+// enqueue queues a validated request to run once those before it have.
+func (s *apiServer) enqueue(req serverRequest) (*serverRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	run := &serverRun{ID: strconv.Itoa(s.next), Request: req, Status: "queued", Submitted: time.Now()}
+	run.dir = filepath.Join(s.dir, run.Submitted.Format("20060102-150405")+"-"+run.ID)
+	if err := os.MkdirAll(run.dir, 0o755); err != nil {
+		return nil, err
+	}
+	select {
+	case s.queue <- run:
+	default:
+		return nil, errQueueFull
+	}
+	s.runs = append(s.runs, run)
+	return run, nil
+}
+
+func (s *apiServer) find(id string) *serverRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range s.runs {
+		if run.ID == id {
+			return run
+		}
+	}
+	return nil
+}
+
+// cancel keeps a queued run from starting, or interrupts a running one, which drains and reports as an interrupted
+// sweep does.
+func (s *apiServer) cancel(run *serverRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch run.Status {
+	case "queued":
+		run.cancelled = true
+		s.finish(run, nil)
+	case "running":
+		run.cancelled = true
+		run.cmd.Process.Signal(os.Interrupt)
+	}
+}
+
+// listArtifacts lists the files under dir, relative to it.
+func listArtifacts(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v) // the client has gone if this fails
+}
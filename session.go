@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionScript is the ordered sequence of requests each virtual user makes before starting over, such as logging in,
+// browsing three pages and checking out. Steps of the same name are one request class, reported together, and the
+// run's traffic mix is the script's classes weighted by how often a session makes them.
+type SessionScript []SessionStep
+
+type SessionStep struct {
+	Name        string
+	Repeat      int
+	WorkTime    time.Duration
+	NetworkTime time.Duration
+}
+
+// parseSessionScript parses a comma-separated list of name[*repeat]:cpu/network steps, such as
+// "login:5ms/20ms,browse*3:2ms/10ms,checkout:10ms/50ms".
+func parseSessionScript(s string) (SessionScript, error) {
+	var script SessionScript
+	times := map[string]SessionStep{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		colon := strings.Index(entry, ":")
+		slash := strings.Index(entry, "/")
+		if colon <= 0 || slash < colon {
+			return nil, fmt.Errorf("session step %q is not of the form name[*repeat]:cpu/network", entry)
+		}
+		step := SessionStep{Name: entry[:colon], Repeat: 1}
+		if star := strings.Index(step.Name, "*"); star >= 0 {
+			n, err := strconv.Atoi(step.Name[star+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("session step %q: the repeat count must be a positive integer", entry)
+			}
+			step.Name, step.Repeat = step.Name[:star], n
+		}
+		var err error
+		if step.WorkTime, err = time.ParseDuration(entry[colon+1 : slash]); err != nil {
+			return nil, fmt.Errorf("session step %q: %w", entry, err)
+		}
+		if step.NetworkTime, err = time.ParseDuration(entry[slash+1:]); err != nil {
+			return nil, fmt.Errorf("session step %q: %w", entry, err)
+		}
+		if prev, ok := times[step.Name]; ok && (prev.WorkTime != step.WorkTime || prev.NetworkTime != step.NetworkTime) {
+			return nil, fmt.Errorf("session step %q: steps named %s must take the same times", entry, step.Name)
+		}
+		times[step.Name] = step
+		script = append(script, step)
+	}
+	return script, nil
+}
+
+// mix returns the script's request classes in the order they first appear, weighted by how often a session makes them.
+func (s SessionScript) mix() TrafficMix {
+	var mix TrafficMix
+	index := map[string]int{}
+	for _, step := range s {
+		i, ok := index[step.Name]
+		if !ok {
+			i = len(mix)
+			index[step.Name] = i
+			mix = append(mix, RequestClass{Name: step.Name, WorkTime: step.WorkTime, NetworkTime: step.NetworkTime})
+		}
+		mix[i].Weight += float64(step.Repeat)
+	}
+	return mix
+}
+
+func (s SessionScript) String() string {
+	parts := make([]string, len(s))
+	for i, step := range s {
+		parts[i] = step.Name
+		if step.Repeat > 1 {
+			parts[i] += fmt.Sprintf(" ×%d", step.Repeat)
+		}
+	}
+	return strings.Join(parts, " → ")
+}
+
+// sessionUsers tracks where each virtual user of a run is in its session. A user is taken by a request for the time
+// it runs and the think time after it, so that a user's steps never overlap and always run in order.
+type sessionUsers struct {
+	steps []RequestClass // the script with its repeats spelled out
+	free  chan *sessionUser
+
+	mu        sync.Mutex
+	completed int
+	total     time.Duration
+}
+
+type sessionUser struct {
+	next    int // step
+	started time.Time
+}
+
+func newSessionUsers(script SessionScript, users int64) *sessionUsers {
+	s := &sessionUsers{free: make(chan *sessionUser, users)}
+	for _, step := range script {
+		for i := 0; i < step.Repeat; i++ {
+			s.steps = append(s.steps, RequestClass{Name: step.Name, WorkTime: step.WorkTime, NetworkTime: step.NetworkTime})
+		}
+	}
+	for i := int64(0); i < users; i++ {
+		s.free <- &sessionUser{}
+	}
+	return s
+}
+
+// take returns a user free to make its next request; nil when the run has no sessions.
+func (s *sessionUsers) take() *sessionUser {
+	if s == nil {
+		return nil
+	}
+	return <-s.free
+}
+
+func (s *sessionUsers) put(u *sessionUser) {
+	if s != nil {
+		s.free <- u
+	}
+}
+
+// begin returns the class of u's next request, starting at now.
+func (s *sessionUsers) begin(u *sessionUser, now time.Time) RequestClass {
+	if u.next == 0 {
+		u.started = now
+	}
+	return s.steps[u.next]
+}
+
+// end moves u on once its request has ended at now, counting its session if that was the last step.
+func (s *sessionUsers) end(u *sessionUser, now time.Time) {
+	u.next++
+	if u.next < len(s.steps) {
+		return
+	}
+	u.next = 0
+	s.mu.Lock()
+	s.completed++
+	s.total += now.Sub(u.started)
+	s.mu.Unlock()
+}
+
+// SessionStats is how many sessions the run's virtual users completed, and how long they took from the start of the
+// first step to the end of the last, think times included.
+type SessionStats struct {
+	Completed int           `json:"completed"`
+	Mean      time.Duration `json:"mean_ns"`
+}
+
+func (s *sessionUsers) stats() *SessionStats {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := &SessionStats{Completed: s.completed}
+	if s.completed > 0 {
+		stats.Mean = s.total / time.Duration(s.completed)
+	}
+	return stats
+}
+
+func outputSessions(result BenchmarkResult) {
+	if result.Config.Session != nil {
+		fmt.Printf("\tSession: %v\n", result.Config.Session)
+	}
+	if s := result.Sessions; s != nil {
+		fmt.Printf("\tSessions completed: %s, taking %s on average\n", formatCount(int64(s.Completed)), formatDuration(s.Mean))
+	}
+}
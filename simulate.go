@@ -0,0 +1,399 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"runtime"
+	"time"
+)
+
+// A simulated run replaces the workload with a discrete-event model of it: requests hold a co-routine from start to
+// finish, their CPU phases queue for GOMAXPROCS processors and are preempted every goSchedQuantum as the Go scheduler
+// would, and their network phases are pure delays. Time is virtual, jumping from one event to the next, so that a run
+// of millions of requests completes in seconds and GOMAXPROCS can exceed the machine's CPUs. Requests draw their times
+// from the same random streams as a real run's, so the two can be set side by side, with compare among others;
+// where they differ, the scheduler, the runtime or the hardware is at work.
+//
+// The model leaves out everything but CPU and network time: middleware, faults, retries, the breaker, load shedding,
+// request timeouts, other backends and other workloads. simulationUnsupported names the first a configuration uses.
+
+// goSchedQuantum is how long the Go scheduler lets a goroutine run before preempting it for one that is waiting.
+const goSchedQuantum = 10 * time.Millisecond
+
+// simEpoch is when simulated runs start; being fixed, it keeps their results the same from one simulation to the next.
+var simEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SimulationStats is what simulating a run took.
+type SimulationStats struct {
+	Events   int64
+	WallTime time.Duration
+}
+
+// simulationUnsupported names the first part of cfg the simulation does not model, or returns the empty string.
+func (cfg RunConfig) simulationUnsupported() string {
+	switch {
+	case len(cfg.Middleware) > 0:
+		return "middleware"
+	case cfg.Faults.enabled(), cfg.Retry.enabled(), cfg.Breaker.enabled(), cfg.Hedge.enabled():
+		return "faults, retries, a breaker or hedging"
+	case cfg.ShedLoad:
+		return "load shedding"
+	case cfg.Dispatch != "":
+		return "dispatch policies"
+	case cfg.Load.Kind == "users", cfg.Session != nil:
+		return "virtual users"
+	case cfg.RequestTimeout > 0:
+		return "request timeouts"
+	case cfg.FanOut > 1:
+		return "fan-out"
+	case cfg.Network != nil, cfg.Disk != nil, cfg.Lock != nil, cfg.DNS != nil, cfg.SQL != nil, cfg.Redis != nil, cfg.Pipeline != nil, cfg.MQ != nil, cfg.Compress != nil, cfg.Exec != nil, cfg.Plugin != nil:
+		return "workloads other than CPU and sleep-based network time"
+	case cfg.Target != nil, cfg.Processes > 1:
+		return "remote targets or several processes"
+	case cfg.Clock != nil:
+		return "time compression"
+	case cfg.RequestTrace, cfg.TraceSample > 0, cfg.TraceSlow > 0, cfg.BudgetAt > 0:
+		return "request traces or latency budgets"
+	}
+	return ""
+}
+
+// simClock is the load generator's clock in a simulation: sleeping on it moves it forward at once.
+type simClock struct {
+	now time.Time
+}
+
+func (c *simClock) Now() time.Time {
+	return c.now
+}
+
+func (c *simClock) After(d time.Duration) <-chan time.Time {
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func (c *simClock) Sleep(d time.Duration) {
+	c.After(d)
+}
+
+type simSegment struct {
+	cpu bool
+	d   time.Duration
+}
+
+type simRequest struct {
+	x         int
+	class     string
+	segments  []simSegment // as doWork would spend the request's time
+	next      int          // the segment under way
+	remaining time.Duration
+	issued    time.Time
+	started   time.Time
+	log       *RequestLog
+}
+
+// newSimRequest draws request x's times from the random stream a real run's would use, in the same order.
+func newSimRequest(cfg RunConfig, x int) *simRequest {
+	ctx, class := cfg.requestContext(context.Background(), x)
+	if c, ok := requestClassFrom(ctx); ok {
+		cfg.WorkTime, cfg.NetworkTime = c.WorkTime, c.NetworkTime
+	}
+	r := randFrom(ctx)
+	workTime := cfg.CPUDist.Sample(r, cfg.WorkTime)
+	networkTime := cfg.NetworkDist.Sample(r, cfg.NetworkTime)
+	splits := cfg.SplitsDist.Sample(r, cfg.Splits)
+	cpu := simSegment{cpu: true, d: workTime / time.Duration(splits+1)}
+	req := &simRequest{x: x, class: class, segments: []simSegment{cpu}, log: newRequestLog(x, cfg.LogLevel)}
+	for i := 0; i < splits; i++ {
+		req.segments = append(req.segments, simSegment{d: cfg.SplitsDist.phaseTime(networkTime, cfg.Splits)}, cpu)
+	}
+	return req
+}
+
+// simEvent is a request's CPU slice or network phase ending, or with no request, the next one arriving.
+type simEvent struct {
+	at  time.Time
+	seq int64 // breaks ties in the order events were scheduled, keeping simulations deterministic
+	req *simRequest
+}
+
+type simEvents []simEvent
+
+func (h simEvents) Len() int { return len(h) }
+func (h simEvents) Less(i, j int) bool {
+	return h[i].at.Before(h[j].at) || (h[i].at.Equal(h[j].at) && h[i].seq < h[j].seq)
+}
+func (h simEvents) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *simEvents) Push(x interface{}) { *h = append(*h, x.(simEvent)) }
+func (h *simEvents) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+type simulation struct {
+	cfg       RunConfig
+	rc        *runCollection
+	reporter  Reporter
+	precision *precisionTracker
+	gen       LoadGenerator
+	clock     *simClock // the dispatcher's, at the time it issues the next request
+	now       time.Time // of the event being handled
+	events    simEvents
+	seq       int64
+	handled   int64
+	idleProcs int
+	runQueue  []*simRequest
+	freeSlots int64
+	blocked   *simRequest // issued while every co-routine was busy; the dispatcher waits for one with it
+	issued    int
+	limit     int
+	stopped   bool
+	cpuTime   time.Duration
+	last      time.Time // when the last request completed
+}
+
+func (s *simulation) schedule(at time.Time, req *simRequest) {
+	s.seq++
+	heap.Push(&s.events, simEvent{at: at, seq: s.seq, req: req})
+}
+
+// dispatch has the load generator issue the next request, unless the run has issued all it will.
+func (s *simulation) dispatch(ctx context.Context) error {
+	if s.stopped || s.issued == s.limit || s.precision.Reached() {
+		return nil
+	}
+	if err := s.gen.Next(ctx); err != nil {
+		s.stopped = true
+		return err
+	}
+	s.schedule(s.clock.Now(), nil)
+	return nil
+}
+
+func (s *simulation) start(req *simRequest) {
+	s.freeSlots--
+	req.started = s.now
+	s.step(req)
+}
+
+// step starts req's next segment, or completes it after its last.
+func (s *simulation) step(req *simRequest) {
+	if req.next == len(req.segments) {
+		s.complete(req)
+		return
+	}
+	seg := req.segments[req.next]
+	if !seg.cpu {
+		req.log.Begin(s.now, "network", "network time", seg.d)
+		s.schedule(s.now.Add(seg.d), req)
+		return
+	}
+	req.log.Begin(s.now, "cpu", "CPU time", seg.d)
+	req.remaining = seg.d
+	s.cpuTime += seg.d
+	s.runQueue = append(s.runQueue, req)
+	s.runProcessors()
+}
+
+// runProcessors gives idle processors to the requests waiting for one, in the order they became runnable.
+func (s *simulation) runProcessors() {
+	for s.idleProcs > 0 && len(s.runQueue) > 0 {
+		req := s.runQueue[0]
+		s.runQueue[0] = nil
+		s.runQueue = s.runQueue[1:]
+		slice := req.remaining
+		if slice > goSchedQuantum {
+			slice = goSchedQuantum
+		}
+		req.remaining -= slice
+		s.idleProcs--
+		s.schedule(s.now.Add(slice), req)
+	}
+}
+
+// handle ends req's CPU slice or network phase.
+func (s *simulation) handle(req *simRequest) {
+	if req.segments[req.next].cpu {
+		s.idleProcs++
+		if req.remaining > 0 {
+			s.runQueue = append(s.runQueue, req) // preempted, to the back of the queue
+			s.runProcessors()
+			return
+		}
+	}
+	req.log.End(s.now)
+	req.next++
+	s.step(req)
+	s.runProcessors()
+}
+
+func (s *simulation) complete(req *simRequest) {
+	s.freeSlots++
+	s.last = s.now
+	timeTaken := s.now.Sub(req.started)
+	s.precision.observe(float64(timeTaken) / float64(time.Millisecond))
+	s.rc.add(WorkResult{
+		request:   req.x,
+		class:     req.class,
+		timeTaken: timeTaken,
+		log:       req.log,
+		queueWait: req.started.Sub(req.issued),
+		finished:  s.now.Sub(simEpoch),
+	}, s.reporter)
+}
+
+// simulateBenchmark runs cfg through the simulation and reports the result as runBenchmark would.
+func simulateBenchmark(ctx context.Context, cfg RunConfig, reporter Reporter) (BenchmarkResult, error) {
+	if what := cfg.simulationUnsupported(); what != "" {
+		return BenchmarkResult{}, fmt.Errorf("the simulation does not model %s", what)
+	}
+	if cfg.GOMAXPROCS <= 0 {
+		cfg.GOMAXPROCS = runtime.GOMAXPROCS(0)
+	}
+	reporter.OnRunStart(cfg)
+	started := time.Now()
+
+	// The baseline runs its requests one after another, with nothing to contend with. It costs next to nothing here,
+	// so it is modelled every run rather than shared.
+	var baselineDuration time.Duration
+	baselineRequests := 0
+	for ; baselineRequests < cfg.BaselineIterations || (cfg.BaselineDuration > 0 && baselineDuration < cfg.BaselineDuration); baselineRequests++ {
+		for _, seg := range newSimRequest(cfg, baselineRequests).segments {
+			baselineDuration += seg.d
+		}
+	}
+
+	s := &simulation{cfg: cfg, reporter: reporter, precision: newPrecisionTracker(cfg), clock: &simClock{now: simEpoch},
+		now: simEpoch, idleProcs: cfg.GOMAXPROCS, freeSlots: cfg.NumCoroutines, limit: cfg.Iterations}
+	if cfg.Executor == "unbounded" {
+		s.freeSlots = math.MaxInt64
+	}
+	if s.precision != nil {
+		s.limit = cfg.MaxIterations
+	}
+	var err error
+	if s.rc, err = newRunCollection(cfg, cfg.expectedRequests()); err != nil {
+		return BenchmarkResult{}, err
+	}
+	if s.gen, err = newLoadGenerator(cfg.Load, s.clock, newRand(cfg.Seed, loadRandStream)); err != nil {
+		return BenchmarkResult{}, err
+	}
+	var limited *limitedGenerator
+	if cfg.RateLimit.enabled() {
+		limited = newLimitedGenerator(s.gen, cfg.RateLimit, s.clock)
+		s.gen = limited
+	}
+
+	// Cancelling ctx stops issuing requests; those under way are still simulated to completion.
+	runErr := s.dispatch(ctx)
+	for s.events.Len() > 0 {
+		e := heap.Pop(&s.events).(simEvent)
+		s.now = e.at
+		s.handled++
+		if s.handled%4096 == 0 && !s.stopped {
+			if runErr = ctx.Err(); runErr != nil {
+				s.stopped = true
+			}
+		}
+		if e.req != nil {
+			s.handle(e.req)
+			if s.blocked == nil || s.freeSlots == 0 {
+				continue
+			}
+			// The dispatcher gets its co-routine and goes on issuing requests from now.
+			req := s.blocked
+			s.blocked = nil
+			s.start(req)
+			s.clock.now = s.now
+		} else {
+			req := newSimRequest(cfg, s.issued)
+			req.issued = s.now
+			s.issued++
+			if s.freeSlots == 0 {
+				s.blocked = req
+				continue
+			}
+			s.start(req)
+		}
+		if err := s.dispatch(ctx); err != nil && runErr == nil {
+			runErr = err
+		}
+	}
+
+	rc := s.rc
+	completed := rc.responseTimes.Count()
+	totalDuration := s.last.Sub(simEpoch)
+	var baselineRps float64
+	if baselineRequests > 0 {
+		baselineRps = float64(baselineRequests) / baselineDuration.Seconds()
+	}
+	resultRps := float64(completed) / totalDuration.Seconds()
+	cpus := float64(cfg.GOMAXPROCS)
+	maxRps := cpus / cfg.WorkTime.Seconds()
+	result := BenchmarkResult{
+		WorkTime:         cfg.WorkTime,
+		NetworkTime:      cfg.NetworkTime,
+		Iterations:       completed,
+		NumCoroutines:    cfg.NumCoroutines,
+		Config:           cfg,
+		ThroughputRps:    resultRps,
+		Speedup:          speedup(resultRps, baselineRps),
+		CpuUtilization:   resultRps * 100.0 / maxRps,
+		EffectiveCPUs:    cpus,
+		ResponseTimes:    rc.responseTimes,
+		Latency:          latencyStats(rc.responseTimes),
+		Outliers:         detectOutliers(cfg.Outliers, rc.responseTimes),
+		ThroughputSeries: throughputSeries(rc.completions, cfg.ThroughputInterval, totalDuration),
+		LatencyTrace:     rc.trace.sorted(),
+		Phases:           rc.phases.results(),
+		QueueWaits:       rc.queueWaits,
+		CallerTimes:      rc.callerTimes,
+		QueueLength:      rc.queued.Seconds() / totalDuration.Seconds(),
+		LongestRequest:   rc.longestRequest.log.String(),
+		ErrorTimes:       rc.errorTimes,
+		Err:              runErr,
+		RateLimitStats:   limited.stats(),
+		Started:          started,
+		CPUTime:          s.cpuTime,
+		PrecisionRSE:     s.precision.RSE(),
+		QueueModel:       modelQueue(cfg, rc, completed, resultRps, totalDuration),
+		AvgConcurrency:   rc.busy.Seconds() / totalDuration.Seconds(),
+		Simulation:       &SimulationStats{Events: s.handled, WallTime: time.Since(started)},
+		Host:             currentHost(),
+	}
+	for _, class := range cfg.Mix {
+		result.Classes = append(result.Classes, ClassResult{Name: class.Name, Priority: class.Priority,
+			ResponseTimes: rc.classes[class.Name], CallerTimes: rc.classCallers[class.Name]})
+	}
+	for _, slow := range rc.slowest {
+		if slow.log != nil {
+			result.Slowest = append(result.Slowest, slow.log.timeline(slow.timeTaken))
+		}
+	}
+	if err := applySteadyState(&result, rc); err != nil {
+		return BenchmarkResult{}, err
+	}
+	result.Failure = classifyFailure(result)
+	return result, reporter.OnRunComplete(result)
+}
+
+func outputSimulation(result BenchmarkResult) {
+	s := result.Simulation
+	if s == nil {
+		return
+	}
+	fmt.Printf("\tSimulated: %s events in %s of wall time", formatCount(s.Events), formatDuration(s.WallTime))
+	if s.WallTime > 0 && result.ThroughputRps > 0 {
+		simulated := time.Duration(float64(result.Iterations) / result.ThroughputRps * float64(time.Second))
+		fmt.Printf(", %s× faster than real time", formatFloat(float64(simulated)/float64(s.WallTime), 0))
+	}
+	fmt.Println()
+}
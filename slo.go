@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SLO is a service level objective a sweep is checked against once it is done, such as p99<120ms@15: a metric, a
+// comparison, a bound and, optionally, the co-routine count it applies at. Without a count it applies to every run.
+type SLO struct {
+	Spec       string
+	Metric     string  // throughput, error-rate, timeout-rate, or a percentile such as p99 or p99.9
+	Percentile float64 // of a percentile metric
+	Op         string  // <, <=, > or >=
+	Bound      float64 // in ms for percentiles, rps for throughput and a fraction for rates
+	Coroutines int64   // zero for every run
+}
+
+// SLOs is a flag.Value, so that -slo can be repeated.
+type SLOs []SLO
+
+func (s *SLOs) Set(spec string) error {
+	slo, err := parseSLO(spec)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, slo)
+	return nil
+}
+
+func (s *SLOs) String() string {
+	if s == nil {
+		return ""
+	}
+	specs := make([]string, len(*s))
+	for i, slo := range *s {
+		specs[i] = slo.Spec
+	}
+	return strings.Join(specs, ",")
+}
+
+// parseSLO parses metric op bound[@coroutines]. Percentile bounds are durations, such as 120ms; throughput is in
+// requests per second, with an optional rps suffix; error and timeout rates are fractions, or percentages with %.
+func parseSLO(spec string) (SLO, error) {
+	slo := SLO{Spec: strings.TrimSpace(spec)}
+	s := strings.ReplaceAll(slo.Spec, " ", "")
+	if at := strings.LastIndex(s, "@"); at >= 0 {
+		n, err := strconv.ParseInt(s[at+1:], 10, 64)
+		if err != nil || n <= 0 {
+			return slo, fmt.Errorf("SLO %q: co-routine count after @ must be a positive integer", spec)
+		}
+		slo.Coroutines, s = n, s[:at]
+	}
+	i := strings.IndexAny(s, "<>")
+	if i <= 0 {
+		return slo, fmt.Errorf("SLO %q is not of the form metric<bound, e.g. p99<120ms@15 or throughput>=150", spec)
+	}
+	slo.Metric, slo.Op, s = s[:i], s[i:i+1], s[i+1:]
+	if strings.HasPrefix(s, "=") {
+		slo.Op, s = slo.Op+"=", s[1:]
+	}
+	var err error
+	switch {
+	case slo.Metric == "throughput":
+		slo.Bound, err = strconv.ParseFloat(strings.TrimSuffix(s, "rps"), 64)
+	case slo.Metric == "error-rate" || slo.Metric == "timeout-rate":
+		if strings.HasSuffix(s, "%") {
+			slo.Bound, err = strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+			slo.Bound /= 100
+		} else {
+			slo.Bound, err = strconv.ParseFloat(s, 64)
+		}
+	case strings.HasPrefix(slo.Metric, "p"):
+		slo.Percentile, err = strconv.ParseFloat(slo.Metric[1:], 64)
+		if err != nil || slo.Percentile <= 0 || slo.Percentile > 100 {
+			return slo, fmt.Errorf("SLO %q: %q is not a percentile between p0 and p100", spec, slo.Metric)
+		}
+		var d time.Duration
+		d, err = time.ParseDuration(s)
+		slo.Bound = float64(d) / float64(time.Millisecond)
+	default:
+		return slo, fmt.Errorf("SLO %q: unknown metric %q, expected throughput, error-rate, timeout-rate or a percentile such as p99", spec, slo.Metric)
+	}
+	if err != nil {
+		return slo, fmt.Errorf("SLO %q: invalid bound %q", spec, s)
+	}
+	return slo, nil
+}
+
+// loadSLOs reads SLOs from a file, one per line; blank lines and lines starting with # are skipped.
+func loadSLOs(path string, slos *SLOs) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := slos.Set(text); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// measure returns the run's value of the SLO's metric and how to print it.
+func (slo SLO) measure(result BenchmarkResult) (float64, string) {
+	switch slo.Metric {
+	case "throughput":
+		return result.ThroughputRps, formatRps(result.ThroughputRps)
+	case "error-rate":
+		if result.Iterations == 0 {
+			return 0, formatPercent(0)
+		}
+		rate := float64(result.Errors) / float64(result.Iterations)
+		return rate, formatPercent(rate)
+	case "timeout-rate":
+		return result.TimeoutRate(), formatPercent(result.TimeoutRate())
+	}
+	v := result.ResponseTimes.Percentile(slo.Percentile)
+	return v, formatMs(v)
+}
+
+func (slo SLO) holds(v float64) bool {
+	switch slo.Op {
+	case "<":
+		return v < slo.Bound
+	case "<=":
+		return v <= slo.Bound
+	case ">":
+		return v > slo.Bound
+	}
+	return v >= slo.Bound
+}
+
+// checkSLOs prints a table of every SLO against every run it applies to and returns how many of those checks failed.
+// A failed run, or an SLO at a co-routine count no run had, fails outright.
+func checkSLOs(slos SLOs, results []BenchmarkResult) int {
+	if len(slos) == 0 {
+		return 0
+	}
+	failed := 0
+	fmt.Println("SLOs:")
+	for _, slo := range slos {
+		checked := false
+		for _, result := range results {
+			if slo.Coroutines != 0 && result.NumCoroutines != slo.Coroutines {
+				continue
+			}
+			checked = true
+			run := fmt.Sprintf("%s executor, GOMAXPROCS=%d, %d co-routines", result.Config.Executor, result.Config.GOMAXPROCS, result.NumCoroutines)
+			if result.Config.Repetition > 0 {
+				run += fmt.Sprintf(" (repeat %d)", result.Config.Repetition+1)
+			}
+			verdict, measured := "PASS", ""
+			if result.Failure != nil {
+				verdict, measured = "FAIL", "run failed ("+string(result.Failure.Kind)+")"
+			} else {
+				var v float64
+				v, measured = slo.measure(result)
+				if !slo.holds(v) {
+					verdict = "FAIL"
+				}
+			}
+			if verdict == "FAIL" {
+				failed++
+			}
+			fmt.Printf("\t%-4s  %-24s  %-48s  %s\n", verdict, slo.Spec, run, measured)
+		}
+		if !checked {
+			failed++
+			fmt.Printf("\t%-4s  %-24s  no run had %d co-routines\n", "FAIL", slo.Spec, slo.Coroutines)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// smokeRequests is how many requests each run of a -smoke sweep issues, instead of the usual 100.
+const smokeRequests = 20
+
+// smokePass is one of the sweeps a -smoke run is made of: the flags it sets, unless they are given on the command line
+// too, and the artifacts, as globs within its sweep directory, it must leave behind.
+type smokePass struct {
+	name      string
+	flags     [][2]string
+	artifacts []string
+}
+
+// smokeWork is the microseconds of work every pass's requests do.
+var smokeWork = [][2]string{
+	{"work-time", "20us"},
+	{"network-time", "100us"},
+	{"splits", "2"},
+	{"seed", "1"},
+}
+
+// smokePasses are the sweeps of a -smoke run. The first runs every executor at two co-routine counts, through every
+// reporter but the terminal dashboard, with the analyses that are off by default, which draw plots of their own,
+// turned on. The rest are a run each: one profiled, which is slow enough that it is done only once, and one for each
+// load generator other than the closed loop, among which the exporters, -template and -ascii are spread. What needs a
+// service outside the process, such as -sql or -redis, is left off; the exporters push to sinks in the process.
+func smokePasses(dir string, sinks *smokeSinks) []smokePass {
+	single := [][2]string{{"executor", "semaphore"}, {"coroutines", "4"}, {"baseline", "off"}}
+	pass := func(name string, artifacts []string, flags ...[2]string) smokePass {
+		flags = append(append(append([][2]string{}, smokeWork...), single...), flags...)
+		return smokePass{name: name, flags: flags, artifacts: artifacts}
+	}
+	plots := []string{"slowest_requests-*.html", "latency_time-*.png"} // the gantt chart and the latency trace
+	return []smokePass{
+		{
+			name: "executors",
+			flags: append(append([][2]string{}, smokeWork...), [][2]string{
+				{"executor", strings.Join(executorNames, ",")},
+				{"coroutines", "1,4"},
+				{"baseline", "10"},
+				{"report", "console,json,plots,prometheus"},
+				{"prom-addr", "127.0.0.1:0"},
+				{"save", "sweep.json"},
+				{"throughput-interval", "1ms"},
+				{"steady-window", "2"},
+				{"budget", "4"},
+				{"phases", "true"},
+				{"apdex", "1ms"},
+				{"hedge-after", "200us"},
+				{"outliers", "iqr"},
+				{"request-trace", "true"},
+				{"trace-sample", "0.1"},
+				{"plot-memory", "true"},
+				{"plot-hist-grid", "true"},
+			}...),
+			artifacts: append([]string{"results.jsonl", "sweep.json", "requests-*.jsonl", "traces-*.jsonl", "hist_grid.png"}, plots...),
+		},
+		pass("profile", []string{"cpu-*.pprof", "cpu-*.folded", "trace-*.out"},
+			[2]string{"report", "plots"}, [2]string{"cpu-profile", "true"}, [2]string{"runtime-trace", "true"}),
+		pass("poisson", append([]string{"smoke.txt"}, plots...),
+			[2]string{"load", "poisson"}, [2]string{"rate", "2000"}, [2]string{"report", "console,plots"},
+			[2]string{"template", filepath.Join(dir, "smoke.txt.tmpl")},
+			[2]string{"influx", "http://" + sinks.http.Addr().String() + "/api/v2/write?org=perf&bucket=smoke"},
+			[2]string{"graphite", sinks.tcp.Addr().String()}, [2]string{"statsd", sinks.udp.LocalAddr().String()}),
+		pass("constant", plots, [2]string{"load", "constant"}, [2]string{"rate", "2000"}, [2]string{"report", "plots"}),
+		pass("burst", plots, [2]string{"load", "burst"}, [2]string{"burst-size", "5"}, [2]string{"burst-interval", "2ms"},
+			[2]string{"report", "plots"}),
+		pass("users", []string{"results.jsonl"}, [2]string{"load", "users"}, [2]string{"think-time", "100us"},
+			[2]string{"report", "console,json"}, [2]string{"ascii", "true"}),
+		pass("trace", plots, [2]string{"load", "trace"}, [2]string{"trace", filepath.Join(dir, "arrivals.txt")},
+			[2]string{"report", "plots"}),
+	}
+}
+
+// smokeFiles are written to a -smoke run's directory for its passes to read.
+var smokeFiles = map[string]string{
+	"smoke.txt.tmpl": "{{range .Runs}}{{.Executor}} at {{.NumCoroutines}} co-routines: {{rps .ThroughputRps}}\n{{end}}",
+	"arrivals.txt":   "0s\n500us\n1ms\n1ms\n3ms\n",
+}
+
+// runSmoke runs every smokePass as a sweep of its own with args, each into a directory named after it under -out-dir,
+// or a new temporary directory without one, and checks each left its artifacts behind.
+func runSmoke(fs *flag.FlagSet, args []string) error {
+	dir := fs.Lookup("out-dir").Value.String()
+	given := false
+	fs.Visit(func(f *flag.Flag) { given = given || f.Name == "out-dir" })
+	if !given {
+		var err error
+		if dir, err = os.MkdirTemp("", "perf-smoke-"); err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, content := range smokeFiles {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+	sinks, err := startSmokeSinks()
+	if err != nil {
+		return err
+	}
+	defer sinks.Close()
+	for _, pass := range smokePasses(dir, sinks) {
+		pass := pass
+		fmt.Printf("Smoke pass %s\n", pass.name)
+		if err := sweep(args, &pass, filepath.Join(dir, pass.name)); err != nil {
+			return fmt.Errorf("-smoke pass %s: %w", pass.name, err)
+		}
+		for _, pattern := range pass.artifacts {
+			if matches, _ := filepath.Glob(filepath.Join(dir, pass.name, "sweep-*", pattern)); len(matches) == 0 {
+				return fmt.Errorf("-smoke pass %s wrote no %s", pass.name, pattern)
+			}
+		}
+	}
+	fmt.Printf("Smoke passed; artifacts are in %s\n", dir)
+	return nil
+}
+
+// applySmoke sets pass's flags on fs, parsed already, leaving those given on the command line be, and writes the
+// sweep's artifacts to dir.
+func applySmoke(fs *flag.FlagSet, pass *smokePass, dir string) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, f := range pass.flags {
+		if given[f[0]] {
+			continue
+		}
+		if err := fs.Set(f[0], f[1]); err != nil {
+			return fmt.Errorf("-smoke: -%s=%s: %w", f[0], f[1], err)
+		}
+	}
+	return fs.Set("out-dir", dir)
+}
+
+// smokeSinks stand in for the services the exporters push to, discarding whatever they are sent: an InfluxDB write
+// endpoint over HTTP, a Graphite listener over TCP and a StatsD one over UDP.
+type smokeSinks struct {
+	http net.Listener
+	tcp  net.Listener
+	udp  net.PacketConn
+}
+
+func startSmokeSinks() (*smokeSinks, error) {
+	s := &smokeSinks{}
+	var err error
+	if s.http, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	if s.tcp, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if s.udp, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		s.Close()
+		return nil, err
+	}
+	go http.Serve(s.http, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	go func() {
+		for {
+			conn, err := s.tcp.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	go func() {
+		buf := make([]byte, 64<<10)
+		for {
+			if _, _, err := s.udp.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+	return s, nil
+}
+
+func (s *smokeSinks) Close() {
+	for _, c := range []io.Closer{s.http, s.tcp, s.udp} {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// What a run's throughput is divided by for the speedup reported. The serial baseline is what BenchmarkResult.Speedup
+// holds, and is what results files record; the others are worked out from the sweep's results when they are reported.
+const (
+	// speedupSerial divides by the serial baseline's throughput: requests called one after another, with no executor
+	// in the way. With splits, a request's phases can overlap its own, so this can exceed the co-routine count.
+	speedupSerial = "serial"
+	// speedupSingle divides by the throughput of the same configuration's run at one co-routine, so that the executor
+	// and harness are on both sides and a speedup of n at n co-routines is perfect scaling.
+	speedupSingle = "single"
+	// speedupIdeal divides by the throughput Amdahl's law allows at the run's co-routine count, as idealSpeedup
+	// models it, so that 1 is all the machine could give.
+	speedupIdeal = "ideal"
+)
+
+var speedupBases = []string{speedupSerial, speedupSingle, speedupIdeal}
+
+func validSpeedupBasis(basis string) error {
+	for _, b := range speedupBases {
+		if basis == b {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown speedup basis %q, expected one of %s", basis, strings.Join(speedupBases, ", "))
+}
+
+// speedupLabel names a basis's speedup, for axes and tables.
+func speedupLabel(basis string) string {
+	switch basis {
+	case speedupSingle:
+		return "Speedup over 1 co-routine"
+	case speedupIdeal:
+		return "Fraction of ideal throughput"
+	}
+	return "Speedup"
+}
+
+// speedupSeriesKey identifies the configuration a run belongs to, leaving out its co-routine count and repetition.
+func speedupSeriesKey(cfg RunConfig) string {
+	parts := []string{cfg.Executor, fmt.Sprint(cfg.GOMAXPROCS), cfg.Load.String(), cfg.Tags.String()}
+	for _, d := range gridDims {
+		parts = append(parts, d.value(cfg))
+	}
+	return strings.Join(parts, "|")
+}
+
+// speedupNormalizer works out speedups over a basis. For speedupSingle it needs the runs at one co-routine, which
+// the sweep runs first, passed to add before the runs normalized against them.
+type speedupNormalizer struct {
+	basis  string
+	single map[string][]float64 // throughputs of the runs at one co-routine, by series
+}
+
+func newSpeedupNormalizer(basis string) *speedupNormalizer {
+	return &speedupNormalizer{basis: basis, single: map[string][]float64{}}
+}
+
+// add records r if it ran at one co-routine.
+func (n *speedupNormalizer) add(r BenchmarkResult) {
+	if r.NumCoroutines == 1 && r.Failure == nil && r.ThroughputRps > 0 {
+		key := speedupSeriesKey(r.Config)
+		n.single[key] = append(n.single[key], r.ThroughputRps)
+	}
+}
+
+// of returns r's speedup over the basis, NaN where the basis has nothing for r, such as a series without a run at one
+// co-routine.
+func (n *speedupNormalizer) of(r BenchmarkResult) float64 {
+	switch n.basis {
+	case speedupSingle:
+		runs := n.single[speedupSeriesKey(r.Config)]
+		if len(runs) == 0 {
+			return math.NaN()
+		}
+		var sum float64
+		for _, rps := range runs {
+			sum += rps
+		}
+		return r.ThroughputRps / (sum / float64(len(runs)))
+	case speedupIdeal:
+		cpus := r.EffectiveCPUs
+		if cpus <= 0 {
+			cpus = float64(r.Config.GOMAXPROCS)
+		}
+		total := (r.WorkTime + r.NetworkTime).Seconds()
+		if total <= 0 || r.NumCoroutines < 1 {
+			return math.NaN()
+		}
+		ideal := idealSpeedup(float64(r.NumCoroutines), cpus, float64(r.WorkTime), float64(r.NetworkTime)) / total
+		return r.ThroughputRps / ideal
+	}
+	return r.Speedup
+}
+
+// normalizeSpeedups returns a copy of results with each Speedup over basis instead of the serial baseline.
+func normalizeSpeedups(results []BenchmarkResult, basis string) []BenchmarkResult {
+	if basis == "" || basis == speedupSerial {
+		return results
+	}
+	n := newSpeedupNormalizer(basis)
+	for _, r := range results {
+		n.add(r)
+	}
+	normalized := make([]BenchmarkResult, len(results))
+	for i, r := range results {
+		normalized[i] = r
+		normalized[i].Speedup = n.of(r)
+	}
+	return normalized
+}
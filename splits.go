@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// plotSplits draws throughput and p99 against the number of splits, one line per co-routine count, for sweeps over
+// more than one: how finely CPU work is interleaved with network waits decides how often a request gives up its
+// co-routine's CPU, which shows in both. Repeated runs are averaged; failed ones are left out.
+func plotSplits(results []BenchmarkResult, opts PlotOptions) error {
+	type key struct {
+		executor   string
+		gomaxprocs int
+		coroutines int64
+	}
+	var keys []key
+	runs := map[key][]BenchmarkResult{}
+	splits := map[int]bool{}
+	executors, procs := map[string]bool{}, map[int]bool{}
+	for _, result := range results {
+		k := key{result.Config.Executor, result.Config.GOMAXPROCS, result.NumCoroutines}
+		if _, ok := runs[k]; !ok {
+			keys = append(keys, k)
+		}
+		runs[k] = append(runs[k], result)
+		splits[result.Config.Splits] = true
+		executors[k.executor], procs[k.gomaxprocs] = true, true
+	}
+	if len(splits) < 2 {
+		return nil
+	}
+
+	throughput, latency := plot.New(), plot.New()
+	throughput.Title.Text = "Throughput vs. Splits"
+	throughput.Y.Label.Text = throughputLabel("Throughput")
+	throughputTicks(&throughput.Y)
+	throughput.Y.Min = 0
+	latency.Title.Text = "p99 Response Time vs. Splits"
+	latency.Y.Label.Text = latencyLabel("p99 response time")
+	opts.latencyAxis(&latency.Y, lowestLatency(results))
+	for _, plt := range []*plot.Plot{throughput, latency} {
+		plt.X.Label.Text = "Network phases per request (splits)"
+		plt.Legend.Top = true
+	}
+	for i, k := range keys {
+		label := fmt.Sprintf("%d co-routines", k.coroutines)
+		if len(executors) > 1 {
+			label = k.executor + ", " + label
+		}
+		if len(procs) > 1 {
+			label += fmt.Sprintf(", GOMAXPROCS=%d", k.gomaxprocs)
+		}
+		for _, panel := range []struct {
+			plt *plot.Plot
+			y   func(BenchmarkResult) float64
+		}{
+			{throughput, func(r BenchmarkResult) float64 { return r.ThroughputRps }},
+			{latency, func(r BenchmarkResult) float64 { return r.ResponseTimesPercentile(99) }},
+		} {
+			pts := meanPoints(runs[k], func(cfg RunConfig) int { return cfg.Splits }, panel.y)
+			if len(pts) == 0 {
+				continue
+			}
+			line, points, err := plotter.NewLinePoints(pts)
+			if err != nil {
+				return err
+			}
+			line.LineStyle.Color = plotutil.Color(i)
+			line.LineStyle.Dashes = plotutil.Dashes(i / len(plotutil.DefaultColors))
+			points.GlyphStyle.Color = plotutil.Color(i)
+			panel.plt.Add(line, points)
+			panel.plt.Legend.Add(label, line, points)
+		}
+	}
+	opts.fitLatency(&latency.Y)
+
+	plots := [][]*plot.Plot{{throughput, latency}}
+	return opts.saveCanvas(10*vg.Inch, 4*vg.Inch, opts.sweepFile("splits_effect.png"), func(dc draw.Canvas) {
+		tiles := draw.Tiles{Rows: 1, Cols: 2, PadX: vg.Millimeter * 6, PadTop: vg.Millimeter * 2, PadBottom: vg.Millimeter * 2,
+			PadLeft: vg.Millimeter * 2, PadRight: vg.Millimeter * 2}
+		canvases := plot.Align(plots, tiles, dc)
+		throughput.Draw(canvases[0][0])
+		latency.Draw(canvases[0][1])
+	})
+}
+
+// meanPoints averages y over the runs at each value of x, in increasing order of x.
+func meanPoints(runs []BenchmarkResult, x func(RunConfig) int, y func(BenchmarkResult) float64) plotter.XYs {
+	sums, counts := map[int]float64{}, map[int]int{}
+	for _, r := range runs {
+		if r.Failure != nil || r.ResponseTimes.Count() == 0 {
+			continue
+		}
+		sums[x(r.Config)] += y(r)
+		counts[x(r.Config)]++
+	}
+	var pts plotter.XYs
+	for s, n := range counts {
+		pts = append(pts, plotter.XY{X: float64(s), Y: sums[s] / float64(n)})
+	}
+	sort.Slice(pts, func(i, j int) bool { return pts[i].X < pts[j].X })
+	return pts
+}
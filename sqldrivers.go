@@ -0,0 +1,7 @@
+package main
+
+// Database drivers -sql can reach. SQLite's is only built in with -tags sqlite; see historydb_sqlite.go.
+import (
+	_ "github.com/go-sql-driver/mysql" // as "mysql"
+	_ "github.com/lib/pq"              // as "postgres"
+)
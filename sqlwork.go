@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLWorkload adds a query against a real database after every network phase, through database/sql and its
+// connection pool. The pool's cap on open connections is a dimension of the sweep, RunConfig.SQLMaxOpen, so that it
+// can be swept against the co-routine count: requests beyond the cap wait for a connection, much as they wait for a
+// co-routine when the executor is bounded.
+type SQLWorkload struct {
+	Driver   string
+	DSN      string
+	Query    string
+	Args     []SQLArg
+	MaxIdle  int
+	Lifetime time.Duration // how long a connection is reused; zero for as long as it stays healthy
+
+	db *sql.DB
+}
+
+// SQLArg is one of a query's parameters: a fixed value, passed as an integer if it is one, or with Rand set a random
+// integer in [0, Rand) drawn from the request's random stream, so that the same seed queries the same rows.
+type SQLArg struct {
+	Value string
+	Rand  int64
+}
+
+// parseSQLArgs parses a comma-separated list of parameters, each a literal or rand:<n>.
+func parseSQLArgs(s string) ([]SQLArg, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var args []SQLArg
+	for _, v := range strings.Split(s, ",") {
+		if strings.HasPrefix(v, "rand:") {
+			n, err := strconv.ParseInt(strings.TrimPrefix(v, "rand:"), 10, 64)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid SQL parameter %q: rand:<n> needs a positive bound", v)
+			}
+			args = append(args, SQLArg{Rand: n})
+			continue
+		}
+		args = append(args, SQLArg{Value: v})
+	}
+	return args, nil
+}
+
+func (a SQLArg) String() string {
+	if a.Rand > 0 {
+		return fmt.Sprintf("rand:%d", a.Rand)
+	}
+	return a.Value
+}
+
+func (w *SQLWorkload) Open() error {
+	db, err := sql.Open(w.Driver, w.DSN)
+	if err != nil {
+		drivers := sql.Drivers()
+		sort.Strings(drivers)
+		return fmt.Errorf("%w (built in: %s)", err, strings.Join(drivers, ", "))
+	}
+	db.SetMaxIdleConns(w.MaxIdle)
+	db.SetConnMaxLifetime(w.Lifetime)
+	// A first query checks the database is there and takes the query and its parameters.
+	if _, err := w.queryRows(db, rand.New(rand.NewSource(0))); err != nil {
+		db.Close()
+		return err
+	}
+	w.db = db
+	return nil
+}
+
+func (w *SQLWorkload) Close() error {
+	return w.db.Close()
+}
+
+// setMaxOpen caps the pool's open connections for the next run; zero lifts the cap. Connections over the cap are
+// closed as they are returned.
+func (w *SQLWorkload) setMaxOpen(n int) {
+	w.db.SetMaxOpenConns(n)
+}
+
+// queryRows runs the query and reads every row it returns, returning how many there were.
+func (w *SQLWorkload) queryRows(db *sql.DB, r *rand.Rand) (int, error) {
+	args := make([]interface{}, len(w.Args))
+	for i, a := range w.Args {
+		if a.Rand > 0 {
+			args[i] = r.Int63n(a.Rand)
+		} else if n, err := strconv.ParseInt(a.Value, 10, 64); err == nil {
+			args[i] = n
+		} else {
+			args[i] = a.Value
+		}
+	}
+	rows, err := db.QueryContext(context.Background(), w.Query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	return n, rows.Err()
+}
+
+func (w *SQLWorkload) String() string {
+	s := fmt.Sprintf("%s on %s", w.Query, w.Driver)
+	if len(w.Args) > 0 {
+		args := make([]string, len(w.Args))
+		for i, a := range w.Args {
+			args[i] = a.String()
+		}
+		s += " with " + strings.Join(args, ", ")
+	}
+	return s
+}
+
+// SQLPoolStats is how the connection pool held up over a run.
+type SQLPoolStats struct {
+	MaxOpen  int           `json:"max_open"` // the run's cap on open connections; zero for none
+	Waits    int64         `json:"waits"`    // queries that waited for a connection
+	WaitTime time.Duration `json:"wait_ns"`  // they waited in all
+	Opened   int64         `json:"opened"`   // connections opened, counting ones closed again as idle or over the cap
+}
+
+// poolStats returns how many queries have waited for a connection, for how long, and how many connections were
+// opened, since the database was opened.
+func (w *SQLWorkload) poolStats() SQLPoolStats {
+	s := w.db.Stats()
+	return SQLPoolStats{MaxOpen: s.MaxOpenConnections, Waits: s.WaitCount, WaitTime: s.WaitDuration,
+		Opened: int64(s.OpenConnections) + s.MaxIdleClosed + s.MaxIdleTimeClosed + s.MaxLifetimeClosed}
+}
+
+// since returns the pool's stats over the time since before was taken.
+func (s SQLPoolStats) since(before SQLPoolStats) *SQLPoolStats {
+	return &SQLPoolStats{MaxOpen: s.MaxOpen, Waits: s.Waits - before.Waits, WaitTime: s.WaitTime - before.WaitTime,
+		Opened: s.Opened - before.Opened}
+}
+
+// Queries go to a real database and so always run on wall-clock time.
+func doSQLWork(w *SQLWorkload, r *rand.Rand, log *RequestLog) error {
+	log.Begin(log.now(realClock{}), "sql", "SQL query", 0)
+	n, err := w.queryRows(w.db, r)
+	if err != nil {
+		return err
+	}
+	end := log.now(realClock{})
+	if log.Enabled(LogDebug) {
+		log.Debugf(end, "%d rows", n)
+	}
+	log.End(end)
+	return nil
+}
+
+func outputSQLPool(result BenchmarkResult) {
+	if result.Config.SQL != nil {
+		fmt.Printf("\tSQL: %v\n", result.Config.SQL)
+	}
+	p := result.SQLPool
+	if p == nil {
+		return
+	}
+	limit := "no cap on open connections"
+	if p.MaxOpen == 1 {
+		limit = "at most 1 open connection"
+	} else if p.MaxOpen > 0 {
+		limit = fmt.Sprintf("at most %s open connections", formatCount(int64(p.MaxOpen)))
+	}
+	fmt.Printf("\tSQL pool: %s; %s connections opened, %s queries waited for one, for %s in all\n", limit,
+		formatCount(p.Opened), formatCount(p.Waits), formatDuration(p.WaitTime))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdInterval is how often the statsd reporter sends what it has gathered.
+const statsdInterval = time.Second
+
+// statsdMaxPacket keeps each datagram under the MTU of most networks, so that none is fragmented and lost whole.
+const statsdMaxPacket = 1432
+
+// statsdReporter emits the load the sweep generates over StatsD while it runs, for APM dashboards to show live:
+//
+//	<prefix>requests:<n>|c              requests completed, sent each second
+//	<prefix>latency:<ms>|ms             the response time of each request, at the sample rate
+//	<prefix>coroutines:<n>|g            the co-routines of the run, set as each starts
+//	<prefix>throughput_rps:<rps>|g      each run's throughput, and its errors and p99 alongside, once it completes
+//
+// With DogStatsD tags, every metric is tagged with the run's executor, GOMAXPROCS and co-routine count and any -tag;
+// plain StatsD has no tags, so there runs can only be told apart by when they ran. Metrics go over UDP and are lost,
+// rather than slowing the run, when nothing listens.
+type statsdReporter struct {
+	conn       net.Conn
+	prefix     string
+	dogstatsd  bool
+	sampleRate float64
+
+	mu        sync.Mutex
+	tags      string // the DogStatsD suffix of the current run's metrics
+	completed int64  // since the last send
+	latencies []float64
+	sampled   uint64 // requests seen, of which every 1/sampleRate-th has its latency sent
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+func newStatsdReporter(addr, prefix string, dogstatsd bool, sampleRate float64) (*statsdReporter, error) {
+	if sampleRate <= 0 || sampleRate > 1 {
+		return nil, fmt.Errorf("-statsd-sample-rate must be above 0 and at most 1, got %g", sampleRate)
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("-statsd: %w", err)
+	}
+	r := &statsdReporter{conn: conn, prefix: prefix, dogstatsd: dogstatsd, sampleRate: sampleRate,
+		stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(r.stopped)
+		ticker := time.NewTicker(statsdInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.flush(nil)
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+func (r *statsdReporter) OnRunStart(cfg RunConfig) {
+	// What the last run left goes out under its own tags.
+	r.flush(nil)
+	r.mu.Lock()
+	r.tags = ""
+	if r.dogstatsd {
+		tags := []string{"executor:" + cfg.Executor, "gomaxprocs:" + strconv.Itoa(cfg.GOMAXPROCS),
+			"coroutines:" + strconv.FormatInt(cfg.NumCoroutines, 10)}
+		for _, tag := range strings.Split(cfg.Tags.String(), ",") {
+			if tag != "" {
+				tags = append(tags, strings.Replace(tag, "=", ":", 1))
+			}
+		}
+		r.tags = "|#" + strings.Join(tags, ",")
+	}
+	r.mu.Unlock()
+	r.flush([]string{r.metric("coroutines", strconv.FormatInt(cfg.NumCoroutines, 10), "g")})
+}
+
+func (r *statsdReporter) OnSample(sample Sample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.completed++
+	r.sampled++
+	if uint64(float64(r.sampled)*r.sampleRate) != uint64(float64(r.sampled-1)*r.sampleRate) {
+		r.latencies = append(r.latencies, float64(sample.Latency)/float64(time.Millisecond))
+	}
+}
+
+func (r *statsdReporter) OnRunComplete(result BenchmarkResult) error {
+	final := []string{
+		r.metric("throughput_rps", strconv.FormatFloat(result.ThroughputRps, 'f', 2, 64), "g"),
+		r.metric("errors", strconv.Itoa(result.Errors), "c"),
+	}
+	if result.ResponseTimes != nil && result.ResponseTimes.Count() > 0 {
+		final = append(final, r.metric("p99_ms", strconv.FormatFloat(result.ResponseTimesPercentile(99), 'f', 3, 64), "g"))
+	}
+	r.flush(final)
+	return nil
+}
+
+func (r *statsdReporter) Close() error {
+	close(r.stop)
+	<-r.stopped
+	r.flush(nil)
+	return r.conn.Close()
+}
+
+// metric formats one metric in the current run's tags; r.mu must not be held.
+func (r *statsdReporter) metric(name, value, kind string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.prefix + name + ":" + value + "|" + kind + r.tags
+}
+
+// flush sends the requests completed and the latencies sampled since the last flush, then extra, packed into as few
+// datagrams as fit.
+func (r *statsdReporter) flush(extra []string) {
+	r.mu.Lock()
+	var lines []string
+	if r.completed > 0 {
+		lines = append(lines, r.prefix+"requests:"+strconv.FormatInt(r.completed, 10)+"|c"+r.tags)
+	}
+	rate := ""
+	if r.sampleRate < 1 {
+		rate = "|@" + strconv.FormatFloat(r.sampleRate, 'g', -1, 64)
+	}
+	for _, ms := range r.latencies {
+		lines = append(lines, r.prefix+"latency:"+strconv.FormatFloat(ms, 'f', 3, 64)+"|ms"+rate+r.tags)
+	}
+	r.completed, r.latencies = 0, r.latencies[:0]
+	r.mu.Unlock()
+	lines = append(lines, extra...)
+
+	var packet bytes.Buffer
+	for _, line := range lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			r.conn.Write(packet.Bytes()) // UDP: a metric nobody receives is not an error
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	if packet.Len() > 0 {
+		r.conn.Write(packet.Bytes())
+	}
+}
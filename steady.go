@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// SteadyState is where a run's throughput settled: the start of the first SteadyWindow intervals of its throughput
+// series whose coefficient of variation was under SteadyCV. What comes before it is warm-up, of the executor, the
+// caches, the connection pools or the runtime's heap, that a short run's averages would otherwise carry.
+type SteadyState struct {
+	Start         time.Duration `json:"start_ns"`
+	ThroughputRps float64       `json:"throughput_rps"` // mean of the intervals from Start to the end of the run
+	CV            float64       `json:"cv"`             // of those intervals
+	Applied       bool          `json:"applied"`        // the run's throughput and response times are from Start on alone
+}
+
+// findSteadyState finds the steady state of a throughput series recorded every interval, or returns nil when no
+// window of that many intervals varied by less than maxCV.
+func findSteadyState(series []float64, interval time.Duration, window int, maxCV float64) *SteadyState {
+	if maxCV <= 0 || window < 2 || interval <= 0 {
+		return nil
+	}
+	for i := 0; i+window <= len(series); i++ {
+		if cv := coefficientOfVariation(series[i : i+window]); cv < maxCV {
+			rest := series[i:]
+			var sum float64
+			for _, rps := range rest {
+				sum += rps
+			}
+			return &SteadyState{Start: time.Duration(i) * interval, ThroughputRps: sum / float64(len(rest)),
+				CV: coefficientOfVariation(rest)}
+		}
+	}
+	return nil
+}
+
+func coefficientOfVariation(values []float64) float64 {
+	var sum, sq float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if mean <= 0 {
+		return math.Inf(1)
+	}
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sq/float64(len(values))) / mean
+}
+
+// steadySample is a completed request's response time and when it finished, kept with -steady-only to narrow the
+// run's response times to its steady state once that is known.
+type steadySample struct {
+	finished time.Duration
+	ms       float64
+}
+
+// applySteadyState finds result's steady state and, with SteadyOnly, narrows its throughput, speedup, CPU utilization
+// and response times to the steady window. Queue waits, errors and the run's other statistics still cover all of it.
+func applySteadyState(result *BenchmarkResult, rc *runCollection) error {
+	cfg := result.Config
+	steady := findSteadyState(result.ThroughputSeries, cfg.ThroughputInterval, cfg.SteadyWindow, cfg.SteadyCV)
+	result.SteadyState = steady
+	if steady == nil || !cfg.SteadyOnly || steady.Start == 0 || result.ThroughputRps <= 0 {
+		return nil
+	}
+	responseTimes, err := newCollector(cfg.Collector, cfg.ReservoirSize, len(rc.steady), newRand(cfg.Seed, collectorRandStream))
+	if err != nil {
+		return err
+	}
+	for _, s := range rc.steady {
+		if s.finished >= steady.Start {
+			responseTimes.Add(s.ms)
+		}
+	}
+	if responseTimes.Count() == 0 {
+		return nil
+	}
+	scale := steady.ThroughputRps / result.ThroughputRps
+	result.ThroughputRps = steady.ThroughputRps
+	result.Speedup *= scale
+	result.CpuUtilization *= scale
+	result.ResponseTimes = responseTimes
+	result.Latency = latencyStats(responseTimes)
+	result.Outliers = detectOutliers(cfg.Outliers, responseTimes)
+	steady.Applied = true
+	return nil
+}
+
+func outputSteadyState(result BenchmarkResult) {
+	cfg := result.Config
+	if cfg.SteadyCV <= 0 || len(result.ThroughputSeries) < cfg.SteadyWindow {
+		return
+	}
+	s := result.SteadyState
+	if s == nil {
+		fmt.Printf("\tSteady state: not reached; no %d intervals of %s varied by under %s\n", cfg.SteadyWindow,
+			formatDuration(cfg.ThroughputInterval), formatPercent(cfg.SteadyCV))
+		return
+	}
+	line := fmt.Sprintf("\tSteady state: from %s, %s (varying by %s)", formatDuration(s.Start), formatRps(s.ThroughputRps), formatPercent(s.CV))
+	if s.Applied {
+		line += "; throughput and response times are from then on"
+	}
+	fmt.Println(line)
+}
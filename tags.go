@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tags label the runs of a sweep, such as branch=feature-x, so that results from different builds or setups can be
+// told apart once they share a results file. A tag given without a value is kept with an empty one.
+type Tags map[string]string
+
+// Set adds a key=value tag. It makes Tags a flag.Value, so that -tag can be repeated.
+func (t Tags) Set(s string) error {
+	key, value := s, ""
+	if eq := strings.Index(s, "="); eq >= 0 {
+		key, value = s[:eq], s[eq+1:]
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return fmt.Errorf("tag %q has no name", s)
+	}
+	t[key] = strings.TrimSpace(value)
+	return nil
+}
+
+func (t Tags) String() string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k
+		if t[k] != "" {
+			parts[i] += "=" + t[k]
+		}
+	}
+	return strings.Join(parts, ",")
+}
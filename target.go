@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// targetEnv carries a targetSpec to a target process. Like a load-generating child, the target is started with the
+// parent's own arguments, so it simulates the same service.
+const targetEnv = "PERF_TARGET"
+
+// targetSpec is where a target process listens.
+type targetSpec struct {
+	Network string
+	Address string
+}
+
+// targetListening prefixes the line a target process prints once it accepts requests.
+const targetListening = "Target listening on "
+
+// The protocol is one exchange per request over a long-lived connection: the generator sends the run's seed and the
+// request's index, big-endian, and the target replies with a status byte, zero for success, and the request's output
+// or error text prefixed with its length.
+
+// RemoteTarget is the simulated service running in a process of its own, so that the load generator's garbage
+// collector and scheduler are not shared with the work being measured. Middleware still runs in the generator.
+type RemoteTarget struct {
+	Network string // "unix" or "tcp"
+	address string
+	dir     string
+	cmd     *exec.Cmd
+
+	mu   sync.Mutex
+	idle []net.Conn
+}
+
+// startRemoteTarget starts a target process and waits until it accepts connections.
+func startRemoteTarget(network string, args []string) (*RemoteTarget, error) {
+	spec := targetSpec{Network: network, Address: "127.0.0.1:0"}
+	t := &RemoteTarget{Network: network}
+	switch network {
+	case "unix":
+		dir, err := os.MkdirTemp("", "perf-target")
+		if err != nil {
+			return nil, err
+		}
+		t.dir = dir
+		spec.Address = filepath.Join(dir, "target.sock")
+	case "tcp":
+	default:
+		return nil, fmt.Errorf("unknown target %q, expected in-process, unix or tcp", network)
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	t.cmd = exec.Command(os.Args[0], args...)
+	t.cmd.Env = append(os.Environ(), targetEnv+"="+string(b))
+	t.cmd.Stderr = os.Stderr
+	stdout, err := t.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.cmd.Start(); err != nil {
+		return nil, err
+	}
+	lines := bufio.NewScanner(stdout)
+	for lines.Scan() {
+		if strings.HasPrefix(lines.Text(), targetListening) {
+			t.address = strings.TrimPrefix(lines.Text(), targetListening)
+			break
+		}
+	}
+	if t.address == "" {
+		t.Close()
+		return nil, errors.New("target process exited before accepting connections")
+	}
+	go io.Copy(io.Discard, stdout)
+	return t, nil
+}
+
+func (t *RemoteTarget) String() string {
+	return fmt.Sprintf("separate process over %s (%s)", t.Network, t.address)
+}
+
+// Close stops the target process, letting it drain like an interrupted sweep would.
+func (t *RemoteTarget) Close() error {
+	t.mu.Lock()
+	for _, conn := range t.idle {
+		conn.Close()
+	}
+	t.idle = nil
+	t.mu.Unlock()
+	if err := t.cmd.Process.Signal(os.Interrupt); err != nil {
+		t.cmd.Process.Kill() // Windows cannot deliver an interrupt to another process
+	}
+	t.cmd.Wait()
+	if t.dir != "" {
+		os.RemoveAll(t.dir)
+	}
+	return nil
+}
+
+// workload sends each request to the target, which rebuilds its random stream from seed and the request's index.
+func (t *RemoteTarget) workload(seed int64) Workload {
+	return WorkloadFunc(func(ctx context.Context, log *RequestLog) error {
+		x, ok := requestIndexFrom(ctx)
+		if !ok {
+			return errors.New("request has no index to send to the target")
+		}
+		return t.call(ctx, seed, x, log)
+	})
+}
+
+func (t *RemoteTarget) call(ctx context.Context, seed int64, x int, log *RequestLog) error {
+	conn, err := t.conn()
+	if err != nil {
+		return err
+	}
+	if ctx.Done() != nil {
+		// Abandoning the exchange leaves the connection out of step, so a cancelled request closes it.
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.SetDeadline(time.Now())
+			case <-stop:
+			}
+		}()
+	}
+	status, payload, err := exchange(conn, seed, x)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	t.release(conn)
+	if status != 0 {
+		return errors.New(payload)
+	}
+	if log.Enabled(LogInfo) && payload != "" {
+		var events []Event
+		if err := json.Unmarshal([]byte(payload), &events); err != nil {
+			return fmt.Errorf("target's log: %w", err)
+		}
+		log.Append(events)
+	}
+	return ctx.Err()
+}
+
+// conn takes an idle connection, or dials a new one when every connection is busy.
+func (t *RemoteTarget) conn() (net.Conn, error) {
+	t.mu.Lock()
+	if n := len(t.idle); n > 0 {
+		conn := t.idle[n-1]
+		t.idle = t.idle[:n-1]
+		t.mu.Unlock()
+		return conn, nil
+	}
+	t.mu.Unlock()
+	return net.Dial(t.Network, t.address)
+}
+
+func (t *RemoteTarget) release(conn net.Conn) {
+	t.mu.Lock()
+	t.idle = append(t.idle, conn)
+	t.mu.Unlock()
+}
+
+func exchange(conn net.Conn, seed int64, x int) (byte, string, error) {
+	var req [16]byte
+	binary.BigEndian.PutUint64(req[:8], uint64(seed))
+	binary.BigEndian.PutUint64(req[8:], uint64(x))
+	if _, err := conn.Write(req[:]); err != nil {
+		return 0, "", err
+	}
+	var head [5]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return 0, "", err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(head[1:]))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return 0, "", err
+	}
+	return head[0], string(payload), nil
+}
+
+// serveTarget runs the simulated service of base for a load generator until ctx is cancelled.
+func serveTarget(ctx context.Context, base RunConfig, spec string) error {
+	var target targetSpec
+	if err := json.Unmarshal([]byte(spec), &target); err != nil {
+		return fmt.Errorf("%s: %w", targetEnv, err)
+	}
+	if base.Pipeline != nil {
+		base.Pipeline.Start(base)
+		defer base.Pipeline.Stop()
+	}
+	listener, err := net.Listen(target.Network, target.Address)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	fmt.Println(targetListening + listener.Addr().String())
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			serveTargetConn(base, conn)
+		}()
+	}
+}
+
+func serveTargetConn(base RunConfig, conn net.Conn) {
+	var req [16]byte
+	for {
+		if _, err := io.ReadFull(conn, req[:]); err != nil {
+			return
+		}
+		cfg := base
+		cfg.Seed = int64(binary.BigEndian.Uint64(req[:8]))
+		x := int(binary.BigEndian.Uint64(req[8:]))
+		ctx, _ := cfg.requestContext(context.Background(), x)
+		// The target records at the load generator's level, having been started with its flags, and sends its log back
+		// as JSON.
+		log := newRequestLog(x, cfg.LogLevel)
+		status := byte(0)
+		var payload []byte
+		if err := simulatedWorkload(cfg).Do(ctx, log); err != nil {
+			status = 1
+			payload = []byte(err.Error())
+		} else if log != nil {
+			payload, _ = json.Marshal(log.Events)
+		}
+		var head [5]byte
+		head[0] = status
+		binary.BigEndian.PutUint32(head[1:], uint32(len(payload)))
+		if _, err := conn.Write(append(head[:], payload...)); err != nil {
+			return
+		}
+	}
+}
+
+// targetName is how results record where the service ran: empty for in-process, otherwise the network.
+func targetName(t *RemoteTarget) string {
+	if t == nil {
+		return ""
+	}
+	return t.Network
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TCPBackend spends network time on request/response exchanges with a built-in echo server. Every message starts
+// with the delay the server waits before echoing it back. With PoolSize zero every call dials a new connection;
+// otherwise up to PoolSize idle connections are kept for reuse.
+type TCPBackend struct {
+	MessageSize int
+	PoolSize    int
+
+	ln    net.Listener
+	addr  string
+	idle  chan net.Conn
+	conns sync.WaitGroup
+}
+
+const tcpHeaderSize = 8
+
+func (t *TCPBackend) Start() error {
+	if t.MessageSize < tcpHeaderSize {
+		return fmt.Errorf("TCP message size must be at least %d bytes, got %d", tcpHeaderSize, t.MessageSize)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	t.ln = ln
+	t.addr = ln.Addr().String()
+	t.idle = make(chan net.Conn, t.PoolSize)
+	go t.serve()
+	return nil
+}
+
+func (t *TCPBackend) serve() {
+	for {
+		conn, err := t.ln.Accept()
+		if err != nil {
+			return
+		}
+		t.conns.Add(1)
+		go func() {
+			defer t.conns.Done()
+			defer conn.Close()
+			buf := make([]byte, t.MessageSize)
+			for {
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				time.Sleep(time.Duration(binary.BigEndian.Uint64(buf)))
+				if _, err := conn.Write(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (t *TCPBackend) Close() error {
+	err := t.ln.Close()
+	close(t.idle)
+	for conn := range t.idle {
+		conn.Close()
+	}
+	t.conns.Wait()
+	return err
+}
+
+func (t *TCPBackend) get() (conn net.Conn, pooled bool, err error) {
+	select {
+	case conn := <-t.idle:
+		return conn, true, nil
+	default:
+	}
+	conn, err = net.Dial("tcp", t.addr)
+	return conn, false, err
+}
+
+func (t *TCPBackend) put(conn net.Conn) {
+	select {
+	case t.idle <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (t *TCPBackend) Call(networkTime time.Duration, log *RequestLog) error {
+	start := time.Now()
+	conn, pooled, err := t.get()
+	if err != nil {
+		return err
+	}
+	log.Begin(start, "network", "network time over TCP", networkTime)
+	if pooled {
+		log.Debugf(start, "on a pooled connection")
+	} else {
+		log.Debugf(start, "on a new connection")
+	}
+
+	buf := make([]byte, t.MessageSize)
+	binary.BigEndian.PutUint64(buf, uint64(networkTime))
+	if _, err := conn.Write(buf); err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		conn.Close()
+		return err
+	}
+	if t.PoolSize > 0 {
+		t.put(conn)
+	} else {
+		conn.Close()
+	}
+
+	log.End(time.Now())
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templateData is what a -template report is executed with: the sweep's environment, every run's summary as the json
+// reporter writes it, with the same field names, and every run's full result, whose methods, such as
+// ResponseTimesPercentile, templates can call.
+type templateData struct {
+	Environment sweepEnvironment
+	Runs        []jsonRunSummary
+	Results     []BenchmarkResult
+}
+
+// templateFuncs are the repo's own formatting helpers, so that a template's numbers read as the console's do.
+var templateFuncs = map[string]interface{}{
+	"ms":       formatMs,
+	"rps":      formatRps,
+	"percent":  formatPercent,
+	"duration": formatDuration,
+	"float":    formatFloat,
+	"count":    func(n int) string { return formatCount(int64(n)) },
+	"join":     strings.Join,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.MarshalIndent(v, "", "  ")
+		return string(b), err
+	},
+}
+
+// executer is a parsed text/template or html/template.
+type executer interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// reportTemplate is a user-supplied template, and the file it renders to: its own name without a trailing .tmpl, so
+// that slack.txt.tmpl renders to slack.txt. Templates rendering to .html or .htm are html/templates, which escape
+// what they insert; the rest are text/templates.
+type reportTemplate struct {
+	out  string
+	tmpl executer
+}
+
+func parseReportTemplate(path string) (reportTemplate, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return reportTemplate{}, err
+	}
+	out := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+	t := reportTemplate{out: out}
+	if ext := strings.ToLower(filepath.Ext(out)); ext == ".html" || ext == ".htm" {
+		t.tmpl, err = htmltemplate.New(out).Funcs(htmltemplate.FuncMap(templateFuncs)).Parse(string(src))
+	} else {
+		t.tmpl, err = template.New(out).Funcs(template.FuncMap(templateFuncs)).Parse(string(src))
+	}
+	if err != nil {
+		return reportTemplate{}, fmt.Errorf("-template %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// templateReporter renders -template reports once the sweep is over, with every run's result. Templates are parsed
+// up front, so that a mistake in one fails the sweep before it starts rather than after it has run.
+type templateReporter struct {
+	templates []reportTemplate
+	env       sweepEnvironment
+	out       *artifactStore
+	results   []BenchmarkResult
+}
+
+func newTemplateReporter(paths []string, env sweepEnvironment, out *artifactStore) (*templateReporter, error) {
+	r := &templateReporter{env: env, out: out}
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		t, err := parseReportTemplate(path)
+		if err != nil {
+			return nil, err
+		}
+		r.templates = append(r.templates, t)
+	}
+	return r, nil
+}
+
+func (r *templateReporter) OnRunStart(cfg RunConfig) {}
+
+func (r *templateReporter) OnSample(sample Sample) {}
+
+func (r *templateReporter) OnRunComplete(result BenchmarkResult) error {
+	r.results = append(r.results, result)
+	return nil
+}
+
+func (r *templateReporter) Close() error {
+	if len(r.results) == 0 {
+		return nil
+	}
+	data := templateData{Environment: r.env, Results: r.results}
+	for _, result := range r.results {
+		data.Runs = append(data.Runs, newRunSummary(result))
+	}
+	for _, t := range r.templates {
+		// Rendered in full before the file is written, so that a template failing halfway leaves no partial report.
+		var buf bytes.Buffer
+		if err := t.tmpl.Execute(&buf, data); err != nil {
+			return fmt.Errorf("-template: %w", err)
+		}
+		path := r.out.path(t.out, nil)
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SleepOvershoot is how late a sleep of Sleep typically woke up on the host: the median of several.
+type SleepOvershoot struct {
+	Sleep     time.Duration `json:"sleep"`
+	Overshoot time.Duration `json:"overshoot"`
+}
+
+// TimerCalibration is how late sleeps of a few lengths woke up on the host, shortest first. Linux usually overshoots
+// by tens of microseconds whatever the length; Windows' default 15.6ms timer tick and macOS timer coalescing can
+// overshoot by milliseconds, by an amount that depends on where the sleep ends between ticks, which silently
+// inflates every simulated network phase and skews comparisons between the two.
+type TimerCalibration []SleepOvershoot
+
+// timerCalibrationSleeps are the lengths calibrateTimer times: a short network phase, a typical one, and the default
+// network time, which is not a multiple of Windows' tick.
+var timerCalibrationSleeps = []time.Duration{time.Millisecond, 10 * time.Millisecond, 55 * time.Millisecond}
+
+// calibrateTimer times sleeps of each of timerCalibrationSleeps, as many as fit in about 100ms but at least five, and
+// keeps the median overshoot of each.
+func calibrateTimer() TimerCalibration {
+	var c TimerCalibration
+	for _, d := range timerCalibrationSleeps {
+		samples := int(100 * time.Millisecond / d)
+		if samples < 5 {
+			samples = 5
+		} else if samples > 21 {
+			samples = 21
+		}
+		overshoots := make([]time.Duration, samples)
+		for i := range overshoots {
+			start := time.Now()
+			time.Sleep(d)
+			overshoots[i] = time.Since(start) - d
+		}
+		sort.Slice(overshoots, func(i, j int) bool { return overshoots[i] < overshoots[j] })
+		c = append(c, SleepOvershoot{Sleep: d, Overshoot: overshoots[samples/2]})
+	}
+	return c
+}
+
+var (
+	timerOnce            sync.Once
+	hostTimerCalibration TimerCalibration
+)
+
+// timerCalibration calibrates the host's timer once, at the resolution raiseTimerResolution asks for, and returns
+// the calibration from then on.
+func timerCalibration() TimerCalibration {
+	timerOnce.Do(func() {
+		raiseTimerResolution()
+		hostTimerCalibration = calibrateTimer()
+	})
+	return hostTimerCalibration
+}
+
+// granularity is how late a 1ms sleep wakes up, the shortest calibrated.
+func (c TimerCalibration) granularity() time.Duration {
+	if len(c) == 0 {
+		return 0
+	}
+	return c[0].Overshoot
+}
+
+// overshoot is how late a sleep of d can be expected to wake up: interpolated between the calibrated lengths either
+// side of it, or the nearest one's outside their range.
+func (c TimerCalibration) overshoot(d time.Duration) time.Duration {
+	if len(c) == 0 {
+		return 0
+	}
+	if d <= c[0].Sleep {
+		return c[0].Overshoot
+	}
+	for i := 1; i < len(c); i++ {
+		if d <= c[i].Sleep {
+			lo, hi := c[i-1], c[i]
+			f := float64(d-lo.Sleep) / float64(hi.Sleep-lo.Sleep)
+			return lo.Overshoot + time.Duration(f*float64(hi.Overshoot-lo.Overshoot))
+		}
+	}
+	return c[len(c)-1].Overshoot
+}
+
+func (c TimerCalibration) String() string {
+	parts := make([]string, len(c))
+	for i, s := range c {
+		parts[i] = fmt.Sprintf("%v sleeps overshoot by %v", s.Sleep, s.Overshoot)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sleepTime is what to sleep for a network wait of d to take d with cfg.CompensateTimer: d less the overshoot the
+// host's timer was calibrated at for a sleep that long, and never less than zero. A scaled clock's real sleeps are
+// shorter by its factor, and are compensated for what they overshoot by at that length.
+func (cfg RunConfig) sleepTime(d time.Duration) time.Duration {
+	if !cfg.CompensateTimer {
+		return d
+	}
+	switch c := cfg.clock().(type) {
+	case realClock:
+		d -= timerCalibration().overshoot(d)
+	case *scaledClock:
+		d -= time.Duration(float64(timerCalibration().overshoot(time.Duration(float64(d)/c.factor))) * c.factor)
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// checkTimerResolution warns about sleeps in cfg that are short enough for timer granularity to distort them by more
+// than 10%. Under time compression the real sleeps are shorter than the configured ones, and it is those that count.
+func checkTimerResolution(cfg RunConfig, granularity time.Duration) {
+	if cfg.Network != nil || cfg.Splits == 0 {
+		return
+	}
+	phases := map[string]time.Duration{"network phase": cfg.NetworkTime / time.Duration(cfg.Splits)}
+	for _, class := range cfg.Mix {
+		phases[class.Name+" network phase"] = class.NetworkTime / time.Duration(cfg.Splits)
+	}
+	if cfg.Load.Rate > 0 {
+		phases["arrival interval"] = time.Duration(float64(time.Second) / cfg.Load.Rate)
+	}
+	for name, d := range phases {
+		if c, ok := cfg.Clock.(*scaledClock); ok {
+			d = time.Duration(float64(d) / c.factor)
+		}
+		if granularity*10 > d {
+			fmt.Fprintf(os.Stderr, "Warning: %s of %v is below reliable timer resolution (sleeps overshoot by ~%v); "+
+				"lengthen it or expect inflated latencies.\n", name, d, granularity)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// raiseTimerResolution is a no-op: other platforms have no process-wide timer resolution to raise. macOS coalescing
+// can only be detected, by calibrateTimer.
+func raiseTimerResolution() {}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "syscall"
+
+// raiseTimerResolution asks Windows for a 1ms timer tick instead of the default 15.6ms for the life of the process.
+func raiseTimerResolution() {
+	syscall.NewLazyDLL("winmm.dll").NewProc("timeBeginPeriod").Call(1)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"image/color"
+	"sort"
+	"time"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// throughputSeries turns the requests completed in each interval of a run into rates, so that ramp-up, steady state
+// and degradation can be told apart where the run's average throughput blends them. A final interval cut short by
+// the end of the run is rated over the time it covered, or left out when that is under half an interval, too little
+// to rate fairly.
+func throughputSeries(completions []int, interval, duration time.Duration) []float64 {
+	var series []float64
+	for i, n := range completions {
+		length := interval
+		if end := time.Duration(i+1) * interval; end > duration {
+			length = duration - time.Duration(i)*interval
+		}
+		if length < interval/2 {
+			break
+		}
+		series = append(series, float64(n)/length.Seconds())
+	}
+	return series
+}
+
+// saveThroughputSeries plots a run's throughput over time, against its average as a dashed line.
+func saveThroughputSeries(result BenchmarkResult, opts PlotOptions) error {
+	if len(result.ThroughputSeries) < 2 {
+		return nil
+	}
+	p := plot.New()
+	p.Title.Text = "Throughput over the run"
+	p.X.Label.Text = "Time (s)"
+	p.Y.Label.Text = throughputLabel("Throughput")
+	p.Y.Min = 0
+	throughputTicks(&p.Y)
+	interval := result.Config.ThroughputInterval.Seconds()
+	pts := make(plotter.XYs, len(result.ThroughputSeries))
+	for i, rps := range result.ThroughputSeries {
+		// Each rate is drawn at the end of the interval it was measured over.
+		pts[i] = plotter.XY{X: float64(i+1) * interval, Y: rps}
+	}
+	line, points, err := plotter.NewLinePoints(pts)
+	if err != nil {
+		return err
+	}
+	p.Add(line, points)
+	avg, err := plotter.NewLine(plotter.XYs{{X: 0, Y: result.ThroughputRps}, {X: pts[len(pts)-1].X, Y: result.ThroughputRps}})
+	if err != nil {
+		return err
+	}
+	avg.LineStyle.Color = color.Gray{Y: 128}
+	avg.LineStyle.Dashes = []vg.Length{vg.Points(4), vg.Points(3)}
+	p.Add(avg)
+	p.Legend.Add("per "+formatDuration(result.Config.ThroughputInterval), line, points)
+	p.Legend.Add("average "+formatRps(result.ThroughputRps), avg)
+	p.Legend.Top = true
+	return opts.save(p, 6*vg.Inch, 4*vg.Inch, opts.runFile(result, "throughput_time.png"))
+}
+
+// TracePoint is one request of a run, placed in time.
+type TracePoint struct {
+	Request   int     `json:"request"`
+	StartMs   float64 `json:"start_ms"` // since the run started
+	LatencyMs float64 `json:"latency_ms"`
+	TimedOut  bool    `json:"timed_out,omitempty"`
+}
+
+// latencyTrace keeps every request's TracePoint up to max of them. Past that it keeps only the requests whose index is
+// a multiple of a stride, doubled as often as needed, so that however long a run is, its trace is spread evenly over
+// it and shards that collected their requests apart agree on which to keep.
+type latencyTrace struct {
+	points []TracePoint
+	stride int
+	max    int
+}
+
+func newLatencyTrace(max int) *latencyTrace {
+	if max <= 0 {
+		return nil
+	}
+	return &latencyTrace{stride: 1, max: max}
+}
+
+func (t *latencyTrace) add(result WorkResult) {
+	if result.request%t.stride != 0 {
+		return
+	}
+	t.points = append(t.points, TracePoint{
+		Request:   result.request,
+		StartMs:   float64(result.finished-result.timeTaken) / float64(time.Millisecond),
+		LatencyMs: float64(result.timeTaken) / float64(time.Millisecond),
+		TimedOut:  result.timedOut,
+	})
+	t.thin()
+}
+
+func (t *latencyTrace) merge(other *latencyTrace) {
+	if other.stride > t.stride {
+		t.stride = other.stride
+	}
+	t.points = append(t.points, other.points...)
+	t.thin()
+}
+
+func (t *latencyTrace) thin() {
+	for {
+		kept := t.points[:0]
+		for _, p := range t.points {
+			if p.Request%t.stride == 0 {
+				kept = append(kept, p)
+			}
+		}
+		t.points = kept
+		if len(t.points) <= t.max {
+			return
+		}
+		t.stride *= 2
+	}
+}
+
+// sorted returns the points in the order their requests started.
+func (t *latencyTrace) sorted() []TracePoint {
+	if t == nil {
+		return nil
+	}
+	sort.Slice(t.points, func(i, j int) bool { return t.points[i].StartMs < t.points[j].StartMs })
+	return t.points
+}
+
+// saveLatencyTrace plots the response time of each traced request against when it started, bringing out what the
+// run's percentiles hide: warm-up, drift, and periodic spikes such as garbage collection pauses. Requests that timed
+// out are drawn in red.
+func saveLatencyTrace(result BenchmarkResult, opts PlotOptions) error {
+	if len(result.LatencyTrace) == 0 {
+		return nil
+	}
+	p := plot.New()
+	p.Title.Text = "Response times over the run"
+	p.X.Label.Text = "Request start (s)"
+	p.Y.Label.Text = latencyLabel("Response time")
+	opts.latencyAxis(&p.Y, lowestLatency([]BenchmarkResult{result}))
+	var completed, timedOut plotter.XYs
+	for _, t := range result.LatencyTrace {
+		pt := plotter.XY{X: t.StartMs / 1000, Y: t.LatencyMs}
+		if t.TimedOut {
+			timedOut = append(timedOut, pt)
+		} else {
+			completed = append(completed, pt)
+		}
+	}
+	for _, series := range []struct {
+		name  string
+		pts   plotter.XYs
+		color color.Color
+	}{
+		{"completed", completed, color.RGBA{B: 255, A: 255}},
+		{"timed out", timedOut, color.RGBA{R: 255, A: 255}},
+	} {
+		if len(series.pts) == 0 {
+			continue
+		}
+		scatter, err := plotter.NewScatter(series.pts)
+		if err != nil {
+			return err
+		}
+		scatter.GlyphStyle.Color = series.color
+		scatter.GlyphStyle.Radius = vg.Points(1.5)
+		p.Add(scatter)
+		if len(timedOut) > 0 {
+			p.Legend.Add(series.name, scatter)
+		}
+	}
+	p.Legend.Top = true
+	opts.fitLatency(&p.Y)
+	return opts.save(p, 6*vg.Inch, 4*vg.Inch, opts.runFile(result, "latency_time.png"))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// TLSBackend spends every network phase on a TLS connection of its own to a built-in echo server, handshake
+// included, as a client that keeps no connections open would: the handshake's key exchange and signature are CPU work
+// on both ends, where a sleep is none. With Resume, the client keeps session tickets, so that every connection but the
+// first resumes a session, skipping the certificate and its signature; under TLS 1.3 the key exchange remains.
+type TLSBackend struct {
+	Resume bool
+
+	ln     net.Listener
+	client *tls.Config
+	conns  sync.WaitGroup
+}
+
+const tlsHeaderSize = 8
+
+func (t *TLSBackend) Start() error {
+	cert, pool, err := selfSignedCert()
+	if err != nil {
+		return err
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return err
+	}
+	t.ln = ln
+	t.client = &tls.Config{RootCAs: pool, ServerName: "localhost"}
+	if t.Resume {
+		t.client.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	go t.serve()
+	return nil
+}
+
+// selfSignedCert makes a certificate for localhost and a pool trusting it.
+func selfSignedCert() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: parsed}, pool, nil
+}
+
+func (t *TLSBackend) serve() {
+	for {
+		conn, err := t.ln.Accept()
+		if err != nil {
+			return
+		}
+		t.conns.Add(1)
+		go func() {
+			defer t.conns.Done()
+			defer conn.Close()
+			buf := make([]byte, tlsHeaderSize)
+			for {
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				time.Sleep(time.Duration(binary.BigEndian.Uint64(buf)))
+				if _, err := conn.Write(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (t *TLSBackend) Close() error {
+	err := t.ln.Close()
+	t.conns.Wait()
+	return err
+}
+
+func (t *TLSBackend) Call(networkTime time.Duration, log *RequestLog) error {
+	start := time.Now()
+	log.Begin(start, "network", "network time over TLS", networkTime)
+	raw, err := net.Dial("tcp", t.ln.Addr().String())
+	if err != nil {
+		return err
+	}
+	conn := tls.Client(raw, t.client)
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	if log.Enabled(LogDebug) {
+		how := "full handshake"
+		if conn.ConnectionState().DidResume {
+			how = "resumed session"
+		}
+		log.Debugf(time.Now(), "%s in %v", how, time.Since(start))
+	}
+	buf := make([]byte, tlsHeaderSize)
+	binary.BigEndian.PutUint64(buf, uint64(networkTime))
+	if _, err := conn.Write(buf); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	log.End(time.Now())
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// newRunID returns a short random ID for a run, which its requests' IDs start with so that they stay unique across
+// the runs of a sweep and across sweeps.
+func newRunID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID identifies request x of the run with the given ID.
+func requestID(runID string, x int) string {
+	return fmt.Sprintf("%s-%d", runID, x)
+}
+
+// traceSampleSalt keeps which requests -trace-sample keeps apart from the random streams the requests draw on, so
+// that being sampled says nothing about how a request ran.
+const traceSampleSalt = 0x7f4a7c159e3779b9
+
+// maxSlowCandidates caps the requests held as candidates for -trace-slow where the number a run will complete is not
+// known up front.
+const maxSlowCandidates = 10000
+
+// traceSpan is a span of a sampled request's timeline, in ms from its start.
+type traceSpan struct {
+	Kind    string  `json:"kind"`
+	Label   string  `json:"label,omitempty"`
+	StartMs float64 `json:"start_ms"`
+	EndMs   float64 `json:"end_ms"`
+	Depth   int     `json:"depth"`
+}
+
+// sampledTrace is the line -trace-sample and -trace-slow write for each request they keep: its record as -request-trace
+// writes it, why it was kept (sample, slow or timeout) and its full timeline.
+type sampledTrace struct {
+	requestRecord
+	Kept  string      `json:"kept"`
+	Spans []traceSpan `json:"spans"`
+}
+
+// traceSampler keeps the full timelines of some of a run's requests in traces.jsonl, so that slow requests can be
+// looked into without recording every request in full: a fraction of them chosen at random, written as they complete,
+// and, once the run is over and its percentiles are known, every request slower than the slow percentile and every
+// request that timed out. It is shared by every shard, so it serializes itself.
+type traceSampler struct {
+	runID    string
+	seed     int64
+	fraction float64 // of the requests sampled; zero for none
+	slowPct  float64 // percentile above which requests are kept; zero for none
+	limit    int     // slow candidates held
+
+	mu       sync.Mutex
+	f        *os.File
+	w        *bufio.Writer
+	enc      *json.Encoder
+	err      error
+	slow     slowCandidates // the slowest requests not already sampled, as candidates until the run's percentile is known
+	timeouts []WorkResult
+	closed   bool
+}
+
+func newTraceSampler(path string, cfg RunConfig) (*traceSampler, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriterSize(f, 64<<10)
+	s := &traceSampler{runID: cfg.runID, seed: cfg.Seed, fraction: cfg.TraceSample, slowPct: cfg.TraceSlow, f: f, w: w, enc: json.NewEncoder(w)}
+	if s.slowPct > 0 {
+		// The requests above the percentile, and one more for those the collector's interpolation puts on either side.
+		s.limit = maxSlowCandidates
+		if n := cfg.expectedRequests(); n > 0 {
+			s.limit = int(math.Ceil(float64(n)*(1-s.slowPct/100))) + 1
+		}
+	}
+	return s, nil
+}
+
+// sampled reports whether request x is among the fraction kept, the same requests for the same seed.
+func (s *traceSampler) sampled(x int) bool {
+	r := splitMix64{state: uint64(s.seed) ^ uint64(x)*0xd1b54a32d192ed03 ^ traceSampleSalt}
+	return float64(r.Uint64()>>11)/(1<<53) < s.fraction
+}
+
+func (s *traceSampler) add(result WorkResult) {
+	if s.fraction > 0 && s.sampled(result.request) {
+		s.write(result, "sample")
+		return
+	}
+	if result.timedOut {
+		s.mu.Lock()
+		s.timeouts = append(s.timeouts, result)
+		s.mu.Unlock()
+		return
+	}
+	if s.limit == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.slow) < s.limit {
+		heap.Push(&s.slow, result)
+	} else if result.timeTaken > s.slow[0].timeTaken {
+		s.slow[0] = result
+		heap.Fix(&s.slow, 0)
+	}
+}
+
+func (s *traceSampler) write(result WorkResult, kept string) {
+	trace := sampledTrace{requestRecord: newRequestRecord(s.runID, result), Kept: kept, Spans: []traceSpan{}}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	for _, span := range result.log.timeline(result.timeTaken).Spans {
+		trace.Spans = append(trace.Spans, traceSpan{Kind: span.Kind, Label: span.Label, StartMs: ms(span.Start), EndMs: ms(span.End), Depth: span.Depth})
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = s.enc.Encode(trace)
+	}
+}
+
+// Close writes the requests that timed out and those slower than the slow percentile of responseTimes, flushes the
+// file and closes it, returning the first error writing it met. A nil sampler, or one already closed, does nothing.
+func (s *traceSampler) Close(responseTimes Collector) error {
+	if s == nil || s.closed {
+		return nil
+	}
+	s.closed = true
+	for _, result := range s.timeouts {
+		s.write(result, "timeout")
+	}
+	if s.limit > 0 && responseTimes != nil && responseTimes.Count() > 0 {
+		threshold := responseTimes.Percentile(s.slowPct)
+		for _, result := range s.slow {
+			if float64(result.timeTaken)/float64(time.Millisecond) > threshold {
+				s.write(result, "slow")
+			}
+		}
+	}
+	s.timeouts, s.slow = nil, nil
+	err := s.err
+	if flushErr := s.w.Flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := s.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// slowCandidates is a min-heap of requests by response time, so that the fastest of those held is the first to go.
+type slowCandidates []WorkResult
+
+func (h slowCandidates) Len() int            { return len(h) }
+func (h slowCandidates) Less(i, j int) bool  { return h[i].timeTaken < h[j].timeTaken }
+func (h slowCandidates) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *slowCandidates) Push(x interface{}) { *h = append(*h, x.(WorkResult)) }
+func (h *slowCandidates) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
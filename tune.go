@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var tuneStrategies = []string{"binary", "hill"}
+
+// Tuner searches for the highest co-routine count at which a latency SLO holds, instead of sweeping every count: the
+// swept counts only give the range searched. Binary bisects the range, assuming the SLO holds up to some count and
+// not past it; hill climbs from the lowest count, doubling while the SLO holds, then bisects between the last count
+// that met it and the first that didn't, which costs fewer runs when the answer is near the bottom of a wide range.
+type Tuner struct {
+	SLO      SLO // a percentile bound, such as p99<50ms
+	Strategy string
+}
+
+// parseTuner parses the SLO to tune for, which must bound a percentile from above and apply to every run.
+func parseTuner(spec, strategy string) (*Tuner, error) {
+	slo, err := parseSLO(spec)
+	if err != nil {
+		return nil, err
+	}
+	if slo.Percentile == 0 || !strings.HasPrefix(slo.Op, "<") || slo.Coroutines != 0 {
+		return nil, fmt.Errorf("-tune %q must bound a percentile from above for every run, such as p99<50ms", spec)
+	}
+	switch strategy {
+	case "binary", "hill":
+	default:
+		return nil, fmt.Errorf("unknown tuning strategy %q, expected one of %s", strategy, strings.Join(tuneStrategies, ", "))
+	}
+	return &Tuner{SLO: slo, Strategy: strategy}, nil
+}
+
+// meets reports whether every repetition of a configuration held the SLO; a failed run never does.
+func (t *Tuner) meets(runs []BenchmarkResult) bool {
+	for _, r := range runs {
+		if v, _ := t.SLO.measure(r); r.Failure != nil || !t.SLO.holds(v) {
+			return false
+		}
+	}
+	return len(runs) > 0
+}
+
+// search finds the highest n in [lo, hi] that run meets the SLO at, running each count at most once. It reports
+// false when not even lo meets it.
+func (t *Tuner) search(lo, hi int64, run func(n int64) (bool, error)) (int64, bool, error) {
+	ok, err := run(lo)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	pass, fail := lo, hi+1 // the highest count known to meet the SLO, and the lowest known to miss it
+	if t.Strategy == "hill" {
+		for next := pass * 2; next < fail; next = pass * 2 {
+			if next > hi {
+				next = hi
+			}
+			if ok, err = run(next); err != nil {
+				return 0, false, err
+			}
+			if !ok {
+				fail = next
+				break
+			}
+			pass = next
+			if pass == hi {
+				break
+			}
+		}
+	} else if hi > lo {
+		if ok, err = run(hi); err != nil {
+			return 0, false, err
+		}
+		if ok {
+			return hi, true, nil
+		}
+		fail = hi
+	}
+	for fail-pass > 1 {
+		mid := pass + (fail-pass)/2
+		if ok, err = run(mid); err != nil {
+			return 0, false, err
+		}
+		if ok {
+			pass = mid
+		} else {
+			fail = mid
+		}
+	}
+	return pass, true, nil
+}
+
+// tune searches every group of cfgs, which differ only in their co-routine count, and prints what it found.
+// runConfig runs a configuration and returns its repetitions.
+func (t *Tuner) tune(cfgs []RunConfig, groupSize int, runConfig func(RunConfig) ([]BenchmarkResult, error)) error {
+	dims := sweptDims(cfgs)
+	type outcome struct {
+		label       string
+		lo, hi, n   int64
+		found       bool
+		best, above []BenchmarkResult
+		runs        int
+	}
+	var outcomes []outcome
+	for start := 0; start < len(cfgs); start += groupSize {
+		group := cfgs[start : start+groupSize]
+		o := outcome{lo: group[0].NumCoroutines, hi: group[0].NumCoroutines}
+		for _, cfg := range group {
+			if cfg.NumCoroutines < o.lo {
+				o.lo = cfg.NumCoroutines
+			}
+			if cfg.NumCoroutines > o.hi {
+				o.hi = cfg.NumCoroutines
+			}
+		}
+		parts := []string{fmt.Sprintf("%s executor, GOMAXPROCS=%d", group[0].Executor, group[0].GOMAXPROCS)}
+		for _, d := range dims {
+			parts = append(parts, d.label(group[0]))
+		}
+		o.label = strings.Join(parts, ", ")
+		tried := map[int64][]BenchmarkResult{}
+		var err error
+		o.n, o.found, err = t.search(o.lo, o.hi, func(n int64) (bool, error) {
+			cfg := group[0]
+			cfg.NumCoroutines = n
+			runs, err := runConfig(cfg)
+			if err != nil {
+				return false, err
+			}
+			tried[n] = runs
+			return t.meets(runs), nil
+		})
+		if err != nil {
+			return err
+		}
+		o.runs = len(tried)
+		if o.found {
+			o.best = tried[o.n]
+			o.above = tried[o.n+1]
+		}
+		outcomes = append(outcomes, o)
+	}
+	fmt.Printf("Tuning for %s by %s search:\n", t.SLO.Spec, t.Strategy)
+	for _, o := range outcomes {
+		if !o.found {
+			fmt.Printf("\t%s: missed even at %d co-routines\n", o.label, o.lo)
+			continue
+		}
+		_, measured := t.SLO.measure(o.best[0])
+		fmt.Printf("\t%s: highest meeting it %d co-routines, %s %s at %s, found in %d configurations of %d–%d",
+			o.label, o.n, t.SLO.Metric, measured, formatRps(o.best[0].ThroughputRps), o.runs, o.lo, o.hi)
+		if len(o.above) > 0 {
+			_, missed := t.SLO.measure(o.above[0])
+			fmt.Printf("; %d missed it with %s", o.n+1, missed)
+		}
+		fmt.Println()
+	}
+	return nil
+}
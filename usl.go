@@ -0,0 +1,120 @@
+package main
+
+import (
+	"image/color"
+	"math"
+
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+)
+
+// USLFit is Gunther's Universal Scalability Law fitted to a series' speedups, S(N) = N / (1 + σ(N-1) + κN(N-1)).
+// Sigma is contention, the share of the work that serializes; Kappa is coherence, the cost every request pays for
+// every other one, which makes throughput fall again past a peak.
+type USLFit struct {
+	Sigma float64
+	Kappa float64
+}
+
+// fitUSL fits the law to the successful runs of a series by least squares on its linearized form,
+// N/S - 1 = σ(N-1) + κN(N-1). Speedup is already relative to a single request at a time, so no scale is fitted.
+// It reports false when fewer than two coroutine counts above one were measured.
+func fitUSL(runs []BenchmarkResult) (USLFit, bool) {
+	var aa, ab, bb, ay, by float64
+	counts := map[int64]bool{}
+	for _, r := range runs {
+		if r.Failure != nil || r.Speedup <= 0 || math.IsNaN(r.Speedup) || r.NumCoroutines < 1 {
+			continue
+		}
+		n := float64(r.NumCoroutines)
+		a, b, y := n-1, n*(n-1), n/r.Speedup-1
+		aa, ab, bb, ay, by = aa+a*a, ab+a*b, bb+b*b, ay+a*y, by+b*y
+		if r.NumCoroutines > 1 {
+			counts[r.NumCoroutines] = true
+		}
+	}
+	if len(counts) < 2 {
+		return USLFit{}, false
+	}
+	det := aa*bb - ab*ab
+	f := USLFit{Sigma: (ay*bb - by*ab) / det, Kappa: (by*aa - ay*ab) / det}
+	// Negative coefficients only fit noise; drop the offending term and fit the other alone.
+	if f.Kappa < 0 || det == 0 {
+		f = USLFit{Sigma: ay / aa}
+	}
+	if f.Sigma < 0 {
+		f = USLFit{Kappa: math.Max(by/bb, 0)}
+	}
+	return f, true
+}
+
+func (f USLFit) Speedup(n float64) float64 {
+	return n / (1 + f.Sigma*(n-1) + f.Kappa*n*(n-1))
+}
+
+// Peak is the concurrency at which the fitted throughput is highest, or false when it keeps rising.
+func (f USLFit) Peak() (float64, bool) {
+	if f.Kappa <= 0 || f.Sigma >= 1 {
+		return 0, false
+	}
+	return math.Sqrt((1 - f.Sigma) / f.Kappa), true
+}
+
+func (f USLFit) String() string {
+	s := "σ=" + formatFloat(f.Sigma, 4) + " κ=" + formatFloat(f.Kappa, 4)
+	if peak, ok := f.Peak(); ok {
+		s += ", peak at " + formatFloat(peak, 0)
+	}
+	return s
+}
+
+// addUSLFit overlays the law fitted to runs as a dashed line in c, extended past the sweep to the projected peak
+// when that is no more than twice the highest coroutine count run, where the peak is marked.
+func addUSLFit(plt *plot.Plot, runs []BenchmarkResult, label string, c color.Color) error {
+	f, ok := fitUSL(runs)
+	if !ok {
+		return nil
+	}
+	var highest float64
+	for _, r := range runs {
+		highest = math.Max(highest, float64(r.NumCoroutines))
+	}
+	end := highest
+	peak, hasPeak := f.Peak()
+	if hasPeak && peak > highest && peak <= 2*highest {
+		end = math.Min(peak*1.25, 2*highest)
+	}
+	const steps = 100
+	pts := make(plotter.XYs, steps+1)
+	for i := range pts {
+		n := 1 + (end-1)*float64(i)/steps
+		pts[i] = plotter.XY{X: n, Y: f.Speedup(n)}
+	}
+	line, err := plotter.NewLine(pts)
+	if err != nil {
+		return err
+	}
+	line.LineStyle.Color = c
+	line.LineStyle.Width = vg.Points(1)
+	line.LineStyle.Dashes = []vg.Length{vg.Points(4), vg.Points(3)}
+	plt.Add(line)
+	name := "USL " + f.String()
+	if label != "" {
+		name = "USL " + label + " " + f.String()
+	}
+	plt.Legend.Add(name, line)
+
+	if hasPeak && peak <= end {
+		mark, err := plotter.NewScatter(plotter.XYs{{X: peak, Y: f.Speedup(peak)}})
+		if err != nil {
+			return err
+		}
+		mark.GlyphStyle.Shape = draw.PyramidGlyph{}
+		mark.GlyphStyle.Color = c
+		mark.GlyphStyle.Radius = vg.Points(4)
+		plt.Add(mark)
+	}
+	return nil
+}
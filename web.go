@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// webResult is what the web UI knows about a completed run.
+type webResult struct {
+	Series        string    `json:"series"`
+	Coroutines    int64     `json:"coroutines"`
+	ThroughputRps float64   `json:"throughput_rps"`
+	P99Ms         float64   `json:"p99_ms"` // charted whichever percentiles are reported
+	Latency       []webStat `json:"latency"`
+	Errors        int       `json:"errors"`
+	Failure       string    `json:"failure,omitempty"`
+	FlameGraph    string    `json:"flame_graph,omitempty"` // where the page serves the run's flame graph, with -cpu-profile
+}
+
+// webStat is a column of the results table: a reported percentile, the mean or the standard deviation.
+type webStat struct {
+	Name string  `json:"name"`
+	Ms   float64 `json:"ms"`
+}
+
+type webRun struct {
+	Series     string  `json:"series"`
+	Coroutines int64   `json:"coroutines"`
+	Iterations int     `json:"iterations"`
+	Completed  int64   `json:"completed"`
+	Rps        float64 `json:"rps"`
+}
+
+// webReporter serves a page that charts the sweep as it runs. Browsers get the state so far when they connect and
+// follow progress over server-sent events, so the page doubles as the final report once the sweep is done.
+type webReporter struct {
+	server    *http.Server
+	completed int64
+
+	mu       sync.Mutex
+	clients  map[chan string]struct{}
+	results  []webResult
+	flames   map[string][]byte // flame graphs by their path
+	current  *webRun
+	finished bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newWebReporter(addr string) (*webReporter, error) {
+	r := &webReporter{clients: map[chan string]struct{}{}, flames: map[string][]byte{}}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", r.serveIndex)
+	mux.HandleFunc("/events", r.serveEvents)
+	mux.HandleFunc("/flamegraph/", r.serveFlameGraph)
+	r.server = &http.Server{Handler: mux}
+	go r.server.Serve(ln)
+	fmt.Printf("Serving live results on http://%s/\n", ln.Addr())
+	return r, nil
+}
+
+func webSeries(cfg RunConfig) string {
+	executor := cfg.Executor
+	if executor == "" {
+		executor = "semaphore"
+	}
+	return fmt.Sprintf("%s, GOMAXPROCS=%d", executor, cfg.GOMAXPROCS)
+}
+
+func (r *webReporter) OnRunStart(cfg RunConfig) {
+	r.stopTicker()
+	atomic.StoreInt64(&r.completed, 0)
+	run := &webRun{Series: webSeries(cfg), Coroutines: cfg.NumCoroutines, Iterations: cfg.Iterations}
+	stop, done := make(chan struct{}), make(chan struct{})
+	r.mu.Lock()
+	r.current = run
+	r.stop, r.done = stop, done
+	r.publishLocked("run", run)
+	r.mu.Unlock()
+
+	clock := cfg.clock()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		last, lastTime := int64(0), clock.Now()
+		for {
+			select {
+			case <-ticker.C:
+				n, now := atomic.LoadInt64(&r.completed), clock.Now()
+				r.mu.Lock()
+				run.Completed = n
+				run.Rps = float64(n-last) / now.Sub(lastTime).Seconds()
+				r.publishLocked("progress", run)
+				r.mu.Unlock()
+				last, lastTime = n, now
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (r *webReporter) OnSample(sample Sample) {
+	atomic.AddInt64(&r.completed, 1)
+}
+
+func (r *webReporter) OnRunComplete(result BenchmarkResult) error {
+	r.stopTicker()
+	res := webResult{
+		Series:        webSeries(result.Config),
+		Coroutines:    result.NumCoroutines,
+		ThroughputRps: result.ThroughputRps,
+		P99Ms:         result.ResponseTimesPercentile(99),
+		Errors:        result.Errors,
+	}
+	for _, pct := range result.Config.percentiles() {
+		res.Latency = append(res.Latency, webStat{percentileName(pct), result.ResponseTimesPercentile(pct)})
+	}
+	res.Latency = append(res.Latency, webStat{"mean", result.Latency.Mean}, webStat{"stddev", result.Latency.StdDev})
+	if result.Failure != nil {
+		res.Failure = string(result.Failure.Kind)
+	}
+	// Drawn now rather than read back from flamegraph.svg, which the next run may overwrite.
+	var flame []byte
+	if result.CPUProfile != nil {
+		if stacks, err := foldProfile(result.CPUProfile); err == nil {
+			flame, _ = flameGraph(stacks, flameGraphTitle(result))
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if flame != nil {
+		res.FlameGraph = fmt.Sprintf("/flamegraph/%d.svg", len(r.results))
+		r.flames[res.FlameGraph] = flame
+	}
+	r.results = append(r.results, res)
+	r.current = nil
+	r.publishLocked("result", res)
+	return nil
+}
+
+// finish tells connected browsers the sweep is over; the page keeps serving as a report until Close.
+func (r *webReporter) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.finished = true
+	r.publishLocked("done", struct{}{})
+}
+
+func (r *webReporter) Close() error {
+	r.stopTicker()
+	return r.server.Close()
+}
+
+func (r *webReporter) stopTicker() {
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+	r.stop = nil
+}
+
+// publishLocked sends an event to every connected browser. A browser too slow to keep up misses events rather than
+// stalling the run.
+func (r *webReporter) publishLocked(event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	msg := fmt.Sprintf("event: %s\ndata: %s\n\n", event, data)
+	for c := range r.clients {
+		select {
+		case c <- msg:
+		default:
+		}
+	}
+}
+
+func (r *webReporter) serveEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	c := make(chan string, 64)
+	r.mu.Lock()
+	state, _ := json.Marshal(struct {
+		Results  []webResult `json:"results"`
+		Current  *webRun     `json:"current"`
+		Finished bool        `json:"finished"`
+	}{r.results, r.current, r.finished})
+	r.clients[c] = struct{}{}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.clients, c)
+		r.mu.Unlock()
+	}()
+
+	fmt.Fprintf(w, "event: state\ndata: %s\n\n", state)
+	flusher.Flush()
+	for {
+		select {
+		case msg := <-c:
+			fmt.Fprint(w, msg)
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+func (r *webReporter) serveFlameGraph(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	svg, ok := r.flames[req.URL.Path]
+	r.mu.Unlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svg)
+}
+
+func (r *webReporter) serveIndex(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, webIndex)
+}
+
+const webIndex = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>perf</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.charts { display: flex; flex-wrap: wrap; gap: 2em; }
+svg { border: 1px solid #ccc; }
+table { border-collapse: collapse; margin-top: 2em; }
+td, th { padding: 0.2em 0.8em; text-align: right; border-bottom: 1px solid #eee; }
+#status { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Throughput sweep</h1>
+<p id="status">Connecting...</p>
+<div class="charts">
+<div><h3>Throughput (rps)</h3><svg id="throughput" width="480" height="320"></svg></div>
+<div><h3>p99 latency (ms)</h3><svg id="latency" width="480" height="320"></svg></div>
+</div>
+<table id="results">
+</table>
+<script>
+const colors = ["#1f77b4", "#d62728", "#2ca02c", "#9467bd", "#ff7f0e", "#8c564b", "#e377c2", "#7f7f7f"];
+let results = [];
+
+function chart(id, field) {
+  const svg = document.getElementById(id), w = svg.width.baseVal.value, h = svg.height.baseVal.value, pad = 40;
+  const series = {};
+  results.forEach(r => (series[r.series] = series[r.series] || []).push(r));
+  const xs = results.map(r => r.coroutines), ys = results.map(r => r[field]);
+  const xmax = Math.max(1, ...xs), ymax = Math.max(1e-9, ...ys);
+  const px = x => pad + (w - 2 * pad) * x / xmax, py = y => h - pad - (h - 2 * pad) * y / ymax;
+  let out = '<line x1="' + pad + '" y1="' + (h - pad) + '" x2="' + (w - pad) + '" y2="' + (h - pad) + '" stroke="#000"/>' +
+    '<line x1="' + pad + '" y1="' + pad + '" x2="' + pad + '" y2="' + (h - pad) + '" stroke="#000"/>' +
+    '<text x="' + (w - pad) + '" y="' + (h - pad + 15) + '" text-anchor="end" font-size="11">' + xmax + ' co-routines</text>' +
+    '<text x="' + (pad - 4) + '" y="' + (pad + 4) + '" text-anchor="end" font-size="11">' + ymax.toFixed(0) + '</text>';
+  Object.keys(series).forEach((name, i) => {
+    const color = colors[i % colors.length];
+    const points = series[name].map(r => px(r.coroutines) + "," + py(r[field])).join(" ");
+    out += '<polyline fill="none" stroke="' + color + '" stroke-width="2" points="' + points + '"/>';
+    out += '<text x="' + (pad + 8) + '" y="' + (pad + 14 * (i + 1)) + '" fill="' + color + '" font-size="11">' + name + '</text>';
+  });
+  svg.innerHTML = out;
+}
+
+function addRow(r) {
+  const table = document.getElementById("results");
+  if (table.rows.length == 0) {
+    const header = table.insertRow();
+    ["series", "co-routines", "rps", ...r.latency.map(s => s.name + " ms"), "errors", "failure", ...(r.flame_graph ? ["CPU"] : [])]
+      .forEach(v => (header.appendChild(document.createElement("th")).textContent = v));
+  }
+  const row = table.insertRow();
+  [r.series, r.coroutines, r.throughput_rps.toFixed(2), ...r.latency.map(s => s.ms.toFixed(2)), r.errors, r.failure || ""]
+    .forEach(v => (row.insertCell().textContent = v));
+  if (r.flame_graph) {
+    const link = row.insertCell().appendChild(document.createElement("a"));
+    link.href = r.flame_graph;
+    link.target = "_blank";
+    link.textContent = "flame graph";
+  }
+  if (r.failure) row.style.color = "#d62728";
+}
+
+function redraw() {
+  chart("throughput", "throughput_rps");
+  chart("latency", "p99_ms");
+}
+
+function showRun(run) {
+  document.getElementById("status").textContent = "Running " + run.series + " with " + run.coroutines + " co-routines: " +
+    run.completed + "/" + run.iterations + " requests, " + run.rps.toFixed(1) + " rps";
+}
+
+const events = new EventSource("/events");
+events.addEventListener("state", e => {
+  const state = JSON.parse(e.data);
+  results = state.results || [];
+  results.forEach(addRow);
+  redraw();
+  if (state.finished) document.getElementById("status").textContent = "Sweep complete";
+  else if (state.current) showRun(state.current);
+});
+events.addEventListener("run", e => showRun(JSON.parse(e.data)));
+events.addEventListener("progress", e => showRun(JSON.parse(e.data)));
+events.addEventListener("result", e => {
+  const r = JSON.parse(e.data);
+  results.push(r);
+  addRow(r);
+  redraw();
+});
+events.addEventListener("done", () => {
+  document.getElementById("status").textContent = "Sweep complete";
+  events.close();
+});
+events.onerror = () => (document.getElementById("status").textContent = "Disconnected");
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketBackend spends network time on message round-trips over persistent WebSocket connections to a built-in
+// echo server, which holds every message for the delay it starts with before sending it back. With Conns zero a
+// connection is opened whenever every open one is busy and kept, so there end up as many as there are calls in
+// flight; otherwise the calls share Conns connections, waiting for a free one when all are busy.
+type WebSocketBackend struct {
+	Conns       int
+	MessageSize int
+
+	server *http.Server
+	url    string
+	free   chan struct{} // a token per connection of a fixed pool, open or not yet; nil when unbounded
+
+	mu   sync.Mutex
+	idle []*websocket.Conn
+	open []*websocket.Conn
+}
+
+const websocketHeaderSize = 8
+
+func (w *WebSocketBackend) Start() error {
+	if w.MessageSize < websocketHeaderSize {
+		return fmt.Errorf("WebSocket message size must be at least %d bytes, got %d", websocketHeaderSize, w.MessageSize)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	w.server = &http.Server{Handler: websocket.Handler(func(conn *websocket.Conn) {
+		defer conn.Close()
+		var msg []byte
+		for {
+			if err := websocket.Message.Receive(conn, &msg); err != nil || len(msg) < websocketHeaderSize {
+				return
+			}
+			time.Sleep(time.Duration(binary.BigEndian.Uint64(msg)))
+			if err := websocket.Message.Send(conn, msg); err != nil {
+				return
+			}
+		}
+	})}
+	go w.server.Serve(ln)
+	w.url = "ws://" + ln.Addr().String() + "/"
+	if w.Conns > 0 {
+		w.free = make(chan struct{}, w.Conns)
+		for i := 0; i < w.Conns; i++ {
+			w.free <- struct{}{}
+		}
+	}
+	return nil
+}
+
+func (w *WebSocketBackend) Close() error {
+	w.mu.Lock()
+	for _, conn := range w.open {
+		conn.Close()
+	}
+	w.open, w.idle = nil, nil
+	w.mu.Unlock()
+	return w.server.Close()
+}
+
+// get returns an idle connection, or a new one if there is none and the pool has room, waiting for one otherwise.
+func (w *WebSocketBackend) get() (conn *websocket.Conn, reused bool, err error) {
+	if w.free != nil {
+		<-w.free
+	}
+	w.mu.Lock()
+	if n := len(w.idle); n > 0 {
+		conn = w.idle[n-1]
+		w.idle = w.idle[:n-1]
+		w.mu.Unlock()
+		return conn, true, nil
+	}
+	w.mu.Unlock()
+	if conn, err = websocket.Dial(w.url, "", "http://localhost/"); err != nil {
+		w.release()
+		return nil, false, err
+	}
+	w.mu.Lock()
+	w.open = append(w.open, conn)
+	w.mu.Unlock()
+	return conn, false, nil
+}
+
+func (w *WebSocketBackend) put(conn *websocket.Conn) {
+	w.mu.Lock()
+	w.idle = append(w.idle, conn)
+	w.mu.Unlock()
+	w.release()
+}
+
+// drop closes a connection that failed, leaving room in the pool for a new one.
+func (w *WebSocketBackend) drop(conn *websocket.Conn) {
+	conn.Close()
+	w.mu.Lock()
+	for i, c := range w.open {
+		if c == conn {
+			w.open = append(w.open[:i], w.open[i+1:]...)
+			break
+		}
+	}
+	w.mu.Unlock()
+	w.release()
+}
+
+func (w *WebSocketBackend) release() {
+	if w.free != nil {
+		w.free <- struct{}{}
+	}
+}
+
+func (w *WebSocketBackend) Call(networkTime time.Duration, log *RequestLog) error {
+	start := time.Now()
+	log.Begin(start, "network", "network time over WebSocket", networkTime)
+	conn, reused, err := w.get()
+	if err != nil {
+		return err
+	}
+	if log.Enabled(LogDebug) {
+		if reused {
+			log.Debugf(time.Now(), "on an open connection after waiting %v", time.Since(start))
+		} else {
+			log.Debugf(time.Now(), "on a new connection after %v", time.Since(start))
+		}
+	}
+
+	msg := make([]byte, w.MessageSize)
+	binary.BigEndian.PutUint64(msg, uint64(networkTime))
+	if err := websocket.Message.Send(conn, msg); err != nil {
+		w.drop(conn)
+		return err
+	}
+	if err := websocket.Message.Receive(conn, &msg); err != nil {
+		w.drop(conn)
+		return err
+	}
+	w.put(conn)
+	log.End(time.Now())
+	return nil
+}